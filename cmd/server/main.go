@@ -33,7 +33,36 @@ func main() {
 	}
 	defer runtime.Stop()
 
-	handler := server.New(cfg, runtime).Handler()
+	srv := server.New(cfg, runtime)
+	if err = srv.Start(); err != nil {
+		log.Fatalf("failed to start background workers: %v", err)
+	}
+	defer srv.Stop()
+
+	sessions := make(map[string]http.Handler, len(cfg.Sessions))
+	for _, name := range cfg.Sessions {
+		sessionRuntime, err := runtime.NewSession(name)
+		if err != nil {
+			log.Fatalf("failed to create session %s: %v", name, err)
+		}
+		if err = sessionRuntime.Start(runtimeCtx); err != nil {
+			log.Fatalf("failed to start session %s: %v", name, err)
+		}
+		defer sessionRuntime.Stop()
+		sessionCfg := cfg
+		if cfg.PrimaryURL != "" {
+			// A follower's sessions follow the same session on the primary.
+			sessionCfg.PrimaryURL = cfg.PrimaryURL + "/sessions/" + name
+		}
+		sessionSrv := server.New(sessionCfg, sessionRuntime)
+		if err = sessionSrv.Start(); err != nil {
+			log.Fatalf("failed to start background workers for session %s: %v", name, err)
+		}
+		defer sessionSrv.Stop()
+		sessions[name] = sessionSrv.Handler()
+	}
+
+	handler := server.NewSessionRouter(srv.Handler(), sessions)
 	httpServer := &http.Server{
 		Addr:    cfg.ListenAddr,
 		Handler: handler,
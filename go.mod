@@ -5,10 +5,15 @@ go 1.24.0
 require (
 	github.com/beeper/desktop-api-go v0.4.0
 	github.com/coder/websocket v1.8.14
+	github.com/disintegration/imaging v1.6.2
 	github.com/joho/godotenv v1.5.1
 	github.com/modelcontextprotocol/go-sdk v1.3.0
+	github.com/yuin/gopher-lua v1.1.1
 	go.mau.fi/gomuks v0.2601.0
 	go.mau.fi/util v0.9.6-0.20260124144959-47fbccd7a8f4
+	golang.org/x/image v0.35.0
+	golang.org/x/net v0.49.0
+	gopkg.in/yaml.v3 v3.0.1
 	maunium.net/go/mautrix v0.26.3-0.20260128193407-2423716f8394
 )
 
@@ -18,7 +23,6 @@ require (
 	github.com/alecthomas/chroma/v2 v2.22.0 // indirect
 	github.com/buckket/go-blurhash v1.1.0 // indirect
 	github.com/coreos/go-systemd/v22 v22.6.0 // indirect
-	github.com/disintegration/imaging v1.6.2 // indirect
 	github.com/dlclark/regexp2 v1.11.5 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.12 // indirect
 	github.com/golang-jwt/jwt/v5 v5.2.2 // indirect
@@ -40,12 +44,9 @@ require (
 	go.mau.fi/zeroconfig v0.2.0 // indirect
 	golang.org/x/crypto v0.47.0 // indirect
 	golang.org/x/exp v0.0.0-20260112195511-716be5621a96 // indirect
-	golang.org/x/image v0.35.0 // indirect
-	golang.org/x/net v0.49.0 // indirect
 	golang.org/x/oauth2 v0.30.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	mvdan.cc/xurls/v2 v2.6.0 // indirect
 )
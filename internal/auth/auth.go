@@ -81,6 +81,31 @@ func withBearerToken(r *http.Request, token string) *http.Request {
 	return clone
 }
 
+type basePathKey struct{}
+
+// WithBasePath records the path prefix a router stripped before passing the
+// request on, so URLs handed back to the client can put it back.
+func WithBasePath(r *http.Request, prefix string) *http.Request {
+	return r.WithContext(ContextWithBasePath(r.Context(), prefix))
+}
+
+// ContextWithBasePath is WithBasePath for code that only holds a context.
+func ContextWithBasePath(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, basePathKey{}, prefix)
+}
+
+// BasePath returns the prefix recorded by WithBasePath, or "" for requests
+// served at the root.
+func BasePath(r *http.Request) string {
+	return ContextBasePath(r.Context())
+}
+
+// ContextBasePath is BasePath for code that only holds the request context.
+func ContextBasePath(ctx context.Context) string {
+	prefix, _ := ctx.Value(basePathKey{}).(string)
+	return prefix
+}
+
 func protectedResourceMetadataURL(r *http.Request) string {
 	scheme := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])
 	if scheme == "" {
@@ -95,7 +120,7 @@ func protectedResourceMetadataURL(r *http.Request) string {
 		host = strings.TrimSpace(r.Host)
 	}
 	if host == "" {
-		return "/.well-known/oauth-protected-resource"
+		return BasePath(r) + "/.well-known/oauth-protected-resource"
 	}
-	return scheme + "://" + host + "/.well-known/oauth-protected-resource"
+	return scheme + "://" + host + BasePath(r) + "/.well-known/oauth-protected-resource"
 }
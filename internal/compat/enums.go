@@ -0,0 +1,67 @@
+package compat
+
+import (
+	"strings"
+
+	beeperdesktopapi "github.com/beeper/desktop-api-go"
+	"github.com/beeper/desktop-api-go/shared"
+)
+
+const (
+	MessageTypeText     = shared.MessageTypeText
+	MessageTypeNotice   = shared.MessageTypeNotice
+	MessageTypeImage    = shared.MessageTypeImage
+	MessageTypeVideo    = shared.MessageTypeVideo
+	MessageTypeVoice    = shared.MessageTypeVoice
+	MessageTypeAudio    = shared.MessageTypeAudio
+	MessageTypeFile     = shared.MessageTypeFile
+	MessageTypeSticker  = shared.MessageTypeSticker
+	MessageTypeLocation = shared.MessageTypeLocation
+	MessageTypeReaction = shared.MessageTypeReaction
+)
+
+const (
+	AttachmentTypeUnknown = shared.AttachmentTypeUnknown
+	AttachmentTypeImg     = shared.AttachmentTypeImg
+	AttachmentTypeVideo   = shared.AttachmentTypeVideo
+	AttachmentTypeAudio   = shared.AttachmentTypeAudio
+)
+
+const (
+	ChatTypeSingle = beeperdesktopapi.ChatTypeSingle
+	ChatTypeGroup  = beeperdesktopapi.ChatTypeGroup
+)
+
+var (
+	MessageTypes = []MessageType{
+		MessageTypeText, MessageTypeNotice, MessageTypeImage, MessageTypeVideo, MessageTypeVoice,
+		MessageTypeAudio, MessageTypeFile, MessageTypeSticker, MessageTypeLocation, MessageTypeReaction,
+	}
+	AttachmentTypes = []AttachmentType{AttachmentTypeUnknown, AttachmentTypeImg, AttachmentTypeVideo, AttachmentTypeAudio}
+	ChatTypes       = []ChatType{ChatTypeSingle, ChatTypeGroup}
+)
+
+// ParseMessageType matches raw case-insensitively against MessageTypes.
+func ParseMessageType(raw string) (MessageType, bool) {
+	return parseEnum(raw, MessageTypes, strings.ToUpper)
+}
+
+// ParseAttachmentType matches raw case-insensitively against AttachmentTypes.
+func ParseAttachmentType(raw string) (AttachmentType, bool) {
+	return parseEnum(raw, AttachmentTypes, strings.ToLower)
+}
+
+// ParseChatType matches raw case-insensitively against ChatTypes.
+func ParseChatType(raw string) (ChatType, bool) {
+	return parseEnum(raw, ChatTypes, strings.ToLower)
+}
+
+func parseEnum[T ~string](raw string, values []T, normalize func(string) string) (T, bool) {
+	candidate := T(normalize(strings.TrimSpace(raw)))
+	for _, value := range values {
+		if value == candidate {
+			return value, true
+		}
+	}
+	return "", false
+}
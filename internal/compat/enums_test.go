@@ -0,0 +1,21 @@
+package compat
+
+import "testing"
+
+func TestParseEnumsNormalizeCase(t *testing.T) {
+	if got, ok := ParseMessageType(" text "); !ok || got != MessageTypeText {
+		t.Fatalf("expected TEXT, got %q (%v)", got, ok)
+	}
+	if got, ok := ParseChatType("Group"); !ok || got != ChatTypeGroup {
+		t.Fatalf("expected group, got %q (%v)", got, ok)
+	}
+	if got, ok := ParseAttachmentType("IMG"); !ok || got != AttachmentTypeImg {
+		t.Fatalf("expected img, got %q (%v)", got, ok)
+	}
+	if _, ok := ParseChatType("channel"); ok {
+		t.Fatal("expected unknown chat type to be rejected")
+	}
+	if _, ok := ParseMessageType(""); ok {
+		t.Fatal("expected empty message type to be rejected")
+	}
+}
@@ -1,6 +1,9 @@
 package compat
 
 import (
+	"encoding/json"
+	"time"
+
 	beeperdesktopapi "github.com/beeper/desktop-api-go"
 	"github.com/beeper/desktop-api-go/shared"
 )
@@ -11,6 +14,9 @@ type Account struct {
 	AccountID string `json:"accountID"`
 	User      User   `json:"user"`
 	Network   string `json:"network,omitempty"`
+	// Display preferences set through PUT /v1/preferences/accounts.
+	Label string `json:"label,omitempty"`
+	Color string `json:"color,omitempty"`
 }
 
 type Participants = beeperdesktopapi.ChatParticipants
@@ -18,10 +24,109 @@ type Attachment = shared.Attachment
 type AttachmentType = shared.AttachmentType
 type AttachmentSize = shared.AttachmentSize
 type Reaction = shared.Reaction
-type Message = shared.Message
 type MessageType = shared.MessageType
 type ChatType = beeperdesktopapi.ChatType
 
+type Message struct {
+	shared.Message
+	// Preview of the first link in the message text, if one is known.
+	LinkPreview *LinkPreview `json:"linkPreview,omitempty"`
+	// Delivery state of messages sent by the current user; omitted for others.
+	DeliveryStatus MessageDeliveryStatus `json:"deliveryStatus,omitempty"`
+	// Set on search results to explain why the message matched the query.
+	Match *SearchMatch `json:"match,omitempty"`
+	// Annotations stored by the calling client, keyed by namespace. Only
+	// present when requested with includeAnnotations=true.
+	Annotations map[string]json.RawMessage `json:"annotations,omitempty"`
+	// Content hashes of attachments already in the local asset cache, keyed
+	// by attachment ID. Equal hashes mean the same file, whatever the chat.
+	AttachmentContentHashes map[string]string `json:"attachmentContentHashes,omitempty"`
+	// Attachments that are animations (GIFs, animated WebP or Lottie
+	// stickers), keyed by attachment ID. A still frame is available from
+	// /v1/assets/serve with poster=true.
+	AttachmentIsAnimated map[string]bool `json:"attachmentIsAnimated,omitempty"`
+	// Set when a bridge marked the message as forwarded in its text.
+	ForwardedFrom *ForwardedFrom `json:"forwardedFrom,omitempty"`
+}
+
+// ForwardedFrom is the forward header parsed out of a bridged message. Name
+// and UserID are set when the bridge names the original sender; Text is the
+// forwarded content without the header and quote markers.
+type ForwardedFrom struct {
+	Name   string `json:"name,omitempty"`
+	UserID string `json:"userID,omitempty"`
+	Text   string `json:"text"`
+}
+
+const (
+	SearchMatchFieldText        = "text"
+	SearchMatchFieldFileName    = "fileName"
+	SearchMatchFieldReactionKey = "reactionKey"
+)
+
+type SearchMatch struct {
+	// Fields lists the message fields any query term was found in.
+	Fields []string `json:"fields"`
+	// Terms are the normalized query terms that were matched.
+	Terms []string `json:"terms"`
+	// Highlights are rune offsets into the message text, sorted and
+	// non-overlapping. Snippet is an HTML-escaped excerpt around the first
+	// highlight with matches wrapped in <mark>.
+	Highlights []SearchHighlight `json:"highlights,omitempty"`
+	Snippet    string            `json:"snippet,omitempty"`
+}
+
+// SearchHighlight is a half-open [Start, End) rune range.
+type SearchHighlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type MessageDeliveryStatus string
+
+const (
+	MessageDeliveryStatusPending   MessageDeliveryStatus = "pending"
+	MessageDeliveryStatusSent      MessageDeliveryStatus = "sent"
+	MessageDeliveryStatusDelivered MessageDeliveryStatus = "delivered"
+	MessageDeliveryStatusRead      MessageDeliveryStatus = "read"
+	MessageDeliveryStatusFailed    MessageDeliveryStatus = "failed"
+)
+
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+	// Image is an mxc:// URI that can be resolved through the asset endpoints.
+	Image       string `json:"image,omitempty"`
+	ImageWidth  int    `json:"imageWidth,omitempty"`
+	ImageHeight int    `json:"imageHeight,omitempty"`
+}
+
+// ServerHealth is the monitoring view served by GET /v1/health. It is kept
+// out of the public /v1/info response.
+type ServerHealth struct {
+	// LastSyncAt and SyncLagSeconds are omitted until the first sync completes.
+	LastSyncAt         *time.Time `json:"lastSyncAt,omitempty"`
+	SyncLagSeconds     *int64     `json:"syncLagSeconds,omitempty"`
+	DBSizeBytes        int64      `json:"dbSizeBytes"`
+	PendingOutboxCount int64      `json:"pendingOutboxCount"`
+	WSClientCount      int        `json:"wsClientCount"`
+	// WorkPools reports load on the bounded pools heavy routes run in.
+	WorkPools map[string]WorkPoolStats `json:"workPools,omitempty"`
+	// PrimaryURL is set when this instance is a read-only follower.
+	PrimaryURL string `json:"primaryURL,omitempty"`
+}
+
+// WorkPoolStats is a point-in-time view of one bounded route pool. Rejected
+// counts requests turned away with 503 since startup.
+type WorkPoolStats struct {
+	Capacity int   `json:"capacity"`
+	Active   int   `json:"active"`
+	Queued   int64 `json:"queued"`
+	Rejected int64 `json:"rejected"`
+}
+
 type Chat struct {
 	beeperdesktopapi.Chat
 	// Extension for current renderer expectations.
@@ -32,10 +137,20 @@ type Chat struct {
 	IsMarkedUnread bool `json:"isMarkedUnread"`
 	// Low-priority is not in the public SDK schema, but Desktop consumers use it.
 	IsLowPriority bool `json:"isLowPriority,omitempty"`
+	// Tags are the chat's Matrix room tags (m.favourite, u.work, ...).
+	Tags []string `json:"tags,omitempty"`
+	// Description is the Matrix room topic; bridges map group descriptions here.
+	Description string `json:"description,omitempty"`
+	// PredecessorChatIDs are the rooms this chat replaced through room
+	// upgrades, newest first.
+	PredecessorChatIDs []string `json:"predecessorChatIDs,omitempty"`
 	// Extra metadata consumed by Desktop-side inbox/archive logic.
 	Extra *ChatExtra `json:"extra,omitempty"`
 	// Snooze metadata used by Desktop-side scheduling views.
 	Snooze *ChatSnooze `json:"snooze,omitempty"`
+	// Integration metadata stored by the calling client, keyed by namespace.
+	// Only present when requested with includeMetadata=true.
+	Metadata map[string]json.RawMessage `json:"metadata,omitempty"`
 }
 
 type ChatExtra struct {
@@ -56,6 +171,17 @@ type ListChatsOutput struct {
 
 type SearchChatsOutput = ListChatsOutput
 
+// FoundChat is a chat returned by GET /v1/chats/find. Match is "exact" when
+// the chat has no other members, "superset" when it has more.
+type FoundChat struct {
+	Chat  Chat   `json:"chat"`
+	Match string `json:"match"`
+}
+
+type FindChatsOutput struct {
+	Items []FoundChat `json:"items"`
+}
+
 type ListMessagesOutput struct {
 	Items   []Message `json:"items"`
 	HasMore bool      `json:"hasMore"`
@@ -69,7 +195,81 @@ type SearchMessagesOutput struct {
 	NewestCursor *string         `json:"newestCursor"`
 }
 
-type SendMessageOutput = beeperdesktopapi.MessageSendResponse
+// MessageReceipt is a participant whose read receipt is at or after a
+// message. ReadAt is the receipt's own timestamp, so it may be later than the
+// moment the participant first saw the message.
+type MessageReceipt struct {
+	User             User      `json:"user"`
+	ReadAt           time.Time `json:"readAt"`
+	ReceiptMessageID string    `json:"receiptMessageID"`
+}
+
+type ListMessageReceiptsOutput struct {
+	Items []MessageReceipt `json:"items"`
+}
+
+// ReactionSummary aggregates one reaction key. Count is the number of
+// distinct users who reacted with it.
+type ReactionSummary struct {
+	ReactionKey   string `json:"reactionKey"`
+	Count         int64  `json:"count"`
+	Emoji         bool   `json:"emoji"`
+	ReactedBySelf bool   `json:"reactedBySelf"`
+}
+
+type ReactionDetail struct {
+	ID          string    `json:"id"`
+	ReactionKey string    `json:"reactionKey"`
+	User        User      `json:"user"`
+	ReactedAt   time.Time `json:"reactedAt"`
+}
+
+// ListMessageReactionsOutput always carries the full Summary; only Items,
+// the individual reactions, is paginated.
+type ListMessageReactionsOutput struct {
+	Summary    []ReactionSummary `json:"summary"`
+	Items      []ReactionDetail  `json:"items"`
+	HasMore    bool              `json:"hasMore"`
+	NextCursor *string           `json:"nextCursor"`
+}
+
+type MembershipChangeAction string
+
+const (
+	MembershipChangeJoined         MembershipChangeAction = "joined"
+	MembershipChangeLeft           MembershipChangeAction = "left"
+	MembershipChangeInvited        MembershipChangeAction = "invited"
+	MembershipChangeInviteRejected MembershipChangeAction = "inviteRejected"
+	MembershipChangeInviteRevoked  MembershipChangeAction = "inviteRevoked"
+	MembershipChangeKicked         MembershipChangeAction = "kicked"
+	MembershipChangeBanned         MembershipChangeAction = "banned"
+	MembershipChangeUnbanned       MembershipChangeAction = "unbanned"
+	MembershipChangeKnocked        MembershipChangeAction = "knocked"
+)
+
+// MembershipChange is one entry of a chat's membership history. Actor is
+// the user who made the change and equals User for joins and voluntary leaves.
+type MembershipChange struct {
+	ID        string                 `json:"id"`
+	Action    MembershipChangeAction `json:"action"`
+	User      User                   `json:"user"`
+	Actor     User                   `json:"actor"`
+	Reason    string                 `json:"reason,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+type ListMembershipHistoryOutput struct {
+	Items      []MembershipChange `json:"items"`
+	HasMore    bool               `json:"hasMore"`
+	NextCursor *string            `json:"nextCursor"`
+}
+
+// SendMessageOutput lists the pending IDs of every event a send with an
+// attachments list produced; PendingMessageID is the first of them.
+type SendMessageOutput struct {
+	beeperdesktopapi.MessageSendResponse
+	PendingMessageIDs []string `json:"pendingMessageIDs,omitempty"`
+}
 type EditMessageOutput = beeperdesktopapi.MessageUpdateResponse
 
 type AddReactionOutput = beeperdesktopapi.ChatMessageReactionAddResponse
@@ -77,10 +277,65 @@ type AddReactionOutput = beeperdesktopapi.ChatMessageReactionAddResponse
 type RemoveReactionOutput = beeperdesktopapi.ChatMessageReactionDeleteResponse
 
 type DownloadAssetInput = beeperdesktopapi.AssetDownloadParams
-type DownloadAssetOutput = beeperdesktopapi.AssetDownloadResponse
+
+// DownloadAssetOutput and UploadAssetOutput add the file's content hash
+// ("sha256:<hex>"), which stays the same when a file is re-sent under a new
+// mxc URI.
+type DownloadAssetOutput struct {
+	beeperdesktopapi.AssetDownloadResponse
+	ContentHash string `json:"contentHash,omitempty"`
+}
 
 type UploadAssetInput = beeperdesktopapi.AssetUploadBase64Params
-type UploadAssetOutput = beeperdesktopapi.AssetUploadBase64Response
+type UploadAssetOutput struct {
+	beeperdesktopapi.AssetUploadBase64Response
+	ContentHash string `json:"contentHash,omitempty"`
+	// IsAnimated is set for animated GIF, PNG and WebP images and Lottie
+	// (TGS) files.
+	IsAnimated bool `json:"isAnimated,omitempty"`
+}
+
+// PruneAssetCacheOutput reports an asset cache eviction pass. CacheBytes is
+// the size left afterwards.
+type PruneAssetCacheOutput struct {
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+	RemovedFiles   int   `json:"removedFiles"`
+	CacheBytes     int64 `json:"cacheBytes"`
+	MaxBytes       int64 `json:"maxBytes"`
+}
+
+// StorageRepairOutput summarizes a consistency pass over the upload and
+// asset stores. Counts are of entries removed or rewritten.
+type StorageRepairOutput struct {
+	CheckedUploads        int   `json:"checkedUploads"`
+	RepairedUploads       int   `json:"repairedUploads"`
+	RemovedUploads        int   `json:"removedUploads"`
+	RemovedUploadSessions int   `json:"removedUploadSessions"`
+	RemovedTempFiles      int   `json:"removedTempFiles"`
+	RemovedIndexEntries   int   `json:"removedIndexEntries"`
+	CheckedBlobs          int   `json:"checkedBlobs"`
+	RemovedBlobs          int   `json:"removedBlobs"`
+	ReclaimedBytes        int64 `json:"reclaimedBytes"`
+}
+
+// CreateUploadSessionInput starts a resumable upload. FileSize is required
+// so the server knows when every chunk has arrived.
+type CreateUploadSessionInput struct {
+	FileName string `json:"fileName,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	FileSize int64  `json:"fileSize"`
+}
+
+// UploadSession reports how much of a resumable upload the server has
+// stored. Clients resume by sending the next chunk at Offset.
+type UploadSession struct {
+	SessionID string    `json:"sessionID"`
+	FileName  string    `json:"fileName"`
+	MimeType  string    `json:"mimeType"`
+	FileSize  int64     `json:"fileSize"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
 
 type SendMessageInput = beeperdesktopapi.MessageSendParams
 type MessageAttachmentInput = beeperdesktopapi.MessageSendParamsAttachment
@@ -98,12 +353,393 @@ type RemoveReactionInput struct {
 type ArchiveChatInput = beeperdesktopapi.ChatArchiveParams
 type SetChatReminderInput = beeperdesktopapi.ChatReminderNewParams
 
+type ChatBan struct {
+	User     User       `json:"user"`
+	Reason   string     `json:"reason,omitempty"`
+	BannedBy string     `json:"bannedBy,omitempty"`
+	BannedAt *time.Time `json:"bannedAt,omitempty"`
+}
+
+type ListChatBansOutput struct {
+	Items []ChatBan `json:"items"`
+}
+
+// RoomPreview describes a room without joining it. ChatID is set when the
+// account is already in the room. Messages is only filled for world-readable
+// rooms, oldest first.
+type RoomPreview struct {
+	RoomID         string               `json:"roomID"`
+	ChatID         string               `json:"chatID,omitempty"`
+	CanonicalAlias string               `json:"canonicalAlias,omitempty"`
+	Name           string               `json:"name,omitempty"`
+	Topic          string               `json:"topic,omitempty"`
+	AvatarURL      string               `json:"avatarURL,omitempty"`
+	MemberCount    int                  `json:"memberCount"`
+	JoinRule       string               `json:"joinRule,omitempty"`
+	RoomType       string               `json:"roomType,omitempty"`
+	WorldReadable  bool                 `json:"worldReadable"`
+	GuestCanJoin   bool                 `json:"guestCanJoin"`
+	Encrypted      bool                 `json:"encrypted"`
+	Membership     string               `json:"membership,omitempty"`
+	Messages       []RoomPreviewMessage `json:"messages"`
+}
+
+type RoomPreviewMessage struct {
+	ID        string    `json:"id"`
+	SenderID  string    `json:"senderID"`
+	Timestamp time.Time `json:"timestamp"`
+	MsgType   string    `json:"msgType"`
+	Text      string    `json:"text"`
+}
+
+type ChatRole string
+
+const (
+	ChatRoleAdmin     ChatRole = "admin"
+	ChatRoleModerator ChatRole = "moderator"
+	ChatRoleMember    ChatRole = "member"
+)
+
+type ChatMemberPermission struct {
+	UserID     string   `json:"userID"`
+	Role       ChatRole `json:"role"`
+	PowerLevel int      `json:"powerLevel"`
+}
+
+type ChatPermissions struct {
+	// Role of the current user in the chat.
+	Role ChatRole `json:"role"`
+	// Members with a role above the chat's default.
+	Users []ChatMemberPermission `json:"users"`
+	// Minimum role needed for each action, e.g. "invite" or "changeTitle".
+	Actions map[string]ChatRole `json:"actions"`
+}
+
+type UpdateChatPermissionsInput struct {
+	Users   map[string]ChatRole `json:"users,omitempty"`
+	Actions map[string]ChatRole `json:"actions,omitempty"`
+}
+
+// UpdateChatInput is a partial update; omitted fields are left unchanged.
+type UpdateChatInput struct {
+	Title *string `json:"title,omitempty"`
+	// AvatarUploadID is an uploadID from /v1/assets/upload; an empty string
+	// removes the current avatar.
+	AvatarUploadID *string `json:"avatarUploadID,omitempty"`
+	// Description sets the room topic; an empty string clears it.
+	Description *string `json:"description,omitempty"`
+}
+
+// Sandbox is a private test chat. While Route is set, every chat write by the
+// owning client (sends, edits, reactions, deletes and so on) goes to the
+// sandbox instead of its target chat.
+type Sandbox struct {
+	ChatID    string    `json:"chatID"`
+	Name      string    `json:"name"`
+	ClientID  string    `json:"clientID"`
+	Route     bool      `json:"route"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ListSandboxesOutput struct {
+	Items []Sandbox `json:"items"`
+}
+
+// Followup is a chat whose latest message is an inbound question or request
+// the user has not replied to. WaitingSince is that message's timestamp.
+type Followup struct {
+	Chat         Chat      `json:"chat"`
+	Message      Message   `json:"message"`
+	WaitingSince time.Time `json:"waitingSince"`
+}
+
+type ListFollowupsOutput struct {
+	Items []Followup `json:"items"`
+}
+
+// ChangesOutput lists chats changed since the consumer's cursor. Full is set
+// when the cursor was missing or could not be resumed, in which case every
+// chat is replayed and the consumer should drop chats it doesn't see.
+type ChangesOutput struct {
+	Chats          []Chat   `json:"chats"`
+	DeletedChatIDs []string `json:"deletedChatIDs"`
+	Cursor         string   `json:"cursor"`
+	HasMore        bool     `json:"hasMore"`
+	Full           bool     `json:"full"`
+}
+
+type ChatExportStatus string
+
+const (
+	ChatExportStatusPending   ChatExportStatus = "pending"
+	ChatExportStatusRunning   ChatExportStatus = "running"
+	ChatExportStatusCompleted ChatExportStatus = "completed"
+	ChatExportStatusFailed    ChatExportStatus = "failed"
+)
+
+// ChatExport is a transcript export job. SrcURL points at the finished
+// transcript and can be passed to /v1/assets/serve.
+type ChatExport struct {
+	ID               string           `json:"id"`
+	ChatID           string           `json:"chatID"`
+	Format           string           `json:"format"`
+	IncludeMedia     bool             `json:"includeMedia"`
+	Status           ChatExportStatus `json:"status"`
+	MessagesExported int64            `json:"messagesExported"`
+	SrcURL           string           `json:"srcURL,omitempty"`
+	Error            string           `json:"error,omitempty"`
+	CreatedAt        time.Time        `json:"createdAt"`
+	CompletedAt      *time.Time       `json:"completedAt,omitempty"`
+}
+
+// AccountImportOutput describes a staged account import. The imported state
+// replaces the local one the next time the server starts.
+type AccountImportOutput struct {
+	UserID          string `json:"userID"`
+	ExportedAt      int64  `json:"exportedAt"`
+	RestartRequired bool   `json:"restartRequired"`
+}
+
+// ApplyConfigOutput reports how many entries of an applied declarative
+// config document were upserted.
+type ApplyConfigOutput struct {
+	OAuthClients       int  `json:"oauthClients"`
+	ClientPolicies     int  `json:"clientPolicies"`
+	AutoArchiveChanged bool `json:"autoArchiveChanged"`
+}
+
+// AutoArchivePolicy configures the background task that archives idle
+// chats. A rule with zero days is disabled.
+type AutoArchivePolicy struct {
+	// InactiveDays archives any chat without activity for this many days.
+	InactiveDays int `json:"inactiveDays"`
+	// LowPriorityUnreadDays archives low-priority chats that have sat unread
+	// for this many days.
+	LowPriorityUnreadDays int      `json:"lowPriorityUnreadDays"`
+	ExcludedChatIDs       []string `json:"excludedChatIDs"`
+	// LastRunAt is set by the server and ignored on update.
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+}
+
+// DigestSettings schedules the email digest of unread mentions for the
+// calling user. A digest is only sent when chats with mentions have seen
+// activity since the previous one.
+type DigestSettings struct {
+	Email         string `json:"email"`
+	IntervalHours int    `json:"intervalHours"`
+	// SendWhileConnected also sends digests while a websocket client is
+	// connected; by default the digest is only a fallback.
+	SendWhileConnected bool `json:"sendWhileConnected"`
+	// Locale is the language of the email. It defaults to the locale of the
+	// request that scheduled the digest.
+	Locale string `json:"locale,omitempty"`
+	// LastSentAt is set by the server and ignored on update.
+	LastSentAt *time.Time `json:"lastSentAt,omitempty"`
+}
+
+// SendDigestOutput reports how many chats a manually triggered digest
+// covered. Sent is false when there was nothing to report.
+type SendDigestOutput struct {
+	Sent  bool `json:"sent"`
+	Chats int  `json:"chats"`
+}
+
+// AccountData is a raw Matrix account data event. ChatID is set for
+// room-scoped data.
+type AccountData struct {
+	Type    string          `json:"type"`
+	ChatID  string          `json:"chatID,omitempty"`
+	Content json.RawMessage `json:"content"`
+}
+
+// BridgeLoginFlow is one way of linking an account on a bridge, such as a
+// QR code or phone number login.
+type BridgeLoginFlow struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type BridgeLoginFlowsOutput struct {
+	BridgeID string            `json:"bridgeID"`
+	Flows    []BridgeLoginFlow `json:"flows"`
+}
+
+// BridgeLoginStep is the current step of an account link in progress. Type
+// is one of user_input, cookies, display_and_wait or complete, and decides
+// which of Fields, Cookies or Display is set.
+type BridgeLoginStep struct {
+	BridgeID       string              `json:"bridgeID"`
+	LoginProcessID string              `json:"loginProcessID"`
+	StepID         string              `json:"stepID"`
+	Type           string              `json:"type"`
+	Instructions   string              `json:"instructions,omitempty"`
+	Fields         []BridgeLoginField  `json:"fields,omitempty"`
+	Display        *BridgeLoginDisplay `json:"display,omitempty"`
+	// Cookies is the bridge's description of a webview cookie login, passed
+	// through unchanged.
+	Cookies json.RawMessage `json:"cookies,omitempty"`
+	// AccountID is set once the login is complete.
+	AccountID string    `json:"accountID,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type BridgeLoginField struct {
+	Type         string   `json:"type"`
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	DefaultValue string   `json:"defaultValue,omitempty"`
+	Pattern      string   `json:"pattern,omitempty"`
+	Options      []string `json:"options,omitempty"`
+}
+
+// BridgeLoginDisplay is something to show the user while the bridge waits,
+// such as a QR code or an emoji verification.
+type BridgeLoginDisplay struct {
+	Type     string `json:"type"`
+	Data     string `json:"data,omitempty"`
+	ImageURL string `json:"imageURL,omitempty"`
+}
+
+// AccountStatus is the bridge state of an account as recorded in
+// com.beeper.local_bridge_state. State is passed through unchanged, e.g.
+// CONNECTED, TRANSIENT_DISCONNECT or BAD_CREDENTIALS.
+type AccountStatus struct {
+	AccountID string               `json:"accountID"`
+	Network   string               `json:"network,omitempty"`
+	State     string               `json:"state"`
+	Error     string               `json:"error,omitempty"`
+	Message   string               `json:"message,omitempty"`
+	Healthy   bool                 `json:"healthy"`
+	UpdatedAt *time.Time           `json:"updatedAt,omitempty"`
+	Devices   []AccountDeviceState `json:"devices"`
+}
+
+type AccountDeviceState struct {
+	DeviceID      string     `json:"deviceID"`
+	State         string     `json:"state"`
+	UpdatedAt     *time.Time `json:"updatedAt,omitempty"`
+	CurrentDevice bool       `json:"currentDevice,omitempty"`
+}
+
+// Invite is a chat the account has been invited to but not yet joined. The
+// fields come from the invite's stripped state and are not verified.
+type Invite struct {
+	ChatID    string    `json:"chatID"`
+	AccountID string    `json:"accountID"`
+	Network   string    `json:"network,omitempty"`
+	Title     string    `json:"title"`
+	Type      ChatType  `json:"type"`
+	ImgURL    string    `json:"imgURL,omitempty"`
+	Inviter   *User     `json:"inviter,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	InvitedAt time.Time `json:"invitedAt"`
+}
+
+type ListInvitesOutput struct {
+	Items []Invite `json:"items"`
+}
+
+// Space is a joined Matrix space. ChildSpaceIDs and ParentSpaceIDs link it
+// to the other joined spaces so clients can rebuild the hierarchy.
+type Space struct {
+	ID             string   `json:"id"`
+	Title          string   `json:"title"`
+	Description    string   `json:"description,omitempty"`
+	ImgURL         string   `json:"imgURL,omitempty"`
+	ChildSpaceIDs  []string `json:"childSpaceIDs"`
+	ParentSpaceIDs []string `json:"parentSpaceIDs"`
+	// ChildCount counts non-space children, including chats not joined yet.
+	ChildCount int `json:"childCount"`
+}
+
+type ListSpacesOutput struct {
+	Items []Space `json:"items"`
+}
+
+type SpaceChatsOutput struct {
+	Space Space  `json:"space"`
+	Items []Chat `json:"items"`
+	// UnjoinedChatIDs lists children the account is not a member of.
+	UnjoinedChatIDs []string `json:"unjoinedChatIDs"`
+}
+
+// ClientAccessRule lists what an OAuth client may touch: chats listed
+// directly, plus every chat on the listed accounts.
+type ClientAccessRule struct {
+	AccountIDs []string `json:"accountIDs"`
+	ChatIDs    []string `json:"chatIDs"`
+}
+
+type ClientAccessPolicy struct {
+	ClientID string           `json:"clientID"`
+	Read     ClientAccessRule `json:"read"`
+	// Write defaults to Read when omitted.
+	Write     *ClientAccessRule `json:"write,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+type ListClientAccessPoliciesOutput struct {
+	Items []ClientAccessPolicy `json:"items"`
+}
+
+type ChatTag struct {
+	Tag   string   `json:"tag"`
+	Order *float64 `json:"order,omitempty"`
+}
+
+type ChatTagsOutput struct {
+	ChatID string    `json:"chatID"`
+	Tags   []ChatTag `json:"tags"`
+}
+
+type DryRunIssue struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// DryRunOutput describes what a mutating request would do without doing it.
+type DryRunOutput struct {
+	DryRun       bool   `json:"dryRun"`
+	Action       string `json:"action"`
+	WouldSucceed bool   `json:"wouldSucceed"`
+	ChatID       string `json:"chatID,omitempty"`
+	// For chat creation: "existing" when a matching chat would be reused.
+	Status        string        `json:"status,omitempty"`
+	MessageType   MessageType   `json:"messageType,omitempty"`
+	ResolvedUsers []User        `json:"resolvedUsers,omitempty"`
+	Issues        []DryRunIssue `json:"issues"`
+}
+
 type ActionSuccessOutput struct {
 	Success bool `json:"success"`
 }
 
 type SearchContactsOutput = beeperdesktopapi.AccountContactSearchResponse
 
+// GlobalContact is a contact found on one or more accounts. Entries from
+// different accounts are merged when they share a phone number or email.
+type GlobalContact struct {
+	User
+	AccountIDs []string `json:"accountIDs"`
+}
+
+type SearchAllContactsOutput struct {
+	Items   []GlobalContact `json:"items"`
+	HasMore bool            `json:"hasMore"`
+}
+
+// ListParticipantsOutput pages through a chat's joined and invited members.
+// Total counts matches for the query across all pages.
+type ListParticipantsOutput struct {
+	Items      []User  `json:"items"`
+	HasMore    bool    `json:"hasMore"`
+	Total      int64   `json:"total"`
+	NextCursor *string `json:"nextCursor"`
+}
+
 type ListContactsOutput struct {
 	Items        []User  `json:"items"`
 	HasMore      bool    `json:"hasMore"`
@@ -125,6 +761,31 @@ type CreateChatInput struct {
 	MessageText    string                    `json:"messageText,omitempty"`
 	User           *CreateChatStartUserInput `json:"user,omitempty"`
 	AllowInvite    *bool                     `json:"allowInvite,omitempty"`
+	// AvatarUploadID, Description and PowerLevels seed a new group chat's
+	// initial state so it needs no follow-up updates.
+	AvatarUploadID string                 `json:"avatarUploadID,omitempty"`
+	Description    string                 `json:"description,omitempty"`
+	PowerLevels    *CreateChatPowerLevels `json:"powerLevels,omitempty"`
+	// Encrypted enables end-to-end encryption from the start.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// AllowExisting makes mode=create return an existing chat of the same
+	// type with exactly the same participants instead of creating another.
+	AllowExisting bool `json:"allowExisting,omitempty"`
+}
+
+// CreateChatPowerLevels overrides the default power levels of a new chat.
+// Unset fields keep the homeserver defaults.
+type CreateChatPowerLevels struct {
+	// Users maps Matrix user IDs to levels. The creator keeps level 100
+	// unless listed.
+	Users         map[string]int `json:"users,omitempty"`
+	Events        map[string]int `json:"events,omitempty"`
+	EventsDefault *int           `json:"eventsDefault,omitempty"`
+	StateDefault  *int           `json:"stateDefault,omitempty"`
+	Invite        *int           `json:"invite,omitempty"`
+	Kick          *int           `json:"kick,omitempty"`
+	Ban           *int           `json:"ban,omitempty"`
+	Redact        *int           `json:"redact,omitempty"`
 }
 
 type CreateChatOutput = beeperdesktopapi.ChatNewResponse
@@ -135,6 +796,152 @@ type UnifiedSearchResults struct {
 	Messages SearchMessagesOutput `json:"messages"`
 }
 
+// UnifiedSearchCursors holds the oldest cursor of each section. Pass one
+// back with section=<key> to continue that section; nil means exhausted.
+type UnifiedSearchCursors struct {
+	Chats    *string `json:"chats"`
+	InGroups *string `json:"in_groups"`
+	Messages *string `json:"messages"`
+}
+
 type UnifiedSearchOutput struct {
 	Results UnifiedSearchResults `json:"results"`
+	Cursors UnifiedSearchCursors `json:"cursors"`
+}
+
+// RateLimitStatus describes the caller's request budget for the current
+// window. Limit and Remaining are omitted while rate limiting is disabled.
+type RateLimitStatus struct {
+	Enabled       bool       `json:"enabled"`
+	Limit         int        `json:"limit,omitempty"`
+	Remaining     int        `json:"remaining,omitempty"`
+	WindowSeconds int        `json:"windowSeconds,omitempty"`
+	ResetAt       *time.Time `json:"resetAt,omitempty"`
+}
+
+// AccountMessageStats counts the messages of one account seen in sync.
+// Outbound includes messages sent from other devices of the same user.
+type AccountMessageStats struct {
+	AccountID      string     `json:"accountID"`
+	Inbound        int64      `json:"inbound"`
+	Outbound       int64      `json:"outbound"`
+	LastInboundAt  *time.Time `json:"lastInboundAt,omitempty"`
+	LastOutboundAt *time.Time `json:"lastOutboundAt,omitempty"`
+}
+
+// NetworkStats sums AccountMessageStats per network. NetworkID is the stable
+// bridge name, e.g. signal, and Network the display name.
+type NetworkStats struct {
+	NetworkID      string                `json:"networkID"`
+	Network        string                `json:"network"`
+	Inbound        int64                 `json:"inbound"`
+	Outbound       int64                 `json:"outbound"`
+	LastInboundAt  *time.Time            `json:"lastInboundAt,omitempty"`
+	LastOutboundAt *time.Time            `json:"lastOutboundAt,omitempty"`
+	Accounts       []AccountMessageStats `json:"accounts"`
+}
+
+type NetworkStatsOutput struct {
+	// Since is when counting started, i.e. the server start.
+	Since    time.Time      `json:"since"`
+	Networks []NetworkStats `json:"networks"`
+}
+
+// Verification is an interactive SAS verification between this session and
+// another device. State is requested, ready, sas, confirmed, done or
+// cancelled; Emojis and Decimals are shown once it reaches sas.
+type Verification struct {
+	TransactionID string     `json:"transactionID"`
+	UserID        string     `json:"userID"`
+	DeviceID      string     `json:"deviceID,omitempty"`
+	Incoming      bool       `json:"incoming"`
+	State         string     `json:"state"`
+	Emojis        []SASEmoji `json:"emojis,omitempty"`
+	Decimals      []int      `json:"decimals,omitempty"`
+	CancelCode    string     `json:"cancelCode,omitempty"`
+	CancelReason  string     `json:"cancelReason,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+type SASEmoji struct {
+	Emoji       string `json:"emoji"`
+	Description string `json:"description"`
+}
+
+type ListVerificationsOutput struct {
+	Items []Verification `json:"items"`
+}
+
+// E2EESessionHealth reports how well the recent encrypted events of a chat
+// decrypt. Sessions lists only the Megolm sessions with undecryptable events,
+// oldest first.
+type E2EESessionHealth struct {
+	ChatID              string               `json:"chatID"`
+	Encrypted           bool                 `json:"encrypted"`
+	CheckedEvents       int                  `json:"checkedEvents"`
+	EncryptedEvents     int                  `json:"encryptedEvents"`
+	UndecryptableEvents int                  `json:"undecryptableEvents"`
+	OldestMissingAt     *time.Time           `json:"oldestMissingAt,omitempty"`
+	Sessions            []E2EEMissingSession `json:"sessions"`
+}
+
+// E2EEMissingSession is a Megolm session this device has no key for.
+// KeyRequested and BackupChecked mirror the client's key request queue.
+type E2EEMissingSession struct {
+	SessionID           string    `json:"sessionID"`
+	SenderID            string    `json:"senderID"`
+	UndecryptableEvents int       `json:"undecryptableEvents"`
+	OldestEventAt       time.Time `json:"oldestEventAt"`
+	NewestEventAt       time.Time `json:"newestEventAt"`
+	Error               string    `json:"error,omitempty"`
+	Queued              bool      `json:"queued"`
+	BackupChecked       bool      `json:"backupChecked"`
+	KeyRequested        bool      `json:"keyRequested"`
+}
+
+// E2EERepairOutput lists the sessions queued for another key backup lookup
+// and key request, with the health as it stood before the requests went out.
+type E2EERepairOutput struct {
+	QueuedSessions []string          `json:"queuedSessions"`
+	Health         E2EESessionHealth `json:"health"`
+}
+
+// KeyBackupStatus describes the server-side key backup. Version is the
+// backup this session uploads to and is empty while backup is disabled;
+// ServerVersion is the latest one on the homeserver. Trusted is true when the
+// local key matches that backup.
+type KeyBackupStatus struct {
+	Enabled         bool              `json:"enabled"`
+	Version         string            `json:"version,omitempty"`
+	ServerVersion   string            `json:"serverVersion,omitempty"`
+	Algorithm       string            `json:"algorithm,omitempty"`
+	BackedUpKeys    int               `json:"backedUpKeys"`
+	ETag            string            `json:"etag,omitempty"`
+	Trusted         bool              `json:"trusted"`
+	PendingSessions int               `json:"pendingSessions"`
+	Restore         *KeyBackupRestore `json:"restore,omitempty"`
+}
+
+// KeyBackupRestore is the progress of the latest restore. FinishedAt is unset
+// while it is still running.
+type KeyBackupRestore struct {
+	ChatID           string     `json:"chatID,omitempty"`
+	Stage            string     `json:"stage"`
+	Decrypted        int        `json:"decrypted"`
+	DecryptionFailed int        `json:"decryptionFailed"`
+	ImportFailed     int        `json:"importFailed"`
+	Saved            int        `json:"saved"`
+	Total            int        `json:"total"`
+	Error            string     `json:"error,omitempty"`
+	StartedAt        time.Time  `json:"startedAt"`
+	FinishedAt       *time.Time `json:"finishedAt,omitempty"`
+}
+
+// CalendarFeedToken is a read-only credential for the reminders calendar
+// feed. Token is only returned when it is issued.
+type CalendarFeedToken struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
 }
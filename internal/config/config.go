@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"io/fs"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -23,11 +27,83 @@ type Config struct {
 	MatrixUsername      string
 	MatrixPassword      string
 	MatrixRecoveryKey   string
+	ScriptsEnabled      bool
+	OAuthRequireConsent bool
+	PluginsFile         string
+	ProxyURL            string
+	CAFile              string
+	HTTPTimeout         time.Duration
+	DialTimeout         time.Duration
+	// OAuthTokenFormat is "opaque" (default) or "jwt". JWT access tokens are
+	// signed with a key kept in the state dir and published as JWKS.
+	OAuthTokenFormat string
+	// Assets larger than AssetMaxDownloadBytes are streamed through instead of
+	// cached; AssetDownloadTimeout bounds a single cache fill.
+	AssetMaxDownloadBytes int64
+	AssetDownloadTimeout  time.Duration
+	// AssetCacheMaxBytes caps the asset cache directory. The least recently
+	// used files are evicted past it. Zero means the server default.
+	AssetCacheMaxBytes int64
+	// AccountImportMaxBytes caps how much an account import may unpack.
+	// Zero means the server default.
+	AccountImportMaxBytes int64
+	// KeepImageMetadata sends JPEG attachments as uploaded. By default their
+	// EXIF and XMP metadata is removed and the orientation applied first.
+	KeepImageMetadata bool
+	// LinkPreviewsInEncryptedRooms lets the homeserver fetch link previews
+	// for messages in encrypted rooms, which reveals their URLs to it.
+	LinkPreviewsInEncryptedRooms bool
+	// SearchConcurrency and UploadConcurrency cap how many heavy requests of
+	// each kind run at once, so they cannot starve latency-sensitive routes.
+	// Zero means the server default.
+	SearchConcurrency int
+	UploadConcurrency int
+	// RateLimit is how many authenticated requests one caller may make per
+	// minute. Zero disables rate limiting.
+	RateLimit int
+	// ReactionCoalesceWindow batches websocket message.upserted events caused
+	// by reactions so a busy message is hydrated once per window. Zero sends
+	// them immediately.
+	ReactionCoalesceWindow time.Duration
+	// SMTP settings for the optional email digest. Digests stay disabled
+	// unless SMTPHost is set.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	// DeviceDisplayName names the Matrix device created at login, and
+	// UserAgent is sent on outbound requests, so the session is easy to
+	// recognize in device lists and server logs.
+	DeviceDisplayName string
+	UserAgent         string
+	// Multi-user mode: unknown bearer tokens are introspected (RFC 7662)
+	// against an external identity provider and each subject is confined to
+	// the accounts and chats listed in SubjectPoliciesFile.
+	IdentityIntrospectionURL string
+	IdentityClientID         string
+	IdentityClientSecret     string
+	SubjectPoliciesFile      string
+	// Sessions names additional Matrix sessions hosted next to the primary
+	// one. Each has its own gomuks state and is served under
+	// /sessions/{name}/.
+	Sessions []string
+	// PrimaryURL turns the instance into a read-only follower: it serves
+	// read routes from a replicated copy of the primary's state dir, never
+	// syncs, and proxies everything else to the primary at this URL.
+	PrimaryURL string
+	// ReplayWebhookHosts lists the hosts /v1/admin/replay may deliver to
+	// by webhook. Webhook replay is off while it is empty.
+	ReplayWebhookHosts []string
 }
 
 const (
 	defaultListenAddr          = "127.0.0.1:23373"
 	defaultMatrixHomeserverURL = "https://matrix.beeper.com"
+	// defaultReactionCoalesceWindow applies when the variable is unset; an
+	// explicit 0 turns coalescing off.
+	defaultReactionCoalesceWindow = 300 * time.Millisecond
+	defaultSMTPPort               = 587
 )
 
 func Load() (Config, error) {
@@ -36,15 +112,36 @@ func Load() (Config, error) {
 	}
 
 	cfg := Config{
-		ListenAddr:          resolveListenAddr(),
-		AccessToken:         os.Getenv("MATRIX_ACCESS_TOKEN"),
-		AllowQueryTokenAuth: os.Getenv("MATRIX_ALLOW_QUERY_TOKEN") == "true",
-		ManageSecret:        strings.TrimSpace(os.Getenv("EASYMATRIX_MANAGE_SECRET")),
-		MatrixHomeserverURL: getenvDefault("MATRIX_HOMESERVER_URL", defaultMatrixHomeserverURL),
-		MatrixLoginToken:    os.Getenv("MATRIX_LOGIN_TOKEN"),
-		MatrixUsername:      os.Getenv("MATRIX_USERNAME"),
-		MatrixPassword:      os.Getenv("MATRIX_PASSWORD"),
-		MatrixRecoveryKey:   os.Getenv("MATRIX_RECOVERY_KEY"),
+		ListenAddr:                   resolveListenAddr(),
+		AccessToken:                  os.Getenv("MATRIX_ACCESS_TOKEN"),
+		AllowQueryTokenAuth:          os.Getenv("MATRIX_ALLOW_QUERY_TOKEN") == "true",
+		ManageSecret:                 strings.TrimSpace(os.Getenv("EASYMATRIX_MANAGE_SECRET")),
+		MatrixHomeserverURL:          getenvDefault("MATRIX_HOMESERVER_URL", defaultMatrixHomeserverURL),
+		MatrixLoginToken:             os.Getenv("MATRIX_LOGIN_TOKEN"),
+		MatrixUsername:               os.Getenv("MATRIX_USERNAME"),
+		MatrixPassword:               os.Getenv("MATRIX_PASSWORD"),
+		MatrixRecoveryKey:            os.Getenv("MATRIX_RECOVERY_KEY"),
+		ScriptsEnabled:               os.Getenv("EASYMATRIX_SCRIPTS_ENABLED") == "true",
+		OAuthRequireConsent:          os.Getenv("EASYMATRIX_OAUTH_REQUIRE_CONSENT") == "true",
+		KeepImageMetadata:            os.Getenv("EASYMATRIX_KEEP_IMAGE_METADATA") == "true",
+		LinkPreviewsInEncryptedRooms: os.Getenv("EASYMATRIX_LINK_PREVIEWS_ENCRYPTED") == "true",
+		OAuthTokenFormat:             strings.ToLower(getenvDefault("EASYMATRIX_OAUTH_TOKEN_FORMAT", "opaque")),
+		PluginsFile:                  strings.TrimSpace(os.Getenv("EASYMATRIX_PLUGINS_FILE")),
+		ProxyURL:                     strings.TrimSpace(os.Getenv("EASYMATRIX_PROXY_URL")),
+		CAFile:                       strings.TrimSpace(os.Getenv("EASYMATRIX_CA_FILE")),
+		DeviceDisplayName:            strings.TrimSpace(os.Getenv("EASYMATRIX_DEVICE_NAME")),
+		UserAgent:                    strings.TrimSpace(os.Getenv("EASYMATRIX_USER_AGENT")),
+		PrimaryURL:                   strings.TrimRight(strings.TrimSpace(os.Getenv("EASYMATRIX_PRIMARY_URL")), "/"),
+
+		IdentityIntrospectionURL: strings.TrimSpace(os.Getenv("EASYMATRIX_IDENTITY_INTROSPECTION_URL")),
+		IdentityClientID:         strings.TrimSpace(os.Getenv("EASYMATRIX_IDENTITY_CLIENT_ID")),
+		IdentityClientSecret:     os.Getenv("EASYMATRIX_IDENTITY_CLIENT_SECRET"),
+		SubjectPoliciesFile:      strings.TrimSpace(os.Getenv("EASYMATRIX_SUBJECT_POLICIES_FILE")),
+
+		SMTPHost:     strings.TrimSpace(os.Getenv("EASYMATRIX_SMTP_HOST")),
+		SMTPUsername: strings.TrimSpace(os.Getenv("EASYMATRIX_SMTP_USERNAME")),
+		SMTPPassword: os.Getenv("EASYMATRIX_SMTP_PASSWORD"),
+		SMTPFrom:     strings.TrimSpace(os.Getenv("EASYMATRIX_SMTP_FROM")),
 	}
 	if (cfg.MatrixUsername == "") != (cfg.MatrixPassword == "") {
 		return Config{}, fmt.Errorf("MATRIX_USERNAME and MATRIX_PASSWORD must be provided together")
@@ -52,10 +149,153 @@ func Load() (Config, error) {
 	if cfg.MatrixLoginToken != "" && cfg.MatrixUsername != "" {
 		return Config{}, fmt.Errorf("MATRIX_LOGIN_TOKEN cannot be combined with MATRIX_USERNAME/MATRIX_PASSWORD")
 	}
+	if cfg.OAuthTokenFormat != "opaque" && cfg.OAuthTokenFormat != "jwt" {
+		return Config{}, fmt.Errorf("EASYMATRIX_OAUTH_TOKEN_FORMAT must be one of: opaque, jwt")
+	}
+	if cfg.OAuthRequireConsent && cfg.ManageSecret == "" {
+		return Config{}, fmt.Errorf("EASYMATRIX_OAUTH_REQUIRE_CONSENT requires EASYMATRIX_MANAGE_SECRET")
+	}
+	if cfg.IdentityIntrospectionURL != "" && cfg.SubjectPoliciesFile == "" {
+		return Config{}, fmt.Errorf("EASYMATRIX_IDENTITY_INTROSPECTION_URL requires EASYMATRIX_SUBJECT_POLICIES_FILE")
+	}
+	if cfg.SMTPHost != "" && cfg.SMTPFrom == "" {
+		return Config{}, fmt.Errorf("EASYMATRIX_SMTP_HOST requires EASYMATRIX_SMTP_FROM")
+	}
+	if cfg.PrimaryURL != "" {
+		if parsed, err := url.Parse(cfg.PrimaryURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return Config{}, fmt.Errorf("EASYMATRIX_PRIMARY_URL must be an absolute http(s) URL")
+		}
+	}
+	cfg.ReplayWebhookHosts = parseHostList(os.Getenv("EASYMATRIX_REPLAY_WEBHOOK_HOSTS"))
+	var err error
+	if cfg.Sessions, err = parseSessionNames(os.Getenv("EASYMATRIX_SESSIONS")); err != nil {
+		return Config{}, err
+	}
+	if cfg.HTTPTimeout, err = getenvDuration("EASYMATRIX_HTTP_TIMEOUT"); err != nil {
+		return Config{}, err
+	}
+	if cfg.DialTimeout, err = getenvDuration("EASYMATRIX_DIAL_TIMEOUT"); err != nil {
+		return Config{}, err
+	}
+	if cfg.AssetDownloadTimeout, err = getenvDuration("EASYMATRIX_ASSET_DOWNLOAD_TIMEOUT"); err != nil {
+		return Config{}, err
+	}
+	if cfg.AssetMaxDownloadBytes, err = getenvBytes("EASYMATRIX_ASSET_MAX_DOWNLOAD_BYTES"); err != nil {
+		return Config{}, err
+	}
+	if cfg.AssetCacheMaxBytes, err = getenvBytes("EASYMATRIX_ASSET_CACHE_MAX_BYTES"); err != nil {
+		return Config{}, err
+	}
+	if cfg.AccountImportMaxBytes, err = getenvBytes("EASYMATRIX_ACCOUNT_IMPORT_MAX_BYTES"); err != nil {
+		return Config{}, err
+	}
+	if cfg.SearchConcurrency, err = getenvCount("EASYMATRIX_SEARCH_CONCURRENCY"); err != nil {
+		return Config{}, err
+	}
+	if cfg.UploadConcurrency, err = getenvCount("EASYMATRIX_UPLOAD_CONCURRENCY"); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimit, err = getenvCount("EASYMATRIX_RATE_LIMIT"); err != nil {
+		return Config{}, err
+	}
+	if cfg.ReactionCoalesceWindow, err = getenvDuration("EASYMATRIX_REACTION_COALESCE_WINDOW"); err != nil {
+		return Config{}, err
+	}
+	if _, set := os.LookupEnv("EASYMATRIX_REACTION_COALESCE_WINDOW"); !set {
+		cfg.ReactionCoalesceWindow = defaultReactionCoalesceWindow
+	}
+	if cfg.SMTPPort, err = getenvCount("EASYMATRIX_SMTP_PORT"); err != nil {
+		return Config{}, err
+	}
+	if cfg.SMTPPort == 0 {
+		cfg.SMTPPort = defaultSMTPPort
+	}
 	cfg.StateDir = resolveStateDir()
 	return cfg, nil
 }
 
+func getenvDuration(key string) (time.Duration, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 30s", key)
+	}
+	return value, nil
+}
+
+func getenvBytes(key string) (int64, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number of bytes", key)
+	}
+	return value, nil
+}
+
+func getenvCount(key string) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", key)
+	}
+	return value, nil
+}
+
+// parseHostList reads a comma-separated list of host names, lowercased so
+// they compare against parsed URLs.
+func parseHostList(raw string) []string {
+	var hosts []string
+	for _, host := range strings.Split(raw, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// parseSessionNames reads a comma-separated list of session names. Names
+// become directory and URL path segments, so they are limited to lowercase
+// letters, digits, "-" and "_".
+func parseSessionNames(raw string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !validSessionName(name) {
+			return nil, fmt.Errorf("EASYMATRIX_SESSIONS contains invalid session name %q", name)
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("EASYMATRIX_SESSIONS contains session %q more than once", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func validSessionName(name string) bool {
+	if len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
 func getenvDefault(key, fallback string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
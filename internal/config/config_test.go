@@ -47,3 +47,171 @@ func TestLoadUsesManageSecret(t *testing.T) {
 		t.Fatalf("ManageSecret = %q, want %q", got, want)
 	}
 }
+
+func TestLoadParsesOutboundTimeouts(t *testing.T) {
+	t.Setenv("EASYMATRIX_HTTP_TIMEOUT", "45s")
+	t.Setenv("EASYMATRIX_DIAL_TIMEOUT", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got, want := cfg.HTTPTimeout.String(), "45s"; got != want {
+		t.Fatalf("HTTPTimeout = %q, want %q", got, want)
+	}
+
+	t.Setenv("EASYMATRIX_DIAL_TIMEOUT", "soon")
+	if _, err = Load(); err == nil {
+		t.Fatal("expected invalid EASYMATRIX_DIAL_TIMEOUT to be rejected")
+	}
+}
+
+func TestLoadRejectsInvalidAssetDownloadLimit(t *testing.T) {
+	t.Setenv("EASYMATRIX_ASSET_MAX_DOWNLOAD_BYTES", "lots")
+
+	if _, err := Load(); err == nil {
+		t.Fatal("expected invalid EASYMATRIX_ASSET_MAX_DOWNLOAD_BYTES to be rejected")
+	}
+}
+
+func TestLoadParsesConcurrencyLimits(t *testing.T) {
+	t.Setenv("EASYMATRIX_SEARCH_CONCURRENCY", "3")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.SearchConcurrency != 3 || cfg.UploadConcurrency != 0 {
+		t.Fatalf("unexpected concurrency limits: search=%d upload=%d", cfg.SearchConcurrency, cfg.UploadConcurrency)
+	}
+
+	t.Setenv("EASYMATRIX_UPLOAD_CONCURRENCY", "0")
+	if _, err = Load(); err == nil {
+		t.Fatal("expected zero EASYMATRIX_UPLOAD_CONCURRENCY to be rejected")
+	}
+}
+
+func TestLoadValidatesPrimaryURL(t *testing.T) {
+	t.Setenv("EASYMATRIX_PRIMARY_URL", "https://primary.internal:23373/")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.PrimaryURL != "https://primary.internal:23373" {
+		t.Fatalf("unexpected primary URL: %q", cfg.PrimaryURL)
+	}
+
+	t.Setenv("EASYMATRIX_PRIMARY_URL", "primary.internal")
+	if _, err = Load(); err == nil {
+		t.Fatal("expected a relative EASYMATRIX_PRIMARY_URL to be rejected")
+	}
+}
+
+func TestLoadParsesRateLimit(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.RateLimit != 0 {
+		t.Fatalf("expected rate limiting to be off by default, got %d", cfg.RateLimit)
+	}
+
+	t.Setenv("EASYMATRIX_RATE_LIMIT", "120")
+	if cfg, err = Load(); err != nil || cfg.RateLimit != 120 {
+		t.Fatalf("unexpected rate limit: %d (err=%v)", cfg.RateLimit, err)
+	}
+
+	t.Setenv("EASYMATRIX_RATE_LIMIT", "many")
+	if _, err = Load(); err == nil {
+		t.Fatal("expected invalid EASYMATRIX_RATE_LIMIT to be rejected")
+	}
+}
+
+func TestLoadReactionCoalesceWindowDefaultsAndDisables(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.ReactionCoalesceWindow != defaultReactionCoalesceWindow {
+		t.Fatalf("ReactionCoalesceWindow = %v, want default", cfg.ReactionCoalesceWindow)
+	}
+
+	t.Setenv("EASYMATRIX_REACTION_COALESCE_WINDOW", "0")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.ReactionCoalesceWindow != 0 {
+		t.Fatalf("ReactionCoalesceWindow = %v, want 0", cfg.ReactionCoalesceWindow)
+	}
+}
+
+func TestLoadSMTPRequiresSenderAndDefaultsPort(t *testing.T) {
+	t.Setenv("EASYMATRIX_SMTP_HOST", "smtp.example.com")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected EASYMATRIX_SMTP_HOST without EASYMATRIX_SMTP_FROM to be rejected")
+	}
+
+	t.Setenv("EASYMATRIX_SMTP_FROM", "digest@example.com")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.SMTPPort != defaultSMTPPort {
+		t.Fatalf("SMTPPort = %d, want %d", cfg.SMTPPort, defaultSMTPPort)
+	}
+}
+
+func TestLoadReadsSessionIdentity(t *testing.T) {
+	t.Setenv("EASYMATRIX_DEVICE_NAME", " headless on host-x ")
+	t.Setenv("EASYMATRIX_USER_AGENT", "headless-matrix-client/0.3 on host-x")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.DeviceDisplayName != "headless on host-x" || cfg.UserAgent != "headless-matrix-client/0.3 on host-x" {
+		t.Fatalf("unexpected session identity: %q, %q", cfg.DeviceDisplayName, cfg.UserAgent)
+	}
+}
+
+func TestLoadParsesSessions(t *testing.T) {
+	t.Setenv("EASYMATRIX_SESSIONS", " work, personal ,")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(cfg.Sessions) != 2 || cfg.Sessions[0] != "work" || cfg.Sessions[1] != "personal" {
+		t.Fatalf("unexpected sessions: %q", cfg.Sessions)
+	}
+
+	for _, raw := range []string{"Work", "../x", "a,a"} {
+		t.Setenv("EASYMATRIX_SESSIONS", raw)
+		if _, err = Load(); err == nil {
+			t.Fatalf("expected EASYMATRIX_SESSIONS=%q to be rejected", raw)
+		}
+	}
+}
+
+func TestLoadRequiresManageSecretForConsent(t *testing.T) {
+	t.Setenv("EASYMATRIX_OAUTH_REQUIRE_CONSENT", "true")
+	t.Setenv("EASYMATRIX_MANAGE_SECRET", "")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected consent mode without a manage secret to be rejected")
+	}
+	t.Setenv("EASYMATRIX_MANAGE_SECRET", "super-secret")
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+}
+
+func TestLoadParsesReplayWebhookHosts(t *testing.T) {
+	t.Setenv("EASYMATRIX_REPLAY_WEBHOOK_HOSTS", " Hooks.Example.org, ,hooks.example.org,ci.internal")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(cfg.ReplayWebhookHosts) != 2 || cfg.ReplayWebhookHosts[0] != "hooks.example.org" || cfg.ReplayWebhookHosts[1] != "ci.internal" {
+		t.Fatalf("unexpected replay webhook hosts: %q", cfg.ReplayWebhookHosts)
+	}
+}
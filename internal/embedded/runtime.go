@@ -106,6 +106,10 @@ func (r *Runtime) Start(ctx context.Context) error {
 	if err := r.rt.Start(ctx); err != nil {
 		return err
 	}
+	if err := r.server.Start(); err != nil {
+		r.rt.Stop()
+		return err
+	}
 	r.started = true
 	return nil
 }
@@ -116,6 +120,7 @@ func (r *Runtime) Stop() {
 	if !r.started {
 		return
 	}
+	r.server.Stop()
 	r.rt.Stop()
 	r.started = false
 }
@@ -53,6 +53,10 @@ func NotImplemented(message string) *APIError {
 	return New(http.StatusNotImplemented, "NOT_IMPLEMENTED", message, nil)
 }
 
+func PayloadTooLarge(limitBytes int64) *APIError {
+	return New(http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "Request body too large", map[string]any{"limitBytes": limitBytes})
+}
+
 func Internal(err error) *APIError {
 	if err == nil {
 		return New(http.StatusInternalServerError, "INTERNAL_ERROR", "Internal error", nil)
@@ -0,0 +1,36 @@
+package gomuksruntime
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"maunium.net/go/mautrix/id"
+)
+
+// attachFollower loads the replicated account without starting sync or the
+// crypto machine. The primary owns the session; a follower only reads what
+// the primary already stored, and events in the database are decrypted.
+func attachFollower(ctx context.Context, cli *hicli.HiClient, userID id.UserID) error {
+	defer func() { cli.Initialized = true }()
+	if userID == "" {
+		return nil
+	}
+	account, err := cli.DB.Account.Get(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to read replicated account: %w", err)
+	} else if account == nil {
+		return nil
+	}
+	homeserverURL, err := url.Parse(account.HomeserverURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse replicated homeserver URL: %w", err)
+	}
+	cli.Account = account
+	cli.Client.UserID = account.UserID
+	cli.Client.DeviceID = account.DeviceID
+	cli.Client.AccessToken = account.AccessToken
+	cli.Client.HomeserverURL = homeserverURL
+	return nil
+}
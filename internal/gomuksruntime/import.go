@@ -0,0 +1,45 @@
+package gomuksruntime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ImportStagingDirName is where an account import is unpacked inside the
+// gomuks data dir. The live database can't be swapped underneath a running
+// client, so Start moves the staged files into place before opening it.
+const ImportStagingDirName = "import-staging"
+
+// DatabaseFileName is the gomuks database inside the data dir.
+const DatabaseFileName = "gomuks.db"
+
+// applyStagedImport replaces the data dir contents with a staged import.
+// Top-level entries present in the import overwrite their counterparts;
+// everything else is left alone.
+func applyStagedImport(dataDir string) error {
+	stagingDir := filepath.Join(dataDir, ImportStagingDirName)
+	entries, err := os.ReadDir(stagingDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read staged import: %w", err)
+	}
+	for _, entry := range entries {
+		target := filepath.Join(dataDir, entry.Name())
+		if entry.Name() == DatabaseFileName {
+			// Stale WAL files from the old database would be replayed
+			// against the imported one.
+			_ = os.Remove(target + "-wal")
+			_ = os.Remove(target + "-shm")
+		}
+		if err = os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to remove %s before import: %w", entry.Name(), err)
+		}
+		if err = os.Rename(filepath.Join(stagingDir, entry.Name()), target); err != nil {
+			return fmt.Errorf("failed to move imported %s into place: %w", entry.Name(), err)
+		}
+	}
+	return os.RemoveAll(stagingDir)
+}
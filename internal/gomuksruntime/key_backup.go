@@ -0,0 +1,330 @@
+package gomuksruntime
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/signatures"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrSessionNotVerified      = errors.New("this session is not verified")
+	ErrNoKeyBackup             = errors.New("no key backup exists on the server")
+	ErrKeyBackupKeyMismatch    = errors.New("the key backup key does not match the backup on the server")
+	ErrKeyBackupKeyUnavailable = errors.New("the key backup key is not stored locally, a recovery key is required")
+	ErrKeyBackupRestoreRunning = errors.New("a key backup restore is already running")
+)
+
+// KeyBackupStatus compares the backup this session uploads to with the
+// latest one on the server. Pending counts the local megolm sessions that
+// have not been uploaded to the active backup yet.
+type KeyBackupStatus struct {
+	Enabled       bool
+	Version       id.KeyBackupVersion
+	ServerVersion id.KeyBackupVersion
+	Algorithm     id.KeyBackupAlgorithm
+	Count         int
+	ETag          string
+	Trusted       bool
+	Pending       int
+	Restore       *KeyBackupRestore
+}
+
+// KeyBackupRestore is the progress of the last restore started through
+// StartKeyBackupRestore.
+type KeyBackupRestore struct {
+	RoomID           id.RoomID
+	Stage            string
+	Decrypted        int
+	DecryptionFailed int
+	ImportFailed     int
+	Saved            int
+	Total            int
+	Error            string
+	StartedAt        time.Time
+	FinishedAt       time.Time
+}
+
+func (r *Runtime) keyBackupClient() (*hicli.HiClient, error) {
+	cli := r.Client()
+	if cli == nil || cli.Account == nil || cli.Crypto == nil {
+		return nil, errors.New("no logged-in session")
+	}
+	return cli, nil
+}
+
+func keyBackupPublicKey(key *backup.MegolmBackupKey) id.Ed25519 {
+	return id.Ed25519(base64.RawStdEncoding.EncodeToString(key.PublicKey().Bytes()))
+}
+
+// keyBackupKeyMatches reports whether key decrypts the backup described by
+// authData. A matching public key is what makes a backup trusted when the
+// key came from the user or secret storage.
+func keyBackupKeyMatches(key *backup.MegolmBackupKey, authData backup.MegolmAuthData) bool {
+	return key != nil && authData.PublicKey != "" && keyBackupPublicKey(key) == authData.PublicKey
+}
+
+// latestKeyBackup returns nil without an error when the server has no backup.
+func latestKeyBackup(ctx context.Context, cli *hicli.HiClient) (*mautrix.RespRoomKeysVersion[backup.MegolmAuthData], error) {
+	version, err := cli.Client.GetKeyBackupLatestVersion(ctx)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get latest key backup version: %w", err)
+	}
+	return version, nil
+}
+
+// KeyBackupStatus reports the local and server-side key backup state.
+func (r *Runtime) KeyBackupStatus(ctx context.Context) (KeyBackupStatus, error) {
+	cli, err := r.keyBackupClient()
+	if err != nil {
+		return KeyBackupStatus{}, err
+	}
+	status := KeyBackupStatus{
+		Enabled: cli.KeyBackupVersion != "" && cli.KeyBackupKey != nil,
+		Version: cli.KeyBackupVersion,
+		Restore: r.keyBackupRestoreSnapshot(),
+	}
+	latest, err := latestKeyBackup(ctx, cli)
+	if err != nil {
+		return KeyBackupStatus{}, err
+	}
+	if latest != nil {
+		status.ServerVersion = latest.Version
+		status.Algorithm = latest.Algorithm
+		status.Count = latest.Count
+		status.ETag = latest.ETag
+		status.Trusted = keyBackupKeyMatches(cli.KeyBackupKey, latest.AuthData)
+	}
+	if status.Enabled {
+		sessions, err := cli.CryptoStore.GetGroupSessionsWithoutKeyBackupVersion(ctx, cli.KeyBackupVersion).AsList()
+		if err != nil {
+			return KeyBackupStatus{}, fmt.Errorf("failed to count sessions missing from the backup: %w", err)
+		}
+		status.Pending = len(sessions)
+	}
+	return status, nil
+}
+
+// secretStorageKey unlocks secret storage with a recovery key or, like the
+// verify command, with the passphrase it was derived from.
+func secretStorageKey(ctx context.Context, cli *hicli.HiClient, code string) (*ssss.Key, error) {
+	keyID, keyData, err := cli.Crypto.SSSS.GetDefaultKeyData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default secret storage key: %w", err)
+	}
+	key, err := keyData.VerifyRecoveryKey(keyID, code)
+	if errors.Is(err, ssss.ErrInvalidRecoveryKey) && keyData.Passphrase != nil {
+		key, err = keyData.VerifyPassphrase(keyID, code)
+	}
+	return key, err
+}
+
+// loadKeyBackupKey reads the backup key from secret storage when a recovery
+// key is given, and otherwise uses the one this session already holds.
+func loadKeyBackupKey(ctx context.Context, cli *hicli.HiClient, recoveryKey string) (*backup.MegolmBackupKey, error) {
+	if recoveryKey == "" {
+		if cli.KeyBackupKey != nil {
+			return cli.KeyBackupKey, nil
+		}
+		secret, err := cli.CryptoStore.GetSecret(ctx, id.SecretMegolmBackupV1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored key backup key: %w", err)
+		} else if secret == "" {
+			return nil, ErrKeyBackupKeyUnavailable
+		}
+		data, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode stored key backup key: %w", err)
+		}
+		return backup.MegolmBackupKeyFromBytes(data)
+	}
+	ssssKey, err := secretStorageKey(ctx, cli, recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := cli.Crypto.SSSS.GetDecryptedAccountData(ctx, event.AccountDataMegolmBackupKey, ssssKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key backup key from secret storage: %w", err)
+	}
+	return backup.MegolmBackupKeyFromBytes(data)
+}
+
+// useKeyBackup stores key locally and makes version the backup new megolm
+// sessions are uploaded to. Waking the request queue uploads the sessions
+// received before the backup was enabled.
+func useKeyBackup(ctx context.Context, cli *hicli.HiClient, key *backup.MegolmBackupKey, version id.KeyBackupVersion) error {
+	err := cli.CryptoStore.PutSecret(ctx, id.SecretMegolmBackupV1, base64.StdEncoding.EncodeToString(key.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to store key backup key: %w", err)
+	}
+	cli.KeyBackupKey = key
+	cli.KeyBackupVersion = version
+	cli.WakeupRequestQueue()
+	return nil
+}
+
+// EnableKeyBackup starts uploading to the latest server-side backup after
+// checking that the key from recoveryKey, or the stored one, belongs to it.
+func (r *Runtime) EnableKeyBackup(ctx context.Context, recoveryKey string) (KeyBackupStatus, error) {
+	cli, err := r.keyBackupClient()
+	if err != nil {
+		return KeyBackupStatus{}, err
+	}
+	if err = r.enableKeyBackup(ctx, cli, recoveryKey); err != nil {
+		return KeyBackupStatus{}, err
+	}
+	return r.KeyBackupStatus(ctx)
+}
+
+func (r *Runtime) enableKeyBackup(ctx context.Context, cli *hicli.HiClient, recoveryKey string) error {
+	key, err := loadKeyBackupKey(ctx, cli, recoveryKey)
+	if err != nil {
+		return err
+	}
+	latest, err := latestKeyBackup(ctx, cli)
+	if err != nil {
+		return err
+	} else if latest == nil {
+		return ErrNoKeyBackup
+	} else if latest.Algorithm != id.KeyBackupAlgorithmMegolmBackupV1 || !keyBackupKeyMatches(key, latest.AuthData) {
+		return ErrKeyBackupKeyMismatch
+	}
+	return useKeyBackup(ctx, cli, key, latest.Version)
+}
+
+// CreateKeyBackup creates a new server-side backup version with a fresh key
+// and switches to it. The key is saved in secret storage under recoveryKey so
+// other sessions can restore from the new backup.
+func (r *Runtime) CreateKeyBackup(ctx context.Context, recoveryKey string) (KeyBackupStatus, error) {
+	cli, err := r.keyBackupClient()
+	if err != nil {
+		return KeyBackupStatus{}, err
+	}
+	if !cli.Verified {
+		return KeyBackupStatus{}, ErrSessionNotVerified
+	}
+	ssssKey, err := secretStorageKey(ctx, cli, recoveryKey)
+	if err != nil {
+		return KeyBackupStatus{}, err
+	}
+	key, err := backup.NewMegolmBackupKey()
+	if err != nil {
+		return KeyBackupStatus{}, fmt.Errorf("failed to generate key backup key: %w", err)
+	}
+	authData, err := signKeyBackupAuthData(cli, key)
+	if err != nil {
+		return KeyBackupStatus{}, err
+	}
+	created, err := cli.Client.CreateKeyBackupVersion(ctx, &mautrix.ReqRoomKeysVersionCreate[backup.MegolmAuthData]{
+		Algorithm: id.KeyBackupAlgorithmMegolmBackupV1,
+		AuthData:  authData,
+	})
+	if err != nil {
+		return KeyBackupStatus{}, fmt.Errorf("failed to create key backup version: %w", err)
+	}
+	err = cli.Crypto.SSSS.SetEncryptedAccountData(ctx, event.AccountDataMegolmBackupKey, key.Bytes(), ssssKey)
+	if err != nil {
+		return KeyBackupStatus{}, fmt.Errorf("failed to save key backup key in secret storage: %w", err)
+	}
+	if err = useKeyBackup(ctx, cli, key, created.Version); err != nil {
+		return KeyBackupStatus{}, err
+	}
+	return r.KeyBackupStatus(ctx)
+}
+
+// signKeyBackupAuthData signs the backup with this device and, when the
+// cross-signing keys are loaded, the master key, so other clients trust it
+// without the private key.
+func signKeyBackupAuthData(cli *hicli.HiClient, key *backup.MegolmBackupKey) (backup.MegolmAuthData, error) {
+	mach := cli.Crypto
+	authData := backup.MegolmAuthData{PublicKey: keyBackupPublicKey(key)}
+	deviceSig, err := mach.GetAccount().SignJSON(authData)
+	if err != nil {
+		return authData, fmt.Errorf("failed to sign key backup with device key: %w", err)
+	}
+	authData.Signatures = signatures.NewSingleSignature(mach.Client.UserID, id.KeyAlgorithmEd25519, mach.Client.DeviceID.String(), deviceSig)
+	if mach.CrossSigningKeys != nil && mach.CrossSigningKeys.MasterKey != nil {
+		masterKey := mach.CrossSigningKeys.MasterKey
+		masterSig, err := masterKey.SignJSON(backup.MegolmAuthData{PublicKey: authData.PublicKey})
+		if err != nil {
+			return authData, fmt.Errorf("failed to sign key backup with master key: %w", err)
+		}
+		authData.Signatures[mach.Client.UserID][id.NewKeyID(id.KeyAlgorithmEd25519, masterKey.PublicKey().String())] = masterSig
+	}
+	return authData, nil
+}
+
+// StartKeyBackupRestore imports the keys in the backup, or only those of
+// roomID, in the background. The backup is enabled first when needed, which
+// requires recoveryKey unless the key is stored locally.
+func (r *Runtime) StartKeyBackupRestore(ctx context.Context, recoveryKey string, roomID id.RoomID) (KeyBackupRestore, error) {
+	cli, err := r.keyBackupClient()
+	if err != nil {
+		return KeyBackupRestore{}, err
+	}
+	r.keyBackupMu.Lock()
+	running := r.keyBackupRestore != nil && r.keyBackupRestore.FinishedAt.IsZero()
+	r.keyBackupMu.Unlock()
+	if running {
+		return KeyBackupRestore{}, ErrKeyBackupRestoreRunning
+	}
+	if recoveryKey != "" || cli.KeyBackupVersion == "" || cli.KeyBackupKey == nil {
+		if err = r.enableKeyBackup(ctx, cli, recoveryKey); err != nil {
+			return KeyBackupRestore{}, err
+		}
+	}
+
+	r.keyBackupMu.Lock()
+	defer r.keyBackupMu.Unlock()
+	if r.keyBackupRestore != nil && r.keyBackupRestore.FinishedAt.IsZero() {
+		return KeyBackupRestore{}, ErrKeyBackupRestoreRunning
+	}
+	restore := &KeyBackupRestore{RoomID: roomID, Stage: "starting", StartedAt: time.Now()}
+	r.keyBackupRestore = restore
+	go r.runKeyBackupRestore(cli, restore)
+	return *restore, nil
+}
+
+func (r *Runtime) runKeyBackupRestore(cli *hicli.HiClient, restore *KeyBackupRestore) {
+	err := cli.RestoreKeyBackup(context.Background(), restore.RoomID, func(progress hicli.KeyBackupRestoreProgress) {
+		r.keyBackupMu.Lock()
+		defer r.keyBackupMu.Unlock()
+		restore.Stage = progress.Stage
+		restore.Decrypted = progress.Decrypted
+		restore.DecryptionFailed = progress.DecryptionFailed
+		restore.ImportFailed = progress.ImportFailed
+		restore.Saved = progress.Saved
+		restore.Total = progress.Total
+	})
+	if err != nil {
+		log.Printf("key backup restore failed: %v", err)
+	}
+	r.keyBackupMu.Lock()
+	defer r.keyBackupMu.Unlock()
+	if err != nil {
+		restore.Error = err.Error()
+	}
+	restore.FinishedAt = time.Now()
+}
+
+func (r *Runtime) keyBackupRestoreSnapshot() *KeyBackupRestore {
+	r.keyBackupMu.Lock()
+	defer r.keyBackupMu.Unlock()
+	if r.keyBackupRestore == nil {
+		return nil
+	}
+	restore := *r.keyBackupRestore
+	return &restore
+}
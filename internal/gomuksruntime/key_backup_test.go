@@ -0,0 +1,25 @@
+package gomuksruntime
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/crypto/backup"
+)
+
+func TestKeyBackupKeyMatchesPublicKey(t *testing.T) {
+	key, err := backup.NewMegolmBackupKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	other, err := backup.NewMegolmBackupKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	authData := backup.MegolmAuthData{PublicKey: keyBackupPublicKey(key)}
+	if !keyBackupKeyMatches(key, authData) {
+		t.Fatalf("expected key to match its own backup")
+	}
+	if keyBackupKeyMatches(other, authData) || keyBackupKeyMatches(nil, authData) || keyBackupKeyMatches(key, backup.MegolmAuthData{}) {
+		t.Fatalf("expected mismatching keys to be rejected")
+	}
+}
@@ -0,0 +1,103 @@
+package gomuksruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"maunium.net/go/mautrix"
+)
+
+// Logout signs the session out of the homeserver and deletes its database,
+// which also holds the E2EE keys, along with the media cache. A fresh client
+// is started on the same event buffer, so subscribers stay attached and a new
+// login can follow without a restart. With wipeState everything else in the
+// data dir goes too, except the state of additional sessions.
+func (r *Runtime) Logout(ctx context.Context, wipeState bool) error {
+	if r.cfg.PrimaryURL != "" {
+		return fmt.Errorf("followers cannot log out; log out on the primary instead")
+	}
+	r.logoutMu.Lock()
+	defer r.logoutMu.Unlock()
+	gmx := r.gmx
+	if gmx == nil || gmx.Client == nil {
+		return fmt.Errorf("gomuks runtime is not initialized")
+	}
+	cli := gmx.Client
+	loggedIn := cli.IsLoggedIn()
+	cli.Stop()
+	if loggedIn {
+		if _, err := cli.Client.Logout(ctx); err != nil && !errors.Is(err, mautrix.MUnknownToken) {
+			// Nothing was deleted yet, so bring the old session back up.
+			if restartErr := startClientWithoutExit(gmx, r.cfg, r.logoutCommand); restartErr != nil {
+				return fmt.Errorf("failed to log out: %w (restarting the client also failed: %v)", err, restartErr)
+			}
+			return fmt.Errorf("failed to log out: %w", err)
+		}
+	}
+
+	if err := os.RemoveAll(gmx.CacheDir); err != nil {
+		return fmt.Errorf("failed to remove cache dir: %w", err)
+	}
+	var err error
+	if wipeState {
+		err = clearDir(gmx.DataDir, SessionsDirName)
+	} else {
+		err = removeDatabase(gmx.DataDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = withConfiguredGomuksRoot(r.cfg.StateDir, func() error {
+		gmx.InitDirectories()
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err = startClientWithoutExit(gmx, r.cfg, r.logoutCommand); err != nil {
+		return fmt.Errorf("failed to restart client after logout: %w", err)
+	}
+	gmx.Client.EventHandler(gmx.Client.State())
+	gmx.Client.EventHandler(gmx.Client.SyncStatus.Load())
+	gmx.Log.Info().Bool("wipe_state", wipeState).Msg("logged out and restarted client")
+	return nil
+}
+
+// logoutCommand backs the hicli logout command, so it goes through the same
+// path as the manage endpoint instead of gomuks' own restart logic.
+func (r *Runtime) logoutCommand(ctx context.Context) error {
+	return r.Logout(ctx, false)
+}
+
+func removeDatabase(dataDir string) error {
+	path := filepath.Join(dataDir, DatabaseFileName)
+	for _, file := range []string{path, path + "-wal", path + "-shm"} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", filepath.Base(file), err)
+		}
+	}
+	return nil
+}
+
+// clearDir removes the contents of dir except the named top-level entries.
+func clearDir(dir string, keep ...string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if slices.Contains(keep, entry.Name()) {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package gomuksruntime
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClearDirKeepsNamedEntries(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{DatabaseFileName, "chat-metadata.json", filepath.Join("oauth", "state.json"), filepath.Join(SessionsDirName, "work", "data", DatabaseFileName)} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := clearDir(dir, SessionsDirName); err != nil {
+		t.Fatalf("clearDir returned error: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != SessionsDirName {
+		t.Fatalf("expected only the sessions dir to remain, got %v", entries)
+	}
+	if err = clearDir(filepath.Join(dir, "missing")); err != nil {
+		t.Fatalf("expected a missing dir to be a no-op, got %v", err)
+	}
+}
+
+func TestRemoveDatabaseLeavesOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{DatabaseFileName, DatabaseFileName + "-wal", "digest.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := removeDatabase(dir); err != nil {
+		t.Fatalf("removeDatabase returned error: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "digest.json" {
+		t.Fatalf("expected only digest.json to remain, got %v", entries)
+	}
+}
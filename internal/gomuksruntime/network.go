@@ -0,0 +1,91 @@
+package gomuksruntime
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/config"
+)
+
+const defaultOutboundTimeout = 60 * time.Second
+
+// configureTransport applies the outbound proxy, extra root CAs and dial
+// timeout from cfg. Without an explicit proxy, HTTP(S)_PROXY and NO_PROXY from
+// the environment are honored.
+func configureTransport(transport *http.Transport, cfg config.Config) error {
+	transport.Proxy = http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if cfg.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = cfg.DialTimeout
+	}
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("CA file %s does not contain any PEM certificates", path)
+	}
+	return pool, nil
+}
+
+func newOutboundHTTPClient(cfg config.Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if err := configureTransport(transport, cfg); err != nil {
+		return nil, err
+	}
+	timeout := defaultOutboundTimeout
+	if cfg.HTTPTimeout > 0 {
+		timeout = cfg.HTTPTimeout
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	if cfg.UserAgent != "" {
+		client.Transport = &userAgentTransport{base: transport, userAgent: cfg.UserAgent}
+	}
+	return client, nil
+}
+
+// userAgentTransport labels requests that do not already carry a
+// User-Agent, so the Beeper API sees the same identity as the homeserver.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
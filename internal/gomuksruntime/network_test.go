@@ -0,0 +1,62 @@
+package gomuksruntime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/config"
+)
+
+func TestConfigureTransportUsesExplicitProxy(t *testing.T) {
+	transport := &http.Transport{}
+	if err := configureTransport(transport, config.Config{ProxyURL: "http://proxy.internal:3128"}); err != nil {
+		t.Fatalf("configureTransport returned error: %v", err)
+	}
+	req := &http.Request{URL: &url.URL{Scheme: "https", Host: "matrix.beeper.com"}}
+	proxyURL, err := transport.Proxy(req)
+	if err != nil || proxyURL == nil || proxyURL.Host != "proxy.internal:3128" {
+		t.Fatalf("unexpected proxy: %v, %v", proxyURL, err)
+	}
+}
+
+func TestConfigureTransportRejectsInvalidCAFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+	if err := configureTransport(&http.Transport{}, config.Config{CAFile: path}); err == nil {
+		t.Fatal("expected CA file without certificates to be rejected")
+	}
+}
+
+func TestOutboundHTTPClientSetsConfiguredUserAgent(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("User-Agent"))
+	}))
+	defer srv.Close()
+
+	client, err := newOutboundHTTPClient(config.Config{UserAgent: "headless-matrix-client/0.3 on host-x"})
+	if err != nil {
+		t.Fatalf("newOutboundHTTPClient returned error: %v", err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("User-Agent", "explicit")
+	if resp, err = client.Do(req); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(got) != 2 || got[0] != "headless-matrix-client/0.3 on host-x" || got[1] != "explicit" {
+		t.Fatalf("unexpected User-Agent headers: %q", got)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"go.mau.fi/gomuks/pkg/gomuks"
@@ -24,9 +25,18 @@ import (
 )
 
 type Runtime struct {
-	cfg     config.Config
-	dataDir string
-	gmx     *gomuks.Gomuks
+	cfg        config.Config
+	dataDir    string
+	gmx        *gomuks.Gomuks
+	httpClient *http.Client
+	logoutMu   sync.Mutex
+
+	verifyMu             sync.Mutex
+	verifier             *verifier
+	verificationListener func(Verification)
+
+	keyBackupMu      sync.Mutex
+	keyBackupRestore *KeyBackupRestore
 }
 
 func New(cfg config.Config) (*Runtime, error) {
@@ -41,7 +51,30 @@ func New(cfg config.Config) (*Runtime, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Runtime{cfg: cfg, dataDir: dataDir}, nil
+	httpClient, err := newOutboundHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Runtime{cfg: cfg, dataDir: dataDir, httpClient: httpClient}, nil
+}
+
+// SessionsDirName holds the state of additional sessions inside the primary
+// session's data dir.
+const SessionsDirName = "sessions"
+
+// NewSession creates the runtime of an additional named session. Its gomuks
+// root is a subdirectory of this runtime's data dir, so each session keeps
+// its own database, keys and login. The MATRIX_* bootstrap credentials only
+// apply to the primary session.
+func (r *Runtime) NewSession(name string) (*Runtime, error) {
+	cfg := r.cfg
+	cfg.StateDir = filepath.Join(r.dataDir, SessionsDirName, name)
+	cfg.MatrixLoginToken = ""
+	cfg.MatrixUsername = ""
+	cfg.MatrixPassword = ""
+	cfg.MatrixRecoveryKey = ""
+	cfg.Sessions = nil
+	return New(cfg)
 }
 
 func withConfiguredGomuksRoot(root string, fn func() error) error {
@@ -80,10 +113,18 @@ func resolveDataDir(root string) (string, error) {
 	return dataDir, nil
 }
 
-func startClientWithoutExit(gmx *gomuks.Gomuks) error {
+func startClientWithoutExit(gmx *gomuks.Gomuks, cfg config.Config, logout func(context.Context) error) error {
 	hicli.HTMLSanitizerImgSrcTemplate = "_gomuks/media/%s/%s?encrypted=false"
+	if cfg.DeviceDisplayName != "" {
+		hicli.InitialDeviceDisplayName = cfg.DeviceDisplayName
+	}
+	poolConfig := gmx.GetDBConfig()
+	if cfg.PrimaryURL != "" {
+		// The replica belongs to whatever keeps it in sync with the primary.
+		poolConfig.URI += "&_query_only=1"
+	}
 	rawDB, err := dbutil.NewFromConfig("gomuks", dbutil.Config{
-		PoolConfig: gmx.GetDBConfig(),
+		PoolConfig: poolConfig,
 	}, dbutil.ZeroLogger(gmx.Log.With().Str("component", "hicli").Str("db_section", "main").Logger()))
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
@@ -97,13 +138,22 @@ func startClientWithoutExit(gmx *gomuks.Gomuks) error {
 		[]byte("meow"),
 		gmx.HandleEvent,
 	)
-	gmx.Client.LogoutFunc = gmx.Logout
+	gmx.Client.LogoutFunc = logout
 
 	httpClient := gmx.Client.Client.Client
 	if runtime.GOOS == "js" {
 		gmx.Client.Client.UserAgent = ""
 		httpClient.Transport = nil
 	} else if transport, ok := httpClient.Transport.(*http.Transport); ok {
+		if cfg.UserAgent != "" {
+			gmx.Client.Client.UserAgent = cfg.UserAgent
+		}
+		if err := configureTransport(transport, cfg); err != nil {
+			return err
+		}
+		if cfg.HTTPTimeout > 0 {
+			httpClient.Timeout = cfg.HTTPTimeout
+		}
 		transport.ForceAttemptHTTP2 = false
 		if !gmx.Config.Matrix.DisableHTTP2 {
 			h2, err := http2.ConfigureTransports(transport)
@@ -118,6 +168,9 @@ func startClientWithoutExit(gmx *gomuks.Gomuks) error {
 	if err != nil {
 		return fmt.Errorf("failed to get first user ID: %w", err)
 	}
+	if cfg.PrimaryURL != "" {
+		return attachFollower(clientCtx, gmx.Client, userID)
+	}
 	if err := gmx.Client.Start(clientCtx, userID, nil); err != nil {
 		return fmt.Errorf("failed to start client: %w", err)
 	}
@@ -142,17 +195,25 @@ func (r *Runtime) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to resolve gomuks data dir: %w", err)
 	}
 	r.dataDir = dataDir
+	if r.cfg.PrimaryURL == "" {
+		if err := applyStagedImport(dataDir); err != nil {
+			return err
+		}
+	}
 
 	if err := gmx.LoadConfig(); err != nil {
 		return fmt.Errorf("failed to load gomuks config: %w", err)
 	}
 	gmx.SetupLog()
-	if err := startClientWithoutExit(gmx); err != nil {
+	if err := startClientWithoutExit(gmx, r.cfg, r.logoutCommand); err != nil {
 		return err
 	}
 	r.gmx = gmx
 	gmx.Log.Info().Str("state_dir", r.cfg.StateDir).Msg("gomuks runtime started")
-	if r.hasBootstrapEnv() {
+	if r.cfg.DeviceDisplayName != "" && gmx.Client.IsLoggedIn() && r.cfg.PrimaryURL == "" {
+		go r.syncDeviceDisplayName(ctx, gmx.Client)
+	}
+	if r.hasBootstrapEnv() && r.cfg.PrimaryURL == "" {
 		go func() {
 			if err := r.bootstrapSessionFromEnv(ctx, gmx); err != nil {
 				log.Printf("gomuks bootstrap failed; continuing without a ready session: %v", err)
@@ -164,6 +225,23 @@ func (r *Runtime) Start(ctx context.Context) error {
 	return nil
 }
 
+// syncDeviceDisplayName renames a session that was created before the device
+// name was configured. New logins pick the name up from hicli directly.
+func (r *Runtime) syncDeviceDisplayName(ctx context.Context, cli *hicli.HiClient) {
+	deviceID := cli.Client.DeviceID
+	info, err := cli.Client.GetDeviceInfo(ctx, deviceID)
+	if err != nil {
+		log.Printf("failed to read device info: %v", err)
+		return
+	}
+	if info.DisplayName == r.cfg.DeviceDisplayName {
+		return
+	}
+	if err = cli.Client.SetDeviceInfo(ctx, deviceID, &mautrix.ReqDeviceInfo{DisplayName: r.cfg.DeviceDisplayName}); err != nil {
+		log.Printf("failed to update device display name: %v", err)
+	}
+}
+
 func (r *Runtime) Stop() {
 	if r.gmx != nil {
 		r.gmx.DirectStop()
@@ -178,6 +256,12 @@ func (r *Runtime) Client() *hicli.HiClient {
 	return r.gmx.Client
 }
 
+// HTTPClient returns the client used for non-Matrix outbound requests, such as
+// the Beeper login API. It shares proxy and CA settings with the Matrix client.
+func (r *Runtime) HTTPClient() *http.Client {
+	return r.httpClient
+}
+
 func (r *Runtime) EventBuffer() *gomuks.EventBuffer {
 	if r.gmx == nil {
 		return nil
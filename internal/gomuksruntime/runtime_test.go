@@ -2,6 +2,7 @@ package gomuksruntime
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -66,3 +67,58 @@ func TestStartKeepsRuntimeAvailableWhenBootstrapFails(t *testing.T) {
 
 	cancel()
 }
+
+func TestApplyStagedImportReplacesDatabase(t *testing.T) {
+	dataDir := t.TempDir()
+	for name, content := range map[string]string{
+		"gomuks.db":     "old",
+		"gomuks.db-wal": "stale",
+		"keep.json":     "keep",
+	} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	staging := filepath.Join(dataDir, ImportStagingDirName)
+	if err := os.MkdirAll(staging, 0o700); err != nil {
+		t.Fatalf("failed to create staging: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(staging, "gomuks.db"), []byte("new"), 0o600); err != nil {
+		t.Fatalf("failed to stage database: %v", err)
+	}
+
+	if err := applyStagedImport(dataDir); err != nil {
+		t.Fatalf("applyStagedImport failed: %v", err)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(dataDir, "gomuks.db")); string(raw) != "new" {
+		t.Fatalf("database was not replaced: %q", raw)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "gomuks.db-wal")); !os.IsNotExist(err) {
+		t.Fatalf("stale WAL file was kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "keep.json")); err != nil {
+		t.Fatalf("unrelated state was removed: %v", err)
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Fatalf("staging dir was not removed: %v", err)
+	}
+}
+
+func TestNewSessionUsesSubdirectoryWithoutBootstrap(t *testing.T) {
+	root := t.TempDir()
+	rt, err := New(config.Config{StateDir: root, MatrixRecoveryKey: "key", Sessions: []string{"work"}})
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	session, err := rt.NewSession("work")
+	if err != nil {
+		t.Fatalf("failed to create session runtime: %v", err)
+	}
+	want := filepath.Join(root, "data", "sessions", "work", "data")
+	if got := session.StateDir(); got != want {
+		t.Fatalf("unexpected session state dir: got %q want %q", got, want)
+	}
+	if session.hasBootstrapEnv() || len(session.cfg.Sessions) != 0 {
+		t.Fatal("expected bootstrap credentials and nested sessions to be dropped")
+	}
+}
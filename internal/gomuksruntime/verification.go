@@ -0,0 +1,538 @@
+package gomuksruntime
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	VerificationStateRequested = "requested"
+	VerificationStateReady     = "ready"
+	VerificationStateSAS       = "sas"
+	VerificationStateConfirmed = "confirmed"
+	VerificationStateDone      = "done"
+	VerificationStateCancelled = "cancelled"
+)
+
+const (
+	verificationPollTimeout   = 30 * time.Second
+	verificationIdleInterval  = 5 * time.Second
+	verificationRetryInterval = 10 * time.Second
+	verificationRetention     = 10 * time.Minute
+	verificationSecretTimeout = time.Minute
+)
+
+// toDeviceOnlyFilter keeps the verification poll from pulling rooms, which
+// hicli fetches itself once the session is verified.
+const toDeviceOnlyFilter = `{"room":{"rooms":[]},"presence":{"types":[]},"account_data":{"types":[]}}`
+
+// Verification is a snapshot of one interactive verification. Emojis and
+// Decimals are filled in once both sides have exchanged SAS keys.
+type Verification struct {
+	TransactionID string
+	UserID        id.UserID
+	DeviceID      id.DeviceID
+	Incoming      bool
+	State         string
+	Emojis        []SASEmoji
+	Decimals      []int
+	CancelCode    string
+	CancelReason  string
+	// Error is set when verifying this session succeeded but fetching the
+	// cross-signing secrets from the other device did not.
+	Error     string
+	UpdatedAt time.Time
+}
+
+type SASEmoji struct {
+	Emoji       string
+	Description string
+}
+
+// verifier runs SAS verification for one hicli client. hicli has no
+// verification support of its own, so to-device events reach the mautrix
+// helper either through hicli's sync (ToDeviceInSync) or, while the session
+// is unverified and hicli does not sync at all, through a to-device-only poll.
+type verifier struct {
+	rt     *Runtime
+	cli    *hicli.HiClient
+	syncer *mautrix.DefaultSyncer
+	helper *verificationhelper.VerificationHelper
+	since  string
+
+	mu            sync.Mutex
+	verifications map[id.VerificationTransactionID]*Verification
+}
+
+// OnVerificationUpdate registers the function that receives every change to
+// a verification, including incoming requests.
+func (r *Runtime) OnVerificationUpdate(fn func(Verification)) {
+	r.verifyMu.Lock()
+	defer r.verifyMu.Unlock()
+	r.verificationListener = fn
+}
+
+func (r *Runtime) notifyVerification(v Verification) {
+	r.verifyMu.Lock()
+	listener := r.verificationListener
+	r.verifyMu.Unlock()
+	if listener != nil {
+		listener(v)
+	}
+}
+
+// currentVerifier returns the verifier of the current client, creating it on
+// first use and again after a logout replaced the client.
+func (r *Runtime) currentVerifier(ctx context.Context) (*verifier, error) {
+	if r.cfg.PrimaryURL != "" {
+		return nil, fmt.Errorf("followers cannot verify; verify on the primary instead")
+	}
+	cli := r.Client()
+	if cli == nil || cli.Account == nil || cli.Client.HomeserverURL == nil {
+		return nil, fmt.Errorf("a logged-in Matrix session is required")
+	}
+	r.verifyMu.Lock()
+	defer r.verifyMu.Unlock()
+	if r.verifier != nil && r.verifier.cli == cli {
+		return r.verifier, nil
+	}
+	v, err := newVerifier(ctx, r, cli)
+	if err != nil {
+		return nil, err
+	}
+	r.verifier = v
+	return v, nil
+}
+
+func newVerifier(ctx context.Context, r *Runtime, cli *hicli.HiClient) (*verifier, error) {
+	// The helper insists on an extensible syncer, which hicli's is not, so it
+	// gets a client of its own that shares hicli's credentials and transport.
+	helperClient, err := mautrix.NewClient(cli.Client.HomeserverURL.String(), cli.Account.UserID, cli.Account.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create verification client: %w", err)
+	}
+	helperClient.DeviceID = cli.Account.DeviceID
+	helperClient.Client = cli.Client.Client
+	helperClient.Log = cli.Client.Log
+	helperClient.Crypto = toDeviceOnlyCrypto{}
+	syncer := mautrix.NewDefaultSyncer()
+	helperClient.Syncer = syncer
+
+	v := &verifier{
+		rt:            r,
+		cli:           cli,
+		syncer:        syncer,
+		verifications: make(map[id.VerificationTransactionID]*Verification),
+	}
+	v.helper = verificationhelper.NewVerificationHelper(helperClient, cli.Crypto, nil, v, false, false, true)
+	if err = v.helper.Init(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize verification: %w", err)
+	}
+	cli.ToDeviceInSync.Store(true)
+	return v, nil
+}
+
+// RunVerification keeps verification events flowing until ctx is done. While
+// the session is unverified hicli does not sync, so this polls to-device
+// events itself; afterwards it only makes sure hicli forwards them.
+func (r *Runtime) RunVerification(ctx context.Context) {
+	if r.cfg.PrimaryURL != "" {
+		return
+	}
+	for ctx.Err() == nil {
+		wait := verificationIdleInterval
+		if cli := r.Client(); cli != nil && cli.Account != nil {
+			v, err := r.currentVerifier(ctx)
+			if err != nil {
+				log.Printf("verification unavailable: %v", err)
+				wait = verificationRetryInterval
+			} else if !cli.Verified && !cli.IsSyncing() {
+				if err = v.poll(ctx); err == nil {
+					continue
+				} else if ctx.Err() == nil {
+					log.Printf("verification poll failed: %v", err)
+					wait = verificationRetryInterval
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
+
+// HandleToDevice passes to-device events from hicli's sync to the verifier.
+// hicli has already decrypted them.
+func (r *Runtime) HandleToDevice(ctx context.Context, events []*jsoncmd.SyncToDevice) {
+	if len(events) == 0 {
+		return
+	}
+	r.verifyMu.Lock()
+	v := r.verifier
+	r.verifyMu.Unlock()
+	if v == nil || v.cli != r.Client() {
+		return
+	}
+	for _, td := range events {
+		v.handleToDevice(ctx, &event.Event{Sender: td.Sender, Type: td.Type, Content: event.Content{VeryRaw: td.Content}})
+	}
+}
+
+func (v *verifier) poll(ctx context.Context) error {
+	resp, err := v.cli.Client.FullSyncRequest(ctx, mautrix.ReqSync{
+		Timeout:     int(verificationPollTimeout / time.Millisecond),
+		Since:       v.since,
+		FilterID:    toDeviceOnlyFilter,
+		SetPresence: event.PresenceOffline,
+	})
+	if err != nil {
+		return err
+	}
+	v.since = resp.NextBatch
+	v.cli.Crypto.HandleOTKCounts(ctx, &resp.DeviceOTKCount)
+	for _, evt := range resp.ToDevice.Events {
+		v.handleToDevice(ctx, evt)
+	}
+	v.cli.Crypto.MarkOlmHashSavePoint(ctx)
+	return nil
+}
+
+func (v *verifier) handleToDevice(ctx context.Context, evt *event.Event) {
+	evt.Type.Class = event.ToDeviceEventType
+	if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return
+	}
+	if _, ok := evt.Content.Parsed.(*event.EncryptedEventContent); ok {
+		// Olm messages carry room keys and the secrets requested after a
+		// self-verification, so the crypto machine has to see all of them.
+		decrypted := v.cli.Crypto.HandleEncryptedEvent(ctx, evt)
+		if decrypted == nil {
+			return
+		}
+		evt = &event.Event{Sender: decrypted.Sender, Type: decrypted.Type, Content: decrypted.Content}
+		evt.Type.Class = event.ToDeviceEventType
+	}
+	v.syncer.Dispatch(ctx, evt)
+}
+
+func (v *verifier) update(txnID id.VerificationTransactionID, fn func(*Verification)) {
+	v.mu.Lock()
+	entry, ok := v.verifications[txnID]
+	if !ok {
+		entry = &Verification{TransactionID: string(txnID)}
+		v.verifications[txnID] = entry
+	}
+	fn(entry)
+	entry.UpdatedAt = time.Now()
+	snapshot := entry.clone()
+	pruneVerifications(v.verifications, entry.UpdatedAt)
+	v.mu.Unlock()
+	v.rt.notifyVerification(snapshot)
+}
+
+func (v Verification) clone() Verification {
+	v.Emojis = append([]SASEmoji(nil), v.Emojis...)
+	v.Decimals = append([]int(nil), v.Decimals...)
+	return v
+}
+
+func (v Verification) finished() bool {
+	return v.State == VerificationStateDone || v.State == VerificationStateCancelled
+}
+
+// pruneVerifications drops finished verifications once clients have had
+// time to see how they ended.
+func pruneVerifications(verifications map[id.VerificationTransactionID]*Verification, now time.Time) {
+	for txnID, entry := range verifications {
+		if entry.finished() && now.Sub(entry.UpdatedAt) > verificationRetention {
+			delete(verifications, txnID)
+		}
+	}
+}
+
+func (v *verifier) list() []Verification {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	output := make([]Verification, 0, len(v.verifications))
+	for _, entry := range v.verifications {
+		output = append(output, entry.clone())
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].UpdatedAt.After(output[j].UpdatedAt)
+	})
+	return output
+}
+
+func (v *verifier) get(txnID id.VerificationTransactionID) (Verification, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.verifications[txnID]
+	if !ok {
+		return Verification{}, false
+	}
+	return entry.clone(), true
+}
+
+func (v *verifier) VerificationRequested(_ context.Context, txnID id.VerificationTransactionID, from id.UserID, fromDevice id.DeviceID) {
+	v.update(txnID, func(entry *Verification) {
+		entry.UserID, entry.DeviceID = from, fromDevice
+		entry.Incoming = true
+		entry.State = VerificationStateRequested
+	})
+}
+
+func (v *verifier) VerificationReady(_ context.Context, txnID id.VerificationTransactionID, otherDeviceID id.DeviceID, supportsSAS, _ bool, _ *verificationhelper.QRCode) {
+	v.update(txnID, func(entry *Verification) {
+		entry.DeviceID = otherDeviceID
+		entry.State = VerificationStateReady
+	})
+	if !supportsSAS {
+		return
+	}
+	// SAS is the only method offered, so start it right away. If the other
+	// side starts too, the helper keeps the start event the spec picks. The
+	// helper still holds its lock here, hence the goroutine.
+	go func() {
+		if err := v.helper.StartSAS(context.Background(), txnID); err != nil {
+			if entry, ok := v.get(txnID); ok && entry.State == VerificationStateReady {
+				log.Printf("failed to start SAS for %s: %v", txnID, err)
+			}
+		}
+	}()
+}
+
+func (v *verifier) ShowSAS(_ context.Context, txnID id.VerificationTransactionID, emojis []rune, emojiDescriptions []string, decimals []int) {
+	v.update(txnID, func(entry *Verification) {
+		entry.Emojis = sasEmojis(emojis, emojiDescriptions)
+		entry.Decimals = append([]int(nil), decimals...)
+		entry.State = VerificationStateSAS
+	})
+}
+
+func (v *verifier) VerificationCancelled(_ context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) {
+	v.update(txnID, func(entry *Verification) {
+		entry.State = VerificationStateCancelled
+		entry.CancelCode, entry.CancelReason = string(code), reason
+	})
+}
+
+func (v *verifier) VerificationDone(_ context.Context, txnID id.VerificationTransactionID, _ event.VerificationMethod) {
+	var ownDevice bool
+	v.update(txnID, func(entry *Verification) {
+		entry.State = VerificationStateDone
+		ownDevice = entry.UserID == v.cli.Account.UserID
+	})
+	if ownDevice && !v.cli.Verified {
+		go func() {
+			if err := v.completeSelfVerification(context.Background()); err != nil {
+				log.Printf("failed to finish verifying this session: %v", err)
+				v.update(txnID, func(entry *Verification) { entry.Error = err.Error() })
+			}
+		}()
+	}
+}
+
+func sasEmojis(emojis []rune, descriptions []string) []SASEmoji {
+	output := make([]SASEmoji, 0, len(emojis))
+	for i, emoji := range emojis {
+		item := SASEmoji{Emoji: string(emoji)}
+		if i < len(descriptions) {
+			item.Description = descriptions[i]
+		}
+		output = append(output, item)
+	}
+	return output
+}
+
+// completeSelfVerification asks the device that just verified this session
+// for the cross-signing and key backup secrets, then marks the session
+// verified the same way a recovery key would.
+func (v *verifier) completeSelfVerification(ctx context.Context) error {
+	secrets := make(map[id.Secret][]byte, 4)
+	for _, name := range []id.Secret{id.SecretXSMaster, id.SecretXSSelfSigning, id.SecretXSUserSigning, id.SecretMegolmBackupV1} {
+		err := v.cli.Crypto.GetOrRequestSecret(ctx, name, func(secret string) (bool, error) {
+			data, err := base64.StdEncoding.DecodeString(secret)
+			if err != nil {
+				return false, nil
+			}
+			secrets[name] = data
+			return true, nil
+		}, verificationSecretTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to receive %s: %w", name, err)
+		}
+	}
+
+	mach := v.cli.Crypto
+	err := mach.ImportCrossSigningKeys(crypto.CrossSigningSeeds{
+		MasterKey:      secrets[id.SecretXSMaster],
+		SelfSigningKey: secrets[id.SecretXSSelfSigning],
+		UserSigningKey: secrets[id.SecretXSUserSigning],
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import cross-signing keys: %w", err)
+	}
+	if err = mach.SignOwnDevice(ctx, mach.OwnIdentity()); err != nil {
+		return fmt.Errorf("failed to sign own device: %w", err)
+	}
+	if err = mach.SignOwnMasterKey(ctx); err != nil {
+		return fmt.Errorf("failed to sign own master key: %w", err)
+	}
+	backupKey, err := backup.MegolmBackupKeyFromBytes(secrets[id.SecretMegolmBackupV1])
+	if err != nil {
+		return fmt.Errorf("failed to parse key backup key: %w", err)
+	}
+	latestVersion, err := v.cli.Client.GetKeyBackupLatestVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get key backup version: %w", err)
+	}
+	v.cli.KeyBackupKey = backupKey
+	v.cli.KeyBackupVersion = latestVersion.Version
+	v.cli.Verified = true
+	if !v.cli.IsSyncing() {
+		go v.cli.Sync()
+	}
+	v.cli.EventHandler(v.cli.State())
+	return nil
+}
+
+// Verifications lists the verifications of the current session, newest
+// first. Finished ones are kept for a while.
+func (r *Runtime) Verifications(ctx context.Context) ([]Verification, error) {
+	v, err := r.currentVerifier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return v.list(), nil
+}
+
+// Verification returns one verification by transaction ID.
+func (r *Runtime) Verification(ctx context.Context, txnID string) (Verification, bool, error) {
+	v, err := r.currentVerifier(ctx)
+	if err != nil {
+		return Verification{}, false, err
+	}
+	entry, ok := v.get(id.VerificationTransactionID(txnID))
+	return entry, ok, nil
+}
+
+// StartVerification sends a verification request to every device of userID,
+// or to this account's other devices when userID is empty.
+func (r *Runtime) StartVerification(ctx context.Context, userID id.UserID) (Verification, error) {
+	v, err := r.currentVerifier(ctx)
+	if err != nil {
+		return Verification{}, err
+	}
+	if userID == "" {
+		userID = v.cli.Account.UserID
+	}
+	txnID, err := v.helper.StartVerification(ctx, userID)
+	if err != nil {
+		return Verification{}, err
+	}
+	v.update(txnID, func(entry *Verification) {
+		entry.UserID = userID
+		entry.State = VerificationStateRequested
+	})
+	entry, _ := v.get(txnID)
+	return entry, nil
+}
+
+// AcceptVerification answers an incoming request. SAS starts once the
+// request is ready.
+func (r *Runtime) AcceptVerification(ctx context.Context, txnID string) (Verification, error) {
+	return r.verificationAction(ctx, txnID, func(v *verifier, txnID id.VerificationTransactionID) error {
+		return v.helper.AcceptVerification(ctx, txnID)
+	})
+}
+
+// ConfirmVerification reports that the SAS matches the other device.
+func (r *Runtime) ConfirmVerification(ctx context.Context, txnID string) (Verification, error) {
+	return r.verificationAction(ctx, txnID, func(v *verifier, txnID id.VerificationTransactionID) error {
+		if err := v.helper.ConfirmSAS(ctx, txnID); err != nil {
+			return err
+		}
+		v.update(txnID, func(entry *Verification) {
+			if entry.State == VerificationStateSAS {
+				entry.State = VerificationStateConfirmed
+			}
+		})
+		return nil
+	})
+}
+
+// CancelVerification stops a verification, telling the other side that the
+// user cancelled it.
+func (r *Runtime) CancelVerification(ctx context.Context, txnID string) (Verification, error) {
+	return r.verificationAction(ctx, txnID, func(v *verifier, txnID id.VerificationTransactionID) error {
+		if err := v.helper.CancelVerification(ctx, txnID, event.VerificationCancelCodeUser, "The user cancelled the verification."); err != nil {
+			return err
+		}
+		v.update(txnID, func(entry *Verification) {
+			entry.State = VerificationStateCancelled
+			entry.CancelCode, entry.CancelReason = string(event.VerificationCancelCodeUser), "cancelled by this session"
+		})
+		return nil
+	})
+}
+
+// ErrUnknownVerification is returned for transaction IDs this session has
+// not seen or has already forgotten.
+var ErrUnknownVerification = errors.New("unknown verification")
+
+func (r *Runtime) verificationAction(ctx context.Context, rawTxnID string, action func(*verifier, id.VerificationTransactionID) error) (Verification, error) {
+	v, err := r.currentVerifier(ctx)
+	if err != nil {
+		return Verification{}, err
+	}
+	txnID := id.VerificationTransactionID(rawTxnID)
+	if _, ok := v.get(txnID); !ok {
+		return Verification{}, ErrUnknownVerification
+	}
+	if err = action(v, txnID); err != nil {
+		return Verification{}, err
+	}
+	entry, _ := v.get(txnID)
+	return entry, nil
+}
+
+// toDeviceOnlyCrypto satisfies the helper's requirement for a crypto helper.
+// It is only used for in-room verification, which is not offered here.
+type toDeviceOnlyCrypto struct{}
+
+var errInRoomVerification = errors.New("in-room verification is not supported")
+
+func (toDeviceOnlyCrypto) Encrypt(context.Context, id.RoomID, event.Type, any) (*event.EncryptedEventContent, error) {
+	return nil, errInRoomVerification
+}
+
+func (toDeviceOnlyCrypto) Decrypt(context.Context, *event.Event) (*event.Event, error) {
+	return nil, errInRoomVerification
+}
+
+func (toDeviceOnlyCrypto) WaitForSession(context.Context, id.RoomID, id.SenderKey, id.SessionID, time.Duration) bool {
+	return false
+}
+
+func (toDeviceOnlyCrypto) RequestSession(context.Context, id.RoomID, id.SenderKey, id.SessionID, id.UserID, id.DeviceID) {
+}
+
+func (toDeviceOnlyCrypto) Init(context.Context) error {
+	return nil
+}
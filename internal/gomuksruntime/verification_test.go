@@ -0,0 +1,29 @@
+package gomuksruntime
+
+import (
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestSASEmojisPairsDescriptions(t *testing.T) {
+	emojis := sasEmojis([]rune{'🐶', '🔑'}, []string{"Dog"})
+	if len(emojis) != 2 || emojis[0] != (SASEmoji{Emoji: "🐶", Description: "Dog"}) || emojis[1] != (SASEmoji{Emoji: "🔑"}) {
+		t.Fatalf("unexpected emojis: %#v", emojis)
+	}
+}
+
+func TestPruneVerificationsKeepsActiveOnes(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * verificationRetention)
+	verifications := map[id.VerificationTransactionID]*Verification{
+		"active": {State: VerificationStateSAS, UpdatedAt: old},
+		"done":   {State: VerificationStateDone, UpdatedAt: old},
+		"recent": {State: VerificationStateCancelled, UpdatedAt: now},
+	}
+	pruneVerifications(verifications, now)
+	if _, ok := verifications["done"]; ok || len(verifications) != 2 {
+		t.Fatalf("unexpected verifications after prune: %#v", verifications)
+	}
+}
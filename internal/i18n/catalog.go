@@ -0,0 +1,107 @@
+package i18n
+
+var catalog = map[Locale]map[string]string{
+	English: {
+		"network.Unknown":       "Unknown",
+		"digest.subject.one":    "%d chat with unread mentions",
+		"digest.subject.other":  "%d chats with unread mentions",
+		"digest.intro":          "You have unread mentions in these chats:",
+		"digest.mentions.one":   "%d mention",
+		"digest.mentions.other": "%d mentions",
+		"digest.unread":         "%d unread",
+		"digest.lastMessage":    "last message %s",
+		"digest.more":           "...and %d more.",
+		"time.justNow":          "just now",
+		"time.minutesAgo.one":   "%d minute ago",
+		"time.minutesAgo.other": "%d minutes ago",
+		"time.hoursAgo.one":     "%d hour ago",
+		"time.hoursAgo.other":   "%d hours ago",
+		"time.yesterday":        "yesterday",
+		"time.daysAgo.one":      "%d day ago",
+		"time.daysAgo.other":    "%d days ago",
+		"time.dateLayout":       "Jan 2, 2006",
+	},
+	German: {
+		"network.Unknown":         "Unbekannt",
+		"network.Google Messages": "Google Nachrichten",
+		"digest.subject.one":      "%d Chat mit ungelesenen Erwähnungen",
+		"digest.subject.other":    "%d Chats mit ungelesenen Erwähnungen",
+		"digest.intro":            "Du hast ungelesene Erwähnungen in diesen Chats:",
+		"digest.mentions.one":     "%d Erwähnung",
+		"digest.mentions.other":   "%d Erwähnungen",
+		"digest.unread":           "%d ungelesen",
+		"digest.lastMessage":      "letzte Nachricht %s",
+		"digest.more":             "...und %d weitere.",
+		"time.justNow":            "gerade eben",
+		"time.minutesAgo.one":     "vor %d Minute",
+		"time.minutesAgo.other":   "vor %d Minuten",
+		"time.hoursAgo.one":       "vor %d Stunde",
+		"time.hoursAgo.other":     "vor %d Stunden",
+		"time.yesterday":          "gestern",
+		"time.daysAgo.one":        "vor %d Tag",
+		"time.daysAgo.other":      "vor %d Tagen",
+		"time.dateLayout":         "2.1.2006",
+	},
+	Spanish: {
+		"network.Unknown":         "Desconocido",
+		"network.Google Messages": "Mensajes de Google",
+		"digest.subject.one":      "%d chat con menciones sin leer",
+		"digest.subject.other":    "%d chats con menciones sin leer",
+		"digest.intro":            "Tienes menciones sin leer en estos chats:",
+		"digest.mentions.one":     "%d mención",
+		"digest.mentions.other":   "%d menciones",
+		"digest.unread":           "%d sin leer",
+		"digest.lastMessage":      "último mensaje %s",
+		"digest.more":             "...y %d más.",
+		"time.justNow":            "ahora mismo",
+		"time.minutesAgo.one":     "hace %d minuto",
+		"time.minutesAgo.other":   "hace %d minutos",
+		"time.hoursAgo.one":       "hace %d hora",
+		"time.hoursAgo.other":     "hace %d horas",
+		"time.yesterday":          "ayer",
+		"time.daysAgo.one":        "hace %d día",
+		"time.daysAgo.other":      "hace %d días",
+		"time.dateLayout":         "2/1/2006",
+	},
+	French: {
+		"network.Unknown":       "Inconnu",
+		"digest.subject.one":    "%d discussion avec des mentions non lues",
+		"digest.subject.other":  "%d discussions avec des mentions non lues",
+		"digest.intro":          "Vous avez des mentions non lues dans ces discussions :",
+		"digest.mentions.one":   "%d mention",
+		"digest.mentions.other": "%d mentions",
+		"digest.unread":         "%d non lus",
+		"digest.lastMessage":    "dernier message %s",
+		"digest.more":           "...et %d de plus.",
+		"time.justNow":          "à l'instant",
+		"time.minutesAgo.one":   "il y a %d minute",
+		"time.minutesAgo.other": "il y a %d minutes",
+		"time.hoursAgo.one":     "il y a %d heure",
+		"time.hoursAgo.other":   "il y a %d heures",
+		"time.yesterday":        "hier",
+		"time.daysAgo.one":      "il y a %d jour",
+		"time.daysAgo.other":    "il y a %d jours",
+		"time.dateLayout":       "02/01/2006",
+	},
+	Turkish: {
+		"network.Unknown":         "Bilinmiyor",
+		"network.Google Messages": "Google Mesajlar",
+		"digest.subject.one":      "%d sohbette okunmamış bahsetme",
+		"digest.subject.other":    "%d sohbette okunmamış bahsetme",
+		"digest.intro":            "Şu sohbetlerde okunmamış bahsetmeleriniz var:",
+		"digest.mentions.one":     "%d bahsetme",
+		"digest.mentions.other":   "%d bahsetme",
+		"digest.unread":           "%d okunmamış",
+		"digest.lastMessage":      "son mesaj %s",
+		"digest.more":             "...ve %d tane daha.",
+		"time.justNow":            "az önce",
+		"time.minutesAgo.one":     "%d dakika önce",
+		"time.minutesAgo.other":   "%d dakika önce",
+		"time.hoursAgo.one":       "%d saat önce",
+		"time.hoursAgo.other":     "%d saat önce",
+		"time.yesterday":          "dün",
+		"time.daysAgo.one":        "%d gün önce",
+		"time.daysAgo.other":      "%d gün önce",
+		"time.dateLayout":         "02.01.2006",
+	},
+}
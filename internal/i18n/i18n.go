@@ -0,0 +1,134 @@
+// Package i18n holds the bundled translations for strings the server renders
+// itself, such as network names and digest emails. API payloads stay
+// language-neutral; only human-readable text goes through here.
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Locale string
+
+const (
+	English Locale = "en"
+	German  Locale = "de"
+	Spanish Locale = "es"
+	French  Locale = "fr"
+	Turkish Locale = "tr"
+
+	Default = English
+)
+
+var Supported = []Locale{English, German, Spanish, French, Turkish}
+
+// Parse accepts a BCP 47 tag such as "de" or "de-AT" and returns the bundled
+// locale for its language.
+func Parse(raw string) (Locale, bool) {
+	tag := strings.ToLower(strings.TrimSpace(raw))
+	if idx := strings.IndexAny(tag, "-_"); idx >= 0 {
+		tag = tag[:idx]
+	}
+	for _, locale := range Supported {
+		if tag == string(locale) {
+			return locale, true
+		}
+	}
+	return "", false
+}
+
+// FromAcceptLanguage picks the first supported language of an
+// Accept-Language header. Quality values are ignored because clients list
+// languages in preference order anyway.
+func FromAcceptLanguage(header string) Locale {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if locale, ok := Parse(tag); ok {
+			return locale
+		}
+	}
+	return Default
+}
+
+// FromRequest prefers an explicit locale query parameter over the
+// Accept-Language header.
+func FromRequest(r *http.Request) Locale {
+	if locale, ok := Parse(r.URL.Query().Get("locale")); ok {
+		return locale
+	}
+	return FromAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+type contextKey struct{}
+
+func WithLocale(ctx context.Context, locale Locale) context.Context {
+	return context.WithValue(ctx, contextKey{}, locale)
+}
+
+func FromContext(ctx context.Context) Locale {
+	if locale, ok := ctx.Value(contextKey{}).(Locale); ok {
+		return locale
+	}
+	return Default
+}
+
+func (l Locale) lookup(key string) (string, bool) {
+	if text, ok := catalog[l][key]; ok {
+		return text, true
+	}
+	text, ok := catalog[Default][key]
+	return text, ok
+}
+
+// T formats the message for key, falling back to English and then to the
+// key itself.
+func (l Locale) T(key string, args ...any) string {
+	text, ok := l.lookup(key)
+	if !ok {
+		text = key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// Plural formats key.one for a count of one and key.other otherwise.
+func (l Locale) Plural(key string, count int) string {
+	if count == 1 {
+		return l.T(key+".one", count)
+	}
+	return l.T(key+".other", count)
+}
+
+// Network translates a network display name. Brand names are kept unless
+// the app ships under a localized name.
+func (l Locale) Network(name string) string {
+	if text, ok := l.lookup("network." + name); ok {
+		return text
+	}
+	return name
+}
+
+// Relative describes t relative to now, e.g. "3 hours ago". Anything older
+// than a week is shown as a date.
+func (l Locale) Relative(t, now time.Time) string {
+	elapsed := now.Sub(t)
+	switch {
+	case elapsed < time.Minute:
+		return l.T("time.justNow")
+	case elapsed < time.Hour:
+		return l.Plural("time.minutesAgo", int(elapsed/time.Minute))
+	case elapsed < 24*time.Hour:
+		return l.Plural("time.hoursAgo", int(elapsed/time.Hour))
+	case elapsed < 48*time.Hour:
+		return l.T("time.yesterday")
+	case elapsed < 7*24*time.Hour:
+		return l.Plural("time.daysAgo", int(elapsed/(24*time.Hour)))
+	default:
+		return t.Format(l.T("time.dateLayout"))
+	}
+}
@@ -0,0 +1,41 @@
+package i18n
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFromAcceptLanguagePicksFirstSupported(t *testing.T) {
+	if got := FromAcceptLanguage("nl-NL, de-AT;q=0.8, en;q=0.5"); got != German {
+		t.Fatalf("FromAcceptLanguage = %q, want de", got)
+	}
+	if got := FromAcceptLanguage("nl"); got != Default {
+		t.Fatalf("FromAcceptLanguage = %q, want the default", got)
+	}
+}
+
+func TestRelativeAndFallbacks(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		locale Locale
+		at     time.Time
+		want   string
+	}{
+		{English, now.Add(-time.Minute), "1 minute ago"},
+		{German, now.Add(-3 * time.Hour), "vor 3 Stunden"},
+		{Turkish, now.Add(-30 * time.Hour), "dün"},
+		{Spanish, now.Add(-3 * 24 * time.Hour), "hace 3 días"},
+		{French, now.Add(-30 * 24 * time.Hour), "08/02/2026"},
+	}
+	for _, tc := range cases {
+		if got := tc.locale.Relative(tc.at, now); got != tc.want {
+			t.Fatalf("%s Relative = %q, want %q", tc.locale, got, tc.want)
+		}
+	}
+	if got := French.Network("Google Messages"); got != "Google Messages" {
+		t.Fatalf("expected an untranslated network name to pass through, got %q", got)
+	}
+	if got := Locale("xx").T("digest.intro"); got != English.T("digest.intro") {
+		t.Fatalf("expected an unknown locale to fall back to English, got %q", got)
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+const (
+	accountArchiveVersion      = 1
+	accountArchiveManifestName = "manifest.json"
+	// accountArchiveStatePrefix holds everything in the data dir besides the
+	// database: local JSON stores, uploads and the like.
+	accountArchiveStatePrefix = "state/"
+	accountImportBodyLimit    = int64(8 << 30)
+	// defaultAccountImportMaxBytes caps what an import unpacks, since a
+	// small compressed body can expand far past the body limit.
+	defaultAccountImportMaxBytes = int64(16 << 30)
+)
+
+// accountArchiveSkippedDirs are regenerable caches and transient output that
+// would only bloat the archive, plus the live state of other sessions, which
+// belongs to different accounts.
+var accountArchiveSkippedDirs = map[string]struct{}{
+	"assets":                           {},
+	"exports":                          {},
+	"link-previews":                    {},
+	gomuksruntime.ImportStagingDirName: {},
+	gomuksruntime.ImportStagingDirName + ".partial": {},
+	gomuksruntime.SessionsDirName:                   {},
+}
+
+type accountArchiveManifest struct {
+	Version    int    `json:"version"`
+	UserID     string `json:"userID"`
+	ExportedAt int64  `json:"exportedAt"`
+}
+
+// exportAccount streams the whole local state as a tar.gz. The database is
+// snapshotted with VACUUM INTO so the archive is consistent even while sync
+// keeps writing; it includes the crypto store and therefore the E2EE keys,
+// which is why both account routes sit behind the manage secret.
+func (s *Server) exportAccount(w http.ResponseWriter, r *http.Request) error {
+	cli := s.rt.Client()
+	dataDir := s.rt.StateDir()
+	snapshot, err := os.CreateTemp(dataDir, ".account-export-*.db")
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to create database snapshot: %w", err))
+	}
+	snapshotPath := snapshot.Name()
+	_ = snapshot.Close()
+	// VACUUM INTO refuses to overwrite an existing file.
+	_ = os.Remove(snapshotPath)
+	defer os.Remove(snapshotPath)
+	if _, err = cli.DB.Exec(r.Context(), "VACUUM INTO $1", snapshotPath); err != nil {
+		return errs.Internal(fmt.Errorf("failed to snapshot database: %w", err))
+	}
+
+	manifest, err := json.Marshal(accountArchiveManifest{
+		Version:    accountArchiveVersion,
+		UserID:     string(cli.Account.UserID),
+		ExportedAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to encode export manifest: %w", err))
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="easymatrix-account-%s.tar.gz"`, time.Now().UTC().Format("20060102-150405")))
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	// Headers are already sent by the time a write fails, so the client sees
+	// a truncated archive rather than an error body.
+	if err = writeTarBytes(tw, accountArchiveManifestName, manifest); err != nil {
+		return nil
+	}
+	if err = writeTarFile(tw, gomuksruntime.DatabaseFileName, snapshotPath); err != nil {
+		return nil
+	}
+	err = filepath.WalkDir(dataDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dataDir, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		if entry.IsDir() {
+			if _, skip := accountArchiveSkippedDirs[rel]; skip {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || isAccountArchiveDatabaseFile(rel) || strings.HasPrefix(entry.Name(), ".account-export-") {
+			return nil
+		}
+		return writeTarFile(tw, accountArchiveStatePrefix+filepath.ToSlash(rel), filePath)
+	})
+	if err != nil {
+		return nil
+	}
+	if err = tw.Close(); err != nil {
+		return nil
+	}
+	_ = gz.Close()
+	return nil
+}
+
+func isAccountArchiveDatabaseFile(rel string) bool {
+	return rel == gomuksruntime.DatabaseFileName || strings.HasPrefix(rel, gomuksruntime.DatabaseFileName+"-")
+}
+
+func writeTarBytes(tw *tar.Writer, name string, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o600,
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o600,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// accountArchiveTarget maps an archive entry to its path inside the staging
+// dir. Entries outside the known layout or escaping the dir are rejected.
+func accountArchiveTarget(name string) (string, bool) {
+	if name == gomuksruntime.DatabaseFileName {
+		return name, true
+	}
+	rel, ok := strings.CutPrefix(name, accountArchiveStatePrefix)
+	if !ok || rel == "" || strings.Contains(rel, "\\") {
+		return "", false
+	}
+	cleaned := path.Clean(rel)
+	if cleaned != rel || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	if isAccountArchiveDatabaseFile(cleaned) {
+		return "", false
+	}
+	if _, skip := accountArchiveSkippedDirs[strings.SplitN(cleaned, "/", 2)[0]]; skip {
+		return "", false
+	}
+	return filepath.FromSlash(cleaned), true
+}
+
+// importAccount unpacks an archive produced by exportAccount into the staging
+// dir. The running client keeps its database open, so the import only takes
+// effect on the next start, when the runtime moves the staged files in place.
+func (s *Server) importAccount(w http.ResponseWriter, r *http.Request) error {
+	dataDir := s.rt.StateDir()
+	stagingDir := filepath.Join(dataDir, gomuksruntime.ImportStagingDirName)
+	partialDir := stagingDir + ".partial"
+	if err := os.RemoveAll(partialDir); err != nil {
+		return errs.Internal(fmt.Errorf("failed to clear import staging: %w", err))
+	}
+	manifest, err := extractAccountArchive(r.Body, partialDir, s.accountImportMaxBytes())
+	if err != nil {
+		_ = os.RemoveAll(partialDir)
+		if tooLarge := payloadTooLargeError(err); tooLarge != nil {
+			return tooLarge
+		}
+		return err
+	}
+	// Swap the staging dir in one rename so the runtime never picks up a
+	// half-written import.
+	if err = os.RemoveAll(stagingDir); err == nil {
+		err = os.Rename(partialDir, stagingDir)
+	}
+	if err != nil {
+		_ = os.RemoveAll(partialDir)
+		return errs.Internal(fmt.Errorf("failed to stage account import: %w", err))
+	}
+	return writeJSON(w, compat.AccountImportOutput{
+		UserID:          manifest.UserID,
+		ExportedAt:      manifest.ExportedAt,
+		RestartRequired: true,
+	})
+}
+
+func (s *Server) accountImportMaxBytes() int64 {
+	if s.cfg.AccountImportMaxBytes > 0 {
+		return s.cfg.AccountImportMaxBytes
+	}
+	return defaultAccountImportMaxBytes
+}
+
+// extractAccountArchive unpacks an archive into destDir, giving up once more
+// than maxBytes of tar data has been decompressed.
+func extractAccountArchive(body io.Reader, destDir string, maxBytes int64) (*accountArchiveManifest, error) {
+	invalid := func(reason string) error {
+		return errs.Validation(map[string]any{"archive": reason})
+	}
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		if payloadTooLargeError(err) != nil {
+			return nil, err
+		}
+		return nil, invalid("archive must be a gzip-compressed tar file")
+	}
+	defer gz.Close()
+	if err = os.MkdirAll(destDir, 0o700); err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to create import staging: %w", err))
+	}
+	var (
+		manifest    *accountArchiveManifest
+		hasDatabase bool
+	)
+	// One byte past the limit is let through so reaching it can be told
+	// apart from running over it.
+	unpacked := &io.LimitedReader{R: gz, N: maxBytes + 1}
+	tr := tar.NewReader(unpacked)
+	for {
+		header, err := tr.Next()
+		if unpacked.N <= 0 {
+			return nil, errs.PayloadTooLarge(maxBytes)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			if payloadTooLargeError(err) != nil {
+				return nil, err
+			}
+			return nil, invalid("archive is corrupt")
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, invalid(fmt.Sprintf("unsupported entry type for %q", header.Name))
+		}
+		if header.Name == accountArchiveManifestName {
+			manifest = &accountArchiveManifest{}
+			if err = json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(manifest); err != nil {
+				return nil, invalid("manifest is not valid JSON")
+			}
+			if manifest.Version != accountArchiveVersion {
+				return nil, invalid(fmt.Sprintf("unsupported archive version %d", manifest.Version))
+			}
+			continue
+		}
+		target, ok := accountArchiveTarget(header.Name)
+		if !ok {
+			return nil, invalid(fmt.Sprintf("unexpected entry %q", header.Name))
+		}
+		err = extractTarFile(tr, filepath.Join(destDir, target))
+		if unpacked.N <= 0 {
+			return nil, errs.PayloadTooLarge(maxBytes)
+		}
+		if err != nil {
+			if payloadTooLargeError(err) != nil {
+				return nil, err
+			}
+			return nil, errs.Internal(fmt.Errorf("failed to extract %s: %w", header.Name, err))
+		}
+		if target == gomuksruntime.DatabaseFileName {
+			hasDatabase = true
+		}
+	}
+	if manifest == nil {
+		return nil, invalid("archive has no manifest")
+	}
+	if !hasDatabase {
+		return nil, invalid("archive has no database")
+	}
+	return manifest, nil
+}
+
+func extractTarFile(tr *tar.Reader, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, tr); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+func TestAccountArchiveTargetRejectsEscapes(t *testing.T) {
+	for _, name := range []string{
+		"state/../gomuks.db",
+		"state/../../etc/passwd",
+		"state//abs",
+		"state/gomuks.db-wal",
+		"state/assets/blobs/x",
+		"state/sessions/work/data/gomuks.db",
+		"state/",
+		"other/file",
+		"/gomuks.db",
+	} {
+		if target, ok := accountArchiveTarget(name); ok {
+			t.Fatalf("expected %q to be rejected, got %q", name, target)
+		}
+	}
+	if target, ok := accountArchiveTarget("state/uploads/a.bin"); !ok || target != filepath.Join("uploads", "a.bin") {
+		t.Fatalf("unexpected target for state entry: %q %v", target, ok)
+	}
+	if target, ok := accountArchiveTarget("gomuks.db"); !ok || target != "gomuks.db" {
+		t.Fatalf("unexpected target for database: %q %v", target, ok)
+	}
+}
+
+func buildTestArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := writeTarBytes(tw, name, []byte(content)); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+	return &buf
+}
+
+func TestExtractAccountArchiveStagesFiles(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "staging")
+	archive := buildTestArchive(t, map[string]string{
+		accountArchiveManifestName: `{"version":1,"userID":"@alice:example.com","exportedAt":5}`,
+		"gomuks.db":                "db",
+		"state/auto-archive.json":  "{}",
+	})
+	manifest, err := extractAccountArchive(archive, dest, defaultAccountImportMaxBytes)
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if manifest.UserID != "@alice:example.com" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if raw, err := os.ReadFile(filepath.Join(dest, "auto-archive.json")); err != nil || string(raw) != "{}" {
+		t.Fatalf("state file not extracted: %q %v", raw, err)
+	}
+}
+
+func TestExtractAccountArchiveRequiresDatabase(t *testing.T) {
+	archive := buildTestArchive(t, map[string]string{
+		accountArchiveManifestName: `{"version":1}`,
+	})
+	if _, err := extractAccountArchive(archive, t.TempDir(), defaultAccountImportMaxBytes); err == nil {
+		t.Fatal("expected archive without database to be rejected")
+	}
+}
+
+func TestExtractAccountArchiveCapsUnpackedSize(t *testing.T) {
+	archive := buildTestArchive(t, map[string]string{
+		accountArchiveManifestName: `{"version":1}`,
+		"gomuks.db":                strings.Repeat("\x00", 1<<20),
+	})
+	if archive.Len() > 64<<10 {
+		t.Fatalf("expected the test archive to compress well, got %d bytes", archive.Len())
+	}
+	_, err := extractAccountArchive(archive, t.TempDir(), 256<<10)
+	var apiErr *errs.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected an oversized archive to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const maxAccountDataTypeLength = 255
+
+// protectedAccountDataPrefixes hold key material or have dedicated APIs;
+// the passthrough never reads or writes them.
+var protectedAccountDataPrefixes = []string{
+	"m.secret_storage.",
+	"m.cross_signing.",
+	"m.megolm_backup.",
+	"m.push_rules",
+}
+
+// validateAccountDataType requires a namespaced type such as
+// com.example.settings and rejects the protected ones.
+func validateAccountDataType(raw string) (string, error) {
+	evtType := strings.TrimSpace(raw)
+	if evtType == "" || len(evtType) > maxAccountDataTypeLength || !strings.Contains(evtType, ".") || strings.ContainsAny(evtType, "/ ") {
+		return "", errs.Validation(map[string]any{"type": "must be a namespaced account data type like com.example.settings"})
+	}
+	for _, prefix := range protectedAccountDataPrefixes {
+		if strings.HasPrefix(evtType, prefix) {
+			return "", errs.Forbidden(fmt.Sprintf("%s account data is not available through this endpoint", evtType))
+		}
+	}
+	return evtType, nil
+}
+
+func decodeAccountDataContent(r *http.Request) (json.RawMessage, error) {
+	var content json.RawMessage
+	if err := decodeJSON(r, &content); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return nil, errs.Validation(map[string]any{"content": "must be a JSON object"})
+	}
+	return content, nil
+}
+
+// Global account data can reveal chats outside a confined caller's policy
+// (m.direct lists every DM), so it stays with the deployment owner.
+func (s *Server) requireGlobalAccountDataAccess(r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Global account data is only available to the deployment owner")
+	}
+	return nil
+}
+
+func (s *Server) getGlobalAccountData(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireGlobalAccountDataAccess(r); err != nil {
+		return err
+	}
+	evtType, err := validateAccountDataType(r.PathValue("type"))
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	entries, err := cli.DB.AccountData.GetAllGlobal(r.Context(), cli.Account.UserID)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to read global account data: %w", err))
+	}
+	for _, entry := range entries {
+		if entry.Type == evtType && len(entry.Content) > 0 {
+			return writeJSON(w, compat.AccountData{Type: evtType, Content: entry.Content})
+		}
+	}
+	return errs.NotFound("Account data not found")
+}
+
+func (s *Server) setGlobalAccountData(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireGlobalAccountDataAccess(r); err != nil {
+		return err
+	}
+	evtType, err := validateAccountDataType(r.PathValue("type"))
+	if err != nil {
+		return err
+	}
+	content, err := decodeAccountDataContent(r)
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	if err = cli.Client.SetAccountData(r.Context(), evtType, content); err != nil {
+		return errs.Internal(fmt.Errorf("failed to store account data: %w", err))
+	}
+	// Mirror locally so a read right after the write does not wait for sync.
+	_, _ = cli.DB.AccountData.Put(r.Context(), cli.Account.UserID, event.Type{Type: evtType, Class: event.AccountDataEventType}, content)
+	return writeJSON(w, compat.AccountData{Type: evtType, Content: content})
+}
+
+func (s *Server) getRoomAccountData(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	evtType, err := validateAccountDataType(r.PathValue("type"))
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	entries, err := cli.DB.AccountData.GetAllRoom(r.Context(), cli.Account.UserID, id.RoomID(chatID))
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to read room account data: %w", err))
+	}
+	for _, entry := range entries {
+		if entry.Type == evtType && len(entry.Content) > 0 {
+			return writeJSON(w, compat.AccountData{Type: evtType, ChatID: chatID, Content: entry.Content})
+		}
+	}
+	return errs.NotFound("Account data not found")
+}
+
+func (s *Server) setRoomAccountData(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	evtType, err := validateAccountDataType(r.PathValue("type"))
+	if err != nil {
+		return err
+	}
+	content, err := decodeAccountDataContent(r)
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	if err = cli.Client.SetRoomAccountData(r.Context(), id.RoomID(chatID), evtType, content); err != nil {
+		return errs.Internal(fmt.Errorf("failed to store room account data: %w", err))
+	}
+	_, _ = cli.DB.AccountData.PutRoom(r.Context(), cli.Account.UserID, id.RoomID(chatID), event.Type{Type: evtType, Class: event.AccountDataEventType}, content)
+	return writeJSON(w, compat.AccountData{Type: evtType, ChatID: chatID, Content: content})
+}
@@ -1,8 +1,20 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
 )
 
 func TestApplyRoomAccountDataContent_MarkedUnreadOverridesStaleArchive(t *testing.T) {
@@ -59,3 +71,120 @@ func TestApplyRoomAccountDataContent_ParsesSnoozeState(t *testing.T) {
 func ptrInt64(v int64) *int64 {
 	return &v
 }
+
+func TestApplyRoomAccountDataContent_PinnedFollowsFavouriteTag(t *testing.T) {
+	state := applyRoomAccountDataContent(roomAccountDataState{}, "m.tag", []byte(`{"tags":{"m.favourite":{}}}`))
+	if !state.IsPinned {
+		t.Fatalf("expected m.favourite tag to mark the room as pinned")
+	}
+	state = applyRoomAccountDataContent(state, "m.tag", []byte(`{"tags":{"u.work":{}}}`))
+	if state.IsPinned {
+		t.Fatalf("expected pinned flag to clear once m.favourite is removed")
+	}
+}
+
+func TestRoomTagsAreExposedAndFilterable(t *testing.T) {
+	state := applyRoomAccountDataContent(roomAccountDataState{}, "m.tag", []byte(`{"tags":{"u.work":{"order":0.5},"m.favourite":{}}}`))
+	if len(state.Tags) != 2 || state.Tags[0] != "m.favourite" || state.Tags[1] != "u.work" {
+		t.Fatalf("unexpected tags: %v", state.Tags)
+	}
+	filter := parseRoomTagFilter([]string{"work, m.lowpriority", " "})
+	if len(filter) != 2 || filter[0] != "u.work" || filter[1] != "m.lowpriority" {
+		t.Fatalf("unexpected tag filter: %v", filter)
+	}
+	if !roomHasAnyTag(state.Tags, filter) || roomHasAnyTag(state.Tags, []string{"u.home"}) {
+		t.Fatal("unexpected tag filter match result")
+	}
+}
+
+func TestValidateAccountDataType(t *testing.T) {
+	if got, err := validateAccountDataType(" com.beeper.inbox.prefs "); err != nil || got != "com.beeper.inbox.prefs" {
+		t.Fatalf("validateAccountDataType = %q, %v", got, err)
+	}
+	for _, raw := range []string{"", "settings", "com.example/settings", "m.secret_storage.default_key", "m.cross_signing.master", "m.push_rules"} {
+		if _, err := validateAccountDataType(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func accountDataRequest(method, chatID, evtType, body string) *http.Request {
+	target := "/v1/account-data/type"
+	if chatID != "" {
+		target = "/v1/chats/" + chatID + "/account-data/type"
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.SetPathValue("type", evtType)
+	if chatID != "" {
+		req.SetPathValue("chatID", chatID)
+	}
+	return req
+}
+
+func TestAccountDataHandlersWriteThroughAndReadBack(t *testing.T) {
+	s := newDBTestServer(t)
+	writes := recordAccountDataWrites(t, s)
+
+	rec := httptest.NewRecorder()
+	if err := s.setGlobalAccountData(rec, accountDataRequest(http.MethodPut, "", "com.example.settings", `{"theme":"dark"}`)); err != nil {
+		t.Fatalf("setGlobalAccountData returned error: %v", err)
+	}
+	if string(writes["com.example.settings"]) != `{"theme":"dark"}` {
+		t.Fatalf("expected the content to be sent to the homeserver, got %q", writes["com.example.settings"])
+	}
+	rec = httptest.NewRecorder()
+	if err := s.getGlobalAccountData(rec, accountDataRequest(http.MethodGet, "", "com.example.settings", "")); err != nil {
+		t.Fatalf("getGlobalAccountData returned error: %v", err)
+	}
+	var global compat.AccountData
+	if err := json.Unmarshal(rec.Body.Bytes(), &global); err != nil || global.Type != "com.example.settings" || string(global.Content) != `{"theme":"dark"}` {
+		t.Fatalf("expected the write to be readable before sync, got %s (%v)", rec.Body.String(), err)
+	}
+
+	chatID := "!room:example.org"
+	insertTestRoom(t, s, id.RoomID(chatID))
+	if err := s.setRoomAccountData(httptest.NewRecorder(), accountDataRequest(http.MethodPut, chatID, "com.example.draft", `{"text":"hi"}`)); err != nil {
+		t.Fatalf("setRoomAccountData returned error: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	if err := s.getRoomAccountData(rec, accountDataRequest(http.MethodGet, chatID, "com.example.draft", "")); err != nil {
+		t.Fatalf("getRoomAccountData returned error: %v", err)
+	}
+	var room compat.AccountData
+	if err := json.Unmarshal(rec.Body.Bytes(), &room); err != nil || room.ChatID != chatID || string(room.Content) != `{"text":"hi"}` {
+		t.Fatalf("unexpected room account data: %s (%v)", rec.Body.String(), err)
+	}
+
+	var apiErr *errs.APIError
+	if err := s.getGlobalAccountData(httptest.NewRecorder(), accountDataRequest(http.MethodGet, "", "com.example.missing", "")); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Fatalf("expected missing account data to be 404, got %v", err)
+	}
+	if err := s.setRoomAccountData(httptest.NewRecorder(), accountDataRequest(http.MethodPut, chatID, "com.example.draft", `["not","an","object"]`)); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected non-object content to be rejected, got %v", err)
+	}
+	if err := s.setGlobalAccountData(httptest.NewRecorder(), accountDataRequest(http.MethodPut, "", "m.cross_signing.master", `{}`)); !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Fatalf("expected protected account data to be refused, got %v", err)
+	}
+}
+
+func TestGlobalAccountDataIsOwnerOnly(t *testing.T) {
+	s := newDBTestServer(t)
+	if err := s.clientPolicies.put(compat.ClientAccessPolicy{ClientID: "agent", Read: compat.ClientAccessRule{ChatIDs: []string{"!room:example.org"}}}); err != nil {
+		t.Fatalf("failed to store client policy: %v", err)
+	}
+	verifier := func(_ context.Context, _ string, _ *http.Request) (*mcpauth.TokenInfo, error) {
+		return &mcpauth.TokenInfo{Expiration: time.Now().Add(time.Hour), Extra: map[string]any{"client_id": "agent"}}, nil
+	}
+	var handlerErr error
+	handler := mcpauth.RequireBearerToken(verifier, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("type", "m.direct")
+		handlerErr = s.getGlobalAccountData(w, r)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/v1/account-data/m.direct", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	var apiErr *errs.APIError
+	if !errors.As(handlerErr, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Fatalf("expected a confined client to be refused global account data, got %v", handlerErr)
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"maunium.net/go/mautrix/event"
+)
+
+// bridgeStateTracker remembers the last seen bridge state per account so a
+// change to com.beeper.local_bridge_state is reported only for the accounts
+// it actually touched.
+type bridgeStateTracker struct {
+	mu     sync.Mutex
+	seeded bool
+	states map[string]string
+}
+
+// update stores the new state and returns the accounts that changed. The
+// first call only records the baseline.
+func (t *bridgeStateTracker) update(state localBridgeStateContent) []string {
+	current := bridgeStateFingerprints(state)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous, seeded := t.states, t.seeded
+	t.states, t.seeded = current, true
+	if !seeded {
+		return nil
+	}
+	return changedAccountIDs(previous, current)
+}
+
+func bridgeStateFingerprints(state localBridgeStateContent) map[string]string {
+	fingerprints := make(map[string]string)
+	for bridgeID, accounts := range state {
+		for loginID, account := range accounts {
+			raw, err := json.Marshal(account)
+			if err != nil {
+				continue
+			}
+			fingerprints[bridgeID+"_"+loginID] = string(raw)
+		}
+	}
+	return fingerprints
+}
+
+func changedAccountIDs(previous, current map[string]string) []string {
+	changed := make([]string, 0)
+	for accountID, fingerprint := range current {
+		if previous[accountID] != fingerprint {
+			changed = append(changed, accountID)
+		}
+	}
+	for accountID := range previous {
+		if _, ok := current[accountID]; !ok {
+			changed = append(changed, accountID)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func (h *wsHub) seedBridgeStates() {
+	cli := h.server.rt.Client()
+	if cli == nil || cli.Account == nil {
+		return
+	}
+	if state, err := h.server.loadLocalBridgeState(context.Background()); err == nil {
+		h.bridgeStates.update(state)
+	}
+}
+
+// accountUpdateEvents emits one account.updated event per account whose
+// bridge state changed in this sync.
+func (h *wsHub) accountUpdateEvents(syncComplete *jsoncmd.SyncComplete) []wsDomainEvent {
+	if syncComplete == nil {
+		return nil
+	}
+	ad, ok := syncComplete.AccountData[event.Type{Type: localBridgeStateEventType, Class: event.AccountDataEventType}]
+	if !ok || ad == nil {
+		return nil
+	}
+	var state localBridgeStateContent
+	if len(ad.Content) > 0 {
+		if err := json.Unmarshal(ad.Content, &state); err != nil {
+			return nil
+		}
+	}
+	changed := h.bridgeStates.update(state)
+	output := make([]wsDomainEvent, 0, len(changed))
+	for _, accountID := range changed {
+		output = append(output, wsDomainEvent{Type: wsDomainTypeAccountUpdated, IDs: []string{accountID}})
+	}
+	return output
+}
+
+// accountTargets returns every connected client allowed to see the accounts.
+// Account events are not tied to a chat, so chat subscriptions do not apply.
+func (h *wsHub) accountTargets(accountIDs []string) []*wsClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	output := make([]*wsClient, 0, len(h.clients))
+	for _, client := range h.clients {
+		if client == nil || client.state == nil {
+			continue
+		}
+		if !allAccountsVisible(client.policy, accountIDs) {
+			continue
+		}
+		output = append(output, client)
+	}
+	return output
+}
+
+func allAccountsVisible(policy *subjectPolicy, accountIDs []string) bool {
+	for _, accountID := range accountIDs {
+		if !policy.allowsAccount(accountID) {
+			return false
+		}
+	}
+	return true
+}
+
+// hydrateAccountsForWSEvent returns the current status of each account. An
+// account that disappeared from the bridge state is reported as DELETED.
+func (s *Server) hydrateAccountsForWSEvent(accountIDs []string) ([]compatRecord, error) {
+	state, err := s.loadLocalBridgeState(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	currentDeviceID := string(s.rt.Client().Account.DeviceID)
+	output := make([]compatRecord, 0, len(accountIDs))
+	for _, accountID := range accountIDs {
+		bridgeID, loginID := splitDesktopAccountID(accountID)
+		account, ok := state[bridgeID][loginID]
+		if !ok || strings.TrimSpace(account.State) == "" {
+			account.State = "DELETED"
+		}
+		record, err := toCompatRecord(newAccountStatus(accountID, networkFromBridgeID(bridgeID), account, currentDeviceID))
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, record)
+	}
+	return output, nil
+}
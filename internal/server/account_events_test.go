@@ -0,0 +1,28 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBridgeStateTrackerReportsChangedAccounts(t *testing.T) {
+	tracker := &bridgeStateTracker{}
+	initial := localBridgeStateContent{
+		"whatsapp": {"1": {State: "CONNECTED"}},
+		"signal":   {"2": {State: "CONNECTED"}},
+	}
+	if changed := tracker.update(initial); len(changed) != 0 {
+		t.Fatalf("expected the first update to only seed, got %q", changed)
+	}
+	next := localBridgeStateContent{
+		"whatsapp": {"1": {State: "BAD_CREDENTIALS"}},
+		"telegram": {"3": {State: "CONNECTED"}},
+	}
+	changed := tracker.update(next)
+	if !slices.Equal(changed, []string{"signal_2", "telegram_3", "whatsapp_1"}) {
+		t.Fatalf("unexpected changed accounts: %q", changed)
+	}
+	if changed = tracker.update(next); len(changed) != 0 {
+		t.Fatalf("expected no changes for an identical state, got %q", changed)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// Account preferences live in global account data so that every client of the
+// same Matrix user sees the same ordering and labels.
+const (
+	accountPreferencesEventType = "com.easymatrix.account_preferences"
+	maxAccountLabelLength       = 64
+)
+
+var accountColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type accountDisplayPreference struct {
+	Label string `json:"label,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+type accountPreferences struct {
+	Order    []string                            `json:"order"`
+	Accounts map[string]accountDisplayPreference `json:"accounts"`
+}
+
+func (s *Server) getAccountPreferences(w http.ResponseWriter, r *http.Request) error {
+	prefs, err := s.loadAccountPreferences(r.Context())
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, prefs)
+}
+
+func (s *Server) setAccountPreferences(w http.ResponseWriter, r *http.Request) error {
+	var req accountPreferences
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	prefs, err := normalizeAccountPreferences(req)
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	if err = cli.Client.SetAccountData(r.Context(), accountPreferencesEventType, prefs); err != nil {
+		return errs.Internal(fmt.Errorf("failed to store account preferences: %w", err))
+	}
+	// Mirror the write locally so /v1/accounts reflects it before the sync echo.
+	if raw, marshalErr := json.Marshal(prefs); marshalErr == nil {
+		evtType := event.Type{Type: accountPreferencesEventType, Class: event.AccountDataEventType}
+		_, _ = cli.DB.AccountData.Put(r.Context(), cli.Account.UserID, evtType, raw)
+	}
+	return writeJSON(w, prefs)
+}
+
+func normalizeAccountPreferences(input accountPreferences) (accountPreferences, error) {
+	output := accountPreferences{
+		Order:    make([]string, 0, len(input.Order)),
+		Accounts: make(map[string]accountDisplayPreference, len(input.Accounts)),
+	}
+	for _, accountID := range input.Order {
+		accountID = strings.TrimSpace(accountID)
+		if accountID == "" {
+			return accountPreferences{}, errs.Validation(map[string]any{"order": "must not contain empty account IDs"})
+		}
+		if slices.Contains(output.Order, accountID) {
+			return accountPreferences{}, errs.Validation(map[string]any{"order": fmt.Sprintf("duplicate account ID %q", accountID)})
+		}
+		output.Order = append(output.Order, accountID)
+	}
+	for accountID, pref := range input.Accounts {
+		accountID = strings.TrimSpace(accountID)
+		if accountID == "" {
+			return accountPreferences{}, errs.Validation(map[string]any{"accounts": "must not contain empty account IDs"})
+		}
+		pref.Label = strings.TrimSpace(pref.Label)
+		pref.Color = strings.TrimSpace(pref.Color)
+		if utf8.RuneCountInString(pref.Label) > maxAccountLabelLength {
+			return accountPreferences{}, errs.Validation(map[string]any{"accounts." + accountID + ".label": fmt.Sprintf("must be at most %d characters", maxAccountLabelLength)})
+		}
+		if pref.Color != "" && !accountColorPattern.MatchString(pref.Color) {
+			return accountPreferences{}, errs.Validation(map[string]any{"accounts." + accountID + ".color": "must be a hex color like #1a2b3c"})
+		}
+		if pref.Label == "" && pref.Color == "" {
+			continue
+		}
+		output.Accounts[accountID] = pref
+	}
+	return output, nil
+}
+
+func (s *Server) loadAccountPreferences(ctx context.Context) (accountPreferences, error) {
+	prefs := accountPreferences{Order: []string{}, Accounts: map[string]accountDisplayPreference{}}
+	cli := s.rt.Client()
+	if cli == nil || cli.Account == nil {
+		return prefs, nil
+	}
+	accountDataEvents, err := cli.DB.AccountData.GetAllGlobal(ctx, cli.Account.UserID)
+	if err != nil {
+		return prefs, errs.Internal(fmt.Errorf("failed to read global account data: %w", err))
+	}
+	idx := slices.IndexFunc(accountDataEvents, func(ad *database.AccountData) bool {
+		return ad.Type == accountPreferencesEventType && len(ad.Content) > 0
+	})
+	if idx < 0 {
+		return prefs, nil
+	}
+	if err = json.Unmarshal(accountDataEvents[idx].Content, &prefs); err != nil {
+		return prefs, errs.Internal(fmt.Errorf("failed to parse %s: %w", accountPreferencesEventType, err))
+	}
+	if prefs.Order == nil {
+		prefs.Order = []string{}
+	}
+	if prefs.Accounts == nil {
+		prefs.Accounts = map[string]accountDisplayPreference{}
+	}
+	return prefs, nil
+}
+
+// applyAccountPreferences moves explicitly ordered accounts to the front and
+// attaches labels/colors. Accounts missing from the order keep their relative
+// position after the ordered ones.
+func applyAccountPreferences(accounts []compat.Account, prefs accountPreferences) []compat.Account {
+	rank := make(map[string]int, len(prefs.Order))
+	for idx, accountID := range prefs.Order {
+		rank[accountID] = idx
+	}
+	output := slices.Clone(accounts)
+	slices.SortStableFunc(output, func(a, b compat.Account) int {
+		rankA, okA := rank[a.AccountID]
+		rankB, okB := rank[b.AccountID]
+		switch {
+		case okA && okB:
+			return rankA - rankB
+		case okA:
+			return -1
+		case okB:
+			return 1
+		default:
+			return 0
+		}
+	})
+	for idx := range output {
+		if pref, ok := prefs.Accounts[output[idx].AccountID]; ok {
+			output[idx].Label = pref.Label
+			output[idx].Color = pref.Color
+		}
+	}
+	return output
+}
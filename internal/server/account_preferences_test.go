@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestApplyAccountPreferencesOrdersAndLabels(t *testing.T) {
+	accounts := []compat.Account{{AccountID: "a"}, {AccountID: "b"}, {AccountID: "c"}, {AccountID: "d"}}
+	prefs := accountPreferences{
+		Order:    []string{"c", "missing", "a"},
+		Accounts: map[string]accountDisplayPreference{"d": {Label: "Work", Color: "#112233"}},
+	}
+	got := applyAccountPreferences(accounts, prefs)
+	order := []string{got[0].AccountID, got[1].AccountID, got[2].AccountID, got[3].AccountID}
+	expected := []string{"c", "a", "b", "d"}
+	for idx, accountID := range expected {
+		if order[idx] != accountID {
+			t.Fatalf("expected order %v, got %v", expected, order)
+		}
+	}
+	if got[3].Label != "Work" || got[3].Color != "#112233" {
+		t.Fatalf("expected preferences on d, got %#v", got[3])
+	}
+	if accounts[0].AccountID != "a" {
+		t.Fatal("applyAccountPreferences must not reorder its input")
+	}
+}
+
+func TestNormalizeAccountPreferencesValidates(t *testing.T) {
+	if _, err := normalizeAccountPreferences(accountPreferences{Order: []string{"a", " a "}}); err == nil {
+		t.Fatal("expected duplicate order entries to be rejected")
+	}
+	if _, err := normalizeAccountPreferences(accountPreferences{Accounts: map[string]accountDisplayPreference{"a": {Color: "red"}}}); err == nil {
+		t.Fatal("expected non-hex color to be rejected")
+	}
+	prefs, err := normalizeAccountPreferences(accountPreferences{Accounts: map[string]accountDisplayPreference{"a": {Label: "  "}}})
+	if err != nil || len(prefs.Accounts) != 0 {
+		t.Fatalf("expected empty preference to be dropped, got %#v (%v)", prefs, err)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/i18n"
+)
+
+// bridgeStateTimestamp accepts the integer and fractional Unix timestamps
+// bridges write, in seconds or milliseconds.
+type bridgeStateTimestamp float64
+
+func (ts bridgeStateTimestamp) Time() *time.Time {
+	if ts <= 0 {
+		return nil
+	}
+	var at time.Time
+	if ts > 1e12 {
+		at = time.UnixMilli(int64(ts))
+	} else {
+		at = time.UnixMilli(int64(ts * 1000))
+	}
+	at = at.UTC()
+	return &at
+}
+
+// healthyBridgeStates are the states in which the bridge is delivering
+// messages for the account.
+var healthyBridgeStates = map[string]bool{
+	"RUNNING":     true,
+	"CONNECTED":   true,
+	"BACKFILLING": true,
+}
+
+func newAccountStatus(accountID, network string, account localBridgeAccount, currentDeviceID string) compat.AccountStatus {
+	state := strings.ToUpper(strings.TrimSpace(account.State))
+	status := compat.AccountStatus{
+		AccountID: accountID,
+		Network:   network,
+		State:     state,
+		Error:     account.Error,
+		Message:   account.Message,
+		Healthy:   healthyBridgeStates[state],
+		UpdatedAt: account.Timestamp.Time(),
+		Devices:   make([]compat.AccountDeviceState, 0, len(account.Devices)),
+	}
+	for deviceID, device := range account.Devices {
+		status.Devices = append(status.Devices, compat.AccountDeviceState{
+			DeviceID:      deviceID,
+			State:         strings.ToUpper(strings.TrimSpace(device.State)),
+			UpdatedAt:     device.Timestamp.Time(),
+			CurrentDevice: deviceID == currentDeviceID,
+		})
+	}
+	sort.Slice(status.Devices, func(i, j int) bool {
+		return status.Devices[i].DeviceID < status.Devices[j].DeviceID
+	})
+	return status
+}
+
+// getAccountStatus reports the raw bridge state of an account. Unlike
+// GET /v1/accounts it also answers for accounts in an error state, so
+// monitoring can alert on BAD_CREDENTIALS and similar.
+func (s *Server) getAccountStatus(w http.ResponseWriter, r *http.Request) error {
+	accountID := strings.TrimSpace(r.PathValue("accountID"))
+	bridgeID, loginID := splitDesktopAccountID(accountID)
+	if bridgeID == "" || loginID == "" {
+		return errs.NotFound("Account not found")
+	}
+	if bridgeID == "matrix" {
+		return errs.Validation(map[string]any{"accountID": "the Matrix account has no bridge state"})
+	}
+	state, err := s.loadLocalBridgeState(r.Context())
+	if err != nil {
+		return err
+	}
+	account, ok := state[bridgeID][loginID]
+	if !ok || strings.EqualFold(strings.TrimSpace(account.State), "DELETED") {
+		return errs.NotFound("Account not found")
+	}
+	currentDeviceID := string(s.rt.Client().Account.DeviceID)
+	network := i18n.FromContext(r.Context()).Network(networkFromBridgeID(bridgeID))
+	return writeJSON(w, newAccountStatus(accountID, network, account, currentDeviceID))
+}
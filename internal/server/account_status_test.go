@@ -0,0 +1,30 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewAccountStatusParsesBridgeState(t *testing.T) {
+	var state localBridgeStateContent
+	raw := `{"whatsapp":{"123":{"state":"bad_credentials","timestamp":1700000000,"error":"wa-logged-out","devices":{"B":{"state":"CONNECTED","timestamp":1700000000123},"A":{"state":"LOGGED_OUT"}}}}}`
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		t.Fatalf("failed to parse state: %v", err)
+	}
+	status := newAccountStatus("whatsapp_123", "WhatsApp", state["whatsapp"]["123"], "B")
+	if status.State != "BAD_CREDENTIALS" || status.Healthy || status.Error != "wa-logged-out" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status.UpdatedAt == nil || status.UpdatedAt.Unix() != 1700000000 {
+		t.Fatalf("unexpected updatedAt: %v", status.UpdatedAt)
+	}
+	if len(status.Devices) != 2 || status.Devices[0].DeviceID != "A" || !status.Devices[1].CurrentDevice {
+		t.Fatalf("unexpected devices: %+v", status.Devices)
+	}
+	if status.Devices[1].UpdatedAt == nil || status.Devices[1].UpdatedAt.UnixMilli() != 1700000000123 {
+		t.Fatalf("unexpected device updatedAt: %v", status.Devices[1].UpdatedAt)
+	}
+	if status.Devices[0].UpdatedAt != nil {
+		t.Fatal("expected a missing timestamp to stay unset")
+	}
+}
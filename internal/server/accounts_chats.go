@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -16,10 +17,12 @@ import (
 	"github.com/batuhan/easymatrix/internal/compat"
 	"github.com/batuhan/easymatrix/internal/cursor"
 	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/i18n"
 )
 
 const (
 	localBridgeStateEventType = "com.beeper.local_bridge_state"
+	chatReminderEventType     = "com.beeper.chats.reminder"
 	chatPageSize              = 25
 	chatPreviewParticipants   = 5
 )
@@ -36,11 +39,15 @@ const roomSelectSortedQuery = roomSelectBaseQuery + `WHERE sorting_timestamp > 0
 const roomAccountDataSelectQuery = `SELECT room_id, type, content FROM room_account_data WHERE user_id = $1`
 
 type localBridgeDeviceState struct {
-	State string `json:"state"`
+	State     string               `json:"state"`
+	Timestamp bridgeStateTimestamp `json:"timestamp,omitempty"`
 }
 
 type localBridgeAccount struct {
 	State       string                            `json:"state"`
+	Timestamp   bridgeStateTimestamp              `json:"timestamp,omitempty"`
+	Error       string                            `json:"error,omitempty"`
+	Message     string                            `json:"message,omitempty"`
 	ProfileData map[string]any                    `json:"profile_data,omitempty"`
 	Devices     map[string]localBridgeDeviceState `json:"devices,omitempty"`
 }
@@ -51,18 +58,35 @@ type accountLookup struct {
 	Accounts []compat.Account
 	ByID     map[string]compat.Account
 	ByBridge map[string][]compat.Account
+	// RoomBridges holds the m.bridge info of bridged rooms, which maps them
+	// to an account more reliably than their room ID.
+	RoomBridges map[id.RoomID]roomBridgeInfo
 }
 
 type roomAccountDataState struct {
 	IsMuted               bool
 	IsPinned              bool
 	IsLowPriority         bool
+	Tags                  []string
 	IsMarkedUnread        bool
 	MarkedUnreadUpdatedAt int64
 	ArchivedUpdatedTS     *int64
 	ArchivedAtOrder       *int64
 	SnoozeUntilMS         *int64
 	UserSnoozedAt         *int64
+	ReminderAtMS          *int64
+	// LastReadMessageSortKey comes from the user's own receipts and fully-read
+	// marker rather than account data alone; see loadOwnReadMarkers.
+	LastReadMessageSortKey string
+}
+
+// chatReminderContent accepts both the nested shape Beeper Desktop writes
+// and the flat legacy fields; setChatReminder writes both.
+type chatReminderContent struct {
+	Reminder *struct {
+		RemindAtMS *int64 `json:"remindAtMs,omitempty"`
+	} `json:"reminder,omitempty"`
+	RemindAtMS *int64 `json:"remind_at_ms,omitempty"`
 }
 
 type beeperInboxDoneContent struct {
@@ -105,6 +129,7 @@ func applyRoomAccountDataContent(state roomAccountDataState, eventType string, c
 		}
 		_, state.IsPinned = tags.Tags[event.RoomTagFavourite]
 		_, state.IsLowPriority = tags.Tags[event.RoomTagLowPriority]
+		state.Tags = sortedRoomTagNames(tags.Tags)
 	case event.AccountDataBeeperMute.Type:
 		var mute event.BeeperMuteEventContent
 		if unmarshalErr := json.Unmarshal(content, &mute); unmarshalErr != nil {
@@ -132,6 +157,15 @@ func applyRoomAccountDataContent(state roomAccountDataState, eventType string, c
 		}
 		state.SnoozeUntilMS = snoozed.SnoozedUntilMS
 		state.UserSnoozedAt = snoozed.UserSnoozedAt
+	case chatReminderEventType:
+		var reminder chatReminderContent
+		if unmarshalErr := json.Unmarshal(content, &reminder); unmarshalErr != nil {
+			return state
+		}
+		state.ReminderAtMS = reminder.RemindAtMS
+		if reminder.Reminder != nil && reminder.Reminder.RemindAtMS != nil {
+			state.ReminderAtMS = reminder.Reminder.RemindAtMS
+		}
 	case "com.famedly.marked_unread":
 		// Ignored in Beeper Desktop as well.
 	}
@@ -143,6 +177,16 @@ func (s *Server) getAccounts(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	prefs, err := s.loadAccountPreferences(r.Context())
+	if err != nil {
+		return err
+	}
+	accounts = applyAccountPreferences(accounts, prefs)
+	if policy := s.requestPolicy(r); policy != nil {
+		accounts = slices.DeleteFunc(accounts, func(account compat.Account) bool {
+			return !policy.allowsAccount(account.AccountID)
+		})
+	}
 	return writeJSON(w, accounts)
 }
 
@@ -163,6 +207,10 @@ func (s *Server) buildAccountLookup(ctx context.Context) (*accountLookup, error)
 			lookup.ByBridge[bridgeID] = append(lookup.ByBridge[bridgeID], account)
 		}
 	}
+	// Without accounts there is nothing for the bridge info to choose from.
+	if len(accounts) > 0 {
+		s.attachRoomBridges(ctx, lookup)
+	}
 	return lookup, nil
 }
 
@@ -172,23 +220,9 @@ func (s *Server) loadAccounts(ctx context.Context) ([]compat.Account, error) {
 		return []compat.Account{}, nil
 	}
 
-	accountDataEvents, err := cli.DB.AccountData.GetAllGlobal(ctx, cli.Account.UserID)
+	state, err := s.loadLocalBridgeState(ctx)
 	if err != nil {
-		return nil, errs.Internal(fmt.Errorf("failed to read global account data: %w", err))
-	}
-
-	var state localBridgeStateContent
-	for _, ad := range accountDataEvents {
-		if ad.Type != localBridgeStateEventType {
-			continue
-		}
-		if len(ad.Content) == 0 {
-			continue
-		}
-		if err = json.Unmarshal(ad.Content, &state); err != nil {
-			return nil, errs.Internal(fmt.Errorf("failed to parse %s: %w", localBridgeStateEventType, err))
-		}
-		break
+		return nil, err
 	}
 
 	accounts := make([]compat.Account, 0)
@@ -215,11 +249,11 @@ func (s *Server) loadAccounts(ctx context.Context) ([]compat.Account, error) {
 			}
 
 			desktopAccountID := bridgeID + "_" + remoteID
-			network := networkFromBridgeID(bridgeID)
+			network := i18n.FromContext(ctx).Network(networkFromBridgeID(bridgeID))
 			accounts = append(accounts, compat.Account{
 				AccountID: desktopAccountID,
 				Network:   network,
-				User:      userFromLocalBridgeProfile(remoteID, bridgeAccount.ProfileData),
+				User:      userFromLocalBridgeProfile(ctx, remoteID, bridgeAccount.ProfileData),
 			})
 		}
 	}
@@ -228,13 +262,35 @@ func (s *Server) loadAccounts(ctx context.Context) ([]compat.Account, error) {
 		accounts = append(accounts, compat.Account{
 			AccountID: "matrix_" + string(cli.Account.UserID),
 			Network:   "Matrix",
-			User:      newCompatUser(userShape{ID: string(cli.Account.UserID), IsSelf: true}),
+			User:      newCompatUser(ctx, userShape{ID: string(cli.Account.UserID), IsSelf: true}),
 		})
 	}
 
 	return accounts, nil
 }
 
+func (s *Server) loadLocalBridgeState(ctx context.Context) (localBridgeStateContent, error) {
+	cli := s.rt.Client()
+	accountDataEvents, err := cli.DB.AccountData.GetAllGlobal(ctx, cli.Account.UserID)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to read global account data: %w", err))
+	}
+	var state localBridgeStateContent
+	for _, ad := range accountDataEvents {
+		if ad.Type != localBridgeStateEventType {
+			continue
+		}
+		if len(ad.Content) == 0 {
+			continue
+		}
+		if err = json.Unmarshal(ad.Content, &state); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to parse %s: %w", localBridgeStateEventType, err))
+		}
+		break
+	}
+	return state, nil
+}
+
 func isConfiguredLocalAccount(account localBridgeAccount, deviceID string) bool {
 	state := strings.ToUpper(strings.TrimSpace(account.State))
 	if state == "" || state == "DELETED" {
@@ -275,6 +331,7 @@ func (s *Server) listChats(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	accountIDs := parseAccountIDs(r)
+	visibility := s.requestPolicy(r)
 	rooms, err := s.loadRoomsSorted(r.Context())
 	if err != nil {
 		return err
@@ -301,6 +358,9 @@ func (s *Server) listChats(w http.ResponseWriter, r *http.Request) error {
 		if len(accountIDs) > 0 && !equalsAny(chat.AccountID, accountIDs) {
 			continue
 		}
+		if !visibility.allowsChat(chat.ID, chat.AccountID) {
+			continue
+		}
 		items = append(items, chat)
 		if len(items) > chatPageSize {
 			break
@@ -311,6 +371,9 @@ func (s *Server) listChats(w http.ResponseWriter, r *http.Request) error {
 	if hasMore {
 		items = items[:chatPageSize]
 	}
+	if err = s.attachChatMetadata(r, items); err != nil {
+		return err
+	}
 
 	var oldestCursor *string
 	var newestCursor *string
@@ -342,6 +405,10 @@ func (s *Server) getChat(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	chatID, err = s.resolveLatestChatID(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
 	lookup, err := s.buildAccountLookup(r.Context())
 	if err != nil {
 		return err
@@ -362,7 +429,11 @@ func (s *Server) getChat(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	return writeJSON(w, chat)
+	chats := []compat.Chat{chat}
+	if err = s.attachChatMetadata(r, chats); err != nil {
+		return err
+	}
+	return writeJSON(w, chats[0])
 }
 
 func (s *Server) loadRoomsSorted(ctx context.Context) ([]*database.Room, error) {
@@ -418,6 +489,11 @@ func (s *Server) loadRoomAccountDataStates(ctx context.Context) (map[id.RoomID]r
 	if err = rows.Err(); err != nil {
 		return nil, errs.Internal(fmt.Errorf("room account data query failed: %w", err))
 	}
+	markers, err := s.loadOwnReadMarkers(ctx, cli.Account.UserID)
+	if err != nil {
+		return nil, err
+	}
+	applyOwnReadMarkers(states, markers)
 	return states, nil
 }
 
@@ -451,28 +527,36 @@ func roomIsNewerThanCursor(room *database.Room, c *cursor.ChatCursor) bool {
 
 func (s *Server) mapRoomToChat(ctx context.Context, room *database.Room, lookup *accountLookup, maxParticipants int, includePreview bool, roomState roomAccountDataState) (compat.Chat, error) {
 	accountID, network := inferAccountForRoom(room.ID, lookup)
-	participants, total := s.loadRoomParticipants(ctx, room)
-	filteredParticipants := participants
-	hasMoreParticipants := false
-	if maxParticipants >= 0 && len(filteredParticipants) > maxParticipants {
-		filteredParticipants = filteredParticipants[:maxParticipants]
-		hasMoreParticipants = true
+	var filteredParticipants []compat.User
+	var total int
+	var previewErr error
+	if maxParticipants >= 0 {
+		filteredParticipants, total, previewErr = s.loadParticipantPreview(ctx, room, maxParticipants)
 	}
+	if maxParticipants < 0 || previewErr != nil {
+		filteredParticipants, total = s.loadRoomParticipants(ctx, room)
+		if maxParticipants >= 0 && len(filteredParticipants) > maxParticipants {
+			filteredParticipants = filteredParticipants[:maxParticipants]
+		}
+	}
+	hasMoreParticipants := total > len(filteredParticipants)
 
 	title := strings.TrimSpace(ptrString(room.Name))
 	if title == "" {
 		title = string(room.ID)
 	}
-	chatType := "group"
+	chatType := compat.ChatTypeGroup
 	if room.DMUserID != nil && *room.DMUserID != "" {
-		chatType = "single"
+		chatType = compat.ChatTypeSingle
 	}
 
 	chat := compat.Chat{Network: network}
 	chat.ID = string(room.ID)
 	chat.AccountID = accountID
 	chat.Title = title
-	chat.Type = compat.ChatType(chatType)
+	chat.Type = chatType
+	chat.Description = strings.TrimSpace(ptrString(room.Topic))
+	chat.PredecessorChatIDs = s.loadPredecessorChatIDs(ctx, room)
 	chat.Participants = compat.Participants{
 		Items:   filteredParticipants,
 		HasMore: hasMoreParticipants,
@@ -484,6 +568,8 @@ func (s *Server) mapRoomToChat(ctx context.Context, room *database.Room, lookup
 	chat.IsPinned = roomState.IsPinned
 	chat.IsMarkedUnread = roomState.IsMarkedUnread
 	chat.IsLowPriority = roomState.IsLowPriority
+	chat.LastReadMessageSortKey = roomState.LastReadMessageSortKey
+	chat.Tags = roomState.Tags
 	if roomState.MarkedUnreadUpdatedAt > 0 {
 		chat.Extra = &compat.ChatExtra{
 			MarkedUnreadUpdatedAt: roomState.MarkedUnreadUpdatedAt,
@@ -537,7 +623,7 @@ func (s *Server) loadRoomParticipants(ctx context.Context, room *database.Room)
 			continue
 		}
 		seen[userID] = struct{}{}
-		users = append(users, userFromMemberEvent(userID, content, string(cli.Account.UserID)))
+		users = append(users, userFromMemberEvent(ctx, userID, content, string(cli.Account.UserID)))
 	}
 
 	sort.Slice(users, func(i, j int) bool {
@@ -554,6 +640,11 @@ func inferAccountForRoom(roomID id.RoomID, lookup *accountLookup) (string, strin
 	if lookup == nil || len(lookup.Accounts) == 0 {
 		return "", "Unknown"
 	}
+	if info, ok := lookup.RoomBridges[roomID]; ok {
+		if account, ok := accountFromRoomBridge(info, lookup); ok {
+			return account.AccountID, account.Network
+		}
+	}
 	server := roomServerPart(string(roomID))
 	bridgeIDs := make([]string, 0, len(lookup.ByBridge))
 	for bridgeID := range lookup.ByBridge {
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/lottie"
+	_ "golang.org/x/image/webp"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	// mimeTypeTGS is a gzipped Lottie animation, the format of Telegram's
+	// animated stickers.
+	mimeTypeTGS    = "application/x-tgsticker"
+	mimeTypeLottie = "video/lottie+json"
+
+	animationSniffBytes = 64 * 1024
+	posterRenderTimeout = 30 * time.Second
+	maxLottieBytes      = 16 * 1024 * 1024
+)
+
+var (
+	errNoPoster       = errors.New("no poster can be rendered for this file")
+	errPosterTooLarge = errors.New("animation frame is too large to decode")
+)
+
+func isLottieMimeType(mimeType string) bool {
+	switch strings.ToLower(strings.TrimSpace(mimeType)) {
+	case mimeTypeTGS, mimeTypeLottie, "application/json+lottie":
+		return true
+	default:
+		return false
+	}
+}
+
+// sniffAnimation inspects the start of a file and reports whether it is an
+// animation, along with the Lottie MIME type when it is one, since uploads
+// of those usually arrive as application/gzip or application/json.
+func sniffAnimation(header []byte) (animated bool, lottieMimeType string) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1F, 0x8B}):
+		reader, err := gzip.NewReader(bytes.NewReader(header))
+		if err != nil {
+			return false, ""
+		}
+		// A truncated header makes the reader fail at some point; whatever
+		// was decompressed until then is enough to recognize Lottie.
+		prefix, _ := io.ReadAll(io.LimitReader(reader, 4096))
+		if looksLikeLottie(prefix) {
+			return true, mimeTypeTGS
+		}
+	case looksLikeLottie(header):
+		return true, mimeTypeLottie
+	case len(header) >= 21 && string(header[:4]) == "RIFF" && string(header[8:16]) == "WEBPVP8X":
+		// The VP8X flags byte marks animated files.
+		return header[20]&0x02 != 0, ""
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return pngHasAnimationControl(header), ""
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		return gifFrameCount(header) > 1, ""
+	}
+	return false, ""
+}
+
+// looksLikeLottie recognizes Lottie JSON by its frame rate and out point
+// keys, which come before the (large) layer list.
+func looksLikeLottie(prefix []byte) bool {
+	trimmed := bytes.TrimSpace(prefix)
+	return bytes.HasPrefix(trimmed, []byte("{")) && bytes.Contains(trimmed, []byte(`"fr"`)) && bytes.Contains(trimmed, []byte(`"op"`))
+}
+
+// pngHasAnimationControl reports whether an acTL chunk, which makes a PNG an
+// APNG, appears before the image data.
+func pngHasAnimationControl(data []byte) bool {
+	for offset := 8; offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		switch string(data[offset+4 : offset+8]) {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+		offset += 12 + length
+	}
+	return false
+}
+
+// gifFrameCount walks the GIF block structure and counts image descriptors,
+// stopping early once a second frame is found or the data runs out.
+func gifFrameCount(data []byte) int {
+	if len(data) < 13 {
+		return 0
+	}
+	offset := 13
+	if data[10]&0x80 != 0 {
+		offset += 3 << (int(data[10]&0x07) + 1)
+	}
+	frames := 0
+	skipSubBlocks := func() bool {
+		for offset < len(data) {
+			size := int(data[offset])
+			offset += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for offset < len(data) && frames < 2 {
+		switch data[offset] {
+		case 0x21:
+			offset += 2
+			if !skipSubBlocks() {
+				return frames
+			}
+		case 0x2C:
+			frames++
+			if offset+10 > len(data) {
+				return frames
+			}
+			flags := data[offset+9]
+			offset += 10
+			if flags&0x80 != 0 {
+				offset += 3 << (int(flags&0x07) + 1)
+			}
+			// LZW minimum code size, then the image data sub-blocks.
+			offset++
+			if !skipSubBlocks() {
+				return frames
+			}
+		default:
+			return frames
+		}
+	}
+	return frames
+}
+
+// lottieDimensions reads the canvas size of a Lottie or TGS file.
+func lottieDimensions(data []byte) (int, int) {
+	var reader io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1F, 0x8B}) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return 0, 0
+		}
+		reader = gzipReader
+	}
+	var canvas struct {
+		Width  float64 `json:"w"`
+		Height float64 `json:"h"`
+	}
+	if err := json.NewDecoder(io.LimitReader(reader, maxLottieBytes)).Decode(&canvas); err != nil {
+		return 0, 0
+	}
+	return int(canvas.Width), int(canvas.Height)
+}
+
+func readFileHeader(filePath string, limit int64) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, limit))
+}
+
+// webpFirstFrame rewraps the first frame of an animated WebP as a still one,
+// which the x/image decoder can read. Frames drawn at an offset on a larger
+// canvas come out at their own size.
+func webpFirstFrame(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a WebP file")
+	}
+	for offset := 12; offset+8 <= len(data); {
+		fourCC := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		end := offset + 8 + size
+		if end > len(data) || size < 0 {
+			break
+		}
+		if fourCC == "ANMF" && size >= 16 {
+			frame := data[offset+8 : end]
+			width := 1 + (int(frame[6]) | int(frame[7])<<8 | int(frame[8])<<16)
+			height := 1 + (int(frame[9]) | int(frame[10])<<8 | int(frame[11])<<16)
+			if int64(width)*int64(height) > maxImageTransformSourcePixels {
+				return nil, errPosterTooLarge
+			}
+			return stillWebP(frame[16:], width, height), nil
+		}
+		offset = end + size%2
+	}
+	return nil, errors.New("no animation frame found")
+}
+
+// stillWebP wraps frame chunks (an optional ALPH chunk followed by VP8 or
+// VP8L) in a RIFF container. Lossy frames with alpha need the extended VP8X
+// header to carry the ALPH chunk.
+func stillWebP(chunks []byte, width, height int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if bytes.HasPrefix(chunks, []byte("ALPH")) {
+		header := make([]byte, 10)
+		header[0] = 0x10
+		putUint24(header[4:], width-1)
+		putUint24(header[7:], height-1)
+		body.WriteString("VP8X")
+		_ = binary.Write(&body, binary.LittleEndian, uint32(len(header)))
+		body.Write(header)
+	}
+	body.Write(chunks)
+	output := make([]byte, 0, 8+body.Len())
+	output = append(output, "RIFF"...)
+	output = binary.LittleEndian.AppendUint32(output, uint32(body.Len()))
+	return append(output, body.Bytes()...)
+}
+
+func putUint24(dst []byte, value int) {
+	dst[0] = byte(value)
+	dst[1] = byte(value >> 8)
+	dst[2] = byte(value >> 16)
+}
+
+// renderPoster rasterizes the first frame of an animated image as PNG.
+// Lottie needs the lottieconverter tool; the other formats are decoded here.
+func renderPoster(ctx context.Context, filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	animated, lottieMimeType := sniffAnimation(data[:min(len(data), animationSniffBytes)])
+	if lottieMimeType != "" {
+		if !lottie.Supported() {
+			return nil, errNoPoster
+		}
+		width, height := lottieDimensions(data)
+		if width <= 0 || height <= 0 || width > maxImageTransformDimension || height > maxImageTransformDimension {
+			width, height = 512, 512
+		}
+		ctx, cancel := context.WithTimeout(ctx, posterRenderTimeout)
+		defer cancel()
+		var output bytes.Buffer
+		if err = lottie.Convert(ctx, bytes.NewReader(data), "", &output, "png", width, height); err != nil {
+			return nil, err
+		}
+		return output.Bytes(), nil
+	}
+	if animated && bytes.HasPrefix(data, []byte("RIFF")) {
+		if data, err = webpFirstFrame(data); err != nil {
+			return nil, err
+		}
+	}
+	// The header claims the frame size, and the decoder allocates whatever
+	// it claims, so it is checked before decoding.
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errNoPoster
+	}
+	if int64(config.Width)*int64(config.Height) > maxImageTransformSourcePixels {
+		return nil, errPosterTooLarge
+	}
+	// GIF and PNG decoders return the first frame of animated files.
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errNoPoster
+	}
+	var output bytes.Buffer
+	if err = png.Encode(&output, img); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// assetPosterPath returns a cached PNG poster of an animated asset,
+// rendering it on first use. Still images are their own poster.
+func (s *Server) assetPosterPath(ctx context.Context, filePath string) (string, error) {
+	header, err := readFileHeader(filePath, animationSniffBytes)
+	if err != nil {
+		return "", errs.Internal(fmt.Errorf("failed to read asset: %w", err))
+	}
+	if animated, _ := sniffAnimation(header); !animated {
+		return filePath, nil
+	}
+	sum := sha256.Sum256([]byte(filePath + "|poster"))
+	posterPath := filepath.Join(s.assetVariantDir(), hex.EncodeToString(sum[:])+".png")
+	if _, err = os.Stat(posterPath); err == nil {
+		return posterPath, nil
+	}
+	poster, err := renderPoster(ctx, filePath)
+	if errors.Is(err, errNoPoster) {
+		return "", errs.NotFound("No poster image is available for this asset")
+	} else if errors.Is(err, errPosterTooLarge) {
+		return "", errs.New(http.StatusUnprocessableEntity, "IMAGE_TOO_LARGE", fmt.Sprintf("Animations with frames over %d pixels have no poster", maxImageTransformSourcePixels), nil)
+	} else if err != nil {
+		return "", errs.Internal(fmt.Errorf("failed to render poster: %w", err))
+	}
+	if err = os.MkdirAll(s.assetVariantDir(), 0o700); err != nil {
+		return "", errs.Internal(fmt.Errorf("failed to create asset variant dir: %w", err))
+	}
+	tempPath := posterPath + ".tmp"
+	if err = os.WriteFile(tempPath, poster, 0o600); err != nil {
+		return "", errs.Internal(fmt.Errorf("failed to write poster: %w", err))
+	}
+	if err = os.Rename(tempPath, posterPath); err != nil {
+		_ = os.Remove(tempPath)
+		return "", errs.Internal(fmt.Errorf("failed to finalize poster: %w", err))
+	}
+	return posterPath, nil
+}
+
+// attachmentIsAnimated trusts the sender's is_animated and fi.mau.gif flags
+// and Lottie MIME types, and otherwise sniffs the file if it is cached.
+func (s *Server) attachmentIsAnimated(content event.MessageEventContent, attachmentID string) bool {
+	if content.Info != nil && (content.Info.IsAnimated || content.Info.MauGIF || isLottieMimeType(content.Info.MimeType)) {
+		return true
+	}
+	normalized, _, err := parseAssetMXC(attachmentID)
+	if err != nil {
+		return false
+	}
+	blobPath, _, ok := s.lookupAssetBlob(normalized)
+	if !ok {
+		return false
+	}
+	header, err := readFileHeader(blobPath, animationSniffBytes)
+	if err != nil {
+		return false
+	}
+	animated, _ := sniffAnimation(header)
+	return animated
+}
+
+// attachStickerPoster uploads the first frame of an animated sticker as its
+// thumbnail, for clients that cannot play the animation. It is best effort:
+// the sticker is sent without a thumbnail when rendering fails.
+func (s *Server) attachStickerPoster(ctx context.Context, content *event.MessageEventContent, filePath string) {
+	poster, err := renderPoster(ctx, filePath)
+	if err != nil {
+		if !errors.Is(err, errNoPoster) {
+			log.Printf("failed to render sticker poster: %v", err)
+		}
+		return
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(poster))
+	if err != nil {
+		return
+	}
+	resp, err := s.rt.Client().Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+		ContentBytes: poster,
+		ContentType:  "image/png",
+		FileName:     "poster.png",
+	})
+	if err != nil {
+		log.Printf("failed to upload sticker poster: %v", err)
+		return
+	}
+	content.Info.ThumbnailURL = resp.ContentURI.CUString()
+	content.Info.ThumbnailInfo = &event.FileInfo{
+		MimeType: "image/png",
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+		Size:     len(poster),
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testLottie = `{"v":"5.5.2","fr":60,"ip":0,"op":180,"w":512,"h":256,"layers":[]}`
+
+func TestSniffAnimationDetectsLottie(t *testing.T) {
+	var tgs bytes.Buffer
+	writer := gzip.NewWriter(&tgs)
+	_, _ = writer.Write([]byte(testLottie))
+	_ = writer.Close()
+
+	if animated, mimeType := sniffAnimation(tgs.Bytes()); !animated || mimeType != mimeTypeTGS {
+		t.Fatalf("expected TGS, got animated=%v mimeType=%q", animated, mimeType)
+	}
+	if animated, mimeType := sniffAnimation([]byte(testLottie)); !animated || mimeType != mimeTypeLottie {
+		t.Fatalf("expected Lottie JSON, got animated=%v mimeType=%q", animated, mimeType)
+	}
+	if width, height := lottieDimensions(tgs.Bytes()); width != 512 || height != 256 {
+		t.Fatalf("unexpected TGS dimensions %dx%d", width, height)
+	}
+	if animated, _ := sniffAnimation([]byte(`{"name":"not an animation"}`)); animated {
+		t.Fatalf("expected plain JSON not to be detected as Lottie")
+	}
+}
+
+func TestSniffAnimationReadsWebPFlags(t *testing.T) {
+	header := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00")
+	header[20] = 0x02
+	if animated, _ := sniffAnimation(header); !animated {
+		t.Fatalf("expected animated WebP")
+	}
+	header[20] = 0x10
+	if animated, _ := sniffAnimation(header); animated {
+		t.Fatalf("expected WebP with only alpha to be still")
+	}
+}
+
+func TestSniffAnimationCountsGIFFrames(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	frame := image.NewPaletted(image.Rect(0, 0, 2, 2), palette)
+	var still, animated bytes.Buffer
+	if err := gif.EncodeAll(&still, &gif.GIF{Image: []*image.Paletted{frame}, Delay: []int{0}}); err != nil {
+		t.Fatalf("failed to encode GIF: %v", err)
+	}
+	if err := gif.EncodeAll(&animated, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatalf("failed to encode GIF: %v", err)
+	}
+	if ok, _ := sniffAnimation(still.Bytes()); ok {
+		t.Fatalf("expected single frame GIF to be still")
+	}
+	if ok, _ := sniffAnimation(animated.Bytes()); !ok {
+		t.Fatalf("expected two frame GIF to be animated")
+	}
+}
+
+func TestSniffAnimationIgnoresStillPNG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	if animated, _ := sniffAnimation(encoded.Bytes()); animated {
+		t.Fatalf("expected plain PNG to be still")
+	}
+	apng := append([]byte{}, encoded.Bytes()[:33]...)
+	apng = append(apng, 0, 0, 0, 8, 'a', 'c', 'T', 'L', 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0)
+	apng = append(apng, encoded.Bytes()[33:]...)
+	if animated, _ := sniffAnimation(apng); !animated {
+		t.Fatalf("expected acTL chunk to mark an APNG")
+	}
+}
+
+func TestRenderPosterRefusesHugeFramesBeforeDecoding(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	frame := image.NewPaletted(image.Rect(0, 0, 2, 2), palette)
+	var encoded bytes.Buffer
+	if err := gif.EncodeAll(&encoded, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatalf("failed to encode GIF: %v", err)
+	}
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.gif")
+	if err := os.WriteFile(small, encoded.Bytes(), 0o600); err != nil {
+		t.Fatalf("failed to write GIF: %v", err)
+	}
+	if poster, err := renderPoster(context.Background(), small); err != nil || len(poster) == 0 {
+		t.Fatalf("expected a poster for a small GIF, got %d bytes, %v", len(poster), err)
+	}
+
+	// Claim a 20000x20000 logical screen in the header.
+	huge := append([]byte{}, encoded.Bytes()...)
+	huge[6], huge[7], huge[8], huge[9] = 0x20, 0x4E, 0x20, 0x4E
+	hugePath := filepath.Join(dir, "huge.gif")
+	if err := os.WriteFile(hugePath, huge, 0o600); err != nil {
+		t.Fatalf("failed to write GIF: %v", err)
+	}
+	if _, err := renderPoster(context.Background(), hugePath); !errors.Is(err, errPosterTooLarge) {
+		t.Fatalf("expected an oversized GIF to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// assetReferenceRoomsQuery lists the rooms whose events reference a media
+// URI. gomuks records a reference for attachments, thumbnails, link preview
+// images and room and member avatars.
+const assetReferenceRoomsQuery = `
+	SELECT DISTINCT event.room_id
+	FROM media_reference
+	JOIN event ON event.rowid = media_reference.event_rowid
+	WHERE media_reference.media_mxc = $1
+`
+
+// authorizeAssetURL keeps callers confined by a policy to media from chats
+// they can see. An mxc URI must be referenced in a visible room and an export
+// file must come from a visible chat. Uploads and cached blobs are addressed
+// by random IDs and content digests that are only handed out alongside
+// visible messages. Malformed URLs pass through for the resolver to reject.
+func (s *Server) authorizeAssetURL(r *http.Request, raw string) error {
+	policy := s.requestPolicy(r)
+	if policy == nil {
+		return nil
+	}
+	if strings.HasPrefix(raw, "file://") {
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return nil
+		}
+		exportID, ok := s.exportIDFromPath(filepath.Clean(parsed.Path))
+		if !ok {
+			return nil
+		}
+		if chatID, found := s.exportChatID(exportID); !found || !policy.allowsChat(chatID, s.chatAccountID(r.Context(), chatID)) {
+			return errs.NotFound("Asset not found")
+		}
+		return nil
+	}
+	normalized, _, err := parseAssetMXC(raw)
+	if err != nil {
+		return nil
+	}
+	rows, err := s.rt.Client().DB.Query(r.Context(), assetReferenceRoomsQuery, normalized)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to query media references: %w", err))
+	}
+	defer rows.Close()
+	var roomIDs []id.RoomID
+	for rows.Next() {
+		var roomID id.RoomID
+		if err = rows.Scan(&roomID); err != nil {
+			return errs.Internal(fmt.Errorf("failed to scan media reference: %w", err))
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	if err = rows.Err(); err != nil {
+		return errs.Internal(fmt.Errorf("media reference query failed: %w", err))
+	}
+	if len(roomIDs) > 0 {
+		lookup, lookupErr := s.buildAccountLookup(r.Context())
+		if lookupErr != nil {
+			return lookupErr
+		}
+		for _, roomID := range roomIDs {
+			accountID, _ := inferAccountForRoom(roomID, lookup)
+			if policy.allowsChat(string(roomID), accountID) {
+				return nil
+			}
+		}
+	}
+	return errs.NotFound("Asset not found")
+}
+
+// exportIDFromPath returns the export a path inside the exports dir belongs
+// to.
+func (s *Server) exportIDFromPath(path string) (string, bool) {
+	exportRoot, err := filepath.Abs(s.exportRootDir())
+	if err != nil {
+		return "", false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(exportRoot, absPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return strings.Split(rel, string(filepath.Separator))[0], true
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/config"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func TestAuthorizeAssetURLChecksExportChat(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), MatrixHomeserverURL: "https://matrix.beeper.com"}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	s := New(cfg, rt)
+	if err = s.clientPolicies.put(compat.ClientAccessPolicy{ClientID: "agent", Read: compat.ClientAccessRule{ChatIDs: []string{"!visible:example.org"}}}); err != nil {
+		t.Fatalf("failed to store policy: %v", err)
+	}
+	for exportID, chatID := range map[string]string{"visible": "!visible:example.org", "hidden": "!hidden:example.org"} {
+		dir := filepath.Join(s.exportRootDir(), exportID)
+		if err = os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatalf("failed to create export dir: %v", err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, exportChatIDFile), []byte(chatID), 0o600); err != nil {
+			t.Fatalf("failed to write export chat: %v", err)
+		}
+	}
+
+	agent := func(context.Context, string, *http.Request) (*mcpauth.TokenInfo, error) {
+		return &mcpauth.TokenInfo{Expiration: time.Now().Add(time.Hour), Extra: map[string]any{"client_id": "agent"}}, nil
+	}
+	exportURL := func(exportID string) string {
+		return fileURLFromPath(filepath.Join(s.exportRootDir(), exportID, "transcript.json"))
+	}
+	var errs []error
+	handler := mcpauth.RequireBearerToken(agent, nil)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		for _, exportID := range []string{"visible", "hidden", "unknown"} {
+			errs = append(errs, s.authorizeAssetURL(r, exportURL(exportID)))
+		}
+	}))
+	req := httptest.NewRequest("GET", "/v1/assets/serve", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] == nil {
+		t.Fatalf("expected only the visible chat's export to be allowed, got %v", errs)
+	}
+	if err = s.authorizeAssetURL(httptest.NewRequest("GET", "/v1/assets/serve", nil), exportURL("hidden")); err != nil {
+		t.Fatalf("expected the owner to reach every export: %v", err)
+	}
+}
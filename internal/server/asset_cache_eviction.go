@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	defaultAssetCacheMaxBytes = int64(5 * 1024 * 1024 * 1024)
+	assetEvictionInterval     = time.Hour
+	// assetTouchInterval limits how often a hit rewrites the modification
+	// time, which doubles as the access time for eviction. Many filesystems
+	// are mounted noatime, so the real access time cannot be relied on.
+	assetTouchInterval = time.Hour
+)
+
+type cachedAssetFile struct {
+	path    string
+	size    int64
+	touched time.Time
+}
+
+func (s *Server) assetCacheMaxBytes() int64 {
+	if s.cfg.AssetCacheMaxBytes > 0 {
+		return s.cfg.AssetCacheMaxBytes
+	}
+	return defaultAssetCacheMaxBytes
+}
+
+// touchAsset marks a cached file as recently used.
+func (s *Server) touchAsset(filePath string) {
+	if !strings.HasPrefix(filePath, s.assetCacheDir()+string(os.PathSeparator)) {
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || time.Since(info.ModTime()) < assetTouchInterval {
+		return
+	}
+	now := time.Now()
+	_ = os.Chtimes(filePath, now, now)
+}
+
+// pickAssetEvictions returns the least recently used files to remove so the
+// rest fit in maxBytes, along with the total size before eviction.
+func pickAssetEvictions(files []cachedAssetFile, maxBytes int64) ([]cachedAssetFile, int64) {
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	if total <= maxBytes {
+		return nil, total
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].touched.Before(files[j].touched)
+	})
+	var evict []cachedAssetFile
+	remaining := total
+	for _, file := range files {
+		if remaining <= maxBytes {
+			break
+		}
+		evict = append(evict, file)
+		remaining -= file.size
+	}
+	return evict, total
+}
+
+// evictAssetCache evicts blobs, variants and posters until the cache fits
+// its budget, then drops mxc index entries whose blob is gone. Files still
+// being written (.tmp) are left alone.
+func (s *Server) evictAssetCache() (compat.PruneAssetCacheOutput, error) {
+	s.assetPruneMu.Lock()
+	defer s.assetPruneMu.Unlock()
+
+	output := compat.PruneAssetCacheOutput{MaxBytes: s.assetCacheMaxBytes()}
+	root := s.assetCacheDir()
+	indexDir := s.assetIndexDir()
+	var files []cachedAssetFile
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if path == indexDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return nil
+		}
+		files = append(files, cachedAssetFile{path: path, size: info.Size(), touched: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return output, err
+	}
+
+	evict, total := pickAssetEvictions(files, output.MaxBytes)
+	output.CacheBytes = total
+	for _, file := range evict {
+		if removeErr := os.Remove(file.path); removeErr != nil && !os.IsNotExist(removeErr) {
+			continue
+		}
+		output.ReclaimedBytes += file.size
+		output.RemovedFiles++
+		output.CacheBytes -= file.size
+	}
+	if output.RemovedFiles > 0 {
+		s.pruneAssetIndex()
+	}
+	return output, nil
+}
+
+// pruneAssetIndex drops mxc index entries whose blob is gone, so lookups
+// fall through to a fresh download. It returns how many were removed.
+func (s *Server) pruneAssetIndex() int {
+	indexDir := s.assetIndexDir()
+	entries, err := os.ReadDir(indexDir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		indexPath := filepath.Join(indexDir, entry.Name())
+		raw, readErr := os.ReadFile(indexPath)
+		if readErr != nil {
+			continue
+		}
+		digest := strings.TrimSpace(string(raw))
+		if _, statErr := os.Stat(filepath.Join(s.assetBlobDir(), digest)); !contentHashPattern.MatchString(digest) || os.IsNotExist(statErr) {
+			if os.Remove(indexPath) == nil {
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+func (s *Server) runAssetCacheEviction(ctx context.Context) {
+	ticker := time.NewTicker(assetEvictionInterval)
+	defer ticker.Stop()
+	for {
+		if output, err := s.evictAssetCache(); err != nil {
+			log.Printf("asset cache eviction failed: %v", err)
+		} else if output.RemovedFiles > 0 {
+			log.Printf("evicted %d cached assets (%d bytes)", output.RemovedFiles, output.ReclaimedBytes)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) pruneAssetCache(w http.ResponseWriter, r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Asset cache pruning is only available to the deployment owner")
+	}
+	output, err := s.evictAssetCache()
+	if err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, output)
+}
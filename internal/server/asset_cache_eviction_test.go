@@ -0,0 +1,22 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPickAssetEvictionsRemovesLeastRecentlyUsed(t *testing.T) {
+	now := time.Now()
+	files := []cachedAssetFile{
+		{path: "recent", size: 40, touched: now},
+		{path: "oldest", size: 30, touched: now.Add(-3 * time.Hour)},
+		{path: "older", size: 30, touched: now.Add(-2 * time.Hour)},
+	}
+	evict, total := pickAssetEvictions(files, 50)
+	if total != 100 || len(evict) != 2 || evict[0].path != "oldest" || evict[1].path != "older" {
+		t.Fatalf("unexpected evictions %v (total %d)", evict, total)
+	}
+	if evict, _ = pickAssetEvictions(files, 100); len(evict) != 0 {
+		t.Fatalf("expected nothing to evict under budget, got %v", evict)
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// serveAssetFile serves a file from the upload or asset cache with
+// validators, so media players can seek with Range requests and clients can
+// revalidate without downloading again. http.ServeFile already answers Range,
+// HEAD and If-Modified-Since; If-None-Match only works once the ETag is set.
+func (s *Server) serveAssetFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	s.touchAsset(filePath)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		http.ServeFile(w, r, filePath)
+		return
+	}
+	// Blobs are named by their SHA-256 and never change. Uploads can be
+	// rewritten in place before sending, so they are revalidated instead.
+	if filepath.Dir(filePath) == s.assetBlobDir() {
+		w.Header().Set("ETag", `"`+contentHashID(filepath.Base(filePath))+`"`)
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	http.ServeFile(w, r, filePath)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/config"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func TestServeAssetFileSupportsRangeAndETag(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), MatrixHomeserverURL: "https://matrix.beeper.com"}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	s := New(cfg, rt)
+	digest := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	blobPath := filepath.Join(s.assetBlobDir(), digest)
+	if err = os.MkdirAll(filepath.Dir(blobPath), 0o700); err != nil {
+		t.Fatalf("failed to create blob dir: %v", err)
+	}
+	if err = os.WriteFile(blobPath, []byte("hello"), 0o600); err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/assets/serve", nil)
+	req.Header.Set("Range", "bytes=1-3")
+	rec := httptest.NewRecorder()
+	s.serveAssetFile(rec, req, blobPath)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "ell" {
+		t.Fatalf("unexpected range response %d %q", rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag != `"sha256:`+digest+`"` {
+		t.Fatalf("unexpected ETag %q", etag)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/assets/serve", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.serveAssetFile(rec, req, blobPath)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rec.Code)
+	}
+}
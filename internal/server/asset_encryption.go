@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/id"
+)
+
+var errAssetIntegrity = errors.New("encrypted asset failed its integrity check")
+
+// encryptedAsset returns the decryption keys and MIME type of an attachment
+// from an encrypted room. gomuks records them in its media table for every
+// file it sees in the timeline; a nil file means the asset is plaintext.
+func (s *Server) encryptedAsset(ctx context.Context, mxc id.ContentURI) (*attachment.EncryptedFile, string) {
+	cli := s.rt.Client()
+	if cli == nil || cli.DB == nil {
+		return nil, ""
+	}
+	media, err := cli.DB.Media.Get(ctx, mxc)
+	if err != nil || media == nil || media.EncFile == nil {
+		return nil, ""
+	}
+	return media.EncFile, media.MimeType
+}
+
+// copyAssetBody writes at most maxBytes+1 bytes of body to dst, so callers
+// can tell an oversized asset from one that fits exactly. Encrypted assets
+// are decrypted on the way and their SHA-256 hash is checked at the end,
+// before anything is cached.
+func copyAssetBody(dst io.Writer, body io.Reader, encFile *attachment.EncryptedFile, maxBytes int64) (int64, error) {
+	limited := io.LimitReader(body, maxBytes+1)
+	if encFile == nil {
+		return io.Copy(dst, limited)
+	}
+	if err := encFile.PrepareForDecryption(); err != nil {
+		return 0, fmt.Errorf("unsupported encrypted asset: %w", err)
+	}
+	decrypter := encFile.DecryptStream(limited)
+	written, err := io.Copy(dst, decrypter)
+	if err != nil || written > maxBytes {
+		return written, err
+	}
+	if err = decrypter.Close(); err != nil {
+		return written, errAssetIntegrity
+	}
+	return written, nil
+}
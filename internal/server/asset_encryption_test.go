@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+)
+
+func TestCopyAssetBodyDecryptsAndVerifies(t *testing.T) {
+	plaintext := []byte("hello from an encrypted room")
+	sender := attachment.NewEncryptedFile()
+	ciphertext := sender.Encrypt(plaintext)
+	// Receivers only have the keys from the event content.
+	raw, _ := json.Marshal(sender)
+	var encFile *attachment.EncryptedFile
+	if err := json.Unmarshal(raw, &encFile); err != nil {
+		t.Fatalf("failed to round-trip keys: %v", err)
+	}
+
+	var output bytes.Buffer
+	written, err := copyAssetBody(&output, bytes.NewReader(ciphertext), encFile, 1024)
+	if err != nil || written != int64(len(plaintext)) || !bytes.Equal(output.Bytes(), plaintext) {
+		t.Fatalf("unexpected decryption result %q (%d bytes, err=%v)", output.String(), written, err)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[0] ^= 0xFF
+	output.Reset()
+	if _, err = copyAssetBody(&output, bytes.NewReader(tampered), encFile, 1024); !errors.Is(err, errAssetIntegrity) {
+		t.Fatalf("expected integrity error, got %v", err)
+	}
+}
+
+func TestCopyAssetBodyStopsAfterLimit(t *testing.T) {
+	var output bytes.Buffer
+	written, err := copyAssetBody(&output, bytes.NewReader(make([]byte, 100)), nil, 10)
+	if err != nil || written != 11 {
+		t.Fatalf("expected copy to stop one byte past the limit, got %d (err=%v)", written, err)
+	}
+}
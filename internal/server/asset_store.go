@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const contentHashPrefix = "sha256:"
+
+var contentHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// The asset cache stores each distinct file once under blobs/<sha256> and
+// keeps a small by-mxc index pointing at it, so the same file re-sent under a
+// new mxc URI is neither downloaded into a second copy nor given a new hash.
+
+func contentHashID(hexDigest string) string {
+	return contentHashPrefix + hexDigest
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func (s *Server) assetBlobDir() string {
+	return filepath.Join(s.assetCacheDir(), "blobs")
+}
+
+func (s *Server) assetIndexDir() string {
+	return filepath.Join(s.assetCacheDir(), "by-mxc")
+}
+
+func (s *Server) assetIndexPath(normalizedMXC string) string {
+	sum := sha256.Sum256([]byte(normalizedMXC))
+	return filepath.Join(s.assetIndexDir(), hex.EncodeToString(sum[:]))
+}
+
+// lookupAssetBlob returns the cached blob for an mxc URI along with its hex
+// digest. A stale index entry whose blob has been removed is a miss.
+func (s *Server) lookupAssetBlob(normalizedMXC string) (string, string, bool) {
+	raw, err := os.ReadFile(s.assetIndexPath(normalizedMXC))
+	if err != nil {
+		return "", "", false
+	}
+	digest := strings.TrimSpace(string(raw))
+	if !contentHashPattern.MatchString(digest) {
+		return "", "", false
+	}
+	blobPath := filepath.Join(s.assetBlobDir(), digest)
+	if _, err = os.Stat(blobPath); err != nil {
+		return "", "", false
+	}
+	return blobPath, digest, true
+}
+
+// storeAssetBlob moves srcPath into the blob store under its digest and
+// indexes it by mxc URI. If an identical blob already exists, srcPath is
+// discarded instead.
+func (s *Server) storeAssetBlob(normalizedMXC, srcPath, digest string) (string, error) {
+	if err := os.MkdirAll(s.assetBlobDir(), 0o700); err != nil {
+		return "", fmt.Errorf("failed to create asset blob dir: %w", err)
+	}
+	blobPath := filepath.Join(s.assetBlobDir(), digest)
+	if _, err := os.Stat(blobPath); err == nil {
+		_ = os.Remove(srcPath)
+	} else if err = os.Rename(srcPath, blobPath); err != nil {
+		return "", fmt.Errorf("failed to finalize cached asset: %w", err)
+	}
+	if err := s.indexAssetBlob(normalizedMXC, digest); err != nil {
+		return "", err
+	}
+	return blobPath, nil
+}
+
+func (s *Server) indexAssetBlob(normalizedMXC, digest string) error {
+	indexPath := s.assetIndexPath(normalizedMXC)
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create asset index dir: %w", err)
+	}
+	if err := writeAtomicFile(indexPath, []byte(digest), 0o600); err != nil {
+		return fmt.Errorf("failed to write asset index: %w", err)
+	}
+	return nil
+}
+
+// rememberUploadedAsset seeds the cache with a file we just sent, so the
+// echoed event resolves locally and carries its content hash immediately.
+// The staged upload is hard-linked rather than moved since it may be sent
+// again; failures only cost a later re-download.
+func (s *Server) rememberUploadedAsset(uri id.ContentURI, meta uploadMetadata) {
+	digest := meta.ContentHash
+	if !contentHashPattern.MatchString(digest) {
+		return
+	}
+	if err := os.MkdirAll(s.assetBlobDir(), 0o700); err != nil {
+		return
+	}
+	blobPath := filepath.Join(s.assetBlobDir(), digest)
+	if _, err := os.Stat(blobPath); err != nil {
+		if err = os.Link(meta.FilePath, blobPath); err != nil {
+			return
+		}
+	}
+	_ = s.indexAssetBlob(string(uri.CUString()), digest)
+}
+
+// assetContentHash reports the content hash of an already cached asset. It
+// never downloads, so uncached attachments simply have no hash yet.
+func (s *Server) assetContentHash(raw string) string {
+	normalized, _, err := parseAssetMXC(raw)
+	if err != nil {
+		return ""
+	}
+	if _, digest, ok := s.lookupAssetBlob(normalized); ok {
+		return contentHashID(digest)
+	}
+	return ""
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashFileMatchesContentHashFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := hashFile(path)
+	if err != nil {
+		t.Fatalf("hashFile returned error: %v", err)
+	}
+	if !contentHashPattern.MatchString(digest) {
+		t.Fatalf("digest %q does not match the blob name pattern", digest)
+	}
+	if got := contentHashID(digest); got != "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Fatalf("unexpected content hash %q", got)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// assetTooLargeError reports an asset over the cache budget. A size of -1
+// means the server sent no Content-Length and the copy hit the limit.
+type assetTooLargeError struct {
+	mxc   id.ContentURI
+	size  int64
+	limit int64
+}
+
+func (e *assetTooLargeError) Error() string {
+	if e.size < 0 {
+		return fmt.Sprintf("asset is larger than the %d byte download limit; use /v1/assets/serve to stream it", e.limit)
+	}
+	return fmt.Sprintf("asset is %d bytes, over the %d byte download limit; use /v1/assets/serve to stream it", e.size, e.limit)
+}
+
+func (s *Server) assetDownloadBudget() (int64, time.Duration) {
+	maxBytes := s.cfg.AssetMaxDownloadBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultAssetMaxDownloadBytes
+	}
+	timeout := s.cfg.AssetDownloadTimeout
+	if timeout <= 0 {
+		timeout = defaultAssetDownloadTimeout
+	}
+	return maxBytes, timeout
+}
+
+// streamAsset proxies an oversized asset straight from the homeserver without
+// touching the cache. It is bounded only by the client's own request.
+func (s *Server) streamAsset(w http.ResponseWriter, r *http.Request, mxc id.ContentURI) error {
+	resp, err := s.rt.Client().Client.Download(r.Context(), mxc)
+	if err != nil {
+		return errs.NotFound(fmt.Sprintf("failed to download asset: %v", err))
+	}
+	defer resp.Body.Close()
+	for _, header := range []string{"Content-Type", "Content-Disposition", "Last-Modified"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	// Encrypted media is stored as opaque ciphertext. AES-CTR keeps the
+	// length, but the hash can only be checked once everything is read, so
+	// the tail of the file is held back until then.
+	var decrypter io.ReadCloser
+	if encFile, mimeType := s.encryptedAsset(r.Context(), mxc); encFile != nil {
+		if err = encFile.PrepareForDecryption(); err != nil {
+			return errs.Internal(fmt.Errorf("unsupported encrypted asset: %w", err))
+		}
+		decrypter = encFile.DecryptStream(resp.Body)
+		if mimeType != "" {
+			w.Header().Set("Content-Type", mimeType)
+		}
+	}
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if decrypter == nil {
+		_, _ = io.Copy(w, resp.Body)
+		return nil
+	}
+	if err = copyVerifiedStream(w, decrypter); errors.Is(err, errAssetIntegrity) {
+		// The status is already out. Aborting drops the connection short of
+		// Content-Length, so the client cannot mistake the file for whole.
+		log.Printf("streamed asset %s failed its integrity check", mxc)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+// streamHoldBack is how much of a streamed encrypted asset is kept back until
+// its hash has been checked.
+const streamHoldBack = 32 * 1024
+
+// copyVerifiedStream copies a decrypting stream to w but only writes its last
+// streamHoldBack bytes once Close has verified the hash. A mismatch returns
+// errAssetIntegrity with the tail unsent.
+func copyVerifiedStream(w io.Writer, decrypter io.ReadCloser) error {
+	held := &holdBackWriter{w: w, hold: streamHoldBack}
+	if _, err := io.Copy(held, decrypter); err != nil {
+		return err
+	}
+	if err := decrypter.Close(); err != nil {
+		return errAssetIntegrity
+	}
+	_, err := w.Write(held.tail)
+	return err
+}
+
+// holdBackWriter forwards everything written to it except the last hold
+// bytes, which stay in tail.
+type holdBackWriter struct {
+	w    io.Writer
+	hold int
+	tail []byte
+}
+
+func (h *holdBackWriter) Write(p []byte) (int, error) {
+	h.tail = append(h.tail, p...)
+	if extra := len(h.tail) - h.hold; extra > 0 {
+		if _, err := h.w.Write(h.tail[:extra]); err != nil {
+			return 0, err
+		}
+		h.tail = append(h.tail[:0], h.tail[extra:]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+
+	"github.com/batuhan/easymatrix/internal/config"
+)
+
+func TestAssetDownloadBudgetDefaults(t *testing.T) {
+	s := &Server{cfg: config.Config{}}
+	maxBytes, timeout := s.assetDownloadBudget()
+	if maxBytes != defaultAssetMaxDownloadBytes || timeout != defaultAssetDownloadTimeout {
+		t.Fatalf("expected defaults, got %d / %s", maxBytes, timeout)
+	}
+	s.cfg.AssetMaxDownloadBytes = 1024
+	if maxBytes, _ = s.assetDownloadBudget(); maxBytes != 1024 {
+		t.Fatalf("expected configured limit, got %d", maxBytes)
+	}
+}
+
+func TestAssetTooLargeErrorMentionsLimit(t *testing.T) {
+	err := &assetTooLargeError{size: 4096, limit: 1024}
+	if !strings.Contains(err.Error(), "4096") || !strings.Contains(err.Error(), "1024") {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+}
+
+func TestCopyVerifiedStreamHoldsBackTailUntilHashChecks(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("encrypted room media "), 4096)
+	sender := attachment.NewEncryptedFile()
+	ciphertext := sender.Encrypt(plaintext)
+	raw, _ := json.Marshal(sender)
+	decrypter := func(body []byte) io.ReadCloser {
+		var encFile *attachment.EncryptedFile
+		if err := json.Unmarshal(raw, &encFile); err != nil {
+			t.Fatalf("failed to round-trip keys: %v", err)
+		}
+		if err := encFile.PrepareForDecryption(); err != nil {
+			t.Fatalf("failed to prepare keys: %v", err)
+		}
+		return encFile.DecryptStream(bytes.NewReader(body))
+	}
+
+	var output bytes.Buffer
+	if err := copyVerifiedStream(&output, decrypter(ciphertext)); err != nil || !bytes.Equal(output.Bytes(), plaintext) {
+		t.Fatalf("expected the whole file, got %d bytes (err=%v)", output.Len(), err)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[0] ^= 0xFF
+	output.Reset()
+	if err := copyVerifiedStream(&output, decrypter(tampered)); !errors.Is(err, errAssetIntegrity) {
+		t.Fatalf("expected integrity error, got %v", err)
+	}
+	if want := len(plaintext) - streamHoldBack; output.Len() != want {
+		t.Fatalf("expected the last %d bytes to be held back, wrote %d of %d", streamHoldBack, output.Len(), len(plaintext))
+	}
+}
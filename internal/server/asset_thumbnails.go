@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const defaultThumbnailSize = 320
+
+// parseThumbnailTransform is parseImageTransform with a default box, since a
+// thumbnail without a size would just be the original.
+func parseThumbnailTransform(query url.Values) (*imageTransform, error) {
+	transform, err := parseImageTransform(query)
+	if err != nil {
+		return nil, err
+	}
+	if transform == nil {
+		transform = &imageTransform{Width: defaultThumbnailSize, Height: defaultThumbnailSize, Fit: "contain"}
+	}
+	return transform, nil
+}
+
+// getAssetThumbnail serves a resized preview of an uploaded or Matrix image,
+// cached next to the other asset variants. Animated images are previewed by
+// their first frame. Originals over the cache budget are thumbnailed by the
+// homeserver instead, unless they are encrypted.
+func (s *Server) getAssetThumbnail(w http.ResponseWriter, r *http.Request) error {
+	assetURL := strings.TrimSpace(r.URL.Query().Get("url"))
+	if assetURL == "" {
+		return errs.Validation(map[string]any{"url": "url is required"})
+	}
+	transform, err := parseThumbnailTransform(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	if err = s.authorizeAssetURL(r, assetURL); err != nil {
+		return err
+	}
+	filePath, err := s.resolveServePath(r.Context(), assetURL)
+	var tooLarge *assetTooLargeError
+	if errors.As(err, &tooLarge) {
+		return s.streamHomeserverThumbnail(w, r, tooLarge.mxc, transform)
+	} else if err != nil {
+		return err
+	}
+	if filePath, err = s.assetPosterPath(r.Context(), filePath); err != nil {
+		return err
+	}
+	if width, height := imageDimensions(filePath); width == 0 || height == 0 {
+		return errs.New(http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA", "Thumbnails are only available for images", nil)
+	}
+	return s.serveAssetVariant(w, r, filePath, transform)
+}
+
+func (s *Server) streamHomeserverThumbnail(w http.ResponseWriter, r *http.Request, mxc id.ContentURI, transform *imageTransform) error {
+	if encFile, _ := s.encryptedAsset(r.Context(), mxc); encFile != nil {
+		return errs.New(http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA", "Encrypted media over the download limit cannot be thumbnailed", nil)
+	}
+	width, height := transform.Width, transform.Height
+	if width == 0 {
+		width = height
+	} else if height == 0 {
+		height = width
+	}
+	resp, err := s.rt.Client().Client.DownloadThumbnail(r.Context(), mxc, height, width)
+	if err != nil {
+		return errs.NotFound(fmt.Sprintf("failed to download thumbnail: %v", err))
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, resp.Body)
+	return nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const maxImageTransformDimension = 4096
+
+// maxImageTransformSourcePixels bounds the images decoded for resizing. A
+// small file can declare huge dimensions and expand to gigabytes in memory.
+const maxImageTransformSourcePixels = 50_000_000
+
+// imageTransform describes a resized variant requested through
+// /v1/assets/serve. "contain" fits inside the box keeping the aspect ratio and
+// never upscales; "cover" fills the box exactly, cropping around the center.
+type imageTransform struct {
+	Width  int
+	Height int
+	Fit    string
+}
+
+func parseImageTransform(query url.Values) (*imageTransform, error) {
+	rawWidth := strings.TrimSpace(query.Get("width"))
+	rawHeight := strings.TrimSpace(query.Get("height"))
+	fit := strings.TrimSpace(query.Get("fit"))
+	if rawWidth == "" && rawHeight == "" {
+		if fit != "" {
+			return nil, errs.Validation(map[string]any{"fit": "fit requires width or height"})
+		}
+		return nil, nil
+	}
+	transform := &imageTransform{Fit: fit}
+	var err error
+	if transform.Width, err = parseImageDimension(rawWidth, "width"); err != nil {
+		return nil, err
+	}
+	if transform.Height, err = parseImageDimension(rawHeight, "height"); err != nil {
+		return nil, err
+	}
+	switch transform.Fit {
+	case "":
+		transform.Fit = "contain"
+	case "contain":
+	case "cover":
+		if transform.Width == 0 || transform.Height == 0 {
+			return nil, errs.Validation(map[string]any{"fit": "cover requires both width and height"})
+		}
+	default:
+		return nil, errs.Validation(map[string]any{"fit": "must be one of: contain, cover"})
+	}
+	return transform, nil
+}
+
+func parseImageDimension(raw, field string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 || value > maxImageTransformDimension {
+		return 0, errs.Validation(map[string]any{field: fmt.Sprintf("must be an integer between 1 and %d", maxImageTransformDimension)})
+	}
+	return value, nil
+}
+
+func (t *imageTransform) apply(img image.Image) image.Image {
+	bounds := img.Bounds()
+	switch {
+	case t.Fit == "cover":
+		return imaging.Fill(img, t.Width, t.Height, imaging.Center, imaging.Lanczos)
+	case t.Width > 0 && t.Height > 0:
+		return imaging.Fit(img, t.Width, t.Height, imaging.Lanczos)
+	case t.Width > 0 && t.Width < bounds.Dx():
+		return imaging.Resize(img, t.Width, 0, imaging.Lanczos)
+	case t.Height > 0 && t.Height < bounds.Dy():
+		return imaging.Resize(img, 0, t.Height, imaging.Lanczos)
+	default:
+		return img
+	}
+}
+
+func (s *Server) assetVariantDir() string {
+	return filepath.Join(s.assetCacheDir(), "variants")
+}
+
+// serveAssetVariant serves a resized copy of a cached image, generating it on
+// first use. Files that are not decodable images are served unchanged, and
+// images over maxImageTransformSourcePixels are refused before decoding.
+func (s *Server) serveAssetVariant(w http.ResponseWriter, r *http.Request, filePath string, transform *imageTransform) error {
+	format, err := imaging.FormatFromFilename(filePath)
+	if err != nil || format != imaging.JPEG {
+		format = imaging.PNG
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%s", filePath, transform.Width, transform.Height, transform.Fit)))
+	variantPath := filepath.Join(s.assetVariantDir(), hex.EncodeToString(sum[:])+"."+strings.ToLower(format.String()))
+	if _, statErr := os.Stat(variantPath); statErr == nil {
+		s.serveAssetFile(w, r, variantPath)
+		return nil
+	}
+
+	width, height := imageDimensions(filePath)
+	if width == 0 || height == 0 {
+		s.serveAssetFile(w, r, filePath)
+		return nil
+	}
+	if int64(width)*int64(height) > maxImageTransformSourcePixels {
+		return errs.New(http.StatusUnprocessableEntity, "IMAGE_TOO_LARGE", fmt.Sprintf("Images over %d pixels cannot be resized", maxImageTransformSourcePixels), nil)
+	}
+	src, err := imaging.Open(filePath, imaging.AutoOrientation(true))
+	if err != nil {
+		s.serveAssetFile(w, r, filePath)
+		return nil
+	}
+	if err = os.MkdirAll(s.assetVariantDir(), 0o700); err != nil {
+		return errs.Internal(fmt.Errorf("failed to create asset variant dir: %w", err))
+	}
+	// Concurrent requests for the same variant each write their own temp
+	// file; whichever rename lands last wins with identical content.
+	file, err := os.CreateTemp(s.assetVariantDir(), ".variant-*.tmp")
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to create asset variant: %w", err))
+	}
+	tempPath := file.Name()
+	err = imaging.Encode(file, transform.apply(src), format, imaging.JPEGQuality(85))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return errs.Internal(fmt.Errorf("failed to write asset variant: %w", err))
+	}
+	if err = os.Rename(tempPath, variantPath); err != nil {
+		_ = os.Remove(tempPath)
+		return errs.Internal(fmt.Errorf("failed to finalize asset variant: %w", err))
+	}
+	s.serveAssetFile(w, r, variantPath)
+	return nil
+}
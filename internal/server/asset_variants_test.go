@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/config"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func TestParseImageTransform(t *testing.T) {
+	transform, err := parseImageTransform(url.Values{})
+	if err != nil || transform != nil {
+		t.Fatalf("expected no transform without params, got %#v, %v", transform, err)
+	}
+	transform, err = parseImageTransform(url.Values{"width": {"64"}})
+	if err != nil || transform.Width != 64 || transform.Fit != "contain" {
+		t.Fatalf("expected contain transform, got %#v, %v", transform, err)
+	}
+	if _, err = parseImageTransform(url.Values{"width": {"64"}, "fit": {"cover"}}); err == nil {
+		t.Fatal("expected cover without height to be rejected")
+	}
+	if _, err = parseImageTransform(url.Values{"width": {"99999"}}); err == nil {
+		t.Fatal("expected oversized width to be rejected")
+	}
+}
+
+func TestImageTransformContainDoesNotUpscale(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	if got := (&imageTransform{Width: 400, Fit: "contain"}).apply(src).Bounds(); got.Dx() != 200 {
+		t.Fatalf("expected original width, got %d", got.Dx())
+	}
+	if got := (&imageTransform{Width: 50, Fit: "contain"}).apply(src).Bounds(); got.Dx() != 50 || got.Dy() != 25 {
+		t.Fatalf("expected 50x25, got %dx%d", got.Dx(), got.Dy())
+	}
+	if got := (&imageTransform{Width: 40, Height: 40, Fit: "cover"}).apply(src).Bounds(); got.Dx() != 40 || got.Dy() != 40 {
+		t.Fatalf("expected 40x40, got %dx%d", got.Dx(), got.Dy())
+	}
+}
+
+func TestParseThumbnailTransformDefaultsToBox(t *testing.T) {
+	transform, err := parseThumbnailTransform(url.Values{})
+	if err != nil || transform.Width != defaultThumbnailSize || transform.Height != defaultThumbnailSize || transform.Fit != "contain" {
+		t.Fatalf("expected default thumbnail box, got %#v, %v", transform, err)
+	}
+	transform, err = parseThumbnailTransform(url.Values{"height": {"48"}})
+	if err != nil || transform.Width != 0 || transform.Height != 48 {
+		t.Fatalf("expected requested height only, got %#v, %v", transform, err)
+	}
+}
+
+func TestServeAssetVariantCachesAndRefusesHugeImages(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), MatrixHomeserverURL: "https://matrix.beeper.com"}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	s := New(cfg, rt)
+	dir := t.TempDir()
+
+	// A GIF header declaring 65535x65535 pixels, far more than it contains.
+	bombPath := filepath.Join(dir, "bomb.gif")
+	if err = os.WriteFile(bombPath, []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00;"), 0o600); err != nil {
+		t.Fatalf("failed to write bomb: %v", err)
+	}
+	err = s.serveAssetVariant(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), bombPath, &imageTransform{Width: 10, Fit: "contain"})
+	var apiErr *errs.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "IMAGE_TOO_LARGE" {
+		t.Fatalf("expected IMAGE_TOO_LARGE, got %v", err)
+	}
+
+	srcPath := filepath.Join(dir, "photo.png")
+	file, err := os.Create(srcPath)
+	if err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+	if err = png.Encode(file, image.NewNRGBA(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatalf("failed to encode source: %v", err)
+	}
+	_ = file.Close()
+	transform := &imageTransform{Width: 50, Fit: "contain"}
+	rec := httptest.NewRecorder()
+	if err = s.serveAssetVariant(rec, httptest.NewRequest(http.MethodGet, "/", nil), srcPath, transform); err != nil {
+		t.Fatalf("serveAssetVariant returned error: %v", err)
+	}
+	if variant, _, decodeErr := image.DecodeConfig(rec.Body); decodeErr != nil || variant.Width != 50 || variant.Height != 25 {
+		t.Fatalf("expected a 50x25 variant, got %#v, %v", variant, decodeErr)
+	}
+
+	// The cached variant is served without reading the source again.
+	if err = os.WriteFile(srcPath, []byte("not an image"), 0o600); err != nil {
+		t.Fatalf("failed to overwrite source: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	if err = s.serveAssetVariant(rec, httptest.NewRequest(http.MethodGet, "/", nil), srcPath, transform); err != nil {
+		t.Fatalf("serveAssetVariant returned error: %v", err)
+	}
+	if variant, _, decodeErr := image.DecodeConfig(rec.Body); decodeErr != nil || variant.Width != 50 {
+		t.Fatalf("expected the cached variant, got %#v, %v", variant, decodeErr)
+	}
+	entries, _ := os.ReadDir(s.assetVariantDir())
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Fatalf("temp file left behind: %s", entry.Name())
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -20,6 +21,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"maunium.net/go/mautrix/id"
 
@@ -27,7 +29,11 @@ import (
 	errs "github.com/batuhan/easymatrix/internal/errors"
 )
 
-const maxUploadSizeBytes = int64(500 * 1024 * 1024)
+const (
+	maxUploadSizeBytes           = int64(500 * 1024 * 1024)
+	defaultAssetMaxDownloadBytes = int64(256 * 1024 * 1024)
+	defaultAssetDownloadTimeout  = 5 * time.Minute
+)
 
 var safeUploadIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
@@ -40,6 +46,14 @@ type uploadMetadata struct {
 	Width    int     `json:"width,omitempty"`
 	Height   int     `json:"height,omitempty"`
 	Duration float64 `json:"duration,omitempty"`
+	Waveform []int   `json:"waveform,omitempty"`
+	// ContentHash is the hex SHA-256 of the file, used to seed the asset
+	// cache once the upload is sent.
+	ContentHash string `json:"contentHash,omitempty"`
+	// Sanitized is set once image metadata has been stripped from the file.
+	Sanitized bool `json:"sanitized,omitempty"`
+	// IsAnimated marks animated GIF, PNG and WebP images and Lottie files.
+	IsAnimated bool `json:"isAnimated,omitempty"`
 }
 
 func (s *Server) downloadAsset(w http.ResponseWriter, r *http.Request) error {
@@ -48,14 +62,19 @@ func (s *Server) downloadAsset(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	if strings.TrimSpace(input.URL) == "" {
-		return writeJSON(w, compat.DownloadAssetOutput{Error: "URL is required"})
+		return writeDownloadAssetError(w, "URL is required")
+	}
+	if err := s.authorizeAssetURL(r, input.URL); err != nil {
+		return err
 	}
 
 	filePath, err := s.resolveAssetURL(r.Context(), input.URL)
 	if err != nil {
-		return writeJSON(w, compat.DownloadAssetOutput{Error: err.Error()})
+		return writeDownloadAssetError(w, err.Error())
 	}
-	return writeJSON(w, compat.DownloadAssetOutput{SrcURL: fileURLFromPath(filePath)})
+	output := compat.DownloadAssetOutput{ContentHash: s.assetContentHash(input.URL)}
+	output.SrcURL = fileURLFromPath(filePath)
+	return writeJSON(w, output)
 }
 
 func (s *Server) serveAsset(w http.ResponseWriter, r *http.Request) error {
@@ -63,14 +82,32 @@ func (s *Server) serveAsset(w http.ResponseWriter, r *http.Request) error {
 	if assetURL == "" {
 		return errs.Validation(map[string]any{"url": "url is required"})
 	}
-	filePath, err := s.resolveServePath(r.Context(), assetURL)
+	transform, err := parseImageTransform(r.URL.Query())
 	if err != nil {
 		return err
 	}
+	if err = s.authorizeAssetURL(r, assetURL); err != nil {
+		return err
+	}
+	filePath, err := s.resolveServePath(r.Context(), assetURL)
+	var tooLarge *assetTooLargeError
+	if errors.As(err, &tooLarge) {
+		return s.streamAsset(w, r, tooLarge.mxc)
+	} else if err != nil {
+		return err
+	}
 	if _, statErr := os.Stat(filePath); statErr != nil {
 		return errs.NotFound("Asset not found")
 	}
-	http.ServeFile(w, r, filePath)
+	if r.URL.Query().Get("poster") == "true" {
+		if filePath, err = s.assetPosterPath(r.Context(), filePath); err != nil {
+			return err
+		}
+	}
+	if transform != nil {
+		return s.serveAssetVariant(w, r, filePath, transform)
+	}
+	s.serveAssetFile(w, r, filePath)
 	return nil
 }
 
@@ -89,25 +126,16 @@ func (s *Server) uploadAsset(w http.ResponseWriter, r *http.Request) error {
 		data, fileName, mimeType, err = s.parseBase64Upload(r)
 	}
 	if err != nil {
-		return writeJSON(w, compat.UploadAssetOutput{Error: err.Error()})
+		if tooLarge := payloadTooLargeError(err); tooLarge != nil {
+			return tooLarge
+		}
+		return writeUploadAssetError(w, err.Error())
 	}
 
 	if int64(len(data)) > maxUploadSizeBytes {
-		return writeJSON(w, compat.UploadAssetOutput{Error: "Upload too large"})
-	}
-	if fileName == "" {
-		fileName = "file"
-	}
-	fileName = filepath.Base(fileName)
-	if fileName == "." || fileName == "/" || fileName == "" {
-		fileName = "file"
-	}
-	if mimeType == "" {
-		mimeType = mime.TypeByExtension(filepath.Ext(fileName))
-	}
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+		return writeUploadAssetError(w, "Upload too large")
 	}
+	fileName, mimeType = normalizeUploadName(fileName, mimeType)
 
 	uploadID := randomID()
 	uploadDir := filepath.Join(s.uploadRootDir(), uploadID)
@@ -119,31 +147,87 @@ func (s *Server) uploadAsset(w http.ResponseWriter, r *http.Request) error {
 		return errs.Internal(fmt.Errorf("failed to write upload: %w", err))
 	}
 
+	sum := sha256.Sum256(data)
 	meta := uploadMetadata{
-		UploadID: uploadID,
-		FilePath: filePath,
-		FileName: fileName,
-		MimeType: mimeType,
-		FileSize: int64(len(data)),
+		ContentHash: hex.EncodeToString(sum[:]),
+		UploadID:    uploadID,
+		FilePath:    filePath,
+		FileName:    fileName,
+		MimeType:    mimeType,
+		FileSize:    int64(len(data)),
+	}
+	s.describeUpload(r.Context(), &meta, data[:min(len(data), animationSniffBytes)])
+	if err = s.writeUploadMetadata(meta); err != nil {
+		return errs.Internal(err)
 	}
-	if width, height := imageDimensions(filePath); width > 0 && height > 0 {
+	return writeJSON(w, uploadAssetOutput(meta))
+}
+
+// normalizeUploadName reduces a client-supplied file name to its base name
+// and guesses the MIME type from the extension when none was given.
+func normalizeUploadName(fileName, mimeType string) (string, string) {
+	fileName = filepath.Base(fileName)
+	if fileName == "." || fileName == "/" || fileName == "" {
+		fileName = "file"
+	}
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(fileName))
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return fileName, mimeType
+}
+
+// describeUpload fills in what can be read from a staged file: animation,
+// dimensions and audio waveforms. header is the start of the file.
+func (s *Server) describeUpload(ctx context.Context, meta *uploadMetadata, header []byte) {
+	animated, lottieMimeType := sniffAnimation(header)
+	if lottieMimeType != "" && !isLottieMimeType(meta.MimeType) {
+		meta.MimeType = lottieMimeType
+	}
+	meta.IsAnimated = animated
+	if lottieMimeType != "" {
+		if data, err := os.ReadFile(meta.FilePath); err == nil {
+			meta.Width, meta.Height = lottieDimensions(data)
+		}
+	} else if width, height := imageDimensions(meta.FilePath); width > 0 && height > 0 {
 		meta.Width = width
 		meta.Height = height
 	}
-	if err = s.writeUploadMetadata(meta); err != nil {
-		return errs.Internal(err)
+	if strings.HasPrefix(meta.MimeType, "audio/") {
+		if duration, waveform, ok := analyzeAudio(ctx, meta.FilePath); ok {
+			meta.Duration = duration
+			meta.Waveform = waveform
+		}
 	}
+}
 
-	return writeJSON(w, compat.UploadAssetOutput{
-		UploadID: uploadID,
-		SrcURL:   fileURLFromPath(filePath),
-		FileName: fileName,
-		MimeType: mimeType,
-		FileSize: float64(len(data)),
-		Width:    float64(meta.Width),
-		Height:   float64(meta.Height),
-		Duration: meta.Duration,
-	})
+func uploadAssetOutput(meta uploadMetadata) compat.UploadAssetOutput {
+	output := compat.UploadAssetOutput{ContentHash: contentHashID(meta.ContentHash), IsAnimated: meta.IsAnimated}
+	output.UploadID = meta.UploadID
+	output.SrcURL = fileURLFromPath(meta.FilePath)
+	output.FileName = meta.FileName
+	output.MimeType = meta.MimeType
+	output.FileSize = float64(meta.FileSize)
+	output.Width = float64(meta.Width)
+	output.Height = float64(meta.Height)
+	output.Duration = meta.Duration
+	return output
+}
+
+// Asset endpoints report failures in the response body rather than as API
+// errors, matching the desktop API.
+func writeDownloadAssetError(w http.ResponseWriter, message string) error {
+	var output compat.DownloadAssetOutput
+	output.Error = message
+	return writeJSON(w, output)
+}
+
+func writeUploadAssetError(w http.ResponseWriter, message string) error {
+	var output compat.UploadAssetOutput
+	output.Error = message
+	return writeJSON(w, output)
 }
 
 func (s *Server) parseMultipartUpload(r *http.Request) ([]byte, string, string, error) {
@@ -206,57 +290,104 @@ func (s *Server) resolveServePath(ctx context.Context, raw string) (string, erro
 		return path, nil
 	}
 	path, err := s.resolveAssetURL(ctx, raw)
-	if err != nil {
+	var tooLarge *assetTooLargeError
+	if errors.As(err, &tooLarge) {
+		return "", err
+	} else if err != nil {
 		return "", errs.NotFound(err.Error())
 	}
 	return path, nil
 }
 
-func (s *Server) resolveAssetURL(ctx context.Context, raw string) (string, error) {
+func parseAssetMXC(raw string) (string, id.ContentURI, error) {
 	normalized := strings.TrimSpace(raw)
 	if strings.HasPrefix(normalized, "localmxc://") {
 		normalized = "mxc://" + strings.TrimPrefix(normalized, "localmxc://")
 	}
 	parsedMXC := id.ContentURIString(normalized).ParseOrIgnore()
 	if !parsedMXC.IsValid() {
-		return "", fmt.Errorf("URL must be mxc:// or localmxc://")
+		return "", id.ContentURI{}, fmt.Errorf("URL must be mxc:// or localmxc://")
+	}
+	return normalized, parsedMXC, nil
+}
+
+func (s *Server) resolveAssetURL(ctx context.Context, raw string) (string, error) {
+	normalized, parsedMXC, err := parseAssetMXC(raw)
+	if err != nil {
+		return "", err
 	}
 
 	cacheDir := s.assetCacheDir()
-	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+	if err = os.MkdirAll(cacheDir, 0o700); err != nil {
 		return "", fmt.Errorf("failed to create asset cache dir: %w", err)
 	}
+	if blobPath, _, ok := s.lookupAssetBlob(normalized); ok {
+		s.touchAsset(blobPath)
+		return blobPath, nil
+	}
 	sum := sha256.Sum256([]byte(normalized))
 	cachePath := filepath.Join(cacheDir, hex.EncodeToString(sum[:]))
-	if _, err := os.Stat(cachePath); err == nil {
-		return cachePath, nil
+	// Entries cached before the blob store existed are migrated on first use.
+	if _, err = os.Stat(cachePath); err == nil {
+		digest, hashErr := hashFile(cachePath)
+		if hashErr != nil {
+			return cachePath, nil
+		}
+		return s.storeAssetBlob(normalized, cachePath, digest)
 	}
 
+	maxBytes, timeout := s.assetDownloadBudget()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 	resp, err := s.rt.Client().Client.Download(ctx, parsedMXC)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("asset download exceeded the %s time budget", timeout)
+		}
 		return "", fmt.Errorf("failed to download asset: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.ContentLength > maxBytes {
+		return "", &assetTooLargeError{mxc: parsedMXC, size: resp.ContentLength, limit: maxBytes}
+	}
 
 	tempPath := cachePath + ".tmp"
 	file, err := os.Create(tempPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp asset file: %w", err)
 	}
-	if _, err = io.Copy(file, resp.Body); err != nil {
+	// Content-Length is optional, so the copy itself enforces the size budget.
+	hasher := sha256.New()
+	encFile, _ := s.encryptedAsset(ctx, parsedMXC)
+	written, err := copyAssetBody(io.MultiWriter(file, hasher), resp.Body, encFile, maxBytes)
+	if err == nil && written > maxBytes {
+		err = &assetTooLargeError{mxc: parsedMXC, size: -1, limit: maxBytes}
+	}
+	if err != nil {
 		_ = file.Close()
 		_ = os.Remove(tempPath)
+		if errors.Is(err, errAssetIntegrity) {
+			return "", err
+		}
+		var tooLarge *assetTooLargeError
+		if errors.As(err, &tooLarge) {
+			return "", err
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", fmt.Errorf("asset download exceeded the %s time budget", timeout)
+		}
 		return "", fmt.Errorf("failed to save downloaded asset: %w", err)
 	}
 	if closeErr := file.Close(); closeErr != nil {
 		_ = os.Remove(tempPath)
 		return "", fmt.Errorf("failed to close downloaded asset: %w", closeErr)
 	}
-	if err = os.Rename(tempPath, cachePath); err != nil {
+	blobPath, err := s.storeAssetBlob(normalized, tempPath, hex.EncodeToString(hasher.Sum(nil)))
+	if err != nil {
 		_ = os.Remove(tempPath)
-		return "", fmt.Errorf("failed to finalize cached asset: %w", err)
+		return "", err
 	}
-	return cachePath, nil
+	return blobPath, nil
 }
 
 func (s *Server) writeUploadMetadata(meta uploadMetadata) error {
@@ -331,8 +462,13 @@ func (s *Server) isAllowedServePath(path string) bool {
 	if err != nil {
 		return false
 	}
+	exportRoot, err := filepath.Abs(s.exportRootDir())
+	if err != nil {
+		return false
+	}
 	return strings.HasPrefix(absPath, uploadRoot+string(os.PathSeparator)) ||
-		strings.HasPrefix(absPath, assetRoot+string(os.PathSeparator))
+		strings.HasPrefix(absPath, assetRoot+string(os.PathSeparator)) ||
+		strings.HasPrefix(absPath, exportRoot+string(os.PathSeparator))
 }
 
 func randomID() string {
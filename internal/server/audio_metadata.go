@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mau.fi/util/ffmpeg"
+)
+
+const (
+	waveformSampleRate   = 8000
+	waveformBuckets      = 100
+	maxWaveformValue     = 1024
+	audioAnalysisTimeout = 30 * time.Second
+)
+
+// analyzeAudio decodes an upload to mono PCM with ffmpeg to measure its
+// duration and build an MSC3246 waveform. Without ffmpeg on PATH, or for
+// files ffmpeg cannot decode, the upload simply has no audio metadata.
+func analyzeAudio(ctx context.Context, filePath string) (float64, []int, bool) {
+	if !ffmpeg.Supported() {
+		return 0, nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, audioAnalysisTimeout)
+	defer cancel()
+	pcmPath := filepath.Join(filepath.Dir(filePath), ".waveform.pcm")
+	defer os.Remove(pcmPath)
+	err := ffmpeg.ConvertPathWithDestination(ctx, filePath, pcmPath, nil, []string{
+		"-vn", "-ac", "1", "-ar", "8000", "-f", "s16le",
+	}, false)
+	if err != nil {
+		return 0, nil, false
+	}
+	pcm, err := os.ReadFile(pcmPath)
+	if err != nil || len(pcm) < 2 {
+		return 0, nil, false
+	}
+	duration := float64(len(pcm)/2) / waveformSampleRate
+	return duration, waveformFromPCM(pcm, waveformBuckets), true
+}
+
+// waveformFromPCM downsamples signed 16-bit little-endian mono samples into
+// RMS buckets scaled so the loudest bucket is maxWaveformValue.
+func waveformFromPCM(pcm []byte, buckets int) []int {
+	samples := len(pcm) / 2
+	if samples == 0 || buckets <= 0 {
+		return nil
+	}
+	buckets = min(buckets, samples)
+	levels := make([]float64, buckets)
+	loudest := 0.0
+	for bucket := range buckets {
+		start := bucket * samples / buckets
+		end := (bucket + 1) * samples / buckets
+		sum := 0.0
+		for idx := start; idx < end; idx++ {
+			sample := float64(int16(binary.LittleEndian.Uint16(pcm[idx*2:])))
+			sum += sample * sample
+		}
+		levels[bucket] = math.Sqrt(sum / float64(end-start))
+		loudest = max(loudest, levels[bucket])
+	}
+	waveform := make([]int, buckets)
+	if loudest == 0 {
+		return waveform
+	}
+	for idx, level := range levels {
+		waveform[idx] = int(math.Round(level / loudest * maxWaveformValue))
+	}
+	return waveform
+}
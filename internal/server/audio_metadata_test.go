@@ -0,0 +1,20 @@
+package server
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestWaveformFromPCMScalesToLoudestBucket(t *testing.T) {
+	pcm := make([]byte, 0, 8)
+	for _, sample := range []int16{0, 0, 1000, -1000} {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(sample))
+	}
+	waveform := waveformFromPCM(pcm, 2)
+	if len(waveform) != 2 || waveform[0] != 0 || waveform[1] != maxWaveformValue {
+		t.Fatalf("expected [0 %d], got %v", maxWaveformValue, waveform)
+	}
+	if got := waveformFromPCM(pcm, 100); len(got) != 4 {
+		t.Fatalf("expected bucket count capped at sample count, got %d", len(got))
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	autoArchiveStoreFormat = 1
+	autoArchiveInterval    = time.Hour
+	autoArchiveMaxDays     = 3650
+)
+
+// autoArchiveStore persists the policy locally rather than in account data:
+// only this server runs the task, and LastRunAt is bookkeeping for it.
+type autoArchiveStore struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	replica replicaFile
+	policy  compat.AutoArchivePolicy
+}
+
+type autoArchiveStorePersisted struct {
+	Version int                      `json:"version"`
+	Policy  compat.AutoArchivePolicy `json:"policy"`
+}
+
+func newAutoArchiveStore(path string) *autoArchiveStore {
+	return &autoArchiveStore{path: path}
+}
+
+func (c *autoArchiveStore) loadLocked() error {
+	stamp, changed, err := c.replica.changed(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read auto-archive policy: %w", err)
+	}
+	if c.loaded && !changed {
+		return nil
+	}
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.policy = compat.AutoArchivePolicy{ExcludedChatIDs: []string{}}
+			c.replica.seen, c.loaded = stamp, true
+			return nil
+		}
+		return fmt.Errorf("failed to read auto-archive policy: %w", err)
+	}
+	var persisted autoArchiveStorePersisted
+	if err = json.Unmarshal(raw, &persisted); err != nil {
+		return fmt.Errorf("failed to parse auto-archive policy: %w", err)
+	}
+	if persisted.Version != autoArchiveStoreFormat {
+		return fmt.Errorf("unsupported auto-archive policy version: %d", persisted.Version)
+	}
+	c.policy = persisted.Policy
+	if c.policy.ExcludedChatIDs == nil {
+		c.policy.ExcludedChatIDs = []string{}
+	}
+	c.replica.seen, c.loaded = stamp, true
+	return nil
+}
+
+func (c *autoArchiveStore) saveLocked() error {
+	raw, err := json.Marshal(autoArchiveStorePersisted{Version: autoArchiveStoreFormat, Policy: c.policy})
+	if err != nil {
+		return fmt.Errorf("failed to encode auto-archive policy: %w", err)
+	}
+	return writeAtomicFile(c.path, raw, 0o600)
+}
+
+func (c *autoArchiveStore) get() (compat.AutoArchivePolicy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return compat.AutoArchivePolicy{}, err
+	}
+	return c.policy, nil
+}
+
+// set replaces the policy and clears LastRunAt, so the next pass evaluates
+// every chat against the new thresholds instead of only recent crossings.
+func (c *autoArchiveStore) set(policy compat.AutoArchivePolicy) (compat.AutoArchivePolicy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return compat.AutoArchivePolicy{}, err
+	}
+	policy.LastRunAt = nil
+	c.policy = policy
+	return c.policy, c.saveLocked()
+}
+
+func (c *autoArchiveStore) markRun(at time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return err
+	}
+	c.policy.LastRunAt = &at
+	return c.saveLocked()
+}
+
+func normalizeAutoArchivePolicy(input compat.AutoArchivePolicy) (compat.AutoArchivePolicy, error) {
+	if input.InactiveDays < 0 || input.InactiveDays > autoArchiveMaxDays {
+		return compat.AutoArchivePolicy{}, errs.Validation(map[string]any{"inactiveDays": fmt.Sprintf("must be between 0 and %d", autoArchiveMaxDays)})
+	}
+	if input.LowPriorityUnreadDays < 0 || input.LowPriorityUnreadDays > autoArchiveMaxDays {
+		return compat.AutoArchivePolicy{}, errs.Validation(map[string]any{"lowPriorityUnreadDays": fmt.Sprintf("must be between 0 and %d", autoArchiveMaxDays)})
+	}
+	output := compat.AutoArchivePolicy{
+		InactiveDays:          input.InactiveDays,
+		LowPriorityUnreadDays: input.LowPriorityUnreadDays,
+		ExcludedChatIDs:       make([]string, 0, len(input.ExcludedChatIDs)),
+	}
+	for _, chatID := range input.ExcludedChatIDs {
+		chatID = strings.TrimSpace(chatID)
+		if chatID == "" {
+			return compat.AutoArchivePolicy{}, errs.Validation(map[string]any{"excludedChatIDs": "must not contain empty chat IDs"})
+		}
+		if !slices.Contains(output.ExcludedChatIDs, chatID) {
+			output.ExcludedChatIDs = append(output.ExcludedChatIDs, chatID)
+		}
+	}
+	return output, nil
+}
+
+// autoArchiveDue reports whether a rule threshold was crossed in (since, now].
+// Acting only on new crossings means a chat the user unarchives by hand is
+// left alone until it goes idle again.
+func autoArchiveDue(policy compat.AutoArchivePolicy, lastActivity time.Time, lowPriorityUnread bool, since, now time.Time) bool {
+	crossed := func(days int) bool {
+		if days <= 0 {
+			return false
+		}
+		at := lastActivity.Add(time.Duration(days) * 24 * time.Hour)
+		return at.After(since) && !at.After(now)
+	}
+	return crossed(policy.InactiveDays) || (lowPriorityUnread && crossed(policy.LowPriorityUnreadDays))
+}
+
+func (s *Server) getAutoArchivePolicy(w http.ResponseWriter, r *http.Request) error {
+	policy, err := s.autoArchive.get()
+	if err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, policy)
+}
+
+func (s *Server) setAutoArchivePolicy(w http.ResponseWriter, r *http.Request) error {
+	var req compat.AutoArchivePolicy
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	policy, err := normalizeAutoArchivePolicy(req)
+	if err != nil {
+		return err
+	}
+	if policy, err = s.autoArchive.set(policy); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, policy)
+}
+
+func (s *Server) runAutoArchive(ctx context.Context) {
+	ticker := time.NewTicker(autoArchiveInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.autoArchivePass(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("auto-archive pass failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) autoArchivePass(ctx context.Context, now time.Time) error {
+	policy, err := s.autoArchive.get()
+	if err != nil {
+		return err
+	}
+	if policy.InactiveDays <= 0 && policy.LowPriorityUnreadDays <= 0 {
+		return nil
+	}
+	cli := s.rt.Client()
+	if cli == nil || cli.Account == nil {
+		return nil
+	}
+	var since time.Time
+	if policy.LastRunAt != nil {
+		since = *policy.LastRunAt
+	}
+	rooms, err := s.loadRoomsSorted(ctx)
+	if err != nil {
+		return err
+	}
+	states, err := s.loadRoomAccountDataStates(ctx)
+	if err != nil {
+		return err
+	}
+	archived := 0
+	for _, room := range rooms {
+		if room.SortingTimestamp.IsZero() || slices.Contains(policy.ExcludedChatIDs, string(room.ID)) {
+			continue
+		}
+		state := states[room.ID]
+		if state.EffectiveArchived() {
+			continue
+		}
+		lowPriorityUnread := state.IsLowPriority && (room.UnreadMessages > 0 || state.IsMarkedUnread)
+		if !autoArchiveDue(policy, room.SortingTimestamp.Time, lowPriorityUnread, since, now) {
+			continue
+		}
+		if err = s.setChatArchived(ctx, string(room.ID), true); err != nil {
+			return err
+		}
+		archived++
+	}
+	if archived > 0 {
+		log.Printf("auto-archived %d chats", archived)
+	}
+	return s.autoArchive.markRun(now)
+}
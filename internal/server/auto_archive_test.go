@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestAutoArchiveDueOnlyOnNewCrossings(t *testing.T) {
+	policy := compat.AutoArchivePolicy{InactiveDays: 30, LowPriorityUnreadDays: 7}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	lastRun := now.Add(-time.Hour)
+
+	if !autoArchiveDue(policy, now.Add(-30*24*time.Hour-30*time.Minute), false, lastRun, now) {
+		t.Fatal("expected chat crossing the inactivity threshold this interval to be due")
+	}
+	if autoArchiveDue(policy, now.Add(-60*24*time.Hour), false, lastRun, now) {
+		t.Fatal("expected chat that crossed before the last run to be left alone")
+	}
+	if !autoArchiveDue(policy, now.Add(-60*24*time.Hour), false, time.Time{}, now) {
+		t.Fatal("expected first run to archive every idle chat")
+	}
+	eightDays := now.Add(-8 * 24 * time.Hour)
+	if autoArchiveDue(policy, eightDays, false, time.Time{}, now) {
+		t.Fatal("expected low-priority rule to require an unread low-priority chat")
+	}
+	if !autoArchiveDue(policy, eightDays, true, time.Time{}, now) {
+		t.Fatal("expected unread low-priority chat to be due")
+	}
+}
+
+func TestNormalizeAutoArchivePolicy(t *testing.T) {
+	policy, err := normalizeAutoArchivePolicy(compat.AutoArchivePolicy{InactiveDays: 30, ExcludedChatIDs: []string{" !a:x ", "!a:x"}})
+	if err != nil || len(policy.ExcludedChatIDs) != 1 || policy.ExcludedChatIDs[0] != "!a:x" {
+		t.Fatalf("unexpected normalized policy %#v, %v", policy, err)
+	}
+	if _, err = normalizeAutoArchivePolicy(compat.AutoArchivePolicy{InactiveDays: -1}); err == nil {
+		t.Fatal("expected negative days to be rejected")
+	}
+}
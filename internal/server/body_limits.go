@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	defaultBodyLimitBytes = int64(1 << 20)
+	// Multipart framing and form fields ride on top of the file itself.
+	multipartUploadBodyLimitBytes = maxUploadSizeBytes + 1<<20
+	// Base64 inflates content by 4/3, plus the surrounding JSON envelope.
+	base64UploadBodyLimitBytes = maxUploadSizeBytes/3*4 + 1<<20
+)
+
+// routeBodyLimits overrides defaultBodyLimitBytes for routes that legitimately
+// accept large bodies. Keys are the exact patterns passed to handle.
+var routeBodyLimits = map[string]int64{
+	"POST /v1/assets/upload":               multipartUploadBodyLimitBytes,
+	"POST /v1/assets/upload/base64":        base64UploadBodyLimitBytes,
+	"PATCH /v1/assets/uploads/{sessionID}": uploadChunkMaxBytes,
+	"POST /v1/admin/account/import":        accountImportBodyLimit,
+}
+
+func bodyLimitForRoute(pattern string) int64 {
+	if limit, ok := routeBodyLimits[pattern]; ok {
+		return limit
+	}
+	return defaultBodyLimitBytes
+}
+
+func limitRequestBody(w http.ResponseWriter, r *http.Request, limit int64) {
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+}
+
+// payloadTooLargeError converts a body read failure caused by
+// http.MaxBytesReader into the API's PAYLOAD_TOO_LARGE error.
+func payloadTooLargeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errs.PayloadTooLarge(maxBytesErr.Limit)
+	}
+	var apiErr *errs.APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusRequestEntityTooLarge {
+		return apiErr
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// bridgeLoginRetention bounds how long a finished or abandoned login stays
+// visible to status polls.
+const bridgeLoginRetention = time.Hour
+
+// bridgeLoginResponse mirrors the bridgev2 provisioning API's login step
+// response, where login_id names the login process rather than the account.
+type bridgeLoginResponse struct {
+	LoginID string `json:"login_id"`
+	bridgev2.LoginStep
+}
+
+type bridgeLoginFlowsResponse struct {
+	Flows []bridgev2.LoginFlow `json:"flows"`
+}
+
+// bridgeLoginTracker remembers the latest step of each login so clients can
+// poll it, e.g. while another device is scanning a QR code.
+type bridgeLoginTracker struct {
+	mu    sync.Mutex
+	steps map[string]compat.BridgeLoginStep
+}
+
+func newBridgeLoginTracker() *bridgeLoginTracker {
+	return &bridgeLoginTracker{steps: make(map[string]compat.BridgeLoginStep)}
+}
+
+func (t *bridgeLoginTracker) put(step compat.BridgeLoginStep) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, existing := range t.steps {
+		if step.UpdatedAt.Sub(existing.UpdatedAt) > bridgeLoginRetention {
+			delete(t.steps, key)
+		}
+	}
+	t.steps[step.BridgeID+"/"+step.LoginProcessID] = step
+}
+
+func (t *bridgeLoginTracker) get(bridgeID, loginProcessID string) (compat.BridgeLoginStep, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	step, ok := t.steps[bridgeID+"/"+loginProcessID]
+	return step, ok
+}
+
+func mapBridgeLoginStep(bridgeID string, resp bridgeLoginResponse, now time.Time) compat.BridgeLoginStep {
+	step := compat.BridgeLoginStep{
+		BridgeID:       bridgeID,
+		LoginProcessID: resp.LoginID,
+		StepID:         resp.StepID,
+		Type:           string(resp.Type),
+		Instructions:   resp.Instructions,
+		UpdatedAt:      now,
+	}
+	if params := resp.UserInputParams; params != nil {
+		step.Fields = make([]compat.BridgeLoginField, 0, len(params.Fields))
+		for _, field := range params.Fields {
+			step.Fields = append(step.Fields, compat.BridgeLoginField{
+				Type:         string(field.Type),
+				ID:           field.ID,
+				Name:         field.Name,
+				Description:  field.Description,
+				DefaultValue: field.DefaultValue,
+				Pattern:      field.Pattern,
+				Options:      field.Options,
+			})
+		}
+	}
+	if params := resp.DisplayAndWaitParams; params != nil {
+		step.Display = &compat.BridgeLoginDisplay{Type: string(params.Type), Data: params.Data, ImageURL: params.ImageURL}
+	}
+	if params := resp.CookiesParams; params != nil {
+		step.Cookies, _ = json.Marshal(params)
+	}
+	if params := resp.CompleteParams; params != nil && params.UserLoginID != "" {
+		step.AccountID = bridgeID + "_" + string(params.UserLoginID)
+	}
+	return step
+}
+
+// bridgeConnectError keeps the bridge's own message for rejected input, such
+// as a wrong 2FA code, so clients can show it to the user.
+func bridgeConnectError(err error, action string) error {
+	var httpErr mautrix.HTTPError
+	switch {
+	case errors.Is(err, mautrix.MNotFound):
+		return errs.NotFound("Login not found")
+	case errors.As(err, &httpErr) && httpErr.RespError != nil && httpErr.Response != nil && httpErr.Response.StatusCode < 500:
+		return errs.New(http.StatusBadRequest, "BRIDGE_LOGIN_FAILED", httpErr.RespError.Err, map[string]any{"errcode": httpErr.RespError.ErrCode})
+	default:
+		return errs.Internal(fmt.Errorf("failed to %s: %w", action, err))
+	}
+}
+
+// Linking accounts changes what the deployment exposes, so it stays with
+// the deployment owner.
+func (s *Server) readConnectBridgeID(r *http.Request) (string, error) {
+	if s.requestPolicy(r) != nil {
+		return "", errs.Forbidden("Connecting accounts is only available to the deployment owner")
+	}
+	bridgeID := strings.TrimSpace(r.PathValue("bridgeID"))
+	if bridgeID == "" || bridgeID == "matrix" || strings.ContainsAny(bridgeID, "/_") {
+		return "", errs.Validation(map[string]any{"bridgeID": "must be a bridge ID like whatsapp"})
+	}
+	return bridgeID, nil
+}
+
+func (s *Server) bridgeProvisionRequest(ctx context.Context, method, bridgeID string, path []any, query map[string]string, body, out any) error {
+	cli := s.rt.Client()
+	if cli == nil || cli.Client == nil || cli.Account == nil {
+		return errs.New(http.StatusServiceUnavailable, "NOT_LOGGED_IN", "Matrix session is not ready", nil)
+	}
+	if query == nil {
+		query = map[string]string{}
+	}
+	query["user_id"] = string(cli.Account.UserID)
+	urlPath := cli.Client.BuildURLWithQuery(
+		append(mautrix.ClientURLPath{"unstable", "com.beeper.bridge", bridgeID, "_matrix", "provision", "v3"}, path...),
+		query,
+	)
+	_, err := cli.Client.MakeRequest(ctx, method, urlPath, body, out)
+	return err
+}
+
+func (s *Server) listBridgeLoginFlows(w http.ResponseWriter, r *http.Request) error {
+	bridgeID, err := s.readConnectBridgeID(r)
+	if err != nil {
+		return err
+	}
+	var resp bridgeLoginFlowsResponse
+	if err = s.bridgeProvisionRequest(r.Context(), http.MethodGet, bridgeID, []any{"login", "flows"}, nil, nil, &resp); err != nil {
+		return bridgeConnectError(err, "list login flows")
+	}
+	output := compat.BridgeLoginFlowsOutput{BridgeID: bridgeID, Flows: make([]compat.BridgeLoginFlow, 0, len(resp.Flows))}
+	for _, flow := range resp.Flows {
+		output.Flows = append(output.Flows, compat.BridgeLoginFlow{ID: flow.ID, Name: flow.Name, Description: flow.Description})
+	}
+	return writeJSON(w, output)
+}
+
+func (s *Server) startBridgeLogin(w http.ResponseWriter, r *http.Request) error {
+	bridgeID, err := s.readConnectBridgeID(r)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		FlowID string `json:"flowID"`
+		// AccountID re-authenticates an existing account instead of adding one.
+		AccountID string `json:"accountID,omitempty"`
+	}
+	if err = decodeJSON(r, &req); err != nil {
+		return err
+	}
+	req.FlowID = strings.TrimSpace(req.FlowID)
+	if req.FlowID == "" {
+		return errs.Validation(map[string]any{"flowID": "flowID is required"})
+	}
+	query := map[string]string{}
+	if req.AccountID = strings.TrimSpace(req.AccountID); req.AccountID != "" {
+		accountBridgeID, loginID := splitDesktopAccountID(req.AccountID)
+		if accountBridgeID != bridgeID || loginID == "" {
+			return errs.Validation(map[string]any{"accountID": "must be an account on this bridge"})
+		}
+		query["login_id"] = loginID
+	}
+	var resp bridgeLoginResponse
+	if err = s.bridgeProvisionRequest(r.Context(), http.MethodPost, bridgeID, []any{"login", "start", req.FlowID}, query, struct{}{}, &resp); err != nil {
+		return bridgeConnectError(err, "start login")
+	}
+	step := mapBridgeLoginStep(bridgeID, resp, time.Now().UTC())
+	s.bridgeLogins.put(step)
+	return writeJSON(w, step)
+}
+
+func (s *Server) getBridgeLogin(w http.ResponseWriter, r *http.Request) error {
+	bridgeID, err := s.readConnectBridgeID(r)
+	if err != nil {
+		return err
+	}
+	step, ok := s.bridgeLogins.get(bridgeID, strings.TrimSpace(r.PathValue("loginProcessID")))
+	if !ok {
+		return errs.NotFound("Login not found")
+	}
+	return writeJSON(w, step)
+}
+
+// submitBridgeLoginStep completes the current step. For display_and_wait
+// steps there is no input and the request blocks until the bridge moves on,
+// e.g. once the QR code has been scanned.
+func (s *Server) submitBridgeLoginStep(w http.ResponseWriter, r *http.Request) error {
+	bridgeID, err := s.readConnectBridgeID(r)
+	if err != nil {
+		return err
+	}
+	loginProcessID := strings.TrimSpace(r.PathValue("loginProcessID"))
+	current, ok := s.bridgeLogins.get(bridgeID, loginProcessID)
+	if !ok {
+		return errs.NotFound("Login not found")
+	}
+	var req struct {
+		StepID string            `json:"stepID"`
+		Input  map[string]string `json:"input"`
+	}
+	if err = decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	if req.StepID = strings.TrimSpace(req.StepID); req.StepID == "" {
+		req.StepID = current.StepID
+	}
+	if req.StepID != current.StepID {
+		return errs.Validation(map[string]any{"stepID": fmt.Sprintf("login is at step %q", current.StepID)})
+	}
+	var body any = struct{}{}
+	switch bridgev2.LoginStepType(current.Type) {
+	case bridgev2.LoginStepTypeUserInput, bridgev2.LoginStepTypeCookies:
+		if len(req.Input) == 0 {
+			return errs.Validation(map[string]any{"input": "input is required for this step"})
+		}
+		body = req.Input
+	case bridgev2.LoginStepTypeDisplayAndWait:
+	default:
+		return errs.Validation(map[string]any{"stepID": "login is already complete"})
+	}
+	var resp bridgeLoginResponse
+	path := []any{"login", "step", loginProcessID, current.StepID, current.Type}
+	if err = s.bridgeProvisionRequest(r.Context(), http.MethodPost, bridgeID, path, nil, body, &resp); err != nil {
+		return bridgeConnectError(err, "submit login step")
+	}
+	if resp.LoginID == "" {
+		resp.LoginID = loginProcessID
+	}
+	step := mapBridgeLoginStep(bridgeID, resp, time.Now().UTC())
+	s.bridgeLogins.put(step)
+	return writeJSON(w, step)
+}
+
+// disconnectAccount logs the account out of its bridge and marks it deleted
+// in the local bridge state, which drops it from /v1/accounts.
+func (s *Server) disconnectAccount(w http.ResponseWriter, r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Disconnecting accounts is only available to the deployment owner")
+	}
+	accountID := strings.TrimSpace(r.PathValue("accountID"))
+	lookup, err := s.buildAccountLookup(r.Context())
+	if err != nil {
+		return err
+	}
+	if _, ok := lookup.ByID[accountID]; !ok {
+		return errs.NotFound("Account not found")
+	}
+	bridgeID, loginID := splitDesktopAccountID(accountID)
+	if bridgeID == "" || loginID == "" || bridgeID == "matrix" {
+		return errs.Validation(map[string]any{"accountID": "the Matrix account itself cannot be disconnected"})
+	}
+	err = s.bridgeProvisionRequest(r.Context(), http.MethodPost, bridgeID, []any{"logout", loginID}, nil, struct{}{}, nil)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return bridgeConnectError(err, "log out of the bridge")
+	}
+	if err = s.markLocalBridgeAccountDeleted(r.Context(), bridgeID, loginID); err != nil {
+		return err
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+func (s *Server) markLocalBridgeAccountDeleted(ctx context.Context, bridgeID, loginID string) error {
+	cli := s.rt.Client()
+	entries, err := cli.DB.AccountData.GetAllGlobal(ctx, cli.Account.UserID)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to read global account data: %w", err))
+	}
+	idx := slices.IndexFunc(entries, func(ad *database.AccountData) bool {
+		return ad.Type == localBridgeStateEventType && len(ad.Content) > 0
+	})
+	if idx < 0 {
+		return nil
+	}
+	raw, changed, err := markBridgeAccountDeleted(entries[idx].Content, bridgeID, loginID)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	if !changed {
+		return nil
+	}
+	if err = cli.Client.SetAccountData(ctx, localBridgeStateEventType, raw); err != nil {
+		return errs.Internal(fmt.Errorf("failed to update %s: %w", localBridgeStateEventType, err))
+	}
+	_, _ = cli.DB.AccountData.Put(ctx, cli.Account.UserID, event.Type{Type: localBridgeStateEventType, Class: event.AccountDataEventType}, raw)
+	return nil
+}
+
+// markBridgeAccountDeleted edits the local bridge state as generic JSON so
+// fields this server does not model survive the rewrite.
+func markBridgeAccountDeleted(raw json.RawMessage, bridgeID, loginID string) (json.RawMessage, bool, error) {
+	var state map[string]map[string]map[string]any
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, false, fmt.Errorf("failed to parse %s: %w", localBridgeStateEventType, err)
+	}
+	account, ok := state[bridgeID][loginID]
+	if !ok {
+		return raw, false, nil
+	}
+	account["state"] = "DELETED"
+	updated, err := json.Marshal(state)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode %s: %w", localBridgeStateEventType, err)
+	}
+	return updated, true, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+func TestMapBridgeLoginStep(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	step := mapBridgeLoginStep("whatsapp", bridgeLoginResponse{
+		LoginID: "proc1",
+		LoginStep: bridgev2.LoginStep{
+			Type:   bridgev2.LoginStepTypeUserInput,
+			StepID: "fi.mau.whatsapp.phone",
+			UserInputParams: &bridgev2.LoginUserInputParams{Fields: []bridgev2.LoginInputDataField{
+				{Type: bridgev2.LoginInputFieldTypePhoneNumber, ID: "phone", Name: "Phone number"},
+			}},
+		},
+	}, now)
+	if step.LoginProcessID != "proc1" || step.Type != "user_input" || len(step.Fields) != 1 || step.Fields[0].Type != "phone_number" {
+		t.Fatalf("unexpected step: %+v", step)
+	}
+
+	done := mapBridgeLoginStep("whatsapp", bridgeLoginResponse{
+		LoginID: "proc1",
+		LoginStep: bridgev2.LoginStep{
+			Type:           bridgev2.LoginStepTypeComplete,
+			StepID:         "fi.mau.whatsapp.complete",
+			CompleteParams: &bridgev2.LoginCompleteParams{UserLoginID: "15551234567"},
+		},
+	}, now)
+	if done.AccountID != "whatsapp_15551234567" {
+		t.Fatalf("AccountID = %q", done.AccountID)
+	}
+}
+
+func TestBridgeConnectErrorKeepsBridgeMessage(t *testing.T) {
+	err := bridgeConnectError(mautrix.HTTPError{
+		Response:  &http.Response{StatusCode: http.StatusBadRequest},
+		RespError: &mautrix.RespError{ErrCode: "FI.MAU.WHATSAPP.INVALID_CODE", Err: "Invalid code"},
+	}, "submit login step")
+	var apiErr *errs.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "BRIDGE_LOGIN_FAILED" || apiErr.Message != "Invalid code" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBridgeLoginTrackerPrunesOldLogins(t *testing.T) {
+	tracker := newBridgeLoginTracker()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker.put(mapBridgeLoginStep("signal", bridgeLoginResponse{LoginID: "old"}, start))
+	tracker.put(mapBridgeLoginStep("signal", bridgeLoginResponse{LoginID: "new"}, start.Add(2*bridgeLoginRetention)))
+	if _, ok := tracker.get("signal", "old"); ok {
+		t.Fatal("expected the abandoned login to be pruned")
+	}
+	if _, ok := tracker.get("signal", "new"); !ok {
+		t.Fatal("expected the new login to be tracked")
+	}
+}
+
+func TestMarkBridgeAccountDeletedKeepsUnknownFields(t *testing.T) {
+	raw := json.RawMessage(`{"whatsapp":{"123":{"state":"CONNECTED","devices":{"DEV":{"state":"CONNECTED"}},"extra":1}}}`)
+	updated, changed, err := markBridgeAccountDeleted(raw, "whatsapp", "123")
+	if err != nil || !changed {
+		t.Fatalf("markBridgeAccountDeleted = %v, %v", changed, err)
+	}
+	var state localBridgeStateContent
+	if err = json.Unmarshal(updated, &state); err != nil {
+		t.Fatalf("failed to parse updated state: %v", err)
+	}
+	if isConfiguredLocalAccount(state["whatsapp"]["123"], "DEV") {
+		t.Fatal("expected the account to no longer be listed")
+	}
+	if !strings.Contains(string(updated), `"extra":1`) {
+		t.Fatalf("unknown fields were dropped: %s", updated)
+	}
+	if _, changed, _ = markBridgeAccountDeleted(raw, "signal", "123"); changed {
+		t.Fatal("expected an unknown account to leave the state unchanged")
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	calendarEventDuration = 15 * time.Minute
+	calendarICSTimeFormat = "20060102T150405Z"
+)
+
+// calendarReminder is one chat reminder rendered as a calendar event.
+type calendarReminder struct {
+	ChatID   string
+	Title    string
+	RemindAt time.Time
+}
+
+// calendarFeed renders active chat reminders as an iCalendar feed.
+// Calendar apps subscribe by URL, so the route also accepts the calendar feed
+// token from POST /v1/calendar/feed-token in the query string.
+func (s *Server) calendarFeed(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	rooms, err := s.loadRoomsSorted(ctx)
+	if err != nil {
+		return err
+	}
+	states, err := s.loadRoomAccountDataStates(ctx)
+	if err != nil {
+		return err
+	}
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	policy := s.requestPolicy(r)
+
+	titles := make(map[id.RoomID]string, len(rooms))
+	for _, room := range rooms {
+		if room.Name != nil && *room.Name != "" {
+			titles[room.ID] = *room.Name
+		}
+	}
+	var reminders []calendarReminder
+	for roomID, state := range states {
+		if state.ReminderAtMS == nil || *state.ReminderAtMS <= 0 {
+			continue
+		}
+		accountID, _ := inferAccountForRoom(roomID, lookup)
+		if !policy.allowsChat(string(roomID), accountID) {
+			continue
+		}
+		title := titles[roomID]
+		if title == "" {
+			title = string(roomID)
+		}
+		reminders = append(reminders, calendarReminder{
+			ChatID:   string(roomID),
+			Title:    title,
+			RemindAt: time.UnixMilli(*state.ReminderAtMS).UTC(),
+		})
+	}
+	sort.Slice(reminders, func(i, j int) bool {
+		return reminders[i].RemindAt.Before(reminders[j].RemindAt)
+	})
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	_, err = io.WriteString(w, formatCalendar(reminders, time.Now().UTC()))
+	return err
+}
+
+func formatCalendar(reminders []calendarReminder, now time.Time) string {
+	var builder strings.Builder
+	writeLine := func(line string) {
+		builder.WriteString(foldContentLine(line))
+		builder.WriteString("\r\n")
+	}
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//EasyMatrix//Chat reminders//EN")
+	writeLine("CALSCALE:GREGORIAN")
+	writeLine("X-WR-CALNAME:Chat reminders")
+	for _, reminder := range reminders {
+		writeLine("BEGIN:VEVENT")
+		// The UID stays stable across reschedules so calendar apps move the
+		// event instead of duplicating it.
+		writeLine("UID:reminder-" + escapeContentLineText(reminder.ChatID) + "@easymatrix")
+		writeLine("DTSTAMP:" + now.Format(calendarICSTimeFormat))
+		writeLine("DTSTART:" + reminder.RemindAt.Format(calendarICSTimeFormat))
+		writeLine("DTEND:" + reminder.RemindAt.Add(calendarEventDuration).Format(calendarICSTimeFormat))
+		writeLine("SUMMARY:" + escapeContentLineText("Follow up: "+reminder.Title))
+		writeLine("DESCRIPTION:" + escapeContentLineText("Chat ID: "+reminder.ChatID))
+		writeLine("BEGIN:VALARM")
+		writeLine("ACTION:DISPLAY")
+		writeLine("DESCRIPTION:" + escapeContentLineText("Follow up: "+reminder.Title))
+		writeLine("TRIGGER:PT0M")
+		writeLine("END:VALARM")
+		writeLine("END:VEVENT")
+	}
+	writeLine("END:VCALENDAR")
+	return builder.String()
+}
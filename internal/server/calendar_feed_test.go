@@ -0,0 +1,35 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatCalendarRendersReminderEvents(t *testing.T) {
+	remindAt := time.Date(2024, 3, 5, 9, 30, 0, 0, time.UTC)
+	ics := formatCalendar([]calendarReminder{{ChatID: "!room:example.org", Title: "Team, Ops", RemindAt: remindAt}}, remindAt)
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:reminder-!room:example.org@easymatrix\r\n",
+		"DTSTART:20240305T093000Z\r\n",
+		"DTEND:20240305T094500Z\r\n",
+		`SUMMARY:Follow up: Team\, Ops` + "\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Fatalf("calendar missing %q:\n%s", want, ics)
+		}
+	}
+}
+
+func TestApplyRoomAccountDataContentReadsReminder(t *testing.T) {
+	state := applyRoomAccountDataContent(roomAccountDataState{}, chatReminderEventType, []byte(`{"reminder":{"remindAtMs":1700000000000},"remind_at_ms":1}`))
+	if state.ReminderAtMS == nil || *state.ReminderAtMS != 1700000000000 {
+		t.Fatalf("unexpected reminder %v", state.ReminderAtMS)
+	}
+	state = applyRoomAccountDataContent(state, chatReminderEventType, []byte(`{}`))
+	if state.ReminderAtMS != nil {
+		t.Fatalf("expected cleared reminder, got %v", *state.ReminderAtMS)
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const calendarFeedTokenStoreFormat = 1
+
+// calendarFeedTokenStore keeps the hash of the single calendar feed token.
+// Calendar apps put credentials in the subscription URL, so the feed gets
+// its own token that can read nothing else and is revoked by rotating it.
+type calendarFeedTokenStore struct {
+	path string
+
+	mu        sync.Mutex
+	loaded    bool
+	tokenHash string
+	createdAt time.Time
+}
+
+type calendarFeedTokenPersisted struct {
+	Version   int       `json:"version"`
+	TokenHash string    `json:"tokenHash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newCalendarFeedTokenStore(path string) *calendarFeedTokenStore {
+	return &calendarFeedTokenStore{path: path}
+}
+
+func hashCalendarFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *calendarFeedTokenStore) loadLocked() error {
+	if c.loaded {
+		return nil
+	}
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.loaded = true
+			return nil
+		}
+		return fmt.Errorf("failed to read calendar feed token: %w", err)
+	}
+	var persisted calendarFeedTokenPersisted
+	if err = json.Unmarshal(raw, &persisted); err != nil {
+		return fmt.Errorf("failed to parse calendar feed token: %w", err)
+	}
+	if persisted.Version != calendarFeedTokenStoreFormat {
+		return fmt.Errorf("unsupported calendar feed token store version: %d", persisted.Version)
+	}
+	c.tokenHash = persisted.TokenHash
+	c.createdAt = persisted.CreatedAt
+	c.loaded = true
+	return nil
+}
+
+func (c *calendarFeedTokenStore) saveLocked(tokenHash string, createdAt time.Time) error {
+	if tokenHash == "" {
+		if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove calendar feed token: %w", err)
+		}
+	} else {
+		raw, err := json.Marshal(calendarFeedTokenPersisted{Version: calendarFeedTokenStoreFormat, TokenHash: tokenHash, CreatedAt: createdAt})
+		if err != nil {
+			return fmt.Errorf("failed to encode calendar feed token: %w", err)
+		}
+		if err = writeAtomicFile(c.path, raw, 0o600); err != nil {
+			return err
+		}
+	}
+	c.tokenHash = tokenHash
+	c.createdAt = createdAt
+	return nil
+}
+
+// rotate issues a new token, revoking the previous one.
+func (c *calendarFeedTokenStore) rotate(now time.Time) (string, error) {
+	token, err := randomHexToken(32)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = c.loadLocked(); err != nil {
+		return "", err
+	}
+	if err = c.saveLocked(hashCalendarFeedToken(token), now.UTC()); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (c *calendarFeedTokenStore) revoke() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return false, err
+	}
+	if c.tokenHash == "" {
+		return false, nil
+	}
+	return true, c.saveLocked("", time.Time{})
+}
+
+func (c *calendarFeedTokenStore) valid(token string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return false, err
+	}
+	if c.tokenHash == "" || token == "" {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(c.tokenHash), []byte(hashCalendarFeedToken(token))) == 1, nil
+}
+
+// calendarFeedHandler serves the feed to either a calendar feed token in the
+// query string or a regular bearer token in the header. Access tokens are not
+// accepted in the query, so subscription URLs never carry full access.
+func (s *Server) calendarFeedHandler() http.Handler {
+	wrapped := s.wrap(s.calendarFeed, defaultBodyLimitBytes, nil)
+	bearer := s.auth.Wrap(wrapped, false, []string{"read"})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			bearer.ServeHTTP(w, r)
+			return
+		}
+		ok, err := s.calendarFeedTokens.valid(token)
+		if err != nil {
+			errs.Write(w, errs.Internal(err))
+			return
+		}
+		if !ok {
+			errs.Write(w, errs.Unauthorized("Invalid or revoked calendar feed token"))
+			return
+		}
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// createCalendarFeedToken issues the calendar feed token and returns the
+// subscription URL. Issuing a new token revokes the previous one.
+func (s *Server) createCalendarFeedToken(w http.ResponseWriter, r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Calendar feed tokens are only available to the deployment owner")
+	}
+	now := time.Now().UTC()
+	token, err := s.calendarFeedTokens.rotate(now)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, compat.CalendarFeedToken{
+		Token:     token,
+		URL:       s.requestBaseURL(r) + "/v1/calendar.ics?token=" + url.QueryEscape(token),
+		CreatedAt: now,
+	})
+}
+
+func (s *Server) deleteCalendarFeedToken(w http.ResponseWriter, r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Calendar feed tokens are only available to the deployment owner")
+	}
+	revoked, err := s.calendarFeedTokens.revoke()
+	if err != nil {
+		return errs.Internal(err)
+	}
+	if !revoked {
+		return errs.NotFound("No calendar feed token is issued")
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/auth"
+)
+
+func TestCalendarFeedTokenStoreRotatesAndRevokes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar-feed.json")
+	store := newCalendarFeedTokenStore(path)
+	first, err := store.rotate(time.Now())
+	if err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	second, err := store.rotate(time.Now())
+	if err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	reloaded := newCalendarFeedTokenStore(path)
+	if ok, _ := reloaded.valid(first); ok {
+		t.Fatal("expected rotating to revoke the previous token")
+	}
+	if ok, _ := reloaded.valid(second); !ok {
+		t.Fatal("expected the current token to survive a reload")
+	}
+	if revoked, err := reloaded.revoke(); err != nil || !revoked {
+		t.Fatalf("revoke = %v, %v", revoked, err)
+	}
+	if ok, _ := newCalendarFeedTokenStore(path).valid(second); ok {
+		t.Fatal("expected revoked token to be rejected")
+	}
+	if revoked, _ := reloaded.revoke(); revoked {
+		t.Fatal("expected revoking twice to report nothing revoked")
+	}
+}
+
+func TestCalendarFeedRejectsAccessTokenInQuery(t *testing.T) {
+	s := &Server{
+		auth:               auth.New("secret", true),
+		calendarFeedTokens: newCalendarFeedTokenStore(filepath.Join(t.TempDir(), "calendar-feed.json")),
+	}
+	if _, err := s.calendarFeedTokens.rotate(time.Now()); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	handler := s.calendarFeedHandler()
+	for _, target := range []string{
+		"/v1/calendar.ics?token=secret",
+		"/v1/calendar.ics?dangerouslyUseTokenInQuery=secret",
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+	}
+}
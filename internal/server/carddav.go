@@ -0,0 +1,433 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/batuhan/easymatrix/internal/auth"
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// The CardDAV address book is read-only and has a single collection holding
+// the contacts of every connected account, merged by phone number or email.
+// Phones and mail clients can't send bearer tokens, so these routes also
+// accept HTTP basic auth with the access token as the password.
+const (
+	cardDAVRoot           = "/carddav/"
+	cardDAVAddressBook    = "/carddav/contacts/"
+	cardDAVContentType    = "text/vcard; charset=utf-8"
+	cardDAVXMLContentType = "application/xml; charset=utf-8"
+	cardDAVNamespace      = "urn:ietf:params:xml:ns:carddav"
+)
+
+type addressBookCard struct {
+	UID   string
+	ETag  string
+	VCard string
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"d:multistatus"`
+	XmlnsD    string        `xml:"xmlns:d,attr"`
+	XmlnsCard string        `xml:"xmlns:card,attr"`
+	XmlnsCS   string        `xml:"xmlns:cs,attr"`
+	Responses []davResponse `xml:"d:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"d:href"`
+	Propstat davPropstat `xml:"d:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"d:prop"`
+	Status string  `xml:"d:status"`
+}
+
+type davProp struct {
+	ResourceType         *davResourceType `xml:"d:resourcetype,omitempty"`
+	DisplayName          string           `xml:"d:displayname,omitempty"`
+	CurrentUserPrincipal *davHref         `xml:"d:current-user-principal,omitempty"`
+	AddressBookHomeSet   *davHref         `xml:"card:addressbook-home-set,omitempty"`
+	GetCTag              string           `xml:"cs:getctag,omitempty"`
+	SyncToken            string           `xml:"d:sync-token,omitempty"`
+	GetETag              string           `xml:"d:getetag,omitempty"`
+	GetContentType       string           `xml:"d:getcontenttype,omitempty"`
+	AddressData          string           `xml:"card:address-data,omitempty"`
+}
+
+type davResourceType struct {
+	Collection  *struct{} `xml:"d:collection,omitempty"`
+	AddressBook *struct{} `xml:"card:addressbook,omitempty"`
+}
+
+type davHref struct {
+	Href string `xml:"d:href"`
+}
+
+// davReport is either an addressbook-multiget listing hrefs or an
+// addressbook-query. Query filters are ignored; every card is returned.
+type davReport struct {
+	XMLName xml.Name
+	Hrefs   []string `xml:"DAV: href"`
+}
+
+func (s *Server) handleCardDAV(mux *http.ServeMux, pattern string, handler apiHandler) {
+	protected := s.auth.Wrap(s.wrap(handler, defaultBodyLimitBytes, nil), false, []string{"read"})
+	mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Clients only send basic credentials after being challenged for them.
+		if r.Header.Get("Authorization") == "" && r.Header.Get("X-Beeper-Access-Token") == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="EasyMatrix CardDAV"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// Address books cannot send bearer tokens, so the token arrives as
+		// the basic auth password. No other route accepts basic auth.
+		if _, password, ok := r.BasicAuth(); ok {
+			r = r.Clone(r.Context())
+			r.Header = r.Header.Clone()
+			r.Header.Set("Authorization", "Bearer "+strings.TrimSpace(password))
+		}
+		protected.ServeHTTP(w, r)
+	}))
+}
+
+func (s *Server) cardDAVWellKnown(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, auth.BasePath(r)+cardDAVRoot, http.StatusMovedPermanently)
+}
+
+func (s *Server) cardDAVOptions(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("DAV", "1, 3, addressbook")
+	w.Header().Set("Allow", "OPTIONS, GET, PROPFIND, REPORT")
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) cardDAVPropfind(w http.ResponseWriter, r *http.Request) error {
+	depthOne := r.Header.Get("Depth") != "0"
+	base := auth.BasePath(r)
+	home := davResponse{Href: base + cardDAVRoot, Propstat: davPropstat{Prop: davProp{
+		ResourceType:         &davResourceType{Collection: &struct{}{}},
+		CurrentUserPrincipal: &davHref{Href: base + cardDAVRoot},
+		AddressBookHomeSet:   &davHref{Href: base + cardDAVRoot},
+	}}}
+
+	switch cleaned := cleanCardDAVPath(r.URL.Path); {
+	case cleaned == cardDAVRoot:
+		responses := []davResponse{home}
+		if depthOne {
+			cards, err := s.loadAddressBook(r.Context(), r)
+			if err != nil {
+				return err
+			}
+			responses = append(responses, addressBookCollectionResponse(base, cards))
+		}
+		return writeDAVMultistatus(w, responses)
+	case cleaned == cardDAVAddressBook:
+		cards, err := s.loadAddressBook(r.Context(), r)
+		if err != nil {
+			return err
+		}
+		responses := []davResponse{addressBookCollectionResponse(base, cards)}
+		if depthOne {
+			for _, card := range cards {
+				responses = append(responses, cardResponse(base, card, false))
+			}
+		}
+		return writeDAVMultistatus(w, responses)
+	default:
+		card, err := s.findAddressBookCard(r, cleaned)
+		if err != nil {
+			return err
+		}
+		return writeDAVMultistatus(w, []davResponse{cardResponse(base, card, false)})
+	}
+}
+
+func (s *Server) cardDAVReport(w http.ResponseWriter, r *http.Request) error {
+	var report davReport
+	if err := xml.NewDecoder(r.Body).Decode(&report); err != nil && !errors.Is(err, io.EOF) {
+		if tooLarge := payloadTooLargeError(err); tooLarge != nil {
+			return tooLarge
+		}
+		return errs.Validation(map[string]any{"body": "invalid REPORT body"})
+	}
+	cards, err := s.loadAddressBook(r.Context(), r)
+	if err != nil {
+		return err
+	}
+	base := auth.BasePath(r)
+	responses := make([]davResponse, 0, len(cards))
+	if report.XMLName.Space == cardDAVNamespace && report.XMLName.Local == "addressbook-multiget" {
+		byHref := make(map[string]addressBookCard, len(cards))
+		for _, card := range cards {
+			byHref[cardHref(card)] = card
+		}
+		for _, href := range report.Hrefs {
+			// Clients echo the hrefs we handed out, which carry the
+			// session's base path; the cards are keyed without it.
+			unprefixed, _ := strings.CutPrefix(strings.TrimSpace(href), base)
+			if card, ok := byHref[cleanCardDAVPath(unprefixed)]; ok {
+				responses = append(responses, cardResponse(base, card, true))
+			} else {
+				responses = append(responses, davResponse{Href: href, Propstat: davPropstat{Status: "HTTP/1.1 404 Not Found"}})
+			}
+		}
+	} else {
+		for _, card := range cards {
+			responses = append(responses, cardResponse(base, card, true))
+		}
+	}
+	return writeDAVMultistatus(w, responses)
+}
+
+// cardDAVGet serves a single card, or the whole address book as one .vcf
+// file for clients that can only subscribe to a URL.
+func (s *Server) cardDAVGet(w http.ResponseWriter, r *http.Request) error {
+	cleaned := cleanCardDAVPath(r.URL.Path)
+	var body string
+	switch cleaned {
+	case cardDAVRoot, cardDAVAddressBook:
+		cards, err := s.loadAddressBook(r.Context(), r)
+		if err != nil {
+			return err
+		}
+		var builder strings.Builder
+		for _, card := range cards {
+			builder.WriteString(card.VCard)
+		}
+		body = builder.String()
+		w.Header().Set("Content-Disposition", `attachment; filename="contacts.vcf"`)
+	default:
+		card, err := s.findAddressBookCard(r, cleaned)
+		if err != nil {
+			return err
+		}
+		body = card.VCard
+		w.Header().Set("ETag", card.ETag)
+	}
+	w.Header().Set("Content-Type", cardDAVContentType)
+	_, err := io.WriteString(w, body)
+	return err
+}
+
+func (s *Server) findAddressBookCard(r *http.Request, cleaned string) (addressBookCard, error) {
+	cards, err := s.loadAddressBook(r.Context(), r)
+	if err != nil {
+		return addressBookCard{}, err
+	}
+	for _, card := range cards {
+		if cardHref(card) == cleaned {
+			return card, nil
+		}
+	}
+	return addressBookCard{}, errs.NotFound("Contact not found")
+}
+
+// loadAddressBook merges the contacts of every account the caller may see.
+func (s *Server) loadAddressBook(ctx context.Context, r *http.Request) ([]addressBookCard, error) {
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policy := s.requestPolicy(r)
+	var contacts []addressBookContact
+	for _, account := range lookup.Accounts {
+		if !policy.allowsAccount(account.AccountID) {
+			continue
+		}
+		users, err := s.loadAccountContacts(ctx, lookup, account.AccountID, "", false)
+		if err != nil {
+			return nil, err
+		}
+		network := account.Network
+		if network == "" {
+			network = account.AccountID
+		}
+		for _, user := range users {
+			contacts = append(contacts, addressBookContact{User: user, Networks: []string{network}})
+		}
+	}
+	merged := mergeAddressBookContacts(contacts)
+	cards := make([]addressBookCard, 0, len(merged))
+	for _, contact := range merged {
+		cards = append(cards, newAddressBookCard(contact))
+	}
+	return cards, nil
+}
+
+type addressBookContact struct {
+	User     compat.User
+	Networks []string
+}
+
+// addressBookKey prefers identifiers that are stable across networks, so the
+// same person reached via WhatsApp and Signal ends up as a single card.
+func addressBookKey(user compat.User) string {
+	switch {
+	case user.PhoneNumber != "":
+		return "phone:" + user.PhoneNumber
+	case user.Email != "":
+		return "email:" + user.Email
+	default:
+		return contactCandidateKey(user)
+	}
+}
+
+func mergeAddressBookContacts(contacts []addressBookContact) []addressBookContact {
+	index := make(map[string]int, len(contacts))
+	merged := make([]addressBookContact, 0, len(contacts))
+	for _, contact := range contacts {
+		key := addressBookKey(contact.User)
+		if idx, ok := index[key]; ok {
+			merged[idx].User = mergeContactUsers(merged[idx].User, contact.User)
+			for _, network := range contact.Networks {
+				if !slices.Contains(merged[idx].Networks, network) {
+					merged[idx].Networks = append(merged[idx].Networks, network)
+				}
+			}
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, addressBookContact{User: contact.User, Networks: slices.Clone(contact.Networks)})
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return strings.ToLower(merged[i].User.FullName) < strings.ToLower(merged[j].User.FullName)
+	})
+	return merged
+}
+
+func newAddressBookCard(contact addressBookContact) addressBookCard {
+	uidSum := sha256.Sum256([]byte(addressBookKey(contact.User)))
+	uid := hex.EncodeToString(uidSum[:16])
+	vcard := formatVCard(uid, contact)
+	etagSum := sha256.Sum256([]byte(vcard))
+	return addressBookCard{UID: uid, ETag: `"` + hex.EncodeToString(etagSum[:16]) + `"`, VCard: vcard}
+}
+
+func formatVCard(uid string, contact addressBookContact) string {
+	user := contact.User
+	var builder strings.Builder
+	writeLine := func(line string) {
+		builder.WriteString(foldContentLine(line))
+		builder.WriteString("\r\n")
+	}
+	writeLine("BEGIN:VCARD")
+	writeLine("VERSION:3.0")
+	writeLine("UID:" + uid)
+	writeLine("FN:" + escapeContentLineText(user.FullName))
+	writeLine("N:;" + escapeContentLineText(user.FullName) + ";;;")
+	if user.PhoneNumber != "" {
+		writeLine("TEL;TYPE=CELL:" + escapeContentLineText(user.PhoneNumber))
+	}
+	if user.Email != "" {
+		writeLine("EMAIL;TYPE=INTERNET:" + escapeContentLineText(user.Email))
+	}
+	if user.Username != "" {
+		writeLine("NICKNAME:" + escapeContentLineText(user.Username))
+	}
+	if strings.HasPrefix(user.ID, "@") {
+		writeLine("IMPP:matrix:u/" + strings.TrimPrefix(user.ID, "@"))
+	}
+	if len(contact.Networks) > 0 {
+		networks := make([]string, len(contact.Networks))
+		for idx, network := range contact.Networks {
+			networks[idx] = escapeContentLineText(network)
+		}
+		writeLine("CATEGORIES:" + strings.Join(networks, ","))
+	}
+	writeLine("END:VCARD")
+	return builder.String()
+}
+
+var contentLineTextEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", "", ",", `\,`, ";", `\;`)
+
+func escapeContentLineText(value string) string {
+	return contentLineTextEscaper.Replace(value)
+}
+
+// foldContentLine wraps lines at 75 octets as vCard and iCalendar require,
+// without splitting multi-byte characters.
+func foldContentLine(line string) string {
+	const maxOctets = 75
+	if len(line) <= maxOctets {
+		return line
+	}
+	var builder strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > maxOctets {
+			builder.WriteString("\r\n ")
+			width = 1
+		}
+		builder.WriteRune(r)
+		width += size
+	}
+	return builder.String()
+}
+
+func cardHref(card addressBookCard) string {
+	return cardDAVAddressBook + card.UID + ".vcf"
+}
+
+func cleanCardDAVPath(raw string) string {
+	cleaned := path.Clean("/" + strings.TrimSpace(raw))
+	if cleaned+"/" == cardDAVRoot || cleaned+"/" == cardDAVAddressBook {
+		return cleaned + "/"
+	}
+	return cleaned
+}
+
+func addressBookCollectionResponse(base string, cards []addressBookCard) davResponse {
+	tags := make([]string, len(cards))
+	for idx, card := range cards {
+		tags[idx] = card.ETag
+	}
+	sort.Strings(tags)
+	sum := sha256.Sum256([]byte(strings.Join(tags, ",")))
+	ctag := hex.EncodeToString(sum[:16])
+	return davResponse{Href: base + cardDAVAddressBook, Propstat: davPropstat{Prop: davProp{
+		ResourceType: &davResourceType{Collection: &struct{}{}, AddressBook: &struct{}{}},
+		DisplayName:  "Beeper contacts",
+		GetCTag:      ctag,
+		SyncToken:    "urn:easymatrix:carddav:" + ctag,
+	}}}
+}
+
+func cardResponse(base string, card addressBookCard, includeData bool) davResponse {
+	prop := davProp{GetETag: card.ETag, GetContentType: cardDAVContentType}
+	if includeData {
+		prop.AddressData = card.VCard
+	}
+	return davResponse{Href: base + cardHref(card), Propstat: davPropstat{Prop: prop}}
+}
+
+func writeDAVMultistatus(w http.ResponseWriter, responses []davResponse) error {
+	for idx := range responses {
+		if responses[idx].Propstat.Status == "" {
+			responses[idx].Propstat.Status = "HTTP/1.1 200 OK"
+		}
+	}
+	w.Header().Set("Content-Type", cardDAVXMLContentType)
+	w.WriteHeader(http.StatusMultiStatus)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(davMultistatus{
+		XmlnsD:    "DAV:",
+		XmlnsCard: cardDAVNamespace,
+		XmlnsCS:   "http://calendarserver.org/ns/",
+		Responses: responses,
+	})
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/beeper/desktop-api-go/shared"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/auth"
+)
+
+func TestMergeAddressBookContactsJoinsByPhoneNumber(t *testing.T) {
+	merged := mergeAddressBookContacts([]addressBookContact{
+		{User: shared.User{ID: "@wa_1:beeper.local", FullName: "Alice", PhoneNumber: "+15550001"}, Networks: []string{"whatsapp"}},
+		{User: shared.User{ID: "@bob:beeper.com", FullName: "Bob"}, Networks: []string{"matrix"}},
+		{User: shared.User{ID: "@signal_1:beeper.local", FullName: "Alice S", PhoneNumber: "+15550001", Email: "alice@example.com"}, Networks: []string{"signal"}},
+	})
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 contacts, got %#v", merged)
+	}
+	alice := merged[0]
+	if alice.User.FullName != "Alice" || alice.User.Email != "alice@example.com" || !slices.Equal(alice.Networks, []string{"whatsapp", "signal"}) {
+		t.Fatalf("unexpected merged contact %#v", alice)
+	}
+}
+
+func TestFormatVCardEscapesAndFolds(t *testing.T) {
+	vcard := formatVCard("uid1", addressBookContact{
+		User:     shared.User{ID: "@alice:example.com", FullName: "Doe, Jane; " + strings.Repeat("x", 80)},
+		Networks: []string{"matrix"},
+	})
+	if !strings.HasPrefix(vcard, "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:uid1\r\n") || !strings.HasSuffix(vcard, "END:VCARD\r\n") {
+		t.Fatalf("unexpected vcard framing %q", vcard)
+	}
+	if !strings.Contains(vcard, `FN:Doe\, Jane\; xxx`) {
+		t.Fatalf("expected escaped name in %q", vcard)
+	}
+	if !strings.Contains(vcard, "IMPP:matrix:u/alice:example.com\r\n") {
+		t.Fatalf("expected matrix IMPP line in %q", vcard)
+	}
+	for _, line := range strings.Split(vcard, "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line longer than 75 octets: %q", line)
+		}
+	}
+}
+
+func TestCleanCardDAVPath(t *testing.T) {
+	for raw, want := range map[string]string{
+		"/carddav":                 cardDAVRoot,
+		"/carddav/contacts":        cardDAVAddressBook,
+		"/carddav/contacts/a.vcf":  "/carddav/contacts/a.vcf",
+		"/carddav/../carddav/x//y": "/carddav/x/y",
+	} {
+		if got := cleanCardDAVPath(raw); got != want {
+			t.Fatalf("cleanCardDAVPath(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestCardDAVHrefsKeepSessionBasePath(t *testing.T) {
+	s := newDBTestServer(t)
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+	insertTestMember(t, s, roomID, "@alice:example.org", event.MembershipJoin)
+	if _, err := s.rt.Client().DB.Exec(context.Background(), `UPDATE room SET sorting_timestamp = 1700000000000, room_type = ''`); err != nil {
+		t.Fatalf("failed to mark room as synced: %v", err)
+	}
+	const base = "/sessions/work"
+	newRequest := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		return auth.WithBasePath(req, base)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := s.cardDAVPropfind(rec, newRequest("PROPFIND", cardDAVAddressBook, "")); err != nil {
+		t.Fatalf("PROPFIND returned error: %v", err)
+	}
+	hrefs := regexp.MustCompile(`<d:href>([^<]*)</d:href>`).FindAllStringSubmatch(rec.Body.String(), -1)
+	var cardHref string
+	for _, match := range hrefs {
+		if !strings.HasPrefix(match[1], base+cardDAVAddressBook) {
+			t.Fatalf("href %q is missing the session base path", match[1])
+		}
+		if strings.HasSuffix(match[1], ".vcf") {
+			cardHref = match[1]
+		}
+	}
+	if cardHref == "" {
+		t.Fatalf("expected a card in the address book, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	report := `<C:addressbook-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav"><D:href>` + cardHref + `</D:href></C:addressbook-multiget>`
+	if err := s.cardDAVReport(rec, newRequest("REPORT", cardDAVAddressBook, report)); err != nil {
+		t.Fatalf("REPORT returned error: %v", err)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "404 Not Found") || !strings.Contains(body, "BEGIN:VCARD") {
+		t.Fatalf("expected the multiget to return the card, got %s", body)
+	}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/cursor"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	changeJournalFormat = 1
+	changesDefaultLimit = 100
+	changesMaxLimit     = 500
+)
+
+// changeJournal assigns every chat touched by sync a monotonically increasing
+// sequence number. Only the latest sequence per chat is kept, which is all
+// a polling consumer needs to reconcile.
+type changeJournal struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	replica replicaFile
+	epoch   string
+	seq     int64
+	entries map[string]changeJournalEntry
+}
+
+type changeJournalEntry struct {
+	Seq     int64 `json:"seq"`
+	Deleted bool  `json:"deleted,omitempty"`
+}
+
+type changeJournalPersisted struct {
+	Version int                           `json:"version"`
+	Epoch   string                        `json:"epoch"`
+	Seq     int64                         `json:"seq"`
+	Entries map[string]changeJournalEntry `json:"entries"`
+}
+
+// changesCursor positions a consumer in the journal: everything up to Seq
+// has been delivered, except that while paging through chats sharing Seq,
+// ChatID marks the last one returned. Epoch changes whenever the journal is
+// recreated, which forces a full resync from the start.
+type changesCursor struct {
+	Epoch  string `json:"epoch"`
+	Seq    int64  `json:"seq"`
+	ChatID string `json:"chatID,omitempty"`
+}
+
+func newChangeJournal(path string) *changeJournal {
+	return &changeJournal{path: path}
+}
+
+func (j *changeJournal) loadLocked() error {
+	stamp, changed, err := j.replica.changed(j.path)
+	if err != nil {
+		return fmt.Errorf("failed to read change journal: %w", err)
+	}
+	if j.loaded && !changed {
+		return nil
+	}
+	raw, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		j.epoch, j.seq, j.entries = randomID(), 0, make(map[string]changeJournalEntry)
+		j.replica.seen, j.loaded = stamp, true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read change journal: %w", err)
+	}
+	var persisted changeJournalPersisted
+	if err = json.Unmarshal(raw, &persisted); err != nil {
+		return fmt.Errorf("failed to parse change journal: %w", err)
+	}
+	if persisted.Version != changeJournalFormat {
+		return fmt.Errorf("unsupported change journal version: %d", persisted.Version)
+	}
+	j.epoch, j.seq, j.entries = persisted.Epoch, persisted.Seq, persisted.Entries
+	if j.entries == nil {
+		j.entries = make(map[string]changeJournalEntry)
+	}
+	j.replica.seen, j.loaded = stamp, true
+	return nil
+}
+
+func (j *changeJournal) saveLocked() error {
+	raw, err := json.Marshal(changeJournalPersisted{Version: changeJournalFormat, Epoch: j.epoch, Seq: j.seq, Entries: j.entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode change journal: %w", err)
+	}
+	return writeAtomicFile(j.path, raw, 0o600)
+}
+
+// record bumps the sequence of every chat referenced by a sync batch. The
+// journal is saved before returning so a restart never reuses a sequence
+// number a consumer has already seen.
+func (j *changeJournal) record(events []wsDomainEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.loadLocked(); err != nil {
+		return err
+	}
+	for _, domainEvent := range events {
+		if domainEvent.ChatID == "" {
+			continue
+		}
+		j.seq++
+		j.entries[domainEvent.ChatID] = changeJournalEntry{Seq: j.seq, Deleted: domainEvent.Type == wsDomainTypeChatDeleted}
+	}
+	return j.saveLocked()
+}
+
+func (j *changeJournal) snapshot() (string, int64, map[string]changeJournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.loadLocked(); err != nil {
+		return "", 0, nil, err
+	}
+	entries := make(map[string]changeJournalEntry, len(j.entries))
+	for chatID, entry := range j.entries {
+		entries[chatID] = entry
+	}
+	return j.epoch, j.seq, entries, nil
+}
+
+type changeCandidate struct {
+	chatID  string
+	seq     int64
+	room    *database.Room
+	deleted bool
+}
+
+// collectChanges orders candidates by (seq, chatID) and keeps those after
+// the cursor. Chats never seen by the journal sort first with seq 0, so a
+// fresh cursor (seq -1) walks every chat before any recorded change.
+func collectChanges(rooms []*database.Room, entries map[string]changeJournalEntry, after changesCursor, limit int) ([]changeCandidate, bool) {
+	candidates := make([]changeCandidate, 0)
+	live := make(map[string]struct{}, len(rooms))
+	for _, room := range rooms {
+		chatID := string(room.ID)
+		live[chatID] = struct{}{}
+		candidates = append(candidates, changeCandidate{chatID: chatID, seq: entries[chatID].Seq, room: room})
+	}
+	for chatID, entry := range entries {
+		if _, ok := live[chatID]; !ok && entry.Deleted {
+			candidates = append(candidates, changeCandidate{chatID: chatID, seq: entry.Seq, deleted: true})
+		}
+	}
+	filtered := candidates[:0]
+	for _, candidate := range candidates {
+		if candidate.seq > after.Seq || (after.ChatID != "" && candidate.seq == after.Seq && candidate.chatID > after.ChatID) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].seq != filtered[j].seq {
+			return filtered[i].seq < filtered[j].seq
+		}
+		return filtered[i].chatID < filtered[j].chatID
+	})
+	if len(filtered) > limit {
+		return filtered[:limit], true
+	}
+	return filtered, false
+}
+
+func (s *Server) listChanges(w http.ResponseWriter, r *http.Request) error {
+	limit, err := parseOptionalLimit(r.URL.Query().Get("limit"), changesDefaultLimit, 1, changesMaxLimit, "limit")
+	if err != nil {
+		return err
+	}
+	epoch, seq, entries, err := s.changes.snapshot()
+	if err != nil {
+		return errs.Internal(err)
+	}
+	after := changesCursor{Epoch: epoch, Seq: -1}
+	full := true
+	if raw := strings.TrimSpace(r.URL.Query().Get("since")); raw != "" {
+		var since changesCursor
+		if err = cursor.Decode(raw, &since); err != nil {
+			return errs.Validation(map[string]any{"since": err.Error()})
+		}
+		// A cursor from another journal, or one ahead of it after state was
+		// restored from a backup, can't be resumed safely.
+		if since.Epoch == epoch && since.Seq <= seq {
+			after, full = since, false
+		}
+	}
+
+	ctx := r.Context()
+	rooms, err := s.loadRoomsSorted(ctx)
+	if err != nil {
+		return err
+	}
+	roomStates, err := s.loadRoomAccountDataStates(ctx)
+	if err != nil {
+		return err
+	}
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	visibility := s.requestPolicy(r)
+	page, hasMore := collectChanges(rooms, entries, after, limit)
+
+	output := compat.ChangesOutput{Chats: make([]compat.Chat, 0, len(page)), DeletedChatIDs: make([]string, 0), HasMore: hasMore, Full: full}
+	for _, candidate := range page {
+		after.Seq, after.ChatID = candidate.seq, candidate.chatID
+		if candidate.deleted {
+			accountID, _ := inferAccountForRoom(id.RoomID(candidate.chatID), lookup)
+			if visibility.allowsChat(candidate.chatID, accountID) {
+				output.DeletedChatIDs = append(output.DeletedChatIDs, candidate.chatID)
+			}
+			continue
+		}
+		chat, mapErr := s.mapRoomToChat(ctx, candidate.room, lookup, chatPreviewParticipants, true, roomStates[candidate.room.ID])
+		if mapErr != nil || !visibility.allowsChat(chat.ID, chat.AccountID) {
+			continue
+		}
+		output.Chats = append(output.Chats, chat)
+	}
+	if !hasMore {
+		// Once caught up, resume from the journal head so that unchanged
+		// chats at seq 0 are not replayed.
+		after.Seq, after.ChatID = seq, ""
+	}
+	if err = s.attachChatMetadata(r, output.Chats); err != nil {
+		return err
+	}
+	if output.Cursor, err = cursor.Encode(after); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, output)
+}
+
+func (s *Server) recordChanges(events []wsDomainEvent) {
+	if err := s.changes.record(events); err != nil {
+		log.Printf("failed to record chat changes: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCollectChangesWalksUnseenChatsThenJournal(t *testing.T) {
+	rooms := []*database.Room{{ID: id.RoomID("!a")}, {ID: id.RoomID("!b")}, {ID: id.RoomID("!c")}}
+	entries := map[string]changeJournalEntry{
+		"!b":    {Seq: 2},
+		"!gone": {Seq: 3, Deleted: true},
+	}
+	page, hasMore := collectChanges(rooms, entries, changesCursor{Seq: -1}, 2)
+	if !hasMore || len(page) != 2 || page[0].chatID != "!a" || page[1].chatID != "!c" {
+		t.Fatalf("unexpected first page %#v hasMore=%v", page, hasMore)
+	}
+	page, hasMore = collectChanges(rooms, entries, changesCursor{Seq: 0, ChatID: "!c"}, 2)
+	if hasMore || len(page) != 2 || page[0].chatID != "!b" || !page[1].deleted {
+		t.Fatalf("unexpected second page %#v hasMore=%v", page, hasMore)
+	}
+	page, _ = collectChanges(rooms, entries, changesCursor{Seq: 2}, 10)
+	if len(page) != 1 || page[0].chatID != "!gone" {
+		t.Fatalf("expected only the deletion after seq 2, got %#v", page)
+	}
+}
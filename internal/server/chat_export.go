@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	exportFormatJSON = "json"
+	exportFormatHTML = "html"
+
+	// exportChatIDFile records the exported chat next to the transcript, so
+	// access to the files can still be checked after the job is forgotten.
+	exportChatIDFile = "chat-id"
+
+	exportPageSize = 200
+)
+
+type chatExportInput struct {
+	Format       string `json:"format,omitempty"`
+	IncludeMedia bool   `json:"includeMedia,omitempty"`
+}
+
+// chatExportJobs tracks exports in memory. Finished transcripts stay on disk
+// under the exports dir; only the job status is lost on restart.
+type chatExportJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*compat.ChatExport
+}
+
+func (j *chatExportJobs) put(job compat.ChatExport) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.jobs == nil {
+		j.jobs = make(map[string]*compat.ChatExport)
+	}
+	j.jobs[job.ID] = &job
+}
+
+func (j *chatExportJobs) update(exportID string, fn func(job *compat.ChatExport)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if job, ok := j.jobs[exportID]; ok {
+		fn(job)
+	}
+}
+
+func (j *chatExportJobs) get(exportID string) (compat.ChatExport, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[exportID]
+	if !ok {
+		return compat.ChatExport{}, false
+	}
+	return *job, true
+}
+
+type chatTranscript struct {
+	Chat       compat.Chat      `json:"chat"`
+	Messages   []compat.Message `json:"messages"`
+	ExportedAt time.Time        `json:"exportedAt"`
+}
+
+var chatTranscriptPage = template.Must(template.New("transcript").Parse(`<!doctype html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Chat.Title}}</title>
+  <style>
+    body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; }
+    .msg { margin: 0 0 1rem; }
+    .meta { color: #666; font-size: .85rem; }
+    .text { white-space: pre-wrap; }
+  </style>
+</head>
+<body>
+  <h1>{{.Chat.Title}}</h1>
+  <p class="meta">Exported {{.ExportedAt.Format "2006-01-02 15:04 MST"}}</p>
+  {{range .Messages}}
+  <div class="msg">
+    <div class="meta">{{.SenderName}} &middot; {{.Timestamp.Format "2006-01-02 15:04"}}</div>
+    {{if .Text}}<div class="text">{{.Text}}</div>{{end}}
+    {{range .Attachments}}<div><a href="{{.SrcURL}}">{{if .FileName}}{{.FileName}}{{else}}attachment{{end}}</a></div>{{end}}
+  </div>
+  {{end}}
+</body>
+</html>
+`))
+
+func (s *Server) exportRootDir() string {
+	return filepath.Join(s.rt.StateDir(), "exports")
+}
+
+func (s *Server) startChatExport(w http.ResponseWriter, r *http.Request) error {
+	var req chatExportInput
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	format := strings.ToLower(strings.TrimSpace(req.Format))
+	if format == "" {
+		format = exportFormatJSON
+	}
+	if format != exportFormatJSON && format != exportFormatHTML {
+		return errs.Validation(map[string]any{"format": "format must be json or html"})
+	}
+	chatID := readChatID(r, "")
+	room, err := s.loadChatRoom(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
+	job := compat.ChatExport{
+		ID:           randomID(),
+		ChatID:       string(room.ID),
+		Format:       format,
+		IncludeMedia: req.IncludeMedia,
+		Status:       compat.ChatExportStatusPending,
+		CreatedAt:    time.Now().UTC(),
+	}
+	s.exports.put(job)
+	// The export outlives the request, so it runs on the server's lifetime
+	// rather than the request context.
+	go s.runChatExport(context.WithoutCancel(r.Context()), job, room)
+	w.WriteHeader(http.StatusAccepted)
+	return writeJSON(w, job)
+}
+
+func (s *Server) getChatExport(w http.ResponseWriter, r *http.Request) error {
+	job, ok := s.exports.get(r.PathValue("exportID"))
+	if !ok || !s.requestAllowsChat(r, job.ChatID) {
+		return errs.NotFound("Export not found")
+	}
+	return writeJSON(w, job)
+}
+
+// exportChatID returns the chat an export dir was made from.
+func (s *Server) exportChatID(exportID string) (string, bool) {
+	if job, ok := s.exports.get(exportID); ok {
+		return job.ChatID, true
+	}
+	raw, err := os.ReadFile(filepath.Join(s.exportRootDir(), exportID, exportChatIDFile))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(raw)), true
+}
+
+func (s *Server) runChatExport(ctx context.Context, job compat.ChatExport, room *database.Room) {
+	s.exports.update(job.ID, func(current *compat.ChatExport) {
+		current.Status = compat.ChatExportStatusRunning
+	})
+	path, err := s.writeChatExport(ctx, job, room)
+	s.exports.update(job.ID, func(current *compat.ChatExport) {
+		now := time.Now().UTC()
+		current.CompletedAt = &now
+		if err != nil {
+			current.Status = compat.ChatExportStatusFailed
+			current.Error = err.Error()
+			return
+		}
+		current.Status = compat.ChatExportStatusCompleted
+		current.SrcURL = fileURLFromPath(path)
+	})
+	if err != nil {
+		log.Printf("chat export %s failed: %v", job.ID, err)
+	}
+}
+
+func (s *Server) writeChatExport(ctx context.Context, job compat.ChatExport, room *database.Room) (string, error) {
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return "", err
+	}
+	roomStates, err := s.loadRoomAccountDataStates(ctx)
+	if err != nil {
+		return "", err
+	}
+	chat, err := s.mapRoomToChat(ctx, room, lookup, -1, false, roomStates[room.ID])
+	if err != nil {
+		return "", err
+	}
+	exportDir := filepath.Join(s.exportRootDir(), job.ID)
+	if err = os.MkdirAll(exportDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create export dir: %w", err)
+	}
+	if err = os.WriteFile(filepath.Join(exportDir, exportChatIDFile), []byte(job.ChatID), 0o600); err != nil {
+		return "", fmt.Errorf("failed to record exported chat: %w", err)
+	}
+
+	memberNames := s.loadMemberNameMap(ctx, room.ID)
+	var messages []compat.Message
+	var cursorValue int64
+	for {
+		events, hasMore, loadErr := s.loadTimelineEvents(ctx, room.ID, cursorValue, "before", exportPageSize)
+		if loadErr != nil {
+			return "", loadErr
+		}
+		if len(events) == 0 {
+			break
+		}
+		if err = s.populateLastEditRefs(ctx, events); err != nil {
+			return "", err
+		}
+		reactions, reactionErr := s.loadReactionMap(ctx, room.ID, events)
+		if reactionErr != nil {
+			return "", reactionErr
+		}
+		for _, evt := range events {
+			message, mapErr := s.mapEventToMessage(ctx, evt, room, lookup, reactionBundle{Names: memberNames, Reactions: reactions})
+			if mapErr != nil {
+				continue
+			}
+			messages = append(messages, message)
+		}
+		s.exports.update(job.ID, func(current *compat.ChatExport) {
+			current.MessagesExported = int64(len(messages))
+		})
+		if !hasMore {
+			break
+		}
+		cursorValue = int64(events[len(events)-1].TimelineRowID)
+	}
+	// Pages arrive newest first; transcripts read oldest first.
+	slices.Reverse(messages)
+
+	if job.IncludeMedia {
+		s.copyExportMedia(ctx, exportDir, messages)
+	}
+	transcript := chatTranscript{Chat: chat, Messages: messages, ExportedAt: time.Now().UTC()}
+	if transcript.Messages == nil {
+		transcript.Messages = []compat.Message{}
+	}
+
+	path := filepath.Join(exportDir, "transcript."+job.Format)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcript: %w", err)
+	}
+	if job.Format == exportFormatHTML {
+		err = chatTranscriptPage.Execute(file, transcript)
+	} else {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(transcript)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write transcript: %w", err)
+	}
+	return path, nil
+}
+
+// copyExportMedia downloads attachments next to the transcript and points
+// them at the relative copy. Attachments that fail to download keep their
+// mxc URI so the transcript is still complete.
+func (s *Server) copyExportMedia(ctx context.Context, exportDir string, messages []compat.Message) {
+	mediaDir := filepath.Join(exportDir, "media")
+	if err := os.MkdirAll(mediaDir, 0o700); err != nil {
+		return
+	}
+	for idx := range messages {
+		for attIdx := range messages[idx].Attachments {
+			att := &messages[idx].Attachments[attIdx]
+			cached, err := s.resolveAssetURL(ctx, att.ID)
+			if err != nil {
+				continue
+			}
+			name := fmt.Sprintf("%s-%d-%s", messages[idx].SortKey, attIdx, filepath.Base(att.FileName))
+			if att.FileName == "" {
+				name = fmt.Sprintf("%s-%d", messages[idx].SortKey, attIdx)
+			}
+			if err = copyFile(cached, filepath.Join(mediaDir, name)); err == nil {
+				att.SrcURL = "media/" + name
+			}
+		}
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return errors.Join(err, os.Remove(dst))
+	}
+	return out.Close()
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestChatTranscriptPageEscapesMessageText(t *testing.T) {
+	var message compat.Message
+	message.SenderName = "Alice"
+	message.Text = "<script>alert(1)</script>"
+	message.Timestamp = time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	var chat compat.Chat
+	chat.Title = "Team"
+
+	var buf bytes.Buffer
+	if err := chatTranscriptPage.Execute(&buf, chatTranscript{Chat: chat, Messages: []compat.Message{message}, ExportedAt: message.Timestamp}); err != nil {
+		t.Fatalf("template failed: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "<script>") || !strings.Contains(out, "Alice") {
+		t.Fatalf("unexpected transcript output: %s", out)
+	}
+}
+
+func TestChatExportJobsUpdate(t *testing.T) {
+	var jobs chatExportJobs
+	jobs.put(compat.ChatExport{ID: "job", Status: compat.ChatExportStatusPending})
+	jobs.update("job", func(job *compat.ChatExport) { job.MessagesExported = 5 })
+	if job, ok := jobs.get("job"); !ok || job.MessagesExported != 5 {
+		t.Fatalf("unexpected job %#v", job)
+	}
+	if _, ok := jobs.get("missing"); ok {
+		t.Fatal("expected unknown export to be missing")
+	}
+}
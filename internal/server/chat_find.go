@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const findChatsMaxParticipants = 50
+
+// findChats lists existing chats that already contain the given people, so
+// clients can offer an existing group before creating a new one. Identifiers
+// other than Matrix user IDs are resolved per account through the bridge;
+// accounts where one of them cannot be resolved are skipped.
+func (s *Server) findChats(w http.ResponseWriter, r *http.Request) error {
+	participantIDs := parseStringListParam(r, "participantIDs")
+	if len(participantIDs) == 0 {
+		return errs.Validation(map[string]any{"participantIDs": "at least one participantID is required"})
+	}
+	if len(participantIDs) > findChatsMaxParticipants {
+		return errs.Validation(map[string]any{"participantIDs": "too many participantIDs"})
+	}
+	superset := false
+	switch match := strings.TrimSpace(r.URL.Query().Get("match")); match {
+	case "", participantMatchExact:
+	case participantMatchSuperset:
+		superset = true
+	default:
+		return errs.Validation(map[string]any{"match": "must be one of: exact, superset"})
+	}
+	accountIDs := parseAccountIDs(r)
+	visibility := s.requestPolicy(r)
+
+	lookup, err := s.buildAccountLookup(r.Context())
+	if err != nil {
+		return err
+	}
+	roomStates, err := s.loadRoomAccountDataStates(r.Context())
+	if err != nil {
+		return err
+	}
+	items := make([]compat.FoundChat, 0)
+	for _, account := range lookup.Accounts {
+		if len(accountIDs) > 0 && !equalsAny(account.AccountID, accountIDs) {
+			continue
+		}
+		resolvedIDs, ok := s.resolveFindChatParticipants(r, account.AccountID, participantIDs)
+		if !ok {
+			continue
+		}
+		matches, err := s.chatsWithParticipants(r.Context(), lookup, account.AccountID, resolvedIDs, superset)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if !visibility.allowsChat(string(match.Room.ID), account.AccountID) {
+				continue
+			}
+			chat, err := s.mapRoomToChat(r.Context(), match.Room, lookup, chatPreviewParticipants, true, roomStates[match.Room.ID])
+			if err != nil {
+				continue
+			}
+			items = append(items, compat.FoundChat{Chat: chat, Match: match.Match})
+		}
+	}
+	return writeJSON(w, compat.FindChatsOutput{Items: items})
+}
+
+func (s *Server) resolveFindChatParticipants(r *http.Request, accountID string, participantIDs []string) ([]string, bool) {
+	resolved := make([]string, 0, len(participantIDs))
+	for _, participantID := range participantIDs {
+		user, err := s.resolveParticipant(r.Context(), accountID, participantID)
+		if err != nil {
+			return nil, false
+		}
+		resolved = append(resolved, user.ID)
+	}
+	return resolved, true
+}
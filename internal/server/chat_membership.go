@@ -0,0 +1,327 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+type inviteParticipantsInput struct {
+	ChatID string `json:"chatID,omitempty"`
+	// ParticipantIDs accepts Matrix user IDs or network identifiers (phone
+	// numbers, usernames, ...) that the chat's bridge can resolve.
+	ParticipantIDs []string `json:"participantIDs"`
+}
+
+type leaveChatInput struct {
+	ChatID string `json:"chatID,omitempty"`
+	// Forget also removes the room from the account's room list on the homeserver.
+	Forget bool `json:"forget,omitempty"`
+	// DeleteForEveryone asks the bridge to delete the remote chat for all
+	// participants where the network supports it.
+	DeleteForEveryone bool `json:"deleteForEveryone,omitempty"`
+}
+
+func (s *Server) leaveChat(w http.ResponseWriter, r *http.Request) error {
+	var req leaveChatInput
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, req.ChatID)
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+
+	ctx := r.Context()
+	cli := s.rt.Client()
+	room, err := s.loadChatRoom(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	if room.DMUserID != nil && *room.DMUserID != "" && s.roomSupportsChatDelete(ctx, room.ID) {
+		content := &event.BeeperChatDeleteEventContent{DeleteForEveryone: req.DeleteForEveryone}
+		if _, err = cli.Send(ctx, room.ID, event.BeeperDeleteChat, content, false, true); err != nil {
+			return errs.Internal(fmt.Errorf("failed to delete bridged chat: %w", err))
+		}
+	}
+	if _, err = cli.Client.LeaveRoom(ctx, room.ID); err != nil {
+		return errs.Internal(fmt.Errorf("failed to leave chat: %w", err))
+	}
+	if req.Forget {
+		if _, err = cli.Client.ForgetRoom(ctx, room.ID); err != nil {
+			return errs.Internal(fmt.Errorf("failed to forget chat: %w", err))
+		}
+	}
+
+	s.ws.publish(wsDomainEvent{Type: wsDomainTypeChatDeleted, ChatID: chatID, IDs: []string{chatID}})
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+// roomSupportsChatDelete reports whether the bridge behind a portal advertises
+// com.beeper.delete_chat. Bridges use that event to tear down the DM portal on
+// the remote network, which a plain Matrix leave does not do.
+func (s *Server) roomSupportsChatDelete(ctx context.Context, roomID id.RoomID) bool {
+	features := s.loadRoomFeatures(ctx, roomID)
+	return features != nil && features.DeleteChat
+}
+
+func (s *Server) loadChatRoom(ctx context.Context, chatID string) (*database.Room, error) {
+	room, err := s.rt.Client().DB.Room.Get(ctx, id.RoomID(chatID))
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to read room metadata: %w", err))
+	}
+	if room == nil {
+		return nil, errs.NotFound("Chat not found")
+	}
+	return room, nil
+}
+
+func (s *Server) inviteParticipants(w http.ResponseWriter, r *http.Request) error {
+	var req inviteParticipantsInput
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, req.ChatID)
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	if len(req.ParticipantIDs) == 0 {
+		return errs.Validation(map[string]any{"participantIDs": "at least one participantID is required"})
+	}
+
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if room.DMUserID != nil && *room.DMUserID != "" {
+		return errs.Validation(map[string]any{"chatID": "participants can only be added to group chats"})
+	}
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	accountID, _ := inferAccountForRoom(room.ID, lookup)
+
+	invited := make([]compat.User, 0, len(req.ParticipantIDs))
+	for _, participantID := range req.ParticipantIDs {
+		user, resolveErr := s.resolveParticipant(ctx, accountID, participantID)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		invited = append(invited, user)
+	}
+
+	cli := s.rt.Client()
+	for _, user := range invited {
+		if _, err = cli.Client.InviteUser(ctx, room.ID, &mautrix.ReqInviteUser{UserID: id.UserID(user.ID)}); err != nil {
+			return errs.Internal(fmt.Errorf("failed to invite %s: %w", user.ID, err))
+		}
+	}
+
+	// Membership changes reach the local store through sync, so merge the
+	// invitees into the current list instead of waiting for it.
+	participants, _ := s.loadRoomParticipants(ctx, room)
+	for _, user := range invited {
+		if !slices.ContainsFunc(participants, func(existing compat.User) bool { return existing.ID == user.ID }) {
+			participants = append(participants, user)
+		}
+	}
+	return writeJSON(w, compat.Participants{
+		Items:   participants,
+		HasMore: false,
+		Total:   int64(len(participants)),
+	})
+}
+
+// resolveParticipant maps a participant identifier onto a Matrix user. Matrix
+// IDs are used as-is; anything else goes through the bridge's
+// resolve_identifier provisioning endpoint for the chat's account.
+func (s *Server) resolveParticipant(ctx context.Context, accountID, participantID string) (compat.User, error) {
+	participantID = strings.TrimSpace(participantID)
+	if participantID == "" {
+		return compat.User{}, errs.Validation(map[string]any{"participantIDs": "participantIDs must not contain empty values"})
+	}
+	if strings.HasPrefix(participantID, "@") && strings.Contains(participantID, ":") {
+		return newCompatUser(ctx, userShape{ID: participantID}), nil
+	}
+	resolved, err := s.resolveCloudBridgeIdentifier(ctx, accountID, participantID)
+	if err != nil {
+		return compat.User{}, err
+	}
+	if resolved == nil || resolved.MXID == "" {
+		return compat.User{}, errs.Validation(map[string]any{"participantIDs": fmt.Sprintf("could not resolve %q on this chat's account", participantID)})
+	}
+	user := s.mapResolvedIdentifierToUser(ctx, resolved)
+	user.ID = string(resolved.MXID)
+	return user, nil
+}
+
+// resolveParticipants resolves every non-empty participant ID with
+// resolveParticipant.
+func (s *Server) resolveParticipants(ctx context.Context, accountID string, participantIDs []string) ([]compat.User, error) {
+	users := make([]compat.User, 0, len(participantIDs))
+	for _, participantID := range participantIDs {
+		if strings.TrimSpace(participantID) == "" {
+			continue
+		}
+		user, err := s.resolveParticipant(ctx, accountID, participantID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (s *Server) removeParticipant(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		ChatID string `json:"chatID,omitempty"`
+		Reason string `json:"reason,omitempty"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, req.ChatID)
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	participantID := strings.TrimSpace(r.PathValue("participantID"))
+	if participantID == "" {
+		return errs.Validation(map[string]any{"participantID": "participantID is required"})
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = strings.TrimSpace(r.URL.Query().Get("reason"))
+	}
+
+	room, err := s.loadChatRoom(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
+	_, err = s.rt.Client().Client.KickUser(r.Context(), room.ID, &mautrix.ReqKickUser{
+		UserID: id.UserID(participantID),
+		Reason: reason,
+	})
+	if err != nil {
+		return membershipActionError(err, "remove participants from this chat")
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+// membershipActionError turns homeserver rejections of moderation actions into
+// API errors, so missing power levels surface as 403 rather than a 500.
+func membershipActionError(err error, action string) error {
+	switch {
+	case errors.Is(err, mautrix.MForbidden):
+		return errs.Forbidden("You do not have permission to " + action)
+	case errors.Is(err, mautrix.MNotFound):
+		return errs.NotFound("Chat or user not found")
+	default:
+		return errs.Internal(fmt.Errorf("failed to %s: %w", action, err))
+	}
+}
+
+func (s *Server) listChatBans(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	memberEvents, err := cli.DB.CurrentState.GetMembers(ctx, room.ID)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to read room members: %w", err))
+	}
+	bans := make([]compat.ChatBan, 0)
+	for _, memberEvt := range memberEvents {
+		if memberEvt.StateKey == nil || *memberEvt.StateKey == "" {
+			continue
+		}
+		var content event.MemberEventContent
+		if err = json.Unmarshal(memberEvt.GetContent(), &content); err != nil || content.Membership != event.MembershipBan {
+			continue
+		}
+		ban := compat.ChatBan{
+			User:     userFromMemberEvent(ctx, *memberEvt.StateKey, content, string(cli.Account.UserID)),
+			Reason:   content.Reason,
+			BannedBy: string(memberEvt.Sender),
+		}
+		if !memberEvt.Timestamp.IsZero() {
+			bannedAt := memberEvt.Timestamp.UTC()
+			ban.BannedAt = &bannedAt
+		}
+		bans = append(bans, ban)
+	}
+	slices.SortFunc(bans, func(a, b compat.ChatBan) int {
+		return strings.Compare(a.User.ID, b.User.ID)
+	})
+	return writeJSON(w, compat.ListChatBansOutput{Items: bans})
+}
+
+func (s *Server) banUser(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		ChatID string `json:"chatID,omitempty"`
+		UserID string `json:"userID"`
+		Reason string `json:"reason,omitempty"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, req.ChatID)
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	userID := strings.TrimSpace(req.UserID)
+	if userID == "" {
+		return errs.Validation(map[string]any{"userID": "userID is required"})
+	}
+	room, err := s.loadChatRoom(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
+	_, err = s.rt.Client().Client.BanUser(r.Context(), room.ID, &mautrix.ReqBanUser{
+		UserID: id.UserID(userID),
+		Reason: strings.TrimSpace(req.Reason),
+	})
+	if err != nil {
+		return membershipActionError(err, "ban users in this chat")
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+func (s *Server) unbanUser(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	userID := strings.TrimSpace(r.PathValue("userID"))
+	if userID == "" {
+		return errs.Validation(map[string]any{"userID": "userID is required"})
+	}
+	room, err := s.loadChatRoom(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
+	if _, err = s.rt.Client().Client.UnbanUser(r.Context(), room.ID, &mautrix.ReqUnbanUser{UserID: id.UserID(userID)}); err != nil {
+		return membershipActionError(err, "unban users in this chat")
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
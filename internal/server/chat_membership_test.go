@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+func TestResolveParticipantAcceptsMatrixIDsDirectly(t *testing.T) {
+	s := &Server{}
+	user, err := s.resolveParticipant(context.Background(), "whatsapp_login", " @alice:example.org ")
+	if err != nil {
+		t.Fatalf("resolveParticipant returned error: %v", err)
+	}
+	if user.ID != "@alice:example.org" {
+		t.Fatalf("expected trimmed Matrix ID, got %q", user.ID)
+	}
+	if _, err = s.resolveParticipant(context.Background(), "whatsapp_login", "   "); err == nil {
+		t.Fatal("expected empty participant ID to be rejected")
+	}
+}
+
+func TestMembershipActionErrorMapsForbidden(t *testing.T) {
+	err := membershipActionError(mautrix.HTTPError{RespError: &mautrix.RespError{ErrCode: "M_FORBIDDEN"}}, "remove participants from this chat")
+	var apiErr *errs.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Fatalf("expected 403 APIError, got %v", err)
+	}
+	err = membershipActionError(errors.New("boom"), "remove participants from this chat")
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusInternalServerError {
+		t.Fatalf("expected 500 APIError, got %v", err)
+	}
+}
+
+func insertTestBan(t *testing.T, s *Server, roomID id.RoomID, userID, sender id.UserID, reason string, ts time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	content, _ := json.Marshal(event.MemberEventContent{Membership: event.MembershipBan, Reason: reason})
+	stateKey := string(userID)
+	rowID, err := s.rt.Client().DB.Event.Insert(ctx, &database.Event{
+		RoomID:    roomID,
+		ID:        id.EventID("$ban-" + stateKey),
+		Sender:    sender,
+		Type:      event.StateMember.Type,
+		StateKey:  &stateKey,
+		Content:   content,
+		Unsigned:  json.RawMessage("{}"),
+		Timestamp: jsontime.UM(ts),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert ban event: %v", err)
+	}
+	if err = s.rt.Client().DB.CurrentState.Set(ctx, roomID, event.StateMember, stateKey, rowID, event.MembershipBan); err != nil {
+		t.Fatalf("failed to set ban state: %v", err)
+	}
+}
+
+func TestListChatBansMapsBannedMembers(t *testing.T) {
+	s := newDBTestServer(t)
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+	insertTestMember(t, s, roomID, "@alice:example.org", event.MembershipJoin)
+	bannedAt := time.UnixMilli(1_700_000_000_000).UTC()
+	insertTestBan(t, s, roomID, "@spam:example.org", testOwnUserID, "spam", bannedAt)
+	insertTestBan(t, s, roomID, "@bot:example.org", "@mod:example.org", "", bannedAt)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/chats/"+string(roomID)+"/bans", nil)
+	req.SetPathValue("chatID", string(roomID))
+	rec := httptest.NewRecorder()
+	if err := s.listChatBans(rec, req); err != nil {
+		t.Fatalf("listChatBans returned error: %v", err)
+	}
+	var out compat.ListChatBansOutput
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("failed to decode bans: %v", err)
+	}
+	if len(out.Items) != 2 || out.Items[0].User.ID != "@bot:example.org" || out.Items[1].User.ID != "@spam:example.org" {
+		t.Fatalf("expected only banned members sorted by user ID, got %#v", out.Items)
+	}
+	spam := out.Items[1]
+	if spam.Reason != "spam" || spam.BannedBy != string(testOwnUserID) || spam.BannedAt == nil || !spam.BannedAt.Equal(bannedAt) {
+		t.Fatalf("unexpected ban mapping: %#v", spam)
+	}
+}
+
+func TestBanUserValidatesInputAndMapsHomeserverErrors(t *testing.T) {
+	var banned map[string]any
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/ban"):
+			_ = json.NewDecoder(r.Body).Decode(&banned)
+			_, _ = w.Write([]byte(`{}`))
+		case strings.HasSuffix(r.URL.Path, "/unban"):
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errcode":"M_FORBIDDEN","error":"not allowed"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer homeserver.Close()
+	s := newDBTestServer(t)
+	homeserverURL, _ := url.Parse(homeserver.URL)
+	s.rt.Client().Client.HomeserverURL = homeserverURL
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+
+	ban := func(body string) error {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chats/"+string(roomID)+"/bans", strings.NewReader(body))
+		req.SetPathValue("chatID", string(roomID))
+		return s.banUser(httptest.NewRecorder(), req)
+	}
+	unban := func(userID string) error {
+		req := httptest.NewRequest(http.MethodDelete, "/v1/chats/"+string(roomID)+"/bans/user", nil)
+		req.SetPathValue("chatID", string(roomID))
+		req.SetPathValue("userID", userID)
+		return s.unbanUser(httptest.NewRecorder(), req)
+	}
+
+	var apiErr *errs.APIError
+	if err := ban(`{"reason":"spam"}`); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected missing userID to be rejected, got %v", err)
+	}
+	if err := unban(" "); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected missing userID to be rejected, got %v", err)
+	}
+	if err := ban(`{"userID":" @spam:example.org ","reason":" spam "}`); err != nil {
+		t.Fatalf("banUser returned error: %v", err)
+	}
+	if banned["user_id"] != "@spam:example.org" || banned["reason"] != "spam" {
+		t.Fatalf("unexpected ban request: %#v", banned)
+	}
+	if err := unban("@spam:example.org"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Fatalf("expected homeserver rejection to map to 403, got %v", err)
+	}
+}
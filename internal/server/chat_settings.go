@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	maxChatTitleLength       = 255
+	maxChatDescriptionLength = 4096
+)
+
+func (s *Server) updateChat(w http.ResponseWriter, r *http.Request) error {
+	var req compat.UpdateChatInput
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	if req.Title == nil && req.AvatarUploadID == nil && req.Description == nil {
+		return errs.Validation(map[string]any{"error": "at least one field to update is required"})
+	}
+	var title string
+	if req.Title != nil {
+		title = strings.TrimSpace(*req.Title)
+		if title == "" {
+			return errs.Validation(map[string]any{"title": "title must not be empty"})
+		}
+		if len([]rune(title)) > maxChatTitleLength {
+			return errs.Validation(map[string]any{"title": "title is too long"})
+		}
+	}
+	var description string
+	if req.Description != nil {
+		description = strings.TrimSpace(*req.Description)
+		if len([]rune(description)) > maxChatDescriptionLength {
+			return errs.Validation(map[string]any{"description": "description is too long"})
+		}
+	}
+
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	isSingle := room.DMUserID != nil && *room.DMUserID != ""
+	features := s.loadRoomFeatures(ctx, room.ID)
+	if req.Title != nil && !chatStateChangeAllowed(isSingle, features, event.StateRoomName) {
+		return errs.Forbidden("This network does not support renaming this chat")
+	}
+	if req.AvatarUploadID != nil && !chatStateChangeAllowed(isSingle, features, event.StateRoomAvatar) {
+		return errs.Forbidden("This network does not support changing the avatar of this chat")
+	}
+	if req.Description != nil && !chatStateChangeAllowed(isSingle, features, event.StateTopic) {
+		return errs.Forbidden("This network does not support chat descriptions")
+	}
+
+	// Resolve the avatar before sending any state so that a bad upload does
+	// not leave the chat half updated.
+	var avatar *event.RoomAvatarEventContent
+	if req.AvatarUploadID != nil {
+		if avatar, err = s.chatAvatarContent(r, strings.TrimSpace(*req.AvatarUploadID)); err != nil {
+			return err
+		}
+	}
+	if req.Title != nil {
+		if err = s.sendChatState(ctx, room.ID, event.StateRoomName, &event.RoomNameEventContent{Name: title}, "rename this chat"); err != nil {
+			return err
+		}
+	}
+	if avatar != nil {
+		if err = s.sendChatState(ctx, room.ID, event.StateRoomAvatar, avatar, "change the avatar of this chat"); err != nil {
+			return err
+		}
+	}
+	if req.Description != nil {
+		if err = s.sendChatState(ctx, room.ID, event.StateTopic, &event.TopicEventContent{Topic: description}, "change the description of this chat"); err != nil {
+			return err
+		}
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+func (s *Server) chatAvatarContent(r *http.Request, uploadID string) (*event.RoomAvatarEventContent, error) {
+	if uploadID == "" {
+		return &event.RoomAvatarEventContent{}, nil
+	}
+	meta, err := s.loadUploadMetadataByID(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(meta.MimeType, "image/") {
+		return nil, errs.Validation(map[string]any{"avatarUploadID": "upload must be an image"})
+	}
+	contentURI, size, err := s.uploadStoredAsset(r.Context(), meta, meta.FileName, meta.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	content := &event.RoomAvatarEventContent{
+		URL: contentURI.CUString(),
+		Info: &event.FileInfo{
+			MimeType: meta.MimeType,
+			Size:     int(size),
+			Width:    meta.Width,
+			Height:   meta.Height,
+		},
+	}
+	return content, nil
+}
+
+// chatStateChangeAllowed decides whether a chat-level state change should be
+// attempted. Bridges list the state events they can forward to the remote
+// network; single chats on a bridge are rejected unless the event is listed,
+// since most networks have no notion of a DM title. Group chats are only
+// rejected when the bridge explicitly says so.
+func chatStateChangeAllowed(isSingle bool, features *event.RoomFeatures, evtType event.Type) bool {
+	if features == nil {
+		return true
+	}
+	stateFeatures := features.State[evtType.Type]
+	if stateFeatures == nil {
+		return !isSingle
+	}
+	return stateFeatures.Level > event.CapLevelRejected
+}
+
+func (s *Server) sendChatState(ctx context.Context, roomID id.RoomID, evtType event.Type, content any, action string) error {
+	if _, err := s.rt.Client().Client.SendStateEvent(ctx, roomID, evtType, "", content); err != nil {
+		return membershipActionError(err, action)
+	}
+	return nil
+}
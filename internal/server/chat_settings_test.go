@@ -0,0 +1,67 @@
+package server
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestChatStateChangeAllowed(t *testing.T) {
+	if !chatStateChangeAllowed(true, nil, event.StateRoomName) {
+		t.Fatal("expected renames to be allowed in unbridged chats")
+	}
+	features := &event.RoomFeatures{}
+	if chatStateChangeAllowed(true, features, event.StateRoomName) {
+		t.Fatal("expected single chat rename to be rejected when the bridge does not list it")
+	}
+	if !chatStateChangeAllowed(false, features, event.StateRoomName) {
+		t.Fatal("expected group chat rename to be allowed when the bridge does not list it")
+	}
+	features.State = event.StateFeatureMap{event.StateRoomName.Type: {Level: event.CapLevelRejected}}
+	if chatStateChangeAllowed(false, features, event.StateRoomName) {
+		t.Fatal("expected group chat rename to be rejected when the bridge rejects it")
+	}
+	features.State[event.StateRoomName.Type].Level = event.CapLevelFullySupported
+	if !chatStateChangeAllowed(true, features, event.StateRoomName) {
+		t.Fatal("expected single chat rename to be allowed when the bridge supports it")
+	}
+}
+
+func TestCreateChatPowerLevelsKeepsCreator(t *testing.T) {
+	moderator := 50
+	levels, err := createChatPowerLevels(&compat.CreateChatPowerLevels{
+		Users:  map[string]int{"@bob:example.org": 50},
+		Invite: &moderator,
+	}, "@me:example.org")
+	if err != nil {
+		t.Fatalf("createChatPowerLevels returned error: %v", err)
+	}
+	if levels.Users["@me:example.org"] != 100 || levels.Users["@bob:example.org"] != 50 {
+		t.Fatalf("unexpected users: %v", levels.Users)
+	}
+	if levels.InvitePtr == nil || *levels.InvitePtr != 50 || levels.KickPtr != nil {
+		t.Fatalf("unexpected overrides: invite=%v kick=%v", levels.InvitePtr, levels.KickPtr)
+	}
+
+	if _, err = createChatPowerLevels(&compat.CreateChatPowerLevels{Users: map[string]int{"bob": 50}}, "@me:example.org"); err == nil {
+		t.Fatal("expected an invalid user ID to be rejected")
+	}
+	if levels, err = createChatPowerLevels(nil, "@me:example.org"); err != nil || levels != nil {
+		t.Fatalf("expected no override without input, got %v, %v", levels, err)
+	}
+}
+
+func TestCompareParticipantSetIgnoresSelfAndOrder(t *testing.T) {
+	participants := []compat.User{{ID: "@me:example.org", IsSelf: true}, {ID: "@b:example.org"}, {ID: "@a:example.org"}}
+	if got := compareParticipantSet(participants, []string{"@a:example.org", " @b:example.org", "@a:example.org"}); got != participantMatchExact {
+		t.Fatalf("expected an exact match, got %q", got)
+	}
+	if got := compareParticipantSet(participants, []string{"@a:example.org"}); got != participantMatchSuperset {
+		t.Fatalf("expected an extra member to make a superset match, got %q", got)
+	}
+	if got := compareParticipantSet(participants, []string{"@a:example.org", "@b:example.org", "@c:example.org"}); got != "" {
+		t.Fatalf("expected a missing member to prevent a match, got %q", got)
+	}
+}
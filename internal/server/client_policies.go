@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const clientPolicyStoreFormat = 1
+
+type clientPolicyStore struct {
+	path string
+
+	mu       sync.Mutex
+	loaded   bool
+	replica  replicaFile
+	policies map[string]compat.ClientAccessPolicy
+}
+
+type clientPolicyStorePersisted struct {
+	Version  int                         `json:"version"`
+	Policies []compat.ClientAccessPolicy `json:"policies"`
+}
+
+func newClientPolicyStore(path string) *clientPolicyStore {
+	return &clientPolicyStore{path: path}
+}
+
+func (c *clientPolicyStore) loadLocked() error {
+	stamp, changed, err := c.replica.changed(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read client policies: %w", err)
+	}
+	if c.loaded && !changed {
+		return nil
+	}
+	policies := make(map[string]compat.ClientAccessPolicy)
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.policies, c.replica.seen, c.loaded = policies, stamp, true
+			return nil
+		}
+		return fmt.Errorf("failed to read client policies: %w", err)
+	}
+	var persisted clientPolicyStorePersisted
+	if err = json.Unmarshal(raw, &persisted); err != nil {
+		return fmt.Errorf("failed to parse client policies: %w", err)
+	}
+	if persisted.Version != clientPolicyStoreFormat {
+		return fmt.Errorf("unsupported client policy store version: %d", persisted.Version)
+	}
+	for _, policy := range persisted.Policies {
+		policies[policy.ClientID] = policy
+	}
+	c.policies, c.replica.seen, c.loaded = policies, stamp, true
+	return nil
+}
+
+func (c *clientPolicyStore) saveLocked() error {
+	raw, err := json.Marshal(clientPolicyStorePersisted{Version: clientPolicyStoreFormat, Policies: c.sortedLocked()})
+	if err != nil {
+		return fmt.Errorf("failed to encode client policies: %w", err)
+	}
+	return writeAtomicFile(c.path, raw, 0o600)
+}
+
+func (c *clientPolicyStore) sortedLocked() []compat.ClientAccessPolicy {
+	items := make([]compat.ClientAccessPolicy, 0, len(c.policies))
+	for _, policy := range c.policies {
+		items = append(items, policy)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ClientID < items[j].ClientID })
+	return items
+}
+
+func (c *clientPolicyStore) list() ([]compat.ClientAccessPolicy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return nil, err
+	}
+	return c.sortedLocked(), nil
+}
+
+func (c *clientPolicyStore) get(clientID string) (compat.ClientAccessPolicy, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return compat.ClientAccessPolicy{}, false, err
+	}
+	policy, ok := c.policies[clientID]
+	return policy, ok, nil
+}
+
+func (c *clientPolicyStore) put(policy compat.ClientAccessPolicy) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return err
+	}
+	c.policies[policy.ClientID] = policy
+	return c.saveLocked()
+}
+
+func (c *clientPolicyStore) remove(clientID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return false, err
+	}
+	if _, ok := c.policies[clientID]; !ok {
+		return false, nil
+	}
+	delete(c.policies, clientID)
+	return true, c.saveLocked()
+}
+
+// clientAccessRule picks the rule that applies to a request: writes use the
+// write rule when one is set, everything else uses the read rule.
+func clientAccessRule(policy compat.ClientAccessPolicy, method string) *subjectPolicy {
+	rule := policy.Read
+	if method != http.MethodGet && method != http.MethodHead && policy.Write != nil {
+		rule = *policy.Write
+	}
+	return &subjectPolicy{AccountIDs: rule.AccountIDs, ChatIDs: rule.ChatIDs}
+}
+
+func normalizeClientAccessRule(rule compat.ClientAccessRule) compat.ClientAccessRule {
+	return compat.ClientAccessRule{
+		AccountIDs: normalizeIDList(rule.AccountIDs),
+		ChatIDs:    normalizeIDList(rule.ChatIDs),
+	}
+}
+
+func normalizeIDList(values []string) []string {
+	output := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		output = append(output, value)
+	}
+	return output
+}
+
+func (s *Server) knownOAuthClient(clientID string) bool {
+	if clientID == oauthStaticClientID || clientID == oauthManageClientID {
+		return true
+	}
+	s.oauthMu.RLock()
+	defer s.oauthMu.RUnlock()
+	_, ok := s.oauthClients[clientID]
+	return ok
+}
+
+func (s *Server) listClientPolicies(w http.ResponseWriter, r *http.Request) error {
+	items, err := s.clientPolicies.list()
+	if err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, compat.ListClientAccessPoliciesOutput{Items: items})
+}
+
+func (s *Server) setClientPolicy(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Read  compat.ClientAccessRule  `json:"read"`
+		Write *compat.ClientAccessRule `json:"write,omitempty"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	clientID := strings.TrimSpace(r.PathValue("clientID"))
+	if clientID == "" {
+		return errs.Validation(map[string]any{"clientID": "clientID is required"})
+	}
+	if !s.knownOAuthClient(clientID) {
+		return errs.NotFound("OAuth client not found")
+	}
+	policy := compat.ClientAccessPolicy{
+		ClientID:  clientID,
+		Read:      normalizeClientAccessRule(req.Read),
+		UpdatedAt: time.Now().UTC(),
+	}
+	if req.Write != nil {
+		write := normalizeClientAccessRule(*req.Write)
+		policy.Write = &write
+	}
+	if err := s.clientPolicies.put(policy); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, policy)
+}
+
+func (s *Server) deleteClientPolicy(w http.ResponseWriter, r *http.Request) error {
+	clientID := strings.TrimSpace(r.PathValue("clientID"))
+	if clientID == "" {
+		return errs.Validation(map[string]any{"clientID": "clientID is required"})
+	}
+	removed, err := s.clientPolicies.remove(clientID)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	if !removed {
+		return errs.NotFound("Client policy not found")
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
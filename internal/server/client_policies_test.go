@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestClientAccessRuleUsesWriteRuleForMutations(t *testing.T) {
+	policy := compat.ClientAccessPolicy{
+		ClientID: "agent",
+		Read:     compat.ClientAccessRule{AccountIDs: []string{"whatsapp"}},
+		Write:    &compat.ClientAccessRule{ChatIDs: []string{"!support:example.org"}},
+	}
+	read := clientAccessRule(policy, http.MethodGet)
+	if !read.allowsChat("!any:example.org", "whatsapp") {
+		t.Fatal("expected read rule to allow chats on the account")
+	}
+	write := clientAccessRule(policy, http.MethodPost)
+	if write.allowsChat("!any:example.org", "whatsapp") || !write.allowsChat("!support:example.org", "whatsapp") {
+		t.Fatal("expected write rule to only allow the listed chat")
+	}
+
+	policy.Write = nil
+	if !clientAccessRule(policy, http.MethodPost).allowsChat("!any:example.org", "whatsapp") {
+		t.Fatal("expected write to fall back to the read rule")
+	}
+}
+
+func TestSubjectPolicyIntersectsWithClientPolicy(t *testing.T) {
+	combined := &subjectPolicy{
+		AccountIDs: []string{"whatsapp", "telegram"},
+		also:       &subjectPolicy{AccountIDs: []string{"telegram"}},
+	}
+	if combined.allowsChat("!a:example.org", "whatsapp") || combined.allowsAccount("whatsapp") {
+		t.Fatal("expected client policy to narrow the subject policy")
+	}
+	if !combined.allowsChat("!b:example.org", "telegram") {
+		t.Fatal("expected chat allowed by both policies to be visible")
+	}
+}
+
+func TestClientPolicyStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client-policies.json")
+	store := newClientPolicyStore(path)
+	if err := store.put(compat.ClientAccessPolicy{ClientID: "agent", Read: normalizeClientAccessRule(compat.ClientAccessRule{ChatIDs: []string{" !a:example.org ", "!a:example.org"}})}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	policy, ok, err := newClientPolicyStore(path).get("agent")
+	if err != nil || !ok || len(policy.Read.ChatIDs) != 1 || policy.Read.ChatIDs[0] != "!a:example.org" {
+		t.Fatalf("unexpected stored policy: %#v (%v, %v)", policy, ok, err)
+	}
+	if removed, err := store.remove("agent"); err != nil || !removed {
+		t.Fatalf("remove failed: %v %v", removed, err)
+	}
+}
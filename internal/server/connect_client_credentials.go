@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const oauthGrantClientCredentials = "client_credentials"
+
+func hashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func clientSecretMatches(client oauthClient, secret string) bool {
+	if client.ClientSecretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashClientSecret(secret)), []byte(client.ClientSecretHash)) == 1
+}
+
+// clientCredentialsFromRequest accepts both client_secret_basic and
+// client_secret_post. Basic credentials are form-encoded per RFC 6749 2.3.1.
+func clientCredentialsFromRequest(r *http.Request, body map[string]string) (string, string) {
+	if username, password, ok := r.BasicAuth(); ok {
+		clientID, idErr := url.QueryUnescape(username)
+		secret, secretErr := url.QueryUnescape(password)
+		if idErr == nil && secretErr == nil {
+			return clientID, secret
+		}
+		return username, password
+	}
+	return strings.TrimSpace(body["client_id"]), body["client_secret"]
+}
+
+// grantedClientScopes narrows the requested scopes to what the client was
+// registered with. An empty request grants the registered scopes.
+func grantedClientScopes(client oauthClient, requested string) ([]string, bool) {
+	allowed := normalizeOAuthScopes(client.Scope)
+	if strings.TrimSpace(requested) == "" {
+		return allowed, true
+	}
+	scopes := normalizeOAuthScopes(requested)
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return nil, false
+		}
+	}
+	return scopes, true
+}
+
+func (s *Server) oauthClientCredentialsToken(w http.ResponseWriter, r *http.Request, body map[string]string) error {
+	clientID, secret := clientCredentialsFromRequest(r, body)
+	s.oauthMu.RLock()
+	client, ok := s.oauthClients[clientID]
+	s.oauthMu.RUnlock()
+	if !ok || !slices.Contains(client.GrantTypes, oauthGrantClientCredentials) || !clientSecretMatches(client, secret) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="easymatrix"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return writeJSON(w, map[string]string{
+			"error":             "invalid_client",
+			"error_description": "client authentication failed",
+		})
+	}
+	scopes, ok := grantedClientScopes(client, body["scope"])
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return writeJSON(w, map[string]string{
+			"error":             "invalid_scope",
+			"error_description": "requested scope exceeds the client's registered scope",
+		})
+	}
+
+	issued, err := s.issueOAuthAccessToken(client.ClientID, scopes, strings.TrimSpace(body["resource"]), s.requestBaseURL(r))
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to issue access token: %w", err))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, map[string]any{
+		"access_token": issued.Value,
+		"token_type":   issued.TokenType,
+		"expires_in":   int64(oauthAccessTokenTTL.Seconds()),
+		"scope":        oauthScopeString(issued.Scopes),
+	})
+}
+
+// createConfidentialClient registers a client for the client_credentials
+// grant. Open dynamic registration cannot do this because such clients get
+// tokens without anyone approving them; the route sits behind the manage
+// secret for the same reason.
+func (s *Server) createConfidentialClient(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		ClientName string `json:"clientName"`
+		Scope      string `json:"scope,omitempty"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	clientName := strings.TrimSpace(req.ClientName)
+	if clientName == "" {
+		return errs.Validation(map[string]any{"clientName": "clientName is required"})
+	}
+	clientID, err := randomHexToken(12)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to generate client id: %w", err))
+	}
+	secret, err := randomHexToken(32)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to generate client secret: %w", err))
+	}
+	client := oauthClient{
+		ClientID:                clientID,
+		ClientName:              clientName,
+		GrantTypes:              []string{oauthGrantClientCredentials},
+		ResponseTypes:           []string{},
+		Scope:                   oauthScopeString(normalizeOAuthScopes(req.Scope)),
+		TokenEndpointAuthMethod: "client_secret_basic",
+		ClientSecretHash:        hashClientSecret(secret),
+		CreatedAt:               time.Now().Unix(),
+	}
+	s.oauthMu.Lock()
+	s.oauthClients[client.ClientID] = client
+	if err = s.persistOAuthStateLocked(); err != nil {
+		s.oauthMu.Unlock()
+		return errs.Internal(fmt.Errorf("failed to persist oauth client: %w", err))
+	}
+	s.oauthMu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+	return writeJSON(w, map[string]any{
+		"clientID":     client.ClientID,
+		"clientName":   client.ClientName,
+		"clientSecret": secret,
+		"scope":        client.Scope,
+		"grantTypes":   client.GrantTypes,
+	})
+}
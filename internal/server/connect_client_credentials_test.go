@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGrantedClientScopesLimitsToRegisteredScope(t *testing.T) {
+	client := oauthClient{ClientID: "job", Scope: "read"}
+	scopes, ok := grantedClientScopes(client, "")
+	if !ok || len(scopes) != 1 || scopes[0] != "read" {
+		t.Fatalf("expected registered scopes by default, got %v ok=%v", scopes, ok)
+	}
+	if _, ok = grantedClientScopes(client, "read write"); ok {
+		t.Fatal("expected write scope to be refused for read-only client")
+	}
+}
+
+func TestClientCredentialsFromRequestPrefersBasicAuth(t *testing.T) {
+	req := httptest.NewRequest("POST", "/oauth/token", nil)
+	req.SetBasicAuth("job%20one", "s3cret")
+	clientID, secret := clientCredentialsFromRequest(req, map[string]string{"client_id": "other", "client_secret": "other"})
+	if clientID != "job one" || secret != "s3cret" {
+		t.Fatalf("unexpected credentials %q/%q", clientID, secret)
+	}
+	client := oauthClient{ClientSecretHash: hashClientSecret("s3cret")}
+	if !clientSecretMatches(client, secret) || clientSecretMatches(client, "wrong") {
+		t.Fatal("expected secret comparison to match only the registered secret")
+	}
+}
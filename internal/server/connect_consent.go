@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/auth"
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const oauthConsentTicketTTL = 10 * time.Minute
+
+type oauthConsent struct {
+	ClientID    string    `json:"client_id"`
+	Scopes      []string  `json:"scopes"`
+	RedirectURI string    `json:"redirect_uri"`
+	GrantedAt   time.Time `json:"granted_at"`
+}
+
+// oauthPendingAuthorization is a validated /oauth/authorize request waiting
+// for the user to approve or deny it on the consent page.
+type oauthPendingAuthorization struct {
+	ClientID            string
+	ClientName          string
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Resource            string
+	ExpiresAt           time.Time
+}
+
+type oauthConsentOutput struct {
+	ClientID    string    `json:"clientID"`
+	ClientName  string    `json:"clientName,omitempty"`
+	Scopes      []string  `json:"scopes"`
+	RedirectURI string    `json:"redirectURI"`
+	GrantedAt   time.Time `json:"grantedAt"`
+}
+
+var oauthConsentPage = template.Must(template.New("consent").Parse(`<!doctype html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width,initial-scale=1">
+  <title>Authorize {{.ClientName}}</title>
+  <style>
+    body{font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,Arial,sans-serif;background:#f7f8fa;color:#111827;margin:0;display:flex;align-items:center;justify-content:center;min-height:100vh}
+    .card{background:#fff;border:1px solid #e5e7eb;border-radius:12px;padding:20px 24px;max-width:560px;width:calc(100% - 32px);box-shadow:0 8px 24px rgba(15,23,42,.08)}
+    h1{font-size:18px;line-height:1.4;margin:0 0 8px}
+    p,li{color:#4b5563}
+    code{word-break:break-all}
+    input[type=password]{width:100%;box-sizing:border-box;padding:8px;margin:4px 0 12px}
+    .actions{display:flex;gap:8px;margin-top:16px}
+    button{padding:8px 16px;border-radius:8px;border:1px solid #d1d5db;background:#fff;cursor:pointer}
+    button.primary{background:#111827;color:#fff;border-color:#111827}
+  </style>
+</head>
+<body><div class="card">
+  <h1>{{.ClientName}} wants to access your chats</h1>
+  <p>Client ID: <code>{{.ClientID}}</code></p>
+  <p>Requested access:</p>
+  <ul>{{range .Scopes}}<li>{{if eq . "write"}}Send messages and change chats (write){{else}}Read chats and messages (read){{end}}</li>{{end}}</ul>
+  <p>After approval you will be sent to <code>{{.RedirectURI}}</code></p>
+  <form method="post" action="{{.Action}}">
+    <input type="hidden" name="ticket" value="{{.Ticket}}">
+    <label>Manage secret<input type="password" name="secret" autocomplete="current-password" required></label>
+    <label><input type="checkbox" name="remember" value="true"> Remember this approval</label>
+    <div class="actions">
+      <button class="primary" type="submit" name="decision" value="approve">Approve</button>
+      <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </div>
+  </form>
+</div></body>
+</html>`))
+
+// consentCovers reports whether a remembered approval already grants the
+// requested scopes for the same redirect URI.
+func consentCovers(consent oauthConsent, scopes []string, redirectURI string) bool {
+	if consent.RedirectURI != redirectURI {
+		return false
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(consent.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) hasRememberedConsent(clientID string, scopes []string, redirectURI string) bool {
+	s.oauthMu.RLock()
+	defer s.oauthMu.RUnlock()
+	consent, ok := s.oauthConsents[clientID]
+	return ok && consentCovers(consent, scopes, redirectURI)
+}
+
+// consentSecretValid checks the manage secret typed on the consent page.
+// Config loading refuses consent mode without a secret; the empty check
+// keeps a misconfigured server from approving every request.
+func (s *Server) consentSecretValid(secret string) bool {
+	expected := strings.TrimSpace(s.cfg.ManageSecret)
+	if expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(secret)), []byte(expected)) == 1
+}
+
+func (s *Server) renderConsentPage(w http.ResponseWriter, r *http.Request, pending oauthPendingAuthorization) error {
+	ticket, err := randomHexToken(24)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	now := time.Now().UTC()
+	pending.ExpiresAt = now.Add(oauthConsentTicketTTL)
+	s.oauthMu.Lock()
+	// Abandoned consent pages never come back, so expired tickets are
+	// dropped here instead of waiting for the next state persist.
+	for key, existing := range s.oauthPending {
+		if now.After(existing.ExpiresAt) {
+			delete(s.oauthPending, key)
+		}
+	}
+	s.oauthPending[ticket] = pending
+	s.oauthMu.Unlock()
+
+	clientName := strings.TrimSpace(pending.ClientName)
+	if clientName == "" {
+		clientName = oauthDefaultClientName
+	}
+	var buf bytes.Buffer
+	err = oauthConsentPage.Execute(&buf, map[string]any{
+		"ClientName":  clientName,
+		"ClientID":    pending.ClientID,
+		"Scopes":      pending.Scopes,
+		"RedirectURI": pending.RedirectURI,
+		"Ticket":      ticket,
+		"Action":      auth.BasePath(r) + "/oauth/authorize/consent",
+	})
+	if err != nil {
+		return errs.Internal(err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	_, _ = w.Write(buf.Bytes())
+	return nil
+}
+
+func (s *Server) oauthAuthorizeConsent(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return errs.Validation(map[string]any{"error": err.Error()})
+	}
+	ticket := strings.TrimSpace(r.PostForm.Get("ticket"))
+	decision := r.PostForm.Get("decision")
+
+	// A ticket is good for one submission whatever its outcome, so the
+	// secret cannot be guessed by replaying the form.
+	s.oauthMu.Lock()
+	pending, ok := s.oauthPending[ticket]
+	delete(s.oauthPending, ticket)
+	s.oauthMu.Unlock()
+	if !ok || time.Now().After(pending.ExpiresAt) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(renderSimpleHTML("Request expired", "Start the authorization again from the application.")))
+		return nil
+	}
+
+	if decision == "deny" {
+		redirect, err := url.Parse(pending.RedirectURI)
+		if err != nil {
+			return errs.Validation(map[string]any{"redirect_uri": "invalid redirect uri"})
+		}
+		values := redirect.Query()
+		values.Set("error", "access_denied")
+		if pending.State != "" {
+			values.Set("state", pending.State)
+		}
+		redirect.RawQuery = values.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+		return nil
+	}
+	if decision != "approve" {
+		return errs.Validation(map[string]any{"decision": "must be one of: approve, deny"})
+	}
+	if !s.consentSecretValid(r.PostForm.Get("secret")) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(renderSimpleHTML("Not approved", "The manage secret was incorrect. Start the authorization again from the application.")))
+		return nil
+	}
+
+	s.oauthMu.Lock()
+	if r.PostForm.Get("remember") == "true" {
+		s.oauthConsents[pending.ClientID] = oauthConsent{
+			ClientID:    pending.ClientID,
+			Scopes:      pending.Scopes,
+			RedirectURI: pending.RedirectURI,
+			GrantedAt:   time.Now().UTC(),
+		}
+		if err := s.persistOAuthStateLocked(); err != nil {
+			s.oauthMu.Unlock()
+			return errs.Internal(err)
+		}
+	}
+	s.oauthMu.Unlock()
+	return s.completeAuthorization(w, r, pending)
+}
+
+func (s *Server) listOAuthConsents(w http.ResponseWriter, r *http.Request) error {
+	s.oauthMu.RLock()
+	items := make([]oauthConsentOutput, 0, len(s.oauthConsents))
+	for _, consent := range s.oauthConsents {
+		items = append(items, oauthConsentOutput{
+			ClientID:    consent.ClientID,
+			ClientName:  s.oauthClients[consent.ClientID].ClientName,
+			Scopes:      consent.Scopes,
+			RedirectURI: consent.RedirectURI,
+			GrantedAt:   consent.GrantedAt,
+		})
+	}
+	s.oauthMu.RUnlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].ClientID < items[j].ClientID })
+	return writeJSON(w, map[string]any{"items": items})
+}
+
+func (s *Server) revokeOAuthConsent(w http.ResponseWriter, r *http.Request) error {
+	clientID := strings.TrimSpace(r.PathValue("clientID"))
+	s.oauthMu.Lock()
+	defer s.oauthMu.Unlock()
+	if _, ok := s.oauthConsents[clientID]; !ok {
+		return errs.NotFound("Consent not found")
+	}
+	delete(s.oauthConsents, clientID)
+	if err := s.persistOAuthStateLocked(); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsentCoversRequiresSameRedirectAndScopes(t *testing.T) {
+	consent := oauthConsent{ClientID: "client", Scopes: []string{"read", "write"}, RedirectURI: "https://app.example/cb"}
+	if !consentCovers(consent, []string{"read"}, "https://app.example/cb") {
+		t.Fatal("expected narrower scope set to be covered")
+	}
+	if consentCovers(consent, []string{"read"}, "https://other.example/cb") {
+		t.Fatal("expected different redirect URI to require consent")
+	}
+	readOnly := oauthConsent{ClientID: "client", Scopes: []string{"read"}, RedirectURI: "https://app.example/cb"}
+	if consentCovers(readOnly, []string{"read", "write"}, "https://app.example/cb") {
+		t.Fatal("expected additional scope to require consent")
+	}
+}
+
+func TestConsentSecretValidRejectsUnsetSecret(t *testing.T) {
+	s := &Server{}
+	if s.consentSecretValid("") || s.consentSecretValid("anything") {
+		t.Fatal("expected approval to fail without a configured manage secret")
+	}
+	s.cfg.ManageSecret = "open-sesame"
+	if !s.consentSecretValid(" open-sesame ") || s.consentSecretValid("wrong") {
+		t.Fatal("expected only the configured secret to approve")
+	}
+}
+
+func TestConsentTicketIsSpentByAWrongSecret(t *testing.T) {
+	s := &Server{oauthPending: map[string]oauthPendingAuthorization{
+		"ticket": {ClientID: "client", RedirectURI: "https://app.example/cb", ExpiresAt: time.Now().Add(time.Minute)},
+	}}
+	s.cfg.ManageSecret = "open-sesame"
+	submit := func(secret string) int {
+		form := url.Values{"ticket": {"ticket"}, "decision": {"approve"}, "secret": {secret}}
+		req := httptest.NewRequest(http.MethodPost, "/oauth/authorize/consent", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		if err := s.oauthAuthorizeConsent(rec, req); err != nil {
+			t.Fatalf("consent failed: %v", err)
+		}
+		return rec.Code
+	}
+	if code := submit("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong secret to be refused, got %d", code)
+	}
+	if code := submit("open-sesame"); code != http.StatusBadRequest {
+		t.Fatalf("expected the ticket to be spent after a wrong secret, got %d", code)
+	}
+}
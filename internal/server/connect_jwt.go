@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const oauthJWTAlgorithm = "ES256"
+
+// oauthSigningKey signs JWT access tokens so that other services can verify
+// them against /.well-known/jwks.json instead of calling /oauth/introspect.
+type oauthSigningKey struct {
+	private *ecdsa.PrivateKey
+	keyID   string
+}
+
+func loadOrCreateSigningKey(path string) (*oauthSigningKey, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createSigningKey(path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("signing key %s is not a PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	private, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || private.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("signing key %s is not a P-256 key", path)
+	}
+	return newOAuthSigningKey(private)
+}
+
+func createSigningKey(path string) (*oauthSigningKey, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	if err = writeAtomicFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+	return newOAuthSigningKey(private)
+}
+
+func newOAuthSigningKey(private *ecdsa.PrivateKey) (*oauthSigningKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return &oauthSigningKey{
+		private: private,
+		keyID:   base64.RawURLEncoding.EncodeToString(sum[:12]),
+	}, nil
+}
+
+func (k *oauthSigningKey) jwk() map[string]any {
+	return map[string]any{
+		"kty": "EC",
+		"crv": "P-256",
+		"use": "sig",
+		"alg": oauthJWTAlgorithm,
+		"kid": k.keyID,
+		"x":   base64.RawURLEncoding.EncodeToString(k.private.PublicKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(k.private.PublicKey.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func (k *oauthSigningKey) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": oauthJWTAlgorithm, "typ": "at+jwt", "kid": k.keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// accessTokenClaims follows the JWT access token profile (RFC 9068).
+func accessTokenClaims(entry oauthAccessToken, issuer, jwtID string) map[string]any {
+	audience := entry.Resource
+	if audience == "" {
+		audience = issuer + "/v1"
+	}
+	claims := map[string]any{
+		"iss":       issuer,
+		"sub":       entry.Subject,
+		"aud":       audience,
+		"client_id": entry.ClientID,
+		"scope":     oauthScopeString(entry.Scopes),
+		"iat":       entry.CreatedAt.Unix(),
+		"nbf":       entry.CreatedAt.Unix(),
+		"jti":       jwtID,
+	}
+	if entry.ExpiresAt != nil {
+		claims["exp"] = entry.ExpiresAt.Unix()
+	}
+	return claims
+}
+
+func (s *Server) signAccessToken(entry oauthAccessToken, issuer string) (string, error) {
+	jwtID, err := randomHexToken(16)
+	if err != nil {
+		return "", err
+	}
+	return s.oauthSigner.sign(accessTokenClaims(entry, issuer, jwtID))
+}
+
+func (s *Server) oauthJWKS(w http.ResponseWriter, r *http.Request) error {
+	keys := make([]map[string]any, 0, 1)
+	if s.oauthSigner != nil {
+		keys = append(keys, s.oauthSigner.jwk())
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Hour.Seconds())))
+	return writeJSON(w, map[string]any{"keys": keys})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigningKeyPersistsAndSignsVerifiableJWT(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oauth", "jwt-signing-key.pem")
+	key, err := loadOrCreateSigningKey(path)
+	if err != nil {
+		t.Fatalf("loadOrCreateSigningKey returned error: %v", err)
+	}
+	reloaded, err := loadOrCreateSigningKey(path)
+	if err != nil {
+		t.Fatalf("reloading signing key returned error: %v", err)
+	}
+	if reloaded.keyID != key.keyID {
+		t.Fatalf("expected reloaded key id %q, got %q", key.keyID, reloaded.keyID)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	entry := oauthAccessToken{ClientID: "client", Subject: "local-user", Scopes: []string{"read"}, CreatedAt: time.Now(), ExpiresAt: &expiresAt}
+	token, err := key.sign(accessTokenClaims(entry, "http://127.0.0.1:23373", "jti"))
+	if err != nil {
+		t.Fatalf("sign returned error: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected three JWT segments, got %d", len(parts))
+	}
+
+	jwk := reloaded.jwk()
+	x, _ := base64.RawURLEncoding.DecodeString(jwk["x"].(string))
+	y, _ := base64.RawURLEncoding.DecodeString(jwk["y"].(string))
+	public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if len(signature) != 64 || !ecdsa.Verify(public, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		t.Fatal("expected signature to verify against published JWK")
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]any
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("failed to decode claims: %v", err)
+	}
+	if claims["aud"] != "http://127.0.0.1:23373/v1" || claims["client_id"] != "client" || claims["scope"] != "read" {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+}
@@ -33,6 +33,7 @@ type oauthClient struct {
 	ResponseTypes           []string `json:"response_types"`
 	Scope                   string   `json:"scope"`
 	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	ClientSecretHash        string   `json:"client_secret_hash,omitempty"`
 	CreatedAt               int64    `json:"created_at"`
 }
 
@@ -140,7 +141,7 @@ func (s *Server) initOAuthState(staticToken string) {
 	s.oauthTokens[staticToken] = oauthAccessToken{
 		Value:      staticToken,
 		TokenType:  oauthTokenTypeBearer,
-		ClientID:   "easymatrix-static",
+		ClientID:   oauthStaticClientID,
 		Subject:    s.oauthSubject,
 		Scopes:     []string{"read", "write"},
 		CreatedAt:  now,
@@ -152,8 +153,14 @@ func (s *Server) initOAuthState(staticToken string) {
 }
 
 func (s *Server) tokenInfoForBearer(token string) (*mcpauth.TokenInfo, bool) {
+	if s.primary != nil {
+		s.reloadOAuthStateIfChanged()
+	}
 	entry, ok := s.oauthTokenByValue(token)
 	if !ok {
+		if s.identity != nil {
+			return s.identity.tokenInfo(token)
+		}
 		return nil, false
 	}
 	tokenInfo := &mcpauth.TokenInfo{
@@ -190,19 +197,14 @@ func (s *Server) oauthTokenByValue(token string) (oauthAccessToken, bool) {
 	return entry, true
 }
 
-func (s *Server) issueOAuthAccessToken(clientID string, scopes []string, resource string) (oauthAccessToken, error) {
-	tokenValue, err := randomHexToken(32)
-	if err != nil {
-		return oauthAccessToken{}, err
-	}
-
+func (s *Server) issueOAuthAccessToken(clientID string, scopes []string, resource string, issuer string) (oauthAccessToken, error) {
 	now := time.Now().UTC()
 	expiresAt := now.Add(oauthAccessTokenTTL)
 
-	s.oauthMu.Lock()
+	s.oauthMu.RLock()
 	client := s.oauthClients[clientID]
+	s.oauthMu.RUnlock()
 	entry := oauthAccessToken{
-		Value:      tokenValue,
 		TokenType:  oauthTokenTypeBearer,
 		ClientID:   clientID,
 		Subject:    s.oauthSubject,
@@ -213,7 +215,20 @@ func (s *Server) issueOAuthAccessToken(clientID string, scopes []string, resourc
 		Resource:   resource,
 		ClientName: client.ClientName,
 	}
-	s.oauthTokens[tokenValue] = entry
+	// JWT tokens are still recorded below so revocation and introspection
+	// behave exactly like they do for opaque tokens.
+	var err error
+	if s.oauthSigner != nil {
+		entry.Value, err = s.signAccessToken(entry, issuer)
+	} else {
+		entry.Value, err = randomHexToken(32)
+	}
+	if err != nil {
+		return oauthAccessToken{}, err
+	}
+
+	s.oauthMu.Lock()
+	s.oauthTokens[entry.Value] = entry
 	if err = s.persistOAuthStateLocked(); err != nil {
 		s.oauthMu.Unlock()
 		return oauthAccessToken{}, err
@@ -237,7 +252,7 @@ func (s *Server) issueManageAccessToken(resource string) (oauthAccessToken, erro
 		}
 	}
 	s.oauthMu.Unlock()
-	return s.issueOAuthAccessToken(oauthManageClientID, []string{"read", "write"}, resource)
+	return s.issueOAuthAccessToken(oauthManageClientID, []string{"read", "write"}, resource, strings.TrimSuffix(resource, "/v1"))
 }
 
 func (s *Server) createAuthorizationCode(
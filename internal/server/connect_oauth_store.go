@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,37 +20,140 @@ type oauthPersistedState struct {
 	Clients map[string]oauthClient            `json:"clients"`
 	Codes   map[string]oauthAuthorizationCode `json:"codes"`
 	Tokens  map[string]oauthAccessToken       `json:"tokens"`
+	// Consents holds remembered approvals, keyed by client ID.
+	Consents map[string]oauthConsent `json:"consents,omitempty"`
+}
+
+// oauthLoadedState is the usable part of a persisted OAuth state file.
+type oauthLoadedState struct {
+	stamp    stateFileStamp
+	clients  map[string]oauthClient
+	codes    map[string]oauthAuthorizationCode
+	tokens   map[string]oauthAccessToken
+	consents map[string]oauthConsent
 }
 
 func (s *Server) loadOAuthState() error {
+	loaded, err := s.readOAuthState()
+	if err != nil || loaded == nil {
+		return err
+	}
+
+	s.oauthMu.Lock()
+	for key, value := range loaded.clients {
+		s.oauthClients[key] = value
+	}
+	for key, value := range loaded.consents {
+		s.oauthConsents[key] = value
+	}
+	for key, value := range loaded.codes {
+		s.oauthCodes[key] = value
+	}
+	for key, value := range loaded.tokens {
+		s.oauthTokens[key] = value
+	}
+	s.oauthStateSeen = loaded.stamp
+	s.pruneOAuthStateLocked(time.Now().UTC())
+	s.oauthMu.Unlock()
+	return nil
+}
+
+// reloadOAuthStateIfChanged replaces a follower's OAuth tables with the
+// replicated state file whenever the primary rewrites it, so tokens issued
+// or revoked on the primary take effect without restarting the follower.
+func (s *Server) reloadOAuthStateIfChanged() {
 	if strings.TrimSpace(s.oauthState) == "" {
-		return nil
+		return
+	}
+	stamp, err := statStateFile(s.oauthState)
+	if err != nil {
+		return
+	}
+	s.oauthMu.RLock()
+	unchanged := stamp == s.oauthStateSeen
+	s.oauthMu.RUnlock()
+	if unchanged {
+		return
+	}
+	loaded, err := s.readOAuthState()
+	if err != nil {
+		log.Printf("failed to reload oauth state: %v", err)
+		return
+	}
+	if loaded == nil {
+		loaded = &oauthLoadedState{}
+	}
+
+	s.oauthMu.Lock()
+	defer s.oauthMu.Unlock()
+	tokens := make(map[string]oauthAccessToken, len(loaded.tokens)+1)
+	for key, value := range s.oauthTokens {
+		if value.Static {
+			tokens[key] = value
+		}
+	}
+	for key, value := range loaded.tokens {
+		tokens[key] = value
+	}
+	s.oauthTokens = tokens
+	s.oauthClients = orEmpty(loaded.clients)
+	s.oauthCodes = orEmpty(loaded.codes)
+	s.oauthConsents = orEmpty(loaded.consents)
+	s.oauthStateSeen = stamp
+}
+
+func orEmpty[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return make(map[string]V)
 	}
-	raw, err := os.ReadFile(s.oauthState)
+	return m
+}
+
+// readOAuthState parses the state file, dropping expired and revoked
+// entries. It returns nil when there is no state file.
+func (s *Server) readOAuthState() (*oauthLoadedState, error) {
+	if strings.TrimSpace(s.oauthState) == "" {
+		return nil, nil
+	}
+	file, err := os.Open(s.oauthState)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("failed to read oauth state: %w", err)
+		return nil, fmt.Errorf("failed to read oauth state: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oauth state: %w", err)
+	}
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oauth state: %w", err)
 	}
 
 	var persisted oauthPersistedState
 	if err = json.Unmarshal(raw, &persisted); err != nil {
-		return fmt.Errorf("failed to parse oauth state: %w", err)
+		return nil, fmt.Errorf("failed to parse oauth state: %w", err)
 	}
 	if persisted.Version != oauthStateVersion {
-		return fmt.Errorf("unsupported oauth state version: %d", persisted.Version)
+		return nil, fmt.Errorf("unsupported oauth state version: %d", persisted.Version)
 	}
 	now := time.Now().UTC()
 
-	clients := make(map[string]oauthClient, len(persisted.Clients))
+	loaded := &oauthLoadedState{
+		stamp:    stampOf(info),
+		clients:  make(map[string]oauthClient, len(persisted.Clients)),
+		codes:    make(map[string]oauthAuthorizationCode, len(persisted.Codes)),
+		tokens:   make(map[string]oauthAccessToken, len(persisted.Tokens)),
+		consents: make(map[string]oauthConsent, len(persisted.Consents)),
+	}
 	for key, value := range persisted.Clients {
 		if strings.TrimSpace(key) == "" || strings.TrimSpace(value.ClientID) == "" {
 			continue
 		}
-		clients[key] = value
+		loaded.clients[key] = value
 	}
-	codes := make(map[string]oauthAuthorizationCode, len(persisted.Codes))
 	for key, value := range persisted.Codes {
 		if strings.TrimSpace(key) == "" || strings.TrimSpace(value.Code) == "" {
 			continue
@@ -56,9 +161,8 @@ func (s *Server) loadOAuthState() error {
 		if !value.ExpiresAt.IsZero() && now.After(value.ExpiresAt) {
 			continue
 		}
-		codes[key] = value
+		loaded.codes[key] = value
 	}
-	tokens := make(map[string]oauthAccessToken, len(persisted.Tokens))
 	for key, value := range persisted.Tokens {
 		if strings.TrimSpace(key) == "" || strings.TrimSpace(value.Value) == "" {
 			continue
@@ -69,22 +173,15 @@ func (s *Server) loadOAuthState() error {
 		if value.ExpiresAt != nil && now.After(*value.ExpiresAt) {
 			continue
 		}
-		tokens[key] = value
-	}
-
-	s.oauthMu.Lock()
-	for key, value := range clients {
-		s.oauthClients[key] = value
-	}
-	for key, value := range codes {
-		s.oauthCodes[key] = value
+		loaded.tokens[key] = value
 	}
-	for key, value := range tokens {
-		s.oauthTokens[key] = value
+	for key, value := range persisted.Consents {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		loaded.consents[key] = value
 	}
-	s.pruneOAuthStateLocked(now)
-	s.oauthMu.Unlock()
-	return nil
+	return loaded, nil
 }
 
 func (s *Server) persistOAuthState() error {
@@ -105,6 +202,8 @@ func (s *Server) persistOAuthStateLocked() error {
 		Clients: make(map[string]oauthClient, len(s.oauthClients)),
 		Codes:   make(map[string]oauthAuthorizationCode, len(s.oauthCodes)),
 		Tokens:  make(map[string]oauthAccessToken, len(s.oauthTokens)),
+
+		Consents: s.oauthConsents,
 	}
 	for key, value := range s.oauthClients {
 		if strings.TrimSpace(key) == "" || strings.TrimSpace(value.ClientID) == "" {
@@ -133,6 +232,11 @@ func (s *Server) persistOAuthStateLocked() error {
 }
 
 func (s *Server) pruneOAuthStateLocked(now time.Time) {
+	for key, pending := range s.oauthPending {
+		if now.After(pending.ExpiresAt) {
+			delete(s.oauthPending, key)
+		}
+	}
 	for key, code := range s.oauthCodes {
 		if code.ExpiresAt.IsZero() {
 			continue
@@ -6,10 +6,12 @@ import (
 	"net/http"
 	"net/url"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/batuhan/easymatrix/internal/auth"
 	errs "github.com/batuhan/easymatrix/internal/errors"
 	beeperdesktopapi "github.com/beeper/desktop-api-go"
 	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
@@ -32,7 +34,7 @@ func (s *Server) requestBaseURL(r *http.Request) string {
 	if host == "" {
 		host = s.cfg.ListenAddr
 	}
-	return proto + "://" + host
+	return proto + "://" + host + auth.BasePath(r)
 }
 
 func renderSimpleHTML(title, body string) string {
@@ -146,20 +148,24 @@ func (s *Server) oauthAuthorizationServerMetadata(w http.ResponseWriter, r *http
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET")
 	w.Header().Set("Cache-Control", "no-cache")
-	return writeJSON(w, map[string]any{
+	metadata := map[string]any{
 		"issuer":                                baseURL,
 		"authorization_endpoint":                baseURL + "/oauth/authorize",
 		"token_endpoint":                        baseURL + "/oauth/token",
 		"revocation_endpoint":                   baseURL + "/oauth/revoke",
 		"userinfo_endpoint":                     baseURL + "/oauth/userinfo",
 		"registration_endpoint":                 baseURL + "/oauth/register",
-		"grant_types_supported":                 []string{"authorization_code"},
-		"token_endpoint_auth_methods_supported": []string{"none"},
+		"grant_types_supported":                 []string{"authorization_code", oauthGrantClientCredentials},
+		"token_endpoint_auth_methods_supported": []string{"none", "client_secret_basic", "client_secret_post"},
 		"response_types_supported":              []string{"code"},
 		"scopes_supported":                      []string{"read", "write"},
 		"code_challenge_methods_supported":      []string{"S256"},
 		"service_documentation":                 baseURL + "/v1/spec",
-	})
+	}
+	if s.oauthSigner != nil {
+		metadata["jwks_uri"] = baseURL + "/.well-known/jwks.json"
+	}
+	return writeJSON(w, metadata)
 }
 
 func (s *Server) oauthAuthorize(w http.ResponseWriter, r *http.Request) error {
@@ -228,19 +234,36 @@ func (s *Server) oauthAuthorize(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	code, err := s.createAuthorizationCode(clientID, redirectURI, scopes, state, codeChallenge, codeChallengeMethod, resource)
+	pending := oauthPendingAuthorization{
+		ClientID:            clientID,
+		ClientName:          client.ClientName,
+		RedirectURI:         redirectURI,
+		Scopes:              scopes,
+		State:               state,
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		Resource:            resource,
+	}
+	if s.cfg.OAuthRequireConsent && !s.hasRememberedConsent(clientID, scopes, redirectURI) {
+		return s.renderConsentPage(w, r, pending)
+	}
+	return s.completeAuthorization(w, r, pending)
+}
+
+func (s *Server) completeAuthorization(w http.ResponseWriter, r *http.Request, pending oauthPendingAuthorization) error {
+	code, err := s.createAuthorizationCode(pending.ClientID, pending.RedirectURI, pending.Scopes, pending.State, pending.CodeChallenge, pending.CodeChallengeMethod, pending.Resource)
 	if err != nil {
 		return errs.Internal(fmt.Errorf("failed to create authorization code: %w", err))
 	}
 
-	redirect, err := url.Parse(redirectURI)
+	redirect, err := url.Parse(pending.RedirectURI)
 	if err != nil {
 		return errs.Validation(map[string]any{"redirect_uri": "invalid redirect uri"})
 	}
 	values := redirect.Query()
 	values.Set("code", code.Code)
-	if state != "" {
-		values.Set("state", state)
+	if pending.State != "" {
+		values.Set("state", pending.State)
 	}
 	redirect.RawQuery = values.Encode()
 	http.Redirect(w, r, redirect.String(), http.StatusFound)
@@ -262,6 +285,11 @@ func (s *Server) oauthAuthorizeCallback(w http.ResponseWriter, r *http.Request)
 	if err := decodeJSON(r, &req); err != nil {
 		return err
 	}
+	// The callback is how Desktop's own approval UI hands out codes. With
+	// consent required it is only open to callers holding the manage secret.
+	if s.cfg.OAuthRequireConsent && !s.consentSecretValid(r.Header.Get(manageSecretHeaderName)) {
+		return errs.Forbidden("Authorization requires approval")
+	}
 
 	clientID := strings.TrimSpace(req.ClientInfo.ClientID)
 	if clientID == "" {
@@ -328,11 +356,14 @@ func (s *Server) oauthToken(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	grantType := strings.TrimSpace(body["grant_type"])
+	if grantType == oauthGrantClientCredentials {
+		return s.oauthClientCredentialsToken(w, r, body)
+	}
 	if grantType != "authorization_code" {
 		w.WriteHeader(http.StatusBadRequest)
 		return writeJSON(w, map[string]string{
 			"error":             "unsupported_grant_type",
-			"error_description": "only authorization_code is supported",
+			"error_description": "only authorization_code and client_credentials are supported",
 		})
 	}
 
@@ -377,7 +408,7 @@ func (s *Server) oauthToken(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	issued, err := s.issueOAuthAccessToken(code.ClientID, code.Scopes, resource)
+	issued, err := s.issueOAuthAccessToken(code.ClientID, code.Scopes, resource, s.requestBaseURL(r))
 	if err != nil {
 		return errs.Internal(fmt.Errorf("failed to issue access token: %w", err))
 	}
@@ -500,6 +531,9 @@ func (s *Server) oauthRegister(w http.ResponseWriter, r *http.Request) error {
 	if len(req.GrantTypes) == 0 {
 		req.GrantTypes = []string{"authorization_code"}
 	}
+	if slices.Contains(req.GrantTypes, oauthGrantClientCredentials) {
+		return errs.Validation(map[string]any{"grant_types": "client_credentials clients are created via POST /v1/admin/oauth/clients"})
+	}
 	if len(req.ResponseTypes) == 0 {
 		req.ResponseTypes = []string{"code"}
 	}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/auth"
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+const (
+	contactCacheMaxAge          = 6 * time.Hour
+	contactCacheRefreshInterval = 10 * time.Minute
+)
+
+// The cache lives in the gomuks database so it is carried along by account
+// export and removed together with the session.
+const contactCacheSchema = `
+	CREATE TABLE IF NOT EXISTS easymatrix_contact_cache (
+		account_id   TEXT    NOT NULL PRIMARY KEY,
+		contacts     TEXT    NOT NULL,
+		refreshed_at INTEGER NOT NULL
+	)
+`
+
+const contactCacheSelectQuery = `SELECT contacts, refreshed_at FROM easymatrix_contact_cache WHERE account_id = $1`
+
+const contactCacheUpsertQuery = `
+	INSERT INTO easymatrix_contact_cache (account_id, contacts, refreshed_at) VALUES ($1, $2, $3)
+	ON CONFLICT (account_id) DO UPDATE SET contacts = excluded.contacts, refreshed_at = excluded.refreshed_at
+`
+
+// cachedContact is a contact from the query-independent sources (room
+// members and the bridge contact list) with its source score.
+type cachedContact struct {
+	User  compat.User `json:"user"`
+	Score int         `json:"score"`
+}
+
+// contactCache tracks which accounts need their cached contacts rebuilt.
+// Membership changes arrive as room IDs and are mapped to accounts on the
+// next read, when an account lookup is at hand.
+type contactCache struct {
+	mu            sync.Mutex
+	schemaReady   bool
+	pendingRooms  map[id.RoomID]struct{}
+	staleAccounts map[string]struct{}
+	// refreshLocks keep concurrent requests for one account from rebuilding
+	// it in parallel; different accounts still refresh side by side.
+	refreshLocks map[string]*sync.Mutex
+}
+
+func newContactCache() *contactCache {
+	return &contactCache{
+		pendingRooms:  make(map[id.RoomID]struct{}),
+		staleAccounts: make(map[string]struct{}),
+		refreshLocks:  make(map[string]*sync.Mutex),
+	}
+}
+
+// reset forgets everything, for when the database the cache lives in has
+// been replaced.
+func (c *contactCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schemaReady = false
+	clear(c.pendingRooms)
+	clear(c.staleAccounts)
+}
+
+func (c *contactCache) refreshLock(accountID string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, ok := c.refreshLocks[accountID]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.refreshLocks[accountID] = lock
+	}
+	return lock
+}
+
+func (c *contactCache) invalidateRooms(roomIDs []id.RoomID) {
+	if len(roomIDs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, roomID := range roomIDs {
+		c.pendingRooms[roomID] = struct{}{}
+	}
+}
+
+// takeStale reports whether accountID was invalidated and clears the flag.
+// Clearing before the rebuild means a change that lands mid-rebuild marks
+// the account again instead of being lost.
+func (c *contactCache) takeStale(lookup *accountLookup, accountID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for roomID := range c.pendingRooms {
+		if mappedAccountID, _ := inferAccountForRoom(roomID, lookup); mappedAccountID != "" {
+			c.staleAccounts[mappedAccountID] = struct{}{}
+		}
+		delete(c.pendingRooms, roomID)
+	}
+	if _, ok := c.staleAccounts[accountID]; !ok {
+		return false
+	}
+	delete(c.staleAccounts, accountID)
+	return true
+}
+
+// syncMembershipRoomIDs lists rooms whose member list changed in a sync.
+func syncMembershipRoomIDs(syncComplete *jsoncmd.SyncComplete) []id.RoomID {
+	if syncComplete == nil {
+		return nil
+	}
+	roomIDs := append([]id.RoomID(nil), syncComplete.LeftRooms...)
+	for roomID, roomSync := range syncComplete.Rooms {
+		if roomSync == nil {
+			continue
+		}
+		for _, evt := range roomSync.Events {
+			if evt != nil && evt.GetType().Type == event.StateMember.Type {
+				roomIDs = append(roomIDs, roomID)
+				break
+			}
+		}
+	}
+	return roomIDs
+}
+
+func (s *Server) ensureContactCacheSchema(ctx context.Context) error {
+	s.contactCache.mu.Lock()
+	defer s.contactCache.mu.Unlock()
+	if s.contactCache.schemaReady {
+		return nil
+	}
+	if _, err := s.rt.Client().DB.Exec(ctx, contactCacheSchema); err != nil {
+		return fmt.Errorf("failed to create contact cache table: %w", err)
+	}
+	s.contactCache.schemaReady = true
+	return nil
+}
+
+func (s *Server) readCachedContacts(ctx context.Context, accountID string) ([]cachedContact, time.Time, error) {
+	var (
+		raw         string
+		refreshedAt int64
+	)
+	err := s.rt.Client().DB.QueryRow(ctx, contactCacheSelectQuery, accountID).Scan(&raw, &refreshedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read contact cache: %w", err)
+	}
+	var contacts []cachedContact
+	if err = json.Unmarshal([]byte(raw), &contacts); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse contact cache: %w", err)
+	}
+	return contacts, time.UnixMilli(refreshedAt), nil
+}
+
+func (s *Server) writeCachedContacts(ctx context.Context, accountID string, contacts []cachedContact, at time.Time) error {
+	raw, err := json.Marshal(contacts)
+	if err != nil {
+		return fmt.Errorf("failed to encode contact cache: %w", err)
+	}
+	if _, err = s.rt.Client().DB.Exec(ctx, contactCacheUpsertQuery, accountID, string(raw), at.UnixMilli()); err != nil {
+		return fmt.Errorf("failed to write contact cache: %w", err)
+	}
+	return nil
+}
+
+// cachedAccountContacts returns the query-independent contacts of an account,
+// rebuilding them when forced, invalidated by a membership change or older
+// than contactCacheMaxAge. Cache failures fall back to a live rebuild.
+func (s *Server) cachedAccountContacts(ctx context.Context, lookup *accountLookup, accountID string, forceRefresh bool) ([]cachedContact, error) {
+	// The background refresh has no request to take a base path from, so
+	// cached avatar URLs are kept relative to the root and rebased on return.
+	base := auth.ContextBasePath(ctx)
+	ctx = auth.ContextWithBasePath(ctx, "")
+	schemaErr := s.ensureContactCacheSchema(ctx)
+	if schemaErr != nil {
+		log.Printf("contact cache disabled: %v", schemaErr)
+	}
+	stale := s.contactCache.takeStale(lookup, accountID)
+	if schemaErr == nil && !forceRefresh && !stale {
+		contacts, refreshedAt, err := s.readCachedContacts(ctx, accountID)
+		if err != nil {
+			log.Printf("ignoring contact cache for %s: %v", accountID, err)
+		} else if !refreshedAt.IsZero() && time.Since(refreshedAt) < contactCacheMaxAge {
+			return rebaseContactAvatars(contacts, base), nil
+		}
+	}
+
+	lock := s.contactCache.refreshLock(accountID)
+	lock.Lock()
+	defer lock.Unlock()
+	contacts, err := s.collectAccountContacts(ctx, lookup, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if schemaErr == nil {
+		if err = s.writeCachedContacts(ctx, accountID, contacts, time.Now()); err != nil {
+			log.Printf("failed to update contact cache for %s: %v", accountID, err)
+		}
+	}
+	return rebaseContactAvatars(contacts, base), nil
+}
+
+// rebaseContactAvatars puts base in front of the asset cache avatar URLs of
+// contacts built by cachedAccountContacts.
+func rebaseContactAvatars(contacts []cachedContact, base string) []cachedContact {
+	if base == "" {
+		return contacts
+	}
+	for i := range contacts {
+		if strings.HasPrefix(contacts[i].User.ImgURL, "/v1/assets/serve?") {
+			contacts[i].User.ImgURL = base + contacts[i].User.ImgURL
+		}
+	}
+	return contacts
+}
+
+// collectAccountContacts walks the account's rooms and fetches the bridge
+// contact list. This is the slow part of a contact search.
+func (s *Server) collectAccountContacts(ctx context.Context, lookup *accountLookup, accountID string) ([]cachedContact, error) {
+	rooms, err := s.loadRoomsSorted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make([]cachedContact, 0, 256)
+	for _, room := range rooms {
+		mappedAccountID, _ := inferAccountForRoom(room.ID, lookup)
+		if mappedAccountID != accountID {
+			continue
+		}
+		participants, _ := s.loadRoomParticipants(ctx, room)
+		for _, participant := range participants {
+			participant.ID = strings.TrimSpace(participant.ID)
+			if participant.ID == "" || participant.IsSelf {
+				continue
+			}
+			if participant.Username == "" {
+				participant.Username = userIDLocalpart(participant.ID)
+			}
+			contacts = append(contacts, cachedContact{User: participant, Score: contactSourceScoreParticipants})
+		}
+	}
+
+	cloudContacts, _ := s.fetchCloudBridgeContacts(ctx, accountID)
+	for _, resolved := range cloudContacts {
+		if resolved == nil {
+			continue
+		}
+		contacts = append(contacts, cachedContact{User: s.mapResolvedIdentifierToUser(ctx, resolved), Score: contactSourceScoreCloudList})
+	}
+	return contacts, nil
+}
+
+func (s *Server) runContactCacheRefresh(ctx context.Context) {
+	ticker := time.NewTicker(contactCacheRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.refreshContactCache(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("contact cache refresh failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshContactCache rebuilds invalidated or expiring entries ahead of time
+// so searches rarely pay for the room walk themselves.
+func (s *Server) refreshContactCache(ctx context.Context) error {
+	cli := s.rt.Client()
+	if cli == nil || cli.Account == nil {
+		return nil
+	}
+	if err := s.ensureContactCacheSchema(ctx); err != nil {
+		return err
+	}
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	for _, account := range lookup.Accounts {
+		_, refreshedAt, err := s.readCachedContacts(ctx, account.AccountID)
+		// Refresh a little before expiry so readers keep hitting the cache.
+		expiring := err != nil || refreshedAt.IsZero() || time.Since(refreshedAt) > contactCacheMaxAge-2*contactCacheRefreshInterval
+		if _, err = s.cachedAccountContacts(ctx, lookup, account.AccountID, expiring); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestSyncMembershipRoomIDs(t *testing.T) {
+	syncComplete := &jsoncmd.SyncComplete{
+		Rooms: map[id.RoomID]*jsoncmd.SyncRoom{
+			"!members:example.org": {Events: []*database.Event{
+				{ID: "$m", Type: event.EventMessage.Type},
+				{ID: "$j", Type: event.StateMember.Type},
+			}},
+			"!chatter:example.org": {Events: []*database.Event{{ID: "$x", Type: event.EventMessage.Type}}},
+		},
+		LeftRooms: []id.RoomID{"!left:example.org"},
+	}
+	got := syncMembershipRoomIDs(syncComplete)
+	slices.Sort(got)
+	want := []id.RoomID{"!left:example.org", "!members:example.org"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("syncMembershipRoomIDs = %v, want %v", got, want)
+	}
+}
+
+func TestContactCacheTakeStaleMapsRoomsToAccounts(t *testing.T) {
+	whatsapp := compat.Account{AccountID: "whatsapp", Network: "WhatsApp"}
+	signal := compat.Account{AccountID: "signal", Network: "Signal"}
+	lookup := &accountLookup{
+		Accounts: []compat.Account{whatsapp, signal},
+		ByID:     map[string]compat.Account{"whatsapp": whatsapp, "signal": signal},
+		ByBridge: map[string][]compat.Account{"whatsapp": {whatsapp}, "signal": {signal}},
+	}
+	cache := newContactCache()
+	cache.invalidateRooms([]id.RoomID{"!abc:signal.example.org"})
+
+	if cache.takeStale(lookup, "whatsapp") {
+		t.Fatal("expected whatsapp to stay fresh")
+	}
+	if !cache.takeStale(lookup, "signal") {
+		t.Fatal("expected signal to be invalidated")
+	}
+	if cache.takeStale(lookup, "signal") {
+		t.Fatal("expected the stale flag to be cleared once taken")
+	}
+}
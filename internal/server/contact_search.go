@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+type accountContactResults struct {
+	AccountID string
+	Users     []compat.User
+	Err       error
+}
+
+// searchAllContacts runs the per-account contact search for every visible
+// account at once. Bridge lookups dominate the latency, so a slow network
+// no longer delays the others.
+func (s *Server) searchAllContacts(w http.ResponseWriter, r *http.Request) error {
+	query := strings.TrimSpace(r.URL.Query().Get("query"))
+	if query == "" {
+		return errs.Validation(map[string]any{"query": "query is required"})
+	}
+	limit, err := parseOptionalLimit(r.URL.Query().Get("limit"), searchContactsDefaultLimit, 1, searchContactsMaxLimit, "limit")
+	if err != nil {
+		return err
+	}
+	forceRefresh, err := parseOptionalBool(r.URL.Query().Get("forceRefresh"), false, "forceRefresh")
+	if err != nil {
+		return err
+	}
+	lookup, err := s.buildAccountLookup(r.Context())
+	if err != nil {
+		return err
+	}
+	policy := s.requestPolicy(r)
+	accountIDs := parseAccountIDs(r)
+
+	var accounts []string
+	for _, account := range lookup.Accounts {
+		if !policy.allowsAccount(account.AccountID) {
+			continue
+		}
+		if len(accountIDs) > 0 && !slices.Contains(accountIDs, account.AccountID) {
+			continue
+		}
+		accounts = append(accounts, account.AccountID)
+	}
+
+	results := make([]accountContactResults, len(accounts))
+	var wg sync.WaitGroup
+	for idx, accountID := range accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			users, err := s.loadAccountContacts(r.Context(), lookup, accountID, query, forceRefresh)
+			results[idx] = accountContactResults{AccountID: accountID, Users: users, Err: err}
+		}()
+	}
+	wg.Wait()
+	for _, result := range results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+
+	items := mergeAccountContactResults(results)
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+	return writeJSON(w, compat.SearchAllContactsOutput{Items: items, HasMore: hasMore})
+}
+
+// mergeAccountContactResults folds the same person found on several accounts
+// into one entry. Each account's list is already ranked, so an entry is
+// ordered by its best rank on any account.
+func mergeAccountContactResults(results []accountContactResults) []compat.GlobalContact {
+	type ranked struct {
+		contact compat.GlobalContact
+		rank    int
+		order   int
+	}
+	index := make(map[string]int)
+	var merged []ranked
+	for _, result := range results {
+		for rank, user := range result.Users {
+			key := addressBookKey(user)
+			if idx, ok := index[key]; ok {
+				entry := &merged[idx]
+				entry.contact.User = mergeContactUsers(entry.contact.User, user)
+				if !slices.Contains(entry.contact.AccountIDs, result.AccountID) {
+					entry.contact.AccountIDs = append(entry.contact.AccountIDs, result.AccountID)
+				}
+				entry.rank = min(entry.rank, rank)
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, ranked{
+				contact: compat.GlobalContact{User: user, AccountIDs: []string{result.AccountID}},
+				rank:    rank,
+				order:   len(merged),
+			})
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].rank != merged[j].rank {
+			return merged[i].rank < merged[j].rank
+		}
+		return merged[i].order < merged[j].order
+	})
+	items := make([]compat.GlobalContact, len(merged))
+	for idx, entry := range merged {
+		items[idx] = entry.contact
+	}
+	return items
+}
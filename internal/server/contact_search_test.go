@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/beeper/desktop-api-go/shared"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestMergeAccountContactResultsOrdersByBestRank(t *testing.T) {
+	items := mergeAccountContactResults([]accountContactResults{
+		{AccountID: "whatsapp", Users: []compat.User{
+			{ID: "@wa_bob:beeper.local", FullName: "Bob"},
+			{ID: "@wa_alice:beeper.local", FullName: "Alice", PhoneNumber: "+15550001"},
+		}},
+		{AccountID: "signal", Users: []compat.User{
+			{ID: "@signal_alice:beeper.local", FullName: "Alice", PhoneNumber: "+15550001"},
+		}},
+	})
+	if len(items) != 2 {
+		t.Fatalf("expected 2 merged contacts, got %#v", items)
+	}
+	if items[0].FullName != "Bob" || items[1].FullName != "Alice" {
+		t.Fatalf("unexpected order: %q, %q", items[0].FullName, items[1].FullName)
+	}
+	if !slices.Equal(items[1].AccountIDs, []string{"whatsapp", "signal"}) {
+		t.Fatalf("unexpected account IDs %#v", items[1].AccountIDs)
+	}
+}
+
+func TestGlobalContactJSONIncludesAccountIDs(t *testing.T) {
+	raw, err := json.Marshal(compat.GlobalContact{User: shared.User{ID: "@a:example.org"}, AccountIDs: []string{"matrix"}})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !strings.Contains(string(raw), `"id":"@a:example.org"`) || !strings.Contains(string(raw), `"accountIDs":["matrix"]`) {
+		t.Fatalf("unexpected JSON %s", raw)
+	}
+}
+
+func TestStartChatIdentifiersDeduplicates(t *testing.T) {
+	got := startChatIdentifiers(&compat.CreateChatStartUserInput{Username: "Alice", PhoneNumber: " +1 555 ", Email: "alice", FullName: ""})
+	if len(got) != 2 || got[0] != "Alice" || got[1] != "+1 555" {
+		t.Fatalf("startChatIdentifiers = %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const declarativeConfigVersion = 1
+
+// declarativeConfig is the version-controllable view of the integration
+// setup: registered OAuth clients, per-client access rules and the
+// auto-archive policy. Tokens, consents and other runtime state are left out.
+type declarativeConfig struct {
+	Version        int                           `yaml:"version"`
+	OAuthClients   []declarativeOAuthClient      `yaml:"oauthClients"`
+	ClientPolicies []declarativeClientPolicy     `yaml:"clientPolicies"`
+	AutoArchive    *declarativeAutoArchivePolicy `yaml:"autoArchive,omitempty"`
+}
+
+// declarativeOAuthClient carries the secret hash rather than the secret, so
+// applying a document reproduces a confidential client without the export
+// ever containing a usable credential.
+type declarativeOAuthClient struct {
+	ClientID                string   `yaml:"clientID"`
+	ClientName              string   `yaml:"clientName"`
+	ClientURI               string   `yaml:"clientURI,omitempty"`
+	RedirectURIs            []string `yaml:"redirectURIs,omitempty"`
+	GrantTypes              []string `yaml:"grantTypes"`
+	ResponseTypes           []string `yaml:"responseTypes,omitempty"`
+	Scope                   string   `yaml:"scope"`
+	TokenEndpointAuthMethod string   `yaml:"tokenEndpointAuthMethod"`
+	ClientSecretHash        string   `yaml:"clientSecretHash,omitempty"`
+}
+
+type declarativeAccessRule struct {
+	AccountIDs []string `yaml:"accountIDs,omitempty"`
+	ChatIDs    []string `yaml:"chatIDs,omitempty"`
+}
+
+type declarativeClientPolicy struct {
+	ClientID string                 `yaml:"clientID"`
+	Read     declarativeAccessRule  `yaml:"read"`
+	Write    *declarativeAccessRule `yaml:"write,omitempty"`
+}
+
+type declarativeAutoArchivePolicy struct {
+	InactiveDays          int      `yaml:"inactiveDays"`
+	LowPriorityUnreadDays int      `yaml:"lowPriorityUnreadDays"`
+	ExcludedChatIDs       []string `yaml:"excludedChatIDs,omitempty"`
+}
+
+func (s *Server) buildDeclarativeConfig() (declarativeConfig, error) {
+	doc := declarativeConfig{Version: declarativeConfigVersion}
+	s.oauthMu.RLock()
+	for _, client := range s.oauthClients {
+		doc.OAuthClients = append(doc.OAuthClients, declarativeOAuthClient{
+			ClientID:                client.ClientID,
+			ClientName:              client.ClientName,
+			ClientURI:               client.ClientURI,
+			RedirectURIs:            client.RedirectURIs,
+			GrantTypes:              client.GrantTypes,
+			ResponseTypes:           client.ResponseTypes,
+			Scope:                   client.Scope,
+			TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+			ClientSecretHash:        client.ClientSecretHash,
+		})
+	}
+	s.oauthMu.RUnlock()
+	sort.Slice(doc.OAuthClients, func(i, j int) bool {
+		return doc.OAuthClients[i].ClientID < doc.OAuthClients[j].ClientID
+	})
+
+	policies, err := s.clientPolicies.list()
+	if err != nil {
+		return doc, err
+	}
+	for _, policy := range policies {
+		entry := declarativeClientPolicy{
+			ClientID: policy.ClientID,
+			Read:     declarativeAccessRule(policy.Read),
+		}
+		if policy.Write != nil {
+			write := declarativeAccessRule(*policy.Write)
+			entry.Write = &write
+		}
+		doc.ClientPolicies = append(doc.ClientPolicies, entry)
+	}
+
+	autoArchive, err := s.autoArchive.get()
+	if err != nil {
+		return doc, err
+	}
+	if autoArchive.InactiveDays > 0 || autoArchive.LowPriorityUnreadDays > 0 || len(autoArchive.ExcludedChatIDs) > 0 {
+		doc.AutoArchive = &declarativeAutoArchivePolicy{
+			InactiveDays:          autoArchive.InactiveDays,
+			LowPriorityUnreadDays: autoArchive.LowPriorityUnreadDays,
+			ExcludedChatIDs:       autoArchive.ExcludedChatIDs,
+		}
+	}
+	return doc, nil
+}
+
+func (s *Server) exportDeclarativeConfig(w http.ResponseWriter, r *http.Request) error {
+	doc, err := s.buildDeclarativeConfig()
+	if err != nil {
+		return errs.Internal(err)
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(doc); err != nil {
+		return errs.Internal(fmt.Errorf("failed to encode config: %w", err))
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func parseDeclarativeConfig(raw io.Reader) (declarativeConfig, error) {
+	var doc declarativeConfig
+	decoder := yaml.NewDecoder(raw)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&doc); err != nil {
+		if tooLarge := payloadTooLargeError(err); tooLarge != nil {
+			return doc, tooLarge
+		}
+		if errors.Is(err, io.EOF) {
+			return doc, errs.Validation(map[string]any{"error": "config document is empty"})
+		}
+		return doc, errs.Validation(map[string]any{"error": err.Error()})
+	}
+	if doc.Version != declarativeConfigVersion {
+		return doc, errs.Validation(map[string]any{"version": fmt.Sprintf("unsupported config version %d", doc.Version)})
+	}
+	clientIDs := make(map[string]struct{}, len(doc.OAuthClients))
+	for idx, client := range doc.OAuthClients {
+		client.ClientID = strings.TrimSpace(client.ClientID)
+		if client.ClientID == "" || client.ClientID == oauthStaticClientID || client.ClientID == oauthManageClientID {
+			return doc, errs.Validation(map[string]any{"oauthClients": fmt.Sprintf("entry %d has an invalid clientID", idx)})
+		}
+		if _, ok := clientIDs[client.ClientID]; ok {
+			return doc, errs.Validation(map[string]any{"oauthClients": fmt.Sprintf("duplicate clientID %q", client.ClientID)})
+		}
+		if slices.Contains(client.GrantTypes, oauthGrantClientCredentials) && client.ClientSecretHash == "" {
+			return doc, errs.Validation(map[string]any{"oauthClients": fmt.Sprintf("client %q uses client_credentials without a clientSecretHash", client.ClientID)})
+		}
+		clientIDs[client.ClientID] = struct{}{}
+		doc.OAuthClients[idx] = client
+	}
+	for idx, policy := range doc.ClientPolicies {
+		if strings.TrimSpace(policy.ClientID) == "" {
+			return doc, errs.Validation(map[string]any{"clientPolicies": fmt.Sprintf("entry %d is missing clientID", idx)})
+		}
+		doc.ClientPolicies[idx].ClientID = strings.TrimSpace(policy.ClientID)
+	}
+	return doc, nil
+}
+
+// applyDeclarativeConfig upserts everything in the document and leaves
+// clients and policies it doesn't mention untouched, so applying the same
+// document twice is a no-op.
+// The document can install confidential clients and rewrite client policies,
+// so the route requires the manage secret like the other admin routes.
+func (s *Server) applyDeclarativeConfig(w http.ResponseWriter, r *http.Request) error {
+	doc, err := parseDeclarativeConfig(r.Body)
+	if err != nil {
+		return err
+	}
+	var autoArchive compat.AutoArchivePolicy
+	if doc.AutoArchive != nil {
+		autoArchive, err = normalizeAutoArchivePolicy(compat.AutoArchivePolicy{
+			InactiveDays:          doc.AutoArchive.InactiveDays,
+			LowPriorityUnreadDays: doc.AutoArchive.LowPriorityUnreadDays,
+			ExcludedChatIDs:       doc.AutoArchive.ExcludedChatIDs,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// Check every policy's client before writing anything, so a rejected
+	// document leaves the configuration as it was.
+	declared := make(map[string]bool, len(doc.OAuthClients))
+	for _, entry := range doc.OAuthClients {
+		declared[entry.ClientID] = true
+	}
+	for _, entry := range doc.ClientPolicies {
+		if !declared[entry.ClientID] && !s.knownOAuthClient(entry.ClientID) {
+			return errs.Validation(map[string]any{"clientPolicies": fmt.Sprintf("unknown OAuth client %q", entry.ClientID)})
+		}
+	}
+
+	s.oauthMu.Lock()
+	for _, entry := range doc.OAuthClients {
+		client := oauthClient{
+			ClientID:                entry.ClientID,
+			ClientName:              strings.TrimSpace(entry.ClientName),
+			ClientURI:               strings.TrimSpace(entry.ClientURI),
+			RedirectURIs:            nonNilStrings(entry.RedirectURIs),
+			GrantTypes:              nonNilStrings(entry.GrantTypes),
+			ResponseTypes:           nonNilStrings(entry.ResponseTypes),
+			Scope:                   oauthScopeString(normalizeOAuthScopes(entry.Scope)),
+			TokenEndpointAuthMethod: entry.TokenEndpointAuthMethod,
+			ClientSecretHash:        entry.ClientSecretHash,
+			CreatedAt:               time.Now().Unix(),
+		}
+		if existing, ok := s.oauthClients[client.ClientID]; ok {
+			client.CreatedAt = existing.CreatedAt
+		}
+		s.oauthClients[client.ClientID] = client
+	}
+	if len(doc.OAuthClients) > 0 {
+		err = s.persistOAuthStateLocked()
+	}
+	s.oauthMu.Unlock()
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to persist oauth clients: %w", err))
+	}
+
+	for _, entry := range doc.ClientPolicies {
+		policy := compat.ClientAccessPolicy{
+			ClientID:  entry.ClientID,
+			Read:      normalizeClientAccessRule(compat.ClientAccessRule(entry.Read)),
+			UpdatedAt: time.Now().UTC(),
+		}
+		if entry.Write != nil {
+			write := normalizeClientAccessRule(compat.ClientAccessRule(*entry.Write))
+			policy.Write = &write
+		}
+		if err = s.clientPolicies.put(policy); err != nil {
+			return errs.Internal(err)
+		}
+	}
+
+	autoArchiveChanged := false
+	if doc.AutoArchive != nil {
+		current, getErr := s.autoArchive.get()
+		if getErr != nil {
+			return errs.Internal(getErr)
+		}
+		// Setting the policy resets its run bookkeeping, so skip unchanged
+		// policies to keep re-applies side-effect free.
+		if current.InactiveDays != autoArchive.InactiveDays || current.LowPriorityUnreadDays != autoArchive.LowPriorityUnreadDays ||
+			!slices.Equal(current.ExcludedChatIDs, autoArchive.ExcludedChatIDs) {
+			if _, err = s.autoArchive.set(autoArchive); err != nil {
+				return errs.Internal(err)
+			}
+			autoArchiveChanged = true
+		}
+	}
+	return writeJSON(w, compat.ApplyConfigOutput{
+		OAuthClients:       len(doc.OAuthClients),
+		ClientPolicies:     len(doc.ClientPolicies),
+		AutoArchiveChanged: autoArchiveChanged,
+	})
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
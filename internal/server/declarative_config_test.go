@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+func TestParseDeclarativeConfig(t *testing.T) {
+	doc, err := parseDeclarativeConfig(strings.NewReader(`
+version: 1
+oauthClients:
+  - clientID: " bot "
+    clientName: Bot
+    grantTypes: [client_credentials]
+    scope: read
+    tokenEndpointAuthMethod: client_secret_basic
+    clientSecretHash: abc
+clientPolicies:
+  - clientID: bot
+    read:
+      chatIDs: ["!a:example.org"]
+autoArchive:
+  inactiveDays: 30
+`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if doc.OAuthClients[0].ClientID != "bot" || doc.ClientPolicies[0].Read.ChatIDs[0] != "!a:example.org" || doc.AutoArchive.InactiveDays != 30 {
+		t.Fatalf("unexpected document %#v", doc)
+	}
+
+	for _, raw := range []string{
+		"version: 2\n",
+		"version: 1\nunknown: true\n",
+		"version: 1\noauthClients:\n  - clientID: bot\n    grantTypes: [client_credentials]\n",
+	} {
+		if _, err = parseDeclarativeConfig(strings.NewReader(raw)); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestApplyDeclarativeConfigRejectsUnknownPolicyClientBeforeWriting(t *testing.T) {
+	s := newDBTestServer(t)
+	req := httptest.NewRequest(http.MethodPut, "/v1/admin/config", strings.NewReader(`
+version: 1
+oauthClients:
+  - clientID: bot
+    clientName: Bot
+clientPolicies:
+  - clientID: bot
+    read:
+      chatIDs: ["!a:example.org"]
+  - clientID: typo
+    read:
+      chatIDs: ["!b:example.org"]
+`))
+	var apiErr *errs.APIError
+	if err := s.applyDeclarativeConfig(httptest.NewRecorder(), req); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if s.knownOAuthClient("bot") {
+		t.Fatal("expected the rejected document not to register its client")
+	}
+	if policies, err := s.clientPolicies.list(); err != nil || len(policies) != 0 {
+		t.Fatalf("expected no client policies to be stored, got %#v (err=%v)", policies, err)
+	}
+}
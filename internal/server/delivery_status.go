@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const readReceiptHighWaterQuery = `
+	SELECT COALESCE(MAX(timeline.rowid), 0)
+	FROM receipt
+	JOIN event ON event.event_id = receipt.event_id
+	JOIN timeline ON timeline.event_rowid = event.rowid
+	WHERE receipt.room_id = $1 AND receipt.receipt_type = 'm.read' AND receipt.user_id <> $2
+`
+
+const messageSendStatusSelectQuery = `
+	SELECT relates_to, content FROM event
+	WHERE room_id = $1 AND type = 'com.beeper.message_send_status' AND relates_to IN (%s)
+	ORDER BY rowid ASC
+`
+
+// messageDeliveryState holds the per-room receipt and bridge status data
+// needed to derive deliveryStatus for a batch of outgoing messages.
+type messageDeliveryState struct {
+	ReadUpTo     database.TimelineRowID
+	BridgeStatus map[id.EventID]event.BeeperMessageStatusEventContent
+}
+
+func (s *Server) loadMessageDeliveryState(ctx context.Context, roomID id.RoomID, events []*database.Event) (*messageDeliveryState, error) {
+	cli := s.rt.Client()
+	state := &messageDeliveryState{BridgeStatus: make(map[id.EventID]event.BeeperMessageStatusEventContent)}
+
+	var ownEventIDs []any
+	for _, evt := range events {
+		if evt != nil && evt.Sender == cli.Account.UserID && !strings.HasPrefix(string(evt.ID), "~") {
+			ownEventIDs = append(ownEventIDs, string(evt.ID))
+		}
+	}
+	if len(ownEventIDs) == 0 {
+		return state, nil
+	}
+
+	if err := cli.DB.QueryRow(ctx, readReceiptHighWaterQuery, roomID, cli.Account.UserID).Scan(&state.ReadUpTo); err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to read receipts: %w", err))
+	}
+
+	placeholders := make([]string, len(ownEventIDs))
+	for idx := range ownEventIDs {
+		placeholders[idx] = fmt.Sprintf("$%d", idx+2)
+	}
+	args := append([]any{roomID}, ownEventIDs...)
+	rows, err := cli.DB.Query(ctx, fmt.Sprintf(messageSendStatusSelectQuery, strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query message send status: %w", err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			relatesTo string
+			content   []byte
+		)
+		if err = rows.Scan(&relatesTo, &content); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan message send status: %w", err))
+		}
+		var status event.BeeperMessageStatusEventContent
+		if json.Unmarshal(content, &status) == nil && status.Status != "" {
+			state.BridgeStatus[id.EventID(relatesTo)] = status
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("message send status query failed: %w", err))
+	}
+	return state, nil
+}
+
+// messageDeliveryStatus derives a tick state for a message sent by the
+// current user. Local echoes have a "~"-prefixed event ID until the homeserver
+// acknowledges them; a nil state degrades to pending/sent/failed only.
+func messageDeliveryStatus(evt *database.Event, state *messageDeliveryState) compat.MessageDeliveryStatus {
+	if evt.SendError != "" {
+		return compat.MessageDeliveryStatusFailed
+	}
+	if strings.HasPrefix(string(evt.ID), "~") {
+		return compat.MessageDeliveryStatusPending
+	}
+	if state == nil {
+		return compat.MessageDeliveryStatusSent
+	}
+	if evt.TimelineRowID != 0 && state.ReadUpTo >= evt.TimelineRowID {
+		return compat.MessageDeliveryStatusRead
+	}
+	if status, ok := state.BridgeStatus[evt.ID]; ok {
+		switch status.Status {
+		case event.MessageStatusFail:
+			return compat.MessageDeliveryStatusFailed
+		case event.MessageStatusSuccess:
+			if status.DeliveredToUsers != nil && len(*status.DeliveredToUsers) > 0 {
+				return compat.MessageDeliveryStatusDelivered
+			}
+		}
+	}
+	return compat.MessageDeliveryStatusSent
+}
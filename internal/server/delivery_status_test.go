@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestMessageDeliveryStatus(t *testing.T) {
+	deliveredTo := []id.UserID{"@alice:example.org"}
+	state := &messageDeliveryState{
+		ReadUpTo: 10,
+		BridgeStatus: map[id.EventID]event.BeeperMessageStatusEventContent{
+			"$delivered": {Status: event.MessageStatusSuccess, DeliveredToUsers: &deliveredTo},
+			"$bridged":   {Status: event.MessageStatusSuccess},
+			"$rejected":  {Status: event.MessageStatusFail},
+		},
+	}
+	cases := []struct {
+		name     string
+		evt      *database.Event
+		state    *messageDeliveryState
+		expected compat.MessageDeliveryStatus
+	}{
+		{"send error", &database.Event{ID: "$x", SendError: "boom"}, state, compat.MessageDeliveryStatusFailed},
+		{"local echo", &database.Event{ID: "~txn"}, state, compat.MessageDeliveryStatusPending},
+		{"read by receipt", &database.Event{ID: "$delivered", TimelineRowID: 9}, state, compat.MessageDeliveryStatusRead},
+		{"delivered by bridge", &database.Event{ID: "$delivered", TimelineRowID: 11}, state, compat.MessageDeliveryStatusDelivered},
+		{"bridged without delivery", &database.Event{ID: "$bridged", TimelineRowID: 12}, state, compat.MessageDeliveryStatusSent},
+		{"bridge failure", &database.Event{ID: "$rejected", TimelineRowID: 13}, state, compat.MessageDeliveryStatusFailed},
+		{"no state", &database.Event{ID: "$plain", TimelineRowID: 1}, nil, compat.MessageDeliveryStatusSent},
+	}
+	for _, tc := range cases {
+		if got := messageDeliveryStatus(tc.evt, tc.state); got != tc.expected {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/i18n"
+)
+
+const (
+	digestStoreFormat          = 1
+	digestCheckInterval        = 15 * time.Minute
+	defaultDigestIntervalHours = 24
+	maxDigestIntervalHours     = 24 * 7
+	maxDigestChats             = 50
+	digestOwnerKey             = "owner"
+)
+
+// digestEntry is one user's schedule. Subjects authenticated by the external
+// identity provider keep their chat policy when the digest is built, since
+// the background pass has no request to derive it from.
+type digestEntry struct {
+	Subject  string                `json:"subject,omitempty"`
+	External bool                  `json:"external,omitempty"`
+	Settings compat.DigestSettings `json:"settings"`
+}
+
+type digestStore struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	replica replicaFile
+	entries map[string]digestEntry
+}
+
+type digestStorePersisted struct {
+	Version int                    `json:"version"`
+	Entries map[string]digestEntry `json:"entries"`
+}
+
+func newDigestStore(path string) *digestStore {
+	return &digestStore{path: path}
+}
+
+func (c *digestStore) loadLocked() error {
+	stamp, changed, err := c.replica.changed(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read digest settings: %w", err)
+	}
+	if c.loaded && !changed {
+		return nil
+	}
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.entries, c.replica.seen, c.loaded = make(map[string]digestEntry), stamp, true
+			return nil
+		}
+		return fmt.Errorf("failed to read digest settings: %w", err)
+	}
+	var persisted digestStorePersisted
+	if err = json.Unmarshal(raw, &persisted); err != nil {
+		return fmt.Errorf("failed to parse digest settings: %w", err)
+	}
+	if persisted.Version != digestStoreFormat {
+		return fmt.Errorf("unsupported digest settings version: %d", persisted.Version)
+	}
+	if persisted.Entries == nil {
+		persisted.Entries = make(map[string]digestEntry)
+	}
+	c.entries, c.replica.seen, c.loaded = persisted.Entries, stamp, true
+	return nil
+}
+
+func (c *digestStore) saveLocked() error {
+	raw, err := json.Marshal(digestStorePersisted{Version: digestStoreFormat, Entries: c.entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode digest settings: %w", err)
+	}
+	return writeAtomicFile(c.path, raw, 0o600)
+}
+
+func (c *digestStore) get(key string) (digestEntry, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return digestEntry{}, false, err
+	}
+	entry, ok := c.entries[key]
+	return entry, ok, nil
+}
+
+func (c *digestStore) list() (map[string]digestEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return nil, err
+	}
+	out := make(map[string]digestEntry, len(c.entries))
+	for key, entry := range c.entries {
+		out[key] = entry
+	}
+	return out, nil
+}
+
+// set replaces a schedule but keeps LastSentAt, so changing the address or
+// interval does not immediately resend the previous digest.
+func (c *digestStore) set(key string, entry digestEntry) (digestEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return digestEntry{}, err
+	}
+	entry.Settings.LastSentAt = c.entries[key].Settings.LastSentAt
+	c.entries[key] = entry
+	return entry, c.saveLocked()
+}
+
+func (c *digestStore) remove(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return false, err
+	}
+	if _, ok := c.entries[key]; !ok {
+		return false, nil
+	}
+	delete(c.entries, key)
+	return true, c.saveLocked()
+}
+
+func (c *digestStore) markSent(key string, at time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return err
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry.Settings.LastSentAt = &at
+	c.entries[key] = entry
+	return c.saveLocked()
+}
+
+// requestDigestEntry identifies the caller's digest. Everyone who is not an
+// external identity acts for the deployment owner and shares one schedule.
+// Digests are built with the subject policy alone, so callers confined any
+// further, such as OAuth clients with an allowlist, are refused.
+func (s *Server) requestDigestEntry(r *http.Request) (string, digestEntry, error) {
+	key, entry := digestOwnerKey, digestEntry{}
+	info := mcpauth.TokenInfoFromContext(r.Context())
+	if info != nil && info.Extra["identity"] == externalIdentityMarker {
+		key, entry = "subject:"+info.UserID, digestEntry{Subject: info.UserID, External: true}
+	}
+	if policy := s.requestPolicy(r); policy != nil && (!entry.External || policy != s.requestSubjectPolicy(r)) {
+		return "", digestEntry{}, errs.Forbidden("Email digests are not available to clients limited to specific chats")
+	}
+	return key, entry, nil
+}
+
+func normalizeDigestSettings(input compat.DigestSettings, fallbackLocale i18n.Locale) (compat.DigestSettings, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(input.Email))
+	if err != nil {
+		return compat.DigestSettings{}, errs.Validation(map[string]any{"email": "must be a valid email address"})
+	}
+	if input.IntervalHours == 0 {
+		input.IntervalHours = defaultDigestIntervalHours
+	}
+	if input.IntervalHours < 1 || input.IntervalHours > maxDigestIntervalHours {
+		return compat.DigestSettings{}, errs.Validation(map[string]any{"intervalHours": fmt.Sprintf("must be between 1 and %d", maxDigestIntervalHours)})
+	}
+	locale := fallbackLocale
+	if input.Locale != "" {
+		var ok bool
+		if locale, ok = i18n.Parse(input.Locale); !ok {
+			return compat.DigestSettings{}, errs.Validation(map[string]any{"locale": "is not a supported locale"})
+		}
+	}
+	return compat.DigestSettings{
+		Email:              addr.Address,
+		IntervalHours:      input.IntervalHours,
+		SendWhileConnected: input.SendWhileConnected,
+		Locale:             string(locale),
+	}, nil
+}
+
+func digestDue(settings compat.DigestSettings, now time.Time) bool {
+	if settings.LastSentAt == nil {
+		return true
+	}
+	return !now.Before(settings.LastSentAt.Add(time.Duration(settings.IntervalHours) * time.Hour))
+}
+
+func (s *Server) digestEnabled() bool {
+	return s.cfg.SMTPHost != ""
+}
+
+func (s *Server) getDigestSettings(w http.ResponseWriter, r *http.Request) error {
+	key, _, err := s.requestDigestEntry(r)
+	if err != nil {
+		return err
+	}
+	entry, ok, err := s.digests.get(key)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	if !ok {
+		return errs.NotFound("No digest is scheduled")
+	}
+	return writeJSON(w, entry.Settings)
+}
+
+func (s *Server) setDigestSettings(w http.ResponseWriter, r *http.Request) error {
+	if !s.digestEnabled() {
+		return errs.Validation(map[string]any{"email": "email digests require EASYMATRIX_SMTP_HOST to be configured"})
+	}
+	var req compat.DigestSettings
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	settings, err := normalizeDigestSettings(req, i18n.FromContext(r.Context()))
+	if err != nil {
+		return err
+	}
+	key, entry, err := s.requestDigestEntry(r)
+	if err != nil {
+		return err
+	}
+	entry.Settings = settings
+	if entry, err = s.digests.set(key, entry); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, entry.Settings)
+}
+
+func (s *Server) deleteDigestSettings(w http.ResponseWriter, r *http.Request) error {
+	key, _, err := s.requestDigestEntry(r)
+	if err != nil {
+		return err
+	}
+	removed, err := s.digests.remove(key)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	if !removed {
+		return errs.NotFound("No digest is scheduled")
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+// sendDigestNow sends the caller's digest immediately, ignoring the interval
+// and connected clients, which makes it the easiest way to test SMTP setup.
+func (s *Server) sendDigestNow(w http.ResponseWriter, r *http.Request) error {
+	if !s.digestEnabled() {
+		return errs.Validation(map[string]any{"email": "email digests require EASYMATRIX_SMTP_HOST to be configured"})
+	}
+	key, _, err := s.requestDigestEntry(r)
+	if err != nil {
+		return err
+	}
+	entry, ok, err := s.digests.get(key)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	if !ok {
+		return errs.NotFound("No digest is scheduled")
+	}
+	entry.Settings.LastSentAt = nil
+	chats, err := s.sendDigest(r.Context(), key, entry, time.Now())
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, compat.SendDigestOutput{Sent: chats > 0, Chats: chats})
+}
+
+func (s *Server) runDigests(ctx context.Context) {
+	if !s.digestEnabled() {
+		return
+	}
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.digestPass(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("digest pass failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) digestPass(ctx context.Context, now time.Time) error {
+	entries, err := s.digests.list()
+	if err != nil {
+		return err
+	}
+	connected := s.ws.clientCount() > 0
+	for key, entry := range entries {
+		if !digestDue(entry.Settings, now) || (connected && !entry.Settings.SendWhileConnected) {
+			continue
+		}
+		if _, err = s.sendDigest(ctx, key, entry, now); err != nil {
+			log.Printf("failed to send digest to %s: %v", entry.Settings.Email, err)
+		}
+	}
+	return nil
+}
+
+// digestChat is one line of a digest email.
+type digestChat struct {
+	Title         string
+	Network       string
+	Highlights    int
+	Unread        int
+	LastMessageAt time.Time
+}
+
+// sendDigest mails the chats with unread mentions that saw activity since
+// the previous digest and returns how many were included.
+func (s *Server) sendDigest(ctx context.Context, key string, entry digestEntry, now time.Time) (int, error) {
+	cli := s.rt.Client()
+	if cli == nil || cli.Account == nil {
+		return 0, nil
+	}
+	var policy *subjectPolicy
+	if entry.External {
+		if s.identity == nil {
+			return 0, nil
+		}
+		if policy = s.identity.policies[entry.Subject]; policy == nil {
+			return 0, nil
+		}
+	}
+	locale, _ := i18n.Parse(entry.Settings.Locale)
+	ctx = i18n.WithLocale(ctx, locale)
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rooms, err := s.loadRoomsSorted(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var chats []digestChat
+	for _, room := range rooms {
+		if room.UnreadHighlights <= 0 {
+			continue
+		}
+		if entry.Settings.LastSentAt != nil && !room.SortingTimestamp.After(*entry.Settings.LastSentAt) {
+			continue
+		}
+		accountID, network := inferAccountForRoom(room.ID, lookup)
+		if !policy.allowsChat(string(room.ID), accountID) {
+			continue
+		}
+		title := strings.TrimSpace(ptrString(room.Name))
+		if title == "" {
+			title = string(room.ID)
+		}
+		chats = append(chats, digestChat{
+			Title:         title,
+			Network:       network,
+			Highlights:    room.UnreadHighlights,
+			Unread:        room.UnreadMessages,
+			LastMessageAt: room.SortingTimestamp.Time,
+		})
+	}
+	if len(chats) == 0 {
+		return 0, nil
+	}
+	msg := formatDigestEmail(s.cfg.SMTPFrom, entry.Settings.Email, chats, now, i18n.FromContext(ctx))
+	if err = s.sendMail(entry.Settings.Email, msg); err != nil {
+		return 0, errs.Internal(fmt.Errorf("failed to send digest email: %w", err))
+	}
+	if err = s.digests.markSent(key, now); err != nil {
+		return 0, errs.Internal(err)
+	}
+	return len(chats), nil
+}
+
+func (s *Server) sendMail(to string, msg []byte) error {
+	var smtpAuth smtp.Auth
+	if s.cfg.SMTPUsername != "" {
+		smtpAuth = smtp.PlainAuth("", s.cfg.SMTPUsername, s.cfg.SMTPPassword, s.cfg.SMTPHost)
+	}
+	addr := net.JoinHostPort(s.cfg.SMTPHost, strconv.Itoa(s.cfg.SMTPPort))
+	return smtp.SendMail(addr, smtpAuth, s.cfg.SMTPFrom, []string{to}, msg)
+}
+
+// formatDigestEmail renders a plain-text digest, most-mentioned chats first.
+func formatDigestEmail(from, to string, chats []digestChat, now time.Time, locale i18n.Locale) []byte {
+	chats = slices.Clone(chats)
+	slices.SortStableFunc(chats, func(a, b digestChat) int {
+		return b.Highlights - a.Highlights
+	})
+	total := len(chats)
+	if len(chats) > maxDigestChats {
+		chats = chats[:maxDigestChats]
+	}
+
+	subject := locale.Plural("digest.subject", total)
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(locale.T("digest.intro") + "\r\n\r\n")
+	for _, chat := range chats {
+		line := "- " + strings.ReplaceAll(strings.ReplaceAll(chat.Title, "\r", " "), "\n", " ")
+		if chat.Network != "" {
+			line += " (" + chat.Network + ")"
+		}
+		line += ": " + locale.Plural("digest.mentions", chat.Highlights)
+		if chat.Unread > 0 {
+			line += ", " + locale.T("digest.unread", chat.Unread)
+		}
+		if !chat.LastMessageAt.IsZero() {
+			line += ", " + locale.T("digest.lastMessage", locale.Relative(chat.LastMessageAt, now))
+		}
+		b.WriteString(line + "\r\n")
+	}
+	if total > len(chats) {
+		b.WriteString("\r\n" + locale.T("digest.more", total-len(chats)) + "\r\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/i18n"
+)
+
+func TestNormalizeDigestSettings(t *testing.T) {
+	settings, err := normalizeDigestSettings(compat.DigestSettings{Email: " Alice <alice@example.com> "}, i18n.German)
+	if err != nil {
+		t.Fatalf("normalizeDigestSettings returned error: %v", err)
+	}
+	if settings.Email != "alice@example.com" || settings.IntervalHours != defaultDigestIntervalHours || settings.Locale != "de" {
+		t.Fatalf("unexpected settings: %+v", settings)
+	}
+	if _, err = normalizeDigestSettings(compat.DigestSettings{Email: "not an address"}, i18n.Default); err == nil {
+		t.Fatal("expected invalid email to be rejected")
+	}
+	if _, err = normalizeDigestSettings(compat.DigestSettings{Email: "a@example.com", IntervalHours: maxDigestIntervalHours + 1}, i18n.Default); err == nil {
+		t.Fatal("expected oversized interval to be rejected")
+	}
+	if _, err = normalizeDigestSettings(compat.DigestSettings{Email: "a@example.com", Locale: "xx"}, i18n.Default); err == nil {
+		t.Fatal("expected an unsupported locale to be rejected")
+	}
+}
+
+func TestDigestDue(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	settings := compat.DigestSettings{IntervalHours: 6}
+	if !digestDue(settings, now) {
+		t.Fatal("expected a never-sent digest to be due")
+	}
+	last := now.Add(-5 * time.Hour)
+	settings.LastSentAt = &last
+	if digestDue(settings, now) {
+		t.Fatal("expected digest inside the interval to wait")
+	}
+	last = now.Add(-6 * time.Hour)
+	if !digestDue(settings, now) {
+		t.Fatal("expected digest at the interval boundary to be due")
+	}
+}
+
+func TestFormatDigestEmailOrdersByMentions(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := string(formatDigestEmail("bot@example.com", "alice@example.com", []digestChat{
+		{Title: "Quiet", Network: "whatsapp", Highlights: 1},
+		{Title: "Busy\r\nBcc: evil@example.com", Highlights: 4, Unread: 9},
+	}, now, i18n.English))
+	if !strings.Contains(msg, "Subject: 2 chats with unread mentions\r\n") {
+		t.Fatalf("missing subject in %q", msg)
+	}
+	busy := strings.Index(msg, "- Busy  Bcc: evil@example.com: 4 mentions, 9 unread\r\n")
+	quiet := strings.Index(msg, "- Quiet (whatsapp): 1 mention\r\n")
+	if busy < 0 || quiet < 0 || busy > quiet {
+		t.Fatalf("unexpected digest body: %q", msg)
+	}
+}
+
+func TestFormatDigestEmailLocalized(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := string(formatDigestEmail("bot@example.com", "alice@example.com", []digestChat{
+		{Title: "Team", Highlights: 2, LastMessageAt: now.Add(-2 * time.Hour)},
+	}, now, i18n.German))
+	if !strings.Contains(msg, "- Team: 2 Erwähnungen, letzte Nachricht vor 2 Stunden\r\n") {
+		t.Fatalf("unexpected digest body: %q", msg)
+	}
+}
+
+func TestDigestRefusesClientsLimitedToSomeChats(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{
+		clientPolicies: newClientPolicyStore(filepath.Join(dir, "client-policies.json")),
+		digests:        newDigestStore(filepath.Join(dir, "digest.json")),
+	}
+	if err := s.clientPolicies.put(compat.ClientAccessPolicy{ClientID: "agent", Read: compat.ClientAccessRule{ChatIDs: []string{"!room:example.org"}}}); err != nil {
+		t.Fatalf("failed to store client policy: %v", err)
+	}
+	var apiErr *errs.APIError
+	err := callAsClient("agent", httptest.NewRequest(http.MethodGet, "/v1/digest", nil), s.getDigestSettings)
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Fatalf("expected a confined client to be refused the owner's digest, got %v", err)
+	}
+	err = callAsClient("other", httptest.NewRequest(http.MethodGet, "/v1/digest", nil), s.getDigestSettings)
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Fatalf("expected an unrestricted client to reach the digest store, got %v", err)
+	}
+}
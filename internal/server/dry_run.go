@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	dryRunActionSendMessage = "message.send"
+	dryRunActionCreateChat  = "chat.create"
+	dryRunActionStartChat   = "chat.start"
+)
+
+// sendPlan is the part of an outgoing message that bridge capabilities and
+// power levels are checked against.
+type sendPlan struct {
+	TextLength int
+	// Caption means the text rides on the attachment, so the caption limit
+	// applies instead of the text limit.
+	Caption  bool
+	HasReply bool
+	MsgType  event.MessageType
+	MimeType string
+	FileSize int64
+	// Field names the attachment in issues, e.g. attachments[1]. Empty
+	// means the single attachment field.
+	Field string
+}
+
+func (p sendPlan) attachmentField() string {
+	if p.Field == "" {
+		return "attachment"
+	}
+	return p.Field
+}
+
+func parseDryRun(r *http.Request) (bool, error) {
+	return parseOptionalBool(r.URL.Query().Get("dryRun"), false, "dryRun")
+}
+
+func newDryRunOutput(action string, issues []compat.DryRunIssue) compat.DryRunOutput {
+	if issues == nil {
+		issues = []compat.DryRunIssue{}
+	}
+	return compat.DryRunOutput{
+		DryRun:       true,
+		Action:       action,
+		WouldSucceed: len(issues) == 0,
+		Issues:       issues,
+	}
+}
+
+// sendCapabilityIssues compares a planned message with the bridge's advertised
+// room features. A nil features value means the room is not bridged (or the
+// bridge did not say), in which case nothing is flagged.
+func sendCapabilityIssues(features *event.RoomFeatures, plan sendPlan) []compat.DryRunIssue {
+	if features == nil {
+		return nil
+	}
+	var issues []compat.DryRunIssue
+	if !plan.Caption && features.MaxTextLength > 0 && plan.TextLength > features.MaxTextLength {
+		issues = append(issues, compat.DryRunIssue{
+			Code:    "TEXT_TOO_LONG",
+			Field:   "text",
+			Message: fmt.Sprintf("text is %d characters; the network allows at most %d", plan.TextLength, features.MaxTextLength),
+		})
+	}
+	if plan.HasReply && features.Reply <= event.CapLevelDropped {
+		issues = append(issues, compat.DryRunIssue{Code: "UNSUPPORTED", Field: "replyToMessageID", Message: "replies are not supported on this network"})
+	}
+	if plan.MsgType == "" {
+		return issues
+	}
+	fileFeatures := features.File[plan.MsgType]
+	if fileFeatures == nil {
+		issues = append(issues, compat.DryRunIssue{Code: "UNSUPPORTED", Field: plan.attachmentField(), Message: fmt.Sprintf("%s attachments are not supported on this network", plan.MsgType)})
+		return issues
+	}
+	if plan.MimeType != "" && fileFeatures.GetMimeSupport(plan.MimeType) <= event.CapLevelRejected {
+		issues = append(issues, compat.DryRunIssue{Code: "UNSUPPORTED", Field: plan.attachmentField() + ".mimeType", Message: fmt.Sprintf("%s files are rejected on this network", plan.MimeType)})
+	}
+	if fileFeatures.MaxSize > 0 && plan.FileSize > fileFeatures.MaxSize {
+		issues = append(issues, compat.DryRunIssue{
+			Code:    "FILE_TOO_LARGE",
+			Field:   plan.attachmentField(),
+			Message: fmt.Sprintf("file is %d bytes; the network allows at most %d", plan.FileSize, fileFeatures.MaxSize),
+		})
+	}
+	return issues
+}
+
+// captionFits reports whether text of textLength can be sent as the caption
+// of a msgType attachment. Rooms without advertised features are plain
+// Matrix, where media captions are part of the spec.
+func captionFits(features *event.RoomFeatures, msgType event.MessageType, textLength int) bool {
+	if features == nil {
+		return true
+	}
+	fileFeatures := features.File[msgType]
+	if fileFeatures == nil || fileFeatures.Caption <= event.CapLevelDropped {
+		return false
+	}
+	return fileFeatures.MaxCaptionLength <= 0 || textLength <= fileFeatures.MaxCaptionLength
+}
+
+func (s *Server) loadRoomFeatures(ctx context.Context, roomID id.RoomID) *event.RoomFeatures {
+	evt, err := s.rt.Client().DB.CurrentState.Get(ctx, roomID, event.StateBeeperRoomFeatures, "")
+	if err != nil || evt == nil {
+		return nil
+	}
+	var features event.RoomFeatures
+	if err = json.Unmarshal(evt.GetContent(), &features); err != nil {
+		return nil
+	}
+	return &features
+}
+
+// dryRunSendMessage checks text and reply once and every attachment on its
+// own. field is "attachment" for the single attachment and "attachments" for
+// the list, which indexes the issue fields.
+func (s *Server) dryRunSendMessage(ctx context.Context, roomID id.RoomID, text, replyToMessageID string, attachments []compat.MessageAttachmentInput, field string) (compat.DryRunOutput, error) {
+	plan := sendPlan{TextLength: utf8.RuneCountInString(text), HasReply: replyToMessageID != ""}
+	var issues []compat.DryRunIssue
+	messageType := compat.MessageTypeText
+	attachmentPlans := make([]sendPlan, 0, len(attachments))
+	for i, attachment := range attachments {
+		meta, err := s.loadUploadMetadataByID(attachment.UploadID)
+		if err != nil {
+			return compat.DryRunOutput{}, err
+		}
+		attachmentPlan := sendPlan{Field: field, FileSize: meta.FileSize}
+		if field == "attachments" {
+			attachmentPlan.Field = fmt.Sprintf("attachments[%d]", i)
+		}
+		attachmentPlan.MimeType = strings.TrimSpace(attachment.MimeType.Or(""))
+		if attachmentPlan.MimeType == "" {
+			attachmentPlan.MimeType = meta.MimeType
+		}
+		attachmentPlan.MsgType = messageTypeFromAttachment(attachmentPlan.MimeType, strings.TrimSpace(attachment.Type))
+		if i == 0 {
+			if attachmentPlan.MsgType == "m.sticker" {
+				messageType = mapMessageType(event.EventSticker.Type, "")
+			} else {
+				messageType = mapMessageType(event.EventMessage.Type, attachmentPlan.MsgType)
+			}
+		}
+		attachmentPlans = append(attachmentPlans, attachmentPlan)
+	}
+
+	if plan.HasReply {
+		target, err := s.rt.Client().DB.Event.GetByID(ctx, id.EventID(replyToMessageID))
+		if err != nil {
+			return compat.DryRunOutput{}, errs.Internal(fmt.Errorf("failed to get reply target: %w", err))
+		}
+		if target == nil || target.RoomID != roomID {
+			issues = append(issues, compat.DryRunIssue{Code: "NOT_FOUND", Field: "replyToMessageID", Message: "reply target was not found in this chat"})
+		}
+	}
+
+	pl, err := s.loadPowerLevels(ctx, roomID)
+	if err != nil {
+		return compat.DryRunOutput{}, err
+	}
+	if pl.GetUserLevel(s.rt.Client().Account.UserID) < pl.GetEventLevel(event.EventMessage) {
+		issues = append(issues, compat.DryRunIssue{Code: "FORBIDDEN", Message: "you do not have permission to send messages in this chat"})
+	}
+	features := s.loadRoomFeatures(ctx, roomID)
+	if len(attachmentPlans) > 0 && plan.TextLength > 0 {
+		plan.Caption = captionFits(features, attachmentPlans[0].MsgType, plan.TextLength)
+	}
+	issues = append(issues, sendCapabilityIssues(features, plan)...)
+	for _, attachmentPlan := range attachmentPlans {
+		issues = append(issues, sendCapabilityIssues(features, attachmentPlan)...)
+	}
+
+	output := newDryRunOutput(dryRunActionSendMessage, issues)
+	output.ChatID = string(roomID)
+	output.MessageType = messageType
+	return output, nil
+}
+
+// participantIDIssues resolves participant IDs the way createChat does, so
+// that a dry run reports the ones that could never be invited instead of the
+// homeserver or bridge rejecting them later. Network identifiers are looked
+// up on the account's bridge.
+func (s *Server) participantIDIssues(ctx context.Context, accountID string, participantIDs []string) ([]compat.User, []compat.DryRunIssue, error) {
+	users := make([]compat.User, 0, len(participantIDs))
+	var issues []compat.DryRunIssue
+	bridgeID, _ := splitDesktopAccountID(accountID)
+	onBridge := bridgeID != "" && bridgeID != "matrix"
+	for _, participantID := range participantIDs {
+		participantID = strings.TrimSpace(participantID)
+		if participantID == "" {
+			continue
+		}
+		if strings.HasPrefix(participantID, "@") || !onBridge {
+			if _, _, err := id.UserID(participantID).Parse(); err != nil {
+				issues = append(issues, compat.DryRunIssue{Code: "INVALID_USER_ID", Field: "participantIDs", Message: fmt.Sprintf("%q is not a Matrix user ID", participantID)})
+				continue
+			}
+			users = append(users, newCompatUser(ctx, userShape{ID: participantID}))
+			continue
+		}
+		resolved, err := s.resolveCloudBridgeIdentifier(ctx, accountID, participantID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resolved == nil || resolved.MXID == "" {
+			issues = append(issues, compat.DryRunIssue{Code: "NOT_FOUND", Field: "participantIDs", Message: fmt.Sprintf("could not resolve %q on this account's network", participantID)})
+			continue
+		}
+		user := s.mapResolvedIdentifierToUser(ctx, resolved)
+		user.ID = string(resolved.MXID)
+		users = append(users, user)
+	}
+	return users, issues, nil
+}
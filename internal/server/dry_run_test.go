@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestSendCapabilityIssuesWithoutFeaturesIsClean(t *testing.T) {
+	if issues := sendCapabilityIssues(nil, sendPlan{TextLength: 1 << 20, HasReply: true, MsgType: event.MsgImage}); len(issues) != 0 {
+		t.Fatalf("expected no issues without room features, got %#v", issues)
+	}
+}
+
+func TestSendCapabilityIssuesFlagsUnsupportedContent(t *testing.T) {
+	features := &event.RoomFeatures{
+		MaxTextLength: 10,
+		Reply:         event.CapLevelRejected,
+		File: event.FileFeatureMap{
+			event.MsgImage: {MimeTypes: map[string]event.CapabilitySupportLevel{"image/png": event.CapLevelFullySupported}, MaxSize: 100},
+		},
+	}
+	issues := sendCapabilityIssues(features, sendPlan{TextLength: 11, HasReply: true, MsgType: event.MsgImage, MimeType: "image/png", FileSize: 101})
+	fields := map[string]bool{}
+	for _, issue := range issues {
+		fields[issue.Field] = true
+	}
+	for _, field := range []string{"text", "replyToMessageID", "attachment"} {
+		if !fields[field] {
+			t.Fatalf("expected an issue for %s, got %#v", field, issues)
+		}
+	}
+
+	issues = sendCapabilityIssues(features, sendPlan{MsgType: event.MsgVideo})
+	if len(issues) != 1 || issues[0].Code != "UNSUPPORTED" {
+		t.Fatalf("expected unsupported video issue, got %#v", issues)
+	}
+	issues = sendCapabilityIssues(features, sendPlan{MsgType: event.MsgImage, MimeType: "image/gif", FileSize: 1})
+	if len(issues) != 1 || issues[0].Field != "attachment.mimeType" {
+		t.Fatalf("expected rejected mime type issue, got %#v", issues)
+	}
+}
+
+func TestParticipantIDIssuesRejectsNonMatrixIDs(t *testing.T) {
+	s := &Server{}
+	users, issues, err := s.participantIDIssues(context.Background(), "matrix", []string{"@alice:example.org", "bob", " "})
+	if err != nil {
+		t.Fatalf("participantIDIssues failed: %v", err)
+	}
+	if len(users) != 1 || users[0].ID != "@alice:example.org" {
+		t.Fatalf("unexpected resolved users: %#v", users)
+	}
+	if len(issues) != 1 || issues[0].Code != "INVALID_USER_ID" {
+		t.Fatalf("unexpected issues: %#v", issues)
+	}
+}
+
+func TestSendCapabilityIssuesUsesAttachmentField(t *testing.T) {
+	features := &event.RoomFeatures{
+		File: event.FileFeatureMap{
+			event.MsgImage: {MimeTypes: map[string]event.CapabilitySupportLevel{"image/png": event.CapLevelFullySupported}},
+		},
+	}
+	issues := sendCapabilityIssues(features, sendPlan{MsgType: event.MsgImage, MimeType: "image/gif", Field: "attachments[1]"})
+	if len(issues) != 1 || issues[0].Field != "attachments[1].mimeType" {
+		t.Fatalf("expected issue on the indexed attachment, got %#v", issues)
+	}
+}
+
+func TestCaptionFitsFollowsFileFeatures(t *testing.T) {
+	if !captionFits(nil, event.MsgImage, 5000) {
+		t.Fatalf("expected captions to fit in rooms without features")
+	}
+	features := &event.RoomFeatures{
+		File: event.FileFeatureMap{
+			event.MsgImage: {Caption: event.CapLevelFullySupported, MaxCaptionLength: 10},
+			event.MsgAudio: {Caption: event.CapLevelDropped},
+		},
+	}
+	if !captionFits(features, event.MsgImage, 10) || captionFits(features, event.MsgImage, 11) {
+		t.Fatalf("expected the caption length limit to apply")
+	}
+	if captionFits(features, event.MsgAudio, 1) || captionFits(features, event.MsgVideo, 1) {
+		t.Fatalf("expected dropped or missing caption support to reject captions")
+	}
+}
+
+func TestSendCapabilityIssuesSkipsTextLimitForCaptions(t *testing.T) {
+	features := &event.RoomFeatures{MaxTextLength: 10}
+	if issues := sendCapabilityIssues(features, sendPlan{TextLength: 11, Caption: true}); len(issues) != 0 {
+		t.Fatalf("expected no text issue for a caption, got %#v", issues)
+	}
+}
+
+func TestParticipantIDIssuesResolvesBridgeIdentifiers(t *testing.T) {
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/resolve_identifier/+15550100") {
+			_, _ = w.Write([]byte(`{"id":"15550100","name":"Alice","mxid":"@whatsapp_15550100:example.org"}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer bridge.Close()
+	s := newDBTestServer(t)
+	homeserverURL, _ := url.Parse(bridge.URL)
+	s.rt.Client().Client.HomeserverURL = homeserverURL
+
+	users, issues, err := s.participantIDIssues(context.Background(), "whatsapp_login", []string{"+15550100", "+15550199"})
+	if err != nil {
+		t.Fatalf("participantIDIssues failed: %v", err)
+	}
+	if len(users) != 1 || users[0].ID != "@whatsapp_15550100:example.org" {
+		t.Fatalf("expected the phone number to resolve to the bridge user, got %#v", users)
+	}
+	if len(issues) != 1 || issues[0].Code != "NOT_FOUND" {
+		t.Fatalf("expected the unknown number to be reported, got %#v", issues)
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	e2eeHealthDefaultLimit = 200
+	e2eeHealthMaxLimit     = 2000
+)
+
+const e2eeRecentEventsQuery = `
+	SELECT type, COALESCE(megolm_session_id, ''), sender, timestamp, decrypted IS NOT NULL, COALESCE(decryption_error, '')
+	FROM event
+	WHERE room_id = $1 AND state_key IS NULL
+	ORDER BY timestamp DESC, rowid DESC
+	LIMIT $2
+`
+
+const e2eeSessionRequestsQuery = `
+	SELECT session_id, backup_checked, request_sent
+	FROM session_request
+	WHERE room_id = $1
+`
+
+type e2eeEventRow struct {
+	Type      string
+	SessionID string
+	Sender    string
+	Timestamp int64
+	Decrypted bool
+	Error     string
+}
+
+type e2eeSessionRequest struct {
+	BackupChecked bool
+	RequestSent   bool
+}
+
+// summarizeE2EEEvents groups the undecryptable events among rows by Megolm
+// session. requests is the client's key request queue for the room.
+func summarizeE2EEEvents(rows []e2eeEventRow, requests map[string]e2eeSessionRequest) compat.E2EESessionHealth {
+	health := compat.E2EESessionHealth{CheckedEvents: len(rows), Sessions: []compat.E2EEMissingSession{}}
+	bySession := make(map[string]*compat.E2EEMissingSession)
+	for _, row := range rows {
+		if row.Type != event.EventEncrypted.Type {
+			continue
+		}
+		health.EncryptedEvents++
+		if row.Decrypted {
+			continue
+		}
+		health.UndecryptableEvents++
+		ts := time.UnixMilli(row.Timestamp).UTC()
+		session, ok := bySession[row.SessionID]
+		if !ok {
+			request, queued := requests[row.SessionID]
+			session = &compat.E2EEMissingSession{
+				SessionID:     row.SessionID,
+				SenderID:      row.Sender,
+				OldestEventAt: ts,
+				NewestEventAt: ts,
+				Queued:        queued,
+				BackupChecked: request.BackupChecked,
+				KeyRequested:  request.RequestSent,
+			}
+			bySession[row.SessionID] = session
+		}
+		session.UndecryptableEvents++
+		if ts.Before(session.OldestEventAt) {
+			session.OldestEventAt = ts
+		}
+		if ts.After(session.NewestEventAt) {
+			session.NewestEventAt = ts
+		}
+		if session.Error == "" {
+			session.Error = row.Error
+		}
+	}
+	for _, session := range bySession {
+		health.Sessions = append(health.Sessions, *session)
+	}
+	sort.Slice(health.Sessions, func(i, j int) bool {
+		return health.Sessions[i].OldestEventAt.Before(health.Sessions[j].OldestEventAt)
+	})
+	if len(health.Sessions) > 0 {
+		oldest := health.Sessions[0].OldestEventAt
+		health.OldestMissingAt = &oldest
+	}
+	return health
+}
+
+func (s *Server) loadE2EESessionHealth(ctx context.Context, room *database.Room, limit int) (compat.E2EESessionHealth, error) {
+	cli := s.rt.Client()
+	rows, err := cli.DB.Query(ctx, e2eeRecentEventsQuery, room.ID, limit)
+	if err != nil {
+		return compat.E2EESessionHealth{}, errs.Internal(fmt.Errorf("failed to query recent events: %w", err))
+	}
+	defer rows.Close()
+	var events []e2eeEventRow
+	for rows.Next() {
+		var row e2eeEventRow
+		if err = rows.Scan(&row.Type, &row.SessionID, &row.Sender, &row.Timestamp, &row.Decrypted, &row.Error); err != nil {
+			return compat.E2EESessionHealth{}, errs.Internal(fmt.Errorf("failed to scan event: %w", err))
+		}
+		events = append(events, row)
+	}
+	if err = rows.Err(); err != nil {
+		return compat.E2EESessionHealth{}, errs.Internal(fmt.Errorf("recent events query failed: %w", err))
+	}
+
+	requestRows, err := cli.DB.Query(ctx, e2eeSessionRequestsQuery, room.ID)
+	if err != nil {
+		return compat.E2EESessionHealth{}, errs.Internal(fmt.Errorf("failed to query key requests: %w", err))
+	}
+	defer requestRows.Close()
+	requests := make(map[string]e2eeSessionRequest)
+	for requestRows.Next() {
+		var (
+			sessionID string
+			request   e2eeSessionRequest
+		)
+		if err = requestRows.Scan(&sessionID, &request.BackupChecked, &request.RequestSent); err != nil {
+			return compat.E2EESessionHealth{}, errs.Internal(fmt.Errorf("failed to scan key request: %w", err))
+		}
+		requests[sessionID] = request
+	}
+	if err = requestRows.Err(); err != nil {
+		return compat.E2EESessionHealth{}, errs.Internal(fmt.Errorf("key request query failed: %w", err))
+	}
+
+	health := summarizeE2EEEvents(events, requests)
+	health.ChatID = string(room.ID)
+	health.Encrypted = room.EncryptionEvent != nil
+	return health, nil
+}
+
+func (s *Server) e2eeHealthRoom(r *http.Request) (*database.Room, int, error) {
+	limit, err := parseOptionalLimit(r.URL.Query().Get("limit"), e2eeHealthDefaultLimit, 1, e2eeHealthMaxLimit, "limit")
+	if err != nil {
+		return nil, 0, err
+	}
+	room, err := s.loadChatRoom(r.Context(), readChatID(r, ""))
+	if err != nil {
+		return nil, 0, err
+	}
+	return room, limit, nil
+}
+
+func (s *Server) getE2EESessionHealth(w http.ResponseWriter, r *http.Request) error {
+	room, limit, err := s.e2eeHealthRoom(r)
+	if err != nil {
+		return err
+	}
+	health, err := s.loadE2EESessionHealth(r.Context(), room, limit)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, health)
+}
+
+// repairE2EESessions puts every missing session back in the client's key
+// request queue, so it is looked up in the key backup again and requested from
+// our other devices and the sender's. The queue never resends a request that
+// already went out, hence the remove before the put.
+func (s *Server) repairE2EESessions(w http.ResponseWriter, r *http.Request) error {
+	room, limit, err := s.e2eeHealthRoom(r)
+	if err != nil {
+		return err
+	}
+	health, err := s.loadE2EESessionHealth(r.Context(), room, limit)
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	output := compat.E2EERepairOutput{QueuedSessions: []string{}, Health: health}
+	for _, session := range health.Sessions {
+		if session.SessionID == "" {
+			continue
+		}
+		sessionID := id.SessionID(session.SessionID)
+		if err = cli.DB.SessionRequest.Remove(r.Context(), sessionID, 0); err != nil {
+			return errs.Internal(fmt.Errorf("failed to reset key request: %w", err))
+		}
+		if err = cli.DB.SessionRequest.Put(r.Context(), &database.SessionRequest{
+			RoomID:    room.ID,
+			SessionID: sessionID,
+			Sender:    id.UserID(session.SenderID),
+		}); err != nil {
+			return errs.Internal(fmt.Errorf("failed to queue key request: %w", err))
+		}
+		output.QueuedSessions = append(output.QueuedSessions, session.SessionID)
+	}
+	if len(output.QueuedSessions) > 0 {
+		cli.WakeupRequestQueue()
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return writeJSON(w, output)
+}
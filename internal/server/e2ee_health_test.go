@@ -0,0 +1,25 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSummarizeE2EEEventsGroupsMissingSessions(t *testing.T) {
+	rows := []e2eeEventRow{
+		{Type: "m.room.encrypted", SessionID: "s1", Sender: "@a:example.org", Timestamp: 3000},
+		{Type: "m.room.encrypted", SessionID: "s2", Sender: "@b:example.org", Timestamp: 2000, Decrypted: true},
+		{Type: "m.room.message", Sender: "@c:example.org", Timestamp: 1500},
+		{Type: "m.room.encrypted", SessionID: "s1", Sender: "@a:example.org", Timestamp: 1000, Error: "no session"},
+	}
+	health := summarizeE2EEEvents(rows, map[string]e2eeSessionRequest{"s1": {BackupChecked: true}})
+	if health.CheckedEvents != 4 || health.EncryptedEvents != 3 || health.UndecryptableEvents != 2 || len(health.Sessions) != 1 {
+		t.Fatalf("unexpected health %+v", health)
+	}
+	session := health.Sessions[0]
+	if session.SessionID != "s1" || session.UndecryptableEvents != 2 || !session.Queued || !session.BackupChecked || session.KeyRequested || session.Error != "no session" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	if health.OldestMissingAt == nil || health.OldestMissingAt.UnixMilli() != 1000 || session.NewestEventAt.UnixMilli() != 3000 {
+		t.Fatalf("unexpected timestamps %+v", health)
+	}
+}
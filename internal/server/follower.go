@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// followerPublicRoutes are served by a follower itself besides the read
+// routes, so health checks see the follower rather than the primary.
+var followerPublicRoutes = map[string]struct{}{
+	"GET /v1/info": {},
+	"GET /v1/spec": {},
+}
+
+// followerPrimaryReads are read routes a follower still proxies: their state
+// lives in the primary's memory, or answering them writes to the state dir.
+// The websocket is among them because the follower does not sync and would
+// never emit an event.
+var followerPrimaryReads = map[string]struct{}{
+	"GET /v1/ws":                 {},
+	"GET /v1/exports/{exportID}": {},
+	"GET /v1/assets/serve":       {},
+	"GET /v1/assets/thumbnail":   {},
+	"GET /v1/accounts/connect/{bridgeID}/logins/{loginProcessID}": {},
+}
+
+// servedByFollower reports whether a follower answers an API route from its
+// replica. Only read-scoped GET routes qualify; read-scoped POST routes such
+// as chat exports create state and go to the primary with the writes.
+func servedByFollower(pattern string, requiredScopes []string) bool {
+	if !strings.HasPrefix(pattern, http.MethodGet+" ") {
+		return false
+	}
+	if _, primaryOnly := followerPrimaryReads[pattern]; primaryOnly {
+		return false
+	}
+	return slices.Contains(requiredScopes, "read") && !slices.Contains(requiredScopes, "write")
+}
+
+func newPrimaryProxy(primaryURL string) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(primaryURL)
+	if err != nil {
+		return nil, err
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("failed to proxy %s %s to primary: %v", r.Method, r.URL.Path, err)
+			errs.Write(w, errs.New(http.StatusBadGateway, "PRIMARY_UNAVAILABLE", "The primary instance could not be reached", nil))
+		},
+	}, nil
+}
+
+// followerHandler sends every request the follower does not serve locally to
+// the primary, including manage, OAuth and any route it does not know.
+func (s *Server) followerHandler(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		_, public := followerPublicRoutes[pattern]
+		if _, local := s.followerRoutes[pattern]; local || public {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		s.primary.ServeHTTP(w, r)
+	})
+}
+
+// stateFileStamp identifies one version of a state file, so a follower can
+// tell when the primary has rewritten its replicated copy. A missing file
+// has the zero stamp.
+type stateFileStamp struct {
+	modTime int64
+	size    int64
+}
+
+func stampOf(info os.FileInfo) stateFileStamp {
+	return stateFileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+func statStateFile(path string) (stateFileStamp, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return stateFileStamp{}, nil
+	} else if err != nil {
+		return stateFileStamp{}, err
+	}
+	return stampOf(info), nil
+}
+
+// replicaFile lets a file-backed store follow the primary's rewrites of its
+// file. Stores normally load their file once; on a follower they load it
+// again whenever its stamp changes.
+type replicaFile struct {
+	follow bool
+	seen   stateFileStamp
+}
+
+// changed reports whether a loaded store has to read path again, along with
+// the stamp to record once that read succeeds.
+func (f *replicaFile) changed(path string) (stateFileStamp, bool, error) {
+	if !f.follow {
+		return stateFileStamp{}, false, nil
+	}
+	stamp, err := statStateFile(path)
+	if err != nil {
+		return stateFileStamp{}, false, err
+	}
+	return stamp, stamp != f.seen, nil
+}
+
+// followReplicatedStores makes the stores behind follower-served routes pick
+// up the primary's rewrites of their files.
+func (s *Server) followReplicatedStores() {
+	s.clientPolicies.replica.follow = true
+	s.changes.replica.follow = true
+	s.chatMetadata.replica.follow = true
+	s.messageAnnotations.replica.follow = true
+	s.sandboxes.replica.follow = true
+	s.autoArchive.replica.follow = true
+	s.digests.replica.follow = true
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"path/filepath"
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/config"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func TestFollowerProxiesWritesToPrimary(t *testing.T) {
+	var proxied []string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer primary.Close()
+
+	cfg := config.Config{
+		ListenAddr:          "127.0.0.1:0",
+		StateDir:            t.TempDir(),
+		MatrixHomeserverURL: "https://matrix.beeper.com",
+		PrimaryURL:          primary.URL,
+	}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	handler := New(cfg, rt).Handler()
+
+	cases := []struct {
+		method, path string
+		proxied      bool
+	}{
+		{http.MethodGet, "/v1/chats", false},
+		{http.MethodGet, "/v1/info", false},
+		{http.MethodPost, "/v1/chats/!room:example.com/messages", true},
+		{http.MethodGet, "/v1/ws", true},
+		{http.MethodPost, "/v1/chats/!room:example.com/export", true},
+		{http.MethodGet, "/v1/exports/abc", true},
+		{http.MethodGet, "/v1/assets/serve", true},
+		{http.MethodPost, "/manage/logout", true},
+		{http.MethodPost, "/oauth/token", true},
+	}
+	for _, tc := range cases {
+		proxied = nil
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if got := len(proxied) == 1; got != tc.proxied {
+			t.Fatalf("%s %s: proxied=%v want %v (status %d)", tc.method, tc.path, got, tc.proxied, rec.Code)
+		}
+		if tc.proxied && (rec.Code != http.StatusTeapot || proxied[0] != tc.method+" "+tc.path) {
+			t.Fatalf("%s %s: unexpected proxy result %d %v", tc.method, tc.path, rec.Code, proxied)
+		}
+	}
+}
+
+func TestFollowerReloadsOAuthStateWhenPrimaryRewritesIt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oauth", "state.json")
+	newOAuthServer := func() *Server {
+		return &Server{
+			oauthState:    path,
+			oauthClients:  make(map[string]oauthClient),
+			oauthCodes:    make(map[string]oauthAuthorizationCode),
+			oauthTokens:   make(map[string]oauthAccessToken),
+			oauthConsents: make(map[string]oauthConsent),
+			oauthPending:  make(map[string]oauthPendingAuthorization),
+		}
+	}
+	primary := newOAuthServer()
+	follower := newOAuthServer()
+	follower.primary = &httputil.ReverseProxy{}
+	follower.initOAuthState("static")
+
+	primary.oauthTokens["issued"] = oauthAccessToken{Value: "issued", ClientID: "app", Scopes: []string{"read"}}
+	if err := primary.persistOAuthState(); err != nil {
+		t.Fatalf("persist failed: %v", err)
+	}
+	if _, ok := follower.tokenInfoForBearer("issued"); !ok {
+		t.Fatal("expected follower to accept a token issued on the primary")
+	}
+
+	delete(primary.oauthTokens, "issued")
+	primary.oauthTokens["other"] = oauthAccessToken{Value: "other", ClientID: "app", Scopes: []string{"read"}}
+	if err := primary.persistOAuthState(); err != nil {
+		t.Fatalf("persist failed: %v", err)
+	}
+	if _, ok := follower.tokenInfoForBearer("issued"); ok {
+		t.Fatal("expected follower to drop a token revoked on the primary")
+	}
+	if _, ok := follower.tokenInfoForBearer("static"); !ok {
+		t.Fatal("expected the static token to survive a reload")
+	}
+}
+
+func TestFollowerStoresReloadWhenPrimaryRewritesThem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client-policies.json")
+	primary := newClientPolicyStore(path)
+	follower := newClientPolicyStore(path)
+	follower.replica.follow = true
+
+	wide := compat.ClientAccessPolicy{ClientID: "agent", Read: compat.ClientAccessRule{ChatIDs: []string{"!a:example.org", "!b:example.org"}}}
+	if err := primary.put(wide); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if policy, ok, err := follower.get("agent"); err != nil || !ok || len(policy.Read.ChatIDs) != 2 {
+		t.Fatalf("unexpected follower policy: %#v (%v, %v)", policy, ok, err)
+	}
+
+	narrow := compat.ClientAccessPolicy{ClientID: "agent", Read: compat.ClientAccessRule{ChatIDs: []string{"!a:example.org"}}}
+	if err := primary.put(narrow); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if policy, _, _ := follower.get("agent"); len(policy.Read.ChatIDs) != 1 {
+		t.Fatalf("expected follower to pick up the narrowed policy, got %#v", policy)
+	}
+
+	if removed, err := primary.remove("agent"); err != nil || !removed {
+		t.Fatalf("remove failed: %v %v", removed, err)
+	}
+	if _, ok, _ := follower.get("agent"); ok {
+		t.Fatal("expected follower to drop a policy deleted on the primary")
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+const (
+	followupDefaultOlderThanHours = 24
+	followupMaxOlderThanHours     = 24 * 30
+	followupDefaultWithinDays     = 14
+	followupMaxWithinDays         = 90
+)
+
+var (
+	// followupURLPattern strips links first so a "?" in a query string is
+	// not mistaken for a question.
+	followupURLPattern     = regexp.MustCompile(`https?://\S+`)
+	followupRequestPattern = regexp.MustCompile(`(?i)^(can|could|would|will|do|did|are|is|have|has|when|what|where|who|why|how|should|shall)\s+(you|u|we)\b|\b(please|pls|plz|let me know|lmk|any update|any news|get back to me|thoughts|what do you think)\b`)
+)
+
+// looksLikeFollowup is a cheap heuristic for inbound text that expects an
+// answer: an explicit question mark or a typical request phrasing.
+func looksLikeFollowup(text string) bool {
+	text = strings.TrimSpace(followupURLPattern.ReplaceAllString(text, ""))
+	if text == "" {
+		return false
+	}
+	return strings.Contains(text, "?") || followupRequestPattern.MatchString(text)
+}
+
+// listFollowups returns chats whose latest message is an unanswered inbound
+// question older than olderThanHours. Only chats active in the last
+// withinDays are considered, and archived or muted chats are skipped.
+func (s *Server) listFollowups(w http.ResponseWriter, r *http.Request) error {
+	olderThanHours, err := parseOptionalLimit(r.URL.Query().Get("olderThanHours"), followupDefaultOlderThanHours, 1, followupMaxOlderThanHours, "olderThanHours")
+	if err != nil {
+		return err
+	}
+	withinDays, err := parseOptionalLimit(r.URL.Query().Get("withinDays"), followupDefaultWithinDays, 1, followupMaxWithinDays, "withinDays")
+	if err != nil {
+		return err
+	}
+	ctx := r.Context()
+	cli := s.rt.Client()
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	rooms, err := s.loadRoomsSorted(ctx)
+	if err != nil {
+		return err
+	}
+	roomStates, err := s.loadRoomAccountDataStates(ctx)
+	if err != nil {
+		return err
+	}
+	visibility := s.requestPolicy(r)
+	now := time.Now()
+	cutoff := now.Add(-time.Duration(olderThanHours) * time.Hour)
+	horizon := now.Add(-time.Duration(withinDays) * 24 * time.Hour)
+
+	items := make([]compat.Followup, 0)
+	for _, room := range rooms {
+		if room.SortingTimestamp.Before(horizon) {
+			break
+		}
+		state := roomStates[room.ID]
+		if room.PreviewEventRowID <= 0 || state.EffectiveArchived() || state.IsMuted {
+			continue
+		}
+		evt, getErr := cli.DB.Event.GetByRowID(ctx, room.PreviewEventRowID)
+		if getErr != nil || evt == nil || evt.Sender == cli.Account.UserID || evt.Timestamp.After(cutoff) {
+			continue
+		}
+		if evt.GetType().Type != event.EventMessage.Type {
+			continue
+		}
+		message, mapErr := s.mapEventToMessage(ctx, evt, room, lookup, reactionBundle{})
+		if mapErr != nil || !looksLikeFollowup(message.Text) {
+			continue
+		}
+		chat, mapErr := s.mapRoomToChat(ctx, room, lookup, chatPreviewParticipants, false, state)
+		if mapErr != nil || !visibility.allowsChat(chat.ID, chat.AccountID) {
+			continue
+		}
+		items = append(items, compat.Followup{Chat: chat, Message: message, WaitingSince: evt.Timestamp.UTC()})
+	}
+	return writeJSON(w, compat.ListFollowupsOutput{Items: items})
+}
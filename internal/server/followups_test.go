@@ -0,0 +1,16 @@
+package server
+
+import "testing"
+
+func TestLooksLikeFollowup(t *testing.T) {
+	for _, text := range []string{"Are you coming tonight?", "can you send me the report", "Please review the doc", "lmk when you land"} {
+		if !looksLikeFollowup(text) {
+			t.Fatalf("expected %q to need a reply", text)
+		}
+	}
+	for _, text := range []string{"thanks!", "see https://example.org/?q=1", "ok sounds good", ""} {
+		if looksLikeFollowup(text) {
+			t.Fatalf("expected %q not to need a reply", text)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+// forwardHeaderPatterns match the header line bridges put in front of
+// forwarded messages. The first group, when present, is the original sender.
+var forwardHeaderPatterns = []*regexp.Regexp{
+	// Telegram: "Forwarded from Alice:" or "Forwarded message from Alice"
+	regexp.MustCompile(`(?i)^forwarded (?:message )?from (.+?):?[ \t]*(?:\n|$)`),
+	// Email-style forwards: "---------- Forwarded message ---------\nFrom: Alice <a@b>"
+	regexp.MustCompile(`(?i)^-{2,} ?forwarded message ?-{2,}[ \t]*\n(?:from: (.+?)[ \t]*(?:\n|$))?`),
+	// WhatsApp and Meta bridges mark forwards without naming the sender.
+	regexp.MustCompile(`(?i)^(?:↷ ?)?forwarded(?: message)?:?[ \t]*(?:\n|$)`),
+}
+
+var matrixToUserPattern = regexp.MustCompile(`href="https://matrix\.to/#/((?:@|%40)[^"?]+)`)
+
+// parseForwardedMessage extracts the forward header from a bridged message
+// body. The remaining text loses the "> " quote prefix bridges add when every
+// line carries it.
+func parseForwardedMessage(body string) (*compat.ForwardedFrom, bool) {
+	body = strings.TrimLeft(body, " \t\r\n")
+	for _, pattern := range forwardHeaderPatterns {
+		match := pattern.FindStringSubmatchIndex(body)
+		if match == nil {
+			continue
+		}
+		forwarded := &compat.ForwardedFrom{Text: unquoteForwardedText(body[match[1]:])}
+		if len(match) > 3 && match[2] >= 0 {
+			forwarded.Name = strings.TrimSpace(body[match[2]:match[3]])
+		}
+		return forwarded, true
+	}
+	return nil, false
+}
+
+func unquoteForwardedText(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for _, line := range lines {
+		if !strings.HasPrefix(line, ">") {
+			return strings.TrimSpace(text)
+		}
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(strings.TrimPrefix(line, ">"), " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// forwardedFrom parses a forward header from a message. The HTML body of
+// Telegram forwards links the original sender, which gives the user ID.
+func forwardedFrom(content *event.MessageEventContent) *compat.ForwardedFrom {
+	if content.MsgType != event.MsgText && content.MsgType != event.MsgNotice {
+		return nil
+	}
+	forwarded, ok := parseForwardedMessage(content.Body)
+	if !ok {
+		return nil
+	}
+	if content.Format == event.FormatHTML && forwarded.Name != "" {
+		header, _, _ := strings.Cut(content.FormattedBody, "<blockquote")
+		if match := matrixToUserPattern.FindStringSubmatch(header); match != nil {
+			if userID, err := url.PathUnescape(match[1]); err == nil {
+				if _, _, err = id.UserID(userID).Parse(); err == nil {
+					forwarded.UserID = userID
+				}
+			}
+		}
+	}
+	return forwarded
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestParseForwardedMessageFormats(t *testing.T) {
+	cases := []struct {
+		body string
+		name string
+		text string
+	}{
+		{"Forwarded from Alice:\n> hello\n> there", "Alice", "hello\nthere"},
+		{"Forwarded message from Bob\nsee this", "Bob", "see this"},
+		{"---------- Forwarded message ---------\nFrom: Carol <carol@example.com>\nSubject: hi\n\nbody", "Carol <carol@example.com>", "Subject: hi\n\nbody"},
+		{"↷ Forwarded\nlook at this", "", "look at this"},
+	}
+	for _, tc := range cases {
+		forwarded, ok := parseForwardedMessage(tc.body)
+		if !ok || forwarded.Name != tc.name || forwarded.Text != tc.text {
+			t.Fatalf("unexpected parse of %q: %#v", tc.body, forwarded)
+		}
+	}
+	if _, ok := parseForwardedMessage("I forwarded from home yesterday"); ok {
+		t.Fatalf("expected ordinary text not to be treated as a forward")
+	}
+}
+
+func TestForwardedFromReadsUserIDFromHTML(t *testing.T) {
+	content := &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          "Forwarded from Alice:\n> hi",
+		Format:        event.FormatHTML,
+		FormattedBody: `Forwarded from <a href="https://matrix.to/#/%40telegram_1%3Aexample.com">Alice</a>:<br/><blockquote>hi <a href="https://matrix.to/#/@other:example.com">x</a></blockquote>`,
+	}
+	forwarded := forwardedFrom(content)
+	if forwarded == nil || forwarded.UserID != "@telegram_1:example.com" || forwarded.Text != "hi" {
+		t.Fatalf("unexpected forward: %#v", forwarded)
+	}
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+	"maunium.net/go/mautrix/id"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	identityIntrospectionTimeout  = 10 * time.Second
+	identityIntrospectionCacheTTL = time.Minute
+	externalIdentityMarker        = "external"
+)
+
+// subjectPolicy confines an externally authenticated subject. A chat is
+// visible when it is listed directly or belongs to a listed account. A nil
+// policy means the caller is the deployment owner and sees everything.
+type subjectPolicy struct {
+	AccountIDs []string `json:"accountIDs,omitempty"`
+	ChatIDs    []string `json:"chatIDs,omitempty"`
+
+	// also is a second policy that must allow access as well, used when
+	// both the subject and its OAuth client are restricted.
+	also *subjectPolicy
+}
+
+type subjectPoliciesFile struct {
+	Subjects map[string]*subjectPolicy `json:"subjects"`
+}
+
+func (p *subjectPolicy) allowsAccount(accountID string) bool {
+	if p == nil {
+		return true
+	}
+	return slices.Contains(p.AccountIDs, accountID) && p.also.allowsAccount(accountID)
+}
+
+func (p *subjectPolicy) allowsChat(chatID, accountID string) bool {
+	if p == nil {
+		return true
+	}
+	allowed := slices.Contains(p.ChatIDs, chatID) || (accountID != "" && slices.Contains(p.AccountIDs, accountID))
+	return allowed && p.also.allowsChat(chatID, accountID)
+}
+
+func loadSubjectPolicies(path string) (map[string]*subjectPolicy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subject policies: %w", err)
+	}
+	var parsed subjectPoliciesFile
+	if err = json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse subject policies: %w", err)
+	}
+	policies := make(map[string]*subjectPolicy, len(parsed.Subjects))
+	for subject, policy := range parsed.Subjects {
+		subject = strings.TrimSpace(subject)
+		if subject == "" || policy == nil {
+			continue
+		}
+		policies[subject] = policy
+	}
+	return policies, nil
+}
+
+type introspectionResult struct {
+	Active   bool   `json:"active"`
+	Subject  string `json:"sub"`
+	Scope    string `json:"scope"`
+	ClientID string `json:"client_id"`
+	Expiry   int64  `json:"exp"`
+}
+
+type cachedIntrospection struct {
+	result    introspectionResult
+	expiresAt time.Time
+}
+
+// identityProvider verifies bearer tokens issued by an external IdP.
+type identityProvider struct {
+	introspectionURL string
+	clientID         string
+	clientSecret     string
+	httpClient       *http.Client
+	policies         map[string]*subjectPolicy
+
+	mu    sync.Mutex
+	cache map[string]cachedIntrospection
+}
+
+func newIdentityProvider(introspectionURL, clientID, clientSecret, policiesFile string) (*identityProvider, error) {
+	policies, err := loadSubjectPolicies(policiesFile)
+	if err != nil {
+		return nil, err
+	}
+	return &identityProvider{
+		introspectionURL: introspectionURL,
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+		httpClient:       &http.Client{Timeout: identityIntrospectionTimeout},
+		policies:         policies,
+		cache:            make(map[string]cachedIntrospection),
+	}, nil
+}
+
+func (p *identityProvider) introspect(ctx context.Context, token string) (introspectionResult, error) {
+	sum := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(sum[:])
+	p.mu.Lock()
+	cached, ok := p.cache[cacheKey]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.result, nil
+	}
+
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.introspectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return introspectionResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientID != "" {
+		req.SetBasicAuth(p.clientID, p.clientSecret)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return introspectionResult{}, fmt.Errorf("introspection request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return introspectionResult{}, fmt.Errorf("introspection endpoint responded with status %d", resp.StatusCode)
+	}
+	var result introspectionResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return introspectionResult{}, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+
+	expiresAt := time.Now().Add(identityIntrospectionCacheTTL)
+	if result.Expiry > 0 && time.Unix(result.Expiry, 0).Before(expiresAt) {
+		expiresAt = time.Unix(result.Expiry, 0)
+	}
+	p.mu.Lock()
+	for key, entry := range p.cache {
+		if time.Now().After(entry.expiresAt) {
+			delete(p.cache, key)
+		}
+	}
+	p.cache[cacheKey] = cachedIntrospection{result: result, expiresAt: expiresAt}
+	p.mu.Unlock()
+	return result, nil
+}
+
+// tokenInfo turns an introspected token into the auth middleware's token
+// info. Inactive tokens and subjects without a policy are rejected outright.
+func (p *identityProvider) tokenInfo(token string) (*mcpauth.TokenInfo, bool) {
+	result, err := p.introspect(context.Background(), token)
+	if err != nil {
+		log.Printf("token introspection failed: %v", err)
+		return nil, false
+	}
+	subject := strings.TrimSpace(result.Subject)
+	if !result.Active || subject == "" || p.policies[subject] == nil {
+		return nil, false
+	}
+	info := &mcpauth.TokenInfo{
+		Scopes: normalizeOAuthScopes(result.Scope),
+		UserID: subject,
+		Extra: map[string]any{
+			"client_id": strings.TrimSpace(result.ClientID),
+			"identity":  externalIdentityMarker,
+		},
+	}
+	if result.Expiry > 0 {
+		info.Expiration = time.Unix(result.Expiry, 0)
+	}
+	return info, true
+}
+
+// requestPolicy returns the visibility policy of the caller, or nil when it
+// is unrestricted. External subjects are limited by their subject policy and
+// OAuth clients by any admin-managed client policy; both apply together.
+func (s *Server) requestPolicy(r *http.Request) *subjectPolicy {
+	subject := s.requestSubjectPolicy(r)
+	var client *subjectPolicy
+	if s.clientPolicies != nil {
+		if policy, ok, err := s.clientPolicies.get(requestClientID(r)); err != nil {
+			// Fail closed: an unreadable policy file must not widen access.
+			client = &subjectPolicy{}
+		} else if ok {
+			client = clientAccessRule(policy, r.Method)
+		}
+	}
+	switch {
+	case subject == nil:
+		return client
+	case client == nil:
+		return subject
+	default:
+		return &subjectPolicy{AccountIDs: subject.AccountIDs, ChatIDs: subject.ChatIDs, also: client}
+	}
+}
+
+func (s *Server) requestSubjectPolicy(r *http.Request) *subjectPolicy {
+	if s.identity == nil {
+		return nil
+	}
+	info := mcpauth.TokenInfoFromContext(r.Context())
+	if info == nil || info.Extra["identity"] != externalIdentityMarker {
+		return nil
+	}
+	if policy := s.identity.policies[info.UserID]; policy != nil {
+		return policy
+	}
+	return &subjectPolicy{}
+}
+
+// enforceSubjectPolicy guards routes addressed to a single chat or account.
+// Hidden chats are reported as missing so that their existence is not leaked.
+func (s *Server) enforceSubjectPolicy(r *http.Request) error {
+	policy := s.requestPolicy(r)
+	if policy == nil {
+		return nil
+	}
+	if strings.HasPrefix(r.URL.Path, "/v1/admin/") || (strings.HasPrefix(r.URL.Path, "/v1/preferences/") && r.Method != http.MethodGet) {
+		return errs.Forbidden("This endpoint is only available to the deployment owner")
+	}
+	if accountID := strings.TrimSpace(r.PathValue("accountID")); accountID != "" && !policy.allowsAccount(accountID) {
+		return errs.NotFound("Account not found")
+	}
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return nil
+	}
+	lookup, err := s.buildAccountLookup(r.Context())
+	if err != nil {
+		return err
+	}
+	accountID, _ := inferAccountForRoom(id.RoomID(chatID), lookup)
+	if !policy.allowsChat(chatID, accountID) {
+		return errs.NotFound("Chat not found")
+	}
+	return nil
+}
+
+// requestAllowsChat reports whether the caller may see chatID, for routes
+// that reach a chat through something other than its ID.
+func (s *Server) requestAllowsChat(r *http.Request, chatID string) bool {
+	policy := s.requestPolicy(r)
+	return policy == nil || policy.allowsChat(chatID, s.chatAccountID(r.Context(), chatID))
+}
+
+// chatAccountID returns the account a chat belongs to, or "" when it cannot
+// be told. An empty account only matches policies listing the chat itself.
+func (s *Server) chatAccountID(ctx context.Context, chatID string) string {
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return ""
+	}
+	accountID, _ := inferAccountForRoom(id.RoomID(chatID), lookup)
+	return accountID
+}
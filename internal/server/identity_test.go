@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubjectPolicyVisibility(t *testing.T) {
+	var owner *subjectPolicy
+	if !owner.allowsChat("!any:example.org", "") || !owner.allowsAccount("whatsapp") {
+		t.Fatal("expected nil policy to allow everything")
+	}
+	policy := &subjectPolicy{AccountIDs: []string{"whatsapp"}, ChatIDs: []string{"!shared:example.org"}}
+	if !policy.allowsChat("!shared:example.org", "telegram") {
+		t.Fatal("expected explicitly listed chat to be visible")
+	}
+	if !policy.allowsChat("!other:example.org", "whatsapp") {
+		t.Fatal("expected chat on allowed account to be visible")
+	}
+	if policy.allowsChat("!other:example.org", "telegram") || policy.allowsAccount("telegram") {
+		t.Fatal("expected unlisted chat and account to be hidden")
+	}
+}
+
+func TestIdentityProviderIntrospectsAndRequiresPolicy(t *testing.T) {
+	calls := 0
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if user, pass, ok := r.BasicAuth(); !ok || user != "easymatrix" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = r.ParseForm()
+		switch r.PostForm.Get("token") {
+		case "alice-token":
+			_, _ = w.Write([]byte(`{"active":true,"sub":"alice","scope":"read write","client_id":"team-app"}`))
+		case "mallory-token":
+			_, _ = w.Write([]byte(`{"active":true,"sub":"mallory","scope":"read"}`))
+		default:
+			_, _ = w.Write([]byte(`{"active":false}`))
+		}
+	}))
+	defer idp.Close()
+
+	policiesPath := filepath.Join(t.TempDir(), "policies.json")
+	if err := os.WriteFile(policiesPath, []byte(`{"subjects":{"alice":{"accountIDs":["whatsapp"]}}}`), 0o600); err != nil {
+		t.Fatalf("failed to write policies: %v", err)
+	}
+	provider, err := newIdentityProvider(idp.URL, "easymatrix", "secret", policiesPath)
+	if err != nil {
+		t.Fatalf("newIdentityProvider failed: %v", err)
+	}
+
+	info, ok := provider.tokenInfo("alice-token")
+	if !ok || info.UserID != "alice" || info.Extra["client_id"] != "team-app" || info.Extra["identity"] != externalIdentityMarker {
+		t.Fatalf("unexpected token info: %#v", info)
+	}
+	if len(info.Scopes) != 2 {
+		t.Fatalf("expected read and write scopes, got %v", info.Scopes)
+	}
+	if _, ok = provider.tokenInfo("alice-token"); !ok || calls != 1 {
+		t.Fatalf("expected cached introspection result, got %d calls", calls)
+	}
+	if _, ok = provider.tokenInfo("mallory-token"); ok {
+		t.Fatal("expected subject without policy to be rejected")
+	}
+	if _, ok = provider.tokenInfo("expired"); ok {
+		t.Fatal("expected inactive token to be rejected")
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	jpegMarkerSOS   = 0xDA
+	jpegMarkerAPP1  = 0xE1
+	jpegMarkerAPP13 = 0xED
+
+	exifOrientationTag = 0x0112
+	// rotatedJPEGQuality is used when a photo has to be re-encoded to apply
+	// its orientation; other JPEGs keep their original compressed data.
+	rotatedJPEGQuality = 95
+)
+
+// stripJPEGMetadata removes the APP1 (EXIF, XMP) and APP13 (IPTC) segments
+// of a JPEG and reports the EXIF orientation they carried, 1 when there was
+// none. ICC profiles and the image data are kept byte for byte. ok is false
+// when data is not a JPEG this parser understands.
+func stripJPEGMetadata(data []byte) (stripped []byte, orientation int, ok bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, false
+	}
+	orientation = 1
+	output := make([]byte, 0, len(data))
+	output = append(output, data[:2]...)
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, false
+		}
+		// Any number of 0xFF fill bytes may precede a marker.
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, 0, false
+		}
+		marker := data[i+1]
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			output = append(output, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if marker == jpegMarkerSOS {
+			// Everything from the scan on is image data.
+			return append(output, data[i:]...), orientation, true
+		}
+		if i+4 > len(data) {
+			return nil, 0, false
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) || end < i+4 {
+			return nil, 0, false
+		}
+		switch marker {
+		case jpegMarkerAPP1:
+			if value, found := exifOrientation(data[i+4 : end]); found {
+				orientation = value
+			}
+		case jpegMarkerAPP13:
+		default:
+			output = append(output, data[i:end]...)
+		}
+		i = end
+	}
+	return output, orientation, true
+}
+
+// exifOrientation reads the orientation tag from IFD0 of an APP1 payload.
+func exifOrientation(payload []byte) (int, bool) {
+	tiff, found := bytes.CutPrefix(payload, []byte("Exif\x00\x00"))
+	if !found || len(tiff) < 8 {
+		return 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0, false
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for entry := 0; entry < count; entry++ {
+		start := offset + 2 + entry*12
+		if start+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[start:]) != exifOrientationTag {
+			continue
+		}
+		value := int(order.Uint16(tiff[start+8:]))
+		if value < 1 || value > 8 {
+			return 0, false
+		}
+		return value, true
+	}
+	return 0, false
+}
+
+// applyOrientation turns an image stored with the given EXIF orientation
+// upright, since viewers stop rotating it once the tag is gone.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
+}
+
+// sanitizeJPEG returns data without its metadata, re-encoded upright when it
+// relied on the orientation tag. changed is false when there was nothing to
+// remove or data is not a readable JPEG.
+func sanitizeJPEG(data []byte) (output []byte, changed bool, err error) {
+	stripped, orientation, ok := stripJPEGMetadata(data)
+	if !ok {
+		return data, false, nil
+	}
+	if orientation == 1 {
+		return stripped, len(stripped) != len(data), nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode image: %w", err)
+	}
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: rotatedJPEGQuality}); err != nil {
+		return nil, false, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), true, nil
+}
+
+// sanitizeStagedImage strips the metadata of a staged JPEG before it is sent
+// so photos don't leak where they were taken. The staged file is rewritten
+// once and its metadata updated, which keeps the content hash seeded into
+// the asset cache in line with what the homeserver receives.
+func (s *Server) sanitizeStagedImage(meta *uploadMetadata, mimeType string) error {
+	if s.cfg.KeepImageMetadata || meta.Sanitized || !strings.EqualFold(mimeType, "image/jpeg") {
+		return nil
+	}
+	data, err := os.ReadFile(meta.FilePath)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to read uploaded asset: %w", err))
+	}
+	output, changed, err := sanitizeJPEG(data)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to strip image metadata: %w", err))
+	}
+	if changed {
+		tmp, err := os.CreateTemp(filepath.Dir(meta.FilePath), ".sanitized-*")
+		if err != nil {
+			return errs.Internal(fmt.Errorf("failed to create sanitized image: %w", err))
+		}
+		_, err = tmp.Write(output)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), meta.FilePath)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return errs.Internal(fmt.Errorf("failed to write sanitized image: %w", err))
+		}
+		sum := sha256.Sum256(output)
+		meta.ContentHash = hex.EncodeToString(sum[:])
+		meta.FileSize = int64(len(output))
+		if width, height := imageDimensions(meta.FilePath); width > 0 && height > 0 {
+			meta.Width = width
+			meta.Height = height
+		}
+	}
+	meta.Sanitized = true
+	if err = s.writeUploadMetadata(*meta); err != nil {
+		return errs.Internal(err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// jpegWithOrientation encodes a width x height JPEG with an EXIF segment
+// carrying the given orientation right after the SOI marker.
+func jpegWithOrientation(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0}
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], exifOrientationTag)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], uint16(orientation))
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, jpegMarkerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestStripJPEGMetadataKeepsImageData(t *testing.T) {
+	data := jpegWithOrientation(t, 4, 2, 1)
+	stripped, orientation, ok := stripJPEGMetadata(data)
+	if !ok || orientation != 1 {
+		t.Fatalf("unexpected parse result: ok=%v orientation=%d", ok, orientation)
+	}
+	if bytes.Contains(stripped, []byte("Exif")) {
+		t.Fatalf("expected EXIF segment to be removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("stripped JPEG no longer decodes: %v", err)
+	}
+}
+
+func TestSanitizeJPEGAppliesOrientation(t *testing.T) {
+	output, changed, err := sanitizeJPEG(jpegWithOrientation(t, 4, 2, 6))
+	if err != nil || !changed {
+		t.Fatalf("unexpected result: changed=%v err=%v", changed, err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(output))
+	if err != nil {
+		t.Fatalf("sanitized JPEG does not decode: %v", err)
+	}
+	if cfg.Width != 2 || cfg.Height != 4 {
+		t.Fatalf("expected rotated 2x4 image, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestSanitizeJPEGIgnoresOtherFiles(t *testing.T) {
+	data := []byte("\x89PNG\r\n\x1a\n")
+	output, changed, err := sanitizeJPEG(data)
+	if err != nil || changed || !bytes.Equal(output, data) {
+		t.Fatalf("expected non-JPEG data to pass through unchanged")
+	}
+}
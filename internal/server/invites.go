@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+type rejectInviteInput struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+func (s *Server) listInvites(w http.ResponseWriter, r *http.Request) error {
+	cli := s.rt.Client()
+	rooms, err := cli.DB.InvitedRoom.GetAll(r.Context())
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to read invites: %w", err))
+	}
+	lookup, err := s.buildAccountLookup(r.Context())
+	if err != nil {
+		return err
+	}
+	visibility := s.requestPolicy(r)
+	items := make([]compat.Invite, 0, len(rooms))
+	for _, room := range rooms {
+		invite := inviteFromStrippedState(r.Context(), room, cli.Account.UserID)
+		invite.AccountID, invite.Network = inferAccountForRoom(room.ID, lookup)
+		if !visibility.allowsChat(invite.ChatID, invite.AccountID) {
+			continue
+		}
+		items = append(items, invite)
+	}
+	return writeJSON(w, compat.ListInvitesOutput{Items: items})
+}
+
+func (s *Server) acceptInvite(w http.ResponseWriter, r *http.Request) error {
+	room, err := s.loadInvitedRoom(r.Context(), readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	if _, err = s.rt.Client().Client.JoinRoomByID(r.Context(), room.ID); err != nil {
+		return membershipActionError(err, "join this chat")
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+func (s *Server) rejectInvite(w http.ResponseWriter, r *http.Request) error {
+	var req rejectInviteInput
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	room, err := s.loadInvitedRoom(r.Context(), readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	_, err = cli.Client.LeaveRoom(r.Context(), room.ID, &mautrix.ReqLeave{Reason: strings.TrimSpace(req.Reason)})
+	// A vanished room or revoked invite still counts as rejected.
+	if err != nil && !errors.Is(err, mautrix.MNotFound) && !errors.Is(err, mautrix.MForbidden) {
+		return errs.Internal(fmt.Errorf("failed to reject invite: %w", err))
+	}
+	if err = cli.DB.InvitedRoom.Delete(r.Context(), room.ID); err != nil {
+		return errs.Internal(fmt.Errorf("failed to delete invite: %w", err))
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+func (s *Server) loadInvitedRoom(ctx context.Context, chatID string) (*database.InvitedRoom, error) {
+	if chatID == "" {
+		return nil, errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	rooms, err := s.rt.Client().DB.InvitedRoom.GetAll(ctx)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to read invites: %w", err))
+	}
+	for _, room := range rooms {
+		if string(room.ID) == chatID {
+			return room, nil
+		}
+	}
+	return nil, errs.NotFound("Invite not found")
+}
+
+// inviteFromStrippedState summarises an invite using the stripped state the
+// homeserver sends along with it: room name and avatar, plus our own member
+// event whose sender is the inviter.
+func inviteFromStrippedState(ctx context.Context, room *database.InvitedRoom, self id.UserID) compat.Invite {
+	invite := compat.Invite{
+		ChatID:    string(room.ID),
+		Type:      compat.ChatTypeGroup,
+		InvitedAt: room.CreatedAt.Time.UTC(),
+	}
+	members := make(map[id.UserID]event.MemberEventContent)
+	var inviter id.UserID
+	for _, evt := range room.InviteState {
+		if evt == nil || evt.StateKey == nil {
+			continue
+		}
+		switch evt.Type {
+		case event.StateRoomName:
+			var content event.RoomNameEventContent
+			if json.Unmarshal(evt.Content.VeryRaw, &content) == nil {
+				invite.Title = strings.TrimSpace(content.Name)
+			}
+		case event.StateRoomAvatar:
+			var content event.RoomAvatarEventContent
+			if json.Unmarshal(evt.Content.VeryRaw, &content) == nil {
+				invite.ImgURL = string(content.URL)
+			}
+		case event.StateMember:
+			var content event.MemberEventContent
+			if json.Unmarshal(evt.Content.VeryRaw, &content) != nil {
+				continue
+			}
+			userID := id.UserID(*evt.StateKey)
+			members[userID] = content
+			if userID == self && content.Membership == event.MembershipInvite {
+				inviter = evt.Sender
+				invite.Reason = content.Reason
+				if content.IsDirect {
+					invite.Type = compat.ChatTypeSingle
+				}
+			}
+		}
+	}
+	if inviter != "" {
+		user := userFromMemberEvent(ctx, string(inviter), members[inviter], string(self))
+		invite.Inviter = &user
+		if invite.Title == "" && invite.Type == compat.ChatTypeSingle {
+			invite.Title = user.FullName
+		}
+	}
+	if invite.Title == "" {
+		invite.Title = string(room.ID)
+	}
+	return invite
+}
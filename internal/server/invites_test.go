@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func strippedStateEvent(t *testing.T, evtType event.Type, stateKey string, sender id.UserID, content any) *event.Event {
+	t.Helper()
+	raw, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("failed to marshal content: %v", err)
+	}
+	return &event.Event{Type: evtType, StateKey: &stateKey, Sender: sender, Content: event.Content{VeryRaw: raw}}
+}
+
+func TestInviteFromStrippedStateDirectInvite(t *testing.T) {
+	self := id.UserID("@me:example.org")
+	inviter := id.UserID("@alice:example.org")
+	room := &database.InvitedRoom{
+		ID:        "!dm:example.org",
+		CreatedAt: jsontime.UMInt(1_700_000_000_000),
+		InviteState: []*event.Event{
+			strippedStateEvent(t, event.StateMember, string(inviter), inviter, map[string]any{"membership": "join", "displayname": "Alice"}),
+			strippedStateEvent(t, event.StateMember, string(self), inviter, map[string]any{"membership": "invite", "is_direct": true, "reason": "hi"}),
+		},
+	}
+	invite := inviteFromStrippedState(context.Background(), room, self)
+	if invite.Type != compat.ChatTypeSingle {
+		t.Fatalf("expected single chat, got %q", invite.Type)
+	}
+	if invite.Inviter == nil || invite.Inviter.ID != string(inviter) || invite.Inviter.FullName != "Alice" {
+		t.Fatalf("unexpected inviter: %#v", invite.Inviter)
+	}
+	if invite.Title != "Alice" || invite.Reason != "hi" {
+		t.Fatalf("unexpected title/reason: %q / %q", invite.Title, invite.Reason)
+	}
+	if invite.InvitedAt.UnixMilli() != 1_700_000_000_000 {
+		t.Fatalf("unexpected invitedAt: %v", invite.InvitedAt)
+	}
+}
+
+func TestInviteFromStrippedStateGroupFallsBackToRoomID(t *testing.T) {
+	room := &database.InvitedRoom{ID: "!group:example.org"}
+	invite := inviteFromStrippedState(context.Background(), room, "@me:example.org")
+	if invite.Type != compat.ChatTypeGroup || invite.Title != "!group:example.org" || invite.Inviter != nil {
+		t.Fatalf("unexpected invite: %#v", invite)
+	}
+}
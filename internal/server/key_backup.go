@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+type keyBackupRequest struct {
+	RecoveryKey string `json:"recoveryKey"`
+}
+
+type restoreKeyBackupRequest struct {
+	RecoveryKey string `json:"recoveryKey"`
+	ChatID      string `json:"chatID"`
+}
+
+func mapKeyBackupStatus(status gomuksruntime.KeyBackupStatus) compat.KeyBackupStatus {
+	output := compat.KeyBackupStatus{
+		Enabled:         status.Enabled,
+		Version:         string(status.Version),
+		ServerVersion:   string(status.ServerVersion),
+		Algorithm:       string(status.Algorithm),
+		BackedUpKeys:    status.Count,
+		ETag:            status.ETag,
+		Trusted:         status.Trusted,
+		PendingSessions: status.Pending,
+	}
+	if status.Restore != nil {
+		restore := mapKeyBackupRestore(*status.Restore)
+		output.Restore = &restore
+	}
+	return output
+}
+
+func mapKeyBackupRestore(restore gomuksruntime.KeyBackupRestore) compat.KeyBackupRestore {
+	output := compat.KeyBackupRestore{
+		ChatID:           string(restore.RoomID),
+		Stage:            restore.Stage,
+		Decrypted:        restore.Decrypted,
+		DecryptionFailed: restore.DecryptionFailed,
+		ImportFailed:     restore.ImportFailed,
+		Saved:            restore.Saved,
+		Total:            restore.Total,
+		Error:            restore.Error,
+		StartedAt:        restore.StartedAt,
+	}
+	if !restore.FinishedAt.IsZero() {
+		finishedAt := restore.FinishedAt
+		output.FinishedAt = &finishedAt
+	}
+	return output
+}
+
+// keyBackupError separates a wrong recovery key and backup state conflicts
+// from homeserver failures.
+func keyBackupError(err error) error {
+	switch {
+	case errors.Is(err, ssss.ErrInvalidRecoveryKey), errors.Is(err, ssss.ErrIncorrectSSSSKey), errors.Is(err, ssss.ErrKeyDataMACMismatch):
+		return errs.Validation(map[string]any{"recoveryKey": "recovery key is incorrect"})
+	case errors.Is(err, ssss.ErrNoDefaultKeyID):
+		return errs.New(http.StatusConflict, "KEY_BACKUP_CONFLICT", "Secret storage is not set up for this account", nil)
+	case errors.Is(err, gomuksruntime.ErrSessionNotVerified),
+		errors.Is(err, gomuksruntime.ErrNoKeyBackup),
+		errors.Is(err, gomuksruntime.ErrKeyBackupKeyMismatch),
+		errors.Is(err, gomuksruntime.ErrKeyBackupKeyUnavailable),
+		errors.Is(err, gomuksruntime.ErrKeyBackupRestoreRunning):
+		return errs.New(http.StatusConflict, "KEY_BACKUP_CONFLICT", err.Error(), nil)
+	default:
+		return errs.Internal(err)
+	}
+}
+
+// Key backup state covers every chat of the session and the recovery key
+// unlocks all of its secrets, so confined callers can't use these endpoints.
+func (s *Server) requireKeyBackupAccess(r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Key backup is only available to the deployment owner")
+	}
+	return s.requireLoggedInSession()
+}
+
+func (s *Server) getKeyBackup(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireKeyBackupAccess(r); err != nil {
+		return err
+	}
+	status, err := s.rt.KeyBackupStatus(r.Context())
+	if err != nil {
+		return keyBackupError(err)
+	}
+	return writeJSON(w, mapKeyBackupStatus(status))
+}
+
+// createKeyBackup replaces the server-side backup with a new version. The
+// recovery key is required because the new backup key is stored in secret
+// storage, where other sessions find it.
+func (s *Server) createKeyBackup(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireKeyBackupAccess(r); err != nil {
+		return err
+	}
+	var req keyBackupRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	req.RecoveryKey = strings.TrimSpace(req.RecoveryKey)
+	if req.RecoveryKey == "" {
+		return errs.Validation(map[string]any{"recoveryKey": "recoveryKey is required"})
+	}
+	status, err := s.rt.CreateKeyBackup(r.Context(), req.RecoveryKey)
+	if err != nil {
+		return keyBackupError(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+	return writeJSON(w, mapKeyBackupStatus(status))
+}
+
+func (s *Server) enableKeyBackup(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireKeyBackupAccess(r); err != nil {
+		return err
+	}
+	var req keyBackupRequest
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	status, err := s.rt.EnableKeyBackup(r.Context(), strings.TrimSpace(req.RecoveryKey))
+	if err != nil {
+		return keyBackupError(err)
+	}
+	return writeJSON(w, mapKeyBackupStatus(status))
+}
+
+// restoreKeyBackup starts importing keys from the backup and returns right
+// away; progress is reported by GET /v1/encryption/backup.
+func (s *Server) restoreKeyBackup(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireKeyBackupAccess(r); err != nil {
+		return err
+	}
+	var req restoreKeyBackupRequest
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	roomID := id.RoomID(strings.TrimSpace(req.ChatID))
+	if roomID != "" && !strings.HasPrefix(string(roomID), "!") {
+		return errs.Validation(map[string]any{"chatID": "must be a Matrix room ID"})
+	}
+	restore, err := s.rt.StartKeyBackupRestore(r.Context(), strings.TrimSpace(req.RecoveryKey), roomID)
+	if err != nil {
+		return keyBackupError(err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return writeJSON(w, mapKeyBackupRestore(restore))
+}
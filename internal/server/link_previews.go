@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+const (
+	linkPreviewCacheTTL         = 24 * time.Hour
+	linkPreviewNegativeCacheTTL = time.Hour
+	linkPreviewFetchTimeout     = 5 * time.Second
+	linkPreviewQueueSize        = 256
+)
+
+var linkURLPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+type linkPreviewCacheEntry struct {
+	FetchedAt int64               `json:"fetchedAt"`
+	Preview   *compat.LinkPreview `json:"preview,omitempty"`
+}
+
+func firstLinkURL(text string) string {
+	match := linkURLPattern.FindString(text)
+	return strings.TrimRight(match, ".,;:!?)]}")
+}
+
+func linkPreviewFromEvent(preview *event.LinkPreview, fallbackURL string) *compat.LinkPreview {
+	if preview == nil || (preview.Title == "" && preview.Description == "" && preview.ImageURL == "") {
+		return nil
+	}
+	output := &compat.LinkPreview{
+		URL:         preview.CanonicalURL,
+		Title:       preview.Title,
+		Description: preview.Description,
+		SiteName:    preview.SiteName,
+		Image:       string(preview.ImageURL),
+		ImageWidth:  int(preview.ImageWidth),
+		ImageHeight: int(preview.ImageHeight),
+	}
+	if output.URL == "" {
+		output.URL = fallbackURL
+	}
+	return output
+}
+
+// cachedLinkPreview resolves a preview without touching the network: bridge
+// supplied previews win, otherwise a previously cached homeserver preview is
+// used. A missing preview is queued for the background fetcher, except in
+// encrypted rooms unless EASYMATRIX_LINK_PREVIEWS_ENCRYPTED opts in, since
+// the homeserver would learn the URL.
+func (s *Server) cachedLinkPreview(content *event.MessageEventContent, text string, evt *database.Event, room *database.Room) *compat.LinkPreview {
+	for _, bridged := range content.BeeperLinkPreviews {
+		if bridged == nil {
+			continue
+		}
+		if preview := linkPreviewFromEvent(&bridged.LinkPreview, bridged.MatchedURL); preview != nil {
+			return preview
+		}
+	}
+	url := firstLinkURL(text)
+	if url == "" {
+		return nil
+	}
+	entry, ok := s.readLinkPreviewCache(url)
+	if ok {
+		return entry.Preview
+	}
+	if room.EncryptionEvent == nil || s.cfg.LinkPreviewsInEncryptedRooms {
+		s.linkPreviews.enqueue(url, string(evt.RoomID), string(evt.ID))
+	}
+	return nil
+}
+
+// linkPreviewFetcher fetches homeserver previews off the request path. Each
+// URL is fetched once however many messages share it, and those messages
+// are re-published when the preview lands.
+type linkPreviewFetcher struct {
+	queue chan string
+
+	mu      sync.Mutex
+	waiting map[string][]linkPreviewTarget
+}
+
+type linkPreviewTarget struct {
+	chatID    string
+	messageID string
+}
+
+func newLinkPreviewFetcher() *linkPreviewFetcher {
+	return &linkPreviewFetcher{
+		queue:   make(chan string, linkPreviewQueueSize),
+		waiting: make(map[string][]linkPreviewTarget),
+	}
+}
+
+// enqueue drops the request when the queue is full; the next read of the
+// message queues it again.
+func (f *linkPreviewFetcher) enqueue(url, chatID, messageID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := linkPreviewTarget{chatID: chatID, messageID: messageID}
+	if targets, ok := f.waiting[url]; ok {
+		if !slices.Contains(targets, target) {
+			f.waiting[url] = append(targets, target)
+		}
+		return
+	}
+	select {
+	case f.queue <- url:
+		f.waiting[url] = []linkPreviewTarget{target}
+	default:
+	}
+}
+
+func (f *linkPreviewFetcher) take(url string) []linkPreviewTarget {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	targets := f.waiting[url]
+	delete(f.waiting, url)
+	return targets
+}
+
+func (s *Server) runLinkPreviewFetcher(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case url := <-s.linkPreviews.queue:
+			s.fetchLinkPreview(ctx, url)
+		}
+	}
+}
+
+// fetchLinkPreview asks the homeserver's preview_url endpoint and caches the
+// result, including failures, so a broken site is not retried on every read.
+func (s *Server) fetchLinkPreview(ctx context.Context, url string) {
+	targets := s.linkPreviews.take(url)
+	cli := s.rt.Client()
+	if cli == nil || cli.Client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, linkPreviewFetchTimeout)
+	defer cancel()
+	resp, err := cli.Client.GetURLPreview(ctx, url)
+	if err != nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)) {
+		return
+	}
+	var preview *compat.LinkPreview
+	if err == nil {
+		preview = linkPreviewFromEvent(resp, url)
+	}
+	s.writeLinkPreviewCache(url, preview)
+	if preview == nil {
+		return
+	}
+	for _, target := range targets {
+		s.ws.publish(wsDomainEvent{Type: wsDomainTypeMessageUpserted, ChatID: target.chatID, IDs: []string{target.messageID}})
+	}
+}
+
+func (s *Server) linkPreviewCacheDir() string {
+	return filepath.Join(s.rt.StateDir(), "link-previews")
+}
+
+func (s *Server) linkPreviewCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(s.linkPreviewCacheDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *Server) readLinkPreviewCache(url string) (linkPreviewCacheEntry, bool) {
+	raw, err := os.ReadFile(s.linkPreviewCachePath(url))
+	if err != nil {
+		return linkPreviewCacheEntry{}, false
+	}
+	var entry linkPreviewCacheEntry
+	if err = json.Unmarshal(raw, &entry); err != nil {
+		return linkPreviewCacheEntry{}, false
+	}
+	ttl := linkPreviewCacheTTL
+	if entry.Preview == nil {
+		ttl = linkPreviewNegativeCacheTTL
+	}
+	if time.Since(time.UnixMilli(entry.FetchedAt)) > ttl {
+		return linkPreviewCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (s *Server) writeLinkPreviewCache(url string, preview *compat.LinkPreview) {
+	raw, err := json.Marshal(linkPreviewCacheEntry{FetchedAt: time.Now().UnixMilli(), Preview: preview})
+	if err != nil {
+		return
+	}
+	_ = writeAtomicFile(s.linkPreviewCachePath(url), raw, 0o600)
+}
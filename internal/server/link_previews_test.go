@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/config"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func TestFirstLinkURLTrimsTrailingPunctuation(t *testing.T) {
+	cases := map[string]string{
+		"see https://example.com/a?b=1.":       "https://example.com/a?b=1",
+		"(http://example.org/path)":            "http://example.org/path",
+		"no links here":                        "",
+		"two https://a.example https://b.test": "https://a.example",
+	}
+	for input, expected := range cases {
+		if got := firstLinkURL(input); got != expected {
+			t.Fatalf("firstLinkURL(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestLinkPreviewFromEventSkipsEmptyPreviews(t *testing.T) {
+	if preview := linkPreviewFromEvent(&event.LinkPreview{Type: "website"}, "https://example.com"); preview != nil {
+		t.Fatalf("expected empty preview to be dropped, got %#v", preview)
+	}
+	preview := linkPreviewFromEvent(&event.LinkPreview{Title: "Example"}, "https://example.com")
+	if preview == nil || preview.URL != "https://example.com" || preview.Title != "Example" {
+		t.Fatalf("unexpected preview: %#v", preview)
+	}
+}
+
+func TestCachedLinkPreviewQueuesFetchOutsideEncryptedRooms(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), MatrixHomeserverURL: "https://matrix.beeper.com"}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	s := New(cfg, rt)
+	content := &event.MessageEventContent{Body: "see https://example.com/a"}
+	evt := &database.Event{ID: "$msg", RoomID: "!room:example.org"}
+	encrypted := &database.Room{ID: id.RoomID("!room:example.org"), EncryptionEvent: &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}}
+
+	if preview := s.cachedLinkPreview(content, content.Body, evt, encrypted); preview != nil || len(s.linkPreviews.queue) != 0 {
+		t.Fatalf("expected encrypted room URL to stay local, preview=%#v queued=%d", preview, len(s.linkPreviews.queue))
+	}
+	s.cfg.LinkPreviewsInEncryptedRooms = true
+	s.cachedLinkPreview(content, content.Body, evt, encrypted)
+	s.cachedLinkPreview(content, content.Body, evt, &database.Room{ID: evt.RoomID})
+	if len(s.linkPreviews.queue) != 1 || len(s.linkPreviews.waiting["https://example.com/a"]) != 1 {
+		t.Fatalf("expected one deduplicated fetch, queued=%d waiting=%v", len(s.linkPreviews.queue), s.linkPreviews.waiting)
+	}
+
+	s.writeLinkPreviewCache("https://example.com/a", &compat.LinkPreview{URL: "https://example.com/a", Title: "Example"})
+	if preview := s.cachedLinkPreview(content, content.Body, evt, encrypted); preview == nil || preview.Title != "Example" {
+		t.Fatalf("expected cached preview, got %#v", preview)
+	}
+}
@@ -21,6 +21,9 @@ const beeperPrivateAPIAuthHeader = "Bearer BEEPER-PRIVATE-API-PLEASE-DONT-USE"
 type manageStateOutput struct {
 	ClientState    *jsoncmd.ClientState `json:"client_state"`
 	HomeserverHost string               `json:"homeserver_host,omitempty"`
+	// RestartRequired is set after a state wipe: the server still holds its
+	// local stores in memory until the process restarts.
+	RestartRequired bool `json:"restart_required,omitempty"`
 }
 
 func (s *Server) manageUI(w http.ResponseWriter, r *http.Request) error {
@@ -240,6 +243,29 @@ func (s *Server) manageVerify(w http.ResponseWriter, r *http.Request) error {
 	return writeJSON(w, state)
 }
 
+// manageLogout ends the Matrix session and deletes its database and crypto
+// keys. wipeState also clears the rest of the state dir (local stores,
+// OAuth clients, uploads, scripts) so the instance can be provisioned anew.
+func (s *Server) manageLogout(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		WipeState bool `json:"wipeState"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	if err := s.rt.Logout(r.Context(), req.WipeState); err != nil {
+		return errs.Internal(err)
+	}
+	s.roomBridges.invalidate()
+	s.contactCache.reset()
+	state, err := s.getManageState()
+	if err != nil {
+		return err
+	}
+	state.RestartRequired = req.WipeState
+	return writeJSON(w, state)
+}
+
 func (s *Server) manageIssueAccessToken(w http.ResponseWriter, r *http.Request) error {
 	if err := s.requireLoggedInSession(); err != nil {
 		return err
@@ -267,7 +293,7 @@ func (s *Server) manageBeeperStartLogin(w http.ResponseWriter, r *http.Request)
 	if err := decodeJSON(r, &req); err != nil {
 		return err
 	}
-	data, status, err := beeperAPIPost(r.Context(), req.Domain, "/user/login", map[string]any{})
+	data, status, err := s.beeperAPIPost(r.Context(), req.Domain, "/user/login", map[string]any{})
 	if err != nil {
 		return err
 	}
@@ -294,7 +320,7 @@ func (s *Server) manageBeeperRequestCode(w http.ResponseWriter, r *http.Request)
 	if req.Email == "" {
 		return errs.Validation(map[string]any{"email": "email is required"})
 	}
-	data, status, err := beeperAPIPost(r.Context(), req.Domain, "/user/login/email", map[string]any{
+	data, status, err := s.beeperAPIPost(r.Context(), req.Domain, "/user/login/email", map[string]any{
 		"request": req.Request,
 		"email":   req.Email,
 	})
@@ -324,7 +350,7 @@ func (s *Server) manageBeeperSubmitCode(w http.ResponseWriter, r *http.Request)
 	if req.Response == "" {
 		return errs.Validation(map[string]any{"response": "response is required"})
 	}
-	data, status, err := beeperAPIPost(r.Context(), req.Domain, "/user/login/response", map[string]any{
+	data, status, err := s.beeperAPIPost(r.Context(), req.Domain, "/user/login/response", map[string]any{
 		"request":  req.Request,
 		"response": strings.ReplaceAll(req.Response, " ", ""),
 	})
@@ -337,7 +363,7 @@ func (s *Server) manageBeeperSubmitCode(w http.ResponseWriter, r *http.Request)
 	return writeJSON(w, dataOrFallback(data, map[string]any{}))
 }
 
-func beeperAPIPost(ctx context.Context, rawDomain, endpoint string, payload any) (map[string]any, int, error) {
+func (s *Server) beeperAPIPost(ctx context.Context, rawDomain, endpoint string, payload any) (map[string]any, int, error) {
 	domain, err := normalizeBeeperDomain(rawDomain)
 	if err != nil {
 		return nil, 0, errs.Validation(map[string]any{"domain": err.Error()})
@@ -356,7 +382,7 @@ func beeperAPIPost(ctx context.Context, rawDomain, endpoint string, payload any)
 	req.Header.Set("Authorization", beeperPrivateAPIAuthHeader)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.rt.HTTPClient().Do(req)
 	if err != nil {
 		return nil, 0, errs.Internal(fmt.Errorf("beeper API request failed: %w", err))
 	}
@@ -667,7 +693,20 @@ const manageHTML = `<!doctype html>
           <button id="verify-submit">Verify</button>
         </div>
       </div>
-      <div class="muted">Emoji / SAS confirmation is not exposed yet through gomuks JSON commands, so verification here is recovery-key based.</div>
+      <div class="inline" style="margin-bottom: 10px;">
+        <button id="verify-device" class="secondary" style="width: auto;">Verify With Another Device</button>
+      </div>
+      <div id="verifications"></div>
+      <div class="muted">Without a recovery key, request verification and compare the emojis with one of your other logged-in devices.</div>
+    </div>
+
+    <div class="card">
+      <h2>Log Out</h2>
+      <div class="inline" style="margin-bottom: 10px;">
+        <label style="width: auto;"><input id="logout-wipe" type="checkbox" style="width: auto;"> Also wipe local state (restart required)</label>
+        <button id="logout-submit" class="secondary" style="width: auto;">Log Out</button>
+      </div>
+      <div class="muted">Logging out deletes this device's database and encryption keys. Unbacked-up message keys are lost.</div>
     </div>
 
     <div class="card">
@@ -802,7 +841,9 @@ const manageHTML = `<!doctype html>
         init.headers["Content-Type"] = "application/json";
         init.body = JSON.stringify(payload);
       }
-      const resp = await fetch(path, init);
+      // Sessions other than the primary one are served under /sessions/{name}.
+      const base = window.location.pathname.replace(/\/manage\/?$/, "");
+      const resp = await fetch(base + path, init);
       let data = null;
       try {
         data = await resp.json();
@@ -832,9 +873,68 @@ const manageHTML = `<!doctype html>
       document.getElementById("api-token-hint").textContent = isLoggedIn
         ? "Generate a local bearer token for Authorization: Bearer ..."
         : "Log in first, then mint a token for API calls.";
+      document.getElementById("verify-device").disabled = !isLoggedIn;
+      if (isLoggedIn) {
+        const verifications = await api("/manage/verifications");
+        renderVerifications(verifications.items || []);
+      } else {
+        renderVerifications([]);
+      }
       return data;
     }
 
+    function renderVerifications(items) {
+      const wrap = document.getElementById("verifications");
+      wrap.textContent = "";
+      items.forEach(function (item) {
+        const row = document.createElement("div");
+        row.style.marginBottom = "10px";
+        const summary = document.createElement("div");
+        let text = (item.incoming ? "From " : "To ") + item.userID + (item.deviceID ? " (" + item.deviceID + ")" : "") + ": " + item.state;
+        if (item.cancelReason) {
+          text += " - " + item.cancelReason;
+        }
+        if (item.error) {
+          text += " - " + item.error;
+        }
+        summary.textContent = text;
+        row.appendChild(summary);
+        if (item.state === "sas" && item.emojis) {
+          const emojis = document.createElement("div");
+          emojis.style.fontSize = "1.4em";
+          emojis.textContent = item.emojis.map(function (e) { return e.emoji + " " + e.description; }).join("  ");
+          row.appendChild(emojis);
+        }
+        const actions = [];
+        if (item.incoming && item.state === "requested") {
+          actions.push(["Accept", "accept"]);
+        }
+        if (item.state === "sas") {
+          actions.push(["They Match", "confirm"]);
+        }
+        if (item.state !== "done" && item.state !== "cancelled") {
+          actions.push(["Cancel", "cancel"]);
+        }
+        const buttons = document.createElement("div");
+        buttons.className = "inline";
+        actions.forEach(function (action) {
+          const button = document.createElement("button");
+          button.className = action[1] === "cancel" ? "secondary" : "";
+          button.style.width = "auto";
+          button.textContent = action[0];
+          button.addEventListener("click", function () {
+            run(async function () {
+              await api("/manage/verifications/" + encodeURIComponent(item.transactionID) + "/" + action[1], {});
+              await refreshState();
+            });
+          });
+          buttons.appendChild(button);
+        });
+        row.appendChild(buttons);
+        wrap.appendChild(row);
+      });
+    }
+
     async function run(action) {
       try {
         setStatus("Working...", false);
@@ -999,6 +1099,24 @@ const manageHTML = `<!doctype html>
       });
     });
 
+    document.getElementById("verify-device").addEventListener("click", function () {
+      run(async function () {
+        await api("/manage/verifications", {});
+        await refreshState();
+      });
+    });
+
+    document.getElementById("logout-submit").addEventListener("click", function () {
+      const wipeState = document.getElementById("logout-wipe").checked;
+      if (!confirm(wipeState ? "Log out and wipe all local state?" : "Log out and delete this device's keys?")) {
+        return;
+      }
+      run(async function () {
+        await api("/manage/logout", { wipeState: wipeState });
+        await refreshState();
+      });
+    });
+
     document.getElementById("discover-run").addEventListener("click", function () {
       run(async function () {
         const userID = document.getElementById("discover-user").value.trim();
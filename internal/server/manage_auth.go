@@ -4,9 +4,10 @@ import (
 	"crypto/subtle"
 	"net/http"
 	"strings"
-)
 
-import errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/auth"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
 
 const (
 	manageSecretCookieName = "easymatrix_manage_access"
@@ -35,13 +36,31 @@ func (s *Server) authorizeManageRequest(w http.ResponseWriter, r *http.Request)
 		query := redirectURL.Query()
 		query.Del(manageSecretQueryName)
 		redirectURL.RawQuery = query.Encode()
-		http.Redirect(w, r, redirectURL.RequestURI(), http.StatusSeeOther)
+		http.Redirect(w, r, auth.BasePath(r)+redirectURL.RequestURI(), http.StatusSeeOther)
 		return true, nil
 	}
 
 	return false, nil
 }
 
+// requireManageSecret guards /v1/admin routes. They mint credentials,
+// rewrite access rules or expose the whole account, so a bearer token alone
+// is not enough: the caller must also send the manage secret in its header.
+// Without a configured secret these routes stay disabled.
+func (s *Server) requireManageSecret(handler apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		expectedSecret := strings.TrimSpace(s.cfg.ManageSecret)
+		if expectedSecret == "" {
+			return errs.Forbidden("Admin routes are disabled until EASYMATRIX_MANAGE_SECRET is set")
+		}
+		secret := strings.TrimSpace(r.Header.Get(manageSecretHeaderName))
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(expectedSecret)) != 1 {
+			return errs.Forbidden("Admin routes require the manage secret in the " + manageSecretHeaderName + " header")
+		}
+		return handler(w, r)
+	}
+}
+
 func readManageSecret(r *http.Request) (string, string) {
 	if secret := strings.TrimSpace(r.Header.Get(manageSecretHeaderName)); secret != "" {
 		return secret, "header"
@@ -61,7 +80,7 @@ func setManageSecretCookie(w http.ResponseWriter, r *http.Request, secret string
 	http.SetCookie(w, &http.Cookie{
 		Name:     manageSecretCookieName,
 		Value:    secret,
-		Path:     "/manage",
+		Path:     auth.BasePath(r) + "/manage",
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 		Secure:   requestUsesHTTPS(r),
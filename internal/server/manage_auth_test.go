@@ -86,3 +86,32 @@ func TestManageAPIRejectsMissingSecret(t *testing.T) {
 		t.Fatalf("/manage/login-flows returned %d, want %d", rec.Code, http.StatusUnauthorized)
 	}
 }
+
+func TestRequireManageSecretGuardsAdminRoutes(t *testing.T) {
+	called := false
+	handler := func(http.ResponseWriter, *http.Request) error {
+		called = true
+		return nil
+	}
+	for _, tc := range []struct {
+		configured string
+		sent       string
+		allowed    bool
+	}{
+		{configured: "", sent: "", allowed: false},
+		{configured: "open-sesame", sent: "", allowed: false},
+		{configured: "open-sesame", sent: "wrong", allowed: false},
+		{configured: "open-sesame", sent: "open-sesame", allowed: true},
+	} {
+		called = false
+		s := &Server{cfg: config.Config{ManageSecret: tc.configured}}
+		req := httptest.NewRequest(http.MethodPost, "/v1/admin/oauth/clients", nil)
+		if tc.sent != "" {
+			req.Header.Set(manageSecretHeaderName, tc.sent)
+		}
+		err := s.requireManageSecret(handler)(httptest.NewRecorder(), req)
+		if (err == nil) != tc.allowed || called != tc.allowed {
+			t.Fatalf("configured %q sent %q: err=%v called=%v, want allowed=%v", tc.configured, tc.sent, err, called, tc.allowed)
+		}
+	}
+}
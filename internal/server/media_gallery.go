@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	mediaGalleryDefaultLimit = 50
+	mediaGalleryMaxLimit     = 200
+)
+
+var mediaGalleryTypes = []string{"image", "video", "file", "link"}
+
+// mediaGalleryFilters select gallery entries directly in SQL so sparse media
+// doesn't require walking the timeline. Edits are excluded; the original
+// event is returned with its latest edit applied.
+var mediaGalleryFilters = map[string]string{
+	"image": `COALESCE(event.decrypted_type, event.type) = 'm.sticker' OR COALESCE(event.decrypted, event.content)->>'msgtype' = 'm.image'`,
+	"video": `COALESCE(event.decrypted, event.content)->>'msgtype' = 'm.video'`,
+	"file":  `COALESCE(event.decrypted, event.content)->>'msgtype' IN ('m.file', 'm.audio')`,
+	"link": `COALESCE(event.decrypted, event.content)->>'msgtype' IN ('m.text', 'm.notice', 'm.emote')
+		AND (COALESCE(event.decrypted, event.content)->>'body' LIKE '%http://%' OR COALESCE(event.decrypted, event.content)->>'body' LIKE '%https://%')`,
+}
+
+func mediaGalleryQuery(types []string) string {
+	clauses := make([]string, 0, len(types))
+	for _, mediaType := range types {
+		clauses = append(clauses, "("+mediaGalleryFilters[mediaType]+")")
+	}
+	return timelineSelectBase + ` AND (? = 0 OR timeline.rowid < ?)
+		AND event.redacted_by IS NULL AND COALESCE(event.relation_type, '') <> 'm.replace'
+		AND (` + strings.Join(clauses, " OR ") + `)
+		ORDER BY timeline.rowid DESC LIMIT ?`
+}
+
+// listChatMedia pages backwards through a chat's attachments, or links with
+// type=link. Without a type filter images, videos and files are returned.
+func (s *Server) listChatMedia(w http.ResponseWriter, r *http.Request) error {
+	types, err := parseEnumList(r, "type", mediaGalleryTypes)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		types = []string{"image", "video", "file"}
+	}
+	cursorValue, err := parseMessageCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		return err
+	}
+	limit, err := parseOptionalLimit(r.URL.Query().Get("limit"), mediaGalleryDefaultLimit, 1, mediaGalleryMaxLimit, "limit")
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	events, err := s.loadMediaEvents(ctx, room.ID, types, cursorValue, limit+1)
+	if err != nil {
+		return err
+	}
+	hasMore := len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	if err = s.populateLastEditRefs(ctx, events); err != nil {
+		return err
+	}
+	reactions, err := s.loadReactionMap(ctx, room.ID, events)
+	if err != nil {
+		return err
+	}
+	bundle := reactionBundle{Names: s.loadMemberNameMap(ctx, room.ID), Reactions: reactions}
+	messages := make([]compat.Message, 0, len(events))
+	for _, evt := range events {
+		message, mapErr := s.mapEventToMessage(ctx, evt, room, lookup, bundle)
+		if mapErr != nil {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return writeJSON(w, compat.ListMessagesOutput{Items: messages, HasMore: hasMore})
+}
+
+func (s *Server) loadMediaEvents(ctx context.Context, roomID id.RoomID, types []string, cursorValue int64, limit int) ([]*database.Event, error) {
+	rows, err := s.rt.Client().DB.Query(ctx, mediaGalleryQuery(types), roomID, cursorValue, cursorValue, limit)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query chat media: %w", err))
+	}
+	defer rows.Close()
+	events := make([]*database.Event, 0, limit)
+	for rows.Next() {
+		evt := &database.Event{}
+		if _, scanErr := evt.Scan(rows); scanErr != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan media event: %w", scanErr))
+		}
+		events = append(events, evt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("chat media query failed: %w", err))
+	}
+	return events, nil
+}
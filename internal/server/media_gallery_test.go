@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestListChatMediaFiltersByTypeAndPages(t *testing.T) {
+	s := newDBTestServer(t)
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+	image := func(eventID id.EventID) {
+		content, _ := json.Marshal(event.MessageEventContent{MsgType: event.MsgImage, Body: "photo.png", URL: "mxc://example.org/photo"})
+		evt := testTextEvent(roomID, eventID, "@alice:example.org", "")
+		evt.Content = content
+		insertTestEvent(t, s, evt)
+	}
+	image("$img1")
+	insertTestEvent(t, s, testTextEvent(roomID, "$plain", "@alice:example.org", "no links here"))
+	image("$img2")
+	insertTestEvent(t, s, testTextEvent(roomID, "$link", "@alice:example.org", "see https://example.org"))
+
+	list := func(query url.Values) compat.ListMessagesOutput {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/v1/chats/"+string(roomID)+"/media?"+query.Encode(), nil)
+		req.SetPathValue("chatID", string(roomID))
+		rec := httptest.NewRecorder()
+		if err := s.listChatMedia(rec, req); err != nil {
+			t.Fatalf("listChatMedia(%s) returned error: %v", query.Encode(), err)
+		}
+		var out compat.ListMessagesOutput
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("failed to decode media page: %v", err)
+		}
+		return out
+	}
+	ids := func(out compat.ListMessagesOutput) []string {
+		items := make([]string, 0, len(out.Items))
+		for _, item := range out.Items {
+			items = append(items, item.ID)
+		}
+		return items
+	}
+
+	first := list(url.Values{"type": {"image"}, "limit": {"1"}})
+	if got := ids(first); len(got) != 1 || got[0] != "$img2" || !first.HasMore {
+		t.Fatalf("expected the newest image with more to come, got %v (hasMore=%v)", got, first.HasMore)
+	}
+	second := list(url.Values{"type": {"image"}, "limit": {"1"}, "cursor": {first.Items[0].SortKey}})
+	if got := ids(second); len(got) != 1 || got[0] != "$img1" || second.HasMore {
+		t.Fatalf("expected the older image on the last page, got %v (hasMore=%v)", got, second.HasMore)
+	}
+	if got := ids(list(url.Values{"type": {"link"}})); len(got) != 1 || got[0] != "$link" {
+		t.Fatalf("expected only the message with a link, got %v", got)
+	}
+}
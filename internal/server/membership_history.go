@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/cursor"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	membershipHistoryDefaultLimit = 50
+	membershipHistoryMaxLimit     = 200
+)
+
+// membershipHistoryQuery pages member events newest first. Profile-only
+// updates (join -> join) are excluded so they don't crowd out real changes.
+const membershipHistoryQuery = `
+	SELECT rowid, event_id, sender, state_key, timestamp, content, unsigned
+	FROM event
+	WHERE room_id = $1 AND type = 'm.room.member' AND state_key IS NOT NULL
+	  AND ($2 = 0 OR timestamp < $2 OR (timestamp = $2 AND rowid < $3))
+	  AND NOT (content->>'membership' = 'join' AND COALESCE(unsigned->>'$.prev_content.membership', '') = 'join')
+	ORDER BY timestamp DESC, rowid DESC
+	LIMIT $4
+`
+
+type membershipHistoryCursor struct {
+	Timestamp int64 `json:"ts"`
+	RowID     int64 `json:"rowid"`
+}
+
+type memberEventRow struct {
+	RowID     int64
+	EventID   string
+	Sender    string
+	StateKey  string
+	Timestamp int64
+	Content   event.MemberEventContent
+	Prev      *event.MemberEventContent
+}
+
+// membershipChangeAction names the transition from prev to the new
+// membership, telling self-initiated changes apart from moderator actions.
+func membershipChangeAction(row memberEventRow) compat.MembershipChangeAction {
+	prev := event.MembershipLeave
+	if row.Prev != nil && row.Prev.Membership != "" {
+		prev = row.Prev.Membership
+	}
+	self := row.Sender == row.StateKey
+	switch row.Content.Membership {
+	case event.MembershipJoin:
+		return compat.MembershipChangeJoined
+	case event.MembershipInvite:
+		return compat.MembershipChangeInvited
+	case event.MembershipBan:
+		return compat.MembershipChangeBanned
+	case event.MembershipKnock:
+		return compat.MembershipChangeKnocked
+	case event.MembershipLeave:
+		switch {
+		case prev == event.MembershipBan:
+			return compat.MembershipChangeUnbanned
+		case prev == event.MembershipInvite && self:
+			return compat.MembershipChangeInviteRejected
+		case prev == event.MembershipInvite:
+			return compat.MembershipChangeInviteRevoked
+		case self:
+			return compat.MembershipChangeLeft
+		default:
+			return compat.MembershipChangeKicked
+		}
+	}
+	return ""
+}
+
+func (s *Server) listMembershipHistory(w http.ResponseWriter, r *http.Request) error {
+	limit, err := parseOptionalLimit(r.URL.Query().Get("limit"), membershipHistoryDefaultLimit, 1, membershipHistoryMaxLimit, "limit")
+	if err != nil {
+		return err
+	}
+	var before membershipHistoryCursor
+	if raw := strings.TrimSpace(r.URL.Query().Get("cursor")); raw != "" {
+		if err = cursor.Decode(raw, &before); err != nil {
+			return errs.Validation(map[string]any{"cursor": err.Error()})
+		}
+	}
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	rows, err := s.loadMemberEventRows(ctx, room.ID, before, limit+1)
+	if err != nil {
+		return err
+	}
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	selfID := string(s.rt.Client().Account.UserID)
+	profiles := s.loadMemberProfiles(ctx, room.ID)
+	items := make([]compat.MembershipChange, 0, len(rows))
+	for _, row := range rows {
+		action := membershipChangeAction(row)
+		if action == "" {
+			continue
+		}
+		targetProfile := row.Content
+		if targetProfile.Displayname == "" && row.Prev != nil {
+			targetProfile = *row.Prev
+		}
+		actor := profiles[row.Sender]
+		if row.Sender == row.StateKey {
+			actor = targetProfile
+		}
+		items = append(items, compat.MembershipChange{
+			ID:        row.EventID,
+			Action:    action,
+			User:      userFromMemberEvent(ctx, row.StateKey, targetProfile, selfID),
+			Actor:     userFromMemberEvent(ctx, row.Sender, actor, selfID),
+			Reason:    row.Content.Reason,
+			Timestamp: time.UnixMilli(row.Timestamp).UTC(),
+		})
+	}
+
+	output := compat.ListMembershipHistoryOutput{Items: items, HasMore: hasMore}
+	if hasMore {
+		last := rows[len(rows)-1]
+		encoded, encodeErr := cursor.Encode(membershipHistoryCursor{Timestamp: last.Timestamp, RowID: last.RowID})
+		if encodeErr != nil {
+			return errs.Internal(encodeErr)
+		}
+		output.NextCursor = &encoded
+	}
+	return writeJSON(w, output)
+}
+
+func (s *Server) loadMemberEventRows(ctx context.Context, roomID id.RoomID, before membershipHistoryCursor, limit int) ([]memberEventRow, error) {
+	rows, err := s.rt.Client().DB.Query(ctx, membershipHistoryQuery, roomID, before.Timestamp, before.RowID, limit)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query membership history: %w", err))
+	}
+	defer rows.Close()
+	var output []memberEventRow
+	for rows.Next() {
+		var (
+			row               memberEventRow
+			content, unsigned []byte
+		)
+		if err = rows.Scan(&row.RowID, &row.EventID, &row.Sender, &row.StateKey, &row.Timestamp, &content, &unsigned); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan membership event: %w", err))
+		}
+		if json.Unmarshal(content, &row.Content) != nil {
+			continue
+		}
+		var extra struct {
+			PrevContent *event.MemberEventContent `json:"prev_content"`
+		}
+		if json.Unmarshal(unsigned, &extra) == nil {
+			row.Prev = extra.PrevContent
+		}
+		output = append(output, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("membership history query failed: %w", err))
+	}
+	return output, nil
+}
+
+// loadMemberProfiles maps every member with current state in the room to
+// their latest profile, so moderators who have since left still get a name.
+func (s *Server) loadMemberProfiles(ctx context.Context, roomID id.RoomID) map[string]event.MemberEventContent {
+	profiles := make(map[string]event.MemberEventContent)
+	memberEvents, err := s.rt.Client().DB.CurrentState.GetMembers(ctx, roomID)
+	if err != nil {
+		return profiles
+	}
+	for _, memberEvt := range memberEvents {
+		if memberEvt.StateKey == nil {
+			continue
+		}
+		var content event.MemberEventContent
+		if json.Unmarshal(memberEvt.GetContent(), &content) == nil {
+			profiles[*memberEvt.StateKey] = content
+		}
+	}
+	return profiles
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestMembershipChangeAction(t *testing.T) {
+	cases := []struct {
+		name   string
+		row    memberEventRow
+		expect compat.MembershipChangeAction
+	}{
+		{"join", memberEventRow{Sender: "@a", StateKey: "@a", Content: event.MemberEventContent{Membership: event.MembershipJoin}}, compat.MembershipChangeJoined},
+		{"leave", memberEventRow{Sender: "@a", StateKey: "@a", Content: event.MemberEventContent{Membership: event.MembershipLeave}, Prev: &event.MemberEventContent{Membership: event.MembershipJoin}}, compat.MembershipChangeLeft},
+		{"kick", memberEventRow{Sender: "@mod", StateKey: "@a", Content: event.MemberEventContent{Membership: event.MembershipLeave}, Prev: &event.MemberEventContent{Membership: event.MembershipJoin}}, compat.MembershipChangeKicked},
+		{"reject", memberEventRow{Sender: "@a", StateKey: "@a", Content: event.MemberEventContent{Membership: event.MembershipLeave}, Prev: &event.MemberEventContent{Membership: event.MembershipInvite}}, compat.MembershipChangeInviteRejected},
+		{"unban", memberEventRow{Sender: "@mod", StateKey: "@a", Content: event.MemberEventContent{Membership: event.MembershipLeave}, Prev: &event.MemberEventContent{Membership: event.MembershipBan}}, compat.MembershipChangeUnbanned},
+	}
+	for _, tc := range cases {
+		if got := membershipChangeAction(tc.row); got != tc.expect {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// annotationFilter is one annotation= search parameter. The accepted forms are
+// "ns" (namespace is set), "!ns" (namespace is not set) and "ns=<json>"
+// (namespace equals the JSON value, e.g. processed=true).
+type annotationFilter struct {
+	Namespace string
+	Negate    bool
+	Value     json.RawMessage
+}
+
+func messageAnnotationSubject(chatID, messageID string) string {
+	return chatID + "|" + messageID
+}
+
+func parseAnnotationFilters(values []string) ([]annotationFilter, error) {
+	filters := make([]annotationFilter, 0, len(values))
+	for _, raw := range values {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		var filter annotationFilter
+		if strings.HasPrefix(raw, "!") {
+			filter.Negate = true
+			raw = strings.TrimPrefix(raw, "!")
+		}
+		namespace, value, hasValue := strings.Cut(raw, "=")
+		filter.Namespace = strings.TrimSpace(namespace)
+		if !metadataNamespacePattern.MatchString(filter.Namespace) {
+			return nil, errs.Validation(map[string]any{"annotation": fmt.Sprintf("invalid namespace %q", filter.Namespace)})
+		}
+		if hasValue {
+			if filter.Negate {
+				return nil, errs.Validation(map[string]any{"annotation": "negated filters cannot compare values"})
+			}
+			compacted := new(bytes.Buffer)
+			if err := json.Compact(compacted, []byte(value)); err != nil {
+				return nil, errs.Validation(map[string]any{"annotation": fmt.Sprintf("value for %q must be JSON", filter.Namespace)})
+			}
+			filter.Value = compacted.Bytes()
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+func matchesAnnotationFilters(annotations map[string]json.RawMessage, filters []annotationFilter) bool {
+	for _, filter := range filters {
+		value, ok := annotations[filter.Namespace]
+		switch {
+		case filter.Negate:
+			if ok {
+				return false
+			}
+		case !ok:
+			return false
+		case filter.Value != nil && !bytes.Equal(value, filter.Value):
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) getMessageAnnotation(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	messageID := readMessageID(r, "")
+	if chatID == "" || messageID == "" {
+		return errs.Validation(map[string]any{"messageID": "chatID and messageID are required"})
+	}
+	return writeMetadataValue(w, r, s.messageAnnotations, messageAnnotationSubject(chatID, messageID))
+}
+
+func (s *Server) setMessageAnnotation(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	messageID := readMessageID(r, "")
+	if chatID == "" || messageID == "" {
+		return errs.Validation(map[string]any{"messageID": "chatID and messageID are required"})
+	}
+	evt, err := s.rt.Client().DB.Event.GetByID(r.Context(), id.EventID(messageID))
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to get target message: %w", err))
+	}
+	if evt == nil || string(evt.RoomID) != chatID {
+		return errs.NotFound("Message not found")
+	}
+	return storeMetadataValue(w, r, s.messageAnnotations, messageAnnotationSubject(chatID, messageID))
+}
+
+// messageAnnotationsFor loads the calling client's annotations for a message.
+func (s *Server) messageAnnotationsFor(clientID string, message compat.Message) (map[string]json.RawMessage, error) {
+	annotations, err := s.messageAnnotations.forClient(messageAnnotationSubject(message.ChatID, message.ID), clientID)
+	if err != nil {
+		return nil, errs.Internal(err)
+	}
+	return annotations, nil
+}
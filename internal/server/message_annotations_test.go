@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAnnotationFilters(t *testing.T) {
+	filters, err := parseAnnotationFilters([]string{"processed=true", "!ticket", " sentiment ", ""})
+	if err != nil {
+		t.Fatalf("parseAnnotationFilters returned error: %v", err)
+	}
+	if len(filters) != 3 {
+		t.Fatalf("expected 3 filters, got %#v", filters)
+	}
+	if filters[0].Namespace != "processed" || string(filters[0].Value) != "true" {
+		t.Fatalf("unexpected value filter %#v", filters[0])
+	}
+	if !filters[1].Negate || filters[1].Namespace != "ticket" {
+		t.Fatalf("unexpected negated filter %#v", filters[1])
+	}
+	for _, invalid := range []string{"bad namespace", "status={", "!done=true"} {
+		if _, err = parseAnnotationFilters([]string{invalid}); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestMatchesAnnotationFilters(t *testing.T) {
+	filters, err := parseAnnotationFilters([]string{"processed=true", "!ticket"})
+	if err != nil {
+		t.Fatalf("parseAnnotationFilters returned error: %v", err)
+	}
+	cases := []struct {
+		annotations map[string]json.RawMessage
+		expected    bool
+	}{
+		{map[string]json.RawMessage{"processed": json.RawMessage(`true`)}, true},
+		{map[string]json.RawMessage{"processed": json.RawMessage(`false`)}, false},
+		{map[string]json.RawMessage{"processed": json.RawMessage(`true`), "ticket": json.RawMessage(`"T-1"`)}, false},
+		{nil, false},
+	}
+	for _, tc := range cases {
+		if got := matchesAnnotationFilters(tc.annotations, filters); got != tc.expected {
+			t.Fatalf("matchesAnnotationFilters(%s) = %v, want %v", tc.annotations, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// messageReceiptsQuery returns every read receipt in the room that points at
+// or past the target event on the timeline.
+const messageReceiptsQuery = `
+	SELECT receipt.user_id, receipt.event_id, receipt.timestamp
+	FROM receipt
+	JOIN event ON event.event_id = receipt.event_id
+	JOIN timeline ON timeline.event_rowid = event.rowid
+	WHERE receipt.room_id = $1 AND receipt.receipt_type IN ('m.read', 'm.read.private')
+	  AND timeline.rowid >= (
+		SELECT timeline.rowid FROM timeline
+		JOIN event ON event.rowid = timeline.event_rowid
+		WHERE event.event_id = $2
+	  )
+`
+
+type messageReceiptRow struct {
+	UserID    id.UserID
+	EventID   id.EventID
+	Timestamp int64
+}
+
+// collapseMessageReceipts keeps one receipt per user, the earliest one, which
+// is the closest available approximation of when the message was read. The
+// sender is skipped since sending implies having read.
+func collapseMessageReceipts(rows []messageReceiptRow, sender id.UserID) []messageReceiptRow {
+	byUser := make(map[id.UserID]messageReceiptRow, len(rows))
+	for _, row := range rows {
+		if row.UserID == sender {
+			continue
+		}
+		if existing, ok := byUser[row.UserID]; ok && existing.Timestamp <= row.Timestamp {
+			continue
+		}
+		byUser[row.UserID] = row
+	}
+	output := make([]messageReceiptRow, 0, len(byUser))
+	for _, row := range byUser {
+		output = append(output, row)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Timestamp != output[j].Timestamp {
+			return output[i].Timestamp < output[j].Timestamp
+		}
+		return output[i].UserID < output[j].UserID
+	})
+	return output
+}
+
+func (s *Server) listMessageReceipts(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	messageID := readMessageID(r, "")
+	if chatID == "" || messageID == "" {
+		return errs.Validation(map[string]any{"messageID": "chatID and messageID are required"})
+	}
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	evt, err := s.rt.Client().DB.Event.GetByID(ctx, id.EventID(messageID))
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to get target message: %w", err))
+	}
+	if evt == nil || evt.RoomID != room.ID {
+		return errs.NotFound("Message not found")
+	}
+	rows, err := s.loadMessageReceipts(ctx, room.ID, evt.ID)
+	if err != nil {
+		return err
+	}
+
+	participants, _ := s.loadRoomParticipants(ctx, room)
+	usersByID := make(map[string]compat.User, len(participants))
+	for _, user := range participants {
+		usersByID[user.ID] = user
+	}
+	items := make([]compat.MessageReceipt, 0, len(rows))
+	for _, row := range collapseMessageReceipts(rows, evt.Sender) {
+		user, ok := usersByID[string(row.UserID)]
+		if !ok {
+			user = compat.User{ID: string(row.UserID)}
+		}
+		items = append(items, compat.MessageReceipt{
+			User:             user,
+			ReadAt:           time.UnixMilli(row.Timestamp).UTC(),
+			ReceiptMessageID: string(row.EventID),
+		})
+	}
+	return writeJSON(w, compat.ListMessageReceiptsOutput{Items: items})
+}
+
+func (s *Server) loadMessageReceipts(ctx context.Context, roomID id.RoomID, eventID id.EventID) ([]messageReceiptRow, error) {
+	rows, err := s.rt.Client().DB.Query(ctx, messageReceiptsQuery, roomID, eventID)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query receipts: %w", err))
+	}
+	defer rows.Close()
+	var output []messageReceiptRow
+	for rows.Next() {
+		var row messageReceiptRow
+		if err = rows.Scan(&row.UserID, &row.EventID, &row.Timestamp); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan receipt: %w", err))
+		}
+		output = append(output, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("receipt query failed: %w", err))
+	}
+	return output, nil
+}
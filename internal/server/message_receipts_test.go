@@ -0,0 +1,21 @@
+package server
+
+import "testing"
+
+func TestCollapseMessageReceiptsKeepsEarliestPerUser(t *testing.T) {
+	rows := collapseMessageReceipts([]messageReceiptRow{
+		{UserID: "@bob:example.org", EventID: "$later", Timestamp: 300},
+		{UserID: "@bob:example.org", EventID: "$earlier", Timestamp: 200},
+		{UserID: "@alice:example.org", EventID: "$own", Timestamp: 100},
+		{UserID: "@carol:example.org", EventID: "$msg", Timestamp: 250},
+	}, "@alice:example.org")
+	if len(rows) != 2 {
+		t.Fatalf("expected two readers, got %#v", rows)
+	}
+	if rows[0].UserID != "@bob:example.org" || rows[0].EventID != "$earlier" {
+		t.Fatalf("expected bob's earliest receipt first, got %#v", rows[0])
+	}
+	if rows[1].UserID != "@carol:example.org" {
+		t.Fatalf("expected carol second, got %#v", rows[1])
+	}
+}
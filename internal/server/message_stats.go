@@ -0,0 +1,234 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+// roomMessageCounts tallies new messages in one room. Counts are kept per
+// room and only mapped to accounts when read, so syncs never need an
+// account lookup.
+type roomMessageCounts struct {
+	Inbound        int64
+	Outbound       int64
+	LastInboundAt  time.Time
+	LastOutboundAt time.Time
+}
+
+func (c *roomMessageCounts) merge(other roomMessageCounts) {
+	c.Inbound += other.Inbound
+	c.Outbound += other.Outbound
+	if other.LastInboundAt.After(c.LastInboundAt) {
+		c.LastInboundAt = other.LastInboundAt
+	}
+	if other.LastOutboundAt.After(c.LastOutboundAt) {
+		c.LastOutboundAt = other.LastOutboundAt
+	}
+}
+
+// messageCounter keeps message counts since the server started.
+type messageCounter struct {
+	mu    sync.Mutex
+	since time.Time
+	rooms map[id.RoomID]roomMessageCounts
+}
+
+func newMessageCounter() *messageCounter {
+	return &messageCounter{since: time.Now().UTC(), rooms: make(map[id.RoomID]roomMessageCounts)}
+}
+
+func (c *messageCounter) add(counts map[id.RoomID]roomMessageCounts) {
+	if len(counts) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for roomID, roomCounts := range counts {
+		current := c.rooms[roomID]
+		current.merge(roomCounts)
+		c.rooms[roomID] = current
+	}
+}
+
+func (c *messageCounter) snapshot() (time.Time, map[id.RoomID]roomMessageCounts) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make(map[id.RoomID]roomMessageCounts, len(c.rooms))
+	for roomID, counts := range c.rooms {
+		rooms[roomID] = counts
+	}
+	return c.since, rooms
+}
+
+// countSyncMessages counts the messages and stickers a sync appended to room
+// timelines. Edits, reactions and events that were only re-sent for context
+// are skipped; messages from self are outbound, including ones sent from
+// another device or through a double-puppeted bridge.
+func countSyncMessages(syncComplete *jsoncmd.SyncComplete, self id.UserID) map[id.RoomID]roomMessageCounts {
+	if syncComplete == nil {
+		return nil
+	}
+	counts := make(map[id.RoomID]roomMessageCounts)
+	for roomID, roomSync := range syncComplete.Rooms {
+		if roomSync == nil || len(roomSync.Timeline) == 0 {
+			continue
+		}
+		appended := make(map[database.EventRowID]struct{}, len(roomSync.Timeline))
+		for _, row := range roomSync.Timeline {
+			appended[row.Event] = struct{}{}
+		}
+		var roomCounts roomMessageCounts
+		for _, evt := range roomSync.Events {
+			if evt == nil || evt.RelationType == event.RelReplace {
+				continue
+			}
+			if _, ok := appended[evt.RowID]; !ok {
+				continue
+			}
+			if evtType := evt.GetType().Type; evtType != event.EventMessage.Type && evtType != event.EventSticker.Type {
+				continue
+			}
+			at := evt.Timestamp.Time.UTC()
+			if evt.Sender == self {
+				roomCounts.merge(roomMessageCounts{Outbound: 1, LastOutboundAt: at})
+			} else {
+				roomCounts.merge(roomMessageCounts{Inbound: 1, LastInboundAt: at})
+			}
+		}
+		if roomCounts.Inbound > 0 || roomCounts.Outbound > 0 {
+			counts[roomID] = roomCounts
+		}
+	}
+	return counts
+}
+
+func optionalTime(at time.Time) *time.Time {
+	if at.IsZero() {
+		return nil
+	}
+	return &at
+}
+
+// networkStatsKey is the stable network name used for grouping and metric
+// labels, e.g. whatsapp, unlike the localized display name.
+func networkStatsKey(accountID string) string {
+	if bridgeID := bridgeIDFromAccountID(accountID); bridgeID != "" {
+		return bridgeID
+	}
+	return accountID
+}
+
+func buildNetworkStats(rooms map[id.RoomID]roomMessageCounts, lookup *accountLookup, policy *subjectPolicy) []compat.NetworkStats {
+	byAccount := make(map[string]roomMessageCounts)
+	for roomID, counts := range rooms {
+		accountID, _ := inferAccountForRoom(roomID, lookup)
+		if accountID == "" || !policy.allowsChat(string(roomID), accountID) {
+			continue
+		}
+		current := byAccount[accountID]
+		current.merge(counts)
+		byAccount[accountID] = current
+	}
+	byNetwork := make(map[string]*compat.NetworkStats)
+	for accountID, counts := range byAccount {
+		key := networkStatsKey(accountID)
+		network, ok := byNetwork[key]
+		if !ok {
+			network = &compat.NetworkStats{NetworkID: key, Network: lookup.ByID[accountID].Network}
+			byNetwork[key] = network
+		}
+		network.Accounts = append(network.Accounts, compat.AccountMessageStats{
+			AccountID:      accountID,
+			Inbound:        counts.Inbound,
+			Outbound:       counts.Outbound,
+			LastInboundAt:  optionalTime(counts.LastInboundAt),
+			LastOutboundAt: optionalTime(counts.LastOutboundAt),
+		})
+		network.Inbound += counts.Inbound
+		network.Outbound += counts.Outbound
+		if counts.LastInboundAt.After(derefTime(network.LastInboundAt)) {
+			network.LastInboundAt = optionalTime(counts.LastInboundAt)
+		}
+		if counts.LastOutboundAt.After(derefTime(network.LastOutboundAt)) {
+			network.LastOutboundAt = optionalTime(counts.LastOutboundAt)
+		}
+	}
+	output := make([]compat.NetworkStats, 0, len(byNetwork))
+	for _, network := range byNetwork {
+		sort.Slice(network.Accounts, func(i, j int) bool {
+			return network.Accounts[i].AccountID < network.Accounts[j].AccountID
+		})
+		output = append(output, *network)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].NetworkID < output[j].NetworkID
+	})
+	return output
+}
+
+func derefTime(at *time.Time) time.Time {
+	if at == nil {
+		return time.Time{}
+	}
+	return *at
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePrometheusNetworkStats renders the stats in the Prometheus text
+// exposition format, one series per account and direction.
+func writePrometheusNetworkStats(b *strings.Builder, networks []compat.NetworkStats) {
+	b.WriteString("# HELP easymatrix_messages_total Messages seen in sync since the server started.\n")
+	b.WriteString("# TYPE easymatrix_messages_total counter\n")
+	for _, network := range networks {
+		for _, account := range network.Accounts {
+			labels := fmt.Sprintf(`network="%s",account="%s"`, prometheusLabelEscaper.Replace(network.NetworkID), prometheusLabelEscaper.Replace(account.AccountID))
+			fmt.Fprintf(b, "easymatrix_messages_total{%s,direction=\"inbound\"} %d\n", labels, account.Inbound)
+			fmt.Fprintf(b, "easymatrix_messages_total{%s,direction=\"outbound\"} %d\n", labels, account.Outbound)
+		}
+	}
+	b.WriteString("# HELP easymatrix_last_message_timestamp_seconds Time of the newest message seen in sync.\n")
+	b.WriteString("# TYPE easymatrix_last_message_timestamp_seconds gauge\n")
+	for _, network := range networks {
+		for _, account := range network.Accounts {
+			labels := fmt.Sprintf(`network="%s",account="%s"`, prometheusLabelEscaper.Replace(network.NetworkID), prometheusLabelEscaper.Replace(account.AccountID))
+			if account.LastInboundAt != nil {
+				fmt.Fprintf(b, "easymatrix_last_message_timestamp_seconds{%s,direction=\"inbound\"} %d\n", labels, account.LastInboundAt.Unix())
+			}
+			if account.LastOutboundAt != nil {
+				fmt.Fprintf(b, "easymatrix_last_message_timestamp_seconds{%s,direction=\"outbound\"} %d\n", labels, account.LastOutboundAt.Unix())
+			}
+		}
+	}
+}
+
+// getNetworkStats reports message counts per network and account since the
+// server started. format=prometheus returns the text exposition format for
+// scraping.
+func (s *Server) getNetworkStats(w http.ResponseWriter, r *http.Request) error {
+	lookup, err := s.buildAccountLookup(r.Context())
+	if err != nil {
+		return err
+	}
+	since, rooms := s.messageCounts.snapshot()
+	networks := buildNetworkStats(rooms, lookup, s.requestPolicy(r))
+	if strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("format")), "prometheus") {
+		var b strings.Builder
+		writePrometheusNetworkStats(&b, networks)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(b.String()))
+		return nil
+	}
+	return writeJSON(w, compat.NetworkStatsOutput{Since: since, Networks: networks})
+}
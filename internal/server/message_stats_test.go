@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestCountSyncMessagesCountsNewTimelineMessages(t *testing.T) {
+	const self = id.UserID("@me:beeper.com")
+	at := time.UnixMilli(1_700_000_000_000).UTC()
+	msg := func(rowID database.EventRowID, sender id.UserID) *database.Event {
+		return &database.Event{RowID: rowID, Sender: sender, Type: event.EventMessage.Type, Timestamp: jsontime.UM(at)}
+	}
+	edit := msg(4, "@alice:beeper.com")
+	edit.RelationType = event.RelReplace
+	syncComplete := &jsoncmd.SyncComplete{Rooms: map[id.RoomID]*jsoncmd.SyncRoom{
+		"!wa:beeper.local": {
+			Timeline: []database.TimelineRowTuple{{Timeline: 1, Event: 1}, {Timeline: 2, Event: 2}, {Timeline: 3, Event: 4}, {Timeline: 4, Event: 5}},
+			Events: []*database.Event{
+				msg(1, "@alice:beeper.com"),
+				msg(2, self),
+				msg(3, "@alice:beeper.com"), // context only, not appended
+				edit,
+				{RowID: 5, Sender: "@alice:beeper.com", Type: event.EventReaction.Type},
+			},
+		},
+		"!quiet:beeper.local": {Events: []*database.Event{msg(6, "@bob:beeper.com")}},
+	}}
+
+	counts := countSyncMessages(syncComplete, self)
+	if len(counts) != 1 {
+		t.Fatalf("expected only the room with appended messages, got %v", counts)
+	}
+	got := counts["!wa:beeper.local"]
+	if got.Inbound != 1 || got.Outbound != 1 || !got.LastInboundAt.Equal(at) || !got.LastOutboundAt.Equal(at) {
+		t.Fatalf("unexpected counts: %+v", got)
+	}
+}
+
+func TestBuildNetworkStatsGroupsByNetwork(t *testing.T) {
+	whatsappA := compat.Account{AccountID: "whatsapp_a", Network: "WhatsApp"}
+	whatsappB := compat.Account{AccountID: "whatsapp_b", Network: "WhatsApp"}
+	lookup := &accountLookup{
+		Accounts: []compat.Account{whatsappA, whatsappB},
+		ByID:     map[string]compat.Account{"whatsapp_a": whatsappA, "whatsapp_b": whatsappB},
+		ByBridge: map[string][]compat.Account{"whatsapp": {whatsappA, whatsappB}},
+		RoomBridges: map[id.RoomID]roomBridgeInfo{
+			"!one:beeper.local": {Protocol: "whatsapp", Receiver: "a"},
+			"!two:beeper.local": {Protocol: "whatsapp", Receiver: "b"},
+		},
+	}
+	older := time.UnixMilli(1_700_000_000_000).UTC()
+	newer := older.Add(time.Hour)
+	rooms := map[id.RoomID]roomMessageCounts{
+		"!one:beeper.local": {Inbound: 3, LastInboundAt: older},
+		"!two:beeper.local": {Inbound: 1, Outbound: 2, LastInboundAt: newer, LastOutboundAt: newer},
+	}
+
+	networks := buildNetworkStats(rooms, lookup, nil)
+	if len(networks) != 1 {
+		t.Fatalf("expected one network, got %+v", networks)
+	}
+	network := networks[0]
+	if network.NetworkID != "whatsapp" || network.Network != "WhatsApp" || network.Inbound != 4 || network.Outbound != 2 || len(network.Accounts) != 2 {
+		t.Fatalf("unexpected network totals: %+v", network)
+	}
+	if !network.LastInboundAt.Equal(newer) {
+		t.Fatalf("expected the newest inbound time, got %v", network.LastInboundAt)
+	}
+
+	var b strings.Builder
+	writePrometheusNetworkStats(&b, networks)
+	if want := `easymatrix_messages_total{network="whatsapp",account="whatsapp_a",direction="inbound"} 3`; !strings.Contains(b.String(), want) {
+		t.Fatalf("expected %q in metrics:\n%s", want, b.String())
+	}
+}
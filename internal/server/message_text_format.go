@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"html"
+	"strings"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+type messageTextFormat string
+
+const (
+	messageTextFormatPlain    messageTextFormat = "plain"
+	messageTextFormatMarkdown messageTextFormat = "markdown"
+	messageTextFormatHTML     messageTextFormat = "html"
+)
+
+func parseTextFormat(raw string) (messageTextFormat, error) {
+	switch value := messageTextFormat(strings.ToLower(strings.TrimSpace(raw))); value {
+	case "":
+		return messageTextFormatPlain, nil
+	case messageTextFormatPlain, messageTextFormatMarkdown, messageTextFormatHTML:
+		return value, nil
+	default:
+		return "", errs.Validation(map[string]any{"textFormat": "must be one of: plain, markdown, html"})
+	}
+}
+
+// applyTextFormat rewrites message.Text into the requested representation.
+// HTML output only ever uses the sanitized HTML computed by hicli or escaped
+// plain text, never the raw formatted_body from the event.
+func applyTextFormat(message *compat.Message, evt *database.Event, textFormat messageTextFormat) {
+	if textFormat == messageTextFormatPlain || textFormat == "" || message.Type == compat.MessageTypeReaction {
+		return
+	}
+	var content event.MessageEventContent
+	if err := json.Unmarshal(evt.GetContent(), &content); err != nil {
+		return
+	}
+	hasHTML := content.Format == event.FormatHTML && strings.TrimSpace(content.FormattedBody) != ""
+	sanitizedHTML := ""
+	if evt.LocalContent != nil {
+		sanitizedHTML = evt.LocalContent.SanitizedHTML
+	}
+
+	switch textFormat {
+	case messageTextFormatMarkdown:
+		if hasHTML && sanitizedHTML != "" {
+			message.Text = format.HTMLToMarkdown(sanitizedHTML)
+		} else if hasHTML {
+			message.Text = format.HTMLToMarkdown(content.FormattedBody)
+		}
+	case messageTextFormatHTML:
+		if sanitizedHTML != "" {
+			message.Text = sanitizedHTML
+		} else if message.Text != "" {
+			message.Text = strings.ReplaceAll(html.EscapeString(message.Text), "\n", "<br>")
+		}
+	}
+}
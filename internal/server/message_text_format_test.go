@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/beeper/desktop-api-go/shared"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestParseTextFormatDefaultsToPlain(t *testing.T) {
+	if got, err := parseTextFormat(""); err != nil || got != messageTextFormatPlain {
+		t.Fatalf("expected plain default, got %q (%v)", got, err)
+	}
+	if got, err := parseTextFormat(" HTML "); err != nil || got != messageTextFormatHTML {
+		t.Fatalf("expected html, got %q (%v)", got, err)
+	}
+	if _, err := parseTextFormat("rtf"); err == nil {
+		t.Fatal("expected unknown textFormat to be rejected")
+	}
+}
+
+func TestApplyTextFormatConvertsFormattedBody(t *testing.T) {
+	evt := &database.Event{
+		Content:      []byte(`{"msgtype":"m.text","body":"hello world","format":"org.matrix.custom.html","formatted_body":"<b>hello</b> <script>x</script>world"}`),
+		LocalContent: &database.LocalContent{SanitizedHTML: "<b>hello</b> world"},
+	}
+	newMessage := func() compat.Message {
+		return compat.Message{Message: shared.Message{Type: compat.MessageTypeText, Text: "hello world"}}
+	}
+
+	markdown := newMessage()
+	applyTextFormat(&markdown, evt, messageTextFormatMarkdown)
+	if markdown.Text != "**hello** world" {
+		t.Fatalf("unexpected markdown text %q", markdown.Text)
+	}
+
+	htmlMessage := newMessage()
+	applyTextFormat(&htmlMessage, evt, messageTextFormatHTML)
+	if htmlMessage.Text != "<b>hello</b> world" {
+		t.Fatalf("expected sanitized HTML, got %q", htmlMessage.Text)
+	}
+
+	evt.LocalContent = nil
+	escaped := compat.Message{Message: shared.Message{Type: compat.MessageTypeText, Text: "a < b\nc"}}
+	applyTextFormat(&escaped, evt, messageTextFormatHTML)
+	if escaped.Text != "a &lt; b<br>c" {
+		t.Fatalf("expected escaped plain text, got %q", escaped.Text)
+	}
+}
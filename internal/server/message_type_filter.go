@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// messageTypeFilter is the set of message types a consumer asked for, so
+// minimal integrations such as text-only summarizers skip the rest. A nil
+// filter lets every type through.
+type messageTypeFilter map[compat.MessageType]struct{}
+
+// parseMessageTypeFilter accepts type names case-insensitively, either as
+// separate values or comma-separated. No names means no filter.
+func parseMessageTypeFilter(values []string) (messageTypeFilter, bool) {
+	var filter messageTypeFilter
+	for _, value := range values {
+		for _, raw := range strings.Split(value, ",") {
+			if strings.TrimSpace(raw) == "" {
+				continue
+			}
+			messageType, ok := compat.ParseMessageType(raw)
+			if !ok {
+				return nil, false
+			}
+			if filter == nil {
+				filter = make(messageTypeFilter)
+			}
+			filter[messageType] = struct{}{}
+		}
+	}
+	return filter, true
+}
+
+func messageTypeFilterError(field string) error {
+	names := make([]string, 0, len(compat.MessageTypes))
+	for _, messageType := range compat.MessageTypes {
+		names = append(names, string(messageType))
+	}
+	return errs.Validation(map[string]any{field: "must list message types from: " + strings.Join(names, ", ")})
+}
+
+func parseMessageTypesQuery(r *http.Request) (messageTypeFilter, error) {
+	filter, ok := parseMessageTypeFilter(r.URL.Query()["messageTypes"])
+	if !ok {
+		return nil, messageTypeFilterError("messageTypes")
+	}
+	return filter, nil
+}
+
+func (f messageTypeFilter) allows(messageType compat.MessageType) bool {
+	if f == nil {
+		return true
+	}
+	_, ok := f[messageType]
+	return ok
+}
+
+// names lists the filter in the order of compat.MessageTypes, for echoing
+// it back to websocket clients.
+func (f messageTypeFilter) names() []string {
+	if f == nil {
+		return nil
+	}
+	output := make([]string, 0, len(f))
+	for _, messageType := range compat.MessageTypes {
+		if _, ok := f[messageType]; ok {
+			output = append(output, string(messageType))
+		}
+	}
+	return output
+}
+
+// filterMessageEntries drops hydrated messages of types the filter excludes,
+// along with their IDs.
+func filterMessageEntries(entries []compatRecord, ids []string, filter messageTypeFilter) ([]compatRecord, []string) {
+	if filter == nil {
+		return entries, ids
+	}
+	keptEntries := make([]compatRecord, 0, len(entries))
+	kept := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		messageType, _ := entry["type"].(string)
+		if !filter.allows(compat.MessageType(messageType)) {
+			continue
+		}
+		keptEntries = append(keptEntries, entry)
+		if entryID, ok := entry["id"].(string); ok {
+			kept[entryID] = struct{}{}
+		}
+	}
+	keptIDs := make([]string, 0, len(ids))
+	for _, entryID := range ids {
+		if _, ok := kept[entryID]; ok {
+			keptIDs = append(keptIDs, entryID)
+		}
+	}
+	return keptEntries, keptIDs
+}
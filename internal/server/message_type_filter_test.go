@@ -0,0 +1,36 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestParseMessageTypeFilter(t *testing.T) {
+	filter, ok := parseMessageTypeFilter([]string{"text, image", "reaction"})
+	if !ok || !filter.allows(compat.MessageTypeText) || !filter.allows(compat.MessageTypeReaction) || filter.allows(compat.MessageTypeVideo) {
+		t.Fatalf("unexpected filter %#v", filter)
+	}
+	if names := filter.names(); !reflect.DeepEqual(names, []string{"TEXT", "IMAGE", "REACTION"}) {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if filter, ok = parseMessageTypeFilter(nil); !ok || filter != nil || !filter.allows(compat.MessageTypeVideo) {
+		t.Fatalf("expected no values to allow every type")
+	}
+	if _, ok = parseMessageTypeFilter([]string{"TEXT,CALL"}); ok {
+		t.Fatalf("expected unknown type to be rejected")
+	}
+}
+
+func TestFilterMessageEntriesKeepsMatchingIDs(t *testing.T) {
+	entries := []compatRecord{
+		{"id": "$a", "type": "TEXT"},
+		{"id": "$b", "type": "REACTION"},
+	}
+	filter, _ := parseMessageTypeFilter([]string{"TEXT"})
+	kept, ids := filterMessageEntries(entries, []string{"$a", "$b"}, filter)
+	if len(kept) != 1 || kept[0]["id"] != "$a" || !reflect.DeepEqual(ids, []string{"$a"}) {
+		t.Fatalf("unexpected filtered entries %v %v", kept, ids)
+	}
+}
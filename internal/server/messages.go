@@ -12,6 +12,8 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	beeperdesktopapi "github.com/beeper/desktop-api-go"
+	"github.com/beeper/desktop-api-go/shared"
 	"go.mau.fi/gomuks/pkg/hicli/database"
 	"go.mau.fi/util/emojirunes"
 	"maunium.net/go/mautrix"
@@ -53,7 +55,23 @@ func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	textFormat, err := parseTextFormat(r.URL.Query().Get("textFormat"))
+	if err != nil {
+		return err
+	}
+	includeAnnotations, err := parseOptionalBool(r.URL.Query().Get("includeAnnotations"), false, "includeAnnotations")
+	if err != nil {
+		return err
+	}
+	messageTypes, err := parseMessageTypesQuery(r)
+	if err != nil {
+		return err
+	}
 
+	chatID, err = s.resolveLatestChatID(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
 	lookup, err := s.buildAccountLookup(r.Context())
 	if err != nil {
 		return err
@@ -92,15 +110,20 @@ func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) error {
 		if reactionErr != nil {
 			return reactionErr
 		}
+		delivery, deliveryErr := s.loadMessageDeliveryState(r.Context(), room.ID, events)
+		if deliveryErr != nil {
+			return deliveryErr
+		}
 
 		for _, evt := range events {
-			mapped, mapErr := s.mapEventToMessage(r.Context(), evt, room, lookup, reactionBundle{Names: memberNames, Reactions: reactions})
+			mapped, mapErr := s.mapEventToMessage(r.Context(), evt, room, lookup, reactionBundle{Names: memberNames, Reactions: reactions, Delivery: delivery, Types: messageTypes})
 			if errors.Is(mapErr, errSkipEvent) {
 				continue
 			}
 			if mapErr != nil {
 				continue
 			}
+			applyTextFormat(&mapped, evt, textFormat)
 			messages = append(messages, mapped)
 			if len(messages) > messagePageSize {
 				break
@@ -117,14 +140,114 @@ func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) error {
 		messages = messages[:messagePageSize]
 		hasMore = true
 	}
+	if includeAnnotations {
+		clientID := requestClientID(r)
+		for idx := range messages {
+			if messages[idx].Annotations, err = s.messageAnnotationsFor(clientID, messages[idx]); err != nil {
+				return err
+			}
+		}
+	}
 	return writeJSON(w, compat.ListMessagesOutput{Items: messages, HasMore: hasMore})
 }
 
-func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) error {
-	var req struct {
-		ChatID string `json:"chatID"`
-		compat.SendMessageInput
+// getMessage returns one message hydrated the same way as a listMessages
+// page, for clients refetching an event referenced by a WS domain event.
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	messageID := readMessageID(r, "")
+	if chatID == "" || messageID == "" {
+		return errs.Validation(map[string]any{"messageID": "chatID and messageID are required"})
+	}
+	textFormat, err := parseTextFormat(r.URL.Query().Get("textFormat"))
+	if err != nil {
+		return err
+	}
+	includeAnnotations, err := parseOptionalBool(r.URL.Query().Get("includeAnnotations"), false, "includeAnnotations")
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	evt, err := s.rt.Client().DB.Event.GetByID(ctx, id.EventID(messageID))
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to get message: %w", err))
+	}
+	if evt == nil || evt.RoomID != room.ID {
+		return errs.NotFound("Message not found")
+	}
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	events := []*database.Event{evt}
+	if err = s.populateLastEditRefs(ctx, events); err != nil {
+		return err
+	}
+	reactions, err := s.loadReactionMap(ctx, room.ID, events)
+	if err != nil {
+		return err
 	}
+	delivery, err := s.loadMessageDeliveryState(ctx, room.ID, events)
+	if err != nil {
+		return err
+	}
+	message, err := s.mapEventToMessage(ctx, evt, room, lookup, reactionBundle{
+		Names:     s.loadMemberNameMap(ctx, room.ID),
+		Reactions: reactions,
+		Delivery:  delivery,
+	})
+	if err != nil {
+		return errs.NotFound("Message not found")
+	}
+	applyTextFormat(&message, evt, textFormat)
+	if includeAnnotations {
+		if message.Annotations, err = s.messageAnnotationsFor(requestClientID(r), message); err != nil {
+			return err
+		}
+	}
+	return writeJSON(w, message)
+}
+
+// maxMessageAttachments bounds one send so a request cannot hold the upload
+// pool for an unbounded number of media uploads.
+const maxMessageAttachments = 20
+
+// sendMessageRequest adds fields to the SDK's send params. The SDK type
+// decodes the whole body in its own UnmarshalJSON, which would otherwise
+// swallow the fields next to it.
+type sendMessageRequest struct {
+	ChatID string
+	compat.SendMessageInput
+	Attachments []compat.MessageAttachmentInput
+}
+
+func (req *sendMessageRequest) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &req.SendMessageInput); err != nil {
+		return err
+	}
+	var extra struct {
+		ChatID      string                          `json:"chatID"`
+		Attachments []compat.MessageAttachmentInput `json:"attachments"`
+	}
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	req.ChatID, req.Attachments = extra.ChatID, extra.Attachments
+	return nil
+}
+
+// sendMessage sends text, one attachment, or an attachments list. Each
+// attachment becomes its own event, sent in order; text becomes the caption
+// of the first one, which also carries the reply. Every file is uploaded
+// before anything is sent, so a bad upload ID fails the request without a
+// partial send.
+func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) error {
+	var req sendMessageRequest
 	if err := decodeJSON(r, &req); err != nil {
 		return err
 	}
@@ -133,10 +256,28 @@ func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) error {
 		return errs.Validation(map[string]any{"chatID": "chatID is required"})
 	}
 	text := strings.TrimSpace(req.Text.Or(""))
-	hasAttachment := strings.TrimSpace(req.Attachment.UploadID) != ""
-	if text == "" && !hasAttachment {
+	attachments, attachmentField := req.Attachments, "attachments"
+	if strings.TrimSpace(req.Attachment.UploadID) != "" {
+		if len(attachments) > 0 {
+			return errs.Validation(map[string]any{"attachments": "cannot be combined with attachment"})
+		}
+		attachments, attachmentField = []compat.MessageAttachmentInput{req.Attachment}, "attachment"
+	}
+	if len(attachments) > maxMessageAttachments {
+		return errs.Validation(map[string]any{"attachments": fmt.Sprintf("at most %d attachments can be sent at once", maxMessageAttachments)})
+	}
+	for i, attachment := range attachments {
+		if strings.TrimSpace(attachment.UploadID) == "" {
+			return errs.Validation(map[string]any{fmt.Sprintf("attachments[%d].uploadID", i): "uploadID is required"})
+		}
+	}
+	if text == "" && len(attachments) == 0 {
 		return errs.Validation(map[string]any{"text": "text or attachment is required"})
 	}
+	chatID, err := s.resolveLatestChatID(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
 
 	cli := s.rt.Client()
 	roomID := id.RoomID(chatID)
@@ -146,31 +287,87 @@ func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) error {
 		return errs.NotFound("Chat not found")
 	}
 
-	var base *event.MessageEventContent
-	var err error
-	if hasAttachment {
-		base, err = s.buildAttachmentMessageContent(r.Context(), &req.Attachment)
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		return err
+	}
+	replyToMessageID := strings.TrimSpace(req.ReplyToMessageID.Or(""))
+	if dryRun {
+		output, err := s.dryRunSendMessage(r.Context(), roomID, text, replyToMessageID, attachments, attachmentField)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, output)
+	}
+
+	bases := make([]*event.MessageEventContent, 0, max(len(attachments), 1))
+	for i := range attachments {
+		base, err := s.buildAttachmentMessageContent(r.Context(), &attachments[i])
 		if err != nil {
 			return err
 		}
+		bases = append(bases, base)
+	}
+	if len(bases) == 0 {
+		bases = append(bases, nil)
+	}
+
+	// Text goes on the first attachment as its caption. Where the network
+	// would drop captions for that kind of file, it follows the media as a
+	// message of its own instead.
+	captionText := text
+	if bases[0] != nil && text != "" && !captionFits(s.loadRoomFeatures(r.Context(), roomID), bases[0].MsgType, utf8.RuneCountInString(text)) {
+		captionText = ""
+		bases = append(bases, nil)
 	}
 
 	var relatesTo *event.RelatesTo
-	replyToMessageID := strings.TrimSpace(req.ReplyToMessageID.Or(""))
 	if replyToMessageID != "" {
 		relatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: id.EventID(replyToMessageID)}}
 	}
 
-	dbEvent, err := cli.SendMessage(r.Context(), roomID, base, nil, text, relatesTo, nil, nil)
-	if err != nil {
-		return errs.Internal(fmt.Errorf("failed to send message: %w", err))
+	pendingMessageIDs := make([]string, 0, len(bases))
+	for i, base := range bases {
+		var eventText string
+		var eventRelatesTo *event.RelatesTo
+		if i == 0 {
+			eventText, eventRelatesTo = captionText, relatesTo
+		} else if base == nil {
+			eventText = text
+		}
+		dbEvent, err := cli.SendMessage(r.Context(), roomID, base, nil, eventText, eventRelatesTo, nil, nil)
+		if err != nil {
+			if len(pendingMessageIDs) > 0 {
+				return errs.Internal(fmt.Errorf("failed to send part %d of %d: %w", i+1, len(bases), err))
+			}
+			return errs.Internal(fmt.Errorf("failed to send message: %w", err))
+		}
+		pendingMessageID := dbEvent.TransactionID
+		if pendingMessageID == "" {
+			pendingMessageID = string(dbEvent.ID)
+		}
+		pendingMessageIDs = append(pendingMessageIDs, pendingMessageID)
 	}
-	pendingMessageID := dbEvent.TransactionID
-	if pendingMessageID == "" {
-		pendingMessageID = string(dbEvent.ID)
+
+	output := compat.SendMessageOutput{MessageSendResponse: beeperdesktopapi.MessageSendResponse{ChatID: chatID, PendingMessageID: pendingMessageIDs[0]}}
+	if len(req.Attachments) > 0 || len(pendingMessageIDs) > 1 {
+		output.PendingMessageIDs = pendingMessageIDs
 	}
+	return writeJSON(w, output)
+}
 
-	return writeJSON(w, compat.SendMessageOutput{ChatID: chatID, PendingMessageID: pendingMessageID})
+// sendTextMessage sends a plain text message on behalf of background
+// automations (scripts and plugins) that do not go through the HTTP handler.
+func (s *Server) sendTextMessage(ctx context.Context, chatID, text, replyToMessageID string) (*database.Event, error) {
+	var relatesTo *event.RelatesTo
+	if replyToMessageID = strings.TrimSpace(replyToMessageID); replyToMessageID != "" {
+		relatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: id.EventID(replyToMessageID)}}
+	}
+	dbEvent, err := s.rt.Client().SendMessage(ctx, id.RoomID(chatID), nil, nil, text, relatesTo, nil, nil)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to send message: %w", err))
+	}
+	return dbEvent, nil
 }
 
 func (s *Server) editMessage(w http.ResponseWriter, r *http.Request) error {
@@ -235,16 +432,9 @@ func (s *Server) addReaction(w http.ResponseWriter, r *http.Request) error {
 		return errs.Validation(map[string]any{"reactionKey": "reactionKey is required"})
 	}
 
-	content := &event.ReactionEventContent{
-		RelatesTo: event.RelatesTo{
-			Type:    event.RelAnnotation,
-			EventID: id.EventID(messageID),
-			Key:     req.ReactionKey,
-		},
-	}
-	dbEvt, err := s.rt.Client().Send(r.Context(), id.RoomID(chatID), event.EventReaction, content, false, false)
+	dbEvt, err := s.sendReaction(r.Context(), chatID, messageID, req.ReactionKey)
 	if err != nil {
-		return errs.Internal(fmt.Errorf("failed to add reaction: %w", err))
+		return err
 	}
 	transactionID := req.TransactionID
 	if transactionID == "" && dbEvt != nil {
@@ -263,6 +453,21 @@ func (s *Server) addReaction(w http.ResponseWriter, r *http.Request) error {
 	})
 }
 
+func (s *Server) sendReaction(ctx context.Context, chatID, messageID, reactionKey string) (*database.Event, error) {
+	content := &event.ReactionEventContent{
+		RelatesTo: event.RelatesTo{
+			Type:    event.RelAnnotation,
+			EventID: id.EventID(messageID),
+			Key:     reactionKey,
+		},
+	}
+	dbEvt, err := s.rt.Client().Send(ctx, id.RoomID(chatID), event.EventReaction, content, false, false)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to add reaction: %w", err))
+	}
+	return dbEvt, nil
+}
+
 func (s *Server) removeReaction(w http.ResponseWriter, r *http.Request) error {
 	var req struct {
 		ChatID    string `json:"chatID"`
@@ -407,6 +612,9 @@ func (s *Server) populateLastEditRefs(ctx context.Context, events []*database.Ev
 type reactionBundle struct {
 	Names     map[string]string
 	Reactions map[id.EventID][]compat.Reaction
+	Delivery  *messageDeliveryState
+	// Types, when set, skips messages of any other type.
+	Types messageTypeFilter
 }
 
 func (s *Server) loadMemberNameMap(ctx context.Context, roomID id.RoomID) map[string]string {
@@ -496,7 +704,7 @@ func (s *Server) mapEventToMessage(ctx context.Context, evt *database.Event, roo
 	}
 
 	accountID, _ := inferAccountForRoom(room.ID, lookup)
-	message := compat.Message{
+	message := compat.Message{Message: shared.Message{
 		ID:        string(evt.ID),
 		ChatID:    string(evt.RoomID),
 		AccountID: accountID,
@@ -505,7 +713,7 @@ func (s *Server) mapEventToMessage(ctx context.Context, evt *database.Event, roo
 		SortKey:   messageSortKey(evt),
 		IsSender:  evt.Sender == s.rt.Client().Account.UserID,
 		Reactions: reactions.Reactions[evt.ID],
-	}
+	}}
 	if name, ok := reactions.Names[string(evt.Sender)]; ok {
 		message.SenderName = name
 	} else {
@@ -514,12 +722,18 @@ func (s *Server) mapEventToMessage(ctx context.Context, evt *database.Event, roo
 	if replyTo := evt.GetReplyTo(); replyTo != "" {
 		message.LinkedMessageID = string(replyTo)
 	}
+	if message.IsSender {
+		message.DeliveryStatus = messageDeliveryStatus(evt, reactions.Delivery)
+	}
 
 	switch evtType {
 	case event.EventReaction.Type:
+		if !reactions.Types.allows(compat.MessageTypeReaction) {
+			return compat.Message{}, errSkipEvent
+		}
 		var reaction event.ReactionEventContent
 		if err := json.Unmarshal(evt.GetContent(), &reaction); err == nil {
-			message.Type = compat.MessageType("REACTION")
+			message.Type = compat.MessageTypeReaction
 			message.Text = reaction.RelatesTo.Key
 			if message.LinkedMessageID == "" {
 				message.LinkedMessageID = string(reaction.RelatesTo.EventID)
@@ -532,12 +746,24 @@ func (s *Server) mapEventToMessage(ctx context.Context, evt *database.Event, roo
 			return compat.Message{}, errSkipEvent
 		}
 		message.Type = mapMessageType(evtType, content.MsgType)
+		if !reactions.Types.allows(message.Type) {
+			return compat.Message{}, errSkipEvent
+		}
 		message.Text = content.Body
 		if message.Text == "" && evt.LocalContent != nil {
 			message.Text = evt.LocalContent.SanitizedHTML
 		}
 		if att, ok := messageAttachment(content, evtType); ok {
 			message.Attachments = []compat.Attachment{att}
+			if hash := s.assetContentHash(att.ID); hash != "" {
+				message.AttachmentContentHashes = map[string]string{att.ID: hash}
+			}
+			if s.attachmentIsAnimated(content, att.ID) {
+				message.AttachmentIsAnimated = map[string]bool{att.ID: true}
+			}
+		} else {
+			message.LinkPreview = s.cachedLinkPreview(&content, message.Text, evt, room)
+			message.ForwardedFrom = forwardedFrom(&content)
 		}
 		return message, nil
 	default:
@@ -547,23 +773,23 @@ func (s *Server) mapEventToMessage(ctx context.Context, evt *database.Event, roo
 
 func mapMessageType(evtType string, msgType event.MessageType) compat.MessageType {
 	if evtType == event.EventSticker.Type {
-		return compat.MessageType("STICKER")
+		return compat.MessageTypeSticker
 	}
 	switch msgType {
 	case event.MsgNotice:
-		return compat.MessageType("NOTICE")
+		return compat.MessageTypeNotice
 	case event.MsgImage:
-		return compat.MessageType("IMAGE")
+		return compat.MessageTypeImage
 	case event.MsgVideo:
-		return compat.MessageType("VIDEO")
+		return compat.MessageTypeVideo
 	case event.MsgAudio:
-		return compat.MessageType("AUDIO")
+		return compat.MessageTypeAudio
 	case event.MsgFile:
-		return compat.MessageType("FILE")
+		return compat.MessageTypeFile
 	case event.MsgLocation:
-		return compat.MessageType("LOCATION")
+		return compat.MessageTypeLocation
 	default:
-		return compat.MessageType("TEXT")
+		return compat.MessageTypeText
 	}
 }
 
@@ -585,7 +811,7 @@ func messageAttachment(content event.MessageEventContent, evtType string) (compa
 		SrcURL:   uri,
 		FileName: content.GetFileName(),
 		MimeType: "",
-		Type:     compat.AttachmentType("unknown"),
+		Type:     compat.AttachmentTypeUnknown,
 	}
 	if content.Info != nil {
 		att.MimeType = content.Info.MimeType
@@ -602,14 +828,14 @@ func messageAttachment(content event.MessageEventContent, evtType string) (compa
 	}
 	switch msgType {
 	case event.MsgImage:
-		att.Type = compat.AttachmentType("img")
+		att.Type = compat.AttachmentTypeImg
 		att.IsGif = strings.EqualFold(att.MimeType, "image/gif")
 	case event.MsgVideo:
-		att.Type = compat.AttachmentType("video")
+		att.Type = compat.AttachmentTypeVideo
 	case event.MsgAudio:
-		att.Type = compat.AttachmentType("audio")
+		att.Type = compat.AttachmentTypeAudio
 	case "m.sticker":
-		att.Type = compat.AttachmentType("img")
+		att.Type = compat.AttachmentTypeImg
 		att.IsSticker = true
 	}
 	return att, true
@@ -652,35 +878,24 @@ func (s *Server) buildAttachmentMessageContent(ctx context.Context, attachment *
 	if mimeType == "" {
 		mimeType = meta.MimeType
 	}
-	file, err := os.Open(meta.FilePath)
-	if err != nil {
-		return nil, errs.Internal(fmt.Errorf("failed to open uploaded asset: %w", err))
-	}
-	defer file.Close()
-	stat, err := file.Stat()
-	if err != nil {
-		return nil, errs.Internal(fmt.Errorf("failed to stat uploaded asset: %w", err))
+	if err = s.sanitizeStagedImage(&meta, mimeType); err != nil {
+		return nil, err
 	}
-
-	uploadResp, err := s.rt.Client().Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
-		Content:       file,
-		ContentLength: stat.Size(),
-		ContentType:   mimeType,
-		FileName:      fileName,
-	})
+	contentURI, size, err := s.uploadStoredAsset(ctx, meta, fileName, mimeType)
 	if err != nil {
-		return nil, errs.Internal(fmt.Errorf("failed to upload media to Matrix: %w", err))
+		return nil, err
 	}
+	s.rememberUploadedAsset(contentURI, meta)
 
 	msgType := messageTypeFromAttachment(mimeType, strings.TrimSpace(attachment.Type))
 	content := &event.MessageEventContent{
 		MsgType:  msgType,
 		Body:     fileName,
-		URL:      uploadResp.ContentURI.CUString(),
+		URL:      contentURI.CUString(),
 		FileName: fileName,
 		Info: &event.FileInfo{
 			MimeType: mimeType,
-			Size:     int(stat.Size()),
+			Size:     int(size),
 		},
 	}
 	if attachment.Size.Width > 0 || attachment.Size.Height > 0 {
@@ -694,9 +909,53 @@ func (s *Server) buildAttachmentMessageContent(ctx context.Context, attachment *
 	if duration > 0 {
 		content.Info.Duration = int(duration * 1000)
 	}
+	if msgType == event.MsgAudio && (content.Info.Duration > 0 || len(meta.Waveform) > 0) {
+		content.MSC1767Audio = &event.MSC1767Audio{Duration: content.Info.Duration, Waveform: meta.Waveform}
+	}
+	if strings.TrimSpace(attachment.Type) == "voiceNote" {
+		content.MSC3245Voice = &event.MSC3245Voice{}
+	}
+	if meta.IsAnimated {
+		content.Info.IsAnimated = true
+	}
+	if msgType == "m.sticker" {
+		// Clients size stickers from the info block, so fall back to the
+		// dimensions measured at upload.
+		if content.Info.Width == 0 && content.Info.Height == 0 {
+			content.Info.Width, content.Info.Height = meta.Width, meta.Height
+		}
+		if meta.IsAnimated {
+			s.attachStickerPoster(ctx, content, meta.FilePath)
+		}
+	}
 	return content, nil
 }
 
+// uploadStoredAsset pushes a file staged by /v1/assets/upload to the
+// homeserver's media repository.
+func (s *Server) uploadStoredAsset(ctx context.Context, meta uploadMetadata, fileName, mimeType string) (id.ContentURI, int64, error) {
+	file, err := os.Open(meta.FilePath)
+	if err != nil {
+		return id.ContentURI{}, 0, errs.Internal(fmt.Errorf("failed to open uploaded asset: %w", err))
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return id.ContentURI{}, 0, errs.Internal(fmt.Errorf("failed to stat uploaded asset: %w", err))
+	}
+
+	uploadResp, err := s.rt.Client().Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+		Content:       file,
+		ContentLength: stat.Size(),
+		ContentType:   mimeType,
+		FileName:      fileName,
+	})
+	if err != nil {
+		return id.ContentURI{}, 0, errs.Internal(fmt.Errorf("failed to upload media to Matrix: %w", err))
+	}
+	return uploadResp.ContentURI, stat.Size(), nil
+}
+
 func messageTypeFromAttachment(mimeType, hint string) event.MessageType {
 	switch hint {
 	case "sticker":
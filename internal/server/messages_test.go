@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/config"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+const testOwnUserID = id.UserID("@me:example.org")
+
+// newDBTestServer starts a runtime on an empty, migrated gomuks database and
+// pretends to be logged in as testOwnUserID, so handlers can run against
+// rows inserted by the test.
+func newDBTestServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := config.Config{StateDir: t.TempDir(), MatrixHomeserverURL: "https://matrix.beeper.com"}
+	configDir := filepath.Join(cfg.StateDir, "config")
+	if err := os.MkdirAll(configDir, 0o700); err != nil {
+		t.Fatalf("failed to create config dir: %v", err)
+	}
+	quietLogs := []byte("logging:\n  min_level: warn\n  writers:\n    - type: stdout\n      format: pretty\n")
+	if err := os.WriteFile(filepath.Join(configDir, "config.yaml"), quietLogs, 0o600); err != nil {
+		t.Fatalf("failed to write gomuks config: %v", err)
+	}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	if err = rt.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start runtime: %v", err)
+	}
+	t.Cleanup(rt.Stop)
+	rt.Client().Account = &database.Account{UserID: testOwnUserID}
+	return New(cfg, rt)
+}
+
+func insertTestRoom(t *testing.T, s *Server, roomID id.RoomID) {
+	t.Helper()
+	if err := s.rt.Client().DB.Room.CreateRow(context.Background(), roomID); err != nil {
+		t.Fatalf("failed to create room: %v", err)
+	}
+}
+
+// insertTestEvent stores an event and appends it to the room timeline.
+func insertTestEvent(t *testing.T, s *Server, evt *database.Event) {
+	t.Helper()
+	ctx := context.Background()
+	if evt.Unsigned == nil {
+		evt.Unsigned = json.RawMessage("{}")
+	}
+	if evt.Timestamp.IsZero() {
+		evt.Timestamp = jsontime.UM(time.UnixMilli(1_700_000_000_000))
+	}
+	rowID, err := s.rt.Client().DB.Event.Insert(ctx, evt)
+	if err != nil {
+		t.Fatalf("failed to insert event: %v", err)
+	}
+	if _, err = s.rt.Client().DB.Timeline.Append(ctx, evt.RoomID, []database.EventRowID{rowID}); err != nil {
+		t.Fatalf("failed to append event: %v", err)
+	}
+}
+
+func testTextEvent(roomID id.RoomID, eventID id.EventID, sender id.UserID, body string) *database.Event {
+	content, _ := json.Marshal(event.MessageEventContent{MsgType: event.MsgText, Body: body})
+	return &database.Event{
+		RoomID:  roomID,
+		ID:      eventID,
+		Sender:  sender,
+		Type:    event.EventMessage.Type,
+		Content: content,
+	}
+}
+
+func TestGetMessageReturnsHydratedMessage(t *testing.T) {
+	s := newDBTestServer(t)
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+	insertTestEvent(t, s, testTextEvent(roomID, "$hello", testOwnUserID, "hello <b>world</b>"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/chats/"+string(roomID)+"/messages/$hello", nil)
+	req.SetPathValue("chatID", string(roomID))
+	req.SetPathValue("messageID", "$hello")
+	rec := httptest.NewRecorder()
+	if err := s.getMessage(rec, req); err != nil {
+		t.Fatalf("getMessage returned error: %v", err)
+	}
+	var message compat.Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &message); err != nil {
+		t.Fatalf("failed to decode message: %v", err)
+	}
+	if message.ID != "$hello" || message.ChatID != string(roomID) || message.Text != "hello <b>world</b>" || !message.IsSender || message.Type != compat.MessageTypeText {
+		t.Fatalf("unexpected message %#v", message)
+	}
+}
+
+func TestGetMessageRejectsMessagesFromOtherChats(t *testing.T) {
+	s := newDBTestServer(t)
+	insertTestRoom(t, s, "!a:example.org")
+	insertTestRoom(t, s, "!b:example.org")
+	insertTestEvent(t, s, testTextEvent("!b:example.org", "$other", "@alice:example.org", "hi"))
+
+	cases := []struct {
+		chatID, messageID string
+		status            int
+	}{
+		{"!a:example.org", "$other", http.StatusNotFound},
+		{"!a:example.org", "$missing", http.StatusNotFound},
+		{"!missing:example.org", "$other", http.StatusNotFound},
+		{"!a:example.org", "", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/v1/chats/x/messages/y", nil)
+		req.SetPathValue("chatID", tc.chatID)
+		req.SetPathValue("messageID", tc.messageID)
+		err := s.getMessage(httptest.NewRecorder(), req)
+		var apiErr *errs.APIError
+		if !errors.As(err, &apiErr) || apiErr.Status != tc.status {
+			t.Fatalf("%s/%s: expected status %d, got %v", tc.chatID, tc.messageID, tc.status, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	oauthStaticClientID   = "easymatrix-static"
+	maxMetadataValueBytes = 16 << 10
+	metadataStoreFormat   = 1
+)
+
+var metadataNamespacePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// namespacedMetadataEntries is keyed by subject (a chat or message key), then
+// client ID, then namespace.
+type namespacedMetadataEntries map[string]map[string]map[string]json.RawMessage
+
+type namespacedMetadataStore struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	replica replicaFile
+	entries namespacedMetadataEntries
+}
+
+type namespacedMetadataPersisted struct {
+	Version int                       `json:"version"`
+	Entries namespacedMetadataEntries `json:"entries"`
+}
+
+func newNamespacedMetadataStore(path string) *namespacedMetadataStore {
+	return &namespacedMetadataStore{path: path}
+}
+
+func (c *namespacedMetadataStore) loadLocked() error {
+	stamp, changed, err := c.replica.changed(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read chat metadata: %w", err)
+	}
+	if c.loaded && !changed {
+		return nil
+	}
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.entries, c.replica.seen, c.loaded = make(namespacedMetadataEntries), stamp, true
+			return nil
+		}
+		return fmt.Errorf("failed to read chat metadata: %w", err)
+	}
+	var persisted namespacedMetadataPersisted
+	if err = json.Unmarshal(raw, &persisted); err != nil {
+		return fmt.Errorf("failed to parse chat metadata: %w", err)
+	}
+	if persisted.Version != metadataStoreFormat {
+		return fmt.Errorf("unsupported chat metadata version: %d", persisted.Version)
+	}
+	if persisted.Entries == nil {
+		persisted.Entries = make(namespacedMetadataEntries)
+	}
+	c.entries, c.replica.seen, c.loaded = persisted.Entries, stamp, true
+	return nil
+}
+
+func (c *namespacedMetadataStore) get(subject, clientID, namespace string) (json.RawMessage, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return nil, false, err
+	}
+	value, ok := c.entries[subject][clientID][namespace]
+	return value, ok, nil
+}
+
+// forClient returns a copy of every namespace the client stored on a subject.
+func (c *namespacedMetadataStore) forClient(subject, clientID string) (map[string]json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return nil, err
+	}
+	stored := c.entries[subject][clientID]
+	if len(stored) == 0 {
+		return nil, nil
+	}
+	output := make(map[string]json.RawMessage, len(stored))
+	for namespace, value := range stored {
+		output[namespace] = value
+	}
+	return output, nil
+}
+
+// set stores value, or deletes the namespace when value is JSON null.
+func (c *namespacedMetadataStore) set(subject, clientID, namespace string, value json.RawMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return err
+	}
+	// Change a copy of the path to the entry and swap it in only once it is
+	// on disk, so a failed write leaves memory matching the file.
+	entries := maps.Clone(c.entries)
+	if entries == nil {
+		entries = make(namespacedMetadataEntries)
+	}
+	subjectEntries := maps.Clone(entries[subject])
+	if subjectEntries == nil {
+		subjectEntries = make(map[string]map[string]json.RawMessage)
+	}
+	clientEntries := maps.Clone(subjectEntries[clientID])
+	if clientEntries == nil {
+		clientEntries = make(map[string]json.RawMessage)
+	}
+	if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		delete(clientEntries, namespace)
+	} else {
+		clientEntries[namespace] = value
+	}
+	if len(clientEntries) == 0 {
+		delete(subjectEntries, clientID)
+	} else {
+		subjectEntries[clientID] = clientEntries
+	}
+	if len(subjectEntries) == 0 {
+		delete(entries, subject)
+	} else {
+		entries[subject] = subjectEntries
+	}
+	raw, err := json.Marshal(namespacedMetadataPersisted{Version: metadataStoreFormat, Entries: entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode chat metadata: %w", err)
+	}
+	if err = writeAtomicFile(c.path, raw, 0o600); err != nil {
+		return err
+	}
+	c.entries = entries
+	return nil
+}
+
+// requestClientID identifies the OAuth client behind a request. The static
+// access token has no OAuth client and is treated as its own namespace owner.
+func requestClientID(r *http.Request) string {
+	info := mcpauth.TokenInfoFromContext(r.Context())
+	if info != nil {
+		if clientID, ok := info.Extra["client_id"].(string); ok && strings.TrimSpace(clientID) != "" {
+			return clientID
+		}
+	}
+	return oauthStaticClientID
+}
+
+func readMetadataNamespace(r *http.Request) (string, error) {
+	namespace := strings.TrimSpace(r.PathValue("namespace"))
+	if !metadataNamespacePattern.MatchString(namespace) {
+		return "", errs.Validation(map[string]any{"namespace": "must be 1-64 characters of letters, digits, '.', '_' or '-'"})
+	}
+	return namespace, nil
+}
+
+func (s *Server) getChatMetadata(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	return writeMetadataValue(w, r, s.chatMetadata, chatID)
+}
+
+func (s *Server) setChatMetadata(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	if _, err := s.loadChatRoom(r.Context(), chatID); err != nil {
+		return err
+	}
+	return storeMetadataValue(w, r, s.chatMetadata, chatID)
+}
+
+func writeMetadataValue(w http.ResponseWriter, r *http.Request, store *namespacedMetadataStore, subject string) error {
+	namespace, err := readMetadataNamespace(r)
+	if err != nil {
+		return err
+	}
+	value, ok, err := store.get(subject, requestClientID(r), namespace)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	if !ok {
+		return errs.NotFound("Metadata not found")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(value)
+	return err
+}
+
+// storeMetadataValue saves the request body under the namespace in the path.
+// A JSON null body deletes the namespace.
+func storeMetadataValue(w http.ResponseWriter, r *http.Request, store *namespacedMetadataStore, subject string) error {
+	namespace, err := readMetadataNamespace(r)
+	if err != nil {
+		return err
+	}
+	var value json.RawMessage
+	if err = decodeJSON(r, &value); err != nil {
+		return err
+	}
+	if len(value) > maxMetadataValueBytes {
+		return errs.Validation(map[string]any{"body": fmt.Sprintf("metadata must be at most %d bytes", maxMetadataValueBytes)})
+	}
+	compacted := new(bytes.Buffer)
+	if err = json.Compact(compacted, value); err != nil {
+		return errs.Validation(map[string]any{"body": err.Error()})
+	}
+	if err = store.set(subject, requestClientID(r), namespace, compacted.Bytes()); err != nil {
+		return errs.Internal(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(compacted.Bytes())
+	return err
+}
+
+// attachChatMetadata fills Chat.Metadata for the calling client when the
+// request opts in with includeMetadata=true.
+func (s *Server) attachChatMetadata(r *http.Request, chats []compat.Chat) error {
+	include, err := parseOptionalBool(r.URL.Query().Get("includeMetadata"), false, "includeMetadata")
+	if err != nil || !include {
+		return err
+	}
+	clientID := requestClientID(r)
+	for idx := range chats {
+		metadata, loadErr := s.chatMetadata.forClient(chats[idx].ID, clientID)
+		if loadErr != nil {
+			return errs.Internal(loadErr)
+		}
+		chats[idx].Metadata = metadata
+	}
+	return nil
+}
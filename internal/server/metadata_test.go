@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+)
+
+func TestChatMetadataStoreScopesByClientAndPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat-metadata.json")
+	store := newNamespacedMetadataStore(path)
+	if err := store.set("!chat:example.org", "crm", "ticket", json.RawMessage(`{"id":42}`)); err != nil {
+		t.Fatalf("set returned error: %v", err)
+	}
+	if _, ok, _ := store.get("!chat:example.org", "other-client", "ticket"); ok {
+		t.Fatal("expected metadata to be scoped to the writing client")
+	}
+
+	reloaded := newNamespacedMetadataStore(path)
+	value, ok, err := reloaded.get("!chat:example.org", "crm", "ticket")
+	if err != nil || !ok || string(value) != `{"id":42}` {
+		t.Fatalf("expected persisted metadata, got %s (%v, %v)", value, ok, err)
+	}
+
+	if err = reloaded.set("!chat:example.org", "crm", "ticket", json.RawMessage(`null`)); err != nil {
+		t.Fatalf("delete returned error: %v", err)
+	}
+	if metadata, _ := reloaded.forClient("!chat:example.org", "crm"); metadata != nil {
+		t.Fatalf("expected metadata to be removed, got %#v", metadata)
+	}
+}
+
+func TestChatMetadataStoreKeepsMemoryUnchangedWhenSaveFails(t *testing.T) {
+	dir := t.TempDir()
+	store := newNamespacedMetadataStore(filepath.Join(dir, "chat-metadata.json"))
+	if err := store.set("!chat:example.org", "crm", "ticket", json.RawMessage(`{"id":1}`)); err != nil {
+		t.Fatalf("set returned error: %v", err)
+	}
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatalf("failed to create blocker: %v", err)
+	}
+	store.path = filepath.Join(blocker, "chat-metadata.json")
+	if err := store.set("!chat:example.org", "crm", "ticket", json.RawMessage(`{"id":2}`)); err == nil {
+		t.Fatal("expected set to fail when the store cannot be written")
+	}
+	if err := store.set("!chat:example.org", "crm", "ticket", json.RawMessage(`null`)); err == nil {
+		t.Fatal("expected delete to fail when the store cannot be written")
+	}
+	value, ok, err := store.get("!chat:example.org", "crm", "ticket")
+	if err != nil || !ok || string(value) != `{"id":1}` {
+		t.Fatalf("expected the saved value to remain, got %s (%v, %v)", value, ok, err)
+	}
+}
+
+func TestRequestClientIDFallsBackToStaticClient(t *testing.T) {
+	req := httptest.NewRequest("GET", "/v1/chats", nil)
+	if got := requestClientID(req); got != oauthStaticClientID {
+		t.Fatalf("expected static client ID, got %q", got)
+	}
+	verifier := func(context.Context, string, *http.Request) (*mcpauth.TokenInfo, error) {
+		return &mcpauth.TokenInfo{Expiration: time.Now().Add(time.Hour), Extra: map[string]any{"client_id": "crm"}}, nil
+	}
+	var got string
+	handler := mcpauth.RequireBearerToken(verifier, nil)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = requestClientID(r)
+	}))
+	req.Header.Set("Authorization", "Bearer token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "crm" {
+		t.Fatalf("expected OAuth client ID, got %q", got)
+	}
+}
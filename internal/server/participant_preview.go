@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+// participantPreviewActivityWindow is how many of the latest timeline events
+// decide who spoke recently. Members quiet for longer sort by user ID.
+const participantPreviewActivityWindow = 500
+
+// participantPreviewQuery picks the joined and invited members who spoke most
+// recently, using the membership column of current_state so only the rows
+// returned have their member content decoded. Activity only looks at the
+// newest timeline rows, which the room_id index serves without touching the
+// rest of the room's history.
+const participantPreviewQuery = `
+	SELECT cs.state_key, member.content
+	FROM current_state cs
+	JOIN event member ON member.rowid = cs.event_rowid
+	LEFT JOIN (
+		SELECT sender, MAX(timestamp) AS last_ts
+		FROM (
+			SELECT event.sender, event.timestamp
+			FROM timeline
+			JOIN event ON event.rowid = timeline.event_rowid
+			WHERE timeline.room_id = $1
+			ORDER BY timeline.rowid DESC
+			LIMIT $3
+		)
+		GROUP BY sender
+	) activity ON activity.sender = cs.state_key
+	WHERE cs.room_id = $1 AND cs.event_type = 'm.room.member' AND cs.membership IN ('join', 'invite')
+	ORDER BY COALESCE(activity.last_ts, 0) DESC, cs.state_key
+	LIMIT $2
+`
+
+const participantCountQuery = `
+	SELECT COUNT(*)
+	FROM current_state
+	WHERE room_id = $1 AND event_type = 'm.room.member' AND membership IN ('join', 'invite')
+`
+
+// loadParticipantPreview is loadRoomParticipants for chat listings, which only
+// show a handful of members. Rooms with thousands of members would otherwise
+// decode every member event per chat. The full, name-sorted list stays with
+// the participants endpoint.
+func (s *Server) loadParticipantPreview(ctx context.Context, room *database.Room, limit int) ([]compat.User, int, error) {
+	cli := s.rt.Client()
+	var total int
+	if err := cli.DB.QueryRow(ctx, participantCountQuery, room.ID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count participants: %w", err)
+	}
+	users := make([]compat.User, 0, min(limit, total))
+	if limit == 0 || total == 0 {
+		return users, total, nil
+	}
+	rows, err := cli.DB.Query(ctx, participantPreviewQuery, room.ID, limit, participantPreviewActivityWindow)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query participant preview: %w", err)
+	}
+	defer rows.Close()
+	selfID := string(cli.Account.UserID)
+	for rows.Next() {
+		var (
+			userID  string
+			content []byte
+		)
+		if err = rows.Scan(&userID, &content); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan participant: %w", err)
+		}
+		var member event.MemberEventContent
+		if json.Unmarshal(content, &member) != nil {
+			continue
+		}
+		users = append(users, userFromMemberEvent(ctx, userID, member, selfID))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("participant preview query failed: %w", err)
+	}
+	return users, total, nil
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func insertTestMember(t *testing.T, s *Server, roomID id.RoomID, userID id.UserID, membership event.Membership) {
+	t.Helper()
+	ctx := context.Background()
+	content, _ := json.Marshal(event.MemberEventContent{Membership: membership, Displayname: string(userID)})
+	stateKey := string(userID)
+	rowID, err := s.rt.Client().DB.Event.Insert(ctx, &database.Event{
+		RoomID:   roomID,
+		ID:       id.EventID("$member-" + stateKey),
+		Sender:   userID,
+		Type:     event.StateMember.Type,
+		StateKey: &stateKey,
+		Content:  content,
+		Unsigned: json.RawMessage("{}"),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert member event: %v", err)
+	}
+	if err = s.rt.Client().DB.CurrentState.Set(ctx, roomID, event.StateMember, stateKey, rowID, membership); err != nil {
+		t.Fatalf("failed to set member state: %v", err)
+	}
+}
+
+func previewUserIDs(t *testing.T, s *Server, roomID id.RoomID, limit int) ([]string, int) {
+	t.Helper()
+	users, total, err := s.loadParticipantPreview(context.Background(), &database.Room{ID: roomID}, limit)
+	if err != nil {
+		t.Fatalf("loadParticipantPreview returned error: %v", err)
+	}
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids, total
+}
+
+func TestLoadParticipantPreviewOrdersByRecentActivity(t *testing.T) {
+	s := newDBTestServer(t)
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+	for _, userID := range []id.UserID{"@alice:example.org", "@bob:example.org", "@carol:example.org"} {
+		insertTestMember(t, s, roomID, userID, event.MembershipJoin)
+	}
+	insertTestMember(t, s, roomID, "@dave:example.org", event.MembershipLeave)
+
+	base := time.UnixMilli(1_700_000_000_000)
+	for idx, sender := range []id.UserID{"@alice:example.org", "@carol:example.org", "@dave:example.org"} {
+		evt := testTextEvent(roomID, id.EventID("$msg"+string(rune('a'+idx))), sender, "hi")
+		evt.Timestamp = jsontime.UM(base.Add(time.Duration(idx) * time.Minute))
+		insertTestEvent(t, s, evt)
+	}
+
+	ids, total := previewUserIDs(t, s, roomID, 2)
+	if total != 3 || !slices.Equal(ids, []string{"@carol:example.org", "@alice:example.org"}) {
+		t.Fatalf("unexpected preview %v (total %d)", ids, total)
+	}
+	if ids, _ = previewUserIDs(t, s, roomID, 0); len(ids) != 0 {
+		t.Fatalf("expected empty preview for limit 0, got %v", ids)
+	}
+}
+
+func TestLoadParticipantPreviewIgnoresActivityOutsideWindow(t *testing.T) {
+	s := newDBTestServer(t)
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+	insertTestMember(t, s, roomID, "@alice:example.org", event.MembershipJoin)
+	insertTestMember(t, s, roomID, "@zed:example.org", event.MembershipJoin)
+
+	// zed's only message is older than the activity window, so zed sorts
+	// with the members who never spoke.
+	old := testTextEvent(roomID, "$old", "@zed:example.org", "long ago")
+	old.Timestamp = jsontime.UM(time.UnixMilli(1_600_000_000_000))
+	insertTestEvent(t, s, old)
+	for idx := range participantPreviewActivityWindow {
+		evt := testTextEvent(roomID, id.EventID("$filler"+strconv.Itoa(idx)), "@bot:example.org", "noise")
+		insertTestEvent(t, s, evt)
+	}
+
+	ids, _ := previewUserIDs(t, s, roomID, 2)
+	if !slices.Equal(ids, []string{"@alice:example.org", "@zed:example.org"}) {
+		t.Fatalf("expected members outside the window to sort by ID, got %v", ids)
+	}
+}
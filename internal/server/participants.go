@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/cursor"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	participantsDefaultLimit = 50
+	participantsMaxLimit     = 500
+)
+
+// participantActivityQuery finds each sender's latest event in a room, for
+// ordering participants by who talks there.
+const participantActivityQuery = `
+	SELECT sender, MAX(timestamp)
+	FROM event
+	WHERE room_id = $1
+	GROUP BY sender
+`
+
+// participantCursor is the sort key of the last participant on a page.
+// Paging by key rather than index keeps pages stable while members join or
+// leave between requests. LastActiveAt is only set with orderBy=activity.
+type participantCursor struct {
+	LastActiveAt int64  `json:"lastActiveAt,omitempty"`
+	FullName     string `json:"fullName"`
+	ID           string `json:"id"`
+}
+
+// participantKey builds the sort key of a user. A nil activity map orders by
+// name alone; members who never sent anything sort after everyone who did.
+func participantKey(user compat.User, activity map[string]int64) participantCursor {
+	return participantCursor{LastActiveAt: activity[user.ID], FullName: user.FullName, ID: user.ID}
+}
+
+func (c participantCursor) less(other participantCursor) bool {
+	if c.LastActiveAt != other.LastActiveAt {
+		return c.LastActiveAt > other.LastActiveAt
+	}
+	if c.FullName != other.FullName {
+		return c.FullName < other.FullName
+	}
+	return c.ID < other.ID
+}
+
+func sortParticipants(users []compat.User, activity map[string]int64) {
+	sort.SliceStable(users, func(i, j int) bool {
+		return participantKey(users[i], activity).less(participantKey(users[j], activity))
+	})
+}
+
+func participantMatches(user compat.User, query string) bool {
+	if query == "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(user.FullName), query) || strings.Contains(strings.ToLower(user.ID), query)
+}
+
+// pageParticipants expects users in sortParticipants order for the same
+// activity map.
+func pageParticipants(users []compat.User, query string, after *participantCursor, limit int, activity map[string]int64) ([]compat.User, int, bool) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	page := make([]compat.User, 0, limit)
+	total := 0
+	hasMore := false
+	for _, user := range users {
+		if !participantMatches(user, query) {
+			continue
+		}
+		total++
+		if after != nil && !after.less(participantKey(user, activity)) {
+			continue
+		}
+		if len(page) == limit {
+			hasMore = true
+			continue
+		}
+		page = append(page, user)
+	}
+	return page, total, hasMore
+}
+
+func (s *Server) listParticipants(w http.ResponseWriter, r *http.Request) error {
+	limit, err := parseOptionalLimit(r.URL.Query().Get("limit"), participantsDefaultLimit, 1, participantsMaxLimit, "limit")
+	if err != nil {
+		return err
+	}
+	var after *participantCursor
+	if raw := strings.TrimSpace(r.URL.Query().Get("cursor")); raw != "" {
+		after = &participantCursor{}
+		if err = cursor.Decode(raw, after); err != nil {
+			return errs.Validation(map[string]any{"cursor": err.Error()})
+		}
+	}
+	orderBy := strings.TrimSpace(r.URL.Query().Get("orderBy"))
+	if orderBy != "" && orderBy != "name" && orderBy != "activity" {
+		return errs.Validation(map[string]any{"orderBy": "must be one of: name, activity"})
+	}
+	room, err := s.loadChatRoom(r.Context(), readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	participants, _ := s.loadRoomParticipants(r.Context(), room)
+	var activity map[string]int64
+	if orderBy == "activity" {
+		if activity, err = s.loadParticipantActivity(r.Context(), room.ID); err != nil {
+			return err
+		}
+		sortParticipants(participants, activity)
+	}
+	page, total, hasMore := pageParticipants(participants, r.URL.Query().Get("query"), after, limit, activity)
+
+	output := compat.ListParticipantsOutput{Items: page, HasMore: hasMore, Total: int64(total)}
+	if hasMore {
+		last := page[len(page)-1]
+		encoded, encodeErr := cursor.Encode(participantKey(last, activity))
+		if encodeErr != nil {
+			return errs.Internal(encodeErr)
+		}
+		output.NextCursor = &encoded
+	}
+	return writeJSON(w, output)
+}
+
+func (s *Server) loadParticipantActivity(ctx context.Context, roomID id.RoomID) (map[string]int64, error) {
+	rows, err := s.rt.Client().DB.Query(ctx, participantActivityQuery, roomID)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query participant activity: %w", err))
+	}
+	defer rows.Close()
+	activity := make(map[string]int64)
+	for rows.Next() {
+		var (
+			sender   string
+			lastSeen int64
+		)
+		if err = rows.Scan(&sender, &lastSeen); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan participant activity: %w", err))
+		}
+		activity[sender] = lastSeen
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("participant activity query failed: %w", err))
+	}
+	return activity, nil
+}
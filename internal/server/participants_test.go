@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestPageParticipantsFiltersAndResumesAfterCursor(t *testing.T) {
+	users := []compat.User{
+		{ID: "@a:example.org", FullName: "Alice"},
+		{ID: "@b:example.org", FullName: "Bob"},
+		{ID: "@c:example.org", FullName: "Bobby"},
+		{ID: "@d:example.org", FullName: "Dana"},
+	}
+	page, total, hasMore := pageParticipants(users, "bob", nil, 1, nil)
+	if total != 2 || !hasMore || len(page) != 1 || page[0].ID != "@b:example.org" {
+		t.Fatalf("unexpected first page %v total=%d hasMore=%v", page, total, hasMore)
+	}
+	page, _, hasMore = pageParticipants(users, "bob", &participantCursor{FullName: "Bob", ID: "@b:example.org"}, 1, nil)
+	if hasMore || len(page) != 1 || page[0].ID != "@c:example.org" {
+		t.Fatalf("unexpected second page %v hasMore=%v", page, hasMore)
+	}
+}
+
+func TestSortParticipantsByActivity(t *testing.T) {
+	users := []compat.User{
+		{ID: "@a:example.org", FullName: "Alice"},
+		{ID: "@b:example.org", FullName: "Bob"},
+		{ID: "@c:example.org", FullName: "Carol"},
+	}
+	activity := map[string]int64{"@b:example.org": 200, "@c:example.org": 100}
+	sortParticipants(users, activity)
+	if users[0].ID != "@b:example.org" || users[1].ID != "@c:example.org" || users[2].ID != "@a:example.org" {
+		t.Fatalf("unexpected order %v", users)
+	}
+	page, _, hasMore := pageParticipants(users, "", &participantCursor{LastActiveAt: 200, FullName: "Bob", ID: "@b:example.org"}, 1, activity)
+	if !hasMore || len(page) != 1 || page[0].ID != "@c:example.org" {
+		t.Fatalf("unexpected page after cursor %v", page)
+	}
+}
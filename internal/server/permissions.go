@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// Power levels behind the simplified roles; these match what Element and
+// Beeper show as Admin and Moderator.
+const (
+	powerLevelAdmin     = 100
+	powerLevelModerator = 50
+	powerLevelMember    = 0
+)
+
+// permissionActionEvents maps state/message actions onto the event type whose
+// power level governs them. invite/kick/ban/redact are top-level fields.
+var permissionActionEvents = map[string]event.Type{
+	"sendMessages":      event.EventMessage,
+	"changeTitle":       event.StateRoomName,
+	"changeTopic":       event.StateTopic,
+	"changeAvatar":      event.StateRoomAvatar,
+	"changePermissions": event.StatePowerLevels,
+}
+
+var permissionActionNames = []string{"sendMessages", "invite", "kick", "ban", "redact", "changeTitle", "changeTopic", "changeAvatar", "changePermissions"}
+
+func roleForPowerLevel(level int) compat.ChatRole {
+	switch {
+	case level >= powerLevelAdmin:
+		return compat.ChatRoleAdmin
+	case level >= powerLevelModerator:
+		return compat.ChatRoleModerator
+	default:
+		return compat.ChatRoleMember
+	}
+}
+
+func powerLevelForRole(role compat.ChatRole) (int, bool) {
+	switch role {
+	case compat.ChatRoleAdmin:
+		return powerLevelAdmin, true
+	case compat.ChatRoleModerator:
+		return powerLevelModerator, true
+	case compat.ChatRoleMember:
+		return powerLevelMember, true
+	default:
+		return 0, false
+	}
+}
+
+func actionPowerLevel(pl *event.PowerLevelsEventContent, action string) int {
+	switch action {
+	case "invite":
+		return pl.Invite()
+	case "kick":
+		return pl.Kick()
+	case "ban":
+		return pl.Ban()
+	case "redact":
+		return pl.Redact()
+	default:
+		return pl.GetEventLevel(permissionActionEvents[action])
+	}
+}
+
+func setActionPowerLevel(pl *event.PowerLevelsEventContent, action string, level int) {
+	switch action {
+	case "invite":
+		pl.InvitePtr = &level
+	case "kick":
+		pl.KickPtr = &level
+	case "ban":
+		pl.BanPtr = &level
+	case "redact":
+		pl.RedactPtr = &level
+	default:
+		pl.SetEventLevel(permissionActionEvents[action], level)
+	}
+}
+
+func chatPermissionsFromPowerLevels(pl *event.PowerLevelsEventContent, selfUserID id.UserID) compat.ChatPermissions {
+	output := compat.ChatPermissions{
+		Role:    roleForPowerLevel(pl.GetUserLevel(selfUserID)),
+		Users:   make([]compat.ChatMemberPermission, 0, len(pl.Users)),
+		Actions: make(map[string]compat.ChatRole, len(permissionActionNames)),
+	}
+	for userID, level := range pl.Users {
+		if level <= pl.UsersDefault {
+			continue
+		}
+		output.Users = append(output.Users, compat.ChatMemberPermission{
+			UserID:     string(userID),
+			Role:       roleForPowerLevel(level),
+			PowerLevel: level,
+		})
+	}
+	sort.Slice(output.Users, func(i, j int) bool {
+		if output.Users[i].PowerLevel != output.Users[j].PowerLevel {
+			return output.Users[i].PowerLevel > output.Users[j].PowerLevel
+		}
+		return output.Users[i].UserID < output.Users[j].UserID
+	})
+	for _, action := range permissionActionNames {
+		output.Actions[action] = roleForPowerLevel(actionPowerLevel(pl, action))
+	}
+	return output
+}
+
+// applyPermissionsUpdate mutates pl according to input. Members demoted to
+// "member" are dropped from the users map when the room default already
+// grants no privileges, keeping the event small.
+func applyPermissionsUpdate(pl *event.PowerLevelsEventContent, input compat.UpdateChatPermissionsInput) error {
+	for rawUserID, role := range input.Users {
+		userID := id.UserID(strings.TrimSpace(rawUserID))
+		if _, _, err := userID.Parse(); err != nil {
+			return errs.Validation(map[string]any{"users": fmt.Sprintf("invalid user ID %q", rawUserID)})
+		}
+		level, ok := powerLevelForRole(role)
+		if !ok {
+			return errs.Validation(map[string]any{"users." + string(userID): "must be one of: admin, moderator, member"})
+		}
+		if level == powerLevelMember && pl.UsersDefault <= powerLevelMember {
+			delete(pl.Users, userID)
+			continue
+		}
+		pl.SetUserLevel(userID, level)
+	}
+	for action, role := range input.Actions {
+		if !slices.Contains(permissionActionNames, action) {
+			return errs.Validation(map[string]any{"actions": fmt.Sprintf("unknown action %q; must be one of: %s", action, strings.Join(permissionActionNames, ", "))})
+		}
+		level, ok := powerLevelForRole(role)
+		if !ok {
+			return errs.Validation(map[string]any{"actions." + action: "must be one of: admin, moderator, member"})
+		}
+		setActionPowerLevel(pl, action, level)
+	}
+	return nil
+}
+
+func (s *Server) loadPowerLevels(ctx context.Context, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	evt, err := s.rt.Client().DB.CurrentState.Get(ctx, roomID, event.StatePowerLevels, "")
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to read power levels: %w", err))
+	}
+	pl := &event.PowerLevelsEventContent{}
+	if evt == nil {
+		return pl, nil
+	}
+	if err = json.Unmarshal(evt.GetContent(), pl); err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to parse power levels: %w", err))
+	}
+	return pl, nil
+}
+
+func (s *Server) getChatPermissions(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	room, err := s.loadChatRoom(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
+	pl, err := s.loadPowerLevels(r.Context(), room.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, chatPermissionsFromPowerLevels(pl, s.rt.Client().Account.UserID))
+}
+
+func (s *Server) updateChatPermissions(w http.ResponseWriter, r *http.Request) error {
+	var req compat.UpdateChatPermissionsInput
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	if len(req.Users) == 0 && len(req.Actions) == 0 {
+		return errs.Validation(map[string]any{"users": "at least one of users or actions is required"})
+	}
+	room, err := s.loadChatRoom(r.Context(), chatID)
+	if err != nil {
+		return err
+	}
+	pl, err := s.loadPowerLevels(r.Context(), room.ID)
+	if err != nil {
+		return err
+	}
+	if err = applyPermissionsUpdate(pl, req); err != nil {
+		return err
+	}
+	if _, err = s.rt.Client().Client.SendStateEvent(r.Context(), room.ID, event.StatePowerLevels, "", pl); err != nil {
+		return membershipActionError(err, "change permissions in this chat")
+	}
+	return writeJSON(w, chatPermissionsFromPowerLevels(pl, s.rt.Client().Account.UserID))
+}
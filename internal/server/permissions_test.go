@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestApplyPermissionsUpdateTransfersAdmin(t *testing.T) {
+	pl := &event.PowerLevelsEventContent{Users: map[id.UserID]int{"@owner:example.org": 100, "@mod:example.org": 50}}
+	err := applyPermissionsUpdate(pl, compat.UpdateChatPermissionsInput{
+		Users:   map[string]compat.ChatRole{"@new:example.org": compat.ChatRoleAdmin, "@owner:example.org": compat.ChatRoleMember},
+		Actions: map[string]compat.ChatRole{"invite": compat.ChatRoleModerator, "changeTitle": compat.ChatRoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("applyPermissionsUpdate returned error: %v", err)
+	}
+	permissions := chatPermissionsFromPowerLevels(pl, "@owner:example.org")
+	if permissions.Role != compat.ChatRoleMember {
+		t.Fatalf("expected previous owner to be a member, got %q", permissions.Role)
+	}
+	if len(permissions.Users) != 2 || permissions.Users[0].UserID != "@new:example.org" || permissions.Users[0].Role != compat.ChatRoleAdmin {
+		t.Fatalf("unexpected users %#v", permissions.Users)
+	}
+	if permissions.Actions["invite"] != compat.ChatRoleModerator || permissions.Actions["changeTitle"] != compat.ChatRoleAdmin {
+		t.Fatalf("unexpected actions %#v", permissions.Actions)
+	}
+}
+
+func TestApplyPermissionsUpdateRejectsUnknownValues(t *testing.T) {
+	inputs := []compat.UpdateChatPermissionsInput{
+		{Users: map[string]compat.ChatRole{"@a:example.org": "owner"}},
+		{Users: map[string]compat.ChatRole{"not-a-user": compat.ChatRoleAdmin}},
+		{Actions: map[string]compat.ChatRole{"deleteChat": compat.ChatRoleAdmin}},
+	}
+	for _, input := range inputs {
+		if err := applyPermissionsUpdate(&event.PowerLevelsEventContent{}, input); err == nil {
+			t.Fatalf("expected %#v to be rejected", input)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pluginEventQueueSize      = 256
+	pluginMaxLineBytes        = 1024 * 1024
+	pluginCommandTimeout      = 30 * time.Second
+	pluginRestartMinBackoff   = time.Second
+	pluginRestartMaxBackoff   = time.Minute
+	pluginHealthyRuntime      = time.Minute
+	pluginCommandSendMessage  = "message.send"
+	pluginCommandAddLabel     = "label.add"
+	pluginCommandResultType   = "command.result"
+	pluginErrorInvalidCommand = "INVALID_COMMAND"
+)
+
+type pluginConfig struct {
+	Name    string            `json:"name"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Dir     string            `json:"dir,omitempty"`
+}
+
+type pluginsFile struct {
+	Plugins []pluginConfig `json:"plugins"`
+}
+
+// pluginCommand is a single NDJSON line a plugin writes to stdout.
+type pluginCommand struct {
+	Type             string `json:"type"`
+	RequestID        string `json:"requestID,omitempty"`
+	ChatID           string `json:"chatID"`
+	Text             string `json:"text,omitempty"`
+	ReplyToMessageID string `json:"replyToMessageID,omitempty"`
+	Label            string `json:"label,omitempty"`
+}
+
+type pluginCommandResult struct {
+	Type             string `json:"type"`
+	RequestID        string `json:"requestID,omitempty"`
+	Success          bool   `json:"success"`
+	PendingMessageID string `json:"pendingMessageID,omitempty"`
+	Code             string `json:"code,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// pluginProcess supervises one external plugin. Domain events are written to
+// the child's stdin as NDJSON and commands are read back from its stdout.
+type pluginProcess struct {
+	server *Server
+	cfg    pluginConfig
+
+	mu  sync.Mutex
+	out chan any
+	seq int
+}
+
+func loadPluginsFile(path string) ([]pluginConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugins file: %w", err)
+	}
+	var parsed pluginsFile
+	if err = json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse plugins file: %w", err)
+	}
+	plugins := make([]pluginConfig, 0, len(parsed.Plugins))
+	for idx, plugin := range parsed.Plugins {
+		plugin.Command = strings.TrimSpace(plugin.Command)
+		if plugin.Command == "" {
+			return nil, fmt.Errorf("plugins[%d]: command is required", idx)
+		}
+		if strings.TrimSpace(plugin.Name) == "" {
+			plugin.Name = plugin.Command
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+func (s *Server) startPlugins(ctx context.Context) error {
+	path := strings.TrimSpace(s.cfg.PluginsFile)
+	if path == "" {
+		return nil
+	}
+	plugins, err := loadPluginsFile(path)
+	if err != nil {
+		return err
+	}
+	if len(plugins) == 0 {
+		return nil
+	}
+	if err = s.ws.ensureSubscription(); err != nil {
+		return err
+	}
+	for _, cfg := range plugins {
+		process := &pluginProcess{server: s, cfg: cfg}
+		s.ws.addListener(process.deliver)
+		go process.supervise(ctx)
+	}
+	return nil
+}
+
+func (p *pluginProcess) supervise(ctx context.Context) {
+	backoff := pluginRestartMinBackoff
+	for {
+		startedAt := time.Now()
+		err := p.runOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(startedAt) >= pluginHealthyRuntime {
+			backoff = pluginRestartMinBackoff
+		}
+		log.Printf("plugin %s exited (%v), restarting in %s", p.cfg.Name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pluginRestartMaxBackoff)
+	}
+}
+
+func (p *pluginProcess) runOnce(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, p.cfg.Command, p.cfg.Args...)
+	cmd.Dir = p.cfg.Dir
+	cmd.Env = os.Environ()
+	for key, value := range p.cfg.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Stderr = &pluginLogWriter{name: p.cfg.Name}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	out := make(chan any, pluginEventQueueSize)
+	done := make(chan struct{})
+	p.setOutput(out)
+	go p.writeLoop(stdin, out, done)
+
+	p.readLoop(ctx, stdout, out, done)
+	p.setOutput(nil)
+	close(done)
+	_ = stdin.Close()
+	return cmd.Wait()
+}
+
+func (p *pluginProcess) setOutput(out chan any) {
+	p.mu.Lock()
+	p.out = out
+	p.mu.Unlock()
+}
+
+func (p *pluginProcess) deliver(domainEvent wsDomainEvent, entries []compatRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.out == nil {
+		return
+	}
+	p.seq++
+	payload := wsDomainEventMessage{
+		Type:    domainEvent.Type,
+		Seq:     p.seq,
+		TS:      time.Now().UnixMilli(),
+		ChatID:  domainEvent.ChatID,
+		IDs:     domainEvent.IDs,
+		Entries: entries,
+	}
+	select {
+	case p.out <- payload:
+	default:
+		log.Printf("plugin %s is not keeping up, dropping %s for %s", p.cfg.Name, domainEvent.Type, domainEvent.ChatID)
+	}
+}
+
+func (p *pluginProcess) writeLoop(stdin io.Writer, out <-chan any, done <-chan struct{}) {
+	encoder := json.NewEncoder(stdin)
+	for {
+		select {
+		case <-done:
+			return
+		case payload := <-out:
+			if err := encoder.Encode(payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (p *pluginProcess) readLoop(ctx context.Context, stdout io.Reader, out chan<- any, done <-chan struct{}) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), pluginMaxLineBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		result := p.handleCommand(ctx, []byte(line))
+		select {
+		case out <- result:
+		case <-done:
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, os.ErrClosed) {
+		log.Printf("plugin %s stdout read failed: %v", p.cfg.Name, err)
+	}
+}
+
+func (p *pluginProcess) handleCommand(ctx context.Context, line []byte) pluginCommandResult {
+	var cmd pluginCommand
+	if err := json.Unmarshal(line, &cmd); err != nil {
+		return pluginCommandResult{Type: pluginCommandResultType, Code: pluginErrorInvalidCommand, Error: "invalid JSON command"}
+	}
+	result := pluginCommandResult{Type: pluginCommandResultType, RequestID: cmd.RequestID}
+	if strings.TrimSpace(cmd.ChatID) == "" {
+		result.Code = pluginErrorInvalidCommand
+		result.Error = "chatID is required"
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pluginCommandTimeout)
+	defer cancel()
+
+	var err error
+	switch cmd.Type {
+	case pluginCommandSendMessage:
+		if strings.TrimSpace(cmd.Text) == "" {
+			result.Code = pluginErrorInvalidCommand
+			result.Error = "text is required"
+			return result
+		}
+		dbEvent, sendErr := p.server.sendTextMessage(ctx, cmd.ChatID, cmd.Text, cmd.ReplyToMessageID)
+		if sendErr == nil {
+			result.PendingMessageID = dbEvent.TransactionID
+			if result.PendingMessageID == "" {
+				result.PendingMessageID = string(dbEvent.ID)
+			}
+		}
+		err = sendErr
+	case pluginCommandAddLabel:
+		tag := normalizeRoomTag(cmd.Label)
+		if tag == "" {
+			result.Code = pluginErrorInvalidCommand
+			result.Error = "label is required"
+			return result
+		}
+		err = p.server.addRoomTag(ctx, cmd.ChatID, tag)
+	default:
+		result.Code = pluginErrorInvalidCommand
+		result.Error = "unsupported command type: " + cmd.Type
+		return result
+	}
+	if err != nil {
+		result.Code = wsErrorCodeInternal
+		result.Error = err.Error()
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+type pluginLogWriter struct {
+	name string
+}
+
+func (w *pluginLogWriter) Write(data []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		log.Printf("plugin %s: %s", w.name, line)
+	}
+	return len(data), nil
+}
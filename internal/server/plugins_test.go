@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPluginsFileDefaultsNameAndRequiresCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugins.json")
+	if err := os.WriteFile(path, []byte(`{"plugins":[{"command":" ./labeler "}]}`), 0o600); err != nil {
+		t.Fatalf("failed to write plugins file: %v", err)
+	}
+	plugins, err := loadPluginsFile(path)
+	if err != nil {
+		t.Fatalf("loadPluginsFile returned error: %v", err)
+	}
+	if len(plugins) != 1 || plugins[0].Command != "./labeler" || plugins[0].Name != "./labeler" {
+		t.Fatalf("unexpected plugins: %#v", plugins)
+	}
+
+	if err = os.WriteFile(path, []byte(`{"plugins":[{"name":"empty"}]}`), 0o600); err != nil {
+		t.Fatalf("failed to write plugins file: %v", err)
+	}
+	if _, err = loadPluginsFile(path); err == nil {
+		t.Fatal("expected missing command to be rejected")
+	}
+}
+
+func TestPluginHandleCommandRejectsInvalidInput(t *testing.T) {
+	process := &pluginProcess{cfg: pluginConfig{Name: "test"}}
+	cases := map[string]string{
+		`not json`: "",
+		`{"type":"message.send","requestID":"r1","chatID":"!a:b"}`:     "r1",
+		`{"type":"label.add","requestID":"r2","chatID":"!a:b"}`:        "r2",
+		`{"type":"chat.delete","requestID":"r3","chatID":"!a:b"}`:      "r3",
+		`{"type":"message.send","requestID":"r4","text":"no chat id"}`: "r4",
+	}
+	for line, requestID := range cases {
+		result := process.handleCommand(context.Background(), []byte(line))
+		if result.Success || result.Code != pluginErrorInvalidCommand {
+			t.Fatalf("expected %s to be rejected, got %#v", line, result)
+		}
+		if result.RequestID != requestID {
+			t.Fatalf("expected requestID %q, got %q", requestID, result.RequestID)
+		}
+	}
+}
+
+func TestNormalizeRoomTagPrefixesUserLabels(t *testing.T) {
+	cases := map[string]string{
+		"work":        "u.work",
+		" u.family ":  "u.family",
+		"m.favourite": "m.favourite",
+		"   ":         "",
+	}
+	for input, expected := range cases {
+		if got := string(normalizeRoomTag(input)); got != expected {
+			t.Fatalf("normalizeRoomTag(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
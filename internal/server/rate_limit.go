@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const rateLimitWindow = time.Minute
+
+// rateLimitStatusRoute reports the budget and does not spend it.
+const rateLimitStatusRoute = "GET /v1/rate-limit"
+
+// rateLimiter counts requests per caller in fixed windows. Windows are
+// cheap to rebuild, so expired ones are simply dropped when the map grows.
+type rateLimiter struct {
+	limit   int
+	window  time.Duration
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+type rateBudget struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+func newRateLimiter(limit int) *rateLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &rateLimiter{limit: limit, window: rateLimitWindow, windows: make(map[string]*rateWindow)}
+}
+
+// current returns the caller's window, starting a new one when the previous
+// has ended. Callers must hold l.mu.
+func (l *rateLimiter) current(key string, now time.Time) *rateWindow {
+	win, ok := l.windows[key]
+	if ok && now.Before(win.start.Add(l.window)) {
+		return win
+	}
+	if len(l.windows) >= 1024 {
+		for existingKey, existing := range l.windows {
+			if !now.Before(existing.start.Add(l.window)) {
+				delete(l.windows, existingKey)
+			}
+		}
+	}
+	win = &rateWindow{start: now}
+	l.windows[key] = win
+	return win
+}
+
+// take spends one request from the caller's budget and reports whether it
+// was within the limit.
+func (l *rateLimiter) take(key string, now time.Time) (rateBudget, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	win := l.current(key, now)
+	allowed := win.count < l.limit
+	if allowed {
+		win.count++
+	}
+	return l.budget(win), allowed
+}
+
+// peek reports the caller's budget without spending any of it.
+func (l *rateLimiter) peek(key string, now time.Time) rateBudget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.budget(l.current(key, now))
+}
+
+func (l *rateLimiter) budget(win *rateWindow) rateBudget {
+	return rateBudget{limit: l.limit, remaining: max(l.limit-win.count, 0), reset: win.start.Add(l.window)}
+}
+
+// rateLimitKey groups requests by who makes them: external identities by
+// subject, everyone else by OAuth client.
+func rateLimitKey(r *http.Request) string {
+	info := mcpauth.TokenInfoFromContext(r.Context())
+	if info != nil && info.Extra["identity"] == externalIdentityMarker {
+		return "subject:" + info.UserID
+	}
+	return "client:" + requestClientID(r)
+}
+
+func writeRateLimitHeaders(w http.ResponseWriter, budget rateBudget) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(budget.limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(budget.remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(budget.reset.Unix(), 10))
+}
+
+// applyRateLimit spends one request of the caller's budget and reports it in
+// the response headers, so SDKs can slow down before they are rejected.
+func (s *Server) applyRateLimit(w http.ResponseWriter, r *http.Request) error {
+	if s.rateLimiter == nil {
+		return nil
+	}
+	now := time.Now()
+	budget, ok := s.rateLimiter.take(rateLimitKey(r), now)
+	writeRateLimitHeaders(w, budget)
+	if ok {
+		return nil
+	}
+	retryAfter := int(budget.reset.Sub(now).Round(time.Second) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	return errs.New(http.StatusTooManyRequests, "RATE_LIMITED", fmt.Sprintf("Rate limit of %d requests per minute exceeded", budget.limit), map[string]any{
+		"resetAt": budget.reset.UTC(),
+	})
+}
+
+// getRateLimit describes the caller's budget.
+func (s *Server) getRateLimit(w http.ResponseWriter, r *http.Request) error {
+	if s.rateLimiter == nil {
+		return writeJSON(w, compat.RateLimitStatus{Enabled: false})
+	}
+	budget := s.rateLimiter.peek(rateLimitKey(r), time.Now())
+	writeRateLimitHeaders(w, budget)
+	reset := budget.reset.UTC()
+	return writeJSON(w, compat.RateLimitStatus{
+		Enabled:       true,
+		Limit:         budget.limit,
+		Remaining:     budget.remaining,
+		WindowSeconds: int(s.rateLimiter.window / time.Second),
+		ResetAt:       &reset,
+	})
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSpendsAndResetsBudget(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Fatal("expected a zero limit to disable the limiter")
+	}
+	limiter := newRateLimiter(2)
+	now := time.Unix(1_700_000_000, 0)
+
+	for i := range 2 {
+		budget, ok := limiter.take("client:a", now)
+		if !ok || budget.remaining != 1-i {
+			t.Fatalf("request %d: allowed=%v remaining=%d", i, ok, budget.remaining)
+		}
+	}
+	budget, ok := limiter.take("client:a", now.Add(30*time.Second))
+	if ok || budget.remaining != 0 || !budget.reset.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected third request to be rejected until %v, got allowed=%v budget=%+v", now.Add(time.Minute), ok, budget)
+	}
+	if other, ok := limiter.take("client:b", now); !ok || other.remaining != 1 {
+		t.Fatalf("expected callers to have separate budgets, got allowed=%v budget=%+v", ok, other)
+	}
+	if peeked := limiter.peek("client:a", now.Add(time.Minute)); peeked.remaining != 2 {
+		t.Fatalf("expected a fresh window after reset, got %+v", peeked)
+	}
+	if peeked := limiter.peek("client:a", now.Add(time.Minute)); peeked.remaining != 2 {
+		t.Fatalf("expected peek not to spend budget, got %+v", peeked)
+	}
+}
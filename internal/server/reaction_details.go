@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mau.fi/util/emojirunes"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/cursor"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	reactionDetailsDefaultLimit = 100
+	reactionDetailsMaxLimit     = 500
+)
+
+// messageReactionsQuery reads decrypted content where present, since
+// reactions in encrypted rooms keep only m.relates_to in the clear.
+const messageReactionsQuery = `
+	SELECT rowid, event_id, sender, timestamp, COALESCE(decrypted, content)
+	FROM event
+	WHERE room_id = $1 AND relates_to = $2 AND relation_type = 'm.annotation' AND redacted_by IS NULL
+	  AND (type = 'm.reaction' OR decrypted_type = 'm.reaction')
+	ORDER BY rowid ASC
+`
+
+type reactionRow struct {
+	RowID     int64
+	EventID   string
+	Sender    string
+	Timestamp int64
+	Key       string
+}
+
+type reactionDetailsCursor struct {
+	RowID int64 `json:"rowid"`
+}
+
+// summarizeReactions counts each key once per sender, orders keys by count
+// and then by first use, and returns the deduplicated rows in reaction order.
+func summarizeReactions(rows []reactionRow, selfID string) ([]compat.ReactionSummary, []reactionRow) {
+	seen := make(map[string]struct{}, len(rows))
+	index := make(map[string]int)
+	summary := make([]compat.ReactionSummary, 0)
+	unique := make([]reactionRow, 0, len(rows))
+	for _, row := range rows {
+		dedupeKey := row.Sender + "\x1f" + row.Key
+		if _, ok := seen[dedupeKey]; ok {
+			continue
+		}
+		seen[dedupeKey] = struct{}{}
+		unique = append(unique, row)
+		idx, ok := index[row.Key]
+		if !ok {
+			idx = len(summary)
+			index[row.Key] = idx
+			summary = append(summary, compat.ReactionSummary{
+				ReactionKey: row.Key,
+				Emoji:       utf8.RuneCountInString(row.Key) <= 100 && emojirunes.IsOnlyEmojis(row.Key),
+			})
+		}
+		summary[idx].Count++
+		if row.Sender == selfID {
+			summary[idx].ReactedBySelf = true
+		}
+	}
+	sort.SliceStable(summary, func(i, j int) bool {
+		return summary[i].Count > summary[j].Count
+	})
+	return summary, unique
+}
+
+func (s *Server) listMessageReactions(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	messageID := readMessageID(r, "")
+	if chatID == "" || messageID == "" {
+		return errs.Validation(map[string]any{"messageID": "chatID and messageID are required"})
+	}
+	limit, err := parseOptionalLimit(r.URL.Query().Get("limit"), reactionDetailsDefaultLimit, 1, reactionDetailsMaxLimit, "limit")
+	if err != nil {
+		return err
+	}
+	var after reactionDetailsCursor
+	if raw := strings.TrimSpace(r.URL.Query().Get("cursor")); raw != "" {
+		if err = cursor.Decode(raw, &after); err != nil {
+			return errs.Validation(map[string]any{"cursor": err.Error()})
+		}
+	}
+	keyFilter := strings.TrimSpace(r.URL.Query().Get("reactionKey"))
+
+	ctx := r.Context()
+	room, err := s.loadChatRoom(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	cli := s.rt.Client()
+	evt, err := cli.DB.Event.GetByID(ctx, id.EventID(messageID))
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to get target message: %w", err))
+	}
+	if evt == nil || evt.RoomID != room.ID {
+		return errs.NotFound("Message not found")
+	}
+	rows, err := s.loadReactionRows(ctx, room.ID, evt.ID)
+	if err != nil {
+		return err
+	}
+
+	selfID := string(cli.Account.UserID)
+	summary, unique := summarizeReactions(rows, selfID)
+	profiles := s.loadMemberProfiles(ctx, room.ID)
+	output := compat.ListMessageReactionsOutput{Summary: summary, Items: make([]compat.ReactionDetail, 0)}
+	for _, row := range unique {
+		if row.RowID <= after.RowID || (keyFilter != "" && row.Key != keyFilter) {
+			continue
+		}
+		if len(output.Items) == limit {
+			output.HasMore = true
+			break
+		}
+		output.Items = append(output.Items, compat.ReactionDetail{
+			ID:          row.EventID,
+			ReactionKey: row.Key,
+			User:        userFromMemberEvent(ctx, row.Sender, profiles[row.Sender], selfID),
+			ReactedAt:   time.UnixMilli(row.Timestamp).UTC(),
+		})
+		after.RowID = row.RowID
+	}
+	if output.HasMore {
+		encoded, encodeErr := cursor.Encode(after)
+		if encodeErr != nil {
+			return errs.Internal(encodeErr)
+		}
+		output.NextCursor = &encoded
+	}
+	return writeJSON(w, output)
+}
+
+func (s *Server) loadReactionRows(ctx context.Context, roomID id.RoomID, eventID id.EventID) ([]reactionRow, error) {
+	rows, err := s.rt.Client().DB.Query(ctx, messageReactionsQuery, roomID, eventID)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query reactions: %w", err))
+	}
+	defer rows.Close()
+	var output []reactionRow
+	for rows.Next() {
+		var (
+			row     reactionRow
+			content []byte
+		)
+		if err = rows.Scan(&row.RowID, &row.EventID, &row.Sender, &row.Timestamp, &content); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan reaction: %w", err))
+		}
+		var reaction event.ReactionEventContent
+		if json.Unmarshal(content, &reaction) != nil {
+			continue
+		}
+		if row.Key = strings.TrimSpace(reaction.RelatesTo.Key); row.Key != "" {
+			output = append(output, row)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("reaction query failed: %w", err))
+	}
+	return output, nil
+}
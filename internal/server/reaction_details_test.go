@@ -0,0 +1,22 @@
+package server
+
+import "testing"
+
+func TestSummarizeReactionsDedupesAndOrdersByCount(t *testing.T) {
+	rows := []reactionRow{
+		{RowID: 1, Sender: "@a", Key: "👍"},
+		{RowID: 2, Sender: "@b", Key: "❤️"},
+		{RowID: 3, Sender: "@c", Key: "❤️"},
+		{RowID: 4, Sender: "@b", Key: "❤️"},
+	}
+	summary, unique := summarizeReactions(rows, "@a")
+	if len(unique) != 3 {
+		t.Fatalf("expected duplicate reaction to be dropped, got %d rows", len(unique))
+	}
+	if len(summary) != 2 || summary[0].ReactionKey != "❤️" || summary[0].Count != 2 {
+		t.Fatalf("unexpected summary %#v", summary)
+	}
+	if !summary[1].ReactedBySelf || summary[0].ReactedBySelf {
+		t.Fatalf("unexpected reactedBySelf flags %#v", summary)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// ownReadMarkerQuery finds, per room, the furthest timeline position covered
+// by the user's own read receipts (public or private) or fully-read marker.
+// The result uses the same timeline rowid that messageSortKey exposes.
+const ownReadMarkerQuery = `
+	SELECT room_id, MAX(timeline_rowid) FROM (
+		SELECT receipt.room_id AS room_id, timeline.rowid AS timeline_rowid
+		FROM receipt
+		JOIN event ON event.event_id = receipt.event_id
+		JOIN timeline ON timeline.event_rowid = event.rowid
+		WHERE receipt.user_id = $1 AND receipt.receipt_type IN ('m.read', 'm.read.private')
+		UNION ALL
+		SELECT room_account_data.room_id, timeline.rowid
+		FROM room_account_data
+		JOIN event ON event.event_id = room_account_data.content->>'$.event_id'
+		JOIN timeline ON timeline.event_rowid = event.rowid
+		WHERE room_account_data.user_id = $1 AND room_account_data.type = 'm.fully_read'
+	)
+	GROUP BY room_id
+`
+
+func applyOwnReadMarkers(states map[id.RoomID]roomAccountDataState, markers map[id.RoomID]int64) {
+	for roomID, rowID := range markers {
+		if rowID <= 0 {
+			continue
+		}
+		state := states[roomID]
+		state.LastReadMessageSortKey = strconv.FormatInt(rowID, 10)
+		states[roomID] = state
+	}
+}
+
+// readMarkerCache keeps the result of ownReadMarkerQuery between syncs.
+// Receipts and fully-read markers only change through sync, so every chat
+// listing in between can share one scan of the receipt table.
+type readMarkerCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	userID  id.UserID
+	markers map[id.RoomID]int64
+}
+
+func (c *readMarkerCache) invalidate() {
+	c.mu.Lock()
+	c.loaded = false
+	c.markers = nil
+	c.mu.Unlock()
+}
+
+// loadOwnReadMarkers returns the cached markers for userID, querying them
+// once per sync. Followers never sync, so they always query the replica.
+// Callers must not modify the returned map.
+func (s *Server) loadOwnReadMarkers(ctx context.Context, userID id.UserID) (map[id.RoomID]int64, error) {
+	if s.primary != nil {
+		return s.queryOwnReadMarkers(ctx, userID)
+	}
+	s.readMarkers.mu.Lock()
+	defer s.readMarkers.mu.Unlock()
+	if s.readMarkers.loaded && s.readMarkers.userID == userID {
+		return s.readMarkers.markers, nil
+	}
+	markers, err := s.queryOwnReadMarkers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.readMarkers.loaded = true
+	s.readMarkers.userID = userID
+	s.readMarkers.markers = markers
+	return markers, nil
+}
+
+func (s *Server) queryOwnReadMarkers(ctx context.Context, userID id.UserID) (map[id.RoomID]int64, error) {
+	rows, err := s.rt.Client().DB.Query(ctx, ownReadMarkerQuery, userID)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query read markers: %w", err))
+	}
+	defer rows.Close()
+	markers := make(map[id.RoomID]int64)
+	for rows.Next() {
+		var (
+			roomID string
+			rowID  int64
+		)
+		if err = rows.Scan(&roomID, &rowID); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan read marker: %w", err))
+		}
+		markers[id.RoomID(roomID)] = rowID
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("read marker query failed: %w", err))
+	}
+	return markers, nil
+}
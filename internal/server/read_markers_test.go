@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestApplyOwnReadMarkersKeepsAccountDataState(t *testing.T) {
+	states := map[id.RoomID]roomAccountDataState{"!a": {IsMuted: true}}
+	applyOwnReadMarkers(states, map[id.RoomID]int64{"!a": 42, "!b": 7, "!c": 0})
+	if !states["!a"].IsMuted || states["!a"].LastReadMessageSortKey != "42" {
+		t.Fatalf("unexpected state for !a: %#v", states["!a"])
+	}
+	if states["!b"].LastReadMessageSortKey != "7" {
+		t.Fatalf("expected marker for room without account data, got %#v", states["!b"])
+	}
+	if _, ok := states["!c"]; ok {
+		t.Fatal("expected empty marker to be ignored")
+	}
+}
+
+func TestLoadOwnReadMarkersCachesUntilSync(t *testing.T) {
+	s := newDBTestServer(t)
+	ctx := context.Background()
+	roomID := id.RoomID("!room:example.org")
+	insertTestRoom(t, s, roomID)
+	insertTestEvent(t, s, testTextEvent(roomID, "$first", "@alice:example.org", "one"))
+	insertTestEvent(t, s, testTextEvent(roomID, "$second", "@alice:example.org", "two"))
+	putReceipt := func(eventID id.EventID) {
+		t.Helper()
+		err := s.rt.Client().DB.Receipt.Put(ctx, &database.Receipt{RoomID: roomID, UserID: testOwnUserID, ReceiptType: event.ReceiptTypeRead, EventID: eventID, Timestamp: jsontime.UM(time.Now())})
+		if err != nil {
+			t.Fatalf("failed to store receipt: %v", err)
+		}
+	}
+
+	putReceipt("$first")
+	first, err := s.loadOwnReadMarkers(ctx, testOwnUserID)
+	if err != nil || first[roomID] == 0 {
+		t.Fatalf("expected a marker, got %v (%v)", first, err)
+	}
+	putReceipt("$second")
+	if cached, _ := s.loadOwnReadMarkers(ctx, testOwnUserID); cached[roomID] != first[roomID] {
+		t.Fatalf("expected cached marker %d before the next sync, got %d", first[roomID], cached[roomID])
+	}
+	s.readMarkers.invalidate()
+	if fresh, _ := s.loadOwnReadMarkers(ctx, testOwnUserID); fresh[roomID] <= first[roomID] {
+		t.Fatalf("expected the newer receipt after invalidation, got %d", fresh[roomID])
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	replayBatchSize      = 50
+	maxReplayEvents      = 5000
+	replayWebhookTimeout = 10 * time.Second
+)
+
+const replayMessageIDsQuery = `
+	SELECT event.event_id
+	FROM timeline
+	JOIN event ON event.rowid = timeline.event_rowid
+	WHERE timeline.room_id = $1
+		AND event.timestamp >= $2 AND event.timestamp < $3
+		AND event.type IN ('m.room.message', 'm.sticker', 'm.room.encrypted')
+		AND event.relation_type IS NOT 'm.replace'
+	ORDER BY timeline.rowid ASC
+	LIMIT $4
+`
+
+type replayInput struct {
+	ChatID string    `json:"chatID"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// Exactly one of ConnectionID (from the websocket ready message) and
+	// WebhookURL selects where replayed events are delivered. Connections
+	// must belong to the caller, and webhooks to an allowlisted host.
+	ConnectionID uint64 `json:"connectionID,omitempty"`
+	WebhookURL   string `json:"webhookURL,omitempty"`
+}
+
+type replayOutput struct {
+	ChatID        string `json:"chatID"`
+	EventsSent    int    `json:"eventsSent"`
+	MessagesFound int    `json:"messagesFound"`
+	Truncated     bool   `json:"truncated"`
+}
+
+// replaySink delivers one replayed event and reports whether it was sent
+// rather than filtered out.
+type replaySink func(payload wsDomainEventMessage) (bool, error)
+
+func validateReplayInput(req *replayInput, allowedHosts []string) error {
+	req.ChatID = strings.TrimSpace(req.ChatID)
+	req.WebhookURL = strings.TrimSpace(req.WebhookURL)
+	if req.ChatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	if req.From.IsZero() {
+		return errs.Validation(map[string]any{"from": "from is required"})
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if !req.To.After(req.From) {
+		return errs.Validation(map[string]any{"to": "to must be after from"})
+	}
+	if (req.ConnectionID == 0) == (req.WebhookURL == "") {
+		return errs.Validation(map[string]any{"connectionID": "exactly one of connectionID or webhookURL is required"})
+	}
+	if req.WebhookURL != "" {
+		parsed, err := url.Parse(req.WebhookURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errs.Validation(map[string]any{"webhookURL": "must be an absolute http(s) URL"})
+		}
+		if !slices.Contains(allowedHosts, strings.ToLower(parsed.Hostname())) {
+			return errs.Forbidden("webhookURL host is not listed in EASYMATRIX_REPLAY_WEBHOOK_HOSTS")
+		}
+	}
+	return nil
+}
+
+// replayEvents re-emits message history as domain events flagged replay=true.
+// Events go only to the chosen target, never to other subscribers, and pass
+// the same chat policy and message type filters as live events.
+func (s *Server) replayEvents(w http.ResponseWriter, r *http.Request) error {
+	var req replayInput
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	if err := validateReplayInput(&req, s.cfg.ReplayWebhookHosts); err != nil {
+		return err
+	}
+	if !s.requestAllowsChat(r, req.ChatID) {
+		return errs.Forbidden("Chat is not allowed for this caller")
+	}
+	if _, err := s.loadChatRoom(r.Context(), req.ChatID); err != nil {
+		return err
+	}
+
+	var sink replaySink
+	if req.WebhookURL != "" {
+		sink = s.webhookReplaySink(r.Context(), req.WebhookURL)
+	} else {
+		client := s.ws.client(req.ConnectionID)
+		if client == nil || client.owner != rateLimitKey(r) {
+			return errs.NotFound("Realtime connection not found")
+		}
+		if client.policy != nil && !client.policy.allowsChat(req.ChatID, s.chatAccountID(r.Context(), req.ChatID)) {
+			return errs.Forbidden("Chat is not allowed for this connection")
+		}
+		sink = s.ws.replaySink(client)
+	}
+
+	messageIDs, err := s.loadReplayMessageIDs(r.Context(), id.RoomID(req.ChatID), req.From, req.To)
+	if err != nil {
+		return err
+	}
+	output := replayOutput{ChatID: req.ChatID, MessagesFound: len(messageIDs), Truncated: len(messageIDs) >= maxReplayEvents}
+
+	if _, err = sink(wsDomainEventMessage{Type: wsDomainTypeChatUpserted, ChatID: req.ChatID, IDs: []string{req.ChatID}}); err != nil {
+		return errs.Internal(fmt.Errorf("failed to deliver replay: %w", err))
+	}
+	output.EventsSent++
+	for start := 0; start < len(messageIDs); start += replayBatchSize {
+		batch := messageIDs[start:min(start+replayBatchSize, len(messageIDs))]
+		entries, err := s.hydrateMessagesForWSEvent(req.ChatID, batch)
+		if err != nil {
+			return errs.Internal(fmt.Errorf("failed to load messages for replay: %w", err))
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if messageID, ok := entry["id"].(string); ok {
+				ids = append(ids, messageID)
+			}
+		}
+		payload := wsDomainEventMessage{Type: wsDomainTypeMessageUpserted, ChatID: req.ChatID, IDs: ids, Entries: entries}
+		sent, err := sink(payload)
+		if err != nil {
+			return errs.Internal(fmt.Errorf("failed to deliver replay: %w", err))
+		}
+		if sent {
+			output.EventsSent++
+		}
+	}
+	return writeJSON(w, output)
+}
+
+func (s *Server) loadReplayMessageIDs(ctx context.Context, roomID id.RoomID, from, to time.Time) ([]string, error) {
+	rows, err := s.rt.Client().DB.Query(ctx, replayMessageIDsQuery, roomID, from.UnixMilli(), to.UnixMilli(), maxReplayEvents)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to query replay range: %w", err))
+	}
+	defer rows.Close()
+	var output []string
+	for rows.Next() {
+		var eventID string
+		if err = rows.Scan(&eventID); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to scan replay range: %w", err))
+		}
+		output = append(output, eventID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errs.Internal(fmt.Errorf("replay range query failed: %w", err))
+	}
+	return output, nil
+}
+
+// replaySink writes to one connection using its own seq counter, so replayed
+// events interleave correctly with live ones. Message batches are narrowed
+// by the connection's type filter like live fan-out.
+func (h *wsHub) replaySink(client *wsClient) replaySink {
+	return func(payload wsDomainEventMessage) (bool, error) {
+		h.dispatchMu.Lock()
+		defer h.dispatchMu.Unlock()
+		if h.client(client.id) == nil {
+			return false, fmt.Errorf("realtime connection closed")
+		}
+		if payload.Type == wsDomainTypeMessageUpserted {
+			payload.Entries, payload.IDs = filterMessageEntries(payload.Entries, payload.IDs, h.messageTypes(client))
+			if len(payload.Entries) == 0 {
+				return false, nil
+			}
+		}
+		client.state.seq++
+		payload.Seq = client.state.seq
+		payload.TS = time.Now().UTC().UnixMilli()
+		payload.Replay = true
+		h.write(client, payload)
+		return true, nil
+	}
+}
+
+func (s *Server) webhookReplaySink(ctx context.Context, webhookURL string) replaySink {
+	// Redirects are not followed, so an allowlisted host cannot bounce the
+	// history somewhere else.
+	httpClient := &http.Client{
+		Timeout: replayWebhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	seq := 0
+	return func(payload wsDomainEventMessage) (bool, error) {
+		seq++
+		payload.Seq = seq
+		payload.TS = time.Now().UTC().UnixMilli()
+		payload.Replay = true
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return false, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return false, err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return false, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+		}
+		return true, nil
+	}
+}
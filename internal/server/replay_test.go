@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestValidateReplayInputRequiresSingleTarget(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	cases := []struct {
+		name  string
+		input replayInput
+		ok    bool
+	}{
+		{"connection", replayInput{ChatID: "!room:example.org", From: from, To: to, ConnectionID: 3}, true},
+		{"webhook", replayInput{ChatID: "!room:example.org", From: from, To: to, WebhookURL: "https://example.org/hook"}, true},
+		{"no target", replayInput{ChatID: "!room:example.org", From: from, To: to}, false},
+		{"both targets", replayInput{ChatID: "!room:example.org", From: from, To: to, ConnectionID: 3, WebhookURL: "https://example.org/hook"}, false},
+		{"unlisted webhook host", replayInput{ChatID: "!room:example.org", From: from, To: to, WebhookURL: "http://169.254.169.254/latest"}, false},
+		{"bad webhook", replayInput{ChatID: "!room:example.org", From: from, To: to, WebhookURL: "file:///etc/passwd"}, false},
+		{"inverted range", replayInput{ChatID: "!room:example.org", From: to, To: from, ConnectionID: 3}, false},
+		{"missing chat", replayInput{From: from, To: to, ConnectionID: 3}, false},
+	}
+	for _, tc := range cases {
+		err := validateReplayInput(&tc.input, []string{"example.org"})
+		if (err == nil) != tc.ok {
+			t.Fatalf("%s: expected ok=%v, got %v", tc.name, tc.ok, err)
+		}
+	}
+
+	open := replayInput{ChatID: "!room:example.org", From: from, ConnectionID: 1}
+	if err := validateReplayInput(&open, nil); err != nil || open.To.IsZero() {
+		t.Fatalf("expected open-ended range to default to now, got %v (%v)", open.To, err)
+	}
+}
+
+func TestValidateReplayInputRejectsWebhooksWithoutAllowlist(t *testing.T) {
+	input := replayInput{ChatID: "!room:example.org", From: time.Now().Add(-time.Hour), WebhookURL: "https://example.org/hook"}
+	if err := validateReplayInput(&input, nil); err == nil {
+		t.Fatal("expected webhook replay to be rejected without allowed hosts")
+	}
+}
+
+func TestReplaySinkAppliesMessageTypeFilter(t *testing.T) {
+	hub, messages := newTestWSHub()
+	hub.setMessageTypes(1, messageTypeFilter{compat.MessageTypeText: {}})
+	sink := hub.replaySink(hub.client(1))
+
+	sent, err := sink(wsDomainEventMessage{
+		Type:    wsDomainTypeMessageUpserted,
+		ChatID:  "!room:example.org",
+		IDs:     []string{"$image"},
+		Entries: []compatRecord{{"id": "$image", "type": "IMAGE"}},
+	})
+	if err != nil || sent || len(*messages) != 0 {
+		t.Fatalf("expected filtered batch to be skipped, sent=%v err=%v messages=%d", sent, err, len(*messages))
+	}
+
+	sent, err = sink(wsDomainEventMessage{
+		Type:    wsDomainTypeMessageUpserted,
+		ChatID:  "!room:example.org",
+		IDs:     []string{"$image", "$text"},
+		Entries: []compatRecord{{"id": "$image", "type": "IMAGE"}, {"id": "$text", "type": "TEXT"}},
+	})
+	if err != nil || !sent || len(*messages) != 1 {
+		t.Fatalf("expected one replayed message, sent=%v err=%v messages=%d", sent, err, len(*messages))
+	}
+	payload := (*messages)[0].(wsDomainEventMessage)
+	if !payload.Replay || payload.Seq != 1 || len(payload.IDs) != 1 || payload.IDs[0] != "$text" {
+		t.Fatalf("unexpected replay payload %#v", payload)
+	}
+}
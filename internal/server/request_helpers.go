@@ -22,6 +22,9 @@ func decodeOptionalJSON(r *http.Request, out any) error {
 		if errors.Is(err, io.EOF) {
 			return nil
 		}
+		if tooLarge := payloadTooLargeError(err); tooLarge != nil {
+			return tooLarge
+		}
 		return errs.Validation(map[string]any{"error": err.Error()})
 	}
 	return nil
@@ -2,8 +2,13 @@ package server
 
 import (
 	"bytes"
+	"errors"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
 )
 
 func TestDecodeOptionalJSONAcceptsEmptyBody(t *testing.T) {
@@ -33,3 +38,29 @@ func TestReadMessageIDPrefersPathOverBodyAndQuery(t *testing.T) {
 		t.Fatalf("expected path-id, got %q", messageID)
 	}
 }
+
+func TestDecodeOptionalJSONReportsPayloadTooLarge(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/focus", bytes.NewBufferString(`{"name":"`+strings.Repeat("a", 64)+`"}`))
+	rec := httptest.NewRecorder()
+	limitRequestBody(rec, req, 16)
+	var payload struct {
+		Name string `json:"name"`
+	}
+	err := decodeOptionalJSON(req, &payload)
+	var apiErr *errs.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "PAYLOAD_TOO_LARGE" {
+		t.Fatalf("expected PAYLOAD_TOO_LARGE, got %v", err)
+	}
+	if apiErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", apiErr.Status)
+	}
+}
+
+func TestBodyLimitForRouteAllowsLargeUploads(t *testing.T) {
+	if got := bodyLimitForRoute("POST /v1/chats/{chatID}/messages"); got != defaultBodyLimitBytes {
+		t.Fatalf("expected default limit for JSON routes, got %d", got)
+	}
+	if got := bodyLimitForRoute("POST /v1/assets/upload"); got <= maxUploadSizeBytes {
+		t.Fatalf("expected upload limit above max upload size, got %d", got)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+const roomBridgeInfoQuery = `
+	SELECT cs.room_id, event.content
+	FROM current_state cs
+	JOIN event ON event.rowid = cs.event_rowid
+	WHERE cs.event_type IN ('m.bridge', 'uk.half-shot.bridge')
+`
+
+// roomBridgeInfo is the part of a room's m.bridge state that identifies the
+// bridge and, for rooms split per login, the receiving login.
+type roomBridgeInfo struct {
+	BridgeBot string
+	Protocol  string
+	Receiver  string
+}
+
+// roomBridgeCache keeps the bridge info of every room. It only changes when a
+// sync carries new bridge state, so it is loaded once and dropped then.
+type roomBridgeCache struct {
+	mu     sync.Mutex
+	loaded bool
+	rooms  map[id.RoomID]roomBridgeInfo
+}
+
+func (c *roomBridgeCache) invalidate() {
+	c.mu.Lock()
+	c.loaded = false
+	c.rooms = nil
+	c.mu.Unlock()
+}
+
+func (s *Server) loadRoomBridges(ctx context.Context) (map[id.RoomID]roomBridgeInfo, error) {
+	s.roomBridges.mu.Lock()
+	defer s.roomBridges.mu.Unlock()
+	if s.roomBridges.loaded {
+		return s.roomBridges.rooms, nil
+	}
+	rows, err := s.rt.Client().DB.Query(ctx, roomBridgeInfoQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query room bridge info: %w", err)
+	}
+	defer rows.Close()
+	rooms := make(map[id.RoomID]roomBridgeInfo)
+	for rows.Next() {
+		var (
+			roomID  id.RoomID
+			content []byte
+		)
+		if err = rows.Scan(&roomID, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan room bridge info: %w", err)
+		}
+		var parsed event.BridgeEventContent
+		if json.Unmarshal(content, &parsed) != nil {
+			continue
+		}
+		info := roomBridgeInfo{
+			BridgeBot: string(parsed.BridgeBot),
+			Protocol:  strings.ToLower(strings.TrimSpace(parsed.Protocol.ID)),
+			Receiver:  strings.TrimSpace(parsed.Channel.Receiver),
+		}
+		// Bridges may send both event types; keep the one naming a receiver.
+		if existing, ok := rooms[roomID]; ok && existing.Receiver != "" {
+			continue
+		}
+		rooms[roomID] = info
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read room bridge info: %w", err)
+	}
+	s.roomBridges.rooms, s.roomBridges.loaded = rooms, true
+	return rooms, nil
+}
+
+// attachRoomBridges adds the bridge info to a lookup. Failures only cost
+// accuracy, since inferAccountForRoom falls back to the room ID heuristic.
+func (s *Server) attachRoomBridges(ctx context.Context, lookup *accountLookup) {
+	rooms, err := s.loadRoomBridges(ctx)
+	if err != nil {
+		log.Printf("using room ID heuristic for account mapping: %v", err)
+		return
+	}
+	lookup.RoomBridges = rooms
+}
+
+// accountFromRoomBridge maps a room's bridge info onto a connected account.
+// The bridge is matched by protocol or bot name, and the login by the
+// receiver; without a receiver the bridge must have exactly one account.
+func accountFromRoomBridge(info roomBridgeInfo, lookup *accountLookup) (compat.Account, bool) {
+	botLocalpart := strings.ToLower(userIDLocalpart(info.BridgeBot))
+	var candidates []compat.Account
+	for bridgeID, accounts := range lookup.ByBridge {
+		network := strings.TrimPrefix(bridgeID, "local-")
+		if network != info.Protocol && botLocalpart != network+"bot" {
+			continue
+		}
+		if info.Receiver != "" {
+			if account, ok := lookup.ByID[bridgeID+"_"+info.Receiver]; ok {
+				return account, true
+			}
+			continue
+		}
+		candidates = append(candidates, accounts...)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	return compat.Account{}, false
+}
+
+// syncChangesRoomBridges reports whether a sync carries bridge state, which
+// also covers newly joined bridged rooms.
+func syncChangesRoomBridges(syncComplete *jsoncmd.SyncComplete) bool {
+	if syncComplete == nil {
+		return false
+	}
+	for _, roomSync := range syncComplete.Rooms {
+		if roomSync == nil {
+			continue
+		}
+		for _, evt := range roomSync.Events {
+			if evt == nil {
+				continue
+			}
+			if evtType := evt.GetType().Type; evtType == event.StateBridge.Type || evtType == event.StateHalfShotBridge.Type {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestInferAccountForRoomPrefersBridgeInfo(t *testing.T) {
+	accounts := []compat.Account{
+		{AccountID: "whatsapp_111", Network: "WhatsApp"},
+		{AccountID: "whatsapp_222", Network: "WhatsApp"},
+		{AccountID: "local-signal_333", Network: "Signal"},
+	}
+	lookup := &accountLookup{
+		Accounts: accounts,
+		ByID:     map[string]compat.Account{},
+		ByBridge: map[string][]compat.Account{},
+		RoomBridges: map[id.RoomID]roomBridgeInfo{
+			"!wa:beeper.local":     {BridgeBot: "@whatsappbot:beeper.local", Protocol: "whatsapp", Receiver: "222"},
+			"!signal:beeper.local": {BridgeBot: "@signalbot:beeper.local", Protocol: "signal"},
+			"!gone:beeper.local":   {BridgeBot: "@whatsappbot:beeper.local", Protocol: "whatsapp", Receiver: "999"},
+		},
+	}
+	for _, account := range accounts {
+		lookup.ByID[account.AccountID] = account
+		bridgeID := bridgeIDFromAccountID(account.AccountID)
+		lookup.ByBridge[bridgeID] = append(lookup.ByBridge[bridgeID], account)
+	}
+
+	if accountID, _ := inferAccountForRoom("!wa:beeper.local", lookup); accountID != "whatsapp_222" {
+		t.Fatalf("expected the receiver's account, got %q", accountID)
+	}
+	if accountID, _ := inferAccountForRoom("!signal:beeper.local", lookup); accountID != "local-signal_333" {
+		t.Fatalf("expected the only signal account, got %q", accountID)
+	}
+	if _, ok := accountFromRoomBridge(lookup.RoomBridges["!gone:beeper.local"], lookup); ok {
+		t.Fatal("expected an unknown receiver to fall back to the heuristic")
+	}
+}
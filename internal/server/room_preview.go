@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	roomPreviewDefaultMessages = 20
+	roomPreviewMaxMessages     = 50
+)
+
+// previewRoom describes a room the account may not be in. The room summary
+// API (MSC3266) is tried first; homeservers without it still allow reading
+// the state of world-readable rooms. Recent messages are only fetched for
+// world-readable rooms, since anything else requires membership.
+func (s *Server) previewRoom(w http.ResponseWriter, r *http.Request) error {
+	raw := strings.TrimSpace(r.PathValue("roomIDOrAlias"))
+	if !strings.HasPrefix(raw, "!") && !strings.HasPrefix(raw, "#") {
+		return errs.Validation(map[string]any{"roomIDOrAlias": "must be a room ID (!...) or alias (#...)"})
+	}
+	messageLimit, err := parseOptionalLimit(r.URL.Query().Get("messageLimit"), roomPreviewDefaultMessages, 0, roomPreviewMaxMessages, "messageLimit")
+	if err != nil {
+		return err
+	}
+	ctx := r.Context()
+	cli := s.rt.Client()
+
+	roomID := id.RoomID(raw)
+	var via []string
+	if strings.HasPrefix(raw, "#") {
+		resolved, err := cli.Client.ResolveAlias(ctx, id.RoomAlias(raw))
+		if err != nil {
+			return roomPreviewError(err, "resolve room alias")
+		}
+		roomID, via = resolved.RoomID, resolved.Servers
+	}
+	if !s.requestPolicy(r).allowsChat(string(roomID), "") {
+		return errs.NotFound("Room not found")
+	}
+
+	preview, err := s.loadRoomSummary(ctx, roomID, via)
+	if err != nil {
+		return err
+	}
+	if local, err := cli.DB.Room.Get(ctx, roomID); err == nil && local != nil {
+		preview.ChatID = string(roomID)
+	}
+	preview.Messages = []compat.RoomPreviewMessage{}
+	if preview.WorldReadable && messageLimit > 0 {
+		resp, err := cli.Client.Messages(ctx, roomID, "", "", mautrix.DirectionBackward, nil, messageLimit)
+		if err != nil {
+			return roomPreviewError(err, "read room history")
+		}
+		preview.Messages = mapRoomPreviewMessages(resp.Chunk)
+	}
+	return writeJSON(w, preview)
+}
+
+func (s *Server) loadRoomSummary(ctx context.Context, roomID id.RoomID, via []string) (compat.RoomPreview, error) {
+	cli := s.rt.Client()
+	summary, err := cli.Client.GetRoomSummary(ctx, string(roomID), via...)
+	if err == nil {
+		encryption := summary.Encryption
+		if encryption == "" {
+			encryption = summary.UnstableEncryption
+		}
+		return compat.RoomPreview{
+			RoomID:         string(summary.RoomID),
+			CanonicalAlias: string(summary.CanonicalAlias),
+			Name:           summary.Name,
+			Topic:          summary.Topic,
+			AvatarURL:      string(summary.AvatarURL),
+			MemberCount:    summary.NumJoinedMembers,
+			JoinRule:       string(summary.JoinRule),
+			RoomType:       string(summary.RoomType),
+			WorldReadable:  summary.WorldReadable,
+			GuestCanJoin:   summary.GuestCanJoin,
+			Encrypted:      encryption != "",
+			Membership:     string(summary.Membership),
+		}, nil
+	}
+	if !errors.Is(err, mautrix.MUnrecognized) && !errors.Is(err, mautrix.MNotFound) {
+		return compat.RoomPreview{}, roomPreviewError(err, "load room summary")
+	}
+	// StateAsArray rather than State: the latter writes the peeked room into
+	// the local state store as if it were joined.
+	state, err := cli.Client.StateAsArray(ctx, roomID)
+	if err != nil {
+		return compat.RoomPreview{}, roomPreviewError(err, "read room state")
+	}
+	return roomPreviewFromState(roomID, state), nil
+}
+
+func roomPreviewFromState(roomID id.RoomID, state []*event.Event) compat.RoomPreview {
+	preview := compat.RoomPreview{RoomID: string(roomID)}
+	for _, evt := range state {
+		if evt == nil || evt.StateKey == nil {
+			continue
+		}
+		// Types decoded from a raw response carry no class, which both the
+		// comparisons below and content parsing depend on.
+		evt.Type.Class = event.StateEventType
+		_ = evt.Content.ParseRaw(evt.Type)
+		switch evt.Type {
+		case event.StateRoomName:
+			preview.Name = evt.Content.AsRoomName().Name
+		case event.StateTopic:
+			preview.Topic = evt.Content.AsTopic().Topic
+		case event.StateRoomAvatar:
+			preview.AvatarURL = string(evt.Content.AsRoomAvatar().URL)
+		case event.StateCanonicalAlias:
+			preview.CanonicalAlias = string(evt.Content.AsCanonicalAlias().Alias)
+		case event.StateJoinRules:
+			preview.JoinRule = string(evt.Content.AsJoinRules().JoinRule)
+		case event.StateHistoryVisibility:
+			preview.WorldReadable = evt.Content.AsHistoryVisibility().HistoryVisibility == event.HistoryVisibilityWorldReadable
+		case event.StateGuestAccess:
+			preview.GuestCanJoin = evt.Content.AsGuestAccess().GuestAccess == event.GuestAccessCanJoin
+		case event.StateEncryption:
+			preview.Encrypted = true
+		case event.StateCreate:
+			preview.RoomType = string(evt.Content.AsCreate().Type)
+		case event.StateMember:
+			if evt.Content.AsMember().Membership == event.MembershipJoin {
+				preview.MemberCount++
+			}
+		}
+	}
+	return preview
+}
+
+// mapRoomPreviewMessages keeps plain room messages, oldest first. Encrypted
+// events can't be read without joining and are skipped.
+func mapRoomPreviewMessages(chunk []*event.Event) []compat.RoomPreviewMessage {
+	messages := make([]compat.RoomPreviewMessage, 0, len(chunk))
+	for idx := len(chunk) - 1; idx >= 0; idx-- {
+		evt := chunk[idx]
+		if evt == nil || evt.Type.Type != event.EventMessage.Type {
+			continue
+		}
+		evt.Type.Class = event.MessageEventType
+		_ = evt.Content.ParseRaw(evt.Type)
+		content := evt.Content.AsMessage()
+		if content.RelatesTo != nil && content.RelatesTo.GetReplaceID() != "" {
+			continue
+		}
+		messages = append(messages, compat.RoomPreviewMessage{
+			ID:        string(evt.ID),
+			SenderID:  string(evt.Sender),
+			Timestamp: time.UnixMilli(evt.Timestamp).UTC(),
+			MsgType:   string(content.MsgType),
+			Text:      content.Body,
+		})
+	}
+	return messages
+}
+
+func roomPreviewError(err error, action string) error {
+	switch {
+	case errors.Is(err, mautrix.MForbidden):
+		return errs.Forbidden("This room can't be previewed without joining")
+	case errors.Is(err, mautrix.MNotFound):
+		return errs.NotFound("Room not found")
+	default:
+		return errs.Internal(fmt.Errorf("failed to %s: %w", action, err))
+	}
+}
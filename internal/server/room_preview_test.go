@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func rawStateEvent(evtType event.Type, stateKey, content string) *event.Event {
+	return &event.Event{Type: evtType, StateKey: &stateKey, Content: event.Content{VeryRaw: json.RawMessage(content)}}
+}
+
+func TestRoomPreviewFromStateCountsJoinedMembers(t *testing.T) {
+	preview := roomPreviewFromState("!room:example.org", []*event.Event{
+		rawStateEvent(event.Type{Type: event.StateRoomName.Type}, "", `{"name":"Lobby"}`),
+		rawStateEvent(event.Type{Type: event.StateHistoryVisibility.Type}, "", `{"history_visibility":"world_readable"}`),
+		rawStateEvent(event.Type{Type: event.StateMember.Type}, "@a:example.org", `{"membership":"join"}`),
+		rawStateEvent(event.Type{Type: event.StateMember.Type}, "@b:example.org", `{"membership":"leave"}`),
+		rawStateEvent(event.Type{Type: event.StateMember.Type}, "@c:example.org", `{"membership":"join"}`),
+	})
+	if preview.Name != "Lobby" || !preview.WorldReadable || preview.MemberCount != 2 {
+		t.Fatalf("unexpected preview %#v", preview)
+	}
+}
+
+func TestMapRoomPreviewMessagesSkipsEditsAndEncrypted(t *testing.T) {
+	message := func(eventID, content string) *event.Event {
+		return &event.Event{ID: id.EventID(eventID), Type: event.Type{Type: event.EventMessage.Type}, Content: event.Content{VeryRaw: json.RawMessage(content)}}
+	}
+	chunk := []*event.Event{
+		message("$3", `{"msgtype":"m.text","body":"* fixed","m.relates_to":{"rel_type":"m.replace","event_id":"$1"}}`),
+		{ID: "$2", Type: event.Type{Type: event.EventEncrypted.Type}},
+		message("$1", `{"msgtype":"m.text","body":"hello"}`),
+	}
+	messages := mapRoomPreviewMessages(chunk)
+	if len(messages) != 1 || messages[0].ID != "$1" || messages[0].Text != "hello" {
+		t.Fatalf("unexpected messages %#v", messages)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const userRoomTagPrefix = "u."
+
+// normalizeRoomTag maps a free-form label onto a Matrix room tag. Reserved
+// m.* tags and already-prefixed user tags are passed through unchanged.
+func normalizeRoomTag(label string) event.RoomTag {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return ""
+	}
+	if strings.HasPrefix(label, "m.") || strings.HasPrefix(label, userRoomTagPrefix) {
+		return event.RoomTag(label)
+	}
+	return event.RoomTag(userRoomTagPrefix + label)
+}
+
+func (s *Server) addRoomTag(ctx context.Context, chatID string, tag event.RoomTag) error {
+	if err := s.rt.Client().Client.AddTagWithCustomData(ctx, id.RoomID(chatID), tag, map[string]any{}); err != nil {
+		return errs.Internal(fmt.Errorf("failed to add room tag: %w", err))
+	}
+	return nil
+}
+
+func (s *Server) removeRoomTag(ctx context.Context, chatID string, tag event.RoomTag) error {
+	if err := s.rt.Client().Client.RemoveTag(ctx, id.RoomID(chatID), tag); err != nil {
+		return errs.Internal(fmt.Errorf("failed to remove room tag: %w", err))
+	}
+	return nil
+}
+
+// setChatPinned toggles the m.favourite tag, which is what Beeper clients
+// read as the pinned state of a chat.
+func (s *Server) setChatPinned(ctx context.Context, chatID string, pinned bool) error {
+	if pinned {
+		return s.addRoomTag(ctx, chatID, event.RoomTagFavourite)
+	}
+	return s.removeRoomTag(ctx, chatID, event.RoomTagFavourite)
+}
+
+// setChatLowPriority toggles the m.lowpriority tag backing inbox=low-priority.
+func (s *Server) setChatLowPriority(ctx context.Context, chatID string, lowPriority bool) error {
+	if lowPriority {
+		return s.addRoomTag(ctx, chatID, event.RoomTagLowPriority)
+	}
+	return s.removeRoomTag(ctx, chatID, event.RoomTagLowPriority)
+}
+
+func sortedRoomTagNames(tags map[event.RoomTag]event.TagMetadata) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, string(tag))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// parseRoomTagFilter accepts repeated or comma-separated labels and
+// normalizes them the same way the tag endpoints do.
+func parseRoomTagFilter(values []string) []string {
+	labels := parseCSVQueryValues(values)
+	tags := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if tag := normalizeRoomTag(label); tag != "" {
+			tags = append(tags, string(tag))
+		}
+	}
+	return tags
+}
+
+func roomHasAnyTag(roomTags, wanted []string) bool {
+	for _, tag := range wanted {
+		if slices.Contains(roomTags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) loadRoomTags(ctx context.Context, roomID id.RoomID) (map[event.RoomTag]event.TagMetadata, error) {
+	cli := s.rt.Client()
+	accountData, err := cli.DB.AccountData.GetAllRoom(ctx, cli.Account.UserID, roomID)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to read room tags: %w", err))
+	}
+	for _, entry := range accountData {
+		if entry.Type != event.AccountDataRoomTags.Type {
+			continue
+		}
+		var content event.TagEventContent
+		if err = json.Unmarshal(entry.Content, &content); err != nil {
+			return nil, errs.Internal(fmt.Errorf("failed to parse room tags: %w", err))
+		}
+		return content.Tags, nil
+	}
+	return nil, nil
+}
+
+func chatTagsOutput(chatID string, tags map[event.RoomTag]event.TagMetadata) compat.ChatTagsOutput {
+	output := compat.ChatTagsOutput{ChatID: chatID, Tags: make([]compat.ChatTag, 0, len(tags))}
+	for _, name := range sortedRoomTagNames(tags) {
+		tag := compat.ChatTag{Tag: name}
+		if order := tags[event.RoomTag(name)].Order; order != "" {
+			if value, err := order.Float64(); err == nil {
+				tag.Order = &value
+			}
+		}
+		output.Tags = append(output.Tags, tag)
+	}
+	return output
+}
+
+func (s *Server) getChatTags(w http.ResponseWriter, r *http.Request) error {
+	room, err := s.loadChatRoom(r.Context(), readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	tags, err := s.loadRoomTags(r.Context(), room.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, chatTagsOutput(string(room.ID), tags))
+}
+
+// setChatTags replaces the chat's tags with the given set. Tags are changed
+// one by one through the tag API because homeservers refuse m.tag writes via
+// the generic account data endpoint.
+func (s *Server) setChatTags(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	if req.Tags == nil {
+		return errs.Validation(map[string]any{"tags": "tags is required"})
+	}
+	room, err := s.loadChatRoom(r.Context(), readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	current, err := s.loadRoomTags(r.Context(), room.ID)
+	if err != nil {
+		return err
+	}
+	desired := make(map[event.RoomTag]event.TagMetadata, len(req.Tags))
+	for _, label := range req.Tags {
+		tag := normalizeRoomTag(label)
+		if tag == "" {
+			return errs.Validation(map[string]any{"tags": "tags must not be empty"})
+		}
+		desired[tag] = current[tag]
+	}
+	for tag := range current {
+		if _, keep := desired[tag]; !keep {
+			if err = s.removeRoomTag(r.Context(), string(room.ID), tag); err != nil {
+				return err
+			}
+		}
+	}
+	for tag := range desired {
+		if _, exists := current[tag]; !exists {
+			if err = s.addRoomTag(r.Context(), string(room.ID), tag); err != nil {
+				return err
+			}
+		}
+	}
+	return writeJSON(w, chatTagsOutput(string(room.ID), desired))
+}
+
+func (s *Server) deleteChatTag(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Tag string `json:"tag"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	label := req.Tag
+	if label == "" {
+		label = r.URL.Query().Get("tag")
+	}
+	tag := normalizeRoomTag(label)
+	if tag == "" {
+		return errs.Validation(map[string]any{"tag": "tag is required"})
+	}
+	room, err := s.loadChatRoom(r.Context(), readChatID(r, ""))
+	if err != nil {
+		return err
+	}
+	current, err := s.loadRoomTags(r.Context(), room.ID)
+	if err != nil {
+		return err
+	}
+	if _, ok := current[tag]; !ok {
+		return errs.NotFound("Tag not found")
+	}
+	if err = s.removeRoomTag(r.Context(), string(room.ID), tag); err != nil {
+		return err
+	}
+	delete(current, tag)
+	return writeJSON(w, chatTagsOutput(string(room.ID), current))
+}
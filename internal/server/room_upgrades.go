@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/id"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// maxRoomUpgradeHops bounds tombstone chains so a malicious or broken
+// replacement_room loop cannot stall a request.
+const maxRoomUpgradeHops = 16
+
+// followRoomChain walks next from start until it stops, revisits a room or
+// runs out of hops, returning every room visited after start in order.
+func followRoomChain(start id.RoomID, next func(id.RoomID) (id.RoomID, bool)) []id.RoomID {
+	seen := map[id.RoomID]struct{}{start: {}}
+	chain := make([]id.RoomID, 0)
+	current := start
+	for len(chain) < maxRoomUpgradeHops {
+		following, ok := next(current)
+		if !ok || following == "" {
+			break
+		}
+		if _, loop := seen[following]; loop {
+			break
+		}
+		seen[following] = struct{}{}
+		chain = append(chain, following)
+		current = following
+	}
+	return chain
+}
+
+// replacesRoom reports whether successor was created as the upgrade of
+// roomID. Any room admin can send a tombstone, so it is only trusted when the
+// replacement's create event points back, as Element does.
+func replacesRoom(successor *database.Room, roomID id.RoomID) bool {
+	return successor.CreationContent.GetPredecessor().RoomID == roomID
+}
+
+// resolveLatestRoomID maps a chat ID onto the newest joined room in its
+// upgrade chain, so that clients holding a pre-upgrade ID keep working after
+// a bridge replaces the room.
+func (s *Server) resolveLatestRoomID(ctx context.Context, roomID id.RoomID) (id.RoomID, error) {
+	db := s.rt.Client().DB
+	var lookupErr error
+	chain := followRoomChain(roomID, func(current id.RoomID) (id.RoomID, bool) {
+		room, err := db.Room.Get(ctx, current)
+		if err != nil {
+			lookupErr = err
+			return "", false
+		}
+		if room == nil || room.Tombstone == nil {
+			return "", false
+		}
+		successor, err := db.Room.Get(ctx, room.Tombstone.ReplacementRoom)
+		if err != nil {
+			lookupErr = err
+			return "", false
+		}
+		if successor == nil {
+			// Not joined yet; the old room is still the only readable one.
+			return "", false
+		}
+		return successor.ID, replacesRoom(successor, current)
+	})
+	if lookupErr != nil {
+		return "", errs.Internal(fmt.Errorf("failed to resolve room upgrades: %w", lookupErr))
+	}
+	if len(chain) == 0 {
+		return roomID, nil
+	}
+	return chain[len(chain)-1], nil
+}
+
+func (s *Server) resolveLatestChatID(ctx context.Context, chatID string) (string, error) {
+	roomID, err := s.resolveLatestRoomID(ctx, id.RoomID(chatID))
+	return string(roomID), err
+}
+
+// loadPredecessorChatIDs lists the rooms this one replaced, newest first.
+// The chain stops at the first predecessor that was never joined locally.
+func (s *Server) loadPredecessorChatIDs(ctx context.Context, room *database.Room) []string {
+	if room.CreationContent == nil || room.CreationContent.Predecessor == nil {
+		return nil
+	}
+	db := s.rt.Client().DB
+	rooms := map[id.RoomID]*database.Room{room.ID: room}
+	chain := followRoomChain(room.ID, func(current id.RoomID) (id.RoomID, bool) {
+		currentRoom := rooms[current]
+		if currentRoom == nil {
+			loaded, err := db.Room.Get(ctx, current)
+			if err != nil || loaded == nil {
+				return "", false
+			}
+			currentRoom = loaded
+			rooms[current] = loaded
+		}
+		predecessor := currentRoom.CreationContent.GetPredecessor().RoomID
+		return predecessor, predecessor != ""
+	})
+	ids := make([]string, len(chain))
+	for idx, roomID := range chain {
+		ids[idx] = string(roomID)
+	}
+	return ids
+}
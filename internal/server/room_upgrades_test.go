@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestFollowRoomChainStopsOnLoops(t *testing.T) {
+	successors := map[id.RoomID]id.RoomID{"!a": "!b", "!b": "!c", "!c": "!a"}
+	chain := followRoomChain("!a", func(current id.RoomID) (id.RoomID, bool) {
+		next, ok := successors[current]
+		return next, ok
+	})
+	if len(chain) != 2 || chain[0] != "!b" || chain[1] != "!c" {
+		t.Fatalf("expected [!b !c], got %v", chain)
+	}
+}
+
+func TestFollowRoomChainWithoutSuccessorIsEmpty(t *testing.T) {
+	chain := followRoomChain("!a", func(id.RoomID) (id.RoomID, bool) { return "", false })
+	if len(chain) != 0 {
+		t.Fatalf("expected empty chain, got %v", chain)
+	}
+}
+
+func TestReplacesRoomRequiresPredecessorBackLink(t *testing.T) {
+	upgraded := &database.Room{ID: "!new", CreationContent: &event.CreateEventContent{Predecessor: &event.Predecessor{RoomID: "!old"}}}
+	if !replacesRoom(upgraded, "!old") {
+		t.Fatal("expected successor pointing back to be followed")
+	}
+	if replacesRoom(upgraded, "!other") {
+		t.Fatal("expected successor of another room to be ignored")
+	}
+	if replacesRoom(&database.Room{ID: "!private"}, "!old") {
+		t.Fatal("expected room without predecessor to be ignored")
+	}
+}
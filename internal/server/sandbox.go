@@ -0,0 +1,271 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	sandboxStoreFormat = 1
+	defaultSandboxName = "EasyMatrix sandbox"
+)
+
+// sandboxStateEventType marks a room as a sandbox so that other clients of
+// the same account can recognise (and ignore) test traffic.
+var sandboxStateEventType = event.Type{Type: "com.easymatrix.sandbox", Class: event.StateEventType}
+
+type sandboxStore struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	replica replicaFile
+	entries map[string]compat.Sandbox
+}
+
+type sandboxStorePersisted struct {
+	Version   int              `json:"version"`
+	Sandboxes []compat.Sandbox `json:"sandboxes"`
+}
+
+type createSandboxInput struct {
+	Name string `json:"name,omitempty"`
+	// Route defaults to true; set it to false to only provision the room.
+	Route *bool `json:"route,omitempty"`
+}
+
+func newSandboxStore(path string) *sandboxStore {
+	return &sandboxStore{path: path}
+}
+
+func (c *sandboxStore) loadLocked() error {
+	stamp, changed, err := c.replica.changed(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read sandboxes: %w", err)
+	}
+	if c.loaded && !changed {
+		return nil
+	}
+	entries := make(map[string]compat.Sandbox)
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.entries, c.replica.seen, c.loaded = entries, stamp, true
+			return nil
+		}
+		return fmt.Errorf("failed to read sandboxes: %w", err)
+	}
+	var persisted sandboxStorePersisted
+	if err = json.Unmarshal(raw, &persisted); err != nil {
+		return fmt.Errorf("failed to parse sandboxes: %w", err)
+	}
+	if persisted.Version != sandboxStoreFormat {
+		return fmt.Errorf("unsupported sandbox store version: %d", persisted.Version)
+	}
+	for _, sandbox := range persisted.Sandboxes {
+		entries[sandbox.ChatID] = sandbox
+	}
+	c.entries, c.replica.seen, c.loaded = entries, stamp, true
+	return nil
+}
+
+func (c *sandboxStore) saveLocked() error {
+	persisted := sandboxStorePersisted{Version: sandboxStoreFormat, Sandboxes: c.sortedLocked()}
+	raw, err := json.Marshal(persisted)
+	if err != nil {
+		return fmt.Errorf("failed to encode sandboxes: %w", err)
+	}
+	return writeAtomicFile(c.path, raw, 0o600)
+}
+
+func (c *sandboxStore) sortedLocked() []compat.Sandbox {
+	items := make([]compat.Sandbox, 0, len(c.entries))
+	for _, sandbox := range c.entries {
+		items = append(items, sandbox)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.Before(items[j].CreatedAt)
+		}
+		return items[i].ChatID < items[j].ChatID
+	})
+	return items
+}
+
+func (c *sandboxStore) list() ([]compat.Sandbox, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return nil, err
+	}
+	return c.sortedLocked(), nil
+}
+
+func (c *sandboxStore) get(chatID string) (compat.Sandbox, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return compat.Sandbox{}, false, err
+	}
+	sandbox, ok := c.entries[chatID]
+	return sandbox, ok, nil
+}
+
+// put stores a sandbox. A routed sandbox replaces any earlier route for the
+// same client so that traffic only ever goes to one place.
+func (c *sandboxStore) put(sandbox compat.Sandbox) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return err
+	}
+	if sandbox.Route {
+		for chatID, existing := range c.entries {
+			if existing.ClientID == sandbox.ClientID && existing.Route {
+				existing.Route = false
+				c.entries[chatID] = existing
+			}
+		}
+	}
+	c.entries[sandbox.ChatID] = sandbox
+	return c.saveLocked()
+}
+
+func (c *sandboxStore) remove(chatID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return err
+	}
+	delete(c.entries, chatID)
+	return c.saveLocked()
+}
+
+// routeFor returns the sandbox chat that should receive the client's
+// chat writes, or "" when the client is not sandboxed.
+func (c *sandboxStore) routeFor(clientID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return "", err
+	}
+	for _, sandbox := range c.entries {
+		if sandbox.Route && sandbox.ClientID == clientID {
+			return sandbox.ChatID, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *Server) listSandboxes(w http.ResponseWriter, r *http.Request) error {
+	items, err := s.sandboxes.list()
+	if err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, compat.ListSandboxesOutput{Items: items})
+}
+
+func (s *Server) createSandbox(w http.ResponseWriter, r *http.Request) error {
+	var req createSandboxInput
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = defaultSandboxName
+	}
+	if len([]rune(name)) > maxChatTitleLength {
+		return errs.Validation(map[string]any{"name": "name is too long"})
+	}
+	clientID := requestClientID(r)
+	createdAt := time.Now().UTC()
+
+	resp, err := s.rt.Client().Client.CreateRoom(r.Context(), &mautrix.ReqCreateRoom{
+		Visibility: "private",
+		Preset:     "private_chat",
+		Name:       name,
+		Topic:      "Test traffic only; messages here never reach a real conversation.",
+		InitialState: []*event.Event{{
+			Type:    sandboxStateEventType,
+			Content: event.Content{Raw: map[string]any{"client_id": clientID, "created_at": createdAt.UnixMilli()}},
+		}},
+	})
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to create sandbox chat: %w", err))
+	}
+	sandbox := compat.Sandbox{
+		ChatID:    string(resp.RoomID),
+		Name:      name,
+		ClientID:  clientID,
+		Route:     req.Route == nil || *req.Route,
+		CreatedAt: createdAt,
+	}
+	if err = s.sandboxes.put(sandbox); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, sandbox)
+}
+
+// purgeSandbox leaves and forgets the sandbox room. As the only member, this
+// lets the homeserver discard the room and its test traffic.
+func (s *Server) purgeSandbox(w http.ResponseWriter, r *http.Request) error {
+	chatID := readChatID(r, "")
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	if _, ok, err := s.sandboxes.get(chatID); err != nil {
+		return errs.Internal(err)
+	} else if !ok {
+		return errs.NotFound("Sandbox not found")
+	}
+	cli := s.rt.Client()
+	roomID := id.RoomID(chatID)
+	if _, err := cli.Client.LeaveRoom(r.Context(), roomID); err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return errs.Internal(fmt.Errorf("failed to leave sandbox chat: %w", err))
+	}
+	if _, err := cli.Client.ForgetRoom(r.Context(), roomID); err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return errs.Internal(fmt.Errorf("failed to forget sandbox chat: %w", err))
+	}
+	if err := s.sandboxes.remove(chatID); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+// sandboxChatWrite reports whether a route writes to the chat in its path,
+// which is what a routed sandbox takes over.
+func sandboxChatWrite(pattern string, requiredScopes []string) bool {
+	_, path, _ := strings.Cut(pattern, " ")
+	return slices.Contains(requiredScopes, "write") && strings.HasPrefix(path, "/v1/chats/{chatID}")
+}
+
+// routeToSandbox points a chat write at the client's routed sandbox, so
+// sends, edits, reactions, deletes and every other chat write stay out of
+// real chats. Without a routed sandbox the request is left unchanged.
+func (s *Server) routeToSandbox(handler apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		sandboxID, err := s.sandboxes.routeFor(requestClientID(r))
+		if err != nil {
+			return errs.Internal(err)
+		}
+		if sandboxID != "" {
+			r.SetPathValue("chatID", sandboxID)
+		}
+		return handler(w, r)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestSandboxStoreRoutesToLatestSandboxPerClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sandboxes.json")
+	store := newSandboxStore(path)
+	now := time.Now().UTC()
+	for _, sandbox := range []compat.Sandbox{
+		{ChatID: "!one:example.org", ClientID: "agent", Route: true, CreatedAt: now},
+		{ChatID: "!two:example.org", ClientID: "agent", Route: true, CreatedAt: now.Add(time.Second)},
+		{ChatID: "!other:example.org", ClientID: "other", Route: false, CreatedAt: now},
+	} {
+		if err := store.put(sandbox); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+
+	reloaded := newSandboxStore(path)
+	if chatID, err := reloaded.routeFor("agent"); err != nil || chatID != "!two:example.org" {
+		t.Fatalf("expected route to latest sandbox, got %q (%v)", chatID, err)
+	}
+	if chatID, _ := reloaded.routeFor("other"); chatID != "" {
+		t.Fatalf("expected unrouted client to keep its target, got %q", chatID)
+	}
+
+	if err := reloaded.remove("!two:example.org"); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if chatID, _ := reloaded.routeFor("agent"); chatID != "" {
+		t.Fatalf("expected no route after purge, got %q", chatID)
+	}
+	items, _ := reloaded.list()
+	if len(items) != 2 {
+		t.Fatalf("expected 2 remaining sandboxes, got %d", len(items))
+	}
+}
+
+func TestRouteToSandboxRedirectsEveryChatWrite(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		scopes  []string
+		want    bool
+	}{
+		{"POST /v1/chats/{chatID}/messages", []string{"write"}, true},
+		{"PUT /v1/chats/{chatID}/messages/{messageID}", []string{"write"}, true},
+		{"POST /v1/chats/{chatID}/messages/{messageID}/reactions", []string{"write"}, true},
+		{"DELETE /v1/chats/{chatID}/messages/{messageID}", []string{"write"}, true},
+		{"GET /v1/chats/{chatID}/messages", []string{"read"}, false},
+		{"DELETE /v1/admin/sandbox/{chatID}", []string{"write"}, false},
+	} {
+		if got := sandboxChatWrite(tc.pattern, tc.scopes); got != tc.want {
+			t.Fatalf("sandboxChatWrite(%q) = %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+
+	s := &Server{sandboxes: newSandboxStore(filepath.Join(t.TempDir(), "sandboxes.json"))}
+	if err := s.sandboxes.put(compat.Sandbox{ChatID: "!sandbox:example.org", ClientID: oauthStaticClientID, Route: true}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	var got string
+	handler := s.routeToSandbox(func(w http.ResponseWriter, r *http.Request) error {
+		got = r.PathValue("chatID")
+		return nil
+	})
+	req := httptest.NewRequest(http.MethodDelete, "/v1/chats/!real:example.org/messages/$event", nil)
+	req.SetPathValue("chatID", "!real:example.org")
+	if err := handler(httptest.NewRecorder(), req); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if got != "!sandbox:example.org" {
+		t.Fatalf("expected write routed to sandbox, got %q", got)
+	}
+}
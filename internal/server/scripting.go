@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	scriptsDirName       = "scripts"
+	scriptEventQueueSize = 256
+	scriptHandlerTimeout = 30 * time.Second
+	scriptAPITableName   = "easymatrix"
+)
+
+// scriptHost runs Lua scripts from <state dir>/scripts against domain events.
+// All Lua states are owned by a single worker goroutine, so handlers never run
+// concurrently and scripts do not need to care about locking.
+type scriptHost struct {
+	server  *Server
+	scripts []*scriptInstance
+	queue   chan scriptEvent
+}
+
+type scriptInstance struct {
+	name         string
+	state        *lua.LState
+	onMessage    []*lua.LFunction
+	onChatUpdate []*lua.LFunction
+}
+
+type scriptEvent struct {
+	domainEvent wsDomainEvent
+	entries     []compatRecord
+}
+
+func (s *Server) startScripts(ctx context.Context) error {
+	if !s.cfg.ScriptsEnabled {
+		return nil
+	}
+	dir := filepath.Join(s.rt.StateDir(), scriptsDirName)
+	paths, err := listScriptFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		log.Printf("scripting enabled but no *.lua files found in %s", dir)
+		return nil
+	}
+
+	host := &scriptHost{
+		server: s,
+		queue:  make(chan scriptEvent, scriptEventQueueSize),
+	}
+	for _, path := range paths {
+		script, loadErr := host.load(path)
+		if loadErr != nil {
+			log.Printf("failed to load script %s: %v", filepath.Base(path), loadErr)
+			continue
+		}
+		host.scripts = append(host.scripts, script)
+	}
+	if len(host.scripts) == 0 {
+		return nil
+	}
+	if err = s.ws.ensureSubscription(); err != nil {
+		return err
+	}
+	s.ws.addListener(host.enqueue)
+	go host.run(ctx)
+	return nil
+}
+
+func listScriptFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read scripts dir: %w", err)
+	}
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".lua") {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (h *scriptHost) load(path string) (*scriptInstance, error) {
+	script := &scriptInstance{
+		name:  filepath.Base(path),
+		state: newSandboxedLuaState(),
+	}
+	h.registerAPI(script)
+	if err := script.state.DoFile(path); err != nil {
+		script.state.Close()
+		return nil, err
+	}
+	return script, nil
+}
+
+// newSandboxedLuaState opens only the side-effect free standard libraries.
+// io, os and package are left out so scripts can only reach the outside world
+// through the easymatrix API table.
+func newSandboxedLuaState() *lua.LState {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		state.Push(state.NewFunction(lib.fn))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+	return state
+}
+
+func (h *scriptHost) registerAPI(script *scriptInstance) {
+	state := script.state
+	state.SetGlobal("on_message", state.NewFunction(func(L *lua.LState) int {
+		script.onMessage = append(script.onMessage, L.CheckFunction(1))
+		return 0
+	}))
+	state.SetGlobal("on_chat_update", state.NewFunction(func(L *lua.LState) int {
+		script.onChatUpdate = append(script.onChatUpdate, L.CheckFunction(1))
+		return 0
+	}))
+
+	api := state.NewTable()
+	state.SetFuncs(api, map[string]lua.LGFunction{
+		"send": func(L *lua.LState) int {
+			evt, err := h.server.sendTextMessage(scriptContext(L), L.CheckString(1), L.CheckString(2), "")
+			return pushScriptResult(L, evt, err)
+		},
+		"react": func(L *lua.LState) int {
+			evt, err := h.server.sendReaction(scriptContext(L), L.CheckString(1), L.CheckString(2), L.CheckString(3))
+			return pushScriptResult(L, evt, err)
+		},
+		"archive": func(L *lua.LState) int {
+			archived := true
+			if L.GetTop() >= 2 {
+				archived = L.ToBool(2)
+			}
+			err := h.server.setChatArchived(scriptContext(L), L.CheckString(1), archived)
+			return pushScriptResult(L, nil, err)
+		},
+		"log": func(L *lua.LState) int {
+			parts := make([]string, 0, L.GetTop())
+			for i := 1; i <= L.GetTop(); i++ {
+				parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+			}
+			log.Printf("script %s: %s", script.name, strings.Join(parts, " "))
+			return 0
+		},
+	})
+	state.SetGlobal(scriptAPITableName, api)
+}
+
+// scriptContext falls back to a background context for API calls made while a
+// script is being loaded, before any handler context is attached.
+func scriptContext(L *lua.LState) context.Context {
+	if ctx := L.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// pushScriptResult returns (id) on success or (nil, error message) on failure,
+// following the usual Lua convention instead of raising.
+func pushScriptResult(L *lua.LState, evt *database.Event, err error) int {
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	if evt == nil {
+		L.Push(lua.LTrue)
+		return 1
+	}
+	if evt.ID != "" {
+		L.Push(lua.LString(evt.ID))
+	} else {
+		L.Push(lua.LString(evt.TransactionID))
+	}
+	return 1
+}
+
+func (h *scriptHost) enqueue(domainEvent wsDomainEvent, entries []compatRecord) {
+	if domainEvent.Type != wsDomainTypeMessageUpserted && domainEvent.Type != wsDomainTypeChatUpserted {
+		return
+	}
+	select {
+	case h.queue <- scriptEvent{domainEvent: domainEvent, entries: entries}:
+	default:
+		log.Printf("script event queue is full, dropping %s for %s", domainEvent.Type, domainEvent.ChatID)
+	}
+}
+
+func (h *scriptHost) run(ctx context.Context) {
+	defer h.close()
+	for {
+		var evt scriptEvent
+		select {
+		case <-ctx.Done():
+			return
+		case evt = <-h.queue:
+		}
+		switch evt.domainEvent.Type {
+		case wsDomainTypeMessageUpserted:
+			for _, entry := range evt.entries {
+				h.dispatch(ctx, func(script *scriptInstance) []*lua.LFunction { return script.onMessage }, entry)
+			}
+		case wsDomainTypeChatUpserted:
+			if !h.hasChatHandlers() {
+				continue
+			}
+			chat, err := h.server.hydrateChatForEvent(ctx, evt.domainEvent.ChatID)
+			if err != nil || chat == nil {
+				continue
+			}
+			h.dispatch(ctx, func(script *scriptInstance) []*lua.LFunction { return script.onChatUpdate }, chat)
+		}
+	}
+}
+
+func (h *scriptHost) close() {
+	for _, script := range h.scripts {
+		script.state.Close()
+	}
+}
+
+func (h *scriptHost) hasChatHandlers() bool {
+	for _, script := range h.scripts {
+		if len(script.onChatUpdate) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *scriptHost) dispatch(ctx context.Context, handlers func(*scriptInstance) []*lua.LFunction, payload compatRecord) {
+	for _, script := range h.scripts {
+		for _, fn := range handlers(script) {
+			callCtx, cancel := context.WithTimeout(ctx, scriptHandlerTimeout)
+			script.state.SetContext(callCtx)
+			err := script.state.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, toLuaValue(script.state, map[string]any(payload)))
+			script.state.RemoveContext()
+			cancel()
+			if err != nil {
+				log.Printf("script %s handler failed: %v", script.name, err)
+			}
+		}
+	}
+}
+
+func toLuaValue(state *lua.LState, value any) lua.LValue {
+	switch typed := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(typed)
+	case float64:
+		return lua.LNumber(typed)
+	case string:
+		return lua.LString(typed)
+	case []any:
+		table := state.NewTable()
+		for _, item := range typed {
+			table.Append(toLuaValue(state, item))
+		}
+		return table
+	case map[string]any:
+		table := state.NewTable()
+		for key, item := range typed {
+			table.RawSetString(key, toLuaValue(state, item))
+		}
+		return table
+	default:
+		return lua.LString(fmt.Sprint(typed))
+	}
+}
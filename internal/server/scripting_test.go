@@ -0,0 +1,64 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestSandboxedLuaStateHidesHostLibraries(t *testing.T) {
+	state := newSandboxedLuaState()
+	defer state.Close()
+
+	for _, name := range []string{"os", "io", "dofile", "require"} {
+		if value := state.GetGlobal(name); value != lua.LNil {
+			t.Fatalf("expected %s to be unavailable, got %s", name, value.Type())
+		}
+	}
+	if err := state.DoString(`assert(string.upper("ok") == "OK")`); err != nil {
+		t.Fatalf("expected string library to be available: %v", err)
+	}
+}
+
+func TestToLuaValueConvertsNestedRecords(t *testing.T) {
+	state := newSandboxedLuaState()
+	defer state.Close()
+
+	state.SetGlobal("msg", toLuaValue(state, map[string]any{
+		"text":        "hello",
+		"isSender":    true,
+		"attachments": []any{map[string]any{"fileName": "a.png"}},
+	}))
+	err := state.DoString(`
+		assert(msg.text == "hello")
+		assert(msg.isSender == true)
+		assert(msg.attachments[1].fileName == "a.png")
+	`)
+	if err != nil {
+		t.Fatalf("unexpected lua error: %v", err)
+	}
+}
+
+func TestListScriptFilesOnlyReturnsLuaFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.lua", "a.lua", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("-- test"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	paths, err := listScriptFiles(dir)
+	if err != nil {
+		t.Fatalf("listScriptFiles returned error: %v", err)
+	}
+	if len(paths) != 2 || filepath.Base(paths[0]) != "a.lua" || filepath.Base(paths[1]) != "b.lua" {
+		t.Fatalf("unexpected script list: %v", paths)
+	}
+
+	missing, err := listScriptFiles(filepath.Join(dir, "missing"))
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("expected missing dir to be ignored, got %v, %v", missing, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"go.mau.fi/gomuks/pkg/hicli/database"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/bridgev2/provisionutil"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"github.com/batuhan/easymatrix/internal/compat"
@@ -30,6 +32,10 @@ const (
 	unifiedChatSectionLimit    = 30
 	unifiedMessageSectionLimit = 20
 
+	unifiedSearchSectionChats    = "chats"
+	unifiedSearchSectionInGroups = "in_groups"
+	unifiedSearchSectionMessages = "messages"
+
 	searchMessagesScanBatchSize  = 500
 	searchMessagesScanMaxEvents  = 5000
 	searchMessagesScanMaxBatches = 20
@@ -57,7 +63,7 @@ type searchChatsParams struct {
 	Query              string
 	Scope              string
 	Inbox              string
-	Type               string
+	Type               compat.ChatType
 	Direction          string
 	Cursor             *cursor.ChatCursor
 	Limit              int
@@ -66,6 +72,9 @@ type searchChatsParams struct {
 	LastActivityBefore *time.Time
 	LastActivityAfter  *time.Time
 	AccountIDs         []string
+	// Tags keeps chats carrying at least one of the given room tags.
+	Tags       []string
+	Visibility *subjectPolicy
 }
 
 type searchMessagesParams struct {
@@ -75,13 +84,20 @@ type searchMessagesParams struct {
 	Limit              int
 	ChatIDs            []string
 	AccountIDs         []string
-	ChatType           string
+	ChatType           compat.ChatType
 	Sender             string
 	MediaTypes         []string
 	DateAfter          *time.Time
 	DateBefore         *time.Time
 	ExcludeLowPriority bool
 	IncludeMuted       bool
+	TextFormat         messageTextFormat
+	ClientID           string
+	Annotations        []annotationFilter
+	IncludeAnnotations bool
+	Visibility         *subjectPolicy
+	// Phrases come from quoted parts of the query and must match verbatim.
+	Phrases []string
 }
 
 type reminderInput struct {
@@ -107,6 +123,7 @@ func (s *Server) searchChats(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	params.Visibility = s.requestPolicy(r)
 	out, err := s.searchChatsCore(r.Context(), params)
 	if err != nil {
 		return err
@@ -119,6 +136,7 @@ func (s *Server) searchMessages(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	params.Visibility = s.requestPolicy(r)
 	out, err := s.searchMessagesCore(r.Context(), params)
 	if err != nil {
 		return err
@@ -135,6 +153,10 @@ func (s *Server) searchContacts(w http.ResponseWriter, r *http.Request) error {
 	if query == "" {
 		return errs.Validation(map[string]any{"query": "query is required"})
 	}
+	forceRefresh, err := parseOptionalBool(r.URL.Query().Get("forceRefresh"), false, "forceRefresh")
+	if err != nil {
+		return err
+	}
 	lookup, err := s.buildAccountLookup(r.Context())
 	if err != nil {
 		return err
@@ -142,7 +164,7 @@ func (s *Server) searchContacts(w http.ResponseWriter, r *http.Request) error {
 	if _, ok := lookup.ByID[accountID]; !ok {
 		return errs.NotFound("Account not found")
 	}
-	items, err := s.loadAccountContacts(r.Context(), lookup, accountID, query)
+	items, err := s.loadAccountContacts(r.Context(), lookup, accountID, query, forceRefresh)
 	if err != nil {
 		return err
 	}
@@ -183,7 +205,7 @@ func (s *Server) searchUsersV0(w http.ResponseWriter, r *http.Request) error {
 		if user == nil {
 			continue
 		}
-		items = append(items, newCompatUser(userShape{
+		items = append(items, newCompatUser(r.Context(), userShape{
 			ID:            user.UserID.String(),
 			Username:      userIDLocalpart(user.UserID.String()),
 			FullName:      user.DisplayName,
@@ -220,8 +242,12 @@ func (s *Server) listContacts(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	forceRefresh, err := parseOptionalBool(r.URL.Query().Get("forceRefresh"), false, "forceRefresh")
+	if err != nil {
+		return err
+	}
 
-	contacts, err := s.loadAccountContacts(r.Context(), lookup, accountID, strings.TrimSpace(r.URL.Query().Get("query")))
+	contacts, err := s.loadAccountContacts(r.Context(), lookup, accountID, strings.TrimSpace(r.URL.Query().Get("query")), forceRefresh)
 	if err != nil {
 		return err
 	}
@@ -287,47 +313,115 @@ func (s *Server) search(w http.ResponseWriter, r *http.Request) error {
 	if query == "" {
 		return errs.Validation(map[string]any{"query": "query is required"})
 	}
-
-	chatsResult, err := s.searchChatsCore(r.Context(), searchChatsParams{
-		Query:        query,
-		Scope:        "titles",
-		Type:         "any",
-		Direction:    "before",
-		Limit:        unifiedChatSectionLimit,
-		IncludeMuted: true,
-	})
+	textFormat, err := parseTextFormat(r.URL.Query().Get("textFormat"))
 	if err != nil {
 		return err
 	}
-	inGroupsResult, err := s.searchChatsCore(r.Context(), searchChatsParams{
-		Query:        query,
-		Scope:        "participants",
-		Type:         "any",
-		Direction:    "before",
-		Limit:        unifiedChatSectionLimit,
-		IncludeMuted: true,
-	})
+	section, err := parseUnifiedSearchSection(r.URL.Query().Get("section"))
 	if err != nil {
 		return err
 	}
-	messagesResult, err := s.searchMessagesCore(r.Context(), searchMessagesParams{
-		Query:              query,
-		Direction:          "before",
-		Limit:              unifiedMessageSectionLimit,
-		IncludeMuted:       true,
-		ExcludeLowPriority: true,
-	})
-	if err != nil {
-		return err
+	rawCursor := r.URL.Query().Get("cursor")
+	if rawCursor != "" && section == "" {
+		return errs.Validation(map[string]any{"cursor": "cursor requires section"})
 	}
 
-	return writeJSON(w, compat.UnifiedSearchOutput{
-		Results: compat.UnifiedSearchResults{
-			Chats:    chatsResult.Items,
-			InGroups: inGroupsResult.Items,
-			Messages: messagesResult,
-		},
-	})
+	visibility := s.requestPolicy(r)
+	var out compat.UnifiedSearchOutput
+	if section == "" || section == unifiedSearchSectionChats {
+		chatCursor, err := parseChatCursor(rawCursor)
+		if err != nil {
+			return err
+		}
+		chatsResult, err := s.searchChatsCore(r.Context(), searchChatsParams{
+			Query:        query,
+			Scope:        "titles",
+			Direction:    "before",
+			Cursor:       chatCursor,
+			Limit:        unifiedChatSectionLimit,
+			IncludeMuted: true,
+			Visibility:   visibility,
+		})
+		if err != nil {
+			return err
+		}
+		out.Results.Chats = chatsResult.Items
+		out.Cursors.Chats = sectionCursor(chatsResult.HasMore, chatsResult.OldestCursor)
+	}
+	if section == "" || section == unifiedSearchSectionInGroups {
+		chatCursor, err := parseChatCursor(rawCursor)
+		if err != nil {
+			return err
+		}
+		inGroupsResult, err := s.searchChatsCore(r.Context(), searchChatsParams{
+			Query:        query,
+			Scope:        "participants",
+			Direction:    "before",
+			Cursor:       chatCursor,
+			Limit:        unifiedChatSectionLimit,
+			IncludeMuted: true,
+			Visibility:   visibility,
+		})
+		if err != nil {
+			return err
+		}
+		out.Results.InGroups = inGroupsResult.Items
+		out.Cursors.InGroups = sectionCursor(inGroupsResult.HasMore, inGroupsResult.OldestCursor)
+	}
+	if section == "" || section == unifiedSearchSectionMessages {
+		messageCursor, err := parseMessageCursor(rawCursor)
+		if err != nil {
+			return err
+		}
+		messagesResult, err := s.searchMessagesCore(r.Context(), searchMessagesParams{
+			Query:              query,
+			Direction:          "before",
+			Cursor:             messageCursor,
+			Limit:              unifiedMessageSectionLimit,
+			IncludeMuted:       true,
+			ExcludeLowPriority: true,
+			TextFormat:         textFormat,
+			Visibility:         visibility,
+		})
+		if err != nil {
+			return err
+		}
+		out.Results.Messages = messagesResult
+		out.Cursors.Messages = sectionCursor(messagesResult.HasMore, messagesResult.OldestCursor)
+	}
+	if out.Results.Chats == nil {
+		out.Results.Chats = []compat.Chat{}
+	}
+	if out.Results.InGroups == nil {
+		out.Results.InGroups = []compat.Chat{}
+	}
+	if out.Results.Messages.Items == nil {
+		out.Results.Messages.Items = []compat.Message{}
+		out.Results.Messages.Chats = map[string]compat.Chat{}
+	}
+	return writeJSON(w, out)
+}
+
+// parseUnifiedSearchSection accepts the section names used as result keys.
+// An empty section searches all of them.
+func parseUnifiedSearchSection(raw string) (string, error) {
+	switch section := strings.TrimSpace(raw); section {
+	case "", unifiedSearchSectionChats, unifiedSearchSectionInGroups, unifiedSearchSectionMessages:
+		return section, nil
+	case "inGroups":
+		return unifiedSearchSectionInGroups, nil
+	default:
+		return "", errs.Validation(map[string]any{"section": "section must be one of: chats, in_groups, messages"})
+	}
+}
+
+// sectionCursor only hands out a cursor when the section has more results,
+// so clients can stop paging on a nil cursor.
+func sectionCursor(hasMore bool, oldest *string) *string {
+	if !hasMore {
+		return nil
+	}
+	return oldest
 }
 
 func (s *Server) focusApp(w http.ResponseWriter, r *http.Request) error {
@@ -364,6 +458,16 @@ func (s *Server) createChat(w http.ResponseWriter, r *http.Request) error {
 	if req.Mode != "create" && req.Mode != "start" {
 		return errs.Validation(map[string]any{"mode": "must be one of: create, start"})
 	}
+	// The account comes from the body, so the route-level policy check never
+	// sees it.
+	policy := s.requestPolicy(r)
+	if !policy.allowsAccount(req.AccountID) {
+		return errs.NotFound("Account not found")
+	}
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		return err
+	}
 
 	lookup, err := s.buildAccountLookup(r.Context())
 	if err != nil {
@@ -374,28 +478,93 @@ func (s *Server) createChat(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if req.Mode == "start" {
-		return s.startChat(w, r, req, lookup)
+		return s.startChat(w, r, req, lookup, policy, dryRun)
 	}
 
-	chatType := strings.TrimSpace(string(req.Type))
-	if chatType != "single" && chatType != "group" {
+	chatType, ok := compat.ParseChatType(req.Type)
+	if !ok {
 		return errs.Validation(map[string]any{"type": "must be one of: single, group"})
 	}
 	if len(req.ParticipantIDs) == 0 {
 		return errs.Validation(map[string]any{"participantIDs": "at least one participantID is required"})
 	}
-	if chatType == "single" && len(req.ParticipantIDs) != 1 {
+	if chatType == compat.ChatTypeSingle && len(req.ParticipantIDs) != 1 {
 		return errs.Validation(map[string]any{"participantIDs": "single chats require exactly one participantID"})
 	}
 
-	chatID, err := s.createChatRoom(r.Context(), chatType, req.ParticipantIDs, req.Title, req.MessageText)
+	req.AvatarUploadID = strings.TrimSpace(req.AvatarUploadID)
+	req.Description = strings.TrimSpace(req.Description)
+	if chatType == compat.ChatTypeSingle && (req.AvatarUploadID != "" || req.Description != "" || req.PowerLevels != nil) {
+		return errs.Validation(map[string]any{"type": "avatarUploadID, description and powerLevels are only supported for group chats"})
+	}
+	if len([]rune(req.Description)) > maxChatDescriptionLength {
+		return errs.Validation(map[string]any{"description": "description is too long"})
+	}
+	opts := chatRoomOptions{Title: req.Title, MessageText: req.MessageText, Description: req.Description, Encrypted: req.Encrypted}
+	if opts.PowerLevels, err = createChatPowerLevels(req.PowerLevels, s.rt.Client().Account.UserID); err != nil {
+		return err
+	}
+
+	// Network identifiers are resolved to the bridge's Matrix users up front,
+	// so they can be matched against existing chats and invited.
+	var users []compat.User
+	var issues []compat.DryRunIssue
+	if dryRun {
+		users, issues, err = s.participantIDIssues(r.Context(), req.AccountID, req.ParticipantIDs)
+	} else {
+		users, err = s.resolveParticipants(r.Context(), req.AccountID, req.ParticipantIDs)
+	}
 	if err != nil {
 		return err
 	}
-	return writeJSON(w, newCreateChatOutput(chatID, ""))
+	participantIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		participantIDs = append(participantIDs, user.ID)
+	}
+
+	existingChatID := ""
+	if req.AllowExisting && len(participantIDs) > 0 {
+		if existingChatID, err = s.findExistingChat(r.Context(), lookup, req.AccountID, chatType, participantIDs); err != nil {
+			return err
+		}
+		if !policy.allowsChat(existingChatID, req.AccountID) {
+			existingChatID = ""
+		}
+	}
+
+	if dryRun {
+		output := newDryRunOutput(dryRunActionCreateChat, issues)
+		output.ResolvedUsers = users
+		if req.AllowExisting {
+			output.ChatID = existingChatID
+			output.Status = "created"
+			if existingChatID != "" {
+				output.Status = "existing"
+			}
+		}
+		return writeJSON(w, output)
+	}
+	if existingChatID != "" {
+		return writeJSON(w, newCreateChatOutput(existingChatID, "existing"))
+	}
+
+	if req.AvatarUploadID != "" {
+		if opts.Avatar, err = s.chatAvatarContent(r, req.AvatarUploadID); err != nil {
+			return err
+		}
+	}
+	chatID, err := s.createChatRoom(r.Context(), chatType, participantIDs, opts)
+	if err != nil {
+		return err
+	}
+	status := ""
+	if req.AllowExisting {
+		status = "created"
+	}
+	return writeJSON(w, newCreateChatOutput(chatID, status))
 }
 
-func (s *Server) startChat(w http.ResponseWriter, r *http.Request, req compat.CreateChatInput, lookup *accountLookup) error {
+func (s *Server) startChat(w http.ResponseWriter, r *http.Request, req compat.CreateChatInput, lookup *accountLookup, policy *subjectPolicy, dryRun bool) error {
 	if req.User == nil {
 		return errs.Validation(map[string]any{"user": "user is required for mode=start"})
 	}
@@ -403,22 +572,52 @@ func (s *Server) startChat(w http.ResponseWriter, r *http.Request, req compat.Cr
 		return errs.Forbidden("Cannot message this user on the selected account")
 	}
 
-	userID, err := s.resolveStartChatUserID(r.Context(), req.User)
+	target, err := s.resolveStartChatUserID(r.Context(), req.AccountID, req.User)
 	if err != nil {
 		return err
 	}
+	userID := target.UserID
 	existingChatID, err := s.findExistingSingleChat(r.Context(), lookup, req.AccountID, userID)
 	if err != nil {
 		return err
 	}
+	if existingChatID == "" {
+		existingChatID = target.DMChatID
+	}
+	if !policy.allowsChat(existingChatID, req.AccountID) {
+		existingChatID = ""
+	}
+	if dryRun {
+		output := newDryRunOutput(dryRunActionStartChat, nil)
+		output.ResolvedUsers = []compat.User{newCompatUser(r.Context(), userShape{ID: userID})}
+		output.ChatID = existingChatID
+		output.Status = "created"
+		if existingChatID != "" {
+			output.Status = "existing"
+		}
+		return writeJSON(w, output)
+	}
 	if existingChatID != "" {
 		return writeJSON(w, newCreateChatOutput(existingChatID, "existing"))
 	}
 
-	chatID, err := s.createChatRoom(r.Context(), "single", []string{userID}, "", req.MessageText)
+	if target.BridgeIdentifier == "" {
+		chatID, err := s.createChatRoom(r.Context(), compat.ChatTypeSingle, []string{userID}, chatRoomOptions{MessageText: req.MessageText, Encrypted: req.Encrypted})
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, newCreateChatOutput(chatID, "created"))
+	}
+
+	chatID, err := s.createBridgeDM(r.Context(), req.AccountID, target.BridgeIdentifier)
 	if err != nil {
 		return err
 	}
+	if strings.TrimSpace(req.MessageText) != "" {
+		if _, err = s.rt.Client().SendMessage(r.Context(), id.RoomID(chatID), nil, nil, req.MessageText, nil, nil, nil); err != nil {
+			return errs.Internal(fmt.Errorf("chat was created but sending first message failed: %w", err))
+		}
+	}
 	return writeJSON(w, newCreateChatOutput(chatID, "created"))
 }
 
@@ -433,7 +632,59 @@ func newCreateChatOutput(chatID, status string) compat.CreateChatOutput {
 	return output
 }
 
-func (s *Server) createChatRoom(ctx context.Context, chatType string, participantIDs []string, title string, messageText string) (string, error) {
+// chatRoomOptions is the optional initial state of a new chat room.
+type chatRoomOptions struct {
+	Title       string
+	MessageText string
+	Description string
+	Avatar      *event.RoomAvatarEventContent
+	Encrypted   bool
+	PowerLevels *event.PowerLevelsEventContent
+}
+
+// createChatPowerLevels converts the power level overrides of a createChat
+// request. A users override replaces the whole map on the homeserver, so the
+// creator is added back at 100 unless it is listed.
+func createChatPowerLevels(input *compat.CreateChatPowerLevels, self id.UserID) (*event.PowerLevelsEventContent, error) {
+	if input == nil {
+		return nil, nil
+	}
+	output := &event.PowerLevelsEventContent{
+		StateDefaultPtr: input.StateDefault,
+		InvitePtr:       input.Invite,
+		KickPtr:         input.Kick,
+		BanPtr:          input.Ban,
+		RedactPtr:       input.Redact,
+	}
+	if input.EventsDefault != nil {
+		output.EventsDefault = *input.EventsDefault
+	}
+	if len(input.Users) > 0 {
+		output.Users = make(map[id.UserID]int, len(input.Users)+1)
+		for userID, level := range input.Users {
+			userID = strings.TrimSpace(userID)
+			if _, _, err := id.UserID(userID).Parse(); err != nil {
+				return nil, errs.Validation(map[string]any{"powerLevels.users": fmt.Sprintf("%q is not a valid Matrix user ID", userID)})
+			}
+			output.Users[id.UserID(userID)] = level
+		}
+		if _, ok := output.Users[self]; !ok && self != "" {
+			output.Users[self] = 100
+		}
+	}
+	if len(input.Events) > 0 {
+		output.Events = make(map[string]int, len(input.Events))
+		for evtType, level := range input.Events {
+			if evtType = strings.TrimSpace(evtType); evtType == "" {
+				return nil, errs.Validation(map[string]any{"powerLevels.events": "event types must not be empty"})
+			}
+			output.Events[evtType] = level
+		}
+	}
+	return output, nil
+}
+
+func (s *Server) createChatRoom(ctx context.Context, chatType compat.ChatType, participantIDs []string, opts chatRoomOptions) (string, error) {
 	invitees := make([]id.UserID, 0, len(participantIDs))
 	for _, participantID := range participantIDs {
 		participantID = strings.TrimSpace(participantID)
@@ -448,24 +699,41 @@ func (s *Server) createChatRoom(ctx context.Context, chatType string, participan
 	createReq := &mautrix.ReqCreateRoom{
 		Visibility: "private",
 		Invite:     invitees,
-		IsDirect:   chatType == "single",
+		IsDirect:   chatType == compat.ChatTypeSingle,
+	}
+	if chatType == compat.ChatTypeGroup {
+		createReq.Name = strings.TrimSpace(opts.Title)
+		createReq.Topic = opts.Description
+	}
+	emptyStateKey := ""
+	if opts.Avatar != nil {
+		createReq.InitialState = append(createReq.InitialState, &event.Event{
+			Type:     event.StateRoomAvatar,
+			StateKey: &emptyStateKey,
+			Content:  event.Content{Parsed: opts.Avatar},
+		})
 	}
-	if chatType == "group" {
-		createReq.Name = strings.TrimSpace(title)
+	if opts.Encrypted {
+		createReq.InitialState = append(createReq.InitialState, &event.Event{
+			Type:     event.StateEncryption,
+			StateKey: &emptyStateKey,
+			Content:  event.Content{Parsed: &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}},
+		})
 	}
+	createReq.PowerLevelOverride = opts.PowerLevels
 
 	createResp, err := s.rt.Client().Client.CreateRoom(ctx, createReq)
 	if err != nil {
 		return "", errs.Internal(fmt.Errorf("failed to create chat: %w", err))
 	}
 
-	if strings.TrimSpace(messageText) != "" {
+	if strings.TrimSpace(opts.MessageText) != "" {
 		if _, err = s.rt.Client().SendMessage(
 			ctx,
 			createResp.RoomID,
 			nil,
 			nil,
-			messageText,
+			opts.MessageText,
 			nil,
 			nil,
 			nil,
@@ -476,14 +744,9 @@ func (s *Server) createChatRoom(ctx context.Context, chatType string, participan
 	return createResp.RoomID.String(), nil
 }
 
-func (s *Server) resolveStartChatUserID(ctx context.Context, user *compat.CreateChatStartUserInput) (string, error) {
-	if user == nil {
-		return "", errs.Validation(map[string]any{"user": "user is required"})
-	}
-	if directID := strings.TrimSpace(user.ID); directID != "" {
-		return directID, nil
-	}
-
+// startChatIdentifiers lists the distinct identifiers of a start-chat target
+// in lookup order.
+func startChatIdentifiers(user *compat.CreateChatStartUserInput) []string {
 	queries := make([]string, 0, 4)
 	for _, candidate := range []string{user.Username, user.PhoneNumber, user.Email, user.FullName} {
 		candidate = strings.TrimSpace(candidate)
@@ -501,8 +764,50 @@ func (s *Server) resolveStartChatUserID(ctx context.Context, user *compat.Create
 			queries = append(queries, candidate)
 		}
 	}
+	return queries
+}
+
+// startChatTarget is a resolved start-chat user. BridgeIdentifier is set when
+// the bridge resolved the user, so the DM can be created through it, and
+// DMChatID when the bridge already has a DM portal for them.
+type startChatTarget struct {
+	UserID           string
+	BridgeIdentifier string
+	DMChatID         string
+}
+
+// resolveStartChatUserID resolves a start-chat user on the given account.
+// Bridged networks know their users by phone number, username or email, which
+// the Matrix user directory cannot find, so those accounts ask the bridge and
+// only Matrix accounts search the directory.
+func (s *Server) resolveStartChatUserID(ctx context.Context, accountID string, user *compat.CreateChatStartUserInput) (startChatTarget, error) {
+	if user == nil {
+		return startChatTarget{}, errs.Validation(map[string]any{"user": "user is required"})
+	}
+	if directID := strings.TrimSpace(user.ID); directID != "" {
+		return startChatTarget{UserID: directID}, nil
+	}
+
+	queries := startChatIdentifiers(user)
 	if len(queries) == 0 {
-		return "", errs.Validation(map[string]any{"user": "one of user.id, user.username, user.phoneNumber, user.email, or user.fullName is required"})
+		return startChatTarget{}, errs.Validation(map[string]any{"user": "one of user.id, user.username, user.phoneNumber, user.email, or user.fullName is required"})
+	}
+
+	if bridgeID, loginID := splitDesktopAccountID(accountID); bridgeID != "" && loginID != "" && bridgeID != "matrix" {
+		for _, query := range queries {
+			for _, identifier := range buildIdentifierLookupCandidates(query) {
+				resolved, _ := s.resolveCloudBridgeIdentifier(ctx, accountID, identifier)
+				if resolved == nil || resolved.MXID == "" {
+					continue
+				}
+				return startChatTarget{
+					UserID:           string(resolved.MXID),
+					BridgeIdentifier: identifier,
+					DMChatID:         string(resolved.DMRoomID),
+				}, nil
+			}
+		}
+		return startChatTarget{}, errs.NotFound("User not found on this account's network")
 	}
 
 	targetUsername := strings.TrimSpace(user.Username)
@@ -522,10 +827,10 @@ func (s *Server) resolveStartChatUserID(ctx context.Context, user *compat.Create
 				continue
 			}
 			if targetUsername != "" && strings.EqualFold(userIDLocalpart(foundUserID), targetUsername) {
-				return foundUserID, nil
+				return startChatTarget{UserID: foundUserID}, nil
 			}
 			if targetFullName != "" && strings.EqualFold(strings.TrimSpace(match.DisplayName), targetFullName) {
-				return foundUserID, nil
+				return startChatTarget{UserID: foundUserID}, nil
 			}
 			if fallbackUserID == "" {
 				fallbackUserID = foundUserID
@@ -533,9 +838,108 @@ func (s *Server) resolveStartChatUserID(ctx context.Context, user *compat.Create
 		}
 	}
 	if fallbackUserID != "" {
-		return fallbackUserID, nil
+		return startChatTarget{UserID: fallbackUserID}, nil
+	}
+	return startChatTarget{}, errs.NotFound("User not found")
+}
+
+// createBridgeDM asks the bridge to create (or return) the DM portal with a
+// resolved identifier, which also creates the ghost user when needed.
+func (s *Server) createBridgeDM(ctx context.Context, accountID, identifier string) (string, error) {
+	bridgeID, loginID := splitDesktopAccountID(accountID)
+	var resp provisionutil.RespResolveIdentifier
+	err := s.bridgeProvisionRequest(ctx, http.MethodPost, bridgeID, []any{"create_dm", identifier}, map[string]string{"login_id": loginID}, nil, &resp)
+	if err != nil {
+		return "", errs.Internal(fmt.Errorf("failed to create chat through the bridge: %w", err))
+	}
+	if resp.DMRoomID == "" {
+		return "", errs.Internal(fmt.Errorf("bridge did not return a chat for %q", identifier))
+	}
+	return string(resp.DMRoomID), nil
+}
+
+// findExistingChat finds a chat on the account with exactly the given
+// participants besides the user, joined or invited.
+func (s *Server) findExistingChat(ctx context.Context, lookup *accountLookup, accountID string, chatType compat.ChatType, participantIDs []string) (string, error) {
+	if chatType == compat.ChatTypeSingle {
+		return s.findExistingSingleChat(ctx, lookup, accountID, strings.TrimSpace(participantIDs[0]))
+	}
+	matches, err := s.chatsWithParticipants(ctx, lookup, accountID, participantIDs, false)
+	if err != nil {
+		return "", err
+	}
+	for _, match := range matches {
+		if match.Room.DMUserID == nil || *match.Room.DMUserID == "" {
+			return string(match.Room.ID), nil
+		}
+	}
+	return "", nil
+}
+
+const (
+	participantMatchExact    = "exact"
+	participantMatchSuperset = "superset"
+)
+
+type participantSetMatch struct {
+	Room  *database.Room
+	Match string
+}
+
+// chatsWithParticipants lists the account's chats whose other members are
+// exactly participantIDs or, with superset, include all of them.
+func (s *Server) chatsWithParticipants(ctx context.Context, lookup *accountLookup, accountID string, participantIDs []string, superset bool) ([]participantSetMatch, error) {
+	rooms, err := s.loadRoomsSorted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matches []participantSetMatch
+	for _, room := range rooms {
+		if mappedAccountID, _ := inferAccountForRoom(room.ID, lookup); mappedAccountID != accountID {
+			continue
+		}
+		participants, _ := s.loadRoomParticipants(ctx, room)
+		match := compareParticipantSet(participants, participantIDs)
+		if match == participantMatchExact || (superset && match == participantMatchSuperset) {
+			matches = append(matches, participantSetMatch{Room: room, Match: match})
+		}
+	}
+	return matches, nil
+}
+
+// compareParticipantSet compares the non-self participants with
+// participantIDs, ignoring order and duplicates. It returns
+// participantMatchExact, participantMatchSuperset when there are additional
+// members, or "" when any of participantIDs is missing.
+func compareParticipantSet(participants []compat.User, participantIDs []string) string {
+	want := make(map[string]struct{}, len(participantIDs))
+	for _, participantID := range participantIDs {
+		if participantID = strings.TrimSpace(participantID); participantID != "" {
+			want[participantID] = struct{}{}
+		}
+	}
+	if len(want) == 0 {
+		return ""
+	}
+	found, others := 0, 0
+	for _, participant := range participants {
+		if participant.IsSelf {
+			continue
+		}
+		if _, ok := want[participant.ID]; ok {
+			found++
+		} else {
+			others++
+		}
+	}
+	switch {
+	case found < len(want):
+		return ""
+	case others > 0:
+		return participantMatchSuperset
+	default:
+		return participantMatchExact
 	}
-	return "", errs.NotFound("User not found")
 }
 
 func (s *Server) findExistingSingleChat(ctx context.Context, lookup *accountLookup, accountID, userID string) (string, error) {
@@ -587,13 +991,96 @@ func (s *Server) archiveChat(w http.ResponseWriter, r *http.Request) error {
 		archived = *req.Archived
 	}
 
+	if err := s.setChatArchived(r.Context(), chatID, archived); err != nil {
+		return err
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+func (s *Server) setChatArchived(ctx context.Context, chatID string, archived bool) error {
 	var content any = map[string]any{}
 	if archived {
 		content = map[string]any{"updated_ts": time.Now().UnixMilli()}
 	}
-	if err := s.rt.Client().Client.SetRoomAccountData(r.Context(), id.RoomID(chatID), "com.beeper.inbox.done", content); err != nil {
+	if err := s.rt.Client().Client.SetRoomAccountData(ctx, id.RoomID(chatID), "com.beeper.inbox.done", content); err != nil {
 		return errs.Internal(fmt.Errorf("failed to set archive state: %w", err))
 	}
+	return nil
+}
+
+func (s *Server) markChatUnread(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Unread *bool  `json:"unread,omitempty"`
+		ChatID string `json:"chatID,omitempty"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, req.ChatID)
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	unread := true
+	if req.Unread != nil {
+		unread = *req.Unread
+	}
+	if err := s.setChatMarkedUnread(r.Context(), chatID, unread); err != nil {
+		return err
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+// setChatMarkedUnread writes m.marked_unread with a timestamp so the flag can
+// be ordered against com.beeper.inbox.done when deciding archive state.
+func (s *Server) setChatMarkedUnread(ctx context.Context, chatID string, unread bool) error {
+	content := markedUnreadContent{Unread: unread, TS: time.Now().UnixMilli()}
+	if err := s.rt.Client().Client.SetRoomAccountData(ctx, id.RoomID(chatID), "m.marked_unread", content); err != nil {
+		return errs.Internal(fmt.Errorf("failed to set marked unread state: %w", err))
+	}
+	return nil
+}
+
+func (s *Server) pinChat(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Pinned *bool  `json:"pinned,omitempty"`
+		ChatID string `json:"chatID,omitempty"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, req.ChatID)
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	pinned := true
+	if req.Pinned != nil {
+		pinned = *req.Pinned
+	}
+	if err := s.setChatPinned(r.Context(), chatID, pinned); err != nil {
+		return err
+	}
+	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
+}
+
+func (s *Server) lowPriorityChat(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		LowPriority *bool  `json:"lowPriority,omitempty"`
+		ChatID      string `json:"chatID,omitempty"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	chatID := readChatID(r, req.ChatID)
+	if chatID == "" {
+		return errs.Validation(map[string]any{"chatID": "chatID is required"})
+	}
+	lowPriority := true
+	if req.LowPriority != nil {
+		lowPriority = *req.LowPriority
+	}
+	if err := s.setChatLowPriority(r.Context(), chatID, lowPriority); err != nil {
+		return err
+	}
 	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
 }
 
@@ -622,7 +1109,7 @@ func (s *Server) setChatReminder(w http.ResponseWriter, r *http.Request) error {
 		"dismiss_on_incoming_message": dismissOnIncoming,
 		"remind_at_client":            "desktop",
 	}
-	if err := s.rt.Client().Client.SetRoomAccountData(r.Context(), id.RoomID(chatID), "com.beeper.chats.reminder", payload); err != nil {
+	if err := s.rt.Client().Client.SetRoomAccountData(r.Context(), id.RoomID(chatID), chatReminderEventType, payload); err != nil {
 		return errs.Internal(fmt.Errorf("failed to set chat reminder: %w", err))
 	}
 	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
@@ -639,7 +1126,7 @@ func (s *Server) clearChatReminder(w http.ResponseWriter, r *http.Request) error
 	if chatID == "" {
 		return errs.Validation(map[string]any{"chatID": "chatID is required"})
 	}
-	if err := s.rt.Client().Client.SetRoomAccountData(r.Context(), id.RoomID(chatID), "com.beeper.chats.reminder", map[string]any{}); err != nil {
+	if err := s.rt.Client().Client.SetRoomAccountData(r.Context(), id.RoomID(chatID), chatReminderEventType, map[string]any{}); err != nil {
 		return errs.Internal(fmt.Errorf("failed to clear chat reminder: %w", err))
 	}
 	return writeJSON(w, compat.ActionSuccessOutput{Success: true})
@@ -666,13 +1153,13 @@ func parseContactCursor(raw string) (*contactCursor, error) {
 	return &decoded, nil
 }
 
-func (s *Server) loadAccountContacts(ctx context.Context, lookup *accountLookup, accountID, query string) ([]compat.User, error) {
+func (s *Server) loadAccountContacts(ctx context.Context, lookup *accountLookup, accountID, query string, forceRefresh bool) ([]compat.User, error) {
 	query = strings.TrimSpace(query)
-	rooms, err := s.loadRoomsSorted(ctx)
+	cached, err := s.cachedAccountContacts(ctx, lookup, accountID, forceRefresh)
 	if err != nil {
 		return nil, err
 	}
-	candidates := make([]contactCandidate, 0, 256)
+	candidates := make([]contactCandidate, 0, len(cached))
 	addCandidate := func(user compat.User, baseScore int) {
 		normalized, ok := normalizeContactUser(user)
 		if !ok || normalized.IsSelf {
@@ -689,30 +1176,8 @@ func (s *Server) loadAccountContacts(ctx context.Context, lookup *accountLookup,
 		})
 	}
 
-	for _, room := range rooms {
-		mappedAccountID, _ := inferAccountForRoom(room.ID, lookup)
-		if mappedAccountID != accountID {
-			continue
-		}
-		participants, _ := s.loadRoomParticipants(ctx, room)
-		for _, participant := range participants {
-			participant.ID = strings.TrimSpace(participant.ID)
-			if participant.ID == "" || participant.IsSelf {
-				continue
-			}
-			if participant.Username == "" {
-				participant.Username = userIDLocalpart(participant.ID)
-			}
-			addCandidate(participant, contactSourceScoreParticipants)
-		}
-	}
-
-	cloudContacts, _ := s.fetchCloudBridgeContacts(ctx, accountID)
-	for _, resolved := range cloudContacts {
-		if resolved == nil {
-			continue
-		}
-		addCandidate(s.mapResolvedIdentifierToUser(resolved), contactSourceScoreCloudList)
+	for _, contact := range cached {
+		addCandidate(contact.User, contact.Score)
 	}
 
 	if query != "" {
@@ -721,12 +1186,12 @@ func (s *Server) loadAccountContacts(ctx context.Context, lookup *accountLookup,
 			if resolved == nil {
 				continue
 			}
-			addCandidate(s.mapResolvedIdentifierToUser(resolved), contactSourceScoreLookup)
+			addCandidate(s.mapResolvedIdentifierToUser(ctx, resolved), contactSourceScoreLookup)
 		}
 		resp, searchErr := s.rt.Client().Client.SearchUserDirectory(ctx, query, searchContactsMaxLimit)
 		if searchErr == nil {
 			for _, user := range resp.Results {
-				addCandidate(s.mapDirectoryUserToContact(user), contactSourceScoreDirectory)
+				addCandidate(s.mapDirectoryUserToContact(ctx, user), contactSourceScoreDirectory)
 			}
 		}
 	}
@@ -884,11 +1349,11 @@ func mergeContactCandidates(candidates []contactCandidate) []compat.User {
 	return items
 }
 
-func (s *Server) mapDirectoryUserToContact(user *mautrix.UserDirectoryEntry) compat.User {
+func (s *Server) mapDirectoryUserToContact(ctx context.Context, user *mautrix.UserDirectoryEntry) compat.User {
 	if user == nil {
 		return compat.User{}
 	}
-	return newCompatUser(userShape{
+	return newCompatUser(ctx, userShape{
 		ID:            user.UserID.String(),
 		Username:      userIDLocalpart(user.UserID.String()),
 		FullName:      user.DisplayName,
@@ -898,7 +1363,7 @@ func (s *Server) mapDirectoryUserToContact(user *mautrix.UserDirectoryEntry) com
 	})
 }
 
-func (s *Server) mapResolvedIdentifierToUser(resolved *provisionutil.RespResolveIdentifier) compat.User {
+func (s *Server) mapResolvedIdentifierToUser(ctx context.Context, resolved *provisionutil.RespResolveIdentifier) compat.User {
 	if resolved == nil {
 		return compat.User{}
 	}
@@ -922,7 +1387,7 @@ func (s *Server) mapResolvedIdentifierToUser(resolved *provisionutil.RespResolve
 	if s.rt.Client() != nil && s.rt.Client().Account != nil {
 		selfUserID = string(s.rt.Client().Account.UserID)
 	}
-	return newCompatUser(userShape{
+	return newCompatUser(ctx, userShape{
 		ID:            userID,
 		Username:      username,
 		PhoneNumber:   phoneNumber,
@@ -1174,6 +1639,9 @@ func (s *Server) searchChatsCore(ctx context.Context, params searchChatsParams)
 		if !params.IncludeMuted && state.IsMuted {
 			continue
 		}
+		if len(params.Tags) > 0 && !roomHasAnyTag(state.Tags, params.Tags) {
+			continue
+		}
 		if params.Inbox != "" {
 			switch params.Inbox {
 			case "primary":
@@ -1188,6 +1656,10 @@ func (s *Server) searchChatsCore(ctx context.Context, params searchChatsParams)
 				if !state.EffectiveArchived() {
 					continue
 				}
+			case "pinned":
+				if !state.IsPinned {
+					continue
+				}
 			}
 		}
 
@@ -1198,7 +1670,10 @@ func (s *Server) searchChatsCore(ctx context.Context, params searchChatsParams)
 		if len(params.AccountIDs) > 0 && !equalsAny(chat.AccountID, params.AccountIDs) {
 			continue
 		}
-		if params.Type != "any" && params.Type != "" && string(chat.Type) != params.Type {
+		if !params.Visibility.allowsChat(chat.ID, chat.AccountID) {
+			continue
+		}
+		if params.Type != "" && chat.Type != params.Type {
 			continue
 		}
 		if params.UnreadOnly && chat.UnreadCount <= 0 && !chat.IsMarkedUnread {
@@ -1341,7 +1816,10 @@ func (s *Server) searchMessagesCore(ctx context.Context, params searchMessagesPa
 		if len(params.AccountIDs) > 0 && !equalsAny(ctxForRoom.chat.AccountID, params.AccountIDs) {
 			continue
 		}
-		if params.ChatType != "" && params.ChatType != "any" && string(ctxForRoom.chat.Type) != params.ChatType {
+		if !params.Visibility.allowsChat(ctxForRoom.chat.ID, ctxForRoom.chat.AccountID) {
+			continue
+		}
+		if params.ChatType != "" && ctxForRoom.chat.Type != params.ChatType {
 			continue
 		}
 
@@ -1364,9 +1842,33 @@ func (s *Server) searchMessagesCore(ctx context.Context, params searchMessagesPa
 		if !matchesMedia(message, params.MediaTypes) {
 			continue
 		}
-		if !matchesMessageQuery(params.Query, message) {
+		match, matched := messageQueryMatch(params.Query, message)
+		if !matched || !matchesPhrases(message, params.Phrases) {
 			continue
 		}
+		if len(params.Phrases) > 0 {
+			if match == nil {
+				match = &compat.SearchMatch{Fields: []string{compat.SearchMatchFieldText}}
+			}
+			match.Terms = append(match.Terms, params.Phrases...)
+		}
+		if match != nil {
+			match.Highlights, match.Snippet = highlightSearchTerms(message.Text, match.Terms)
+		}
+		message.Match = match
+		if len(params.Annotations) > 0 || params.IncludeAnnotations {
+			annotations, annotationErr := s.messageAnnotationsFor(params.ClientID, message)
+			if annotationErr != nil {
+				return compat.SearchMessagesOutput{}, annotationErr
+			}
+			if !matchesAnnotationFilters(annotations, params.Annotations) {
+				continue
+			}
+			if params.IncludeAnnotations {
+				message.Annotations = annotations
+			}
+		}
+		applyTextFormat(&message, evt, params.TextFormat)
 
 		items = append(items, message)
 		resultRows = append(resultRows, int64(evt.TimelineRowID))
@@ -1513,15 +2015,15 @@ func parseSearchChatsParams(r *http.Request) (searchChatsParams, error) {
 		return searchChatsParams{}, errs.Validation(map[string]any{"scope": "must be one of: titles, participants"})
 	}
 	inbox := strings.TrimSpace(r.URL.Query().Get("inbox"))
-	if inbox != "" && inbox != "primary" && inbox != "low-priority" && inbox != "archive" {
-		return searchChatsParams{}, errs.Validation(map[string]any{"inbox": "must be one of: primary, low-priority, archive"})
+	if inbox != "" && inbox != "primary" && inbox != "low-priority" && inbox != "archive" && inbox != "pinned" {
+		return searchChatsParams{}, errs.Validation(map[string]any{"inbox": "must be one of: primary, low-priority, archive, pinned"})
 	}
-	chatType := strings.TrimSpace(r.URL.Query().Get("type"))
-	if chatType == "" {
-		chatType = "any"
-	}
-	if chatType != "any" && chatType != "single" && chatType != "group" {
-		return searchChatsParams{}, errs.Validation(map[string]any{"type": "must be one of: any, single, group"})
+	var chatType compat.ChatType
+	if rawType := strings.TrimSpace(r.URL.Query().Get("type")); rawType != "" && rawType != "any" {
+		var ok bool
+		if chatType, ok = compat.ParseChatType(rawType); !ok {
+			return searchChatsParams{}, errs.Validation(map[string]any{"type": "must be one of: any, single, group"})
+		}
 	}
 	lastActivityBefore, err := parseOptionalRFC3339(r.URL.Query().Get("lastActivityBefore"), "lastActivityBefore")
 	if err != nil {
@@ -1544,6 +2046,7 @@ func parseSearchChatsParams(r *http.Request) (searchChatsParams, error) {
 		LastActivityBefore: lastActivityBefore,
 		LastActivityAfter:  lastActivityAfter,
 		AccountIDs:         parseAccountIDs(r),
+		Tags:               parseRoomTagFilter(r.URL.Query()["tags"]),
 	}, nil
 }
 
@@ -1568,9 +2071,12 @@ func parseSearchMessagesParams(r *http.Request) (searchMessagesParams, error) {
 	if err != nil {
 		return searchMessagesParams{}, err
 	}
-	chatType := strings.TrimSpace(r.URL.Query().Get("chatType"))
-	if chatType != "" && chatType != "single" && chatType != "group" {
-		return searchMessagesParams{}, errs.Validation(map[string]any{"chatType": "must be one of: single, group"})
+	var chatType compat.ChatType
+	if rawChatType := strings.TrimSpace(r.URL.Query().Get("chatType")); rawChatType != "" {
+		var ok bool
+		if chatType, ok = compat.ParseChatType(rawChatType); !ok {
+			return searchMessagesParams{}, errs.Validation(map[string]any{"chatType": "must be one of: single, group"})
+		}
 	}
 	sender := strings.TrimSpace(r.URL.Query().Get("sender"))
 	dateAfter, err := parseOptionalRFC3339(r.URL.Query().Get("dateAfter"), "dateAfter")
@@ -1581,15 +2087,27 @@ func parseSearchMessagesParams(r *http.Request) (searchMessagesParams, error) {
 	if err != nil {
 		return searchMessagesParams{}, err
 	}
-	if dateAfter != nil && dateBefore != nil && !dateAfter.Before(*dateBefore) {
-		return searchMessagesParams{}, errs.Validation(map[string]any{"dateAfter": "must be earlier than dateBefore"})
+	mediaTypes, err := parseEnumList(r, "mediaTypes", searchMediaTypes)
+	if err != nil {
+		return searchMessagesParams{}, err
 	}
-	mediaTypes, err := parseEnumList(r, "mediaTypes", []string{"any", "video", "image", "link", "file"})
+	textFormat, err := parseTextFormat(r.URL.Query().Get("textFormat"))
 	if err != nil {
 		return searchMessagesParams{}, err
 	}
-	return searchMessagesParams{
-		Query:              strings.TrimSpace(r.URL.Query().Get("query")),
+	annotations, err := parseAnnotationFilters(r.URL.Query()["annotation"])
+	if err != nil {
+		return searchMessagesParams{}, err
+	}
+	includeAnnotations, err := parseOptionalBool(r.URL.Query().Get("includeAnnotations"), false, "includeAnnotations")
+	if err != nil {
+		return searchMessagesParams{}, err
+	}
+	query, err := parseSearchQuery(r.URL.Query().Get("query"))
+	if err != nil {
+		return searchMessagesParams{}, err
+	}
+	params := searchMessagesParams{
 		Direction:          direction,
 		Cursor:             cursorValue,
 		Limit:              limit,
@@ -1602,7 +2120,16 @@ func parseSearchMessagesParams(r *http.Request) (searchMessagesParams, error) {
 		DateBefore:         dateBefore,
 		ExcludeLowPriority: excludeLowPriority,
 		IncludeMuted:       includeMuted,
-	}, nil
+		TextFormat:         textFormat,
+		ClientID:           requestClientID(r),
+		Annotations:        annotations,
+		IncludeAnnotations: includeAnnotations,
+	}
+	query.apply(&params)
+	if params.DateAfter != nil && params.DateBefore != nil && !params.DateAfter.Before(*params.DateBefore) {
+		return searchMessagesParams{}, errs.Validation(map[string]any{"dateAfter": "must be earlier than dateBefore"})
+	}
+	return params, nil
 }
 
 func parseStringListParam(r *http.Request, key string) []string {
@@ -1708,56 +2235,82 @@ func matchesAllTokens(query, text string) bool {
 }
 
 func matchesMessageQuery(query string, msg compat.Message) bool {
+	_, ok := messageQueryMatch(query, msg)
+	return ok
+}
+
+// messageQueryMatch reports whether every query token matches the message and,
+// if so, which fields the tokens were found in. A nil match with ok=true means
+// the query was empty.
+func messageQueryMatch(query string, msg compat.Message) (*compat.SearchMatch, bool) {
 	query = strings.TrimSpace(query)
 	if query == "" {
-		return true
+		return nil, true
 	}
 	tokens := strings.Fields(strings.ToLower(query))
 	if len(tokens) == 0 {
-		return true
+		return nil, true
 	}
 
+	textField := compat.SearchMatchFieldText
+	if len(msg.Attachments) > 0 && msg.Text != "" && msg.Text == msg.Attachments[0].FileName {
+		textField = compat.SearchMatchFieldFileName
+	}
 	baseText := strings.ToLower(msg.Text)
 	looseText := normalizeLooseSearch(baseText)
 	compactText := strings.ReplaceAll(looseText, " ", "")
-	trimmedColons := strings.Trim(msg.Text, ":")
-	looseTrimmedColons := normalizeLooseSearch(trimmedColons)
 
-	haystacks := []string{
-		baseText,
-		looseText,
-		compactText,
+	type haystackGroup struct {
+		field     string
+		haystacks []string
 	}
-	if msg.Type == compat.MessageType("REACTION") && strings.TrimSpace(trimmedColons) != "" {
-		haystacks = append(haystacks, strings.ToLower(trimmedColons), looseTrimmedColons, strings.ReplaceAll(looseTrimmedColons, " ", ""))
+	groups := []haystackGroup{{field: textField, haystacks: []string{baseText, looseText, compactText}}}
+	trimmedColons := strings.Trim(msg.Text, ":")
+	if msg.Type == compat.MessageTypeReaction && strings.TrimSpace(trimmedColons) != "" {
+		looseTrimmedColons := normalizeLooseSearch(trimmedColons)
+		groups = append(groups, haystackGroup{
+			field:     compat.SearchMatchFieldReactionKey,
+			haystacks: []string{strings.ToLower(trimmedColons), looseTrimmedColons, strings.ReplaceAll(looseTrimmedColons, " ", "")},
+		})
 	}
 
+	match := &compat.SearchMatch{Terms: tokens}
 	for _, token := range tokens {
 		looseToken := normalizeLooseSearch(token)
 		compactToken := strings.ReplaceAll(looseToken, " ", "")
-		matched := false
-		for _, haystack := range haystacks {
-			if haystack == "" {
-				continue
-			}
-			if strings.Contains(haystack, token) {
-				matched = true
-				break
-			}
-			if looseToken != "" && strings.Contains(haystack, looseToken) {
-				matched = true
-				break
-			}
-			if compactToken != "" && strings.Contains(strings.ReplaceAll(haystack, " ", ""), compactToken) {
-				matched = true
+		matchedField := ""
+		for _, group := range groups {
+			if haystacksContainToken(group.haystacks, token, looseToken, compactToken) {
+				matchedField = group.field
 				break
 			}
 		}
-		if !matched {
-			return false
+		if matchedField == "" {
+			return nil, false
+		}
+		if !slices.Contains(match.Fields, matchedField) {
+			match.Fields = append(match.Fields, matchedField)
 		}
 	}
-	return true
+	return match, true
+}
+
+func haystacksContainToken(haystacks []string, token, looseToken, compactToken string) bool {
+	for _, haystack := range haystacks {
+		if haystack == "" {
+			continue
+		}
+		if strings.Contains(haystack, token) {
+			return true
+		}
+		if looseToken != "" && strings.Contains(haystack, looseToken) {
+			return true
+		}
+		if compactToken != "" && strings.Contains(strings.ReplaceAll(haystack, " ", ""), compactToken) {
+			return true
+		}
+	}
+	return false
 }
 
 func normalizeLooseSearch(input string) string {
@@ -1800,15 +2353,15 @@ func matchesMedia(msg compat.Message, mediaTypes []string) bool {
 				return true
 			}
 		case "video":
-			if string(msg.Type) == "VIDEO" {
+			if msg.Type == compat.MessageTypeVideo {
 				return true
 			}
 		case "image":
-			if string(msg.Type) == "IMAGE" || string(msg.Type) == "STICKER" {
+			if msg.Type == compat.MessageTypeImage || msg.Type == compat.MessageTypeSticker {
 				return true
 			}
 		case "file":
-			if string(msg.Type) == "FILE" {
+			if msg.Type == compat.MessageTypeFile {
 				return true
 			}
 		case "link":
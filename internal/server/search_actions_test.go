@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// callAsClient runs handler for a request authenticated as OAuth clientID.
+func callAsClient(clientID string, req *http.Request, handler apiHandler) error {
+	verifier := func(_ context.Context, _ string, _ *http.Request) (*mcpauth.TokenInfo, error) {
+		return &mcpauth.TokenInfo{Expiration: time.Now().Add(time.Hour), Extra: map[string]any{"client_id": clientID}}, nil
+	}
+	var handlerErr error
+	wrapped := mcpauth.RequireBearerToken(verifier, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerErr = handler(w, r)
+	}))
+	req.Header.Set("Authorization", "Bearer token")
+	wrapped.ServeHTTP(httptest.NewRecorder(), req)
+	return handlerErr
+}
+
+func TestCreateChatRefusesAccountsOutsideClientPolicy(t *testing.T) {
+	s := newDBTestServer(t)
+	accountID := "matrix_" + string(testOwnUserID)
+	if err := s.clientPolicies.put(compat.ClientAccessPolicy{ClientID: "agent", Read: compat.ClientAccessRule{AccountIDs: []string{"telegram_other"}}}); err != nil {
+		t.Fatalf("failed to store client policy: %v", err)
+	}
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/v1/chats", strings.NewReader(`{"accountID":"`+accountID+`","mode":"create"}`))
+	}
+
+	// The body is missing a chat type, which the owner is told about once
+	// the account has been found.
+	var apiErr *errs.APIError
+	if err := s.createChat(httptest.NewRecorder(), newRequest()); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected the owner to reach type validation, got %v", err)
+	}
+	if err := callAsClient("agent", newRequest(), s.createChat); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Fatalf("expected a confined client to be refused the account, got %v", err)
+	}
+	if err := callAsClient("other", newRequest(), s.createChat); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected an unrestricted client to reach type validation, got %v", err)
+	}
+}
+
+// recordAccountDataWrites points the client at a homeserver that accepts
+// account data writes and records the latest content per event type.
+func recordAccountDataWrites(t *testing.T, s *Server) map[string]json.RawMessage {
+	t.Helper()
+	writes := make(map[string]json.RawMessage)
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.Contains(r.URL.Path, "/account_data/") {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		writes[path.Base(r.URL.Path)] = body
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(homeserver.Close)
+	homeserverURL, _ := url.Parse(homeserver.URL)
+	s.rt.Client().Client.HomeserverURL = homeserverURL
+	return writes
+}
+
+func TestMarkChatUnreadWritesTimestampedFlagThatOverridesArchive(t *testing.T) {
+	s := newDBTestServer(t)
+	writes := recordAccountDataWrites(t, s)
+	markUnread := func(body string) markedUnreadContent {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chats/!room:example.org/mark-unread", strings.NewReader(body))
+		req.SetPathValue("chatID", "!room:example.org")
+		if err := s.markChatUnread(httptest.NewRecorder(), req); err != nil {
+			t.Fatalf("markChatUnread returned error: %v", err)
+		}
+		var content markedUnreadContent
+		if err := json.Unmarshal(writes["m.marked_unread"], &content); err != nil {
+			t.Fatalf("failed to decode m.marked_unread write %q: %v", writes["m.marked_unread"], err)
+		}
+		return content
+	}
+
+	archivedAt := time.Now().Add(-time.Minute).UnixMilli()
+	state := applyRoomAccountDataContent(roomAccountDataState{}, "com.beeper.inbox.done", []byte(`{"updated_ts":`+strconv.FormatInt(archivedAt, 10)+`}`))
+	if !state.EffectiveArchived() {
+		t.Fatal("expected chat to start archived")
+	}
+
+	content := markUnread("")
+	if !content.Unread || content.TS <= archivedAt {
+		t.Fatalf("expected an unread flag newer than the archive, got %#v", content)
+	}
+	state = applyRoomAccountDataContent(state, "m.marked_unread", writes["m.marked_unread"])
+	if !state.IsMarkedUnread || state.EffectiveArchived() {
+		t.Fatalf("expected marking unread to bring the chat back to the inbox, got %#v", state)
+	}
+	rearchived := applyRoomAccountDataContent(state, "com.beeper.inbox.done", []byte(`{"updated_ts":`+strconv.FormatInt(content.TS+1, 10)+`}`))
+	if !rearchived.EffectiveArchived() {
+		t.Fatal("expected a later archive to win over the unread flag")
+	}
+
+	if content = markUnread(`{"unread":false}`); content.Unread || content.TS == 0 {
+		t.Fatalf("expected unread=false to clear the flag with a timestamp, got %#v", content)
+	}
+}
+
+func TestSetChatPinnedTogglesFavouriteTag(t *testing.T) {
+	s := newDBTestServer(t)
+	var requests []string
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+path.Base(r.URL.Path))
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer homeserver.Close()
+	homeserverURL, _ := url.Parse(homeserver.URL)
+	s.rt.Client().Client.HomeserverURL = homeserverURL
+
+	for _, body := range []string{"", `{"pinned":false}`} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chats/!room:example.org/pin", strings.NewReader(body))
+		req.SetPathValue("chatID", "!room:example.org")
+		if err := s.pinChat(httptest.NewRecorder(), req); err != nil {
+			t.Fatalf("pinChat(%q) returned error: %v", body, err)
+		}
+	}
+	if len(requests) != 2 || requests[0] != "PUT m.favourite" || requests[1] != "DELETE m.favourite" {
+		t.Fatalf("expected pin and unpin to set and remove m.favourite, got %v", requests)
+	}
+}
+
+func TestSearchChatsInboxPinnedKeepsFavouritedChats(t *testing.T) {
+	s := newDBTestServer(t)
+	ctx := context.Background()
+	for _, roomID := range []id.RoomID{"!pinned:example.org", "!other:example.org"} {
+		insertTestRoom(t, s, roomID)
+		insertTestEvent(t, s, testTextEvent(roomID, id.EventID("$msg-"+string(roomID)), "@alice:example.org", "hello"))
+	}
+	// Chat listings skip rooms that were never sorted by sync.
+	if _, err := s.rt.Client().DB.Exec(ctx, `UPDATE room SET sorting_timestamp = 1700000000000, room_type = ''`); err != nil {
+		t.Fatalf("failed to mark rooms as synced: %v", err)
+	}
+	tags := map[id.RoomID]string{
+		"!pinned:example.org": `{"tags":{"m.favourite":{}}}`,
+		"!other:example.org":  `{"tags":{"u.work":{}}}`,
+	}
+	for roomID, content := range tags {
+		if _, err := s.rt.Client().DB.AccountData.PutRoom(ctx, testOwnUserID, roomID, event.AccountDataRoomTags, json.RawMessage(content)); err != nil {
+			t.Fatalf("failed to store room tags: %v", err)
+		}
+	}
+
+	out, err := s.searchChatsCore(ctx, searchChatsParams{Inbox: "pinned", Limit: 10, IncludeMuted: true})
+	if err != nil {
+		t.Fatalf("searchChatsCore returned error: %v", err)
+	}
+	if len(out.Items) != 1 || out.Items[0].ID != "!pinned:example.org" {
+		t.Fatalf("expected only the pinned chat, got %#v", out.Items)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+// searchSnippetContext is how many runes of text are kept on either side of
+// the first highlight when building a snippet.
+const searchSnippetContext = 60
+
+// highlightSearchTerms locates the query terms in text and returns their
+// rune ranges plus an HTML snippet with the matches wrapped in <mark>. It
+// mirrors the loose matching in messageQueryMatch: a term that does not occur
+// verbatim is retried with punctuation and spacing ignored, so "foobar" still
+// highlights "foo-bar".
+func highlightSearchTerms(text string, terms []string) ([]compat.SearchHighlight, string) {
+	if text == "" || len(terms) == 0 {
+		return nil, ""
+	}
+	runes := []rune(text)
+	lowered := make([]rune, len(runes))
+	var compact []rune
+	var compactIndex []int
+	for idx, r := range runes {
+		lowered[idx] = unicode.ToLower(r)
+		if isLooseSearchRune(lowered[idx]) {
+			compact = append(compact, lowered[idx])
+			compactIndex = append(compactIndex, idx)
+		}
+	}
+
+	var ranges []compat.SearchHighlight
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		found := false
+		for start := indexRunes(lowered, needle, 0); start >= 0; start = indexRunes(lowered, needle, start+len(needle)) {
+			ranges = append(ranges, compat.SearchHighlight{Start: start, End: start + len(needle)})
+			found = true
+		}
+		if found {
+			continue
+		}
+		looseNeedle := []rune(strings.ReplaceAll(normalizeLooseSearch(term), " ", ""))
+		for start := indexRunes(compact, looseNeedle, 0); start >= 0; start = indexRunes(compact, looseNeedle, start+len(looseNeedle)) {
+			end := start + len(looseNeedle) - 1
+			ranges = append(ranges, compat.SearchHighlight{Start: compactIndex[start], End: compactIndex[end] + 1})
+		}
+	}
+	ranges = mergeSearchHighlights(ranges)
+	return ranges, searchSnippet(runes, ranges)
+}
+
+func isLooseSearchRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
+}
+
+func indexRunes(haystack, needle []rune, from int) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for start := from; start+len(needle) <= len(haystack); start++ {
+		matched := true
+		for offset, r := range needle {
+			if haystack[start+offset] != r {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return start
+		}
+	}
+	return -1
+}
+
+func mergeSearchHighlights(ranges []compat.SearchHighlight) []compat.SearchHighlight {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next.Start <= last.End {
+			last.End = max(last.End, next.End)
+			continue
+		}
+		merged = append(merged, next)
+	}
+	return merged
+}
+
+// searchSnippet renders an escaped excerpt around the first highlight. Later
+// highlights are only marked if they fall inside the excerpt.
+func searchSnippet(runes []rune, ranges []compat.SearchHighlight) string {
+	if len(ranges) == 0 {
+		return ""
+	}
+	from := max(ranges[0].Start-searchSnippetContext, 0)
+	to := min(ranges[0].End+searchSnippetContext, len(runes))
+	var builder strings.Builder
+	if from > 0 {
+		builder.WriteString("…")
+	}
+	cursor := from
+	for _, highlight := range ranges {
+		if highlight.Start >= to {
+			break
+		}
+		end := min(highlight.End, to)
+		builder.WriteString(html.EscapeString(string(runes[cursor:highlight.Start])))
+		builder.WriteString("<mark>")
+		builder.WriteString(html.EscapeString(string(runes[highlight.Start:end])))
+		builder.WriteString("</mark>")
+		cursor = end
+	}
+	builder.WriteString(html.EscapeString(string(runes[cursor:to])))
+	if to < len(runes) {
+		builder.WriteString("…")
+	}
+	return builder.String()
+}
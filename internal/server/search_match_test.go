@@ -0,0 +1,71 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/beeper/desktop-api-go/shared"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+)
+
+func TestMessageQueryMatchReportsFields(t *testing.T) {
+	text := compat.Message{Message: shared.Message{Type: compat.MessageTypeText, Text: "Lunch at noon?"}}
+	match, ok := messageQueryMatch("lunch NOON", text)
+	if !ok || match == nil {
+		t.Fatalf("expected text message to match")
+	}
+	if !slices.Equal(match.Fields, []string{compat.SearchMatchFieldText}) || !slices.Equal(match.Terms, []string{"lunch", "noon"}) {
+		t.Fatalf("unexpected match %#v", match)
+	}
+
+	file := compat.Message{Message: shared.Message{
+		Type:        compat.MessageTypeFile,
+		Text:        "report.pdf",
+		Attachments: []compat.Attachment{{FileName: "report.pdf"}},
+	}}
+	if match, ok = messageQueryMatch("report", file); !ok || !slices.Equal(match.Fields, []string{compat.SearchMatchFieldFileName}) {
+		t.Fatalf("expected fileName match, got %#v", match)
+	}
+
+	if _, ok = messageQueryMatch("dinner", text); ok {
+		t.Fatal("expected unrelated query not to match")
+	}
+	if match, ok = messageQueryMatch("  ", text); !ok || match != nil {
+		t.Fatalf("expected empty query to match without metadata, got %#v", match)
+	}
+}
+
+func TestHighlightSearchTermsMarksExactAndLooseMatches(t *testing.T) {
+	highlights, snippet := highlightSearchTerms("Ping <Foo-Bar> about lunch, LUNCH!", []string{"lunch", "foobar"})
+	want := []compat.SearchHighlight{{Start: 6, End: 13}, {Start: 21, End: 26}, {Start: 28, End: 33}}
+	if !slices.Equal(highlights, want) {
+		t.Fatalf("unexpected highlights %#v", highlights)
+	}
+	if snippet != "Ping &lt;<mark>Foo-Bar</mark>&gt; about <mark>lunch</mark>, <mark>LUNCH</mark>!" {
+		t.Fatalf("unexpected snippet %q", snippet)
+	}
+}
+
+func TestHighlightSearchTermsTrimsLongText(t *testing.T) {
+	text := strings.Repeat("a ", 100) + "needle" + strings.Repeat(" b", 100)
+	highlights, snippet := highlightSearchTerms(text, []string{"needle"})
+	if len(highlights) != 1 || highlights[0].Start != 200 {
+		t.Fatalf("unexpected highlights %#v", highlights)
+	}
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") || !strings.Contains(snippet, "<mark>needle</mark>") {
+		t.Fatalf("unexpected snippet %q", snippet)
+	}
+}
+
+func TestParseUnifiedSearchSection(t *testing.T) {
+	for raw, want := range map[string]string{"": "", "chats": "chats", "inGroups": "in_groups", "in_groups": "in_groups", " messages ": "messages"} {
+		if got, err := parseUnifiedSearchSection(raw); err != nil || got != want {
+			t.Fatalf("parseUnifiedSearchSection(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if _, err := parseUnifiedSearchSection("contacts"); err == nil {
+		t.Fatal("expected unknown section to be rejected")
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+var searchMediaTypes = []string{"any", "video", "image", "link", "file"}
+
+// searchQuery is a message search query with its operators pulled out.
+// Terms keep the loose per-token matching; Phrases must appear verbatim.
+type searchQuery struct {
+	Terms   []string
+	Phrases []string
+	From    string
+	In      []string
+	Has     []string
+	Before  *time.Time
+	After   *time.Time
+}
+
+type searchQueryToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeSearchQuery splits on whitespace outside double quotes. A token
+// that starts with a quote is a phrase; quotes after an operator prefix
+// (from:"Jane Doe") only group the value.
+func tokenizeSearchQuery(raw string) []searchQueryToken {
+	var (
+		tokens  []searchQueryToken
+		current strings.Builder
+		inQuote bool
+		quoted  bool
+		started bool
+	)
+	flush := func() {
+		if started && (current.Len() > 0 || quoted) {
+			tokens = append(tokens, searchQueryToken{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+		quoted, started = false, false
+	}
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			if !started {
+				quoted = true
+			}
+			started = true
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			flush()
+		default:
+			started = true
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// parseSearchQuery understands from:, in:, has:, before: and after:. Tokens
+// with any other prefix, such as URLs, stay plain search terms.
+func parseSearchQuery(raw string) (searchQuery, error) {
+	var query searchQuery
+	for _, token := range tokenizeSearchQuery(raw) {
+		if token.quoted {
+			if phrase := strings.TrimSpace(token.text); phrase != "" {
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+		key, value, hasOperator := strings.Cut(token.text, ":")
+		key = strings.ToLower(key)
+		if !hasOperator || value == "" || !slices.Contains([]string{"from", "in", "has", "before", "after"}, key) {
+			query.Terms = append(query.Terms, token.text)
+			continue
+		}
+		switch key {
+		case "from":
+			query.From = value
+		case "in":
+			query.In = append(query.In, value)
+		case "has":
+			value = strings.ToLower(value)
+			if !slices.Contains(searchMediaTypes, value) {
+				return searchQuery{}, errs.Validation(map[string]any{"query": "has: must be one of: " + strings.Join(searchMediaTypes, ", ")})
+			}
+			query.Has = append(query.Has, value)
+		case "before", "after":
+			parsed, err := parseSearchQueryDate(value)
+			if err != nil {
+				return searchQuery{}, errs.Validation(map[string]any{"query": key + ": must be a date (2024-01-31) or RFC3339 datetime"})
+			}
+			if key == "before" {
+				query.Before = &parsed
+			} else {
+				// after: includes the given day, while DateAfter is exclusive.
+				parsed = parsed.Add(-time.Nanosecond)
+				query.After = &parsed
+			}
+		}
+	}
+	return query, nil
+}
+
+func parseSearchQueryDate(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.DateOnly, value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// apply folds the operators into the request parameters. Operators override
+// scalar parameters and extend list parameters.
+func (q searchQuery) apply(params *searchMessagesParams) {
+	params.Query = strings.Join(q.Terms, " ")
+	params.Phrases = q.Phrases
+	if q.From != "" {
+		params.Sender = q.From
+	}
+	params.ChatIDs = append(params.ChatIDs, q.In...)
+	params.MediaTypes = append(params.MediaTypes, q.Has...)
+	if q.Before != nil {
+		params.DateBefore = q.Before
+	}
+	if q.After != nil {
+		params.DateAfter = q.After
+	}
+}
+
+// matchesPhrases requires every phrase to appear in the message text,
+// ignoring case.
+func matchesPhrases(msg compat.Message, phrases []string) bool {
+	if len(phrases) == 0 {
+		return true
+	}
+	text := strings.ToLower(msg.Text)
+	for _, phrase := range phrases {
+		if !strings.Contains(text, strings.ToLower(phrase)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQueryExtractsOperators(t *testing.T) {
+	query, err := parseSearchQuery(`from:@alice:example.org in:!room:example.org has:IMAGE before:2024-02-01 after:2024-01-01 "exact phrase" lunch https://example.com/x`)
+	if err != nil {
+		t.Fatalf("parseSearchQuery returned error: %v", err)
+	}
+	if query.From != "@alice:example.org" || !slices.Equal(query.In, []string{"!room:example.org"}) || !slices.Equal(query.Has, []string{"image"}) {
+		t.Fatalf("unexpected operators %#v", query)
+	}
+	if !slices.Equal(query.Phrases, []string{"exact phrase"}) || !slices.Equal(query.Terms, []string{"lunch", "https://example.com/x"}) {
+		t.Fatalf("unexpected terms %#v / phrases %#v", query.Terms, query.Phrases)
+	}
+	if want := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC); !query.Before.Equal(want) {
+		t.Fatalf("unexpected before %v", query.Before)
+	}
+	if dayStart := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !dayStart.After(*query.After) {
+		t.Fatalf("after: should include the whole day, got %v", query.After)
+	}
+}
+
+func TestParseSearchQueryQuotedOperatorValue(t *testing.T) {
+	query, err := parseSearchQuery(`from:"me" report`)
+	if err != nil {
+		t.Fatalf("parseSearchQuery returned error: %v", err)
+	}
+	if query.From != "me" || len(query.Phrases) != 0 || !slices.Equal(query.Terms, []string{"report"}) {
+		t.Fatalf("unexpected query %#v", query)
+	}
+	if _, err = parseSearchQuery("has:gif"); err == nil {
+		t.Fatal("expected unknown has: value to be rejected")
+	}
+	if _, err = parseSearchQuery("before:tomorrow"); err == nil {
+		t.Fatal("expected invalid before: date to be rejected")
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSendMessageRequestKeepsExtraFields(t *testing.T) {
+	var req sendMessageRequest
+	body := `{"chatID":"!room:example.org","text":"hi","attachments":[{"uploadID":"a"},{"uploadID":"b"}]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.ChatID != "!room:example.org" || req.Text.Or("") != "hi" {
+		t.Fatalf("unexpected request: %#v", req)
+	}
+	if len(req.Attachments) != 2 || req.Attachments[1].UploadID != "b" {
+		t.Fatalf("unexpected attachments: %#v", req.Attachments)
+	}
+}
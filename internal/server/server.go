@@ -1,10 +1,12 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
+	"net/http/httputil"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -15,6 +17,7 @@ import (
 	"github.com/batuhan/easymatrix/internal/cursor"
 	errs "github.com/batuhan/easymatrix/internal/errors"
 	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+	"github.com/batuhan/easymatrix/internal/i18n"
 )
 
 type Server struct {
@@ -26,24 +29,81 @@ type Server struct {
 	oauthClients map[string]oauthClient
 	oauthCodes   map[string]oauthAuthorizationCode
 	oauthTokens  map[string]oauthAccessToken
-	oauthSubject string
-	oauthState   string
+	// oauthConsents are remembered approvals; oauthPending holds authorize
+	// requests waiting on the consent page.
+	oauthConsents map[string]oauthConsent
+	oauthPending  map[string]oauthPendingAuthorization
+	oauthSubject  string
+	oauthState    string
+	// oauthStateSeen is the version of the state file last loaded, which a
+	// follower compares against to pick up the primary's changes.
+	oauthStateSeen stateFileStamp
+	oauthSigner    *oauthSigningKey
 
 	ws *wsHub
+
+	chatMetadata       *namespacedMetadataStore
+	messageAnnotations *namespacedMetadataStore
+	sandboxes          *sandboxStore
+	autoArchive        *autoArchiveStore
+	digests            *digestStore
+	calendarFeedTokens *calendarFeedTokenStore
+	contactCache       *contactCache
+	roomBridges        *roomBridgeCache
+	readMarkers        *readMarkerCache
+	bridgeLogins       *bridgeLoginTracker
+	changes            *changeJournal
+	exports            chatExportJobs
+	identity           *identityProvider
+	clientPolicies     *clientPolicyStore
+	workPools          map[string]*workPool
+	rateLimiter        *rateLimiter
+	messageCounts      *messageCounter
+	linkPreviews       *linkPreviewFetcher
+	// primary is set in follower mode; followerRoutes lists the API routes
+	// answered from the replica instead of being proxied.
+	primary        *httputil.ReverseProxy
+	followerRoutes map[string]struct{}
+
+	// assetPruneMu serializes cache eviction and storage repair, which both
+	// walk and delete cached asset files.
+	assetPruneMu   sync.Mutex
+	uploadSessions uploadSessionLocks
+
+	backgroundMu     sync.Mutex
+	backgroundCancel context.CancelFunc
 }
 
 type apiHandler func(http.ResponseWriter, *http.Request) error
 
 func New(cfg config.Config, rt *gomuksruntime.Runtime) *Server {
 	s := &Server{
-		cfg:          cfg,
-		rt:           rt,
-		auth:         auth.New(cfg.AccessToken, cfg.AllowQueryTokenAuth),
-		oauthClients: make(map[string]oauthClient),
-		oauthCodes:   make(map[string]oauthAuthorizationCode),
-		oauthTokens:  make(map[string]oauthAccessToken),
-		oauthSubject: "local-user",
-		oauthState:   filepath.Join(rt.StateDir(), "oauth", "state.json"),
+		cfg:                cfg,
+		rt:                 rt,
+		auth:               auth.New(cfg.AccessToken, cfg.AllowQueryTokenAuth),
+		oauthClients:       make(map[string]oauthClient),
+		oauthCodes:         make(map[string]oauthAuthorizationCode),
+		oauthTokens:        make(map[string]oauthAccessToken),
+		oauthConsents:      make(map[string]oauthConsent),
+		oauthPending:       make(map[string]oauthPendingAuthorization),
+		oauthSubject:       "local-user",
+		oauthState:         filepath.Join(rt.StateDir(), "oauth", "state.json"),
+		chatMetadata:       newNamespacedMetadataStore(filepath.Join(rt.StateDir(), "chat-metadata.json")),
+		messageAnnotations: newNamespacedMetadataStore(filepath.Join(rt.StateDir(), "message-annotations.json")),
+		sandboxes:          newSandboxStore(filepath.Join(rt.StateDir(), "sandboxes.json")),
+		autoArchive:        newAutoArchiveStore(filepath.Join(rt.StateDir(), "auto-archive.json")),
+		digests:            newDigestStore(filepath.Join(rt.StateDir(), "digest.json")),
+		calendarFeedTokens: newCalendarFeedTokenStore(filepath.Join(rt.StateDir(), "calendar-feed.json")),
+		contactCache:       newContactCache(),
+		roomBridges:        &roomBridgeCache{},
+		readMarkers:        &readMarkerCache{},
+		bridgeLogins:       newBridgeLoginTracker(),
+		changes:            newChangeJournal(filepath.Join(rt.StateDir(), "changes.json")),
+		clientPolicies:     newClientPolicyStore(filepath.Join(rt.StateDir(), "oauth", "client-policies.json")),
+		workPools:          newWorkPools(cfg.SearchConcurrency, cfg.UploadConcurrency),
+		rateLimiter:        newRateLimiter(cfg.RateLimit),
+		messageCounts:      newMessageCounter(),
+		linkPreviews:       newLinkPreviewFetcher(),
 	}
 	if strings.TrimSpace(cfg.AccessToken) != "" {
 		s.initOAuthState(cfg.AccessToken)
@@ -51,11 +111,85 @@ func New(cfg config.Config, rt *gomuksruntime.Runtime) *Server {
 	if err := s.loadOAuthState(); err != nil {
 		log.Printf("failed to load oauth state: %v", err)
 	}
+	if cfg.OAuthTokenFormat == "jwt" {
+		signer, err := loadOrCreateSigningKey(filepath.Join(rt.StateDir(), "oauth", "jwt-signing-key.pem"))
+		if err != nil {
+			log.Printf("falling back to opaque access tokens: %v", err)
+		} else {
+			s.oauthSigner = signer
+		}
+	}
+	if cfg.IdentityIntrospectionURL != "" {
+		identity, err := newIdentityProvider(cfg.IdentityIntrospectionURL, cfg.IdentityClientID, cfg.IdentityClientSecret, cfg.SubjectPoliciesFile)
+		if err != nil {
+			log.Printf("multi-user mode disabled: %v", err)
+		} else {
+			s.identity = identity
+		}
+	}
+	if cfg.PrimaryURL != "" {
+		primary, err := newPrimaryProxy(cfg.PrimaryURL)
+		if err != nil {
+			log.Printf("follower mode disabled: %v", err)
+		} else {
+			s.primary = primary
+			s.followerRoutes = make(map[string]struct{})
+			s.followReplicatedStores()
+		}
+	}
 	s.auth.SetTokenInfoProvider(s.tokenInfoForBearer)
 	s.ws = newWSHub(s)
 	return s
 }
 
+// Start launches background workers that depend on a running gomuks runtime.
+// It is safe to call more than once; only the first call has an effect.
+func (s *Server) Start() error {
+	s.backgroundMu.Lock()
+	defer s.backgroundMu.Unlock()
+	if s.backgroundCancel != nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.backgroundCancel = cancel
+	// Followers do not sync, and every worker below either waits for sync
+	// events or writes state that belongs to the primary.
+	if s.primary != nil {
+		return nil
+	}
+	go s.repairStorageOnStartup()
+	// Subscribing up front lets /v1/health report sync freshness even when no
+	// websocket client, script or plugin is listening.
+	if err := s.ws.ensureSubscription(); err != nil {
+		log.Printf("failed to subscribe to sync events: %v", err)
+	}
+	if err := s.startScripts(ctx); err != nil {
+		log.Printf("failed to start scripts: %v", err)
+	}
+	if err := s.startPlugins(ctx); err != nil {
+		log.Printf("failed to start plugins: %v", err)
+	}
+	go s.runAutoArchive(ctx)
+	go s.runDigests(ctx)
+	go s.runContactCacheRefresh(ctx)
+	go s.runAssetCacheEviction(ctx)
+	go s.runLinkPreviewFetcher(ctx)
+	s.rt.OnVerificationUpdate(s.publishVerification)
+	go s.rt.RunVerification(ctx)
+	return nil
+}
+
+// Stop cancels background workers started by Start.
+func (s *Server) Stop() {
+	s.ws.stop()
+	s.backgroundMu.Lock()
+	defer s.backgroundMu.Unlock()
+	if s.backgroundCancel != nil {
+		s.backgroundCancel()
+		s.backgroundCancel = nil
+	}
+}
+
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
@@ -70,6 +204,12 @@ func (s *Server) Handler() http.Handler {
 	mux.Handle("POST /manage/login-token", s.manage(s.manageLoginToken))
 	mux.Handle("POST /manage/login-custom", s.manage(s.manageLoginCustom))
 	mux.Handle("POST /manage/verify", s.manage(s.manageVerify))
+	mux.Handle("GET /manage/verifications", s.manage(s.manageListVerifications))
+	mux.Handle("POST /manage/verifications", s.manage(s.manageStartVerification))
+	mux.Handle("POST /manage/verifications/{transactionID}/accept", s.manage(s.manageVerificationAction((*gomuksruntime.Runtime).AcceptVerification)))
+	mux.Handle("POST /manage/verifications/{transactionID}/confirm", s.manage(s.manageVerificationAction((*gomuksruntime.Runtime).ConfirmVerification)))
+	mux.Handle("POST /manage/verifications/{transactionID}/cancel", s.manage(s.manageVerificationAction((*gomuksruntime.Runtime).CancelVerification)))
+	mux.Handle("POST /manage/logout", s.manage(s.manageLogout))
 	mux.Handle("POST /manage/access-token", s.manage(s.manageIssueAccessToken))
 	mux.Handle("POST /manage/beeper/start-login", s.manage(s.manageBeeperStartLogin))
 	mux.Handle("POST /manage/beeper/request-code", s.manage(s.manageBeeperRequestCode))
@@ -77,8 +217,16 @@ func (s *Server) Handler() http.Handler {
 	mux.Handle("GET /.well-known/oauth-protected-resource", s.public(s.oauthProtectedResourceMetadata))
 	mux.Handle("GET /.well-known/oauth-protected-resource/", s.public(s.oauthProtectedResourceMetadata))
 	mux.Handle("GET /.well-known/oauth-authorization-server", s.public(s.oauthAuthorizationServerMetadata))
+	mux.Handle("GET /.well-known/jwks.json", s.public(s.oauthJWKS))
+	mux.HandleFunc("GET /.well-known/carddav", s.cardDAVWellKnown)
+	mux.HandleFunc("PROPFIND /.well-known/carddav", s.cardDAVWellKnown)
+	s.handleCardDAV(mux, "OPTIONS /carddav/", s.cardDAVOptions)
+	s.handleCardDAV(mux, "PROPFIND /carddav/", s.cardDAVPropfind)
+	s.handleCardDAV(mux, "REPORT /carddav/", s.cardDAVReport)
+	s.handleCardDAV(mux, "GET /carddav/", s.cardDAVGet)
 	mux.Handle("GET /oauth/authorize", s.public(s.oauthAuthorize))
 	mux.Handle("POST /oauth/authorize/callback", s.public(s.oauthAuthorizeCallback))
+	mux.Handle("POST /oauth/authorize/consent", s.public(s.oauthAuthorizeConsent))
 	mux.Handle("POST /oauth/token", s.public(s.oauthToken))
 	mux.Handle("GET /oauth/userinfo", s.public(s.oauthUserInfo))
 	mux.Handle("POST /oauth/revoke", s.public(s.oauthRevoke))
@@ -92,47 +240,173 @@ func (s *Server) Handler() http.Handler {
 	mux.Handle("GET /focus/{chatID}/{messageID}", s.public(s.focusPage))
 
 	s.handle(mux, "GET /v1/accounts", s.getAccounts, false, "read")
+	s.handle(mux, rateLimitStatusRoute, s.getRateLimit, false, "read")
+	s.handle(mux, "GET /v1/health", s.getServerHealth, false, "read")
+	s.handle(mux, "GET /v1/stats/networks", s.getNetworkStats, false, "read")
+	s.handle(mux, "DELETE /v1/accounts/{accountID}", s.disconnectAccount, false, "write")
+	s.handle(mux, "GET /v1/accounts/{accountID}/status", s.getAccountStatus, false, "read")
+	s.handle(mux, "GET /v1/accounts/connect/{bridgeID}/flows", s.listBridgeLoginFlows, false, "read")
+	s.handle(mux, "POST /v1/accounts/connect/{bridgeID}", s.startBridgeLogin, false, "write")
+	s.handle(mux, "GET /v1/accounts/connect/{bridgeID}/logins/{loginProcessID}", s.getBridgeLogin, false, "read")
+	s.handle(mux, "POST /v1/accounts/connect/{bridgeID}/logins/{loginProcessID}", s.submitBridgeLoginStep, false, "write")
+	s.handle(mux, "GET /v1/preferences/accounts", s.getAccountPreferences, false, "read")
+	s.handle(mux, "PUT /v1/preferences/accounts", s.setAccountPreferences, false, "write")
+	s.handle(mux, "GET /v1/account-data/{type}", s.getGlobalAccountData, false, "read")
+	s.handle(mux, "PUT /v1/account-data/{type}", s.setGlobalAccountData, false, "write")
+	s.handle(mux, "GET /v1/e2ee/sessions/{chatID}", s.getE2EESessionHealth, false, "read")
+	s.handle(mux, "POST /v1/e2ee/sessions/{chatID}/repair", s.repairE2EESessions, false, "write")
+	s.handle(mux, "GET /v1/encryption/backup", s.getKeyBackup, false, "read")
+	s.handle(mux, "POST /v1/encryption/backup", s.createKeyBackup, false, "write")
+	s.handle(mux, "POST /v1/encryption/backup/enable", s.enableKeyBackup, false, "write")
+	s.handle(mux, "POST /v1/encryption/backup/restore", s.restoreKeyBackup, false, "write")
+
+	s.handle(mux, "GET /v1/invites", s.listInvites, false, "read")
+	s.handle(mux, "POST /v1/invites/{chatID}/accept", s.acceptInvite, false, "write")
+	s.handle(mux, "POST /v1/invites/{chatID}/reject", s.rejectInvite, false, "write")
+	s.handle(mux, "GET /v1/spaces", s.listSpaces, false, "read")
+	s.handle(mux, "GET /v1/spaces/{spaceID}/chats", s.listSpaceChats, false, "read")
 
 	s.handle(mux, "GET /v1/chats", s.listChats, false, "read")
 	s.handle(mux, "POST /v1/chats", s.createChat, false, "write")
 	s.handle(mux, "GET /v1/chats/{chatID}", s.getChat, false, "read")
+	s.handle(mux, "GET /v1/chats/{chatID}/participants", s.listParticipants, false, "read")
+	s.handle(mux, "GET /v1/chats/{chatID}/membership-history", s.listMembershipHistory, false, "read")
+	s.handle(mux, "GET /v1/rooms/{roomIDOrAlias}/preview", s.previewRoom, false, "read")
+	s.handle(mux, "PATCH /v1/chats/{chatID}", s.updateChat, false, "write")
 	s.handle(mux, "GET /v1/chats/search", s.searchChats, false, "read")
+	s.handle(mux, "GET /v1/chats/find", s.findChats, false, "read")
 	s.handle(mux, "POST /v1/chats/{chatID}/archive", s.archiveChat, false, "write")
+	s.handle(mux, "POST /v1/chats/{chatID}/mark-unread", s.markChatUnread, false, "write")
+	s.handle(mux, "POST /v1/chats/{chatID}/pin", s.pinChat, false, "write")
+	s.handle(mux, "POST /v1/chats/{chatID}/low-priority", s.lowPriorityChat, false, "write")
+	s.handle(mux, "GET /v1/chats/{chatID}/tags", s.getChatTags, false, "read")
+	s.handle(mux, "PUT /v1/chats/{chatID}/tags", s.setChatTags, false, "write")
+	s.handle(mux, "DELETE /v1/chats/{chatID}/tags", s.deleteChatTag, false, "write")
+	s.handle(mux, "GET /v1/chats/{chatID}/account-data/{type}", s.getRoomAccountData, false, "read")
+	s.handle(mux, "PUT /v1/chats/{chatID}/account-data/{type}", s.setRoomAccountData, false, "write")
+	s.handle(mux, "POST /v1/chats/{chatID}/leave", s.leaveChat, false, "write")
+	s.handle(mux, "DELETE /v1/chats/{chatID}", s.leaveChat, false, "write")
+	s.handle(mux, "POST /v1/chats/{chatID}/participants", s.inviteParticipants, false, "write")
+	s.handle(mux, "DELETE /v1/chats/{chatID}/participants/{participantID}", s.removeParticipant, false, "write")
+	s.handle(mux, "GET /v1/chats/{chatID}/bans", s.listChatBans, false, "read")
+	s.handle(mux, "POST /v1/chats/{chatID}/bans", s.banUser, false, "write")
+	s.handle(mux, "DELETE /v1/chats/{chatID}/bans/{userID}", s.unbanUser, false, "write")
+	s.handle(mux, "GET /v1/chats/{chatID}/permissions", s.getChatPermissions, false, "read")
+	s.handle(mux, "PATCH /v1/chats/{chatID}/permissions", s.updateChatPermissions, false, "write")
+	s.handle(mux, "GET /v1/chats/{chatID}/metadata/{namespace}", s.getChatMetadata, false, "read")
+	s.handle(mux, "PUT /v1/chats/{chatID}/metadata/{namespace}", s.setChatMetadata, false, "write")
 	s.handle(mux, "POST /v1/chats/{chatID}/reminders", s.setChatReminder, false, "write")
 	s.handle(mux, "DELETE /v1/chats/{chatID}/reminders", s.clearChatReminder, false, "write")
+	mux.Handle("GET /v1/calendar.ics", s.calendarFeedHandler())
+	s.handle(mux, "POST /v1/calendar/feed-token", s.createCalendarFeedToken, false, "write")
+	s.handle(mux, "DELETE /v1/calendar/feed-token", s.deleteCalendarFeedToken, false, "write")
+	s.handle(mux, "GET /v1/digest", s.getDigestSettings, false, "read")
+	s.handle(mux, "PUT /v1/digest", s.setDigestSettings, false, "write")
+	s.handle(mux, "DELETE /v1/digest", s.deleteDigestSettings, false, "write")
+	s.handle(mux, "POST /v1/digest/send", s.sendDigestNow, false, "write")
 
 	s.handle(mux, "GET /v1/chats/{chatID}/messages", s.listMessages, false, "read")
+	s.handle(mux, "GET /v1/chats/{chatID}/media", s.listChatMedia, false, "read")
+	s.handle(mux, "POST /v1/chats/{chatID}/export", s.startChatExport, false, "read")
+	s.handle(mux, "GET /v1/exports/{exportID}", s.getChatExport, false, "read")
+	s.handle(mux, "GET /v1/chats/{chatID}/messages/{messageID}", s.getMessage, false, "read")
 	s.handle(mux, "POST /v1/chats/{chatID}/messages", s.sendMessage, false, "write")
 	s.handle(mux, "PUT /v1/chats/{chatID}/messages/{messageID}", s.editMessage, false, "write")
+	s.handle(mux, "GET /v1/chats/{chatID}/messages/{messageID}/reactions", s.listMessageReactions, false, "read")
 	s.handle(mux, "POST /v1/chats/{chatID}/messages/{messageID}/reactions", s.addReaction, false, "write")
 	s.handle(mux, "DELETE /v1/chats/{chatID}/messages/{messageID}/reactions", s.removeReaction, false, "write")
+	s.handle(mux, "GET /v1/chats/{chatID}/messages/{messageID}/receipts", s.listMessageReceipts, false, "read")
+	s.handle(mux, "GET /v1/chats/{chatID}/messages/{messageID}/annotations/{namespace}", s.getMessageAnnotation, false, "read")
+	s.handle(mux, "PUT /v1/chats/{chatID}/messages/{messageID}/annotations/{namespace}", s.setMessageAnnotation, false, "write")
 	s.handle(mux, "GET /v1/messages/search", s.searchMessages, false, "read")
+	s.handle(mux, "GET /v1/followups", s.listFollowups, false, "read")
+	s.handle(mux, "GET /v1/changes", s.listChanges, false, "read")
 	s.handle(mux, "GET /v1/ws", s.wsEvents, true, "read")
 
 	s.handle(mux, "POST /v1/assets/download", s.downloadAsset, false, "read")
 	s.handle(mux, "GET /v1/assets/serve", s.serveAsset, true, "read")
+	s.handle(mux, "GET /v1/assets/thumbnail", s.getAssetThumbnail, true, "read")
 	s.handle(mux, "POST /v1/assets/upload", s.uploadAsset, false, "write")
 	s.handle(mux, "POST /v1/assets/upload/base64", s.uploadAsset, false, "write")
+	s.handle(mux, "POST /v1/assets/uploads", s.createUploadSession, false, "write")
+	s.handle(mux, "GET /v1/assets/uploads/{sessionID}", s.getUploadSession, false, "write")
+	s.handle(mux, "PATCH /v1/assets/uploads/{sessionID}", s.appendUploadChunk, false, "write")
+	s.handle(mux, "POST /v1/assets/uploads/{sessionID}/finalize", s.finalizeUploadSession, false, "write")
+	s.handle(mux, "DELETE /v1/assets/uploads/{sessionID}", s.deleteUploadSession, false, "write")
 
 	s.handle(mux, "GET /v1/accounts/{accountID}/contacts", s.searchContacts, false, "read")
 	s.handle(mux, "GET /v1/accounts/{accountID}/contacts/list", s.listContacts, false, "read")
+	s.handle(mux, "GET /v1/contacts/search", s.searchAllContacts, false, "read")
 	s.handle(mux, "GET /v1/search", s.search, false, "read")
 	s.handle(mux, "POST /v1/focus", s.focusApp, false, "read")
 
+	s.handleAdmin(mux, "GET /v1/admin/sandbox", s.listSandboxes, "read")
+	s.handleAdmin(mux, "POST /v1/admin/sandbox", s.createSandbox, "write")
+	s.handleAdmin(mux, "DELETE /v1/admin/sandbox/{chatID}", s.purgeSandbox, "write")
+	s.handleAdmin(mux, "GET /v1/admin/auto-archive", s.getAutoArchivePolicy, "read")
+	s.handleAdmin(mux, "PUT /v1/admin/auto-archive", s.setAutoArchivePolicy, "write")
+	s.handleAdmin(mux, "POST /v1/admin/replay", s.replayEvents, "write")
+	s.handleAdmin(mux, "POST /v1/admin/storage/repair", s.runStorageRepair, "write")
+	s.handleAdmin(mux, "POST /v1/admin/assets/cache/prune", s.pruneAssetCache, "write")
+	s.handleAdmin(mux, "POST /v1/admin/oauth/clients", s.createConfidentialClient, "write")
+	s.handleAdmin(mux, "GET /v1/admin/oauth/consents", s.listOAuthConsents, "read")
+	s.handleAdmin(mux, "DELETE /v1/admin/oauth/consents/{clientID}", s.revokeOAuthConsent, "write")
+	s.handleAdmin(mux, "GET /v1/admin/client-policies", s.listClientPolicies, "read")
+	s.handleAdmin(mux, "PUT /v1/admin/client-policies/{clientID}", s.setClientPolicy, "write")
+	s.handleAdmin(mux, "DELETE /v1/admin/client-policies/{clientID}", s.deleteClientPolicy, "write")
+	s.handleAdmin(mux, "GET /v1/admin/config", s.exportDeclarativeConfig, "read")
+	s.handleAdmin(mux, "PUT /v1/admin/config", s.applyDeclarativeConfig, "write")
+	s.handleAdmin(mux, "GET /v1/admin/account/export", s.exportAccount, "read")
+	s.handleAdmin(mux, "POST /v1/admin/account/import", s.importAccount, "write")
+
+	if s.primary != nil {
+		return s.followerHandler(mux)
+	}
 	return mux
 }
 
 func (s *Server) handle(mux *http.ServeMux, pattern string, handler apiHandler, allowQueryToken bool, requiredScopes ...string) {
-	wrapped := s.wrap(handler)
+	if s.primary != nil && servedByFollower(pattern, requiredScopes) {
+		s.followerRoutes[pattern] = struct{}{}
+	}
+	if sandboxChatWrite(pattern, requiredScopes) {
+		handler = s.routeToSandbox(handler)
+	}
+	wrapped := s.wrap(handler, bodyLimitForRoute(pattern), s.workPools[routeWorkPools[pattern]])
 	mux.Handle(pattern, s.auth.Wrap(wrapped, allowQueryToken, requiredScopes))
 }
 
-func (s *Server) wrap(handler apiHandler) http.Handler {
+// handleAdmin registers a route that also requires the manage secret.
+func (s *Server) handleAdmin(mux *http.ServeMux, pattern string, handler apiHandler, requiredScopes ...string) {
+	s.handle(mux, pattern, s.requireManageSecret(handler), false, requiredScopes...)
+}
+
+func (s *Server) wrap(handler apiHandler, bodyLimit int64, pool *workPool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitRequestBody(w, r, bodyLimit)
+		r = r.WithContext(i18n.WithLocale(r.Context(), i18n.FromRequest(r)))
 		if err := s.requireLoggedInSession(); err != nil {
 			errs.Write(w, err)
 			return
 		}
+		if err := s.enforceSubjectPolicy(r); err != nil {
+			errs.Write(w, err)
+			return
+		}
+		if r.Pattern != rateLimitStatusRoute {
+			if err := s.applyRateLimit(w, r); err != nil {
+				errs.Write(w, err)
+				return
+			}
+		}
+		if pool != nil {
+			release, err := pool.acquire(r)
+			if err != nil {
+				errs.Write(w, err)
+				return
+			}
+			defer release()
+		}
 		if err := handler(w, r); err != nil {
 			errs.Write(w, err)
 		}
@@ -141,6 +415,7 @@ func (s *Server) wrap(handler apiHandler) http.Handler {
 
 func (s *Server) public(handler apiHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitRequestBody(w, r, defaultBodyLimitBytes)
 		if err := handler(w, r); err != nil {
 			errs.Write(w, err)
 		}
@@ -149,6 +424,7 @@ func (s *Server) public(handler apiHandler) http.Handler {
 
 func (s *Server) manage(handler apiHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitRequestBody(w, r, defaultBodyLimitBytes)
 		handled, err := s.authorizeManageRequest(w, r)
 		if err != nil {
 			errs.Write(w, err)
@@ -180,6 +456,9 @@ func decodeJSON(r *http.Request, out any) error {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(out); err != nil {
+		if tooLarge := payloadTooLargeError(err); tooLarge != nil {
+			return tooLarge
+		}
 		return errs.Validation(map[string]any{"error": err.Error()})
 	}
 	return nil
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	databaseSizeQuery = `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`
+	// Local echoes keep their "~" transaction ID until the homeserver accepts
+	// them; failed sends carry a send_error and are not retried on their own.
+	pendingOutboxCountQuery = `SELECT COUNT(*) FROM event WHERE event_id LIKE '~%' AND COALESCE(send_error, '') = ''`
+)
+
+// getServerHealth serves the monitoring numbers to the deployment owner. They
+// describe the whole instance, so callers confined by a policy are refused.
+func (s *Server) getServerHealth(w http.ResponseWriter, r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Server health is only available to the deployment owner")
+	}
+	return writeJSON(w, s.serverHealth(r.Context()))
+}
+
+// serverHealth collects the monitoring numbers for /v1/health. Each number is
+// best effort: a failing query leaves its field at zero rather than failing
+// the whole response.
+func (s *Server) serverHealth(ctx context.Context) compat.ServerHealth {
+	health := compat.ServerHealth{WSClientCount: s.ws.clientCount(), WorkPools: s.workPoolStats()}
+	if s.primary != nil {
+		health.PrimaryURL = s.cfg.PrimaryURL
+	}
+	if lastSync := s.ws.lastSyncAt.Load(); lastSync > 0 {
+		lastSyncAt := time.UnixMilli(lastSync).UTC()
+		lag := int64(time.Since(lastSyncAt).Seconds())
+		health.LastSyncAt = &lastSyncAt
+		health.SyncLagSeconds = &lag
+	}
+	cli := s.rt.Client()
+	if cli == nil || cli.DB == nil {
+		return health
+	}
+	_ = cli.DB.QueryRow(ctx, databaseSizeQuery).Scan(&health.DBSizeBytes)
+	_ = cli.DB.QueryRow(ctx, pendingOutboxCountQuery).Scan(&health.PendingOutboxCount)
+	return health
+}
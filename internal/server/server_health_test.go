@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mcpauth "github.com/modelcontextprotocol/go-sdk/auth"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/config"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func TestInfoOmitsServerHealth(t *testing.T) {
+	cfg := config.Config{ListenAddr: "127.0.0.1:0", StateDir: t.TempDir(), AccessToken: "test-token", MatrixHomeserverURL: "https://matrix.beeper.com"}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	handler := New(cfg, rt).Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/info", nil))
+	var info struct {
+		Server map[string]any `json:"server"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode /v1/info response: %v", err)
+	}
+	for _, field := range []string{"dbSizeBytes", "pendingOutboxCount", "wsClientCount", "lastSyncAt", "workPools", "primaryURL"} {
+		if _, ok := info.Server[field]; ok {
+			t.Fatalf("expected public /v1/info to omit %s", field)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/health", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected /v1/health to require a token, got %d", rec.Code)
+	}
+}
+
+func TestGetServerHealthReportsDatabaseForOwnerOnly(t *testing.T) {
+	s := newDBTestServer(t)
+	insertTestRoom(t, s, "!room:example.org")
+	insertTestEvent(t, s, testTextEvent("!room:example.org", "~pending", testOwnUserID, "sending"))
+	insertTestEvent(t, s, testTextEvent("!room:example.org", "$sent", testOwnUserID, "sent"))
+
+	rec := httptest.NewRecorder()
+	if err := s.getServerHealth(rec, httptest.NewRequest(http.MethodGet, "/v1/health", nil)); err != nil {
+		t.Fatalf("getServerHealth returned error: %v", err)
+	}
+	var health compat.ServerHealth
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	if health.DBSizeBytes <= 0 || health.PendingOutboxCount != 1 || health.LastSyncAt != nil {
+		t.Fatalf("unexpected health %#v", health)
+	}
+
+	if err := s.clientPolicies.put(compat.ClientAccessPolicy{ClientID: "agent", Read: compat.ClientAccessRule{ChatIDs: []string{"!room:example.org"}}}); err != nil {
+		t.Fatalf("failed to store policy: %v", err)
+	}
+	agent := func(context.Context, string, *http.Request) (*mcpauth.TokenInfo, error) {
+		return &mcpauth.TokenInfo{Expiration: time.Now().Add(time.Hour), Extra: map[string]any{"client_id": "agent"}}, nil
+	}
+	var confinedErr error
+	handler := mcpauth.RequireBearerToken(agent, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		confinedErr = s.getServerHealth(w, r)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if confinedErr == nil {
+		t.Fatal("expected a policy-confined client to be refused")
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/batuhan/easymatrix/internal/auth"
+)
+
+// sessionPathPrefix namespaces the routes of additional Matrix sessions:
+// /sessions/work/v1/chats is GET /v1/chats of the "work" session.
+const sessionPathPrefix = "/sessions/"
+
+// NewSessionRouter serves each named session's handler under
+// /sessions/{name}/ and everything else from the primary handler. Every
+// session is a full Server, so the route surface is identical; the prefix is
+// recorded on the request so the URLs a session hands out point back at it.
+func NewSessionRouter(primary http.Handler, sessions map[string]http.Handler) http.Handler {
+	if len(sessions) == 0 {
+		return primary
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, sessionPathPrefix)
+		if !ok {
+			primary.ServeHTTP(w, r)
+			return
+		}
+		name, _, _ := strings.Cut(rest, "/")
+		handler, ok := sessions[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		prefix := sessionPathPrefix + name
+		http.StripPrefix(prefix, handler).ServeHTTP(w, auth.WithBasePath(r, prefix))
+	})
+}
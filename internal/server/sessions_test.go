@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionRouterStripsSessionPrefix(t *testing.T) {
+	record := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name + " " + r.URL.Path))
+		})
+	}
+	router := NewSessionRouter(record("primary"), map[string]http.Handler{"work": record("work")})
+	cases := map[string]string{
+		"/v1/chats":                "primary /v1/chats",
+		"/sessions/work/v1/chats":  "work /v1/chats",
+		"/sessions/work/manage":    "work /manage",
+		"/sessions/other/v1/chats": "404 page not found\n",
+	}
+	for path, want := range cases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := rec.Body.String(); got != want {
+			t.Fatalf("%s: got %q want %q", path, got, want)
+		}
+	}
+}
+
+func TestSessionOAuthMetadataPointsAtSession(t *testing.T) {
+	s := newDBTestServer(t)
+	router := NewSessionRouter(http.NotFoundHandler(), map[string]http.Handler{"x": s.Handler()})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://easymatrix.test/sessions/x/.well-known/oauth-authorization-server", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	var metadata map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("failed to decode metadata: %v", err)
+	}
+	base := "http://easymatrix.test/sessions/x"
+	for key, want := range map[string]string{
+		"issuer":                 base,
+		"authorization_endpoint": base + "/oauth/authorize",
+		"token_endpoint":         base + "/oauth/token",
+		"registration_endpoint":  base + "/oauth/register",
+	} {
+		if metadata[key] != want {
+			t.Fatalf("%s: got %v want %s", key, metadata[key], want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://easymatrix.test/sessions/x/.well-known/oauth-protected-resource", nil))
+	if !strings.Contains(rec.Body.String(), `"resource":"`+base+`/v1"`) {
+		t.Fatalf("unexpected protected resource metadata: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+type spaceChild struct {
+	RoomID    id.RoomID
+	Order     string
+	Timestamp int64
+}
+
+// spaceChildrenFromState extracts live m.space.child entries (those with a
+// non-empty via list) in the order the spec prescribes: explicit order
+// first, then by when the child was added, then by room ID.
+func spaceChildrenFromState(events []*database.Event) []spaceChild {
+	children := make([]spaceChild, 0)
+	for _, evt := range events {
+		if evt == nil || evt.Type != event.StateSpaceChild.Type || evt.StateKey == nil || *evt.StateKey == "" {
+			continue
+		}
+		var content event.SpaceChildEventContent
+		if err := json.Unmarshal(evt.GetContent(), &content); err != nil || len(content.Via) == 0 {
+			continue
+		}
+		order := content.Order
+		if len(order) > 50 || strings.IndexFunc(order, func(r rune) bool { return r < 0x20 || r > 0x7e }) >= 0 {
+			order = ""
+		}
+		children = append(children, spaceChild{RoomID: id.RoomID(*evt.StateKey), Order: order, Timestamp: evt.Timestamp.UnixMilli()})
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		a, b := children[i], children[j]
+		if (a.Order == "") != (b.Order == "") {
+			return a.Order != ""
+		}
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		if a.Timestamp != b.Timestamp {
+			return a.Timestamp < b.Timestamp
+		}
+		return a.RoomID < b.RoomID
+	})
+	return children
+}
+
+func isSpaceRoom(room *database.Room) bool {
+	return room != nil && room.CreationContent != nil && room.CreationContent.Type == event.RoomTypeSpace
+}
+
+func (s *Server) loadSpaceChildren(ctx context.Context, spaceID id.RoomID) ([]spaceChild, error) {
+	events, err := s.rt.Client().DB.CurrentState.GetAllExceptMembers(ctx, spaceID)
+	if err != nil {
+		return nil, errs.Internal(fmt.Errorf("failed to read space state: %w", err))
+	}
+	return spaceChildrenFromState(events), nil
+}
+
+func mapRoomToSpace(room *database.Room) compat.Space {
+	space := compat.Space{
+		ID:             string(room.ID),
+		Title:          strings.TrimSpace(ptrString(room.Name)),
+		Description:    strings.TrimSpace(ptrString(room.Topic)),
+		ChildSpaceIDs:  []string{},
+		ParentSpaceIDs: []string{},
+	}
+	if space.Title == "" {
+		space.Title = string(room.ID)
+	}
+	if room.Avatar != nil && !room.Avatar.IsEmpty() {
+		space.ImgURL = room.Avatar.String()
+	}
+	return space
+}
+
+// loadSpaceHierarchy maps every joined space and links parents and children
+// among them. Children that are not joined spaces count towards ChildCount.
+func (s *Server) loadSpaceHierarchy(ctx context.Context) ([]compat.Space, map[id.RoomID][]spaceChild, error) {
+	rooms, err := s.rt.Client().DB.Room.GetAllSpaces(ctx)
+	if err != nil {
+		return nil, nil, errs.Internal(fmt.Errorf("failed to query spaces: %w", err))
+	}
+	index := make(map[id.RoomID]int, len(rooms))
+	spaces := make([]compat.Space, len(rooms))
+	for idx, room := range rooms {
+		index[room.ID] = idx
+		spaces[idx] = mapRoomToSpace(room)
+	}
+	childrenBySpace := make(map[id.RoomID][]spaceChild, len(rooms))
+	for idx, room := range rooms {
+		children, loadErr := s.loadSpaceChildren(ctx, room.ID)
+		if loadErr != nil {
+			return nil, nil, loadErr
+		}
+		childrenBySpace[room.ID] = children
+		for _, child := range children {
+			childIdx, isSpace := index[child.RoomID]
+			if !isSpace {
+				spaces[idx].ChildCount++
+				continue
+			}
+			spaces[idx].ChildSpaceIDs = append(spaces[idx].ChildSpaceIDs, string(child.RoomID))
+			spaces[childIdx].ParentSpaceIDs = append(spaces[childIdx].ParentSpaceIDs, string(room.ID))
+		}
+	}
+	sort.Slice(spaces, func(i, j int) bool {
+		if spaces[i].Title != spaces[j].Title {
+			return spaces[i].Title < spaces[j].Title
+		}
+		return spaces[i].ID < spaces[j].ID
+	})
+	return spaces, childrenBySpace, nil
+}
+
+func (s *Server) listSpaces(w http.ResponseWriter, r *http.Request) error {
+	spaces, _, err := s.loadSpaceHierarchy(r.Context())
+	if err != nil {
+		return err
+	}
+	visibility := s.requestPolicy(r)
+	if visibility == nil {
+		return writeJSON(w, compat.ListSpacesOutput{Items: spaces})
+	}
+	lookup, err := s.buildAccountLookup(r.Context())
+	if err != nil {
+		return err
+	}
+	items := make([]compat.Space, 0, len(spaces))
+	for _, space := range spaces {
+		accountID, _ := inferAccountForRoom(id.RoomID(space.ID), lookup)
+		if visibility.allowsChat(space.ID, accountID) {
+			items = append(items, space)
+		}
+	}
+	return writeJSON(w, compat.ListSpacesOutput{Items: items})
+}
+
+func (s *Server) listSpaceChats(w http.ResponseWriter, r *http.Request) error {
+	spaceID := id.RoomID(strings.TrimSpace(r.PathValue("spaceID")))
+	if spaceID == "" {
+		return errs.Validation(map[string]any{"spaceID": "spaceID is required"})
+	}
+	recursive, err := parseOptionalBool(r.URL.Query().Get("recursive"), false, "recursive")
+	if err != nil {
+		return err
+	}
+	ctx := r.Context()
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return err
+	}
+	visibility := s.requestPolicy(r)
+	spaceAccountID, _ := inferAccountForRoom(spaceID, lookup)
+	if !visibility.allowsChat(string(spaceID), spaceAccountID) {
+		return errs.NotFound("Space not found")
+	}
+	spaces, childrenBySpace, err := s.loadSpaceHierarchy(ctx)
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(spaces, func(space compat.Space) bool { return space.ID == string(spaceID) })
+	if idx < 0 {
+		return errs.NotFound("Space not found")
+	}
+	roomStates, err := s.loadRoomAccountDataStates(ctx)
+	if err != nil {
+		return err
+	}
+
+	output := compat.SpaceChatsOutput{Space: spaces[idx], Items: []compat.Chat{}, UnjoinedChatIDs: []string{}}
+	seen := map[id.RoomID]struct{}{spaceID: {}}
+	queue := []id.RoomID{spaceID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, child := range childrenBySpace[current] {
+			if _, ok := seen[child.RoomID]; ok {
+				continue
+			}
+			seen[child.RoomID] = struct{}{}
+			if _, isSpace := childrenBySpace[child.RoomID]; isSpace {
+				if recursive {
+					queue = append(queue, child.RoomID)
+				}
+				continue
+			}
+			room, getErr := s.rt.Client().DB.Room.Get(ctx, child.RoomID)
+			if getErr != nil {
+				return errs.Internal(fmt.Errorf("failed to read room metadata: %w", getErr))
+			}
+			accountID, _ := inferAccountForRoom(child.RoomID, lookup)
+			if !visibility.allowsChat(string(child.RoomID), accountID) {
+				continue
+			}
+			if room == nil || isSpaceRoom(room) {
+				if room == nil {
+					output.UnjoinedChatIDs = append(output.UnjoinedChatIDs, string(child.RoomID))
+				}
+				continue
+			}
+			chat, mapErr := s.mapRoomToChat(ctx, room, lookup, chatPreviewParticipants, true, roomStates[room.ID])
+			if mapErr != nil {
+				continue
+			}
+			output.Items = append(output.Items, chat)
+		}
+	}
+	if err = s.attachChatMetadata(r, output.Items); err != nil {
+		return err
+	}
+	return writeJSON(w, output)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+)
+
+func spaceChildEvent(roomID string, content map[string]any, ts int64) *database.Event {
+	raw, _ := json.Marshal(content)
+	stateKey := roomID
+	return &database.Event{
+		Type:      event.StateSpaceChild.Type,
+		StateKey:  &stateKey,
+		Content:   raw,
+		Timestamp: jsontime.UMInt(ts),
+	}
+}
+
+func TestSpaceChildrenFromStateOrdersAndSkipsRemoved(t *testing.T) {
+	children := spaceChildrenFromState([]*database.Event{
+		spaceChildEvent("!late:example.org", map[string]any{"via": []string{"example.org"}}, 200),
+		spaceChildEvent("!removed:example.org", map[string]any{}, 50),
+		spaceChildEvent("!early:example.org", map[string]any{"via": []string{"example.org"}}, 100),
+		spaceChildEvent("!ordered:example.org", map[string]any{"via": []string{"example.org"}, "order": "a"}, 300),
+	})
+	want := []string{"!ordered:example.org", "!early:example.org", "!late:example.org"}
+	if len(children) != len(want) {
+		t.Fatalf("expected %d children, got %#v", len(want), children)
+	}
+	for idx, roomID := range want {
+		if string(children[idx].RoomID) != roomID {
+			t.Fatalf("expected %s at %d, got %s", roomID, idx, children[idx].RoomID)
+		}
+	}
+}
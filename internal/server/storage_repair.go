@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+// staleTempFileAge is how old a .tmp file must be before repair treats it as
+// left behind by a crash rather than a write still in progress.
+const staleTempFileAge = time.Hour
+
+// repairStorage brings the upload and asset stores back in line with their
+// metadata. Broken state otherwise only shows up later as 404s for uploads or
+// cached media. verifyBlobs also rehashes every cached blob, which reads the
+// whole cache.
+func (s *Server) repairStorage(verifyBlobs bool) compat.StorageRepairOutput {
+	var output compat.StorageRepairOutput
+	now := time.Now()
+	s.repairUploads(now, &output)
+	output.RemovedUploadSessions = s.pruneUploadSessions(now)
+
+	s.assetPruneMu.Lock()
+	defer s.assetPruneMu.Unlock()
+	_ = filepath.WalkDir(s.assetCacheDir(), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		if info, infoErr := entry.Info(); infoErr == nil && now.Sub(info.ModTime()) > staleTempFileAge {
+			if os.Remove(path) == nil {
+				output.RemovedTempFiles++
+				output.ReclaimedBytes += info.Size()
+			}
+		}
+		return nil
+	})
+	if verifyBlobs {
+		s.verifyAssetBlobs(&output)
+	}
+	output.RemovedIndexEntries = s.pruneAssetIndex()
+	return output
+}
+
+// repairUploads checks every staged upload. Metadata pointing at a missing
+// file is re-pointed at the file in its own directory when that still
+// exists, which happens after the state dir moves; anything else unreadable
+// is removed.
+func (s *Server) repairUploads(now time.Time, output *compat.StorageRepairOutput) {
+	root := s.uploadRootDir()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		output.CheckedUploads++
+		uploadDir := filepath.Join(root, entry.Name())
+		switch s.checkUploadDir(uploadDir, now) {
+		case uploadDirRepaired:
+			output.RepairedUploads++
+		case uploadDirBroken:
+			size := dirSize(uploadDir)
+			if os.RemoveAll(uploadDir) == nil {
+				output.RemovedUploads++
+				output.ReclaimedBytes += size
+			}
+		}
+	}
+}
+
+type uploadDirState int
+
+const (
+	uploadDirOK uploadDirState = iota
+	uploadDirRepaired
+	uploadDirBroken
+)
+
+func (s *Server) checkUploadDir(uploadDir string, now time.Time) uploadDirState {
+	metaPath := filepath.Join(uploadDir, "metadata.json")
+	data, err := os.ReadFile(metaPath)
+	if os.IsNotExist(err) {
+		// The upload handler writes metadata last, so a young directory may
+		// still be in flight.
+		if info, statErr := os.Stat(uploadDir); statErr == nil && now.Sub(info.ModTime()) < staleTempFileAge {
+			return uploadDirOK
+		}
+		return uploadDirBroken
+	} else if err != nil {
+		return uploadDirBroken
+	}
+	var meta uploadMetadata
+	if err = json.Unmarshal(data, &meta); err != nil || meta.UploadID != filepath.Base(uploadDir) || meta.FileName == "" {
+		return uploadDirBroken
+	}
+	if _, err = os.Stat(meta.FilePath); err == nil {
+		return uploadDirOK
+	}
+	localPath := filepath.Join(uploadDir, filepath.Base(meta.FileName))
+	if _, err = os.Stat(localPath); err != nil {
+		return uploadDirBroken
+	}
+	meta.FilePath = localPath
+	if err = s.writeUploadMetadata(meta); err != nil {
+		return uploadDirBroken
+	}
+	return uploadDirRepaired
+}
+
+// verifyAssetBlobs removes blobs whose content no longer matches the digest
+// they are stored under, so the next request downloads them again.
+func (s *Server) verifyAssetBlobs(output *compat.StorageRepairOutput) {
+	entries, err := os.ReadDir(s.assetBlobDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !contentHashPattern.MatchString(entry.Name()) {
+			continue
+		}
+		output.CheckedBlobs++
+		blobPath := filepath.Join(s.assetBlobDir(), entry.Name())
+		if digest, hashErr := hashFile(blobPath); hashErr != nil || digest == entry.Name() {
+			continue
+		}
+		info, infoErr := entry.Info()
+		if os.Remove(blobPath) == nil {
+			output.RemovedBlobs++
+			if infoErr == nil {
+				output.ReclaimedBytes += info.Size()
+			}
+		}
+	}
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, infoErr := entry.Info(); infoErr == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func (s *Server) repairStorageOnStartup() {
+	output := s.repairStorage(false)
+	if output.RepairedUploads+output.RemovedUploads+output.RemovedUploadSessions+output.RemovedTempFiles+output.RemovedIndexEntries > 0 {
+		log.Printf("storage repair: %d uploads repaired, %d uploads, %d upload sessions, %d temp files and %d index entries removed (%d bytes)",
+			output.RepairedUploads, output.RemovedUploads, output.RemovedUploadSessions, output.RemovedTempFiles, output.RemovedIndexEntries, output.ReclaimedBytes)
+	}
+}
+
+func (s *Server) runStorageRepair(w http.ResponseWriter, r *http.Request) error {
+	if s.requestPolicy(r) != nil {
+		return errs.Forbidden("Storage repair is only available to the deployment owner")
+	}
+	verifyBlobs, err := parseOptionalBool(r.URL.Query().Get("verifyBlobs"), false, "verifyBlobs")
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, s.repairStorage(verifyBlobs))
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/config"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func TestRepairStorageFixesMovedUploadsAndDropsBrokenOnes(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), MatrixHomeserverURL: "https://matrix.beeper.com"}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	s := New(cfg, rt)
+
+	movedDir := filepath.Join(s.uploadRootDir(), "moved")
+	if err = os.MkdirAll(movedDir, 0o700); err != nil {
+		t.Fatalf("failed to create upload dir: %v", err)
+	}
+	if err = os.WriteFile(filepath.Join(movedDir, "a.txt"), []byte("hi"), 0o600); err != nil {
+		t.Fatalf("failed to write upload: %v", err)
+	}
+	// The metadata still points at the state dir the upload was made in.
+	if err = os.WriteFile(filepath.Join(movedDir, "metadata.json"), []byte(`{"uploadID":"moved","fileName":"a.txt","filePath":"/old/state/api-uploads/moved/a.txt"}`), 0o600); err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+
+	brokenDir := filepath.Join(s.uploadRootDir(), "broken")
+	if err = os.MkdirAll(brokenDir, 0o700); err != nil {
+		t.Fatalf("failed to create upload dir: %v", err)
+	}
+	if err = os.WriteFile(filepath.Join(brokenDir, "metadata.json"), []byte("{"), 0o600); err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+
+	tempPath := filepath.Join(s.assetCacheDir(), "stale.tmp")
+	if err = os.MkdirAll(s.assetCacheDir(), 0o700); err != nil {
+		t.Fatalf("failed to create asset dir: %v", err)
+	}
+	if err = os.WriteFile(tempPath, []byte("partial"), 0o600); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	old := time.Now().Add(-2 * staleTempFileAge)
+	_ = os.Chtimes(tempPath, old, old)
+
+	output := s.repairStorage(false)
+	if output.CheckedUploads != 2 || output.RepairedUploads != 1 || output.RemovedUploads != 1 || output.RemovedTempFiles != 1 {
+		t.Fatalf("unexpected repair summary %+v", output)
+	}
+	if repaired, loadErr := s.loadUploadMetadataByID("moved"); loadErr != nil || repaired.FilePath != filepath.Join(movedDir, "a.txt") {
+		t.Fatalf("expected upload to be re-pointed, got %+v (err=%v)", repaired, loadErr)
+	}
+	if _, statErr := os.Stat(brokenDir); !os.IsNotExist(statErr) {
+		t.Fatalf("expected broken upload to be removed")
+	}
+}
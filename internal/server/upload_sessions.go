@@ -0,0 +1,293 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	// uploadChunkMaxBytes bounds a single PATCH so one request never has to
+	// carry a whole video.
+	uploadChunkMaxBytes = int64(64 * 1024 * 1024)
+	uploadSessionTTL    = 24 * time.Hour
+	uploadOffsetHeader  = "Upload-Offset"
+)
+
+// uploadSessionState is stored next to the partial file so sessions can be
+// resumed after a restart. The offset is the size of the partial file.
+type uploadSessionState struct {
+	SessionID string    `json:"sessionID"`
+	FileName  string    `json:"fileName"`
+	MimeType  string    `json:"mimeType"`
+	FileSize  int64     `json:"fileSize"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// uploadSessionLocks keeps two requests from writing the same session at
+// once, which would corrupt the offset bookkeeping. The zero value is ready
+// to use.
+type uploadSessionLocks struct {
+	mu     sync.Mutex
+	active map[string]struct{}
+}
+
+func (l *uploadSessionLocks) acquire(sessionID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.active[sessionID]; ok {
+		return false
+	}
+	if l.active == nil {
+		l.active = make(map[string]struct{})
+	}
+	l.active[sessionID] = struct{}{}
+	return true
+}
+
+func (l *uploadSessionLocks) release(sessionID string) {
+	l.mu.Lock()
+	delete(l.active, sessionID)
+	l.mu.Unlock()
+}
+
+func (s *Server) uploadSessionRootDir() string {
+	return filepath.Join(s.rt.StateDir(), "api-upload-sessions")
+}
+
+func (s *Server) uploadSessionDir(sessionID string) string {
+	return filepath.Join(s.uploadSessionRootDir(), sessionID)
+}
+
+func (s *Server) createUploadSession(w http.ResponseWriter, r *http.Request) error {
+	var input compat.CreateUploadSessionInput
+	if err := decodeJSON(r, &input); err != nil {
+		return err
+	}
+	if input.FileSize <= 0 || input.FileSize > maxUploadSizeBytes {
+		return errs.Validation(map[string]any{"fileSize": fmt.Sprintf("must be between 1 and %d", maxUploadSizeBytes)})
+	}
+	s.pruneUploadSessions(time.Now())
+
+	fileName, mimeType := normalizeUploadName(strings.TrimSpace(input.FileName), strings.TrimSpace(input.MimeType))
+	state := uploadSessionState{
+		SessionID: randomID(),
+		FileName:  fileName,
+		MimeType:  mimeType,
+		FileSize:  input.FileSize,
+		CreatedAt: time.Now().UTC(),
+	}
+	dir := s.uploadSessionDir(state.SessionID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errs.Internal(fmt.Errorf("failed to create upload session dir: %w", err))
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "session.json"), data, 0o600)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "data.part"), nil, 0o600)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return errs.Internal(fmt.Errorf("failed to write upload session: %w", err))
+	}
+	w.Header().Set(uploadOffsetHeader, "0")
+	w.WriteHeader(http.StatusCreated)
+	return writeJSON(w, uploadSessionOutput(state, 0))
+}
+
+func (s *Server) getUploadSession(w http.ResponseWriter, r *http.Request) error {
+	state, offset, err := s.loadUploadSession(r.PathValue("sessionID"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	return writeJSON(w, uploadSessionOutput(state, offset))
+}
+
+// appendUploadChunk writes the request body at the offset the client names in
+// the Upload-Offset header, which must match what the server already has.
+// Bytes received before a dropped connection are kept, so the client asks for
+// the offset again and continues from there.
+func (s *Server) appendUploadChunk(w http.ResponseWriter, r *http.Request) error {
+	sessionID := r.PathValue("sessionID")
+	if !s.uploadSessions.acquire(sessionID) {
+		return errs.New(http.StatusConflict, "UPLOAD_SESSION_BUSY", "Another chunk is being written to this upload session", nil)
+	}
+	defer s.uploadSessions.release(sessionID)
+
+	state, offset, err := s.loadUploadSession(sessionID)
+	if err != nil {
+		return err
+	}
+	clientOffset, parseErr := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if parseErr != nil || clientOffset < 0 {
+		return errs.Validation(map[string]any{uploadOffsetHeader: "must be a non-negative integer"})
+	}
+	if clientOffset != offset {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+		return errs.New(http.StatusConflict, "UPLOAD_OFFSET_MISMATCH", "Upload-Offset does not match the stored offset", map[string]any{"offset": offset})
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.uploadSessionDir(sessionID), "data.part"), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to open upload session: %w", err))
+	}
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, state.FileSize-offset+1))
+	closeErr := file.Close()
+	offset += written
+	if offset > state.FileSize {
+		_ = os.Truncate(filepath.Join(s.uploadSessionDir(sessionID), "data.part"), state.FileSize)
+		return errs.Validation(map[string]any{"body": "chunk extends past the declared fileSize"})
+	}
+	if copyErr != nil {
+		if tooLarge := payloadTooLargeError(copyErr); tooLarge != nil {
+			return tooLarge
+		}
+		return errs.Validation(map[string]any{"body": fmt.Sprintf("failed to read chunk after %d bytes: %v", written, copyErr)})
+	}
+	if closeErr != nil {
+		return errs.Internal(fmt.Errorf("failed to write upload chunk: %w", closeErr))
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	return writeJSON(w, uploadSessionOutput(state, offset))
+}
+
+// finalizeUploadSession turns a complete session into a regular upload, with
+// the same uploadID metadata the single-request upload endpoints produce.
+func (s *Server) finalizeUploadSession(w http.ResponseWriter, r *http.Request) error {
+	sessionID := r.PathValue("sessionID")
+	if !s.uploadSessions.acquire(sessionID) {
+		return errs.New(http.StatusConflict, "UPLOAD_SESSION_BUSY", "Another chunk is being written to this upload session", nil)
+	}
+	defer s.uploadSessions.release(sessionID)
+
+	state, offset, err := s.loadUploadSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if offset != state.FileSize {
+		return errs.New(http.StatusConflict, "UPLOAD_INCOMPLETE", "Upload session is missing data", map[string]any{"offset": offset, "fileSize": state.FileSize})
+	}
+
+	uploadID := randomID()
+	uploadDir := filepath.Join(s.uploadRootDir(), uploadID)
+	if err = os.MkdirAll(uploadDir, 0o700); err != nil {
+		return errs.Internal(fmt.Errorf("failed to create upload dir: %w", err))
+	}
+	filePath := filepath.Join(uploadDir, state.FileName)
+	if err = os.Rename(filepath.Join(s.uploadSessionDir(sessionID), "data.part"), filePath); err != nil {
+		_ = os.RemoveAll(uploadDir)
+		return errs.Internal(fmt.Errorf("failed to move upload: %w", err))
+	}
+	_ = os.RemoveAll(s.uploadSessionDir(sessionID))
+
+	hash, err := hashFile(filePath)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to hash upload: %w", err))
+	}
+	header, err := readFileHeader(filePath, animationSniffBytes)
+	if err != nil {
+		return errs.Internal(fmt.Errorf("failed to read upload: %w", err))
+	}
+	meta := uploadMetadata{
+		ContentHash: hash,
+		UploadID:    uploadID,
+		FilePath:    filePath,
+		FileName:    state.FileName,
+		MimeType:    state.MimeType,
+		FileSize:    state.FileSize,
+	}
+	s.describeUpload(r.Context(), &meta, header)
+	if err = s.writeUploadMetadata(meta); err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, uploadAssetOutput(meta))
+}
+
+func (s *Server) deleteUploadSession(w http.ResponseWriter, r *http.Request) error {
+	sessionID := r.PathValue("sessionID")
+	if !s.uploadSessions.acquire(sessionID) {
+		return errs.New(http.StatusConflict, "UPLOAD_SESSION_BUSY", "Another chunk is being written to this upload session", nil)
+	}
+	defer s.uploadSessions.release(sessionID)
+	if _, _, err := s.loadUploadSession(sessionID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.uploadSessionDir(sessionID)); err != nil {
+		return errs.Internal(fmt.Errorf("failed to delete upload session: %w", err))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) loadUploadSession(sessionID string) (uploadSessionState, int64, error) {
+	if !safeUploadIDPattern.MatchString(sessionID) {
+		return uploadSessionState{}, 0, errs.Validation(map[string]any{"sessionID": "invalid sessionID"})
+	}
+	dir := s.uploadSessionDir(sessionID)
+	data, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if err != nil {
+		return uploadSessionState{}, 0, errs.NotFound("Upload session not found")
+	}
+	var state uploadSessionState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return uploadSessionState{}, 0, errs.Internal(fmt.Errorf("failed to parse upload session: %w", err))
+	}
+	if time.Since(state.CreatedAt) > uploadSessionTTL {
+		_ = os.RemoveAll(dir)
+		return uploadSessionState{}, 0, errs.NotFound("Upload session has expired")
+	}
+	info, err := os.Stat(filepath.Join(dir, "data.part"))
+	if err != nil {
+		return uploadSessionState{}, 0, errs.NotFound("Upload session not found")
+	}
+	return state, info.Size(), nil
+}
+
+// pruneUploadSessions removes sessions abandoned past their TTL. It runs when
+// a new session is created and during storage repair rather than on a timer,
+// since sessions are rare.
+// It returns how many sessions were removed.
+func (s *Server) pruneUploadSessions(now time.Time) int {
+	entries, err := os.ReadDir(s.uploadSessionRootDir())
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		if infoErr != nil || now.Sub(info.ModTime()) <= uploadSessionTTL {
+			continue
+		}
+		if s.uploadSessions.acquire(entry.Name()) {
+			if os.RemoveAll(filepath.Join(s.uploadSessionRootDir(), entry.Name())) == nil {
+				removed++
+			}
+			s.uploadSessions.release(entry.Name())
+		}
+	}
+	return removed
+}
+
+func uploadSessionOutput(state uploadSessionState, offset int64) compat.UploadSession {
+	return compat.UploadSession{
+		SessionID: state.SessionID,
+		FileName:  state.FileName,
+		MimeType:  state.MimeType,
+		FileSize:  state.FileSize,
+		Offset:    offset,
+		ExpiresAt: state.CreatedAt.Add(uploadSessionTTL),
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	"github.com/batuhan/easymatrix/internal/config"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func sendUploadChunk(s *Server, sessionID, offset, body string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(http.MethodPatch, "/v1/assets/uploads/"+sessionID, strings.NewReader(body))
+	req.SetPathValue("sessionID", sessionID)
+	req.Header.Set(uploadOffsetHeader, offset)
+	rec := httptest.NewRecorder()
+	return rec, s.appendUploadChunk(rec, req)
+}
+
+func TestUploadSessionResumesAtStoredOffset(t *testing.T) {
+	cfg := config.Config{StateDir: t.TempDir(), MatrixHomeserverURL: "https://matrix.beeper.com"}
+	rt, err := gomuksruntime.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	s := New(cfg, rt)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/assets/uploads", strings.NewReader(`{"fileName":"clip.txt","fileSize":11}`))
+	rec := httptest.NewRecorder()
+	if err = s.createUploadSession(rec, req); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	var session compat.UploadSession
+	if err = json.Unmarshal(rec.Body.Bytes(), &session); err != nil || session.SessionID == "" || session.MimeType != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected session %s (err=%v)", rec.Body.String(), err)
+	}
+
+	if _, err = sendUploadChunk(s, session.SessionID, "0", "hello"); err != nil {
+		t.Fatalf("failed to send first chunk: %v", err)
+	}
+	_, err = sendUploadChunk(s, session.SessionID, "0", "hello")
+	var apiErr *errs.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		t.Fatalf("expected offset mismatch, got %v", err)
+	}
+	rec, err = sendUploadChunk(s, session.SessionID, "5", " world")
+	if err != nil || rec.Header().Get(uploadOffsetHeader) != "11" {
+		t.Fatalf("unexpected second chunk result %q (err=%v)", rec.Header().Get(uploadOffsetHeader), err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/assets/uploads/"+session.SessionID+"/finalize", nil)
+	req.SetPathValue("sessionID", session.SessionID)
+	rec = httptest.NewRecorder()
+	if err = s.finalizeUploadSession(rec, req); err != nil {
+		t.Fatalf("failed to finalize: %v", err)
+	}
+	var output compat.UploadAssetOutput
+	if err = json.Unmarshal(rec.Body.Bytes(), &output); err != nil || output.UploadID == "" {
+		t.Fatalf("unexpected finalize output %s (err=%v)", rec.Body.String(), err)
+	}
+	meta, err := s.loadUploadMetadataByID(output.UploadID)
+	if err != nil {
+		t.Fatalf("failed to load upload: %v", err)
+	}
+	if data, _ := os.ReadFile(meta.FilePath); string(data) != "hello world" || meta.FileSize != 11 {
+		t.Fatalf("unexpected upload %q (%d bytes)", data, meta.FileSize)
+	}
+	if _, _, err = s.loadUploadSession(session.SessionID); err == nil {
+		t.Fatalf("expected session to be removed after finalize")
+	}
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"context"
+	"net/url"
 	"strings"
 
 	"maunium.net/go/mautrix/event"
 
+	"github.com/batuhan/easymatrix/internal/auth"
 	"github.com/batuhan/easymatrix/internal/compat"
 )
 
@@ -19,7 +22,7 @@ type userShape struct {
 	IsSelf        bool
 }
 
-func newCompatUser(shape userShape) compat.User {
+func newCompatUser(ctx context.Context, shape userShape) compat.User {
 	userID := strings.TrimSpace(shape.ID)
 	fullName := strings.TrimSpace(shape.FullName)
 	if fullName == "" {
@@ -31,14 +34,26 @@ func newCompatUser(shape userShape) compat.User {
 		PhoneNumber:   strings.TrimSpace(shape.PhoneNumber),
 		Email:         strings.TrimSpace(shape.Email),
 		FullName:      fullName,
-		ImgURL:        strings.TrimSpace(shape.ImgURL),
+		ImgURL:        avatarServeURL(ctx, shape.ImgURL),
 		CannotMessage: shape.CannotMessage,
 		IsSelf:        shape.IsSelf,
 	}
 }
 
-func userFromLocalBridgeProfile(remoteID string, profileData map[string]any) compat.User {
-	return newCompatUser(userShape{
+// avatarServeURL points Matrix avatars at /v1/assets/serve of the session
+// serving the request, which fetches them through the asset cache; API
+// clients cannot load mxc:// URIs themselves. Other URLs are returned
+// unchanged.
+func avatarServeURL(ctx context.Context, raw string) string {
+	raw = strings.TrimSpace(raw)
+	if _, _, err := parseAssetMXC(raw); err != nil {
+		return raw
+	}
+	return auth.ContextBasePath(ctx) + "/v1/assets/serve?url=" + url.QueryEscape(raw)
+}
+
+func userFromLocalBridgeProfile(ctx context.Context, remoteID string, profileData map[string]any) compat.User {
+	return newCompatUser(ctx, userShape{
 		ID:            remoteID,
 		Username:      firstString(profileData, "username", "handle"),
 		PhoneNumber:   firstString(profileData, "phone", "phone_number"),
@@ -50,8 +65,8 @@ func userFromLocalBridgeProfile(remoteID string, profileData map[string]any) com
 	})
 }
 
-func userFromMemberEvent(userID string, member event.MemberEventContent, selfUserID string) compat.User {
-	return newCompatUser(userShape{
+func userFromMemberEvent(ctx context.Context, userID string, member event.MemberEventContent, selfUserID string) compat.User {
+	return newCompatUser(ctx, userShape{
 		ID:            userID,
 		FullName:      member.Displayname,
 		ImgURL:        string(member.AvatarURL),
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+
+	"github.com/batuhan/easymatrix/internal/auth"
+)
+
+func TestUserFromMemberEventProxiesMatrixAvatar(t *testing.T) {
+	user := userFromMemberEvent(context.Background(), "@alice:example.org", event.MemberEventContent{AvatarURL: "mxc://example.org/abc"}, "@me:example.org")
+	if want := "/v1/assets/serve?url=mxc%3A%2F%2Fexample.org%2Fabc"; user.ImgURL != want {
+		t.Fatalf("ImgURL = %q, want %q", user.ImgURL, want)
+	}
+}
+
+func TestAvatarServeURLKeepsOtherURLs(t *testing.T) {
+	for _, raw := range []string{"", "https://cdn.example.org/a.png", "file:///tmp/a.png"} {
+		if got := avatarServeURL(context.Background(), raw); got != raw {
+			t.Fatalf("avatarServeURL(%q) = %q, want it unchanged", raw, got)
+		}
+	}
+}
+
+func TestAvatarServeURLKeepsSessionBasePath(t *testing.T) {
+	ctx := auth.ContextWithBasePath(context.Background(), "/sessions/work")
+	if got, want := avatarServeURL(ctx, "mxc://example.org/abc"), "/sessions/work/v1/assets/serve?url=mxc%3A%2F%2Fexample.org%2Fabc"; got != want {
+		t.Fatalf("avatarServeURL = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+	"github.com/batuhan/easymatrix/internal/gomuksruntime"
+)
+
+func mapVerification(v gomuksruntime.Verification) compat.Verification {
+	output := compat.Verification{
+		TransactionID: v.TransactionID,
+		UserID:        string(v.UserID),
+		DeviceID:      string(v.DeviceID),
+		Incoming:      v.Incoming,
+		State:         v.State,
+		Decimals:      v.Decimals,
+		CancelCode:    v.CancelCode,
+		CancelReason:  v.CancelReason,
+		Error:         v.Error,
+		UpdatedAt:     v.UpdatedAt,
+	}
+	for _, emoji := range v.Emojis {
+		output.Emojis = append(output.Emojis, compat.SASEmoji{Emoji: emoji.Emoji, Description: emoji.Description})
+	}
+	return output
+}
+
+// verificationError keeps the helper's reason, which is usually that the
+// verification is not in the state the action needs.
+func verificationError(err error) error {
+	if errors.Is(err, gomuksruntime.ErrUnknownVerification) {
+		return errs.NotFound("Verification not found")
+	}
+	return errs.New(http.StatusConflict, "VERIFICATION_FAILED", err.Error(), nil)
+}
+
+func (s *Server) manageListVerifications(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireLoggedInSession(); err != nil {
+		return err
+	}
+	verifications, err := s.rt.Verifications(r.Context())
+	if err != nil {
+		return errs.Internal(err)
+	}
+	output := compat.ListVerificationsOutput{Items: make([]compat.Verification, 0, len(verifications))}
+	for _, v := range verifications {
+		output.Items = append(output.Items, mapVerification(v))
+	}
+	return writeJSON(w, output)
+}
+
+// manageStartVerification requests verification from another user's devices,
+// or from this account's other devices when userID is omitted. The latter
+// verifies this session without a recovery key.
+func (s *Server) manageStartVerification(w http.ResponseWriter, r *http.Request) error {
+	if err := s.requireLoggedInSession(); err != nil {
+		return err
+	}
+	var req struct {
+		UserID string `json:"userID"`
+	}
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	userID := id.UserID(strings.TrimSpace(req.UserID))
+	if userID != "" {
+		if _, _, err := userID.Parse(); err != nil {
+			return errs.Validation(map[string]any{"userID": "must be a valid Matrix user ID"})
+		}
+	}
+	v, err := s.rt.StartVerification(r.Context(), userID)
+	if err != nil {
+		return errs.Internal(err)
+	}
+	return writeJSON(w, mapVerification(v))
+}
+
+func (s *Server) manageVerificationAction(action func(*gomuksruntime.Runtime, context.Context, string) (gomuksruntime.Verification, error)) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if err := s.requireLoggedInSession(); err != nil {
+			return err
+		}
+		v, err := action(s.rt, r.Context(), r.PathValue("transactionID"))
+		if err != nil {
+			return verificationError(err)
+		}
+		return writeJSON(w, mapVerification(v))
+	}
+}
+
+func (s *Server) publishVerification(v gomuksruntime.Verification) {
+	s.ws.dispatch(wsDomainEvent{Type: wsDomainTypeVerificationUpdated, IDs: []string{v.TransactionID}})
+}
+
+// ownerTargets lists clients that are not confined by a subject policy.
+// Verification concerns the whole session, so confined callers never see it.
+func (h *wsHub) ownerTargets() []*wsClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	output := make([]*wsClient, 0, len(h.clients))
+	for _, client := range h.clients {
+		if client == nil || client.state == nil || client.policy != nil {
+			continue
+		}
+		output = append(output, client)
+	}
+	return output
+}
+
+func (s *Server) hydrateVerificationsForWSEvent(transactionIDs []string) ([]compatRecord, error) {
+	output := make([]compatRecord, 0, len(transactionIDs))
+	for _, transactionID := range transactionIDs {
+		v, ok, err := s.rt.Verification(context.Background(), transactionID)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		record, err := toCompatRecord(mapVerification(v))
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, record)
+	}
+	return output, nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/batuhan/easymatrix/internal/compat"
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+const (
+	workPoolSearch = "search"
+	workPoolUpload = "upload"
+
+	defaultSearchConcurrency = 4
+	defaultUploadConcurrency = 2
+	// Requests beyond capacity wait their turn, but only up to this many per
+	// slot; past that the pool sheds load instead of piling up goroutines.
+	workPoolQueuePerSlot = 4
+)
+
+// routeWorkPools assigns heavy routes to a bounded pool. Full-table scans and
+// large uploads hold SQLite and disk for a long time, so capping them keeps
+// connections free for sends and chat reads. Keys are the exact patterns
+// passed to handle.
+var routeWorkPools = map[string]string{
+	"GET /v1/chats/search":                         workPoolSearch,
+	"GET /v1/chats/find":                           workPoolSearch,
+	"GET /v1/messages/search":                      workPoolSearch,
+	"GET /v1/search":                               workPoolSearch,
+	"GET /v1/chats/{chatID}/media":                 workPoolSearch,
+	"GET /v1/followups":                            workPoolSearch,
+	"GET /v1/contacts/search":                      workPoolSearch,
+	"POST /v1/assets/upload":                       workPoolUpload,
+	"POST /v1/assets/upload/base64":                workPoolUpload,
+	"PATCH /v1/assets/uploads/{sessionID}":         workPoolUpload,
+	"POST /v1/assets/uploads/{sessionID}/finalize": workPoolUpload,
+}
+
+type workPool struct {
+	slots    chan struct{}
+	maxQueue int64
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+func newWorkPool(capacity int) *workPool {
+	return &workPool{
+		slots:    make(chan struct{}, capacity),
+		maxQueue: int64(capacity * workPoolQueuePerSlot),
+	}
+}
+
+func newWorkPools(searchConcurrency, uploadConcurrency int) map[string]*workPool {
+	if searchConcurrency <= 0 {
+		searchConcurrency = defaultSearchConcurrency
+	}
+	if uploadConcurrency <= 0 {
+		uploadConcurrency = defaultUploadConcurrency
+	}
+	return map[string]*workPool{
+		workPoolSearch: newWorkPool(searchConcurrency),
+		workPoolUpload: newWorkPool(uploadConcurrency),
+	}
+}
+
+// acquire blocks until a slot is free, the request is cancelled, or the
+// queue is already full. The returned release func must be called once the
+// handler is done.
+func (p *workPool) acquire(r *http.Request) (func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	default:
+	}
+	if p.queued.Add(1) > p.maxQueue {
+		p.queued.Add(-1)
+		p.rejected.Add(1)
+		return nil, errs.New(http.StatusServiceUnavailable, "SERVER_BUSY", "Too many concurrent requests of this kind, retry later", nil)
+	}
+	defer p.queued.Add(-1)
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+}
+
+func (p *workPool) release() {
+	<-p.slots
+}
+
+func (p *workPool) stats() compat.WorkPoolStats {
+	return compat.WorkPoolStats{
+		Capacity: cap(p.slots),
+		Active:   len(p.slots),
+		Queued:   p.queued.Load(),
+		Rejected: p.rejected.Load(),
+	}
+}
+
+func (s *Server) workPoolStats() map[string]compat.WorkPoolStats {
+	stats := make(map[string]compat.WorkPoolStats, len(s.workPools))
+	for name, pool := range s.workPools {
+		stats[name] = pool.stats()
+	}
+	return stats
+}
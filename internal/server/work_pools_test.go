@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	errs "github.com/batuhan/easymatrix/internal/errors"
+)
+
+func TestWorkPoolRejectsWhenQueueIsFull(t *testing.T) {
+	pool := &workPool{slots: make(chan struct{}, 1)}
+	req := httptest.NewRequest("GET", "/v1/search", nil)
+	release, err := pool.acquire(req)
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+	defer release()
+
+	_, err = pool.acquire(req)
+	var apiErr *errs.APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when queue is full, got %v", err)
+	}
+	if stats := pool.stats(); stats.Active != 1 || stats.Rejected != 1 || stats.Queued != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestWorkPoolQueuedRequestRunsAfterRelease(t *testing.T) {
+	pool := newWorkPool(1)
+	req := httptest.NewRequest("GET", "/v1/search", nil)
+	release, err := pool.acquire(req)
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		next, err := pool.acquire(req)
+		if err != nil {
+			t.Errorf("queued acquire failed: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("queued request ran while the pool was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	select {
+	case next := <-acquired:
+		if next != nil {
+			next()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued request did not run after release")
+	}
+}
+
+func TestRouteWorkPoolsUseKnownPools(t *testing.T) {
+	pools := newWorkPools(0, 0)
+	for pattern, name := range routeWorkPools {
+		if pools[name] == nil {
+			t.Fatalf("route %q references unknown pool %q", pattern, name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// messageUpsertBatcher collects message.upserted IDs per chat and releases
+// them as one event after a fixed window. Reactions in a busy room otherwise
+// trigger a hydration and fan-out per reaction for the same target message.
+type messageUpsertBatcher struct {
+	window time.Duration
+	flush  func(wsDomainEvent)
+
+	// flushMu is held while a batch is delivered, so an immediate event for
+	// the same chat waits for it instead of overtaking it.
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]*pendingUpserts
+	stopped bool
+}
+
+type pendingUpserts struct {
+	ids   map[string]struct{}
+	timer *time.Timer
+}
+
+func newMessageUpsertBatcher(window time.Duration, flush func(wsDomainEvent)) *messageUpsertBatcher {
+	return &messageUpsertBatcher{
+		window:  window,
+		flush:   flush,
+		pending: make(map[string]*pendingUpserts),
+	}
+}
+
+func (b *messageUpsertBatcher) add(domainEvent wsDomainEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return
+	}
+	batch, ok := b.pending[domainEvent.ChatID]
+	if !ok {
+		batch = &pendingUpserts{ids: make(map[string]struct{}, len(domainEvent.IDs))}
+		b.pending[domainEvent.ChatID] = batch
+		chatID := domainEvent.ChatID
+		batch.timer = time.AfterFunc(b.window, func() {
+			b.flushChat(chatID)
+		})
+	}
+	for _, messageID := range domainEvent.IDs {
+		batch.ids[messageID] = struct{}{}
+	}
+}
+
+func (b *messageUpsertBatcher) flushChat(chatID string) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	batch := b.pending[chatID]
+	delete(b.pending, chatID)
+	b.mu.Unlock()
+	if batch == nil || len(batch.ids) == 0 {
+		return
+	}
+	b.flush(wsDomainEvent{
+		Type:   wsDomainTypeMessageUpserted,
+		ChatID: chatID,
+		IDs:    mapKeysSorted(batch.ids),
+	})
+}
+
+// supersede removes pending IDs that an immediate event makes stale: a
+// delete or fresh upsert of the same message, or the whole chat going away.
+// A batch already being delivered finishes first, so a held upsert never
+// arrives after a later delete.
+func (b *messageUpsertBatcher) supersede(domainEvent wsDomainEvent) {
+	if domainEvent.ChatID == "" {
+		return
+	}
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch := b.pending[domainEvent.ChatID]
+	if batch == nil {
+		return
+	}
+	switch domainEvent.Type {
+	case wsDomainTypeChatDeleted:
+		clear(batch.ids)
+	case wsDomainTypeMessageDeleted, wsDomainTypeMessageUpserted:
+		for _, messageID := range domainEvent.IDs {
+			delete(batch.ids, messageID)
+		}
+	}
+	if len(batch.ids) == 0 {
+		batch.timer.Stop()
+		delete(b.pending, domainEvent.ChatID)
+	}
+}
+
+// stop cancels every pending flush; later adds are ignored.
+func (b *messageUpsertBatcher) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	for chatID, batch := range b.pending {
+		batch.timer.Stop()
+		delete(b.pending, chatID)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestMessageUpsertBatcherMergesWithinWindow(t *testing.T) {
+	flushed := make(chan wsDomainEvent, 4)
+	batcher := newMessageUpsertBatcher(20*time.Millisecond, func(domainEvent wsDomainEvent) {
+		flushed <- domainEvent
+	})
+	batcher.add(wsDomainEvent{Type: wsDomainTypeMessageUpserted, ChatID: "!a:example.org", IDs: []string{"$2"}})
+	batcher.add(wsDomainEvent{Type: wsDomainTypeMessageUpserted, ChatID: "!a:example.org", IDs: []string{"$1", "$2"}})
+
+	select {
+	case got := <-flushed:
+		if got.ChatID != "!a:example.org" || !slices.Equal(got.IDs, []string{"$1", "$2"}) {
+			t.Fatalf("unexpected flushed event %#v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("batch was not flushed")
+	}
+	select {
+	case extra := <-flushed:
+		t.Fatalf("expected a single flush, got extra %#v", extra)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMessageUpsertBatcherDropsIDsSupersededByDelete(t *testing.T) {
+	flushed := make(chan wsDomainEvent, 4)
+	batcher := newMessageUpsertBatcher(20*time.Millisecond, func(domainEvent wsDomainEvent) {
+		flushed <- domainEvent
+	})
+	batcher.add(wsDomainEvent{Type: wsDomainTypeMessageUpserted, ChatID: "!a:example.org", IDs: []string{"$1", "$2"}})
+	batcher.supersede(wsDomainEvent{Type: wsDomainTypeMessageDeleted, ChatID: "!a:example.org", IDs: []string{"$1"}})
+
+	select {
+	case got := <-flushed:
+		if !slices.Equal(got.IDs, []string{"$2"}) {
+			t.Fatalf("expected only the surviving message, got %#v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("batch was not flushed")
+	}
+
+	batcher.add(wsDomainEvent{Type: wsDomainTypeMessageUpserted, ChatID: "!a:example.org", IDs: []string{"$3"}})
+	batcher.supersede(wsDomainEvent{Type: wsDomainTypeChatDeleted, ChatID: "!a:example.org", IDs: []string{"!a:example.org"}})
+	select {
+	case extra := <-flushed:
+		t.Fatalf("expected deleted chat batch to be dropped, got %#v", extra)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMessageUpsertBatcherStopCancelsPendingFlushes(t *testing.T) {
+	flushed := make(chan wsDomainEvent, 4)
+	batcher := newMessageUpsertBatcher(20*time.Millisecond, func(domainEvent wsDomainEvent) {
+		flushed <- domainEvent
+	})
+	batcher.add(wsDomainEvent{Type: wsDomainTypeMessageUpserted, ChatID: "!a:example.org", IDs: []string{"$1"}})
+	batcher.stop()
+	batcher.add(wsDomainEvent{Type: wsDomainTypeMessageUpserted, ChatID: "!b:example.org", IDs: []string{"$2"}})
+
+	select {
+	case extra := <-flushed:
+		t.Fatalf("expected no flush after stop, got %#v", extra)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+func TestMapSyncCompleteMarksReactionOnlyUpsertsForCoalescing(t *testing.T) {
+	roomID := id.RoomID("!room:example.org")
+	reaction := func(eventID, target string) *database.Event {
+		return &database.Event{ID: id.EventID(eventID), Type: event.EventReaction.Type, RelatesTo: id.EventID(target)}
+	}
+	syncComplete := &jsoncmd.SyncComplete{Rooms: map[id.RoomID]*jsoncmd.SyncRoom{
+		roomID: {Events: []*database.Event{
+			reaction("$r1", "$old"),
+			reaction("$r2", "$new"),
+			{ID: "$new", Type: event.EventMessage.Type},
+		}},
+	}}
+
+	var immediate, coalesced []string
+	for _, domainEvent := range mapSyncCompleteToDomainEvents(syncComplete) {
+		if domainEvent.Type != wsDomainTypeMessageUpserted {
+			continue
+		}
+		if domainEvent.Coalesce {
+			coalesced = append(coalesced, domainEvent.IDs...)
+		} else {
+			immediate = append(immediate, domainEvent.IDs...)
+		}
+	}
+	if !slices.Equal(immediate, []string{"$new"}) || !slices.Equal(coalesced, []string{"$old"}) {
+		t.Fatalf("unexpected upserts: immediate=%v coalesced=%v", immediate, coalesced)
+	}
+}
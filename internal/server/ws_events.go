@@ -18,48 +18,56 @@ import (
 	"go.mau.fi/gomuks/pkg/hicli/jsoncmd"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
+
+	"github.com/batuhan/easymatrix/internal/compat"
 )
 
 const (
-	wsDuplicateEventDebounce     = 250 * time.Millisecond
-	wsFingerprintRetention       = 30 * time.Second
-	wsFingerprintPruneInterval   = 5 * time.Second
-	wsDefaultWriteTimeout        = 5 * time.Second
-	wsKeepaliveInterval          = 30 * time.Second
-	wsPingTimeout                = 5 * time.Second
-	wsReadLimitBytes             = int64(64 * 1024)
-	wsEventQueueSize             = 512
-	wsSubscriptionsCommandType   = "subscriptions.set"
-	wsSubscriptionsUpdatedType   = "subscriptions.updated"
-	wsReadyType                  = "ready"
-	wsDomainTypeChatUpserted     = "chat.upserted"
-	wsDomainTypeChatDeleted      = "chat.deleted"
-	wsDomainTypeMessageUpserted  = "message.upserted"
-	wsDomainTypeMessageDeleted   = "message.deleted"
-	wsErrorType                  = "error"
-	wsErrorCodeInvalidCommand    = "INVALID_COMMAND"
-	wsErrorCodeInvalidPayload    = "INVALID_PAYLOAD"
-	wsErrorCodeNotSubscribed     = "NOT_SUBSCRIBED"
-	wsErrorCodeInternal          = "INTERNAL_ERROR"
-	wsWildcardSubscriptionChatID = "*"
+	wsDuplicateEventDebounce        = 250 * time.Millisecond
+	wsFingerprintRetention          = 30 * time.Second
+	wsFingerprintPruneInterval      = 5 * time.Second
+	wsDefaultWriteTimeout           = 5 * time.Second
+	wsKeepaliveInterval             = 30 * time.Second
+	wsPingTimeout                   = 5 * time.Second
+	wsReadLimitBytes                = int64(64 * 1024)
+	wsEventQueueSize                = 512
+	wsSubscriptionsCommandType      = "subscriptions.set"
+	wsSubscriptionsUpdatedType      = "subscriptions.updated"
+	wsReadyType                     = "ready"
+	wsDomainTypeChatUpserted        = "chat.upserted"
+	wsDomainTypeChatDeleted         = "chat.deleted"
+	wsDomainTypeMessageUpserted     = "message.upserted"
+	wsDomainTypeMessageDeleted      = "message.deleted"
+	wsDomainTypeAccountUpdated      = "account.updated"
+	wsDomainTypeVerificationUpdated = "verification.updated"
+	wsErrorType                     = "error"
+	wsErrorCodeInvalidCommand       = "INVALID_COMMAND"
+	wsErrorCodeInvalidPayload       = "INVALID_PAYLOAD"
+	wsErrorCodeNotSubscribed        = "NOT_SUBSCRIBED"
+	wsErrorCodeInternal             = "INTERNAL_ERROR"
+	wsWildcardSubscriptionChatID    = "*"
 )
 
 type wsSetSubscriptionsInput struct {
-	Type      string   `json:"type"`
-	RequestID string   `json:"requestID,omitempty"`
-	ChatIDs   []string `json:"chatIDs"`
+	Type         string   `json:"type"`
+	RequestID    string   `json:"requestID,omitempty"`
+	ChatIDs      []string `json:"chatIDs"`
+	MessageTypes []string `json:"messageTypes,omitempty"`
 }
 
 type wsReadyMessage struct {
 	Type    string   `json:"type"`
 	Version int      `json:"version"`
 	ChatIDs []string `json:"chatIDs"`
+	// ConnectionID lets admin tools such as replay target this connection.
+	ConnectionID uint64 `json:"connectionID"`
 }
 
 type wsSubscriptionsUpdatedMessage struct {
-	Type      string   `json:"type"`
-	RequestID string   `json:"requestID,omitempty"`
-	ChatIDs   []string `json:"chatIDs"`
+	Type         string   `json:"type"`
+	RequestID    string   `json:"requestID,omitempty"`
+	ChatIDs      []string `json:"chatIDs"`
+	MessageTypes []string `json:"messageTypes,omitempty"`
 }
 
 type wsErrorMessage struct {
@@ -73,9 +81,10 @@ type wsDomainEventMessage struct {
 	Type    string         `json:"type"`
 	Seq     int            `json:"seq"`
 	TS      int64          `json:"ts"`
-	ChatID  string         `json:"chatID"`
+	ChatID  string         `json:"chatID,omitempty"`
 	IDs     []string       `json:"ids"`
 	Entries []compatRecord `json:"entries,omitempty"`
+	Replay  bool           `json:"replay,omitempty"`
 }
 
 type compatRecord map[string]any
@@ -84,12 +93,21 @@ type wsDomainEvent struct {
 	Type   string
 	ChatID string
 	IDs    []string
+	// Coalesce marks message upserts caused only by reactions, which the hub
+	// may batch before hydrating.
+	Coalesce bool
 }
 
+// domainEventListener receives every domain event the hub emits, regardless of
+// websocket subscriptions. Entries are populated for message upserts only.
+type domainEventListener func(domainEvent wsDomainEvent, entries []compatRecord)
+
 type wsClientState struct {
 	seq     int
 	chatIDs []string
-	writeMu sync.Mutex
+	// messageTypes narrows message.upserted entries; nil sends every type.
+	messageTypes messageTypeFilter
+	writeMu      sync.Mutex
 }
 
 type realtimeSender func(any) error
@@ -102,6 +120,13 @@ type wsClient struct {
 	send  realtimeSender
 	ping  realtimePinger
 	close realtimeCloser
+	// policy restricts delivery for callers confined by a subject or client
+	// policy; nil means the deployment owner.
+	policy *subjectPolicy
+	// owner is the rateLimitKey of the caller that opened the socket, so
+	// targeted writes like replay only reach the caller's own connections.
+	// Embedded connections have none.
+	owner string
 }
 
 type EmbeddedRealtimeConnection struct {
@@ -137,18 +162,39 @@ type wsHub struct {
 
 	eventQueue chan any
 
+	listenersMu sync.RWMutex
+	listeners   []domainEventListener
+
+	// dispatchMu keeps per-client seq numbers ordered when handlers publish
+	// events alongside the sync loop. It is held for numbering and writing
+	// only, never while hydrating.
+	dispatchMu sync.Mutex
+
 	fingerprintMu        sync.Mutex
 	recentFingerprints   map[string]time.Time
 	lastFingerprintPrune time.Time
+
+	// lastSyncAt is the unix millisecond time of the last processed sync.
+	lastSyncAt atomic.Int64
+
+	// reactionBatcher is nil when coalescing is disabled.
+	reactionBatcher *messageUpsertBatcher
+
+	bridgeStates *bridgeStateTracker
 }
 
 func newWSHub(server *Server) *wsHub {
-	return &wsHub{
+	h := &wsHub{
 		server:             server,
 		clients:            make(map[uint64]*wsClient),
 		eventQueue:         make(chan any, wsEventQueueSize),
 		recentFingerprints: make(map[string]time.Time),
+		bridgeStates:       &bridgeStateTracker{},
+	}
+	if window := server.cfg.ReactionCoalesceWindow; window > 0 {
+		h.reactionBatcher = newMessageUpsertBatcher(window, h.dispatch)
 	}
+	return h
 }
 
 func (h *wsHub) ensureSubscription() error {
@@ -173,6 +219,7 @@ func (h *wsHub) ensureSubscription() error {
 				currentBuffer.Unsubscribe(listenerID)
 			}
 		}
+		h.seedBridgeStates()
 		go h.run()
 	})
 	return h.subscribeErr
@@ -189,6 +236,7 @@ func (h *wsHub) run() {
 			if !ok || syncComplete == nil {
 				continue
 			}
+			h.lastSyncAt.Store(time.Now().UnixMilli())
 			h.processSyncComplete(syncComplete)
 		case <-keepaliveTicker.C:
 			h.pingClients()
@@ -238,9 +286,10 @@ func (h *wsHub) open(send realtimeSender, ping realtimePinger, close realtimeClo
 		return nil, errors.New("failed to register realtime client")
 	}
 	h.write(client, wsReadyMessage{
-		Type:    wsReadyType,
-		Version: 1,
-		ChatIDs: []string{},
+		Type:         wsReadyType,
+		Version:      1,
+		ChatIDs:      []string{},
+		ConnectionID: id,
 	})
 	return &EmbeddedRealtimeConnection{hub: h, id: id}, nil
 }
@@ -249,6 +298,12 @@ func (s *Server) OpenEmbeddedRealtime(send realtimeSender) (*EmbeddedRealtimeCon
 	return s.ws.open(send, nil, nil)
 }
 
+func (h *wsHub) clientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
 func (h *wsHub) client(id uint64) *wsClient {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -273,61 +328,191 @@ func (h *wsHub) setSubscriptions(id uint64, chatIDs []string) {
 	h.mu.Unlock()
 }
 
+func (h *wsHub) setMessageTypes(id uint64, filter messageTypeFilter) {
+	h.mu.Lock()
+	if client, ok := h.clients[id]; ok && client.state != nil {
+		client.state.messageTypes = filter
+	}
+	h.mu.Unlock()
+}
+
+func (h *wsHub) messageTypes(client *wsClient) messageTypeFilter {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return client.state.messageTypes
+}
+
+func (h *wsHub) setCaller(id uint64, owner string, policy *subjectPolicy) {
+	h.mu.Lock()
+	if client, ok := h.clients[id]; ok {
+		client.owner = owner
+		client.policy = policy
+	}
+	h.mu.Unlock()
+}
+
+func (h *wsHub) addListener(listener domainEventListener) {
+	if listener == nil {
+		return
+	}
+	h.listenersMu.Lock()
+	h.listeners = append(h.listeners, listener)
+	h.listenersMu.Unlock()
+}
+
+func (h *wsHub) listenerSnapshot() []domainEventListener {
+	h.listenersMu.RLock()
+	defer h.listenersMu.RUnlock()
+	return append([]domainEventListener(nil), h.listeners...)
+}
+
 func (h *wsHub) processSyncComplete(syncComplete *jsoncmd.SyncComplete) {
 	domainEvents := mapSyncCompleteToDomainEvents(syncComplete)
+	domainEvents = append(domainEvents, h.accountUpdateEvents(syncComplete)...)
+	h.server.recordChanges(domainEvents)
+	if syncChangesRoomBridges(syncComplete) {
+		h.server.roomBridges.invalidate()
+	}
+	h.server.readMarkers.invalidate()
+	h.server.contactCache.invalidateRooms(syncMembershipRoomIDs(syncComplete))
+	h.server.rt.HandleToDevice(context.Background(), syncComplete.ToDevice)
+	if cli := h.server.rt.Client(); cli != nil && cli.Account != nil {
+		h.server.messageCounts.add(countSyncMessages(syncComplete, cli.Account.UserID))
+	}
 	for _, domainEvent := range domainEvents {
-		targets := h.subscribedTargets(domainEvent.ChatID)
-		if len(targets) == 0 {
+		if domainEvent.Coalesce && h.reactionBatcher != nil {
+			h.reactionBatcher.add(domainEvent)
 			continue
 		}
+		h.publish(domainEvent)
+	}
+}
 
-		var entries []compatRecord
-		if domainEvent.Type == wsDomainTypeMessageUpserted {
-			hydrated, err := h.server.hydrateMessagesForWSEvent(domainEvent.ChatID, domainEvent.IDs)
-			if err != nil || len(hydrated) == 0 {
-				continue
-			}
-			entries = hydrated
+// publish dispatches an event right away, first dropping held reaction
+// upserts it supersedes so they cannot be delivered after it.
+func (h *wsHub) publish(domainEvent wsDomainEvent) {
+	if h.reactionBatcher != nil {
+		h.reactionBatcher.supersede(domainEvent)
+	}
+	h.dispatch(domainEvent)
+}
+
+// stop cancels held reaction upserts on shutdown.
+func (h *wsHub) stop() {
+	if h.reactionBatcher != nil {
+		h.reactionBatcher.stop()
+	}
+}
+
+// dispatch hydrates a domain event and fans it out to listeners and subscribed
+// clients. Handlers may call it directly to publish changes ahead of sync; the
+// echo arriving later is dropped by the duplicate debounce. Hydration runs
+// before dispatchMu is taken, so a slow lookup only delays its own event.
+func (h *wsHub) dispatch(domainEvent wsDomainEvent) {
+	var targets []*wsClient
+	switch domainEvent.Type {
+	case wsDomainTypeAccountUpdated:
+		targets = h.accountTargets(domainEvent.IDs)
+	case wsDomainTypeVerificationUpdated:
+		targets = h.ownerTargets()
+	default:
+		targets = h.subscribedTargets(domainEvent.ChatID)
+	}
+	listeners := h.listenerSnapshot()
+	if len(targets) == 0 && len(listeners) == 0 {
+		return
+	}
+
+	var entries []compatRecord
+	switch domainEvent.Type {
+	case wsDomainTypeMessageUpserted:
+		hydrated, err := h.server.hydrateMessagesForWSEvent(domainEvent.ChatID, domainEvent.IDs)
+		if err != nil || len(hydrated) == 0 {
+			return
+		}
+		entries = hydrated
+	case wsDomainTypeAccountUpdated:
+		hydrated, err := h.server.hydrateAccountsForWSEvent(domainEvent.IDs)
+		if err != nil || len(hydrated) == 0 {
+			return
 		}
+		entries = hydrated
+	case wsDomainTypeVerificationUpdated:
+		hydrated, err := h.server.hydrateVerificationsForWSEvent(domainEvent.IDs)
+		if err != nil || len(hydrated) == 0 {
+			return
+		}
+		entries = hydrated
+	}
 
-		now := time.Now().UTC()
-		if h.dropDuplicate(domainEvent, entries, now) {
+	h.dispatchMu.Lock()
+	defer h.dispatchMu.Unlock()
+
+	now := time.Now().UTC()
+	if h.dropDuplicate(domainEvent, entries, now) {
+		return
+	}
+
+	for _, listener := range listeners {
+		listener(domainEvent, entries)
+	}
+
+	for _, target := range targets {
+		if target == nil || target.state == nil {
 			continue
 		}
-
-		for _, target := range targets {
-			if target == nil || target.state == nil {
+		targetEntries, targetIDs := entries, domainEvent.IDs
+		if domainEvent.Type == wsDomainTypeMessageUpserted {
+			targetEntries, targetIDs = filterMessageEntries(entries, domainEvent.IDs, h.messageTypes(target))
+			if len(targetEntries) == 0 {
 				continue
 			}
-			target.state.seq++
-			payload := wsDomainEventMessage{
-				Type:   domainEvent.Type,
-				Seq:    target.state.seq,
-				TS:     now.UnixMilli(),
-				ChatID: domainEvent.ChatID,
-				IDs:    domainEvent.IDs,
-			}
-			if len(entries) > 0 {
-				payload.Entries = entries
-			}
-			h.write(target, payload)
 		}
+		target.state.seq++
+		payload := wsDomainEventMessage{
+			Type:   domainEvent.Type,
+			Seq:    target.state.seq,
+			TS:     now.UnixMilli(),
+			ChatID: domainEvent.ChatID,
+			IDs:    targetIDs,
+		}
+		if len(targetEntries) > 0 {
+			payload.Entries = targetEntries
+		}
+		h.write(target, payload)
 	}
 }
 
 func (h *wsHub) subscribedTargets(chatID string) []*wsClient {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	output := make([]*wsClient, 0, len(h.clients))
+	subscribed := make([]*wsClient, 0, len(h.clients))
 	for _, client := range h.clients {
 		if client == nil || client.state == nil {
 			continue
 		}
 		if isWSSubscribed(client.state.chatIDs, chatID) {
-			output = append(output, client)
+			subscribed = append(subscribed, client)
 		}
 	}
+	h.mu.RUnlock()
+
+	// Only confined clients need the chat's account, and resolving it builds
+	// the account lookup, so it happens at most once per event.
+	var accountID string
+	resolved := false
+	output := subscribed[:0]
+	for _, client := range subscribed {
+		if client.policy != nil {
+			if !resolved {
+				accountID = h.server.chatAccountID(context.Background(), chatID)
+				resolved = true
+			}
+			if !client.policy.allowsChat(chatID, accountID) {
+				continue
+			}
+		}
+		output = append(output, client)
+	}
 	return output
 }
 
@@ -411,16 +596,23 @@ func (s *Server) hydrateMessagesForWSEvent(chatID string, messageIDs []string) (
 
 	memberNames := s.loadMemberNameMap(context.Background(), roomID)
 	reactions, _ := s.loadReactionMap(context.Background(), roomID, events)
+	delivery, _ := s.loadMessageDeliveryState(context.Background(), roomID, events)
 
-	byID := make(map[string]compatRecord, len(events))
+	messages := make([]compat.Message, 0, len(events))
 	for _, evt := range events {
 		message, mapErr := s.mapEventToMessage(context.Background(), evt, room, lookup, reactionBundle{
 			Names:     memberNames,
 			Reactions: reactions,
+			Delivery:  delivery,
 		})
 		if errors.Is(mapErr, errSkipEvent) || mapErr != nil {
 			continue
 		}
+		messages = append(messages, message)
+	}
+
+	byID := make(map[string]compatRecord, len(messages))
+	for _, message := range messages {
 		serialized, marshalErr := toCompatRecord(message)
 		if marshalErr != nil {
 			continue
@@ -437,6 +629,30 @@ func (s *Server) hydrateMessagesForWSEvent(chatID string, messageIDs []string) (
 	return output, nil
 }
 
+func (s *Server) hydrateChatForEvent(ctx context.Context, chatID string) (compatRecord, error) {
+	cli := s.rt.Client()
+	if cli == nil {
+		return nil, nil
+	}
+	room, err := cli.DB.Room.Get(ctx, id.RoomID(chatID))
+	if err != nil || room == nil {
+		return nil, err
+	}
+	lookup, err := s.buildAccountLookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	roomStates, err := s.loadRoomAccountDataStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chat, err := s.mapRoomToChat(ctx, room, lookup, chatPreviewParticipants, true, roomStates[room.ID])
+	if err != nil {
+		return nil, err
+	}
+	return toCompatRecord(chat)
+}
+
 func toCompatRecord(value any) (compatRecord, error) {
 	raw, err := json.Marshal(value)
 	if err != nil {
@@ -472,6 +688,7 @@ func (s *Server) wsEvents(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	defer realtime.Close()
+	s.ws.setCaller(realtime.id, rateLimitKey(r), s.requestPolicy(r))
 
 	for {
 		messageType, rawPayload, readErr := conn.Read(r.Context())
@@ -542,7 +759,7 @@ func (h *wsHub) processRawPayload(clientID uint64, rawPayload []byte) error {
 	}
 
 	for key := range payloadObject {
-		if key != "type" && key != "requestID" && key != "chatIDs" {
+		if key != "type" && key != "requestID" && key != "chatIDs" && key != "messageTypes" {
 			h.write(client, wsErrorMessage{
 				Type:      wsErrorType,
 				RequestID: requestID,
@@ -585,11 +802,30 @@ func (h *wsHub) processRawPayload(clientID uint64, rawPayload []byte) error {
 		return nil
 	}
 
+	var messageTypes messageTypeFilter
+	if rawMessageTypes, ok := payloadObject["messageTypes"]; ok {
+		names, namesOK := decodeWSChatIDs(rawMessageTypes)
+		if namesOK {
+			messageTypes, namesOK = parseMessageTypeFilter(names)
+		}
+		if !namesOK {
+			h.write(client, wsErrorMessage{
+				Type:      wsErrorType,
+				RequestID: requestID,
+				Code:      wsErrorCodeInvalidPayload,
+				Message:   "messageTypes must be an array of message types",
+			})
+			return nil
+		}
+	}
+
 	h.setSubscriptions(clientID, normalized)
+	h.setMessageTypes(clientID, messageTypes)
 	h.write(client, wsSubscriptionsUpdatedMessage{
-		Type:      wsSubscriptionsUpdatedType,
-		RequestID: requestID,
-		ChatIDs:   normalized,
+		Type:         wsSubscriptionsUpdatedType,
+		RequestID:    requestID,
+		ChatIDs:      normalized,
+		MessageTypes: messageTypes.names(),
 	})
 	return nil
 }
@@ -686,6 +922,7 @@ func mapSyncCompleteToDomainEvents(syncComplete *jsoncmd.SyncComplete) []wsDomai
 		}
 
 		messageUpsertIDs := make(map[string]struct{})
+		reactionTargetIDs := make(map[string]struct{})
 		messageDeletedIDs := make(map[string]struct{})
 
 		for _, evt := range roomSync.Events {
@@ -713,7 +950,12 @@ func mapSyncCompleteToDomainEvents(syncComplete *jsoncmd.SyncComplete) []wsDomai
 					targetID = string(evt.RelatesTo)
 				}
 				targetID = strings.TrimSpace(targetID)
-				if targetID != "" {
+				if targetID == "" {
+					break
+				}
+				if evtType == event.EventReaction.Type {
+					reactionTargetIDs[targetID] = struct{}{}
+				} else {
 					messageUpsertIDs[targetID] = struct{}{}
 				}
 			case evtType == event.StateMember.Type ||
@@ -739,6 +981,19 @@ func mapSyncCompleteToDomainEvents(syncComplete *jsoncmd.SyncComplete) []wsDomai
 				IDs:    mapKeysSorted(messageUpsertIDs),
 			})
 		}
+		// A target that also changed for another reason is already hydrated
+		// with its current reactions by the immediate upsert.
+		for targetID := range messageUpsertIDs {
+			delete(reactionTargetIDs, targetID)
+		}
+		if len(reactionTargetIDs) > 0 {
+			output = append(output, wsDomainEvent{
+				Type:     wsDomainTypeMessageUpserted,
+				ChatID:   chatID,
+				IDs:      mapKeysSorted(reactionTargetIDs),
+				Coalesce: true,
+			})
+		}
 		if len(messageDeletedIDs) > 0 {
 			output = append(output, wsDomainEvent{
 				Type:   wsDomainTypeMessageDeleted,
@@ -128,3 +128,41 @@ func decodeWSErrorMessage(t *testing.T, payload any) wsErrorMessage {
 	}
 	return decoded
 }
+
+func TestWSDispatchDeliversChatDeletedOnceWithinDebounce(t *testing.T) {
+	hub, messages := newTestWSHub()
+	hub.setSubscriptions(1, []string{"*"})
+
+	domainEvent := wsDomainEvent{Type: wsDomainTypeChatDeleted, ChatID: "!room:example.org", IDs: []string{"!room:example.org"}}
+	hub.dispatch(domainEvent)
+	hub.dispatch(domainEvent)
+
+	if len(*messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(*messages))
+	}
+	payload, ok := (*messages)[0].(wsDomainEventMessage)
+	if !ok || payload.Type != wsDomainTypeChatDeleted || payload.Seq != 1 {
+		t.Fatalf("unexpected payload %#v", (*messages)[0])
+	}
+}
+
+func TestWSProcessRawPayloadStoresMessageTypes(t *testing.T) {
+	hub, messages := newTestWSHub()
+
+	err := hub.processRawPayload(1, []byte(`{"type":"subscriptions.set","chatIDs":["*"],"messageTypes":["text"]}`))
+	if err != nil {
+		t.Fatalf("processRawPayload returned error: %v", err)
+	}
+	updated, ok := (*messages)[0].(wsSubscriptionsUpdatedMessage)
+	if !ok || len(updated.MessageTypes) != 1 || updated.MessageTypes[0] != "TEXT" {
+		t.Fatalf("unexpected payload %#v", (*messages)[0])
+	}
+
+	err = hub.processRawPayload(1, []byte(`{"type":"subscriptions.set","chatIDs":["*"],"messageTypes":["SYSTEM"]}`))
+	if err != nil {
+		t.Fatalf("processRawPayload returned error: %v", err)
+	}
+	if decoded := decodeWSErrorMessage(t, (*messages)[1]); decoded.Code != wsErrorCodeInvalidPayload {
+		t.Fatalf("expected invalid payload error, got %#v", decoded)
+	}
+}